@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"synapse-operator/state"
+)
+
+// keyPrefix namespaces audit records in the state store. Keys embed a zero-padded timestamp so that their
+// lexical order is chronological.
+const keyPrefix = "audit/"
+
+// Log persists decision records in a state.Store, keeping only the most recent Retention records.
+type Log struct {
+	Store     state.Store
+	Retention int
+}
+
+func recordKey(rec *Record) string {
+	return fmt.Sprintf("%s%020d-%s", keyPrefix, rec.Time.UnixNano(), rec.ID)
+}
+
+// Write stores rec and prunes the oldest records beyond the retention.
+func (l *Log) Write(ctx context.Context, rec *Record) error {
+	rec.mu.Lock()
+	raw, err := json.Marshal(rec)
+	rec.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := l.Store.Put(ctx, recordKey(rec), raw); err != nil {
+		return err
+	}
+	if l.Retention <= 0 {
+		return nil
+	}
+	entries, err := l.Store.List(ctx, keyPrefix)
+	if err != nil {
+		return err
+	}
+	keys := state.Keys(entries)
+	for len(keys) > l.Retention {
+		if err := l.Store.Delete(ctx, keys[0]); err != nil {
+			return err
+		}
+		keys = keys[1:]
+	}
+	return nil
+}
+
+// Find returns the record with transaction ID id.
+func (l *Log) Find(ctx context.Context, id string) (*Record, error) {
+	entries, err := l.Store.List(ctx, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range state.Keys(entries) {
+		if strings.HasSuffix(key, "-"+id) {
+			return decode(entries[key])
+		}
+	}
+	return nil, fmt.Errorf("no audit record with transaction ID %q", id)
+}
+
+// FindAt returns the latest record in namespace taken at or before at.
+func (l *Log) FindAt(ctx context.Context, namespace string, at time.Time) (*Record, error) {
+	entries, err := l.Store.List(ctx, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := state.Keys(entries)
+	for i := len(keys) - 1; i >= 0; i-- {
+		rec, err := decode(entries[keys[i]])
+		if err != nil {
+			return nil, err
+		}
+		if rec.Namespace == namespace && !rec.Time.After(at) {
+			return rec, nil
+		}
+	}
+	return nil, fmt.Errorf("no audit record in namespace %q at or before %s", namespace, at.Format(time.RFC3339))
+}
+
+func decode(raw []byte) (*Record, error) {
+	rec := &Record{}
+	if err := json.Unmarshal(raw, rec); err != nil {
+		return nil, fmt.Errorf("decoding audit record: %w", err)
+	}
+	return rec, nil
+}
@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"synapse-operator/state"
+)
+
+func TestLogRetentionAndLookup(t *testing.T) {
+	ctx := context.Background()
+	log := &Log{Store: state.NewMemoryStore(), Retention: 2}
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	var ids []string
+	for i, namespace := range []string{"matrix", "matrix", "bridges"} {
+		rec := NewRecord(namespace, "configmap/homeserver", start.Add(time.Duration(i)*time.Minute))
+		rec.Finish(ResultRolledOut, nil)
+		require.NoError(t, log.Write(ctx, rec))
+		ids = append(ids, rec.ID)
+	}
+
+	_, err := log.Find(ctx, ids[0])
+	assert.Error(t, err, "oldest record should have been pruned")
+	rec, err := log.Find(ctx, ids[2])
+	require.NoError(t, err)
+	assert.Equal(t, "bridges", rec.Namespace)
+
+	rec, err = log.FindAt(ctx, "matrix", start.Add(5*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, ids[1], rec.ID)
+	_, err = log.FindAt(ctx, "matrix", start.Add(30*time.Second))
+	assert.Error(t, err)
+}
+
+func TestRecordResultAndExplain(t *testing.T) {
+	rec := NewRecord("matrix", "configmap/homeserver", time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC))
+	rec.Source("configmap/homeserver", func(s *Source) { s.Class, s.Policy, s.Digest = "app-config", "restart", "0123456789abcdef" })
+	rec.Source("configmap/ca", func(s *Source) { s.Class, s.Policy, s.Excluded = "ca-bundle", "debounce", "held" })
+	rec.SetHash("feedface")
+	rec.SetDiff("-log_level: INFO\n+log_level: DEBUG\n")
+	rec.AddGate(Gate{Name: "restart-strategy", Workload: "deployment/bridge", Detail: "unknown restart strategy"})
+	rec.AddAction(Action{Workload: "deployment/synapse", Strategy: "annotation", FromHash: "old", ToHash: "feedface", Updated: true})
+	rec.Finish("", nil)
+	assert.Equal(t, ResultRolledOut, rec.Result)
+	assert.True(t, rec.Notable())
+
+	var out bytes.Buffer
+	require.NoError(t, Explain(&out, rec))
+	for _, want := range []string{
+		"Transaction " + rec.ID,
+		"configmap/ca  class=ca-bundle policy=debounce  not applied: held",
+		"digest=0123456789ab",
+		"Config diff:\n  -log_level: INFO\n  +log_level: DEBUG\n",
+		"restart-strategy [deployment/bridge] FAILED: unknown restart strategy",
+		"deployment/synapse strategy=annotation old -> feedface  updated",
+		"Result: rolled-out",
+	} {
+		assert.Contains(t, out.String(), want)
+	}
+
+	failed := NewRecord("matrix", "", time.Now())
+	failed.Finish("", errors.New("boom"))
+	assert.Equal(t, ResultFailed, failed.Result)
+
+	quiet := NewRecord("matrix", "", time.Now())
+	quiet.Finish("", nil)
+	assert.False(t, quiet.Notable())
+
+	var nilRecord *Record
+	nilRecord.AddAction(Action{})
+	assert.False(t, nilRecord.Notable())
+}
@@ -0,0 +1,317 @@
+// Package audit records every rollout decision the operator takes — the inputs it saw, the policies it
+// evaluated, the gates it checked, and the patches it attempted — so a decision can be replayed later with
+// `synapse-operator explain`.
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Results of a decision.
+const (
+	ResultRolledOut = "rolled-out"
+	ResultUpToDate  = "up-to-date"
+	ResultHeld      = "held"
+	ResultNoSources = "no-sources"
+	ResultFailed    = "failed"
+)
+
+// Record is one rollout decision. Its methods are safe to call on a nil Record, which records nothing.
+type Record struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	// Trigger is the object whose event started the decision.
+	Trigger string `json:"trigger,omitempty"`
+	// TriggerID is the ID stamped on the workloads, Events and notifications the decision produced.
+	TriggerID    string   `json:"triggerId,omitempty"`
+	CombinedHash string   `json:"combinedHash,omitempty"`
+	Sources      []Source `json:"sources,omitempty"`
+	// Diff is the redacted, size-capped unified diff of the triggering ConfigMap, with --config-diff.
+	Diff    string   `json:"diff,omitempty"`
+	Gates   []Gate   `json:"gates,omitempty"`
+	Actions []Action `json:"actions,omitempty"`
+	Result  string   `json:"result,omitempty"`
+	Error   string   `json:"error,omitempty"`
+
+	mu sync.Mutex
+}
+
+// Source is one config source seen while hashing, with the policy it was evaluated under.
+type Source struct {
+	Key    string `json:"key"`
+	Class  string `json:"class,omitempty"`
+	Policy string `json:"policy,omitempty"`
+	Digest string `json:"digest,omitempty"`
+	// Excluded explains why the source did not contribute its current digest, if it did not.
+	Excluded string `json:"excluded,omitempty"`
+}
+
+// Gate is one check a rollout had to pass, for the whole namespace or for a single workload.
+type Gate struct {
+	Name     string `json:"name"`
+	Workload string `json:"workload,omitempty"`
+	Passed   bool   `json:"passed"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// Action is one restart attempted on a workload.
+type Action struct {
+	Workload string `json:"workload"`
+	Strategy string `json:"strategy,omitempty"`
+	FromHash string `json:"fromHash,omitempty"`
+	ToHash   string `json:"toHash,omitempty"`
+	Updated  bool   `json:"updated"`
+	Error    string `json:"error,omitempty"`
+}
+
+// NewRecord starts a decision record with a fresh transaction ID.
+func NewRecord(namespace, trigger string, now time.Time) *Record {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return &Record{ID: hex.EncodeToString(id), Time: now.UTC(), Namespace: namespace, Trigger: trigger}
+}
+
+// Source applies update to the entry for key, adding the entry first if needed.
+func (r *Record) Source(key string, update func(*Source)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.Sources {
+		if r.Sources[i].Key == key {
+			update(&r.Sources[i])
+			return
+		}
+	}
+	r.Sources = append(r.Sources, Source{Key: key})
+	update(&r.Sources[len(r.Sources)-1])
+}
+
+// AddGate records the outcome of a gate.
+func (r *Record) AddGate(gate Gate) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Gates = append(r.Gates, gate)
+}
+
+// AddAction records a restart attempt.
+func (r *Record) AddAction(action Action) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Actions = append(r.Actions, action)
+}
+
+// SetTrigger records the object whose event started the decision.
+func (r *Record) SetTrigger(trigger string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Trigger = trigger
+}
+
+// SetHash records the combined hash the decision was made for.
+func (r *Record) SetHash(hash string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.CombinedHash = hash
+}
+
+// SetDiff records the diff of the triggering ConfigMap.
+func (r *Record) SetDiff(diff string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Diff = diff
+}
+
+// Finish sets the result of the decision. Unless err or result is given, it is derived from the recorded
+// actions and held-back sources.
+func (r *Record) Finish(result string, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.Result = ResultFailed
+		r.Error = err.Error()
+		return
+	}
+	if result != "" {
+		r.Result = result
+		return
+	}
+	r.Result = ResultUpToDate
+	for _, source := range r.Sources {
+		if source.Excluded != "" {
+			r.Result = ResultHeld
+		}
+	}
+	for _, action := range r.Actions {
+		if action.Updated {
+			r.Result = ResultRolledOut
+		}
+	}
+}
+
+// Notable reports whether the decision is worth keeping: something was attempted, held back, or refused.
+// Reconciles that found every workload up to date are not recorded.
+func (r *Record) Notable() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Result != ResultUpToDate && r.Result != ResultNoSources {
+		return true
+	}
+	for _, gate := range r.Gates {
+		if !gate.Passed {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// WithRecord returns a context carrying rec, so the stages of a reconcile can add to the same record.
+func WithRecord(ctx context.Context, rec *Record) context.Context {
+	return context.WithValue(ctx, contextKey{}, rec)
+}
+
+// FromContext returns the record carried by ctx, or nil.
+func FromContext(ctx context.Context) *Record {
+	rec, _ := ctx.Value(contextKey{}).(*Record)
+	return rec
+}
+
+// Explain writes a human-readable replay of rec.
+func Explain(w io.Writer, rec *Record) error {
+	p := &printer{w: w}
+	p.printf("Transaction %s at %s in namespace %s\n", rec.ID, rec.Time.Format(time.RFC3339), rec.Namespace)
+	if rec.Trigger != "" {
+		p.printf("Triggered by %s\n", rec.Trigger)
+	}
+	if rec.TriggerID != "" {
+		p.printf("Trigger ID %s\n", rec.TriggerID)
+	}
+
+	p.printf("\nInputs:\n")
+	sources := append([]Source(nil), rec.Sources...)
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Key < sources[j].Key })
+	if len(sources) == 0 {
+		p.printf("  (no config sources)\n")
+	}
+	for _, source := range sources {
+		p.printf("  %s  class=%s policy=%s", source.Key, source.Class, source.Policy)
+		if source.Digest != "" {
+			p.printf(" digest=%s", abbreviate(source.Digest))
+		}
+		if source.Excluded != "" {
+			p.printf("  not applied: %s", source.Excluded)
+		}
+		p.printf("\n")
+	}
+	if rec.CombinedHash != "" {
+		p.printf("Combined hash: %s\n", rec.CombinedHash)
+	}
+	if rec.Diff != "" {
+		p.printf("\nConfig diff:\n")
+		for _, line := range strings.SplitAfter(strings.TrimSuffix(rec.Diff, "\n"), "\n") {
+			p.printf("  %s", line)
+		}
+		p.printf("\n")
+	}
+
+	p.printf("\nGates:\n")
+	if len(rec.Gates) == 0 {
+		p.printf("  (none evaluated)\n")
+	}
+	for _, gate := range rec.Gates {
+		status := "passed"
+		if !gate.Passed {
+			status = "FAILED"
+		}
+		scope := "namespace"
+		if gate.Workload != "" {
+			scope = gate.Workload
+		}
+		p.printf("  %s [%s] %s", gate.Name, scope, status)
+		if gate.Detail != "" {
+			p.printf(": %s", gate.Detail)
+		}
+		p.printf("\n")
+	}
+
+	p.printf("\nActions:\n")
+	if len(rec.Actions) == 0 {
+		p.printf("  (none attempted)\n")
+	}
+	for _, action := range rec.Actions {
+		p.printf("  %s strategy=%s %s -> %s", action.Workload, action.Strategy, abbreviate(action.FromHash), abbreviate(action.ToHash))
+		switch {
+		case action.Error != "":
+			p.printf("  error: %s", action.Error)
+		case action.Updated:
+			p.printf("  updated")
+		default:
+			p.printf("  unchanged")
+		}
+		p.printf("\n")
+	}
+
+	p.printf("\nResult: %s\n", rec.Result)
+	if rec.Error != "" {
+		p.printf("Error: %s\n", rec.Error)
+	}
+	return p.err
+}
+
+// printer remembers the first write error so Explain can report it once.
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
+
+func abbreviate(hash string) string {
+	if hash == "" {
+		return "(none)"
+	}
+	// Drop the encoding prefix of versioned hashes, such as "v2:sha256:".
+	hash = hash[strings.LastIndexByte(hash, ':')+1:]
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,145 @@
+// Package bootstrap converges the namespace-scoped artifacts the operator generates for enabled features
+// (state objects, canary workloads, PDBs, ServiceMonitors, ...). Artifacts are applied idempotently with
+// server-side apply, labelled with their owning feature, and pruned once the feature no longer declares them.
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// ManagedByLabel and ManagedByValue mark every object the operator generates.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "synapse-operator"
+	// ArtifactOwnerLabel names the feature that declared an artifact; pruning is scoped to it.
+	ArtifactOwnerLabel = "synapse.gen0sec.com/artifact-owner"
+	// FieldOwner is the server-side apply field manager used for artifacts.
+	FieldOwner = "synapse-operator"
+)
+
+// Bootstrapper applies and prunes the artifacts of one feature.
+type Bootstrapper struct {
+	Client client.Client
+	// Owner identifies the feature; only artifacts labelled with the same owner are ever pruned.
+	Owner string
+	// Kinds lists the kinds eligible for pruning. Kinds whose API is not installed are skipped.
+	Kinds []schema.GroupVersionKind
+}
+
+// Result lists the artifacts touched by a Sync as "Kind/name".
+type Result struct {
+	Applied []string
+	Pruned  []string
+}
+
+// Sync makes the owner's artifacts in namespace match desired: missing or drifted artifacts are applied and
+// artifacts no longer declared are deleted. Existing objects not created by the operator are never adopted.
+func (b *Bootstrapper) Sync(ctx context.Context, namespace string, desired []*unstructured.Unstructured) (Result, error) {
+	result := Result{}
+	keep := make(map[string]struct{}, len(desired))
+
+	for _, obj := range desired {
+		obj = obj.DeepCopy()
+		obj.SetNamespace(namespace)
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[ManagedByLabel] = ManagedByValue
+		labels[ArtifactOwnerLabel] = b.Owner
+		obj.SetLabels(labels)
+
+		id := artifactID(obj.GetKind(), obj.GetName())
+		keep[id] = struct{}{}
+
+		if err := b.checkAdoptable(ctx, obj); err != nil {
+			return result, err
+		}
+		if err := b.Client.Apply(ctx, client.ApplyConfigurationFromUnstructured(obj), client.FieldOwner(FieldOwner), client.ForceOwnership); err != nil {
+			return result, fmt.Errorf("applying %s: %w", id, err)
+		}
+		result.Applied = append(result.Applied, id)
+	}
+
+	for _, gvk := range b.Kinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := b.Client.List(ctx, list, client.InNamespace(namespace), client.MatchingLabels{
+			ManagedByLabel:     ManagedByValue,
+			ArtifactOwnerLabel: b.Owner,
+		}); err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return result, err
+		}
+		for i := range list.Items {
+			item := &list.Items[i]
+			id := artifactID(gvk.Kind, item.GetName())
+			if _, ok := keep[id]; ok {
+				continue
+			}
+			if err := b.Client.Delete(ctx, item); err != nil && !apierrors.IsNotFound(err) {
+				return result, fmt.Errorf("pruning %s: %w", id, err)
+			}
+			result.Pruned = append(result.Pruned, id)
+		}
+	}
+
+	sort.Strings(result.Applied)
+	sort.Strings(result.Pruned)
+	return result, nil
+}
+
+// checkAdoptable refuses to take over an existing object that was not generated by the operator.
+func (b *Bootstrapper) checkAdoptable(ctx context.Context, obj *unstructured.Unstructured) error {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GroupVersionKind())
+	if err := b.Client.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if existing.GetLabels()[ManagedByLabel] != ManagedByValue {
+		return fmt.Errorf("refusing to adopt %s: it is not managed by %s", artifactID(obj.GetKind(), obj.GetName()), ManagedByValue)
+	}
+	return nil
+}
+
+// Task runs a Sync once when the manager starts. It requires leader election so only one replica writes.
+type Task struct {
+	Bootstrapper
+	Namespace string
+	Desired   []*unstructured.Unstructured
+}
+
+// Start implements manager.Runnable.
+func (t *Task) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("bootstrap").WithValues("owner", t.Owner, "namespace", t.Namespace)
+	result, err := t.Sync(ctx, t.Namespace, t.Desired)
+	if err != nil {
+		logger.Error(err, "failed to bootstrap artifacts")
+		return err
+	}
+	logger.Info("Bootstrapped artifacts", "applied", result.Applied, "pruned", result.Pruned)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (t *Task) NeedLeaderElection() bool {
+	return true
+}
+
+func artifactID(kind, name string) string {
+	return kind + "/" + name
+}
@@ -0,0 +1,57 @@
+package bootstrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var configMapGVK = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+
+func configMapArtifact(name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(configMapGVK)
+	obj.SetName(name)
+	return obj
+}
+
+func TestSyncAppliesAndPrunes(t *testing.T) {
+	ctx := context.Background()
+	foreign := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "user-config", Namespace: "ops"}}
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(foreign).Build()
+	b := &Bootstrapper{Client: c, Owner: "state", Kinds: []schema.GroupVersionKind{configMapGVK}}
+
+	result, err := b.Sync(ctx, "ops", []*unstructured.Unstructured{configMapArtifact("a"), configMapArtifact("b")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ConfigMap/a", "ConfigMap/b"}, result.Applied)
+	assert.Empty(t, result.Pruned)
+
+	var cm corev1.ConfigMap
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "ops", Name: "a"}, &cm))
+	assert.Equal(t, "state", cm.Labels[ArtifactOwnerLabel])
+	assert.Equal(t, ManagedByValue, cm.Labels[ManagedByLabel])
+
+	// Re-running with the same declaration is a no-op apart from re-applying.
+	result, err = b.Sync(ctx, "ops", []*unstructured.Unstructured{configMapArtifact("a"), configMapArtifact("b")})
+	require.NoError(t, err)
+	assert.Empty(t, result.Pruned)
+
+	result, err = b.Sync(ctx, "ops", []*unstructured.Unstructured{configMapArtifact("a")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ConfigMap/b"}, result.Pruned)
+	assert.Error(t, c.Get(ctx, client.ObjectKey{Namespace: "ops", Name: "b"}, &cm))
+
+	// Objects that were not generated by the operator are neither adopted nor pruned.
+	_, err = b.Sync(ctx, "ops", []*unstructured.Unstructured{configMapArtifact("user-config")})
+	assert.Error(t, err)
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "ops", Name: "user-config"}, &cm))
+}
@@ -0,0 +1,199 @@
+// Package conformance bounds what the operator can write at runtime. Client wraps a controller-runtime
+// client and refuses every write that is not on an allow-list derived from the enabled features, as defense
+// in depth on top of RBAC against bugs in new subsystems.
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var refusedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "synapse_operator_conformance_refused_total",
+		Help: "Writes refused because they are outside the conformance allow-list.",
+	},
+	[]string{"verb", "resource"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(refusedTotal)
+}
+
+// Rule allows one verb on one resource, optionally restricted to a namespace.
+type Rule struct {
+	// Group is the API group, "" for the core group.
+	Group string
+	// Resource is the plural resource name, with "/<subresource>" for subresources (e.g. "pods/eviction").
+	Resource string
+	// Verb is one of create, update, patch, delete, or deletecollection.
+	Verb string
+	// Namespace restricts the rule to one namespace; empty allows every namespace.
+	Namespace string
+}
+
+func (r Rule) String() string {
+	resource := r.Resource
+	if r.Group != "" {
+		resource += "." + r.Group
+	}
+	if r.Namespace != "" {
+		return fmt.Sprintf("%s %s in %s", r.Verb, resource, r.Namespace)
+	}
+	return r.Verb + " " + resource
+}
+
+// Client enforces the allow-list on every write made through the wrapped client. Reads pass through.
+type Client struct {
+	client.Client
+	rules []Rule
+}
+
+// NewClient wraps c so that only writes matching one of rules are sent to the API server.
+func NewClient(c client.Client, rules []Rule) *Client {
+	return &Client{Client: c, rules: rules}
+}
+
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.check(obj, "create", ""); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.check(obj, "update", ""); err != nil {
+		return err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := c.check(obj, "patch", ""); err != nil {
+		return err
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *Client) Apply(ctx context.Context, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+	u, err := applyConfigurationObject(obj)
+	if err != nil {
+		return err
+	}
+	if err := c.check(u, "patch", ""); err != nil {
+		return err
+	}
+	return c.Client.Apply(ctx, obj, opts...)
+}
+
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.check(obj, "delete", ""); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *Client) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	if err := c.check(obj, "deletecollection", ""); err != nil {
+		return err
+	}
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *Client) Status() client.SubResourceWriter {
+	return c.SubResource("status")
+}
+
+func (c *Client) SubResource(subResource string) client.SubResourceClient {
+	return &subResourceClient{SubResourceClient: c.Client.SubResource(subResource), parent: c, subResource: subResource}
+}
+
+type subResourceClient struct {
+	client.SubResourceClient
+	parent      *Client
+	subResource string
+}
+
+func (s *subResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	if err := s.parent.check(obj, "create", s.subResource); err != nil {
+		return err
+	}
+	return s.SubResourceClient.Create(ctx, obj, subResource, opts...)
+}
+
+func (s *subResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	if err := s.parent.check(obj, "update", s.subResource); err != nil {
+		return err
+	}
+	return s.SubResourceClient.Update(ctx, obj, opts...)
+}
+
+func (s *subResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	if err := s.parent.check(obj, "patch", s.subResource); err != nil {
+		return err
+	}
+	return s.SubResourceClient.Patch(ctx, obj, patch, opts...)
+}
+
+// check returns a Forbidden error, logs, and counts when the write is not allowed.
+func (c *Client) check(obj client.Object, verb, subResource string) error {
+	gvk, err := c.GroupVersionKindFor(obj)
+	if err != nil {
+		return err
+	}
+	mapping, err := c.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return err
+	}
+	resource := mapping.Resource.Resource
+	if subResource != "" {
+		resource += "/" + subResource
+	}
+	if c.allowed(mapping.Resource.Group, resource, verb, obj.GetNamespace()) {
+		return nil
+	}
+
+	refusedTotal.WithLabelValues(verb, resource).Inc()
+	ctrl.Log.WithName("conformance").Info("Refused write outside the conformance allow-list",
+		"verb", verb, "group", mapping.Resource.Group, "resource", resource,
+		"namespace", obj.GetNamespace(), "name", obj.GetName())
+	return apierrors.NewForbidden(
+		schema.GroupResource{Group: mapping.Resource.Group, Resource: resource},
+		obj.GetName(),
+		fmt.Errorf("%s is outside the conformance allow-list", verb),
+	)
+}
+
+func (c *Client) allowed(group, resource, verb, namespace string) bool {
+	for _, rule := range c.rules {
+		if rule.Group == group && rule.Resource == resource && rule.Verb == verb &&
+			(rule.Namespace == "" || rule.Namespace == namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConfigurationObject recovers type and identity from an apply configuration, which only exposes them
+// through its serialized form.
+func applyConfigurationObject(obj runtime.ApplyConfiguration) (*unstructured.Unstructured, error) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{}
+	if err := json.Unmarshal(raw, &u.Object); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,59 @@
+package conformance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestClientEnforcesAllowList(t *testing.T) {
+	ctx := context.Background()
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "synapse", Namespace: "matrix"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "synapse-0", Namespace: "matrix"}}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+	base := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRESTMapper(mapper).WithObjects(deploy, pod).Build()
+	c := NewClient(base, []Rule{
+		{Group: "apps", Resource: "deployments", Verb: "patch"},
+		{Resource: "configmaps", Verb: "create", Namespace: "synapse-system"},
+	})
+
+	original := deploy.DeepCopy()
+	deploy.Annotations = map[string]string{"a": "b"}
+	require.NoError(t, c.Patch(ctx, deploy, client.MergeFrom(original)))
+
+	allowed := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "synapse-system"}}
+	require.NoError(t, c.Create(ctx, allowed))
+
+	before := testutil.ToFloat64(refusedTotal.WithLabelValues("create", "configmaps"))
+	refused := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "matrix"}}
+	err := c.Create(ctx, refused)
+	assert.True(t, apierrors.IsForbidden(err))
+	assert.Equal(t, before+1, testutil.ToFloat64(refusedTotal.WithLabelValues("create", "configmaps")))
+
+	err = c.Delete(ctx, deploy)
+	assert.True(t, apierrors.IsForbidden(err))
+
+	err = c.SubResource("eviction").Create(ctx, pod, &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}})
+	assert.True(t, apierrors.IsForbidden(err))
+	assert.Equal(t, float64(1), testutil.ToFloat64(refusedTotal.WithLabelValues("create", "pods/eviction")))
+}
+
+func TestRuleString(t *testing.T) {
+	assert.Equal(t, "patch deployments.apps", Rule{Group: "apps", Resource: "deployments", Verb: "patch"}.String())
+	assert.Equal(t, "create configmaps in ops", Rule{Resource: "configmaps", Verb: "create", Namespace: "ops"}.String())
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/audit"
+)
+
+// ApprovalRequiredAnnotation on a Namespace makes config changes in it wait for ApprovedConfigHashAnnotation
+// when "true", and exempts it from RequireApproval when "false".
+const ApprovalRequiredAnnotation = "synapse.gen0sec.com/approval-required"
+
+// ApprovedConfigHashAnnotation on a Namespace approves the rollout of the config hash it is set to.
+const ApprovedConfigHashAnnotation = "synapse.gen0sec.com/approved-config-hash"
+
+// approvalRequired reports whether rollouts in ns wait for approval.
+func (r *ConfigMapReconciler) approvalRequired(ns *corev1.Namespace) bool {
+	switch ns.Annotations[ApprovalRequiredAnnotation] {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return r.RequireApproval
+}
+
+// approvalGate holds a new hash in a namespace that requires approval until ApprovedConfigHashAnnotation
+// approves it. A hash every workload already runs needs no approval.
+func (r *ConfigMapReconciler) approvalGate(ctx context.Context, pass *rolloutPass) error {
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pass.Namespace}, ns); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !r.approvalRequired(ns) {
+		r.clearApprovalPending(ns, false)
+		return nil
+	}
+	if ns.Annotations[ApprovedConfigHashAnnotation] == pass.Hash {
+		r.clearApprovalPending(ns, true)
+		return nil
+	}
+	behind, err := r.workloadsBehind(ctx, pass.Namespace, pass.Hash)
+	if err != nil {
+		return err
+	}
+	if len(behind) == 0 {
+		r.clearApprovalPending(ns, false)
+		return nil
+	}
+	pass.Logger.Info("Holding rollout until the config hash is approved", "configHash", pass.Hash, "workloads", len(behind))
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "approval-required", Detail: fmt.Sprintf("%s is not %s", ApprovedConfigHashAnnotation, pass.Hash)})
+	r.reportApprovalPending(ns, pass.Hash, len(behind))
+	pass.Halt("awaiting approval")
+	return nil
+}
+
+// workloadsBehind returns the targeted workloads of namespace that do not run hash yet.
+func (r *ConfigMapReconciler) workloadsBehind(ctx context.Context, namespace, hash string) ([]*workload, error) {
+	workloads, err := r.listWorkloads(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var behind []*workload
+	for _, w := range workloads {
+		_, strategy, err := r.strategyFor(w)
+		if err != nil {
+			continue
+		}
+		if strategy.appliedHash(r, w) != hash {
+			behind = append(behind, w)
+		}
+	}
+	return behind, nil
+}
+
+// reportApprovalPending exposes hash as awaiting approval in ns, recording an event when it changes.
+func (r *ConfigMapReconciler) reportApprovalPending(ns *corev1.Namespace, hash string, behind int) {
+	if previous, ok := r.approvalHashes.Load(ns.Name); ok && previous == hash {
+		return
+	}
+	r.approvalHashes.Store(ns.Name, hash)
+	approvalPendingInfo.DeletePartialMatch(prometheus.Labels{"cluster": r.ClusterName, "namespace": ns.Name})
+	approvalPendingInfo.WithLabelValues(r.ClusterName, ns.Name, hash).Set(1)
+	r.event(ns, corev1.EventTypeNormal, "RolloutAwaitingApproval",
+		fmt.Sprintf("Config hash %s would restart %d workload(s); annotate the namespace with %s=%s to roll it out", hash, behind, ApprovedConfigHashAnnotation, hash))
+}
+
+// clearApprovalPending drops the hash awaiting approval in ns, if any, recording whether it was approved.
+func (r *ConfigMapReconciler) clearApprovalPending(ns *corev1.Namespace, approved bool) {
+	hash, ok := r.approvalHashes.LoadAndDelete(ns.Name)
+	if !ok {
+		return
+	}
+	approvalPendingInfo.DeletePartialMatch(prometheus.Labels{"cluster": r.ClusterName, "namespace": ns.Name})
+	if approved {
+		r.event(ns, corev1.EventTypeNormal, "RolloutApproved", fmt.Sprintf("Config hash %s approved; rolling it out", hash))
+	}
+}
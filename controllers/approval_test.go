@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestApprovalRequiredHoldsRolloutUntilApproved(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "matrix",
+		Annotations: map[string]string{ApprovalRequiredAnnotation: "true"},
+	}}
+	r := newTestReconciler(t, ns, newTestDeployment(nil), newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	pending, ok := r.approvalHashes.Load("matrix")
+	require.True(t, ok)
+	assert.Equal(t, float64(1), testutil.ToFloat64(approvalPendingInfo.WithLabelValues("", "matrix", pending.(string))))
+
+	ns.Annotations[ApprovedConfigHashAnnotation] = pending.(string)
+	require.NoError(t, r.Update(ctx, ns))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Equal(t, pending, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Equal(t, 0, testutil.CollectAndCount(approvalPendingInfo))
+}
+
+func TestApprovalNotNeededWhenWorkloadsAreCurrent(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix"}}
+	deploy := newTestDeployment(nil)
+	deploy.Spec.Template.Annotations = map[string]string{testHashAnnotation: "one"}
+	r := newTestReconciler(t, ns, deploy)
+	r.RequireApproval = true
+
+	pass := &rolloutPass{Namespace: "matrix", Hash: "one"}
+	require.NoError(t, r.approvalGate(ctx, pass))
+	_, reason := pass.Halted()
+	assert.Empty(t, reason)
+
+	pass = &rolloutPass{Namespace: "matrix", Hash: "two"}
+	require.NoError(t, r.approvalGate(ctx, pass))
+	_, reason = pass.Halted()
+	assert.Equal(t, "awaiting approval", reason)
+
+	ns.Annotations = map[string]string{ApprovalRequiredAnnotation: "false"}
+	require.NoError(t, r.Update(ctx, ns))
+	pass = &rolloutPass{Namespace: "matrix", Hash: "two"}
+	require.NoError(t, r.approvalGate(ctx, pass))
+	_, reason = pass.Halted()
+	assert.Empty(t, reason, "the namespace opted out of --require-approval")
+}
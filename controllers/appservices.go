@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+// AppserviceRegistrationLabel set to "true" on a Secret marks it as holding the registration file of a Matrix
+// appservice, such as a mautrix bridge, under AppserviceRegistrationKey.
+const AppserviceRegistrationLabel = "synapse.gen0sec.com/appservice-registration"
+
+// AppserviceRegistrationKey is the key of a registration Secret holding the registration file.
+const AppserviceRegistrationKey = "registration.yaml"
+
+// AppservicesConfigKey is the key of the generated Secret holding the homeserver config fragment that lists
+// the registration files in app_service_config_files.
+const AppservicesConfigKey = "appservices.yaml"
+
+// Defaults of --appservices-secret-name and --appservices-mount-path.
+const (
+	DefaultAppservicesSecretName = "synapse-appservices"
+	DefaultAppservicesMountPath  = "/synapse/appservices"
+)
+
+// appserviceRegistration holds the fields of a registration file Synapse refuses to start without.
+type appserviceRegistration struct {
+	ID              string `json:"id"`
+	ASToken         string `json:"as_token"`
+	HSToken         string `json:"hs_token"`
+	SenderLocalpart string `json:"sender_localpart"`
+}
+
+// parseAppserviceRegistration parses and validates a registration file.
+func parseAppserviceRegistration(data []byte) (appserviceRegistration, error) {
+	var registration appserviceRegistration
+	if err := yaml.Unmarshal(data, &registration); err != nil {
+		return registration, err
+	}
+	var missing []string
+	for _, field := range []struct{ name, value string }{
+		{"id", registration.ID},
+		{"as_token", registration.ASToken},
+		{"hs_token", registration.HSToken},
+		{"sender_localpart", registration.SenderLocalpart},
+	} {
+		if field.value == "" {
+			missing = append(missing, field.name)
+		}
+	}
+	if len(missing) > 0 {
+		return registration, fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return registration, nil
+}
+
+// AppserviceReconciler assembles the appservice registrations of a namespace, from the Secrets labelled with
+// AppserviceRegistrationLabel, into a generated Secret the homeserver mounts at MountPath: each registration
+// file as <secret>.yaml, and AppservicesConfigKey listing them for Synapse to include in its config. The
+// generated Secret carries the source selector labels, so adding or removing a registration changes the
+// namespace's config hash and rolls the homeserver. Requests are keyed by namespace name.
+type AppserviceReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	// SecretName is the name of the generated Secret.
+	SecretName string
+	// MountPath is where the homeserver mounts the generated Secret.
+	MountPath string
+	// Labels is applied to the generated Secret and must satisfy the operator's source selector.
+	Labels map[string]string
+}
+
+func (r *AppserviceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("namespace", req.Name)
+	list := &corev1.SecretList{}
+	if err := r.List(ctx, list, client.InNamespace(req.Name), client.MatchingLabels{AppserviceRegistrationLabel: "true"}); err != nil {
+		return ctrl.Result{}, err
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+
+	data := map[string][]byte{}
+	owners := map[string]string{}
+	var files []string
+	for i := range list.Items {
+		secret := &list.Items[i]
+		if secret.Name == r.SecretName {
+			continue
+		}
+		registration, err := parseAppserviceRegistration(secret.Data[AppserviceRegistrationKey])
+		if err != nil {
+			r.event(secret, corev1.EventTypeWarning, "InvalidAppserviceRegistration",
+				fmt.Sprintf("Skipping %s: %v", AppserviceRegistrationKey, err))
+			continue
+		}
+		if owner, ok := owners[registration.ID]; ok {
+			r.event(secret, corev1.EventTypeWarning, "InvalidAppserviceRegistration",
+				fmt.Sprintf("Skipping appservice %s: already registered by Secret %s", registration.ID, owner))
+			continue
+		}
+		owners[registration.ID] = secret.Name
+		file := secret.Name + ".yaml"
+		data[file] = secret.Data[AppserviceRegistrationKey]
+		files = append(files, path.Join(r.MountPath, file))
+	}
+	config, err := yaml.Marshal(map[string][]string{"app_service_config_files": append([]string{}, files...)})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	data[AppservicesConfigKey] = config
+
+	generated := &corev1.Secret{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: req.Name, Name: r.SecretName}, generated)
+	if apierrors.IsNotFound(err) {
+		if len(files) == 0 {
+			return ctrl.Result{}, nil
+		}
+		generated = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        r.SecretName,
+				Namespace:   req.Name,
+				Labels:      maps.Clone(r.Labels),
+				Annotations: map[string]string{ManagedByAnnotation: managedByValue},
+			},
+			Data: data,
+		}
+		if err := r.Create(ctx, generated); err != nil {
+			return ctrl.Result{}, fmt.Errorf("creating Secret %s: %w", r.SecretName, err)
+		}
+		logger.Info("Created appservice registrations", "secret", r.SecretName, "appservices", len(files))
+		r.event(generated, corev1.EventTypeNormal, "AppservicesUpdated", fmt.Sprintf("Registered %d appservice(s)", len(files)))
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if generated.Annotations[ManagedByAnnotation] != managedByValue {
+		r.event(generated, corev1.EventTypeWarning, "AppservicesNotUpdated",
+			fmt.Sprintf("Secret %s is not managed by the operator; remove it or choose another --appservices-secret-name", r.SecretName))
+		return ctrl.Result{}, nil
+	}
+	if maps.EqualFunc(generated.Data, data, bytes.Equal) {
+		return ctrl.Result{}, nil
+	}
+	generated.Data = data
+	if generated.Labels == nil {
+		generated.Labels = map[string]string{}
+	}
+	maps.Copy(generated.Labels, r.Labels)
+	if err := r.Update(ctx, generated); err != nil {
+		return ctrl.Result{}, fmt.Errorf("updating Secret %s: %w", r.SecretName, err)
+	}
+	logger.Info("Updated appservice registrations", "secret", r.SecretName, "appservices", len(files))
+	r.event(generated, corev1.EventTypeNormal, "AppservicesUpdated", fmt.Sprintf("Registered %d appservice(s)", len(files)))
+	return ctrl.Result{}, nil
+}
+
+func (r *AppserviceReconciler) event(obj client.Object, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(obj, eventType, reason, message)
+}
+
+// SetupWithManager reconciles a namespace whenever a registration Secret in it, or the generated Secret,
+// changes.
+func (r *AppserviceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	byNamespace := handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+		if obj.GetLabels()[AppserviceRegistrationLabel] != "true" && obj.GetName() != r.SecretName {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: obj.GetNamespace()}}}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("appservices").
+		Watches(&corev1.Secret{}, byNamespace).
+		Complete(r)
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestRegistration(name, id string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "matrix", Labels: map[string]string{AppserviceRegistrationLabel: "true"}},
+		Data: map[string][]byte{AppserviceRegistrationKey: []byte("id: " + id + "\nurl: http://" + name + ":29317\n" +
+			"as_token: as\nhs_token: hs\nsender_localpart: " + id + "bot\n")},
+	}
+}
+
+func newTestAppserviceReconciler(c client.Client) *AppserviceReconciler {
+	return &AppserviceReconciler{
+		Client:     c,
+		SecretName: DefaultAppservicesSecretName,
+		MountPath:  DefaultAppservicesMountPath,
+		Labels:     map[string]string{"app.kubernetes.io/name": "synapse"},
+	}
+}
+
+func TestParseAppserviceRegistration(t *testing.T) {
+	registration, err := parseAppserviceRegistration(newTestRegistration("telegram", "telegram").Data[AppserviceRegistrationKey])
+	require.NoError(t, err)
+	assert.Equal(t, "telegram", registration.ID)
+
+	_, err = parseAppserviceRegistration([]byte("id: telegram\nas_token: as\n"))
+	assert.EqualError(t, err, "missing hs_token, sender_localpart")
+	_, err = parseAppserviceRegistration([]byte("id: [telegram"))
+	assert.Error(t, err)
+}
+
+func TestAppservicesRollHomeserver(t *testing.T) {
+	ctx := context.Background()
+	invalid := newTestRegistration("broken", "broken")
+	invalid.Data[AppserviceRegistrationKey] = []byte("id: broken\n")
+	tr := newTestReconciler(t, newTestDeployment(nil),
+		newTestRegistration("signal", "signal"), newTestRegistration("telegram", "telegram"),
+		newTestRegistration("telegram-copy", "telegram"), invalid)
+	r := newTestAppserviceReconciler(tr.Client)
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "matrix"}}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	generated := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: DefaultAppservicesSecretName}, generated))
+	assert.Equal(t, "synapse", generated.Labels["app.kubernetes.io/name"])
+	assert.Equal(t, managedByValue, generated.Annotations[ManagedByAnnotation])
+	assert.Equal(t, "app_service_config_files:\n- /synapse/appservices/signal.yaml\n- /synapse/appservices/telegram.yaml\n",
+		string(generated.Data[AppservicesConfigKey]))
+	assert.Contains(t, generated.Data, "telegram.yaml")
+	assert.NotContains(t, generated.Data, "telegram-copy.yaml", "a duplicate appservice id is skipped")
+	assert.NotContains(t, generated.Data, "broken.yaml", "an invalid registration is skipped")
+
+	before, _, err := tr.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	require.NoError(t, r.Delete(ctx, newTestRegistration("signal", "signal")))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: DefaultAppservicesSecretName}, generated))
+	assert.Equal(t, "app_service_config_files:\n- /synapse/appservices/telegram.yaml\n", string(generated.Data[AppservicesConfigKey]))
+	after, _, err := tr.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after, "removing a registration changes the homeserver's config hash")
+}
+
+func TestAppservicesLeaveUnmanagedSecretAlone(t *testing.T) {
+	ctx := context.Background()
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultAppservicesSecretName, Namespace: "matrix"},
+		Data:       map[string][]byte{"custom": []byte("x")},
+	}
+	r := newTestAppserviceReconciler(newTestReconciler(t, existing, newTestRegistration("signal", "signal")).Client)
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: "matrix"}})
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: DefaultAppservicesSecretName}, existing))
+	assert.Equal(t, map[string][]byte{"custom": []byte("x")}, existing.Data)
+}
+
+func TestAppservicesWithoutRegistrations(t *testing.T) {
+	ctx := context.Background()
+	r := newTestAppserviceReconciler(newTestReconciler(t).Client)
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: "matrix"}})
+	require.NoError(t, err)
+	list := &corev1.SecretList{}
+	require.NoError(t, r.List(ctx, list))
+	assert.Empty(t, list.Items, "no Secret is generated before the first registration")
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"synapse-operator/notify"
+)
+
+// autoRollback marks the rollout of hash in the namespace's SynapseRolloutHistory as stalled and requests a
+// rollback to the latest earlier rollout that did not stall, which RolloutHistoryReconciler then applies.
+// A rollout is only rolled back once, and never to a hash that stalled before, so a rollback that stalls
+// too is reported but not chased further back.
+func (r *ConfigMapReconciler) autoRollback(ctx context.Context, namespace, hash string, now time.Time) error {
+	var target *RolloutRecord
+	obj := &unstructured.Unstructured{}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		target = nil
+		obj = &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(RolloutHistoryGVK)
+		if err := r.reader().Get(ctx, client.ObjectKey{Namespace: namespace, Name: RolloutHistoryResourceName}, obj); err != nil {
+			return err
+		}
+		records, err := readRolloutRecords(obj)
+		if err != nil {
+			return err
+		}
+		current := -1
+		for i := range records {
+			if records[i].Hash == hash {
+				current = i
+			}
+		}
+		if current < 0 || records[current].Stalled {
+			return nil
+		}
+		records[current].Stalled = true
+
+		stalled := map[string]struct{}{}
+		for _, record := range records {
+			if record.Stalled {
+				stalled[record.Hash] = struct{}{}
+			}
+		}
+		for i := current - 1; i >= 0; i-- {
+			if _, bad := stalled[records[i].Hash]; !bad {
+				target = &records[i]
+				break
+			}
+		}
+		if err := writeRolloutRecords(obj, records); err != nil {
+			return err
+		}
+		if target != nil {
+			annotations := obj.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[RollbackToAnnotation] = strconv.FormatInt(target.Revision, 10)
+			obj.SetAnnotations(annotations)
+		}
+		return r.Update(ctx, obj)
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil || target == nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Rollout of config hash %s stalled; rolling back to revision %d (config hash %s)", hash, target.Revision, target.Hash)
+	log.FromContext(ctx).Info("Requested automatic rollback", "namespace", namespace, "configHash", hash, "revision", target.Revision)
+	r.event(obj, corev1.EventTypeWarning, "AutoRollback", message)
+	r.Notifier.Notify(notify.Event{
+		Type:      notify.EventRollbackTriggered,
+		Time:      now,
+		Namespace: namespace,
+		Hash:      hash,
+		Error:     message,
+	})
+	return nil
+}
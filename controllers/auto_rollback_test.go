@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestAutoRollbackRequestsPreviousHealthyRevision(t *testing.T) {
+	ctx := context.Background()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "v1")
+	r := newTestReconciler(t, cm, newTestDeployment(nil))
+	r.RolloutHistoryRetention = 5
+	for _, content := range []string{"v1", "v2", "v3"} {
+		current := &corev1.ConfigMap{}
+		require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cm), current))
+		current.Data["data"] = content
+		require.NoError(t, r.Update(ctx, current))
+		_, err := r.rolloutWorkloads(ctx, "matrix", "hash-"+content, logr.Discard())
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, r.autoRollback(ctx, "matrix", "hash-v3", time.Now()))
+	history := getRolloutHistory(t, r.Client)
+	assert.Equal(t, "2", history.GetAnnotations()[RollbackToAnnotation])
+	records, err := readRolloutRecords(history)
+	require.NoError(t, err)
+	assert.True(t, records[2].Stalled)
+	assert.False(t, records[1].Stalled)
+
+	// A stalled rollout is only rolled back once.
+	history.SetAnnotations(nil)
+	require.NoError(t, r.Update(ctx, history))
+	require.NoError(t, r.autoRollback(ctx, "matrix", "hash-v3", time.Now()))
+	assert.NotContains(t, getRolloutHistory(t, r.Client).GetAnnotations(), RollbackToAnnotation)
+
+	// The rollback stalling too goes back past every hash that stalled.
+	require.NoError(t, r.autoRollback(ctx, "matrix", "hash-v2", time.Now()))
+	assert.Equal(t, "1", getRolloutHistory(t, r.Client).GetAnnotations()[RollbackToAnnotation])
+}
+
+func TestAutoRollbackWithoutHistory(t *testing.T) {
+	r := newTestReconciler(t)
+	assert.NoError(t, r.autoRollback(context.Background(), "matrix", "hash", time.Now()))
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StrategyCanary restarts a few pods of the workload on the new config first by evicting them, waits for
+// them to become ready, and only then rolls the rest by patching the pod template annotation.
+const StrategyCanary = "canary"
+
+const (
+	// CanarySizeAnnotation sets how many pods of a workload the canary strategy restarts first: a count such
+	// as "2" or a percentage of its pods such as "25%". Defaults to one pod.
+	CanarySizeAnnotation = "synapse.gen0sec.com/canary-size"
+	// CanaryApprovedAnnotation, set to the canary's config hash, promotes a canary held for manual approval.
+	CanaryApprovedAnnotation = "synapse.gen0sec.com/canary-approved"
+	// canaryHashAnnotation holds the hash a workload is running a canary for.
+	canaryHashAnnotation = "synapse.gen0sec.com/canary-hash"
+)
+
+// blockedReasonCanaryApproval marks a healthy canary held for manual approval.
+const blockedReasonCanaryApproval = "CanaryAwaitingApproval"
+
+// canaryRetryInterval is how long the canary strategy waits before checking on a canary again.
+const canaryRetryInterval = 10 * time.Second
+
+type canaryStrategy struct{}
+
+// appliedHash is the hash of a canary in progress, so a workload only takes one gradual rollout slot and
+// one impact report per canary.
+func (canaryStrategy) appliedHash(r *ConfigMapReconciler, w *workload) string {
+	if hash, ok := w.obj.GetAnnotations()[canaryHashAnnotation]; ok {
+		return hash
+	}
+	return w.template.Annotations[r.ConfigHashAnnotation]
+}
+
+func (canaryStrategy) inject(r *ConfigMapReconciler, w *workload, hash string) {
+	setTemplateAnnotation(w, r.ConfigHashAnnotation, hash)
+}
+
+// requestRestart rolls all pods at once: they keep the config they run, so there is nothing to canary.
+func (canaryStrategy) requestRestart(r *ConfigMapReconciler, w *workload, at time.Time) {
+	stampRestartedAt(r, w, at)
+}
+
+func (canaryStrategy) apply(ctx context.Context, r *ConfigMapReconciler, w *workload, hash string) (restartOutcome, error) {
+	outcome := restartOutcome{}
+	annotations := w.obj.GetAnnotations()
+	if w.template.Annotations[r.ConfigHashAnnotation] == hash {
+		if _, ok := annotations[canaryHashAnnotation]; !ok {
+			return outcome, nil
+		}
+		// The hash went back to the rolled out one mid-canary; drop the canary.
+		original := w.obj.DeepCopyObject().(client.Object)
+		delete(annotations, canaryHashAnnotation)
+		w.obj.SetAnnotations(annotations)
+		r.clearBlocked(w, blockedReasonCanaryApproval)
+		return outcome, r.Patch(ctx, w.obj, client.MergeFrom(original))
+	}
+	if annotations[canaryHashAnnotation] != hash {
+		original := w.obj.DeepCopyObject().(client.Object)
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[canaryHashAnnotation] = hash
+		annotations[restartRequestedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+		w.obj.SetAnnotations(annotations)
+		if err := r.Patch(ctx, w.obj, client.MergeFrom(original)); err != nil {
+			return outcome, err
+		}
+		outcome.updated = true
+	}
+	outcome.requeueAfter = canaryRetryInterval
+
+	requestedAt, err := time.Parse(time.RFC3339, annotations[restartRequestedAtAnnotation])
+	if err != nil {
+		return outcome, fmt.Errorf("invalid %s annotation: %w", restartRequestedAtAnnotation, err)
+	}
+	stale, err := r.outdatedPods(ctx, w, requestedAt)
+	if err != nil {
+		return outcome, err
+	}
+	fresh, ready, err := r.canaryPods(ctx, w, requestedAt)
+	if err != nil {
+		return outcome, err
+	}
+	size, err := canarySize(annotations[CanarySizeAnnotation], len(stale)+fresh)
+	if err != nil {
+		return outcome, err
+	}
+
+	if fresh < size && len(stale) > 0 {
+		if !w.available() {
+			return outcome, nil
+		}
+		pod := stale[0]
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+		if err := r.SubResource("eviction").Create(ctx, pod, eviction); err != nil {
+			if apierrors.IsTooManyRequests(err) || apierrors.IsNotFound(err) {
+				return outcome, nil
+			}
+			return outcome, err
+		}
+		r.traceEvent(ctx, w.obj, corev1.EventTypeNormal, "CanaryStarted", fmt.Sprintf("Evicted pod %s to run a canary of config hash %s", pod.Name, hash))
+		return outcome, nil
+	}
+	if ready < min(size, fresh) || !w.available() {
+		return outcome, nil
+	}
+	if r.CanaryManualApproval && annotations[CanaryApprovedAnnotation] != hash {
+		r.reportBlocked(w, hash, blockedReasonCanaryApproval,
+			fmt.Sprintf("Canary of config hash %s is healthy; annotate %s=%s to roll out the remaining pods", hash, CanaryApprovedAnnotation, hash))
+		return outcome, nil
+	}
+	r.clearBlocked(w, blockedReasonCanaryApproval)
+
+	original := w.obj.DeepCopyObject().(client.Object)
+	delete(annotations, canaryHashAnnotation)
+	delete(annotations, CanaryApprovedAnnotation)
+	w.obj.SetAnnotations(annotations)
+	if w.template.Annotations == nil {
+		w.template.Annotations = map[string]string{}
+	}
+	w.template.Annotations[r.ConfigHashAnnotation] = hash
+	stampRestartCause(ctx, w)
+	if err := r.Patch(ctx, w.obj, client.MergeFrom(original)); err != nil {
+		return outcome, err
+	}
+	r.traceEvent(ctx, w.obj, corev1.EventTypeNormal, "CanaryPromoted", fmt.Sprintf("Canary of config hash %s is healthy; rolling out the remaining pods", hash))
+	return restartOutcome{updated: true}, nil
+}
+
+// canaryPods counts the running pods of w created at or after requestedAt, and how many of them are ready.
+func (r *ConfigMapReconciler) canaryPods(ctx context.Context, w *workload, requestedAt time.Time) (int, int, error) {
+	selector, err := metav1.LabelSelectorAsSelector(w.selector)
+	if err != nil {
+		return 0, 0, err
+	}
+	pods := &corev1.PodList{}
+	if err := r.reader().List(ctx, pods, client.InNamespace(w.obj.GetNamespace()), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return 0, 0, err
+	}
+	fresh, ready := 0, 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || pod.CreationTimestamp.Time.Before(requestedAt) {
+			continue
+		}
+		fresh++
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+	}
+	return fresh, ready, nil
+}
+
+// canarySize parses CanarySizeAnnotation for a workload with pods pods. Percentages round up, so a canary
+// always restarts at least one pod.
+func canarySize(value string, pods int) (int, error) {
+	if value == "" {
+		return 1, nil
+	}
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.Atoi(percent)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, fmt.Errorf("invalid %s %q", CanarySizeAnnotation, value)
+		}
+		return max(int(math.Ceil(float64(pods)*float64(p)/100)), 1), nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", CanarySizeAnnotation, value)
+	}
+	return n, nil
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/audit"
+	"synapse-operator/pipeline"
+)
+
+// CanaryNamespaceAnnotation on a Namespace names the canary namespace every config change of it is replayed
+// into, and must pass in, before it rolls out.
+const CanaryNamespaceAnnotation = "synapse.gen0sec.com/canary-namespace"
+
+const (
+	// replayedFromAnnotation marks a ConfigMap copied into a canary namespace with "<namespace>/<name>" of
+	// its original. ConfigMaps without it are never overwritten.
+	replayedFromAnnotation = "synapse.gen0sec.com/replayed-from"
+	// replayedHashAnnotation records the config hash of the original namespace a copy was replayed for.
+	replayedHashAnnotation = "synapse.gen0sec.com/replayed-config-hash"
+)
+
+// canaryNamespacePollInterval is how often a rollout waiting on its canary namespace checks on it.
+const canaryNamespacePollInterval = 15 * time.Second
+
+// canaryNamespaceGate replays the ConfigMaps of the namespace into its canary namespace and holds the
+// rollout until the canary namespace has rolled them out, is available, and passes the verifiers.
+func (r *ConfigMapReconciler) canaryNamespaceGate(ctx context.Context, pass *rolloutPass) error {
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pass.Namespace}, ns); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	canary := ns.Annotations[CanaryNamespaceAnnotation]
+	if canary == "" || canary == pass.Namespace {
+		return nil
+	}
+	behind, err := r.workloadsBehind(ctx, pass.Namespace, pass.Hash)
+	if err != nil || len(behind) == 0 {
+		return err
+	}
+	hold := func(detail string) {
+		pass.Logger.Info("Holding rollout until the canary namespace passes", "canaryNamespace", canary, "configHash", pass.Hash, "state", detail)
+		audit.FromContext(ctx).AddGate(audit.Gate{Name: "canary-namespace", Detail: canary + ": " + detail})
+		pass.RequeueAfter(canaryNamespacePollInterval)
+		pass.Halt("canary namespace " + detail)
+	}
+
+	replayed, err := r.replayConfigMaps(ctx, canary, pass.Namespace, pass.Hash, pass.State.configMaps)
+	if err != nil {
+		var conflict *replayConflictError
+		if errors.As(err, &conflict) {
+			r.traceEvent(ctx, ns, corev1.EventTypeWarning, "CanaryNamespaceConflict", err.Error())
+			hold("blocked by " + conflict.name)
+			return nil
+		}
+		return err
+	}
+	if replayed {
+		r.traceEvent(ctx, ns, corev1.EventTypeNormal, "CanaryNamespaceReplay", fmt.Sprintf("Replaying config hash %s into canary namespace %s before rolling it out", pass.Hash, canary))
+		hold("replaying")
+		return nil
+	}
+
+	status, err := r.namespaceStatus(ctx, canary)
+	if err != nil {
+		return err
+	}
+	rollout := pipeline.Rollout{Namespace: canary, Hash: status.Hash}
+	for _, workload := range status.Workloads {
+		if !workload.Current || !workload.Available {
+			hold(fmt.Sprintf("waiting for %s/%s", strings.ToLower(workload.Kind), workload.Name))
+			return nil
+		}
+		rollout.Workloads = append(rollout.Workloads, pipeline.Workload{Kind: workload.Kind, Name: workload.Name, AppliedHash: workload.AppliedHash})
+	}
+	if len(rollout.Workloads) == 0 {
+		hold("has no targeted workloads")
+		return nil
+	}
+	for _, verifier := range r.Verifiers {
+		if err := verifier.Verify(ctx, rollout); err != nil {
+			hold(fmt.Sprintf("failed verifier %s: %v", verifier.Name(), err))
+			return nil
+		}
+	}
+	if previous, ok := r.canaryPassed.Load(pass.Namespace); !ok || previous != pass.Hash {
+		r.canaryPassed.Store(pass.Namespace, pass.Hash)
+		r.traceEvent(ctx, ns, corev1.EventTypeNormal, "CanaryNamespacePassed", fmt.Sprintf("Config hash %s passed in canary namespace %s; rolling it out", pass.Hash, canary))
+	}
+	return nil
+}
+
+// replayConfigMaps copies configMaps of namespace into canary, tagged with hash. It reports whether any
+// copy had to be written, in which case the canary namespace has yet to roll it out.
+func (r *ConfigMapReconciler) replayConfigMaps(ctx context.Context, canary, namespace, hash string, configMaps []corev1.ConfigMap) (bool, error) {
+	written := false
+	for i := range configMaps {
+		original := &configMaps[i]
+		from := namespace + "/" + original.Name
+		existing := &corev1.ConfigMap{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: canary, Name: original.Name}, existing)
+		if apierrors.IsNotFound(err) {
+			replica := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: canary, Name: original.Name}}
+			setReplica(replica, original, from, hash)
+			if err := r.Create(ctx, replica); err != nil {
+				return written, err
+			}
+			written = true
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+		if existing.Annotations[replayedFromAnnotation] != from {
+			return written, &replayConflictError{name: canary + "/" + original.Name}
+		}
+		if existing.Annotations[replayedHashAnnotation] == hash {
+			continue
+		}
+		setReplica(existing, original, from, hash)
+		if err := r.Update(ctx, existing); err != nil {
+			return written, err
+		}
+		written = true
+	}
+	return written, nil
+}
+
+// setReplica makes replica a copy of the data and labels of original.
+func setReplica(replica, original *corev1.ConfigMap, from, hash string) {
+	replica.Labels = maps.Clone(original.Labels)
+	if replica.Annotations == nil {
+		replica.Annotations = map[string]string{}
+	}
+	replica.Annotations[replayedFromAnnotation] = from
+	replica.Annotations[replayedHashAnnotation] = hash
+	replica.Data = maps.Clone(original.Data)
+	replica.BinaryData = maps.Clone(original.BinaryData)
+}
+
+// replayConflictError reports a ConfigMap in the canary namespace that the operator did not create.
+type replayConflictError struct {
+	name string
+}
+
+func (e *replayConflictError) Error() string {
+	return fmt.Sprintf("ConfigMap %s exists and is not a replayed copy; remove it or the %s annotation", e.name, CanaryNamespaceAnnotation)
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestCanaryNamespaceReplayGatesProduction(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{CanaryNamespaceAnnotation: "matrix-canary"}}}
+	canaryDeploy := newTestDeployment(nil)
+	canaryDeploy.Namespace = "matrix-canary"
+	ignored := newTestConfigMap("chart-values", map[string]string{"app.kubernetes.io/name": "synapse"}, map[string]string{SourcePolicyAnnotation: SourcePolicyIgnore}, "x")
+	r := newTestReconciler(t, ns, newTestDeployment(nil), canaryDeploy, ignored, newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	r.CanaryNamespaces = true
+	production := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+	canary := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix-canary", Name: "homeserver"}}
+	productionHash := func() string {
+		deploy := &appsv1.Deployment{}
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+		return deploy.Spec.Template.Annotations[testHashAnnotation]
+	}
+
+	result, err := r.Reconcile(ctx, production)
+	require.NoError(t, err)
+	assert.Equal(t, canaryNamespacePollInterval, result.RequeueAfter)
+	replica := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, canary.NamespacedName, replica))
+	assert.Equal(t, "matrix/homeserver", replica.Annotations[replayedFromAnnotation])
+	assert.Equal(t, map[string]string{"data": "a"}, replica.Data)
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix-canary", Name: "chart-values"}, replica), "sources the hash ignores are replayed too")
+	assert.Empty(t, productionHash())
+
+	_, err = r.Reconcile(ctx, production)
+	require.NoError(t, err)
+	assert.Empty(t, productionHash(), "the canary namespace has not rolled out yet")
+
+	_, err = r.Reconcile(ctx, canary)
+	require.NoError(t, err)
+	_, err = r.Reconcile(ctx, production)
+	require.NoError(t, err)
+	assert.NotEmpty(t, productionHash())
+}
+
+func TestCanaryNamespaceReplayRefusesForeignConfigMaps(t *testing.T) {
+	ctx := context.Background()
+	foreign := newTestConfigMap("homeserver", nil, nil, "other")
+	foreign.Namespace = "matrix-canary"
+	r := newTestReconciler(t, foreign)
+
+	_, err := r.replayConfigMaps(ctx, "matrix-canary", "matrix", "one", []corev1.ConfigMap{*newTestConfigMap("homeserver", nil, nil, "a")})
+	var conflict *replayConflictError
+	assert.ErrorAs(t, err, &conflict)
+}
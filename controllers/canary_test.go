@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestPod(name string, created time.Time, ready bool) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "matrix",
+			Labels:            map[string]string{"app": "synapse"},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+	}
+	if ready {
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	}
+	return pod
+}
+
+func TestCanaryStrategyRestartsOnePodBeforePromoting(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(map[string]string{RestartStrategyAnnotation: StrategyCanary})
+	r := newTestReconciler(t, deploy,
+		newTestPod("synapse-a", time.Now().Add(-2*time.Hour), true),
+		newTestPod("synapse-b", time.Now().Add(-time.Hour), true))
+	r.CanaryManualApproval = true
+	current := func() *appsv1.Deployment {
+		var d appsv1.Deployment
+		require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), &d))
+		return &d
+	}
+	_, strategy, err := r.strategyFor(deploymentWorkload(deploy))
+	require.NoError(t, err)
+
+	outcome, err := strategy.apply(ctx, r, deploymentWorkload(current()), "abc")
+	require.NoError(t, err)
+	assert.True(t, outcome.updated)
+	assert.Equal(t, canaryRetryInterval, outcome.requeueAfter)
+	assert.Equal(t, "abc", strategy.appliedHash(r, deploymentWorkload(current())))
+	var pods corev1.PodList
+	require.NoError(t, r.List(ctx, &pods, client.InNamespace("matrix")))
+	require.Len(t, pods.Items, 1)
+	assert.Equal(t, "synapse-b", pods.Items[0].Name, "the oldest pod is the canary")
+
+	// The replacement is not ready yet.
+	canary := newTestPod("synapse-c", time.Now().Add(time.Minute), false)
+	require.NoError(t, r.Create(ctx, canary))
+	_, err = strategy.apply(ctx, r, deploymentWorkload(current()), "abc")
+	require.NoError(t, err)
+	require.NoError(t, r.List(ctx, &pods, client.InNamespace("matrix")))
+	assert.Len(t, pods.Items, 2, "no further evictions during the canary")
+
+	// Ready, but waiting for approval.
+	canary.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	require.NoError(t, r.Status().Update(ctx, canary))
+	_, err = strategy.apply(ctx, r, deploymentWorkload(current()), "abc")
+	require.NoError(t, err)
+	assert.Empty(t, current().Spec.Template.Annotations[testHashAnnotation])
+	_, blocked := r.blockedHashes.Load("matrix/deployment/synapse/" + blockedReasonCanaryApproval)
+	assert.True(t, blocked)
+
+	approved := current()
+	approved.Annotations[CanaryApprovedAnnotation] = "abc"
+	require.NoError(t, r.Update(ctx, approved))
+	outcome, err = strategy.apply(ctx, r, deploymentWorkload(current()), "abc")
+	require.NoError(t, err)
+	assert.True(t, outcome.updated)
+	promoted := current()
+	assert.Equal(t, "abc", promoted.Spec.Template.Annotations[testHashAnnotation])
+	assert.NotContains(t, promoted.Annotations, canaryHashAnnotation)
+	assert.NotContains(t, promoted.Annotations, CanaryApprovedAnnotation)
+	_, blocked = r.blockedHashes.Load("matrix/deployment/synapse/" + blockedReasonCanaryApproval)
+	assert.False(t, blocked)
+}
+
+func TestCanarySize(t *testing.T) {
+	for value, want := range map[string]int{"": 1, "3": 3, "10%": 1, "30%": 3, "100%": 8} {
+		size, err := canarySize(value, 8)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, size, value)
+	}
+	for _, value := range []string{"0", "-1", "0%", "150%", "one"} {
+		_, err := canarySize(value, 8)
+		assert.Error(t, err, value)
+	}
+}
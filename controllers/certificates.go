@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var certificateGVK = schema.GroupVersionKind{
+	Group:   "cert-manager.io",
+	Version: "v1",
+	Kind:    "Certificate",
+}
+
+// certificateIssuance identifies the certificate currently issued for a Certificate: cert-manager bumps
+// status.revision on every issuance and sets status.notAfter to the expiry of the new certificate.
+func certificateIssuance(u *unstructured.Unstructured) string {
+	revision, _, _ := unstructured.NestedInt64(u.Object, "status", "revision")
+	notAfter, _, _ := unstructured.NestedString(u.Object, "status", "notAfter")
+	return fmt.Sprintf("%d/%s", revision, notAfter)
+}
+
+// watchCertificates adds a watch on the Certificates matching the selector, reconciling the Secret each one
+// issues into whenever a new certificate is issued or the spec changes.
+func (r *ConfigMapReconciler) watchCertificates(b *builder.Builder, matchesSelector predicate.Predicate) *builder.Builder {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	return b.Watches(
+		certificate,
+		handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return nil
+			}
+			secretName, _, _ := unstructured.NestedString(u.Object, "spec", "secretName")
+			if secretName == "" {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: secretName}}}
+		}),
+		builder.WithPredicates(matchesSelector, predicate.Or(predicate.GenerationChangedPredicate{}, certificateIssuedPredicate())),
+	)
+}
+
+// certificateIssuedPredicate passes updates that issue a new certificate, dropping the status updates
+// cert-manager makes in between, such as renewal times and conditions.
+func certificateIssuedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldObj, okOld := e.ObjectOld.(*unstructured.Unstructured)
+			newObj, okNew := e.ObjectNew.(*unstructured.Unstructured)
+			if !okOld || !okNew {
+				return true
+			}
+			return certificateIssuance(oldObj) != certificateIssuance(newObj)
+		},
+	}
+}
+
+// certificateDigests hashes the Secret name and current issuance of each matching Certificate, so a
+// re-issued certificate rolls the workloads even when the Secret cert-manager writes carries none of the
+// source labels.
+func (r *ConfigMapReconciler) certificateDigests(ctx context.Context, namespace string) ([]sourceDigest, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(certificateGVK.GroupVersion().WithKind(certificateGVK.Kind + "List"))
+	if err := r.cacheReader().List(
+		ctx,
+		list,
+		client.InNamespace(namespace),
+		client.MatchingLabelsSelector{Selector: r.sourceSelector()},
+	); err != nil {
+		return nil, err
+	}
+	digests := make([]sourceDigest, 0, len(list.Items))
+	for i := range list.Items {
+		certificate := &list.Items[i]
+		secretName, _, _ := unstructured.NestedString(certificate.Object, "spec", "secretName")
+		hasher := sha256.New()
+		hasher.Write([]byte(secretName))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(certificateIssuance(certificate)))
+		digests = append(digests, sourceDigest{
+			key:  "certificate/" + certificate.GetName(),
+			hash: hex.EncodeToString(hasher.Sum(nil)),
+		})
+	}
+	return digests, nil
+}
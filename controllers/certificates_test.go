@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func newTestCertificate(revision int64, notAfter, renewalTime string) *unstructured.Unstructured {
+	certificate := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec":   map[string]interface{}{"secretName": "synapse-tls"},
+		"status": map[string]interface{}{"revision": revision, "notAfter": notAfter, "renewalTime": renewalTime},
+	}}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetNamespace("matrix")
+	certificate.SetName("synapse")
+	certificate.SetLabels(map[string]string{"app.kubernetes.io/name": "synapse"})
+	return certificate
+}
+
+func TestCertificateDigestsTrackIssuance(t *testing.T) {
+	ctx := context.Background()
+	digestFor := func(certificate *unstructured.Unstructured) string {
+		r := newTestReconciler(t, certificate)
+		digests, err := r.certificateDigests(ctx, "matrix")
+		require.NoError(t, err)
+		require.Len(t, digests, 1)
+		assert.Equal(t, "certificate/synapse", digests[0].key)
+		return digests[0].hash
+	}
+
+	issued := digestFor(newTestCertificate(1, "2027-01-01T00:00:00Z", "2026-12-01T00:00:00Z"))
+	assert.Equal(t, issued, digestFor(newTestCertificate(1, "2027-01-01T00:00:00Z", "2026-12-02T00:00:00Z")))
+	assert.NotEqual(t, issued, digestFor(newTestCertificate(2, "2027-03-01T00:00:00Z", "2027-02-01T00:00:00Z")))
+}
+
+func TestCertificateIssuedPredicate(t *testing.T) {
+	p := certificateIssuedPredicate()
+	old := newTestCertificate(1, "2027-01-01T00:00:00Z", "2026-12-01T00:00:00Z")
+	assert.False(t, p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: newTestCertificate(1, "2027-01-01T00:00:00Z", "2026-12-02T00:00:00Z")}))
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: newTestCertificate(2, "2027-03-01T00:00:00Z", "2027-02-01T00:00:00Z")}))
+}
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ClusterNameLabel names the cluster of a kubeconfig Secret, as Cluster API labels the Secrets it writes.
+const ClusterNameLabel = "cluster.x-k8s.io/cluster-name"
+
+// clusterKubeconfigKeys are the keys a kubeconfig Secret may hold its kubeconfig under, in order: Cluster API
+// writes it under "value".
+var clusterKubeconfigKeys = []string{"value", "kubeconfig"}
+
+// clusterRetryInterval is how long a remote cluster whose manager stopped on an error waits before it is
+// connected again.
+const clusterRetryInterval = 30 * time.Second
+
+// ClusterRegistry connects the operator to the remote clusters registered through kubeconfig Secrets in
+// Namespace matching Selector, laid out the way Cluster API writes them. For each Secret it builds a manager
+// with Connect and runs it until the Secret changes or goes, so one operator in a hub cluster rolls out the
+// config changes of every registered cluster. It runs on the leader only: the remote managers elect no
+// leader of their own. Requests are keyed by Secret.
+type ClusterRegistry struct {
+	// APIReader reads the kubeconfig Secrets, which the cache may hold without their data.
+	APIReader client.Reader
+	Recorder  record.EventRecorder
+	Namespace string
+	Selector  labels.Selector
+	// Connect builds the manager of the remote cluster called name, with its controllers set up.
+	Connect func(name string, config *rest.Config) (manager.Runnable, error)
+
+	mu  sync.Mutex
+	ctx context.Context
+	// clusters maps the name of each kubeconfig Secret to its connected cluster.
+	clusters map[string]*remoteCluster
+	retries  chan event.GenericEvent
+	running  sync.WaitGroup
+}
+
+// remoteCluster is a remote cluster whose manager runs until cancel is called, or it fails.
+type remoteCluster struct {
+	name string
+	// digest identifies the name and kubeconfig the cluster was connected with.
+	digest [sha256.Size]byte
+	cancel context.CancelFunc
+	done   chan struct{}
+	failed atomic.Bool
+}
+
+// clusterName returns the name of the cluster of secret: its ClusterNameLabel, or the name of the Secret
+// without the "-kubeconfig" suffix Cluster API gives it.
+func clusterName(secret *corev1.Secret) string {
+	if name := secret.Labels[ClusterNameLabel]; name != "" {
+		return name
+	}
+	return strings.TrimSuffix(secret.Name, "-kubeconfig")
+}
+
+// clusterKubeconfig returns the kubeconfig secret holds under the first of clusterKubeconfigKeys it has.
+func clusterKubeconfig(secret *corev1.Secret) []byte {
+	for _, key := range clusterKubeconfigKeys {
+		if value := secret.Data[key]; len(value) > 0 {
+			return value
+		}
+	}
+	return nil
+}
+
+func (r *ClusterRegistry) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r.mu.Lock()
+	started := r.ctx != nil
+	r.mu.Unlock()
+	if !started {
+		// The registry has not started yet, so there is no context to run the clusters in.
+		return ctrl.Result{RequeueAfter: time.Second}, nil
+	}
+	logger := log.FromContext(ctx).WithValues("secret", req.NamespacedName)
+
+	secret := &corev1.Secret{}
+	if err := r.APIReader.Get(ctx, req.NamespacedName, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.disconnect(ctx, req.Name)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if !secret.DeletionTimestamp.IsZero() || !r.Selector.Matches(labels.Set(secret.Labels)) {
+		r.disconnect(ctx, req.Name)
+		return ctrl.Result{}, nil
+	}
+
+	name, kubeconfig := clusterName(secret), clusterKubeconfig(secret)
+	digest := sha256.Sum256(append([]byte(name+"\x00"), kubeconfig...))
+	if r.connected(req.Name, digest) {
+		return ctrl.Result{}, nil
+	}
+	r.disconnect(ctx, req.Name)
+	if kubeconfig == nil {
+		r.event(secret, corev1.EventTypeWarning, "InvalidKubeconfig", fmt.Sprintf("Not connecting cluster %s: the Secret has no kubeconfig under %s", name, strings.Join(clusterKubeconfigKeys, " or ")))
+		return ctrl.Result{}, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for secretName, other := range r.clusters {
+		if other.name == name {
+			r.event(secret, corev1.EventTypeWarning, "DuplicateCluster", fmt.Sprintf("Not connecting cluster %s: it is registered by Secret %s already", name, secretName))
+			return ctrl.Result{}, nil
+		}
+	}
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		r.event(secret, corev1.EventTypeWarning, "InvalidKubeconfig", fmt.Sprintf("Not connecting cluster %s: %v", name, err))
+		return ctrl.Result{}, nil
+	}
+	runnable, err := r.Connect(name, config)
+	if err != nil {
+		clusterConnectedGauge.WithLabelValues(name).Set(0)
+		return ctrl.Result{}, fmt.Errorf("connecting cluster %s: %w", name, err)
+	}
+
+	clusterCtx, cancel := context.WithCancel(r.ctx)
+	cluster := &remoteCluster{name: name, digest: digest, cancel: cancel, done: make(chan struct{})}
+	r.clusters[req.Name] = cluster
+	clusterConnectedGauge.WithLabelValues(name).Set(1)
+	logger.Info("Connected remote cluster", "cluster", name, "host", config.Host)
+	r.event(secret, corev1.EventTypeNormal, "ClusterConnected", fmt.Sprintf("Rolling out config changes in cluster %s", name))
+	r.running.Add(1)
+	go r.run(clusterCtx, req.NamespacedName, cluster, runnable)
+	return ctrl.Result{}, nil
+}
+
+// run runs the manager of cluster until ctx is done. A manager that stops on its own, such as one that
+// cannot reach its cluster, marks the cluster failed and has its Secret reconciled again after
+// clusterRetryInterval, which connects it anew.
+func (r *ClusterRegistry) run(ctx context.Context, secret types.NamespacedName, cluster *remoteCluster, runnable manager.Runnable) {
+	defer r.running.Done()
+	err := runnable.Start(ctx)
+	close(cluster.done)
+	if ctx.Err() != nil {
+		return
+	}
+	clusterConnectedGauge.WithLabelValues(cluster.name).Set(0)
+	cluster.failed.Store(true)
+	log.FromContext(ctx).Error(err, "Remote cluster stopped, reconnecting", "cluster", cluster.name, "retryIn", clusterRetryInterval)
+	select {
+	case <-ctx.Done():
+	case <-time.After(clusterRetryInterval):
+		retry := event.GenericEvent{Object: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: secret.Namespace, Name: secret.Name}}}
+		select {
+		case r.retries <- retry:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// connected reports whether the Secret called secretName registers a running cluster connected with digest.
+func (r *ClusterRegistry) connected(secretName string, digest [sha256.Size]byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, ok := r.clusters[secretName]
+	return ok && current.digest == digest && !current.failed.Load()
+}
+
+// disconnect stops the cluster registered by the Secret called secretName, if any, and waits for its
+// manager to return. It must be called without mu held: the cluster is unregistered under mu, but a manager
+// may take long to shut down, and the other Secrets are reconciled meanwhile.
+func (r *ClusterRegistry) disconnect(ctx context.Context, secretName string) {
+	r.mu.Lock()
+	cluster, ok := r.clusters[secretName]
+	delete(r.clusters, secretName)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	cluster.cancel()
+	<-cluster.done
+	clusterConnectedGauge.DeleteLabelValues(cluster.name)
+	rolloutBudgetRollingGauge.DeleteLabelValues(cluster.name)
+	rolloutBudgetQueuedGauge.DeleteLabelValues(cluster.name)
+	log.FromContext(ctx).Info("Disconnected remote cluster", "cluster", cluster.name)
+}
+
+func (r *ClusterRegistry) event(obj client.Object, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(obj, eventType, reason, message)
+}
+
+// Start provides the context the remote clusters run in and, once it is done, waits for them to stop.
+func (r *ClusterRegistry) Start(ctx context.Context) error {
+	r.mu.Lock()
+	r.ctx = ctx
+	if r.clusters == nil {
+		r.clusters = map[string]*remoteCluster{}
+	}
+	r.mu.Unlock()
+	<-ctx.Done()
+	r.running.Wait()
+	return nil
+}
+
+// SetupWithManager reconciles the Secrets of Namespace whenever they change, and those of clusters to
+// reconnect.
+func (r *ClusterRegistry) SetupWithManager(mgr ctrl.Manager) error {
+	if r.retries == nil {
+		r.retries = make(chan event.GenericEvent)
+	}
+	if err := mgr.Add(r); err != nil {
+		return err
+	}
+	inNamespace := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetNamespace() == r.Namespace
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("cluster-registry").
+		For(&corev1.Secret{}, builder.WithPredicates(inNamespace)).
+		WatchesRawSource(source.Channel(r.retries, &handler.EnqueueRequestForObject{})).
+		Complete(r)
+}
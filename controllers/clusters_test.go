@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: spoke
+  cluster:
+    server: https://%s.example:6443
+contexts:
+- name: spoke
+  context:
+    cluster: spoke
+    user: operator
+current-context: spoke
+users:
+- name: operator
+  user:
+    token: token
+`
+
+// fakeCluster is the manager of a remote cluster, which runs until stopped or fails with err. With linger,
+// it only returns once linger is closed.
+type fakeCluster struct {
+	host    string
+	err     error
+	stopped chan struct{}
+	linger  chan struct{}
+}
+
+func (c *fakeCluster) Start(ctx context.Context) error {
+	if c.err != nil {
+		return c.err
+	}
+	<-ctx.Done()
+	if c.linger != nil {
+		<-c.linger
+	}
+	close(c.stopped)
+	return nil
+}
+
+func newTestKubeconfigSecret(name, cluster, host string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "clusters", Labels: map[string]string{ClusterNameLabel: cluster}},
+		Data:       map[string][]byte{"value": []byte(fmt.Sprintf(testKubeconfig, host))},
+	}
+}
+
+func newTestClusterRegistry(t *testing.T, objs ...client.Object) (*ClusterRegistry, map[string]*fakeCluster) {
+	t.Helper()
+	connected := map[string]*fakeCluster{}
+	r := &ClusterRegistry{
+		APIReader: fake.NewClientBuilder().WithObjects(objs...).Build(),
+		Namespace: "clusters",
+		Selector:  labels.SelectorFromSet(nil),
+		Connect: func(name string, config *rest.Config) (manager.Runnable, error) {
+			cluster := &fakeCluster{host: config.Host, stopped: make(chan struct{})}
+			if name == "broken" {
+				cluster.err = errors.New("cache did not sync")
+			}
+			connected[name] = cluster
+			return cluster, nil
+		},
+		clusters: map[string]*remoteCluster{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.ctx = ctx
+	t.Cleanup(func() {
+		cancel()
+		r.running.Wait()
+	})
+	return r, connected
+}
+
+func TestClusterRegistryConnectsKubeconfigSecrets(t *testing.T) {
+	ctx := context.Background()
+	secret := newTestKubeconfigSecret("spoke-kubeconfig", "spoke", "spoke-a")
+	r, connected := newTestClusterRegistry(t, secret)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "clusters", Name: "spoke-kubeconfig"}}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Contains(t, connected, "spoke")
+	first := connected["spoke"]
+	assert.Equal(t, "https://spoke-a.example:6443", first.host)
+	assert.Equal(t, float64(1), testutil.ToFloat64(clusterConnectedGauge.WithLabelValues("spoke")))
+
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Same(t, first, connected["spoke"], "an unchanged Secret keeps the cluster connected")
+
+	secret.Data["value"] = []byte(fmt.Sprintf(testKubeconfig, "spoke-b"))
+	require.NoError(t, r.APIReader.(client.Client).Update(ctx, secret))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.NotSame(t, first, connected["spoke"])
+	assert.Equal(t, "https://spoke-b.example:6443", connected["spoke"].host)
+	select {
+	case <-first.stopped:
+	default:
+		t.Fatal("the manager of the previous kubeconfig still runs")
+	}
+
+	second := connected["spoke"]
+	require.NoError(t, r.APIReader.(client.Client).Delete(ctx, secret))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	<-second.stopped
+	assert.Empty(t, r.clusters)
+}
+
+func TestClusterRegistryRejectsInvalidSecrets(t *testing.T) {
+	ctx := context.Background()
+	empty := newTestKubeconfigSecret("empty-kubeconfig", "empty", "")
+	empty.Data = nil
+	duplicate := newTestKubeconfigSecret("spoke-copy", "spoke", "spoke-b")
+	r, connected := newTestClusterRegistry(t, newTestKubeconfigSecret("spoke-kubeconfig", "spoke", "spoke-a"), empty, duplicate)
+
+	for _, name := range []string{"spoke-kubeconfig", "empty-kubeconfig", "spoke-copy"} {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "clusters", Name: name}})
+		require.NoError(t, err)
+	}
+	assert.Len(t, connected, 1)
+	assert.Equal(t, "https://spoke-a.example:6443", connected["spoke"].host)
+	assert.Equal(t, []string{"spoke-kubeconfig"}, slices.Collect(maps.Keys(r.clusters)))
+}
+
+func TestClusterRegistryReconnectsFailedClusters(t *testing.T) {
+	ctx := context.Background()
+	r, connected := newTestClusterRegistry(t, newTestKubeconfigSecret("broken-kubeconfig", "broken", "broken"))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "clusters", Name: "broken-kubeconfig"}}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	first := connected["broken"]
+	require.Eventually(t, func() bool { return r.clusters["broken-kubeconfig"].failed.Load() }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(0), testutil.ToFloat64(clusterConnectedGauge.WithLabelValues("broken")))
+
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.NotSame(t, first, connected["broken"], "a failed cluster is connected anew")
+}
+
+func TestClusterName(t *testing.T) {
+	assert.Equal(t, "spoke", clusterName(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "spoke-kubeconfig"}}))
+	assert.Equal(t, "east", clusterName(newTestKubeconfigSecret("spoke-kubeconfig", "east", "east")))
+}
+
+func TestClusterRegistryReconcilesWhileDisconnecting(t *testing.T) {
+	ctx := context.Background()
+	secret := newTestKubeconfigSecret("spoke-kubeconfig", "spoke", "spoke-a")
+	r, connected := newTestClusterRegistry(t, secret, newTestKubeconfigSecret("other-kubeconfig", "other", "other"))
+	spoke := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "clusters", Name: "spoke-kubeconfig"}}
+	_, err := r.Reconcile(ctx, spoke)
+	require.NoError(t, err)
+	slow := connected["spoke"]
+	slow.linger = make(chan struct{})
+
+	require.NoError(t, r.APIReader.(client.Client).Delete(ctx, secret))
+	disconnected := make(chan error)
+	go func() {
+		_, err := r.Reconcile(ctx, spoke)
+		disconnected <- err
+	}()
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.clusters) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// The manager of spoke has not returned yet, which must not hold up the other Secrets.
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "clusters", Name: "other-kubeconfig"}})
+	require.NoError(t, err)
+	assert.Contains(t, connected, "other")
+	select {
+	case <-disconnected:
+		t.Fatal("disconnect returned before the manager stopped")
+	default:
+	}
+	close(slow.linger)
+	require.NoError(t, <-disconnected)
+	<-slow.stopped
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"synapse-operator/audit"
+)
+
+const (
+	// defaultConfigCheckTimeout bounds a config check request when ConfigCheckTimeout is not set.
+	defaultConfigCheckTimeout = 30 * time.Second
+	// configCheckRetryInterval is how soon a config check that could not be completed is tried again.
+	configCheckRetryInterval = time.Minute
+	// maxConfigCheckMessage bounds how much of a rejecting response is quoted in events and logs.
+	maxConfigCheckMessage = 1024
+)
+
+// Results of a config check, as recorded by synapse_operator_config_checks_total.
+const (
+	configCheckPassed   = "passed"
+	configCheckRejected = "rejected"
+	configCheckError    = "error"
+)
+
+// configCheckRequest is the JSON document posted to ConfigCheckURL: the config a rollout would apply.
+type configCheckRequest struct {
+	Namespace string `json:"namespace"`
+	Hash      string `json:"hash"`
+	// ConfigMaps and Secrets map each source name to its data. Secrets are only sent with ConfigCheckSecrets.
+	ConfigMaps map[string]map[string]string `json:"configMaps"`
+	Secrets    map[string]map[string]string `json:"secrets,omitempty"`
+}
+
+// configCheckResult is the outcome of the last config check of a namespace.
+type configCheckResult struct {
+	hash    string
+	passed  bool
+	message string
+}
+
+// configCheckGate posts the config of a new hash to ConfigCheckURL and holds the rollout unless it answers
+// with a 2xx status. A rejection holds the hash until the config changes; a check that could not be
+// completed is retried.
+func (r *ConfigMapReconciler) configCheckGate(ctx context.Context, pass *rolloutPass) error {
+	if value, ok := r.configChecks.Load(pass.Namespace); ok {
+		if last := value.(configCheckResult); last.hash == pass.Hash {
+			if last.passed {
+				return nil
+			}
+			r.holdForConfigCheck(ctx, pass, configCheckRejected, last.message)
+			return nil
+		}
+	}
+	behind, err := r.workloadsBehind(ctx, pass.Namespace, pass.Hash)
+	if err != nil || len(behind) == 0 {
+		return err
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: pass.Namespace}}
+	rejection, err := r.checkConfig(ctx, pass)
+	switch {
+	case err != nil:
+		configChecksTotal.WithLabelValues(r.ClusterName, pass.Namespace, configCheckError).Inc()
+		r.event(ns, corev1.EventTypeWarning, "ConfigCheckError", fmt.Sprintf("Config hash %s could not be checked, retrying: %v", pass.Hash, err))
+		r.holdForConfigCheck(ctx, pass, configCheckError, err.Error())
+		pass.RequeueAfter(configCheckRetryInterval)
+	case rejection != "":
+		configChecksTotal.WithLabelValues(r.ClusterName, pass.Namespace, configCheckRejected).Inc()
+		r.configChecks.Store(pass.Namespace, configCheckResult{hash: pass.Hash, message: rejection})
+		r.event(ns, corev1.EventTypeWarning, "ConfigCheckFailed", fmt.Sprintf("Config hash %s was rejected by the config check and is not rolled out: %s", pass.Hash, rejection))
+		r.holdForConfigCheck(ctx, pass, configCheckRejected, rejection)
+	default:
+		configChecksTotal.WithLabelValues(r.ClusterName, pass.Namespace, configCheckPassed).Inc()
+		r.configChecks.Store(pass.Namespace, configCheckResult{hash: pass.Hash, passed: true})
+	}
+	return nil
+}
+
+// holdForConfigCheck halts pass for a config check that did not pass with result.
+func (r *ConfigMapReconciler) holdForConfigCheck(ctx context.Context, pass *rolloutPass, result, detail string) {
+	pass.Logger.Info("Holding rollout that did not pass the config check", "configHash", pass.Hash, "result", result, "detail", detail)
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "config-check", Detail: result + ": " + detail})
+	pass.Halt("config check " + result)
+}
+
+// configCheckHold reports the rejection of hash by the config check of namespace, if any.
+func (r *ConfigMapReconciler) configCheckHold(namespace, hash string) string {
+	value, ok := r.configChecks.Load(namespace)
+	if !ok {
+		return ""
+	}
+	if last := value.(configCheckResult); last.hash == hash && !last.passed {
+		return "config-check: " + last.message
+	}
+	return ""
+}
+
+// checkConfig posts the config sources of pass to ConfigCheckURL. It returns the response of a non-2xx
+// answer as the rejection, and an error when no answer was received.
+func (r *ConfigMapReconciler) checkConfig(ctx context.Context, pass *rolloutPass) (string, error) {
+	body := configCheckRequest{Namespace: pass.Namespace, Hash: pass.Hash, ConfigMaps: map[string]map[string]string{}}
+	for _, cm := range pass.State.configMaps {
+		body.ConfigMaps[cm.Name] = cm.Data
+	}
+	if r.ConfigCheckSecrets {
+		secrets, err := r.passSecretData(ctx, pass)
+		if err != nil {
+			return "", err
+		}
+		body.Secrets = map[string]map[string]string{}
+		for _, secret := range secrets {
+			data := make(map[string]string, len(secret.Data))
+			for key, value := range secret.Data {
+				data[key] = string(value)
+			}
+			body.Secrets[secret.Name] = data
+		}
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	timeout := r.ConfigCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultConfigCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.ConfigCheckURL, bytes.NewReader(raw))
+	if err != nil {
+		return "", fmt.Errorf("building config check request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("posting to config check %s: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, maxConfigCheckMessage))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return "", nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return "", fmt.Errorf("config check %s answered %s", req.URL.Host, resp.Status)
+	}
+	if text := strings.TrimSpace(string(message)); text != "" {
+		return text, nil
+	}
+	return resp.Status, nil
+}
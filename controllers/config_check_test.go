@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestConfigCheckHoldsRejectedConfig(t *testing.T) {
+	ctx := context.Background()
+	var received []configCheckRequest
+	status := http.StatusUnprocessableEntity
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body configCheckRequest
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		received = append(received, body)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("listeners: port 8008 is used twice\n"))
+	}))
+	defer server.Close()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "listeners: []")
+	r := newTestReconciler(t, cm, newTestDeployment(nil))
+	r.ConfigCheckURL = server.URL
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+	assert.Equal(t, "matrix", received[0].Namespace)
+	assert.Equal(t, map[string]map[string]string{"homeserver": {"data": "listeners: []"}}, received[0].ConfigMaps)
+	assert.Nil(t, received[0].Secrets)
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Contains(t, <-recorder.Events, "ConfigCheckFailed")
+	assert.Equal(t, "config-check: listeners: port 8008 is used twice", r.configCheckHold("matrix", received[0].Hash))
+	assert.Equal(t, float64(1), testutil.ToFloat64(configChecksTotal.WithLabelValues("", "matrix", configCheckRejected)))
+
+	// The rejected hash is not posted again.
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Len(t, received, 1)
+
+	status = http.StatusOK
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cm), cm))
+	cm.Data["data"] = "listeners: [{port: 8008}]"
+	require.NoError(t, r.Update(ctx, cm))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Len(t, received, 2)
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Equal(t, received[1].Hash, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Empty(t, r.configCheckHold("matrix", received[1].Hash))
+}
+
+func TestConfigCheckRetriesUnavailableEndpoint(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	r := newTestReconciler(t, cm, newTestDeployment(nil))
+	r.ConfigCheckURL = server.URL
+
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	assert.Equal(t, configCheckRetryInterval, result.RequeueAfter)
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	_, ok := r.configChecks.Load("matrix")
+	assert.False(t, ok, "a failed check is not remembered")
+}
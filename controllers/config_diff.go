@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DefaultConfigDiffRedactPatterns match config lines whose values must never leave the cluster in a diff.
+var DefaultConfigDiffRedactPatterns = []string{
+	`(?i)(password|passwd|secret|token|private_key|signing_key|macaroon|registration_shared)`,
+}
+
+// ConfigDiffOptions controls how config changes are reported: a structured per-key summary of ConfigMap and
+// Secret changes in the logs, and a unified diff of ConfigMap changes attached to rollout notifications.
+// Secret values never leave the operator; only key names and value digests are reported.
+type ConfigDiffOptions struct {
+	// Enabled emits the redacted unified diff of ConfigMap changes.
+	Enabled bool
+	// Structured logs the per-key summary of ConfigMap and Secret changes.
+	Structured bool
+	// MaxBytes caps the rendered diff; longer diffs are truncated with a marker.
+	MaxBytes int
+	// RedactPatterns select lines whose value is replaced before the diff leaves the operator.
+	RedactPatterns []*regexp.Regexp
+}
+
+// configSnapshotCache remembers the last seen content of each ConfigMap so a diff can be produced on change.
+type configSnapshotCache struct {
+	mu    sync.Mutex
+	items map[types.NamespacedName]map[string]string
+}
+
+func newConfigSnapshotCache() *configSnapshotCache {
+	return &configSnapshotCache{items: map[types.NamespacedName]map[string]string{}}
+}
+
+// swap stores current for key and returns the previously stored content, if any.
+func (c *configSnapshotCache) swap(key types.NamespacedName, current map[string]string) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous, ok := c.items[key]
+	c.items[key] = current
+	return previous, ok
+}
+
+func (c *configSnapshotCache) forget(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+}
+
+// configMapSnapshot flattens a ConfigMap into comparable strings. Binary values are represented by their
+// size and digest since they are neither diffable nor safe to print.
+func configMapSnapshot(cfg *corev1.ConfigMap, keys keyFilter) map[string]string {
+	keys = keys.forSource(cfg)
+	snapshot := make(map[string]string, len(cfg.Data)+len(cfg.BinaryData))
+	for k, v := range cfg.Data {
+		if keys.skips(k) {
+			continue
+		}
+		snapshot[k] = v
+	}
+	for k, v := range cfg.BinaryData {
+		if keys.skips(k) {
+			continue
+		}
+		snapshot[k] = fmt.Sprintf("<binary %d bytes sha256:%s>\n", len(v), shortDigest(v))
+	}
+	return snapshot
+}
+
+// secretSnapshot flattens a Secret into value digests, so no secret value is ever kept or compared in
+// the clear.
+func secretSnapshot(secret *corev1.Secret, keys keyFilter) map[string]string {
+	keys = keys.forSource(secret)
+	snapshot := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		if keys.skips(k) {
+			continue
+		}
+		snapshot[k] = "sha256:" + shortDigest(v)
+	}
+	return snapshot
+}
+
+// configKeyChange summarises the change of one key in a structured log entry.
+type configKeyChange struct {
+	Key    string `json:"key"`
+	Change string `json:"change"`
+	// LinesAdded and LinesRemoved count changed lines of ConfigMap values.
+	LinesAdded   int `json:"linesAdded,omitempty"`
+	LinesRemoved int `json:"linesRemoved,omitempty"`
+	// DigestBefore and DigestAfter identify Secret values without revealing them.
+	DigestBefore string `json:"digestBefore,omitempty"`
+	DigestAfter  string `json:"digestAfter,omitempty"`
+}
+
+// structuredConfigDiff lists the keys added, removed, or modified between two snapshots, in key order.
+// Secret snapshots hold digests, which are reported instead of line counts.
+func structuredConfigDiff(previous, current map[string]string, secret bool) []configKeyChange {
+	keys := make(map[string]struct{}, len(previous)+len(current))
+	for k := range previous {
+		keys[k] = struct{}{}
+	}
+	for k := range current {
+		keys[k] = struct{}{}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []configKeyChange
+	for _, key := range sorted {
+		before, hadBefore := previous[key]
+		after, hasAfter := current[key]
+		change := configKeyChange{Key: key}
+		switch {
+		case hadBefore && hasAfter && before == after:
+			continue
+		case !hadBefore:
+			change.Change = "added"
+		case !hasAfter:
+			change.Change = "removed"
+		default:
+			change.Change = "modified"
+		}
+		if secret {
+			change.DigestBefore, change.DigestAfter = before, after
+		} else {
+			change.LinesAdded, change.LinesRemoved = changedLines(before, after)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// changedLines counts the lines inserted into and deleted from before to obtain after.
+func changedLines(before, after string) (int, int) {
+	a, b := splitConfigLines(before), splitConfigLines(after)
+	added, removed := 0, 0
+	for _, op := range difflib.NewMatcher(a, b).GetOpCodes() {
+		switch op.Tag {
+		case 'r':
+			removed += op.I2 - op.I1
+			added += op.J2 - op.J1
+		case 'd':
+			removed += op.I2 - op.I1
+		case 'i':
+			added += op.J2 - op.J1
+		}
+	}
+	return added, removed
+}
+
+func splitConfigLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// unifiedConfigDiff renders a redacted, size-capped unified diff between two ConfigMap snapshots.
+// It returns an empty string when nothing changed.
+func unifiedConfigDiff(name string, previous, current map[string]string, opts ConfigDiffOptions) (string, error) {
+	keys := make(map[string]struct{}, len(previous)+len(current))
+	for k := range previous {
+		keys[k] = struct{}{}
+	}
+	for k := range current {
+		keys[k] = struct{}{}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var out bytes.Buffer
+	for _, key := range sorted {
+		before, hadBefore := previous[key]
+		after, hasAfter := current[key]
+		if hadBefore && hasAfter && before == after {
+			continue
+		}
+		fromFile, toFile := "a/"+name+"/"+key, "b/"+name+"/"+key
+		if !hadBefore {
+			fromFile = "/dev/null"
+		}
+		if !hasAfter {
+			toFile = "/dev/null"
+		}
+		text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(redactConfigText(before, opts.RedactPatterns)),
+			B:        difflib.SplitLines(redactConfigText(after, opts.RedactPatterns)),
+			FromFile: fromFile,
+			ToFile:   toFile,
+			Context:  2,
+		})
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(text)
+	}
+
+	return truncateDiff(out.String(), opts.MaxBytes), nil
+}
+
+// redactConfigText masks the value part of every line matching one of the patterns, along with the more
+// indented lines that continue its value, such as a YAML block scalar. The key stays visible so reviewers
+// still see that a sensitive setting changed. PEM private keys are masked whole wherever they appear.
+func redactConfigText(text string, patterns []*regexp.Regexp) string {
+	if text == "" {
+		return text
+	}
+	lines := strings.SplitAfter(text, "\n")
+	// block is the indentation of the last matching line while the lines after it continue its value, or -1.
+	block := -1
+	inPrivateKey := false
+	for i, line := range lines {
+		content := strings.TrimSuffix(line, "\n")
+		newline := line[len(content):]
+		trimmed := strings.TrimSpace(content)
+		indent := len(content) - len(strings.TrimLeft(content, " \t"))
+		switch {
+		case inPrivateKey:
+			inPrivateKey = !isPrivateKeyMarker(trimmed, "-----END ")
+			lines[i] = ""
+			continue
+		case isPrivateKeyMarker(trimmed, "-----BEGIN "):
+			inPrivateKey = true
+			lines[i] = content[:indent] + "<redacted private key>" + newline
+			continue
+		case block >= 0 && (trimmed == "" || indent > block):
+			if trimmed != "" {
+				lines[i] = content[:indent] + "<redacted>" + newline
+			}
+			continue
+		}
+		block = -1
+		for _, pattern := range patterns {
+			loc := pattern.FindStringIndex(content)
+			if loc == nil {
+				continue
+			}
+			block = indent
+			if sep := strings.IndexAny(content[loc[1]:], ":="); sep >= 0 {
+				cut := loc[1] + sep + 1
+				lines[i] = content[:cut] + " <redacted>" + newline
+			} else {
+				lines[i] = "<redacted>" + newline
+			}
+			break
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// isPrivateKeyMarker reports whether line is the BEGIN or END line, as given by prefix, of a PEM private key.
+func isPrivateKeyMarker(line, prefix string) bool {
+	return strings.HasPrefix(line, prefix) && strings.HasSuffix(line, "PRIVATE KEY-----")
+}
+
+func truncateDiff(diff string, maxBytes int) string {
+	if maxBytes <= 0 || len(diff) <= maxBytes {
+		return diff
+	}
+	const marker = "\n... diff truncated ...\n"
+	cut := maxBytes - len(marker)
+	if cut < 0 {
+		cut = 0
+	}
+	return diff[:cut] + marker
+}
+
+// CompileRedactPatterns compiles the redaction regular expressions supplied on the command line.
+func CompileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
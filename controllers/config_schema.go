@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"synapse-operator/audit"
+)
+
+// ConfigSchemaAnnotation on a ConfigMap or Secret names a ConfigMap in its namespace holding a JSON Schema,
+// in JSON or YAML, for each of its keys to validate, under the same key.
+const ConfigSchemaAnnotation = "synapse.gen0sec.com/config-schema"
+
+// configSchemaRecheckInterval is how often a rollout held back by a schema failure is validated again, so
+// fixing the schema ConfigMap, which is not watched, releases it.
+const configSchemaRecheckInterval = time.Minute
+
+// schemaViolation is a config source key whose content does not pass its schema.
+type schemaViolation struct {
+	// source is the "<kind>/<name>" key of the config source.
+	source string
+	key    string
+	obj    client.Object
+	err    error
+}
+
+func (v schemaViolation) String() string {
+	if v.key == "" {
+		return fmt.Sprintf("%s: %v", v.source, v.err)
+	}
+	return fmt.Sprintf("%s[%s]: %v", v.source, v.key, v.err)
+}
+
+// configSchemaGate holds a rollout back while a config source of the namespace does not pass the schema
+// its ConfigSchemaAnnotation names, so a malformed config never reaches the workloads.
+func (r *ConfigMapReconciler) configSchemaGate(ctx context.Context, pass *rolloutPass) error {
+	var violations []schemaViolation
+	for i := range pass.State.configMaps {
+		cm := &pass.State.configMaps[i]
+		found, err := r.validateConfigSource(ctx, cm, "configmap/"+cm.Name, stringData(cm.Data))
+		if err != nil {
+			return err
+		}
+		violations = append(violations, found...)
+	}
+	secrets, err := r.passSecretData(ctx, pass)
+	if err != nil {
+		return err
+	}
+	for i := range secrets {
+		secret := &secrets[i]
+		found, err := r.validateConfigSource(ctx, secret, "secret/"+secret.Name, secret.Data)
+		if err != nil {
+			return err
+		}
+		violations = append(violations, found...)
+	}
+	r.reportSchemaViolations(pass.Namespace, violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	details := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		details = append(details, violation.String())
+	}
+	pass.Logger.Info("Holding rollout of config that fails its schema", "configHash", pass.Hash, "violations", details)
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "config-schema", Detail: strings.Join(details, "; ")})
+	pass.Halt("config schema validation failed")
+	pass.RequeueAfter(configSchemaRecheckInterval)
+	return nil
+}
+
+// validateConfigSource validates the keys of a config source against the schema ConfigMap its
+// ConfigSchemaAnnotation names. A missing schema ConfigMap fails every key rather than letting them through.
+func (r *ConfigMapReconciler) validateConfigSource(ctx context.Context, obj client.Object, source string, data map[string][]byte) ([]schemaViolation, error) {
+	name := obj.GetAnnotations()[ConfigSchemaAnnotation]
+	if name == "" {
+		return nil, nil
+	}
+	schemas := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: obj.GetNamespace(), Name: name}, schemas); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		return []schemaViolation{{source: source, obj: obj, err: fmt.Errorf("schema ConfigMap %q not found", name)}}, nil
+	}
+	var violations []schemaViolation
+	for _, key := range slices.Sorted(maps.Keys(schemas.Data)) {
+		content, ok := data[key]
+		if !ok {
+			continue
+		}
+		if err := validateAgainstSchema([]byte(schemas.Data[key]), content); err != nil {
+			violations = append(violations, schemaViolation{source: source, key: key, obj: obj, err: err})
+		}
+	}
+	return violations, nil
+}
+
+// validateAgainstSchema parses content as YAML and validates it against the JSON Schema in schema.
+func validateAgainstSchema(schema, content []byte) error {
+	schemaJSON, err := yaml.YAMLToJSON(schema)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	parsed := &spec.Schema{}
+	if err := json.Unmarshal(schemaJSON, parsed); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	var value interface{}
+	if err := yaml.Unmarshal(content, &value); err != nil {
+		return fmt.Errorf("not valid YAML: %w", err)
+	}
+	return validate.AgainstSchema(parsed, value, strfmt.Default)
+}
+
+// reportSchemaViolations publishes the config sources of namespace failing their schema and warns on each
+// once per distinct failure.
+func (r *ConfigMapReconciler) reportSchemaViolations(namespace string, violations []schemaViolation) {
+	configSchemaInvalidGauge.DeletePartialMatch(prometheus.Labels{"cluster": r.ClusterName, "namespace": namespace})
+	if len(violations) == 0 {
+		r.schemaViolations.Delete(namespace)
+		return
+	}
+	current := map[string]bool{}
+	for _, violation := range violations {
+		current[violation.String()] = true
+	}
+	previous, _ := r.schemaViolations.Swap(namespace, current)
+	reported, _ := previous.(map[string]bool)
+	for _, violation := range violations {
+		configSchemaInvalidGauge.WithLabelValues(r.ClusterName, namespace, violation.source).Set(1)
+		if reported[violation.String()] {
+			continue
+		}
+		message := fmt.Sprintf("Config fails its schema from ConfigMap %s and is not rolled out: %s",
+			violation.obj.GetAnnotations()[ConfigSchemaAnnotation], violation)
+		r.event(violation.obj, corev1.EventTypeWarning, "ConfigSchemaInvalid", message)
+	}
+}
+
+// schemaHolds lists the config sources of namespace whose schema failures hold its rollouts back.
+func (r *ConfigMapReconciler) schemaHolds(namespace string) []string {
+	value, ok := r.schemaViolations.Load(namespace)
+	if !ok {
+		return nil
+	}
+	var holds []string
+	for _, violation := range slices.Sorted(maps.Keys(value.(map[string]bool))) {
+		holds = append(holds, "config-schema: "+violation)
+	}
+	return holds
+}
+
+// stringData converts the data of a ConfigMap to the form of Secret data.
+func stringData(data map[string]string) map[string][]byte {
+	converted := make(map[string][]byte, len(data))
+	for key, value := range data {
+		converted[key] = []byte(value)
+	}
+	return converted
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const testConfigSchema = `
+type: object
+required: [server_name]
+properties:
+  server_name: {type: string}
+  federation_domain_whitelist: {type: array, items: {type: string}}
+`
+
+func TestConfigSchemaHoldsInvalidConfig(t *testing.T) {
+	ctx := context.Background()
+	schema := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "homeserver-schema", Namespace: "matrix"},
+		Data:       map[string]string{"data": testConfigSchema},
+	}
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"},
+		map[string]string{ConfigSchemaAnnotation: "homeserver-schema"}, "server_name: example.org\nfederation_domain_whitelist: matrix.org\n")
+	r := newTestReconciler(t, schema, cm, newTestDeployment(nil))
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, configSchemaRecheckInterval, result.RequeueAfter)
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Contains(t, <-recorder.Events, "ConfigSchemaInvalid")
+	assert.Equal(t, float64(1), testutil.ToFloat64(configSchemaInvalidGauge.WithLabelValues("", "matrix", "configmap/homeserver")))
+	holds := r.schemaHolds("matrix")
+	require.Len(t, holds, 1)
+	assert.Contains(t, holds[0], "config-schema: configmap/homeserver[data]: ")
+
+	// The same failure is not reported again.
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Empty(t, recorder.Events)
+
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cm), cm))
+	cm.Data["data"] = "server_name: example.org\nfederation_domain_whitelist: [matrix.org]\n"
+	require.NoError(t, r.Update(ctx, cm))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.NotEmpty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Empty(t, r.schemaHolds("matrix"))
+	assert.Zero(t, testutil.CollectAndCount(configSchemaInvalidGauge))
+}
+
+func TestConfigSchemaMissingFailsClosed(t *testing.T) {
+	ctx := context.Background()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"},
+		map[string]string{ConfigSchemaAnnotation: "missing"}, "server_name: example.org")
+	r := newTestReconciler(t, cm)
+
+	pass := &rolloutPass{Namespace: "matrix", Hash: "one"}
+	pass.State.configMaps = []corev1.ConfigMap{*cm}
+	require.NoError(t, r.configSchemaGate(ctx, pass))
+	_, reason := pass.Halted()
+	assert.Equal(t, "config schema validation failed", reason)
+	assert.Equal(t, []string{`config-schema: configmap/homeserver: schema ConfigMap "missing" not found`}, r.schemaHolds("matrix"))
+}
+
+func TestValidateAgainstSchema(t *testing.T) {
+	assert.NoError(t, validateAgainstSchema([]byte(testConfigSchema), []byte("server_name: example.org")))
+	assert.Error(t, validateAgainstSchema([]byte(testConfigSchema), []byte("report_stats: true")))
+	assert.ErrorContains(t, validateAgainstSchema([]byte(testConfigSchema), []byte("server_name: [")), "not valid YAML")
+	assert.ErrorContains(t, validateAgainstSchema([]byte(`{"type": 1}`), []byte("{}")), "invalid schema")
+}
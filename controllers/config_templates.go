@@ -0,0 +1,329 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigTemplateAnnotation on a ConfigMap makes it a config template rendered into the object the annotation
+// names as "configmap/<name>" or "secret/<name>". Keys ending in ConfigTemplateSuffix are rendered as Go
+// templates into the key without the suffix; the other keys are copied as they are.
+const ConfigTemplateAnnotation = "synapse.gen0sec.com/config-template"
+
+// ConfigTemplateValuesAnnotation on a config template lists the sources its templates read, as
+// comma-separated "configmap/<name>" or "secret/<name>" references in the template's namespace.
+const ConfigTemplateValuesAnnotation = "synapse.gen0sec.com/config-values"
+
+// ConfigTemplateSuffix marks the keys of a config template that are rendered.
+const ConfigTemplateSuffix = ".tmpl"
+
+// ConfigValuesKey is the key of a values source whose YAML is merged into .Values, in the order the sources
+// are listed.
+const ConfigValuesKey = "values.yaml"
+
+// configTemplateData is what a config template is rendered with.
+type configTemplateData struct {
+	// Values merges the ConfigValuesKey of every values source.
+	Values map[string]any
+	// ConfigMaps and Secrets hold every key of the values sources, by source name.
+	ConfigMaps map[string]map[string]string
+	Secrets    map[string]map[string]string
+}
+
+// parseSourceRef parses a "configmap/<name>" or "secret/<name>" reference into its lowercase kind and name.
+func parseSourceRef(ref string) (string, string, error) {
+	kind, name, ok := strings.Cut(strings.TrimSpace(ref), "/")
+	kind = strings.ToLower(kind)
+	if !ok || name == "" || (kind != "configmap" && kind != "secret") {
+		return "", "", fmt.Errorf("%q is not configmap/<name> or secret/<name>", ref)
+	}
+	return kind, name, nil
+}
+
+// configTemplateFuncs are the functions available to config templates besides the text/template builtins.
+var configTemplateFuncs = template.FuncMap{
+	"toYaml": func(value any) (string, error) {
+		out, err := yaml.Marshal(value)
+		return strings.TrimSuffix(string(out), "\n"), err
+	},
+	"indent": func(spaces int, s string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+	},
+	"quote": func(value any) string { return fmt.Sprintf("%q", fmt.Sprint(value)) },
+	"default": func(fallback, value any) any {
+		if value == nil || value == "" {
+			return fallback
+		}
+		return value
+	},
+	"required": func(message string, value any) (any, error) {
+		if value == nil || value == "" {
+			return nil, fmt.Errorf("%s", message)
+		}
+		return value, nil
+	},
+	"b64enc": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+}
+
+// renderConfigTemplate renders the keys of tmpl with data.
+func renderConfigTemplate(tmpl *corev1.ConfigMap, data configTemplateData) (map[string]string, error) {
+	rendered := make(map[string]string, len(tmpl.Data))
+	for _, key := range slices.Sorted(maps.Keys(tmpl.Data)) {
+		name, isTemplate := strings.CutSuffix(key, ConfigTemplateSuffix)
+		if !isTemplate {
+			rendered[key] = tmpl.Data[key]
+			continue
+		}
+		parsed, err := template.New(key).Option("missingkey=error").Funcs(configTemplateFuncs).Parse(tmpl.Data[key])
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := parsed.Execute(&out, data); err != nil {
+			return nil, err
+		}
+		rendered[name] = out.String()
+	}
+	return rendered, nil
+}
+
+// mergeValues merges overlay into base, recursing into maps present in both.
+func mergeValues(base, overlay map[string]any) {
+	for key, value := range overlay {
+		if nested, ok := value.(map[string]any); ok {
+			if existing, ok := base[key].(map[string]any); ok {
+				mergeValues(existing, nested)
+				continue
+			}
+		}
+		base[key] = value
+	}
+}
+
+// ConfigTemplateReconciler renders every ConfigMap annotated with ConfigTemplateAnnotation, with the values
+// sources it lists, into the ConfigMap or Secret the annotation names. The rendered object carries the source
+// selector labels, so a change to the template or its values is hashed and rolled out like any other config
+// change; it is owned by the template, so deleting the template removes it. A template that fails to render
+// leaves the last rendered object in place. Requests are keyed by template.
+type ConfigTemplateReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	// Labels is applied to the rendered objects and must satisfy the operator's source selector.
+	Labels map[string]string
+}
+
+func (r *ConfigTemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	tmpl := &corev1.ConfigMap{}
+	if err := r.Get(ctx, req.NamespacedName, tmpl); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	output := tmpl.Annotations[ConfigTemplateAnnotation]
+	if output == "" {
+		return ctrl.Result{}, nil
+	}
+	kind, name, err := parseSourceRef(output)
+	if err == nil && name == tmpl.Name && kind == "configmap" {
+		err = fmt.Errorf("the template cannot render into itself")
+	}
+	if err != nil {
+		r.event(tmpl, corev1.EventTypeWarning, "ConfigTemplateFailed", fmt.Sprintf("%s: %v", ConfigTemplateAnnotation, err))
+		return ctrl.Result{}, nil
+	}
+	data, readsSecrets, err := r.templateData(ctx, tmpl)
+	if err != nil {
+		var status apierrors.APIStatus
+		if errors.As(err, &status) && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		r.event(tmpl, corev1.EventTypeWarning, "ConfigTemplateFailed", fmt.Sprintf("Keeping the last rendered %s: %v", output, err))
+		return ctrl.Result{}, nil
+	}
+	if readsSecrets && kind == "configmap" {
+		r.event(tmpl, corev1.EventTypeWarning, "ConfigTemplateFailed", fmt.Sprintf("Not rendering Secret values into %s; render into secret/%s instead", output, name))
+		return ctrl.Result{}, nil
+	}
+	rendered, err := renderConfigTemplate(tmpl, data)
+	if err != nil {
+		r.event(tmpl, corev1.EventTypeWarning, "ConfigTemplateFailed", fmt.Sprintf("Keeping the last rendered %s: %v", output, err))
+		return ctrl.Result{}, nil
+	}
+
+	var obj client.Object
+	if kind == "secret" {
+		obj = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: tmpl.Namespace}}
+	} else {
+		obj = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: tmpl.Namespace}}
+	}
+	err = r.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	exists := err == nil
+	if exists && obj.GetAnnotations()[ManagedByAnnotation] != managedByValue {
+		r.event(tmpl, corev1.EventTypeWarning, "ConfigTemplateFailed", fmt.Sprintf("%s is not managed by the operator; remove it or render into another object", output))
+		return ctrl.Result{}, nil
+	}
+	changed := setRenderedData(obj, rendered)
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for key, value := range r.Labels {
+		if labels[key] != value {
+			labels[key] = value
+			changed = true
+		}
+	}
+	obj.SetLabels(labels)
+	if !exists {
+		obj.SetAnnotations(map[string]string{ManagedByAnnotation: managedByValue})
+		if err := controllerutil.SetControllerReference(tmpl, obj, r.Scheme()); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Create(ctx, obj); err != nil {
+			return ctrl.Result{}, fmt.Errorf("creating %s: %w", output, err)
+		}
+	} else if !changed {
+		return ctrl.Result{}, nil
+	} else if err := r.Update(ctx, obj); err != nil {
+		return ctrl.Result{}, fmt.Errorf("updating %s: %w", output, err)
+	}
+	log.FromContext(ctx).Info("Rendered config template", "template", tmpl.Name, "output", output)
+	r.event(tmpl, corev1.EventTypeNormal, "ConfigTemplateRendered", fmt.Sprintf("Rendered %d key(s) into %s", len(rendered), output))
+	return ctrl.Result{}, nil
+}
+
+// setRenderedData sets rendered as the data of the ConfigMap or Secret obj and reports whether it changed.
+func setRenderedData(obj client.Object, rendered map[string]string) bool {
+	switch obj := obj.(type) {
+	case *corev1.Secret:
+		data := make(map[string][]byte, len(rendered))
+		for key, value := range rendered {
+			data[key] = []byte(value)
+		}
+		if maps.EqualFunc(obj.Data, data, bytes.Equal) {
+			return false
+		}
+		obj.Data = data
+	case *corev1.ConfigMap:
+		if maps.Equal(obj.Data, rendered) {
+			return false
+		}
+		obj.Data = rendered
+	}
+	return true
+}
+
+// templateData reads the values sources of tmpl, reporting whether any of them is a Secret.
+func (r *ConfigTemplateReconciler) templateData(ctx context.Context, tmpl *corev1.ConfigMap) (configTemplateData, bool, error) {
+	data := configTemplateData{Values: map[string]any{}, ConfigMaps: map[string]map[string]string{}, Secrets: map[string]map[string]string{}}
+	readsSecrets := false
+	for _, ref := range configTemplateValues(tmpl) {
+		kind, name, err := parseSourceRef(ref)
+		if err != nil {
+			return data, false, fmt.Errorf("%s: %w", ConfigTemplateValuesAnnotation, err)
+		}
+		values := map[string]string{}
+		if kind == "secret" {
+			readsSecrets = true
+			secret := &corev1.Secret{}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: tmpl.Namespace, Name: name}, secret); err != nil {
+				return data, false, err
+			}
+			for key, value := range secret.Data {
+				values[key] = string(value)
+			}
+			data.Secrets[name] = values
+		} else {
+			cm := &corev1.ConfigMap{}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: tmpl.Namespace, Name: name}, cm); err != nil {
+				return data, false, err
+			}
+			maps.Copy(values, cm.Data)
+			data.ConfigMaps[name] = values
+		}
+		if raw, ok := values[ConfigValuesKey]; ok {
+			var parsed map[string]any
+			if err := yaml.Unmarshal([]byte(raw), &parsed); err != nil {
+				return data, false, fmt.Errorf("%s of %s: %w", ConfigValuesKey, ref, err)
+			}
+			mergeValues(data.Values, parsed)
+		}
+	}
+	return data, readsSecrets, nil
+}
+
+// configTemplateValues returns the values source references of tmpl.
+func configTemplateValues(tmpl *corev1.ConfigMap) []string {
+	var refs []string
+	for _, ref := range strings.Split(tmpl.Annotations[ConfigTemplateValuesAnnotation], ",") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+func (r *ConfigTemplateReconciler) event(obj client.Object, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(obj, eventType, reason, message)
+}
+
+// SetupWithManager renders a template whenever it, one of its values sources, or its rendered object changes.
+func (r *ConfigTemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	templatesOf := func(kind string) handler.EventHandler {
+		return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+			templates := &corev1.ConfigMapList{}
+			if err := r.List(ctx, templates, client.InNamespace(obj.GetNamespace())); err != nil {
+				log.FromContext(ctx).Error(err, "failed to list config templates")
+				return nil
+			}
+			var requests []reconcile.Request
+			for i := range templates.Items {
+				tmpl := &templates.Items[i]
+				if _, ok := tmpl.Annotations[ConfigTemplateAnnotation]; !ok {
+					continue
+				}
+				if kind == "configmap" && tmpl.Name == obj.GetName() {
+					requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(tmpl)})
+					continue
+				}
+				refs := append(configTemplateValues(tmpl), tmpl.Annotations[ConfigTemplateAnnotation])
+				for _, ref := range refs {
+					if refKind, name, err := parseSourceRef(ref); err == nil && refKind == kind && name == obj.GetName() {
+						requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(tmpl)})
+						break
+					}
+				}
+			}
+			return requests
+		})
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("config-templates").
+		Watches(&corev1.ConfigMap{}, templatesOf("configmap")).
+		Watches(&corev1.Secret{}, templatesOf("secret")).
+		Complete(r)
+}
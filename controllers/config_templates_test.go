@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRenderConfigTemplate(t *testing.T) {
+	tmpl := &corev1.ConfigMap{Data: map[string]string{
+		"homeserver.yaml.tmpl": "server_name: {{ .Values.serverName }}\nlisteners:\n{{ toYaml .Values.listeners | indent 2 }}\n",
+		"log.config":           "version: 1\n",
+	}}
+	values := map[string]any{}
+	mergeValues(values, map[string]any{"serverName": "example.com", "listeners": map[string]any{"port": 8008}})
+	mergeValues(values, map[string]any{"listeners": map[string]any{"tls": false}})
+
+	rendered, err := renderConfigTemplate(tmpl, configTemplateData{Values: values})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"homeserver.yaml": "server_name: example.com\nlisteners:\n  port: 8008\n  tls: false\n",
+		"log.config":      "version: 1\n",
+	}, rendered)
+
+	tmpl.Data["homeserver.yaml.tmpl"] = "server_name: {{ .Values.missing }}"
+	_, err = renderConfigTemplate(tmpl, configTemplateData{Values: values})
+	assert.Error(t, err, "a missing key fails instead of rendering <no value>")
+}
+
+func TestConfigTemplateReconciler(t *testing.T) {
+	ctx := context.Background()
+	tmpl := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "synapse-template", Namespace: "matrix", UID: "template-uid", Annotations: map[string]string{
+			ConfigTemplateAnnotation:       "secret/synapse-config",
+			ConfigTemplateValuesAnnotation: "configmap/synapse-values, secret/credentials",
+		}},
+		Data: map[string]string{"homeserver.yaml.tmpl": `server_name: {{ .Values.serverName }}
+password: {{ index .Secrets "credentials" "db-password" | quote }}
+`},
+	}
+	values := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "synapse-values", Namespace: "matrix"},
+		Data:       map[string]string{ConfigValuesKey: "serverName: example.com\n"},
+	}
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "matrix"},
+		Data:       map[string][]byte{"db-password": []byte("hunter2")},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &ConfigTemplateReconciler{
+		Client:   newTestReconciler(t, tmpl, values, credentials).Client,
+		Recorder: recorder,
+		Labels:   map[string]string{"app.kubernetes.io/name": "synapse"},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(tmpl)}
+	rendered := func() *corev1.Secret {
+		var secret corev1.Secret
+		require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse-config"}, &secret))
+		return &secret
+	}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	secret := rendered()
+	assert.Equal(t, "server_name: example.com\npassword: \"hunter2\"\n", string(secret.Data["homeserver.yaml"]))
+	assert.Equal(t, map[string]string{"app.kubernetes.io/name": "synapse"}, secret.Labels)
+	assert.Equal(t, managedByValue, secret.Annotations[ManagedByAnnotation])
+	require.Len(t, secret.OwnerReferences, 1)
+	assert.Equal(t, "synapse-template", secret.OwnerReferences[0].Name)
+	assert.Equal(t, "Normal ConfigTemplateRendered Rendered 1 key(s) into secret/synapse-config", <-recorder.Events)
+
+	values.Data[ConfigValuesKey] = "serverName: matrix.example.com\n"
+	require.NoError(t, r.Update(ctx, values))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "server_name: matrix.example.com\npassword: \"hunter2\"\n", string(rendered().Data["homeserver.yaml"]))
+	<-recorder.Events
+
+	require.NoError(t, r.Delete(ctx, credentials))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Contains(t, string(rendered().Data["homeserver.yaml"]), "matrix.example.com", "a failed render keeps the last output")
+	assert.Contains(t, <-recorder.Events, "Warning ConfigTemplateFailed Keeping the last rendered secret/synapse-config")
+
+	tmpl.Annotations[ConfigTemplateAnnotation] = "configmap/synapse-config"
+	tmpl.Annotations[ConfigTemplateValuesAnnotation] = "secret/synapse-config"
+	require.NoError(t, r.Update(ctx, tmpl))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "Warning ConfigTemplateFailed Not rendering Secret values into configmap/synapse-config; render into secret/synapse-config instead", <-recorder.Events)
+}
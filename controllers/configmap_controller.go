@@ -3,98 +3,495 @@ package controllers
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"hash"
+	"slices"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
-	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
-	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"synapse-operator/audit"
+	"synapse-operator/notify"
+	"synapse-operator/pipeline"
+	"synapse-operator/state"
+	"synapse-operator/tracing"
 )
 
+// ConfigHashVersion is the version of the canonical encoding config hashes are computed from. Combined
+// hashes carry it in ConfigHashPrefix, so tooling can tell hashes of different encodings apart.
+const ConfigHashVersion = "v2"
+
+// ConfigHashPrefix starts every combined config hash.
+const ConfigHashPrefix = ConfigHashVersion + ":sha256:"
+
 // ConfigMapReconciler watches Synapse config ConfigMaps/Secrets and forces a rollout on the workload when the config changes.
 type ConfigMapReconciler struct {
 	client.Client
-	Scheme               *runtime.Scheme
-	LabelSelector        labels.Selector
+	Scheme        *runtime.Scheme
+	LabelSelector labels.Selector
+	// SourceLabelSelector and WorkloadLabelSelector, when set, select the config sources and the workloads in
+	// place of LabelSelector, for clusters where different tooling labels them.
+	SourceLabelSelector   labels.Selector
+	WorkloadLabelSelector labels.Selector
+	// AnnotationSelector, when set, also selects the ConfigMaps and Secrets whose annotations match it,
+	// whatever their labels. Workloads are still selected by their label selector only.
+	AnnotationSelector   labels.Selector
 	ConfigHashAnnotation string
+	// AcceptLegacyHashes leaves a workload whose applied hash is the legacyHashVersion hash of its current
+	// config as it is, so upgrading from an operator that wrote such hashes restarts nothing. The workload
+	// takes a ConfigHashPrefix hash with its next config change.
+	AcceptLegacyHashes   bool
 	IgnoredConfigMapKeys map[string]struct{}
 	IgnoredSecretKeys    map[string]struct{}
+	// IncludedConfigMapKeys and IncludedSecretKeys, when not empty, are the only keys of matching sources
+	// that contribute to the hash, unless a source overrides them with IncludeKeysAnnotation.
+	IncludedConfigMapKeys map[string]struct{}
+	IncludedSecretKeys    map[string]struct{}
+	// ClusterName names the remote cluster the reconciler rolls out in, registered with ClusterRegistry; it is
+	// empty for the operator's own cluster. It labels the per-cluster metrics.
+	ClusterName string
+	// StateStore keeps state that must survive across reconciles (and, depending on the backend, restarts).
+	StateStore state.Store
+	// HashStore keeps the lastKnownHash of every namespace apart from StateStore, so it can be read on its
+	// own; StateStore keeps them without one.
+	HashStore  state.Store
+	Recorder   record.EventRecorder
+	ConfigDiff ConfigDiffOptions
+	// APIReader reads kinds the manager does not cache, such as Pods.
+	APIReader client.Reader
+	// RestartStrategy is the default restart strategy, overridable per workload via RestartStrategyAnnotation.
+	RestartStrategy string
+	// ConformanceMode refuses the versioned strategy on workloads unless it is the default: only then does the
+	// conformance allow-list grant the writes of its copies.
+	ConformanceMode bool
+	// RestartedAtAnnotation is the pod template annotation stamped by the restarted-at strategy.
+	RestartedAtAnnotation string
+	// ZoneTopologyKey is the node label placing pods in zones. When set, the evict strategy restarts
+	// StatefulSets zone by zone, with at most one pod restarting in each zone at a time.
+	ZoneTopologyKey string
+	// WatchSecretProviderClasses folds secrets-store CSI SecretProviderClasses and their rotated object
+	// versions into the combined hash.
+	WatchSecretProviderClasses bool
+	// WatchExternalSecrets folds the spec and synced version of the External Secrets Operator ExternalSecrets
+	// targeting config Secrets into the combined hash.
+	WatchExternalSecrets bool
+	// WatchCertificates folds the issuance of the cert-manager Certificates matching the source selector into
+	// the combined hash.
+	WatchCertificates bool
+	// Vault, when set, folds the versions of the Vault secrets listed in VaultPathsAnnotation into the combined
+	// hash, read again every VaultPollInterval.
+	Vault             VaultReader
+	VaultPollInterval time.Duration
+	// VersionedCopiesRetention is the number of immutable copies of each source the versioned restart strategy
+	// keeps, besides those still referenced by a workload. Zero keeps every copy.
+	VersionedCopiesRetention int
+	// ImmutableConfigSources treats the immutable ConfigMaps of a series, such as synapse-config-<rev>, as
+	// revisions of one source of which only the latest is hashed. PruneSupersededConfigSources deletes the
+	// revisions of each series beyond the ConfigRevisionsRetention newest once nothing references them.
+	ImmutableConfigSources       bool
+	PruneSupersededConfigSources bool
+	ConfigRevisionsRetention     int
+	// SOPS, when set, hashes SOPS-encrypted values in their canonical form, so re-encrypting them does not
+	// roll the workloads.
+	SOPS ValueCanonicalizer
+	// CacheReader reads unstructured kinds from the informer cache populated by the optional watches.
+	CacheReader client.Reader
+	// ReportImpact logs and records an event with the estimated impact before each workload restart.
+	ReportImpact bool
+	// RolloutHistorySize is how many recent rollouts are kept in the rollout history annotation of each
+	// workload; zero disables the history.
+	RolloutHistorySize int
+	// SourceRules classify config sources into policies; the first matching rule wins.
+	SourceRules []SourceRule
+	// ManageCronJobs also rolls the hash out to the job template of matching CronJobs, which applies from
+	// their next run; CronJobs always use the annotation restart strategy.
+	ManageCronJobs bool
+	// RestartInFlightJobs, with ManageCronJobs, restarts the running Jobs of a CronJob that were created
+	// before a hash change by suspending and resuming them.
+	RestartInFlightJobs bool
+	// MaxConcurrentReconciles is the number of namespaces reconciled in parallel. Requests of one namespace
+	// are never processed concurrently, and namespaces are served round-robin.
+	MaxConcurrentReconciles int
+	// NamespaceQPS and NamespaceBurst, when NamespaceQPS is positive, rate-limit the retries of each namespace
+	// separately instead of with one bucket shared by all namespaces.
+	NamespaceQPS   float64
+	NamespaceBurst int
+	// CleanupReleasedWorkloads also removes the operator's annotations from the metadata of workloads that
+	// stop being targeted, not just ManagedByAnnotation.
+	CleanupReleasedWorkloads bool
+	// CleanupReleasedPodTemplates also removes the config hash from the pod template of released workloads,
+	// which restarts them once, so no stale hash is left behind.
+	CleanupReleasedPodTemplates bool
+	// HelmCoalesceWindow, when positive, leaves a Helm-managed workload alone for a config change that its
+	// Helm release wrote within this window of changing the workload itself.
+	HelmCoalesceWindow time.Duration
+	// MaxSourcesPerNamespace, when positive, caps the ConfigMaps and Secrets hashed per namespace; the ones
+	// beyond it, by kind and name, are left out of the hash and reported.
+	MaxSourcesPerNamespace int
+	// RecordConfigSources records ConfigSourcesAnnotation on every workload the operator restarts.
+	RecordConfigSources bool
+	// AnnotateRestartCause records RestartCauseAnnotation and RestartCauseTimeAnnotation on the pod template of
+	// every workload restarted for a changed source, so the pods it creates carry them.
+	AnnotateRestartCause bool
+	// MinRestartInterval, when positive, holds a workload's restart until this long after its previous one;
+	// MinRestartIntervalAnnotation overrides it per workload.
+	MinRestartInterval time.Duration
+	// DeferRestartsOnPDB holds the restart of an available workload while a PodDisruptionBudget matching its
+	// pods allows no disruption.
+	DeferRestartsOnPDB bool
+	// ConfigCheckURL, when set, receives the config of every new hash as a POST before it rolls out; the
+	// rollout is held unless it answers with a 2xx status.
+	ConfigCheckURL string
+	// ConfigCheckTimeout bounds each config check request; 0 means 30 seconds.
+	ConfigCheckTimeout time.Duration
+	// ConfigCheckSecrets also sends the data of the namespace's Secrets to ConfigCheckURL.
+	ConfigCheckSecrets bool
+	// ValidationJobImage, when set, runs a Job of this image with the config sources of every new hash
+	// mounted, and holds the rollout until it succeeds.
+	ValidationJobImage string
+	// ValidationJobCommand is the command of the validation Job; empty runs the image's entrypoint.
+	ValidationJobCommand []string
+	// ValidationJobServiceAccount runs the validation Job as this service account of its namespace.
+	ValidationJobServiceAccount string
+	// ValidationJobTimeout bounds each validation Job; 0 means 5 minutes.
+	ValidationJobTimeout time.Duration
+	// GitOpsCompanionAnnotations are added to the metadata of every managed workload alongside
+	// ManagedByAnnotation, telling GitOps tools not to treat the operator's writes as drift.
+	GitOpsCompanionAnnotations map[string]string
+	// DetectByImage, when set, targets workloads running an image matching this glob instead of those
+	// matching LabelSelector, and takes their config sources from what they mount or read env from.
+	DetectByImage string
+	// GradualRolloutWindow spreads the restarts of a namespace evenly over this duration; zero restarts all
+	// workloads at once. Overridable per namespace with GradualRolloutWindowAnnotation.
+	GradualRolloutWindow time.Duration
+	// MaxRollingNamespaces, when positive, caps how many namespaces restart workloads at once, so a change to a
+	// source shared by many namespaces rolls out a few namespaces at a time; the others wait in line.
+	MaxRollingNamespaces int
+	// RolloutTiers orders the tiers namespaces are labeled with by RolloutTierLabel, such as dev, staging and
+	// prod: a namespace rolls out only once every namespace of the earlier tiers has rolled out and soaked for
+	// RolloutTierSoak. Empty disables tiers.
+	RolloutTiers    []string
+	RolloutTierSoak time.Duration
+	// RolloutHistoryRetention, when positive, records every triggered rollout with a snapshot of its
+	// ConfigMap sources in the namespace's SynapseRolloutHistory, keeping this many records.
+	RolloutHistoryRetention int
+	// CanaryManualApproval holds healthy canaries of the canary strategy until CanaryApprovedAnnotation
+	// approves their hash.
+	CanaryManualApproval bool
+	// AllowRecreateRestarts lets hash changes restart Deployments using the Recreate strategy without the
+	// per-Deployment AllowRecreateRestartsAnnotation confirmation.
+	AllowRecreateRestarts bool
+	// RolloutProgressTimeout, when positive, is how long a restarted workload may take to become ready
+	// before it is reported as stalled.
+	RolloutProgressTimeout time.Duration
+	// AutoRollback, with RolloutProgressTimeout and RolloutHistoryRetention, rolls the namespace's config
+	// sources back to the previous rollout when a restarted workload stalls.
+	AutoRollback bool
+	// Notifier, when set, is told about every rollout triggered or failed.
+	Notifier *notify.Dispatcher
+	// RequireApproval holds every new hash until the namespace's ApprovedConfigHashAnnotation approves it,
+	// except in namespaces annotated with ApprovalRequiredAnnotation "false".
+	RequireApproval bool
+	// InjectConfigHash serves a mutating webhook at HashInjectionPath that records the current hash on
+	// targeted workloads as they are created, so they are not rolled a second time right after creation.
+	InjectConfigHash bool
+	// GroupByOwner hashes the config sources of each controller, such as a release of a Helm operator,
+	// separately, and rolls each hash out only to the workloads with the same controller. Sources without a
+	// controller feed every group.
+	GroupByOwner bool
+	// GroupByComponent groups config sources and workloads by their ComponentLabel instead, so a change to
+	// the config of one component, such as a bridge, restarts only that component's workloads. Sources
+	// without a component feed every group.
+	GroupByComponent bool
+	// CanaryNamespaces replays every config change of a namespace with CanaryNamespaceAnnotation into the
+	// named canary namespace and holds its rollout until the canary namespace has passed.
+	CanaryNamespaces bool
+	// StatusAPIBindAddress, when set, serves the read-only status API on this address from every replica.
+	StatusAPIBindAddress string
+	// Gates are compiled-in Decide plugins, run after the built-in gates; see pipeline.RegisterGate.
+	Gates []pipeline.Gate
+	// Verifiers are compiled-in Verify plugins, run after the built-in follow-ups of every pass that
+	// reached Apply; see pipeline.RegisterVerifier.
+	Verifiers []pipeline.Verifier
+	// RolloutLock holds rollouts in a namespace while its RolloutLockName Lease is held.
+	RolloutLock bool
+	// StartupSettleDelay holds back every rollout for this long after the operator starts, so the burst of
+	// events replayed when it comes up together with the applications settles into one rollout per namespace.
+	StartupSettleDelay time.Duration
+	// SuppressStartupRollouts keeps the first pass of each namespace after the operator starts from restarting
+	// workloads unless their sources changed while it was down, judged by its lastKnownHash; without one, that
+	// pass restarts nothing. The workloads it passes over take the next hash.
+	SuppressStartupRollouts bool
+	// DriftRepair, DriftRepairRestore or DriftRepairRestart, repairs the config hash of managed workloads
+	// when another controller removes or alters it while the config is unchanged.
+	DriftRepair string
+	// ServerSideApply writes the config hash of the annotation restart strategy with a server-side apply as
+	// FieldManager instead of a merge patch, so the operator's ownership of the annotation is recorded and
+	// conflicts with other managers are reported instead of silently overwritten.
+	ServerSideApply bool
+	// ForceServerSideApply, with ServerSideApply, takes the annotation over from other field managers.
+	ForceServerSideApply bool
+	// PatchRetryAttempts is how many times in all a workload write failing with a conflict or a transient
+	// API error is tried, each retry on a freshly read workload; below 2 the error is returned at once.
+	PatchRetryAttempts int
+	// PatchRetryBackoff is the wait before the first retry of a workload write, doubled for every further
+	// retry and jittered by up to half.
+	PatchRetryBackoff time.Duration
+	// SyncRoutes moves the Ingresses and HTTPRoutes listed in RoutesAnnotation of a workload to the listeners
+	// of its new config before restarting it.
+	SyncRoutes bool
+	// ExcludedNamespaces are never rolled out, even when their workloads match the selector, so a
+	// cluster-wide install cannot restart the workloads of protected namespaces.
+	ExcludedNamespaces map[string]struct{}
+	// Audit, when set, keeps a record of every notable rollout decision for `synapse-operator explain`.
+	Audit *audit.Log
+	// IgnoreSecrets leaves Secrets out of the config hash, for when no SecretReconciler watches them.
+	// Otherwise Secrets are hashed, but only a SecretReconciler set up with the manager reacts to their changes.
+	IgnoreSecrets bool
+	// SecretMetadataOnly tells that the manager's cache holds Secrets stripped by StripSecretData; the data of
+	// the selected Secrets is read from the API server whenever they are listed for hashing.
+	SecretMetadataOnly bool
+	// LeastPrivilege probes at setup which workload kinds the operator may list, watch and patch, and leaves
+	// the others out instead of failing every reconcile on them; a patch denied later disables its kind too.
+	LeastPrivilege bool
+	// WatchedNamespace is the only namespace the manager's cache holds, if any; LeastPrivilege probes the
+	// access to workloads there.
+	WatchedNamespace string
+
+	snapshots       *configSnapshotCache
+	secretSnapshots *configSnapshotCache
+	debouncer       *sourceDebouncer
+	remotes         *remoteSourceIndex
+	// secrets is the SecretReconciler handing Secret changes to this reconciler, if any.
+	secrets *SecretReconciler
+	// namespaceLocks holds the *sync.Mutex serializing the reconciles of each namespace.
+	namespaceLocks sync.Map
+	// indexes are the cache indexes shared with the hash injection webhook.
+	indexes *sharedIndexes
+	// pendingHashes holds the hash exposed as pending for each paused namespace.
+	pendingHashes sync.Map
+	// approvalHashes holds the hash awaiting approval in each namespace that requires approval.
+	approvalHashes sync.Map
+	// schemaViolations maps each namespace to the set of schema failures last reported in it.
+	schemaViolations sync.Map
+	// configChecks holds the configCheckResult of the last hash checked in each namespace.
+	configChecks sync.Map
+	// validationJobs holds the validationResult of the last validation Job that finished in each namespace.
+	validationJobs sync.Map
+	// sourceKeyDigests maps each namespace, or "<namespace>/<group UID>" with grouping, to the per-key
+	// digests of its config sources at the last reconcile.
+	sourceKeyDigests sync.Map
+	// hashCache maps each namespace, or "<namespace>/<group UID>" with grouping, to the *hashCacheEntry of
+	// its last hashed sources. The entries of a Namespace are dropped when it is deleted.
+	hashCache sync.Map
+	// deniedKinds maps the name of each workload kind disabled by LeastPrivilege to the verb denied.
+	deniedKinds sync.Map
+	// canaryPassed holds the last hash of each namespace that passed in its canary namespace.
+	canaryPassed sync.Map
+	// settledAt is the end of the startup settle delay, fixed on the first reconcile.
+	settledAt  time.Time
+	settleOnce sync.Once
+	// startupAdoptions maps each namespace, or "<namespace>/<group UID>" with grouping, to its *startupAdoption.
+	startupAdoptions sync.Map
+	// lastKnownHashes holds the lastKnownHash last stored for each scope of startupAdoptions.
+	lastKnownHashes sync.Map
+	// progress maps "<namespace>/<kind>/<name>" to the *rolloutProgress of a restarted workload.
+	progress sync.Map
+	// budget hands out the slots of MaxRollingNamespaces.
+	budget rolloutBudget
+	// tierRollouts maps "tiers/<namespace>" to its tierRollout when there is no StateStore.
+	tierRollouts sync.Map
+	// driftedHashes maps "<namespace>/<kind>/<name>" to the hash a workload ran before its drift was observed.
+	driftedHashes sync.Map
+	// blockedHashes maps "<namespace>/<kind>/<name>/<reason>" to the hash held back from that workload.
+	blockedHashes sync.Map
 }
 
 // Reconcile reacts to ConfigMap/Secret updates by updating the pod template annotation on Synapse workloads.
 func (r *ConfigMapReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	logger := log.FromContext(ctx).WithValues("resource", req.NamespacedName)
+	if r.namespaceExcluded(req.Namespace) {
+		log.FromContext(ctx).V(1).Info("Ignoring config change in excluded namespace", "namespace", req.Namespace)
+		return ctrl.Result{}, nil
+	}
+	defer r.lockNamespace(req.Namespace)()
+	ctx, triggerID := withTriggerID(ctx)
+	ctx, span := tracing.Start(ctx, "Reconcile",
+		tracing.NamespaceKey.String(req.Namespace), tracing.SourceNameKey.String(req.Name), tracing.TriggerIDKey.String(triggerID))
+	result, err := r.reconcileAudited(ctx, req, triggerID)
+	tracing.End(span, err)
+	if r.ClusterName != "" {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+		clusterReconcilesTotal.WithLabelValues(r.ClusterName, outcome).Inc()
+	}
+	return result, err
+}
 
-	var cfg corev1.ConfigMap
-	if err := r.Get(ctx, req.NamespacedName, &cfg); err == nil {
-		logger = logger.WithValues("kind", "ConfigMap")
-	} else if !apierrors.IsNotFound(err) {
-		return ctrl.Result{}, err
-	} else {
-		var secret corev1.Secret
-		if err := r.Get(ctx, req.NamespacedName, &secret); err == nil {
-			logger = logger.WithValues("kind", "Secret")
-		} else if !apierrors.IsNotFound(err) {
-			return ctrl.Result{}, err
+// reconcileAudited runs the reconcile of req, recording it for audit and notifications when configured.
+func (r *ConfigMapReconciler) reconcileAudited(ctx context.Context, req ctrl.Request, triggerID string) (ctrl.Result, error) {
+	if r.Audit == nil && r.Notifier == nil {
+		return r.reconcile(ctx, req)
+	}
+	rec := audit.NewRecord(req.Namespace, req.Name, time.Now())
+	rec.TriggerID = triggerID
+	result, err := r.reconcile(audit.WithRecord(ctx, rec), req)
+	if rec.Result == "" {
+		rec.Finish("", err)
+	}
+	r.notify(rec)
+	if r.Audit != nil && rec.Notable() {
+		if writeErr := r.Audit.Write(ctx, rec); writeErr != nil {
+			log.FromContext(ctx).Error(writeErr, "failed to write audit record", "transaction", rec.ID)
 		}
 	}
+	return result, err
+}
 
-	hash, err := r.computeCombinedHash(ctx, req.Namespace)
-	if err != nil {
-		return ctrl.Result{}, err
+func (r *ConfigMapReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("resource", req.NamespacedName, "triggerID", triggerIDFrom(ctx))
+	if rec := audit.FromContext(ctx); rec != nil {
+		logger = logger.WithValues("transaction", rec.ID)
 	}
-	if hash == "" {
-		logger.Info("No config sources found, skipping rollout")
-		return ctrl.Result{}, nil
+	if !r.grouped() {
+		return r.reconcileGroup(ctx, req, logger)
 	}
-
-	if err := r.patchDeployments(ctx, req.Namespace, hash, logger); err != nil {
+	groups, err := r.triggeredGroups(ctx, req)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
-	if err := r.patchDaemonSets(ctx, req.Namespace, hash, logger); err != nil {
-		return ctrl.Result{}, err
+	var result ctrl.Result
+	for _, group := range groups {
+		groupLogger := logger
+		if group.ref != "" {
+			groupLogger = logger.WithValues("owner", group.ref)
+		}
+		groupResult, err := r.reconcileGroup(withOwnerGroup(ctx, group), req, groupLogger)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if wait := groupResult.RequeueAfter; wait > 0 && (result.RequeueAfter == 0 || wait < result.RequeueAfter) {
+			result.RequeueAfter = wait
+		}
 	}
-	if err := r.patchStatefulSets(ctx, req.Namespace, hash, logger); err != nil {
+	return result, nil
+}
+
+// reconcileGroup runs the reconcile pipeline for the namespace of req, or the owner group ctx is scoped to.
+func (r *ConfigMapReconciler) reconcileGroup(ctx context.Context, req ctrl.Request, logger logr.Logger) (ctrl.Result, error) {
+	pass := &rolloutPass{Namespace: req.Namespace, Logger: logger, State: rolloutState{trigger: req.NamespacedName}}
+	if err := r.reconcilePipeline().Run(ctx, pass); err != nil {
 		return ctrl.Result{}, err
 	}
-
-	return ctrl.Result{}, nil
+	r.reportPendingHash(ctx, pass)
+	return ctrl.Result{RequeueAfter: pass.Requeue()}, nil
 }
 
-// SetupWithManager configures the controller to watch ConfigMaps/Secrets that match the selector.
+// SetupWithManager configures the controller to watch ConfigMaps that match the selector. Secrets are
+// watched by a SecretReconciler.
 func (r *ConfigMapReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	selector := r.selector()
+	if (r.ConfigDiff.Enabled || r.ConfigDiff.Structured) && r.snapshots == nil {
+		r.snapshots = newConfigSnapshotCache()
+	}
+	if r.ConfigDiff.Structured && r.secretSnapshots == nil {
+		r.secretSnapshots = newConfigSnapshotCache()
+	}
+	if r.remotes == nil {
+		r.remotes = newRemoteSourceIndex()
+	}
+	if r.debouncer == nil {
+		r.debouncer = newSourceDebouncer()
+	}
+	if r.LeastPrivilege {
+		if err := r.probeWorkloadAccess(context.Background(), mgr.GetClient()); err != nil {
+			return err
+		}
+	}
+	indexes, err := sharedIndexesFor(context.Background(), mgr.GetCache(), mgr.GetFieldIndexer(), r.workloadKinds())
+	if err != nil {
+		return err
+	}
+	r.indexes = indexes
+	if err := r.setupDeletionHandlers(context.Background(), mgr.GetCache()); err != nil {
+		return err
+	}
+	selector := r.sourceSelector()
 	matchesSelector := predicate.NewPredicateFuncs(func(obj client.Object) bool {
 		if obj == nil {
 			return false
 		}
 		return selector.Matches(labels.Set(obj.GetLabels()))
 	})
+	isConfigSource := r.configSourcePredicate()
+
+	if err := r.setupWorkloadRelease(mgr); err != nil {
+		return err
+	}
+	if r.InjectConfigHash {
+		r.setupHashInjection(mgr)
+	}
+	if r.DriftRepair != "" && r.DriftRepair != DriftRepairOff {
+		if err := r.setupDriftRepair(mgr); err != nil {
+			return err
+		}
+	}
+	if err := r.setupRestartNow(mgr); err != nil {
+		return err
+	}
+	if r.StatusAPIBindAddress != "" {
+		if err := r.setupStatusAPI(mgr); err != nil {
+			return err
+		}
+	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(
 			&corev1.ConfigMap{},
-			builder.WithPredicates(matchesSelector),
+			builder.WithPredicates(isConfigSource, r.sourceChangePredicate()),
 		).
 		Watches(
-			&corev1.Secret{},
-			&handler.EnqueueRequestForObject{},
-			builder.WithPredicates(matchesSelector),
+			&corev1.Namespace{},
+			r.enqueueForNamespace(),
+			builder.WithPredicates(predicate.AnnotationChangedPredicate{}),
 		).
-		WithOptions(controller.Options{
-			MaxConcurrentReconciles: 1,
-		}).
-		Complete(r)
+		Watches(&corev1.ConfigMap{}, r.enqueueRemoteReferrers(remoteKindConfigMap), builder.WithPredicates(r.sourceChangePredicate()))
+	if r.WatchSecretProviderClasses {
+		b = r.watchSecretProviderClasses(b, matchesSelector)
+	}
+	if r.WatchExternalSecrets {
+		b = r.watchExternalSecrets(b)
+	}
+	if r.WatchCertificates {
+		b = r.watchCertificates(b, matchesSelector)
+	}
+
+	options := controller.Options{
+		MaxConcurrentReconciles: max(r.MaxConcurrentReconciles, 1),
+		NewQueue: func(name string, rateLimiter workqueue.TypedRateLimiter[reconcile.Request]) workqueue.TypedRateLimitingInterface[reconcile.Request] {
+			return newFairQueue(name, rateLimiter, r.namespacePriority)
+		},
+	}
+	if r.NamespaceQPS > 0 {
+		options.RateLimiter = newNamespaceRateLimiter(r.NamespaceQPS, max(r.NamespaceBurst, 1))
+	}
+	return b.WithOptions(options).Complete(r)
 }
 
 func (r *ConfigMapReconciler) selector() labels.Selector {
@@ -104,183 +501,226 @@ func (r *ConfigMapReconciler) selector() labels.Selector {
 	return r.LabelSelector
 }
 
-func (r *ConfigMapReconciler) computeCombinedHash(ctx context.Context, namespace string) (string, error) {
-	configMaps := &corev1.ConfigMapList{}
-	if err := r.List(
-		ctx,
-		configMaps,
-		client.InNamespace(namespace),
-		client.MatchingLabelsSelector{Selector: r.selector()},
-	); err != nil {
-		return "", err
+// sourceSelector returns the label selector of config sources.
+func (r *ConfigMapReconciler) sourceSelector() labels.Selector {
+	if r.SourceLabelSelector != nil {
+		return r.SourceLabelSelector
 	}
+	return r.selector()
+}
 
-	secrets := &corev1.SecretList{}
-	if err := r.List(
-		ctx,
-		secrets,
-		client.InNamespace(namespace),
-		client.MatchingLabelsSelector{Selector: r.selector()},
-	); err != nil {
-		return "", err
+// workloadSelector returns the label selector of workloads.
+func (r *ConfigMapReconciler) workloadSelector() labels.Selector {
+	if r.WorkloadLabelSelector != nil {
+		return r.WorkloadLabelSelector
 	}
-
-	return hashConfigSources(configMaps.Items, secrets.Items, r.IgnoredConfigMapKeys, r.IgnoredSecretKeys), nil
+	return r.selector()
 }
 
-func (r *ConfigMapReconciler) patchDeployments(ctx context.Context, namespace, hash string, logger logr.Logger) error {
-	deployments := &appsv1.DeploymentList{}
-	if err := r.List(
-		ctx,
-		deployments,
-		client.InNamespace(namespace),
-		client.MatchingLabelsSelector{Selector: r.selector()},
-	); err != nil {
+// reportConfigDiff logs a structured summary of the change between the cached and current content of cfg
+// and emits its redacted unified diff as an event, adding it to the audit record and so to the notification
+// of the rollout. The first observation of a ConfigMap only seeds the cache.
+func (r *ConfigMapReconciler) reportConfigDiff(ctx context.Context, cfg *corev1.ConfigMap, logger logr.Logger) error {
+	if r.snapshots == nil {
+		return nil
+	}
+	current := configMapSnapshot(cfg, r.configMapKeyFilter())
+	previous, seen := r.snapshots.swap(types.NamespacedName{Namespace: cfg.Namespace, Name: cfg.Name}, current)
+	if !seen {
+		return nil
+	}
+	if r.ConfigDiff.Structured {
+		if changes := structuredConfigDiff(previous, current, false); len(changes) > 0 {
+			logger.Info("Config source changed", "changes", changes)
+		}
+	}
+	if !r.ConfigDiff.Enabled {
+		return nil
+	}
+	diff, err := unifiedConfigDiff(cfg.Name, previous, current, r.ConfigDiff)
+	if err != nil || diff == "" {
 		return err
 	}
+	logger.Info("ConfigMap content changed", "diff", diff)
+	r.event(cfg, corev1.EventTypeNormal, "ConfigChanged", diff)
+	audit.FromContext(ctx).SetDiff(diff)
+	return nil
+}
 
-	for i := range deployments.Items {
-		deploy := &deployments.Items[i]
-		itemLogger := logger.WithValues("deployment", deploy.Name)
-		updated, err := patchDeploymentHash(ctx, r.Client, deploy, r.ConfigHashAnnotation, hash)
-		if err != nil {
-			itemLogger.Error(err, "failed to update deployment with new config hash")
-			return err
-		}
-		if updated {
-			itemLogger.Info("Updated deployment pod template annotation to trigger restart", "configHash", hash)
-		} else {
-			itemLogger.V(1).Info("Deployment already up to date with config hash")
-		}
+// reportSecretDiff logs which keys of secret changed, identified by value digests. Secrets are never
+// diffed by value.
+func (r *ConfigMapReconciler) reportSecretDiff(secret *corev1.Secret, logger logr.Logger) {
+	if r.secretSnapshots == nil || !r.ConfigDiff.Structured {
+		return
+	}
+	current := secretSnapshot(secret, r.secretKeyFilter())
+	previous, seen := r.secretSnapshots.swap(types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}, current)
+	if !seen {
+		return
 	}
+	if changes := structuredConfigDiff(previous, current, true); len(changes) > 0 {
+		logger.Info("Config source changed", "changes", changes)
+	}
+}
 
-	return nil
+func (r *ConfigMapReconciler) forgetConfigSnapshot(key types.NamespacedName) {
+	if r.snapshots != nil {
+		r.snapshots.forget(key)
+	}
 }
 
-func (r *ConfigMapReconciler) patchDaemonSets(ctx context.Context, namespace, hash string, logger logr.Logger) error {
-	daemonSets := &appsv1.DaemonSetList{}
-	if err := r.List(
-		ctx,
-		daemonSets,
-		client.InNamespace(namespace),
-		client.MatchingLabelsSelector{Selector: r.selector()},
-	); err != nil {
-		return err
+func (r *ConfigMapReconciler) forgetSecretSnapshot(key types.NamespacedName) {
+	if r.secretSnapshots != nil {
+		r.secretSnapshots.forget(key)
 	}
+}
 
-	for i := range daemonSets.Items {
-		daemonSet := &daemonSets.Items[i]
-		itemLogger := logger.WithValues("daemonset", daemonSet.Name)
-		updated, err := patchDaemonSetHash(ctx, r.Client, daemonSet, r.ConfigHashAnnotation, hash)
-		if err != nil {
-			itemLogger.Error(err, "failed to update daemonset with new config hash")
-			return err
-		}
-		if updated {
-			itemLogger.Info("Updated daemonset pod template annotation to trigger restart", "configHash", hash)
-		} else {
-			itemLogger.V(1).Info("DaemonSet already up to date with config hash")
-		}
+func (r *ConfigMapReconciler) event(obj runtime.Object, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
 	}
+	r.Recorder.Event(obj, eventType, reason, message)
+}
 
-	return nil
+// computeCombinedHash hashes every config source in namespace according to its source policy. While a
+// debounced change is held back it also returns how long until it settles.
+func (r *ConfigMapReconciler) computeCombinedHash(ctx context.Context, namespace string) (string, time.Duration, error) {
+	configMaps, secrets, err := r.listConfigSources(ctx, namespace)
+	if err != nil {
+		return "", 0, err
+	}
+	return r.combineSources(ctx, namespace, configMaps, secrets)
 }
 
-func (r *ConfigMapReconciler) patchStatefulSets(ctx context.Context, namespace, hash string, logger logr.Logger) error {
-	statefulSets := &appsv1.StatefulSetList{}
-	if err := r.List(
-		ctx,
-		statefulSets,
-		client.InNamespace(namespace),
-		client.MatchingLabelsSelector{Selector: r.selector()},
-	); err != nil {
-		return err
+// combineSources hashes the listed config sources of namespace, together with its remote and external
+// sources, into the combined hash. Only the first MaxSourcesPerNamespace ConfigMaps and Secrets count.
+func (r *ConfigMapReconciler) combineSources(ctx context.Context, namespace string, configMaps []corev1.ConfigMap, secrets []corev1.Secret) (string, time.Duration, error) {
+	configMaps, secrets, dropped := r.capSources(configMaps, secrets)
+	now := time.Now()
+	remote, remoteSettleAfter, err := r.remoteSourceDigests(ctx, namespace, configMaps, secrets, now)
+	if err != nil {
+		return "", 0, err
+	}
+	// classifySources filters in place; the caller's slices stay intact for later stages.
+	configMapItems, secretItems, debounced := r.classifySources(ctx, "", slices.Clone(configMaps), slices.Clone(secrets))
+	digests, err := r.localSourceDigests(ctx, namespace, configMapItems, secretItems)
+	if err != nil {
+		return "", 0, err
+	}
+	digests, settleAfter := r.debounceSources(ctx, namespace, digests, debounced, now)
+	if remoteSettleAfter > 0 && (settleAfter == 0 || remoteSettleAfter < settleAfter) {
+		settleAfter = remoteSettleAfter
+	}
+	external, err := r.externalSourceDigests(ctx, namespace, configMaps, secrets)
+	if err != nil {
+		return "", 0, err
+	}
+	digests = append(append(digests, remote...), external...)
+	rec := audit.FromContext(ctx)
+	for _, digest := range digests {
+		rec.Source(digest.key, func(source *audit.Source) { source.Digest = digest.hash })
 	}
+	recordHashedSources(ctx, digests, dropped)
+	return combineSourceDigests(digests), settleAfter, nil
+}
 
-	for i := range statefulSets.Items {
-		statefulSet := &statefulSets.Items[i]
-		itemLogger := logger.WithValues("statefulset", statefulSet.Name)
-		updated, err := patchStatefulSetHash(ctx, r.Client, statefulSet, r.ConfigHashAnnotation, hash)
+// externalSourceDigests collects digests of optional, non-ConfigMap/Secret sources that feed the combined hash.
+func (r *ConfigMapReconciler) externalSourceDigests(ctx context.Context, namespace string, configMaps []corev1.ConfigMap, secrets []corev1.Secret) ([]sourceDigest, error) {
+	var digests []sourceDigest
+	if r.WatchSecretProviderClasses {
+		spc, err := r.secretProviderClassDigests(ctx, namespace)
 		if err != nil {
-			itemLogger.Error(err, "failed to update statefulset with new config hash")
-			return err
+			return nil, err
 		}
-		if updated {
-			itemLogger.Info("Updated statefulset pod template annotation to trigger restart", "configHash", hash)
-		} else {
-			itemLogger.V(1).Info("StatefulSet already up to date with config hash")
+		digests = append(digests, spc...)
+	}
+	if r.WatchExternalSecrets {
+		externalSecrets, err := r.externalSecretDigests(ctx, namespace, secrets)
+		if err != nil {
+			return nil, err
 		}
+		digests = append(digests, externalSecrets...)
 	}
-
-	return nil
-}
-
-func patchDeploymentHash(ctx context.Context, c client.Client, deploy *appsv1.Deployment, annotationKey, hash string) (bool, error) {
-	original := deploy.DeepCopy()
-	if deploy.Spec.Template.Annotations == nil {
-		deploy.Spec.Template.Annotations = map[string]string{}
+	if r.WatchCertificates {
+		certificates, err := r.certificateDigests(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, certificates...)
 	}
-	if existing := deploy.Spec.Template.Annotations[annotationKey]; existing == hash {
-		return false, nil
+	vaultDigests, err := r.vaultDigests(ctx, configMaps, secrets)
+	if err != nil {
+		return nil, err
 	}
-	deploy.Spec.Template.Annotations[annotationKey] = hash
-	return true, c.Patch(ctx, deploy, client.MergeFrom(original))
+	return append(digests, vaultDigests...), nil
 }
 
-func patchDaemonSetHash(ctx context.Context, c client.Client, daemonSet *appsv1.DaemonSet, annotationKey, hash string) (bool, error) {
-	original := daemonSet.DeepCopy()
-	if daemonSet.Spec.Template.Annotations == nil {
-		daemonSet.Spec.Template.Annotations = map[string]string{}
-	}
-	if existing := daemonSet.Spec.Template.Annotations[annotationKey]; existing == hash {
-		return false, nil
+// rolloutWorkloads applies hash to every targeted workload with its restart strategy.
+func (r *ConfigMapReconciler) rolloutWorkloads(ctx context.Context, namespace, hash string, logger logr.Logger) (ctrl.Result, error) {
+	pass := &rolloutPass{Namespace: namespace, Logger: logger, Hash: hash}
+	if err := r.rolloutPipeline().Run(ctx, pass); err != nil {
+		return ctrl.Result{}, err
 	}
-	daemonSet.Spec.Template.Annotations[annotationKey] = hash
-	return true, c.Patch(ctx, daemonSet, client.MergeFrom(original))
+	return ctrl.Result{RequeueAfter: pass.Requeue()}, nil
 }
 
-func patchStatefulSetHash(ctx context.Context, c client.Client, statefulSet *appsv1.StatefulSet, annotationKey, hash string) (bool, error) {
-	original := statefulSet.DeepCopy()
-	if statefulSet.Spec.Template.Annotations == nil {
-		statefulSet.Spec.Template.Annotations = map[string]string{}
-	}
-	if existing := statefulSet.Spec.Template.Annotations[annotationKey]; existing == hash {
-		return false, nil
-	}
-	statefulSet.Spec.Template.Annotations[annotationKey] = hash
-	return true, c.Patch(ctx, statefulSet, client.MergeFrom(original))
+// plannedRestart is a workload with its resolved restart strategy.
+type plannedRestart struct {
+	w            *workload
+	strategyName string
+	strategy     restartStrategy
+	appliedHash  string
 }
 
-func hashConfigSources(configMaps []corev1.ConfigMap, secrets []corev1.Secret, ignoredConfigMapKeys, ignoredSecretKeys map[string]struct{}) string {
-	type hashEntry struct {
-		key  string
-		hash string
+// reader returns the uncached reader when configured, for lookups of kinds the operator does not cache.
+func (r *ConfigMapReconciler) reader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
 	}
+	return r.Client
+}
 
-	entries := make([]hashEntry, 0, len(configMaps)+len(secrets))
+// sourceDigest is the content hash of one config source, keyed by "<kind>/<name>".
+type sourceDigest struct {
+	key  string
+	hash string
+}
+
+func hashConfigSources(configMaps []corev1.ConfigMap, secrets []corev1.Secret, configMapKeys, secretKeys keyFilter) string {
+	return combineSourceDigests(configSourceDigests(nil, configMaps, secrets, configMapKeys, secretKeys))
+}
+
+// configSourceDigests returns the digests of the config sources with content, taken from index when it has
+// them at their current resourceVersion.
+func configSourceDigests(index *sourceDigestIndex, configMaps []corev1.ConfigMap, secrets []corev1.Secret, configMapKeys, secretKeys keyFilter) []sourceDigest {
+	entries := make([]sourceDigest, 0, len(configMaps)+len(secrets))
 	for i := range configMaps {
 		cfg := &configMaps[i]
-		hash := hashConfigMapContent(cfg, ignoredConfigMapKeys)
+		hash := index.digest("configmap", cfg, func() string { return hashConfigMapContent(cfg, configMapKeys) })
 		if hash == "" {
 			continue
 		}
-		entries = append(entries, hashEntry{
+		entries = append(entries, sourceDigest{
 			key:  "configmap/" + cfg.Name,
 			hash: hash,
 		})
 	}
 	for i := range secrets {
 		secret := &secrets[i]
-		hash := hashSecretContent(secret, ignoredSecretKeys)
+		hash := index.digest("secret", secret, func() string { return hashSecretContent(secret, secretKeys) })
 		if hash == "" {
 			continue
 		}
-		entries = append(entries, hashEntry{
+		entries = append(entries, sourceDigest{
 			key:  "secret/" + secret.Name,
 			hash: hash,
 		})
 	}
+	return entries
+}
 
+// combineSourceDigests folds the per-source digests into the combined hash, independent of their order.
+func combineSourceDigests(entries []sourceDigest) string {
 	if len(entries) == 0 {
 		return ""
 	}
@@ -289,95 +729,95 @@ func hashConfigSources(configMaps []corev1.ConfigMap, secrets []corev1.Secret, i
 		return entries[i].key < entries[j].key
 	})
 
-	hasher := sha256.New()
+	h := canonicalHasher{sha256.New()}
 	for _, entry := range entries {
-		hasher.Write([]byte(entry.key))
-		hasher.Write([]byte{0})
-		hasher.Write([]byte(entry.hash))
-		hasher.Write([]byte{0})
+		h.field([]byte(entry.key))
+		h.field([]byte(entry.hash))
 	}
-
-	return hex.EncodeToString(hasher.Sum(nil))
+	return ConfigHashPrefix + hex.EncodeToString(h.Sum(nil))
 }
 
-func hashConfigMapContent(cfg *corev1.ConfigMap, ignoredKeys map[string]struct{}) string {
+func hashConfigMapContent(cfg *corev1.ConfigMap, keys keyFilter) string {
 	if len(cfg.Data) == 0 && len(cfg.BinaryData) == 0 {
 		return ""
 	}
+	keys = keys.forSource(cfg)
 
-	keys := make([]string, 0, len(cfg.Data)+len(cfg.BinaryData))
-	for k := range cfg.Data {
-		if shouldIgnoreKey(k, ignoredKeys) {
+	entries := make([]contentEntry, 0, len(cfg.Data)+len(cfg.BinaryData))
+	for k, v := range cfg.Data {
+		if keys.skips(k) {
 			continue
 		}
-		keys = append(keys, "s:"+k)
+		entries = append(entries, contentEntry{section: "data", key: k, value: keys.value([]byte(v))})
 	}
-	for k := range cfg.BinaryData {
-		if shouldIgnoreKey(k, ignoredKeys) {
+	for k, v := range cfg.BinaryData {
+		if keys.skips(k) {
 			continue
 		}
-		keys = append(keys, "b:"+k)
-	}
-	if len(keys) == 0 {
-		return ""
-	}
-	sort.Strings(keys)
-
-	hasher := sha256.New()
-	for _, k := range keys {
-		switch {
-		case len(k) > 2 && k[0:2] == "s:":
-			key := k[2:]
-			hasher.Write([]byte("s"))
-			hasher.Write([]byte(key))
-			hasher.Write([]byte{0})
-			hasher.Write([]byte(cfg.Data[key]))
-		case len(k) > 2 && k[0:2] == "b:":
-			key := k[2:]
-			hasher.Write([]byte("b"))
-			hasher.Write([]byte(key))
-			hasher.Write([]byte{0})
-			hasher.Write(cfg.BinaryData[key])
-		}
-		hasher.Write([]byte{0})
+		entries = append(entries, contentEntry{section: "binaryData", key: k, value: keys.value(v)})
 	}
-	return hex.EncodeToString(hasher.Sum(nil))
+	return hashContentEntries(entries)
 }
 
-func hashSecretContent(secret *corev1.Secret, ignoredKeys map[string]struct{}) string {
+func hashSecretContent(secret *corev1.Secret, keys keyFilter) string {
 	if len(secret.Data) == 0 {
 		return ""
 	}
+	keys = keys.forSource(secret)
 
-	keys := make([]string, 0, len(secret.Data))
-	for k := range secret.Data {
-		if shouldIgnoreKey(k, ignoredKeys) {
+	entries := make([]contentEntry, 0, len(secret.Data))
+	for k, v := range secret.Data {
+		if keys.skips(k) {
 			continue
 		}
-		keys = append(keys, "d:"+k)
+		entries = append(entries, contentEntry{section: "data", key: k, value: keys.value(v)})
 	}
-	if len(keys) == 0 {
+	return hashContentEntries(entries)
+}
+
+// contentEntry is one key of a config source with the field it is stored in.
+type contentEntry struct {
+	section string
+	key     string
+	value   []byte
+}
+
+// hashContentEntries hashes the canonical encoding of entries: sorted by section and key, each field
+// length-prefixed, so no choice of keys or values can make two different sources encode alike.
+func hashContentEntries(entries []contentEntry) string {
+	if len(entries) == 0 {
 		return ""
 	}
-	sort.Strings(keys)
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].section != entries[j].section {
+			return entries[i].section < entries[j].section
+		}
+		return entries[i].key < entries[j].key
+	})
 
-	hasher := sha256.New()
-	for _, k := range keys {
-		key := k[2:]
-		hasher.Write([]byte("d"))
-		hasher.Write([]byte(key))
-		hasher.Write([]byte{0})
-		hasher.Write(secret.Data[key])
-		hasher.Write([]byte{0})
+	h := canonicalHasher{sha256.New()}
+	for _, entry := range entries {
+		h.field([]byte(entry.section))
+		h.field([]byte(entry.key))
+		h.field(entry.value)
 	}
+	return hex.EncodeToString(h.Sum(nil))
+}
 
-	return hex.EncodeToString(hasher.Sum(nil))
+// canonicalHasher writes length-prefixed fields, the encoding of ConfigHashVersion.
+type canonicalHasher struct {
+	hash.Hash
 }
 
-func shouldIgnoreKey(key string, ignoredKeys map[string]struct{}) bool {
-	if len(ignoredKeys) == 0 {
-		return false
-	}
-	_, ok := ignoredKeys[key]
-	return ok
+func (h canonicalHasher) field(value []byte) {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(value)))
+	h.Write(length[:])
+	h.Write(value)
+}
+
+// shortDigest returns an abbreviated sha256 of value, suitable for logs and events.
+func shortDigest(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])[:12]
 }
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"synapse-operator/audit"
+	"synapse-operator/state"
+)
+
+func TestReconcileWritesAuditRecord(t *testing.T) {
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	r.Audit = &audit.Log{Store: store, Retention: 10}
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	// The second pass finds everything up to date and is not recorded.
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+
+	entries, err := store.List(ctx, "audit/")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	for _, raw := range entries {
+		var rec audit.Record
+		require.NoError(t, json.Unmarshal(raw, &rec))
+		assert.Equal(t, "configmap/homeserver", rec.Trigger)
+		assert.Equal(t, audit.ResultRolledOut, rec.Result)
+		require.Len(t, rec.Actions, 1)
+		assert.Equal(t, "deployment/synapse", rec.Actions[0].Workload)
+		require.Len(t, rec.Sources, 1)
+		assert.Equal(t, SourceClassAppConfig, rec.Sources[0].Class)
+	}
+}
+
+func TestCombinedHashCarriesEncodingVersion(t *testing.T) {
+	hash := hashConfigSources([]corev1.ConfigMap{*newTestConfigMap("homeserver", nil, nil, "a")}, nil, keyFilter{}, keyFilter{})
+	assert.True(t, strings.HasPrefix(hash, "v2:sha256:"), hash)
+	assert.Len(t, strings.TrimPrefix(hash, ConfigHashPrefix), 64)
+}
+
+func TestHashConfigMapContentSeparatesDataAndBinaryData(t *testing.T) {
+	data := &corev1.ConfigMap{Data: map[string]string{"key": "value"}}
+	binary := &corev1.ConfigMap{BinaryData: map[string][]byte{"key": []byte("value")}}
+	assert.NotEqual(t, hashConfigMapContent(data, keyFilter{}), hashConfigMapContent(binary, keyFilter{}))
+
+	both := &corev1.ConfigMap{Data: map[string]string{"key": "a"}, BinaryData: map[string][]byte{"key": []byte("b")}}
+	swapped := &corev1.ConfigMap{Data: map[string]string{"key": "b"}, BinaryData: map[string][]byte{"key": []byte("a")}}
+	assert.NotEqual(t, hashConfigMapContent(both, keyFilter{}), hashConfigMapContent(swapped, keyFilter{}))
+}
+
+func TestHashConfigMapContentResistsSeparatorsInKeys(t *testing.T) {
+	// With separator-joined fields both would encode as "data\x00a\x00b\x00c".
+	a := &corev1.ConfigMap{Data: map[string]string{"a": "b\x00c"}}
+	b := &corev1.ConfigMap{Data: map[string]string{"a\x00b": "c"}}
+	assert.NotEqual(t, hashConfigMapContent(a, keyFilter{}), hashConfigMapContent(b, keyFilter{}))
+}
+
+func FuzzHashConfigMapContent(f *testing.F) {
+	f.Add("a", "b\x00c", "a\x00b", "c", false)
+	f.Add("key", "value", "key", "value", true)
+	f.Add("", "", "", "", false)
+	f.Fuzz(func(t *testing.T, key1, value1, key2, value2 string, binary bool) {
+		first := &corev1.ConfigMap{Data: map[string]string{key1: value1}}
+		second := &corev1.ConfigMap{Data: map[string]string{key2: value2}}
+		if binary {
+			second = &corev1.ConfigMap{BinaryData: map[string][]byte{key2: []byte(value2)}}
+		}
+		same := !binary && key1 == key2 && value1 == value2
+		assert.Equal(t, same, hashConfigMapContent(first, keyFilter{}) == hashConfigMapContent(second, keyFilter{}),
+			"data[%q]=%q vs %q[%q]=%q", key1, value1, map[bool]string{false: "data", true: "binaryData"}[binary], key2, value2)
+	})
+}
+
+func FuzzCombineSourceDigests(f *testing.F) {
+	f.Add("configmap/a", "b\x00c", "configmap/a\x00b", "c")
+	f.Add("secret/x", "1", "secret/x", "1")
+	f.Fuzz(func(t *testing.T, key1, hash1, key2, hash2 string) {
+		first := combineSourceDigests([]sourceDigest{{key: key1, hash: hash1}})
+		second := combineSourceDigests([]sourceDigest{{key: key2, hash: hash2}})
+		assert.Equal(t, key1 == key2 && hash1 == hash2, first == second)
+	})
+}
+
+func TestSeparateSourceAndWorkloadSelectors(t *testing.T) {
+	ctx := context.Background()
+	sourceLabels := map[string]string{"config.example.com/synapse": "true"}
+	selected := newTestConfigMap("homeserver", sourceLabels, nil, "a")
+	unselected := newTestConfigMap("workload-labelled", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "b")
+	r := newTestReconciler(t, newTestDeployment(nil), selected, unselected)
+	r.SourceLabelSelector = labels.SelectorFromSet(sourceLabels)
+
+	assert.True(t, r.selectsConfigSource(selected))
+	assert.False(t, r.selectsConfigSource(unselected))
+	configMaps, _, err := r.listConfigSources(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, configMaps, 1)
+	assert.Equal(t, "homeserver", configMaps[0].Name)
+
+	// Workloads are still matched by LabelSelector, and no longer by the source selector.
+	workloads, err := r.listWorkloads(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, workloads, 1)
+	r.WorkloadLabelSelector = labels.SelectorFromSet(sourceLabels)
+	workloads, err = r.listWorkloads(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Empty(t, workloads)
+}
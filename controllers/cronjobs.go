@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// jobSuspendedForAnnotation marks a Job the operator suspended to restart it on a new config hash, holding
+// that hash. The Job is resumed, and the annotation replaced by the config hash annotation, once its pods
+// are gone.
+const jobSuspendedForAnnotation = "synapse.gen0sec.com/suspended-for-config-hash"
+
+// jobRestartInterval is how long to wait before checking on a suspended Job's pods again.
+const jobRestartInterval = 5 * time.Second
+
+// cronJobWorkload adapts a CronJob. Its pod template only applies to the Jobs it creates next, so patching
+// it never restarts anything: a new hash reaches the next scheduled run.
+func cronJobWorkload(cronJob *batchv1.CronJob) *workload {
+	return &workload{
+		kind:      "CronJob",
+		obj:       cronJob,
+		template:  &cronJob.Spec.JobTemplate.Spec.Template,
+		available: func() bool { return true },
+	}
+}
+
+// restartInFlightJobs restarts the running Jobs of the CronJob w that were created from an outdated
+// template by suspending them, which deletes their pods, and resuming them once the pods are gone; the
+// replacement pods read the current config sources. It returns how long to wait before checking again while
+// a restart is in progress. Jobs suspended by someone else are left alone.
+func (r *ConfigMapReconciler) restartInFlightJobs(ctx context.Context, w *workload, hash string) (time.Duration, error) {
+	jobs := &batchv1.JobList{}
+	if err := r.reader().List(ctx, jobs, client.InNamespace(w.obj.GetNamespace())); err != nil {
+		return 0, err
+	}
+	var requeueAfter time.Duration
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		owner := metav1.GetControllerOf(job)
+		if owner == nil || owner.UID != w.obj.GetUID() || jobFinished(job) {
+			continue
+		}
+
+		if suspendedFor, ok := job.Annotations[jobSuspendedForAnnotation]; ok {
+			if job.Status.Active > 0 {
+				requeueAfter = jobRestartInterval
+				continue
+			}
+			original := job.DeepCopy()
+			delete(job.Annotations, jobSuspendedForAnnotation)
+			job.Annotations[r.ConfigHashAnnotation] = suspendedFor
+			job.Spec.Suspend = ptr.To(false)
+			if err := r.Patch(ctx, job, client.MergeFrom(original)); err != nil {
+				return 0, err
+			}
+			r.traceEvent(ctx, job, corev1.EventTypeNormal, "JobResumed", fmt.Sprintf("Resumed with config hash %s", suspendedFor))
+			continue
+		}
+
+		if ptr.Deref(job.Spec.Suspend, false) || job.Annotations[r.ConfigHashAnnotation] == hash || job.Spec.Template.Annotations[r.ConfigHashAnnotation] == hash {
+			continue
+		}
+		original := job.DeepCopy()
+		if job.Annotations == nil {
+			job.Annotations = map[string]string{}
+		}
+		job.Annotations[jobSuspendedForAnnotation] = hash
+		job.Spec.Suspend = ptr.To(true)
+		if err := r.Patch(ctx, job, client.MergeFrom(original)); err != nil {
+			return 0, err
+		}
+		r.traceEvent(ctx, job, corev1.EventTypeNormal, "JobSuspended", fmt.Sprintf("Suspended to restart its pods with config hash %s", hash))
+		requeueAfter = jobRestartInterval
+	}
+	return requeueAfter, nil
+}
+
+// jobFinished reports whether job has completed or failed.
+func jobFinished(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+func newTestCronJob(annotations map[string]string) *batchv1.CronJob {
+	return &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "synapse-purge",
+			Namespace:   "matrix",
+			UID:         "cronjob-uid",
+			Annotations: annotations,
+			Labels:      map[string]string{"app.kubernetes.io/name": "synapse"},
+		},
+		Spec: batchv1.CronJobSpec{Schedule: "@daily"},
+	}
+}
+
+func newTestJob(name string, active int32, templateHash string) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "matrix",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "batch/v1",
+				Kind:       "CronJob",
+				Name:       "synapse-purge",
+				UID:        "cronjob-uid",
+				Controller: ptr.To(true),
+			}},
+		},
+		Status: batchv1.JobStatus{Active: active},
+	}
+	if templateHash != "" {
+		job.Spec.Template.Annotations = map[string]string{testHashAnnotation: templateHash}
+	}
+	return job
+}
+
+func TestRolloutWorkloadsPatchesCronJobTemplatesWhenManaged(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestCronJob(map[string]string{RestartStrategyAnnotation: StrategyEvict}))
+	r.RestartStrategy = StrategyRestartedAt
+
+	_, err := r.rolloutWorkloads(ctx, "matrix", "one", logr.Discard())
+	require.NoError(t, err)
+	cronJob := &batchv1.CronJob{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse-purge"}, cronJob))
+	assert.Empty(t, cronJob.Spec.JobTemplate.Spec.Template.Annotations, "CronJobs are ignored unless managed")
+
+	r.ManageCronJobs = true
+	_, err = r.rolloutWorkloads(ctx, "matrix", "one", logr.Discard())
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse-purge"}, cronJob))
+	assert.Empty(t, cronJob.Spec.JobTemplate.Spec.Template.Annotations, "only the annotation strategy applies to CronJobs")
+
+	delete(cronJob.Annotations, RestartStrategyAnnotation)
+	require.NoError(t, r.Update(ctx, cronJob))
+	_, err = r.rolloutWorkloads(ctx, "matrix", "one", logr.Discard())
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse-purge"}, cronJob))
+	assert.Equal(t, "one", cronJob.Spec.JobTemplate.Spec.Template.Annotations[testHashAnnotation])
+	assert.NotContains(t, cronJob.Spec.JobTemplate.Spec.Template.Annotations, DefaultRestartedAtAnnotation)
+}
+
+func TestRestartInFlightJobsSuspendsAndResumesOutdatedJobs(t *testing.T) {
+	ctx := context.Background()
+	cronJob := newTestCronJob(nil)
+	userSuspended := newTestJob("user-suspended", 0, "zero")
+	userSuspended.Spec.Suspend = ptr.To(true)
+	r := newTestReconciler(t, cronJob, newTestJob("outdated", 1, "zero"), newTestJob("current", 1, "one"), userSuspended)
+	w := cronJobWorkload(cronJob)
+
+	wait, err := r.restartInFlightJobs(ctx, w, "one")
+	require.NoError(t, err)
+	assert.Equal(t, jobRestartInterval, wait)
+
+	job := &batchv1.Job{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "outdated"}, job))
+	assert.True(t, ptr.Deref(job.Spec.Suspend, false))
+	assert.Equal(t, "one", job.Annotations[jobSuspendedForAnnotation])
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "current"}, job))
+	assert.False(t, ptr.Deref(job.Spec.Suspend, false))
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "user-suspended"}, job))
+	assert.NotContains(t, job.Annotations, jobSuspendedForAnnotation)
+
+	// The job is resumed only once its pods are gone.
+	wait, err = r.restartInFlightJobs(ctx, w, "one")
+	require.NoError(t, err)
+	assert.Equal(t, jobRestartInterval, wait)
+
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "outdated"}, job))
+	job.Status.Active = 0
+	require.NoError(t, r.Status().Update(ctx, job))
+	wait, err = r.restartInFlightJobs(ctx, w, "one")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "outdated"}, job))
+	assert.False(t, ptr.Deref(job.Spec.Suspend, true))
+	assert.NotContains(t, job.Annotations, jobSuspendedForAnnotation)
+	assert.Equal(t, "one", job.Annotations[testHashAnnotation])
+
+	// A resumed job is not restarted again for the same hash.
+	wait, err = r.restartInFlightJobs(ctx, w, "one")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+}
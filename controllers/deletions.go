@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// setupDeletionHandlers drops the in-memory state of Namespaces and workloads as they are deleted, from the
+// informers the controllers already watch them with. Reconciles never see these deletions: the namespace of a
+// deleted Namespace has no config sources left to reconcile, and a deleted workload is no longer listed.
+func (r *ConfigMapReconciler) setupDeletionHandlers(ctx context.Context, c cache.Cache) error {
+	informer, err := c.GetInformer(ctx, &corev1.Namespace{}, cache.BlockUntilSynced(false))
+	if err != nil {
+		return err
+	}
+	if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{DeleteFunc: r.namespaceDeleted}); err != nil {
+		return err
+	}
+	for _, kind := range r.workloadKinds() {
+		informer, err := c.GetInformer(ctx, kind.newObj(), cache.BlockUntilSynced(false))
+		if err != nil {
+			return err
+		}
+		if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{DeleteFunc: r.workloadDeleted(kind)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namespaceDeleted drops the cached digests and the rollout slot of a deleted Namespace, given as the object
+// or its tombstone.
+func (r *ConfigMapReconciler) namespaceDeleted(obj interface{}) {
+	if ns, ok := deletedObject(obj); ok {
+		r.forgetHashScopes(ns.GetName())
+		r.dropRollout(ns.GetName())
+	}
+}
+
+// workloadDeleted returns the handler dropping the rollout progress of a deleted workload of kind, so it
+// neither holds the rollout slot of its namespace nor halts later rollout tiers as stalled.
+func (r *ConfigMapReconciler) workloadDeleted(kind workloadKind) func(obj interface{}) {
+	return func(obj interface{}) {
+		if deleted, ok := deletedObject(obj); ok {
+			r.forgetProgress(kind.wrap(deleted))
+		}
+	}
+}
+
+// deletedObject returns the object of an informer delete notification, unwrapping tombstones.
+func deletedObject(obj interface{}) (client.Object, bool) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	deleted, ok := obj.(client.Object)
+	return deleted, ok
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// Drift repair modes accepted by --drift-repair.
+const (
+	// DriftRepairOff leaves a removed or altered config hash alone until the next config change.
+	DriftRepairOff = "off"
+	// DriftRepairRestore puts the hash back as it was, without restarting anything the removal did not.
+	DriftRepairRestore = "restore"
+	// DriftRepairRestart puts the hash back and treats the edit as a restart request, stamping the pod
+	// template like `kubectl rollout restart` so the pods are replaced with the current config.
+	DriftRepairRestart = "restart"
+)
+
+// ValidDriftRepairMode reports whether mode is a known drift repair mode.
+func ValidDriftRepairMode(mode string) bool {
+	return mode == DriftRepairOff || mode == DriftRepairRestore || mode == DriftRepairRestart
+}
+
+// workloadDriftReconciler repairs the config hash of managed workloads of one kind after another controller,
+// such as a GitOps tool pruning unknown annotations, removed or altered it while the config was unchanged.
+type workloadDriftReconciler struct {
+	parent *ConfigMapReconciler
+	workloadKind
+}
+
+// observeDrift reports whether the update from before to after removed or altered the hash applied to a
+// managed workload while that hash is still current, and if so remembers it for the repair. The operator's
+// own writes move a workload onto the current hash, so they never count as drift.
+func (r *ConfigMapReconciler) observeDrift(ctx context.Context, before, after *workload) bool {
+	if after.obj.GetAnnotations()[ManagedByAnnotation] != managedByValue || !r.targets(after) {
+		return false
+	}
+	_, strategy, err := r.strategyFor(after)
+	if err != nil {
+		return false
+	}
+	applied := strategy.appliedHash(r, before)
+	if applied == "" || applied == strategy.appliedHash(r, after) {
+		return false
+	}
+	hash, err := r.workloadHash(ctx, after.obj.GetNamespace(), after)
+	if err != nil || hash != applied {
+		return false
+	}
+	r.driftedHashes.Store(after.obj.GetNamespace()+"/"+after.key(), applied)
+	return true
+}
+
+func (d *workloadDriftReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r := d.parent
+	obj := d.newObj()
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	w := d.wrap(obj)
+	expected, ok := r.driftedHashes.LoadAndDelete(req.Namespace + "/" + w.key())
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+	_, strategy, err := r.strategyFor(w)
+	if err != nil || strategy.appliedHash(r, w) == expected {
+		return ctrl.Result{}, nil
+	}
+	// A config change since the drift is rolled out by the config reconcile, through its gates.
+	hash, err := r.workloadHash(ctx, req.Namespace, w)
+	if err != nil || hash != expected {
+		return ctrl.Result{}, err
+	}
+
+	original := w.obj.DeepCopyObject().(client.Object)
+	strategy.inject(r, w, hash)
+	reason, message := "ConfigHashRestored", fmt.Sprintf("Restored config hash %s removed or altered by another controller", hash)
+	if r.DriftRepair == DriftRepairRestart {
+		setTemplateAnnotation(w, r.restartedAtAnnotation(), time.Now().UTC().Format(time.RFC3339))
+		reason, message = "RestartRequested", fmt.Sprintf("Config hash %s was removed or altered; restored it and restarted the pods", hash)
+	}
+	if err := r.Patch(ctx, w.obj, client.MergeFrom(original)); err != nil {
+		return ctrl.Result{}, err
+	}
+	configHashDriftTotal.WithLabelValues(r.ClusterName, req.Namespace, r.DriftRepair).Inc()
+	log.FromContext(ctx).Info("Repaired config hash drift", w.logKey(), w.obj.GetName(), "namespace", req.Namespace, "configHash", hash, "mode", r.DriftRepair)
+	r.event(w.obj, corev1.EventTypeNormal, reason, message)
+	return ctrl.Result{}, nil
+}
+
+// setupDriftRepair watches the targeted workloads for updates that remove or alter their config hash.
+func (r *ConfigMapReconciler) setupDriftRepair(mgr ctrl.Manager) error {
+	for _, kind := range r.workloadKinds() {
+		drift := &workloadDriftReconciler{parent: r, workloadKind: kind}
+		drifted := predicate.Funcs{
+			CreateFunc:  func(event.CreateEvent) bool { return false },
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return r.observeDrift(context.Background(), drift.wrap(e.ObjectOld), drift.wrap(e.ObjectNew))
+			},
+		}
+		if err := ctrl.NewControllerManagedBy(mgr).
+			Named("drift-"+kind.name).
+			For(kind.newObj(), builder.WithPredicates(drifted)).
+			Complete(drift); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// stripHash rolls the hash out to the test Deployment, then removes it from the pod template the way a
+// GitOps tool pruning unknown annotations would, and returns the workload before and after.
+func stripHash(t *testing.T, r *ConfigMapReconciler) (*workload, *workload) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	before := deploy.DeepCopy()
+	delete(deploy.Spec.Template.Annotations, testHashAnnotation)
+	require.NoError(t, r.Update(ctx, deploy))
+	return deploymentWorkload(before), deploymentWorkload(deploy)
+}
+
+func TestDriftRepairRestoresStrippedHash(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", nil, nil, "a"))
+	r.DriftRepair = DriftRepairRestore
+	before, after := stripHash(t, r)
+	hash := before.template.Annotations[testHashAnnotation]
+	drift := &workloadDriftReconciler{parent: r, workloadKind: r.workloadKinds()[0]}
+
+	require.True(t, r.observeDrift(ctx, before, after))
+	_, err := drift.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "synapse"}})
+	require.NoError(t, err)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.Equal(t, hash, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.NotContains(t, deploy.Spec.Template.Annotations, DefaultRestartedAtAnnotation)
+
+	// Restoring the hash is the operator's own write, not drift.
+	assert.False(t, r.observeDrift(ctx, after, deploymentWorkload(deploy)))
+}
+
+func TestDriftRepairRestartMode(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", nil, nil, "a"))
+	r.DriftRepair = DriftRepairRestart
+	before, after := stripHash(t, r)
+	drift := &workloadDriftReconciler{parent: r, workloadKind: r.workloadKinds()[0]}
+
+	require.True(t, r.observeDrift(ctx, before, after))
+	_, err := drift.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "synapse"}})
+	require.NoError(t, err)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.Equal(t, before.template.Annotations[testHashAnnotation], deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.NotEmpty(t, deploy.Spec.Template.Annotations[DefaultRestartedAtAnnotation])
+}
+
+func TestDriftRepairLeavesConfigChangesToRollout(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", nil, nil, "a"))
+	r.DriftRepair = DriftRepairRestore
+	before, after := stripHash(t, r)
+	cfg := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "homeserver"}, cfg))
+	cfg.Data["data"] = "b"
+	require.NoError(t, r.Update(ctx, cfg))
+
+	assert.False(t, r.observeDrift(ctx, before, after), "the hash the workload ran is no longer current")
+}
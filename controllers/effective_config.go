@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Layers an effective setting can come from, from the widest to the narrowest.
+const (
+	layerFlag      = "flag"
+	layerNamespace = "namespace"
+	layerWorkload  = "workload"
+	layerSource    = "source"
+)
+
+// effectiveSetting is one resolved setting and the layer it was taken from.
+type effectiveSetting struct {
+	Value any    `json:"value"`
+	Layer string `json:"layer"`
+}
+
+// effectiveSource is how one config source of the workload feeds its hash.
+type effectiveSource struct {
+	Kind   string           `json:"kind"`
+	Name   string           `json:"name"`
+	Class  effectiveSetting `json:"class"`
+	Policy effectiveSetting `json:"policy"`
+	// Debounce is set for sources under SourcePolicyDebounce.
+	Debounce string `json:"debounce,omitempty"`
+	// IgnoredKeys and IncludedKeys are the key filters applied to the source; an empty IncludedKeys
+	// includes every key not ignored.
+	IgnoredKeys  effectiveSetting `json:"ignoredKeys"`
+	IncludedKeys effectiveSetting `json:"includedKeys"`
+	// Error is set when the source's annotations are invalid; it is then left out of the hash.
+	Error string `json:"error,omitempty"`
+}
+
+// effectiveConfig is the response of GET /debug/effective-config.
+type effectiveConfig struct {
+	Namespace            string            `json:"namespace"`
+	Workload             string            `json:"workload,omitempty"`
+	ConfigHashAnnotation effectiveSetting  `json:"configHashAnnotation"`
+	RestartStrategy      *effectiveSetting `json:"restartStrategy,omitempty"`
+	// StrategySettings are the settings of the workload's restart strategy.
+	StrategySettings     map[string]effectiveSetting `json:"strategySettings,omitempty"`
+	RolloutsPaused       effectiveSetting            `json:"rolloutsPaused"`
+	ApprovalRequired     effectiveSetting            `json:"approvalRequired"`
+	GradualRolloutWindow effectiveSetting            `json:"gradualRolloutWindow"`
+	Sources              []effectiveSource           `json:"sources"`
+}
+
+// serveEffectiveConfig answers GET /debug/effective-config?namespace=<namespace>&workload=<kind>/<name> with
+// the settings the operator applies to the workload, or to the namespace without one, after layering the
+// flags, the namespace annotations, the source class policies, and the annotations of the workload and of
+// its sources.
+func (r *ConfigMapReconciler) serveEffectiveConfig(w http.ResponseWriter, req *http.Request) {
+	namespace := req.URL.Query().Get("namespace")
+	if namespace == "" {
+		http.Error(w, "namespace is required", http.StatusBadRequest)
+		return
+	}
+	config, err := r.effectiveConfig(req.Context(), namespace, req.URL.Query().Get("workload"))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeStatusError(w, err)
+		return
+	}
+	writeStatusJSON(w, config)
+}
+
+// effectiveConfig resolves the settings of namespace and, when workloadRef is set, of the targeted workload
+// it names as "<kind>/<name>", or by name alone.
+func (r *ConfigMapReconciler) effectiveConfig(ctx context.Context, namespace, workloadRef string) (effectiveConfig, error) {
+	config := effectiveConfig{
+		Namespace:            namespace,
+		ConfigHashAnnotation: effectiveSetting{Value: r.ConfigHashAnnotation, Layer: layerFlag},
+		RolloutsPaused:       effectiveSetting{Value: false, Layer: layerFlag},
+		ApprovalRequired:     effectiveSetting{Value: r.RequireApproval, Layer: layerFlag},
+		GradualRolloutWindow: effectiveSetting{Value: r.GradualRolloutWindow.String(), Layer: layerFlag},
+		Sources:              []effectiveSource{},
+	}
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil && !apierrors.IsNotFound(err) {
+		return config, err
+	}
+	if ns.Annotations[RolloutsPausedAnnotation] == "true" {
+		config.RolloutsPaused = effectiveSetting{Value: true, Layer: layerNamespace}
+	}
+	if value := ns.Annotations[ApprovalRequiredAnnotation]; value == "true" || value == "false" {
+		config.ApprovalRequired = effectiveSetting{Value: value == "true", Layer: layerNamespace}
+	}
+	if _, ok := ns.Annotations[GradualRolloutWindowAnnotation]; ok {
+		config.GradualRolloutWindow = effectiveSetting{Value: r.gradualWindow(ctx, namespace).String(), Layer: layerNamespace}
+	}
+
+	configMaps, secrets, err := r.listSelectedSources(ctx, namespace)
+	if err != nil {
+		return config, err
+	}
+	if workloadRef != "" {
+		w, err := r.findWorkload(ctx, namespace, workloadRef)
+		if err != nil {
+			return config, err
+		}
+		config.Workload = w.key()
+		r.resolveWorkloadSettings(&config, w)
+		if r.grouped() {
+			configMaps, secrets = r.ownSources(r.objectGroup(w.obj), configMaps, secrets)
+		}
+	}
+	for i := range configMaps {
+		config.Sources = append(config.Sources, r.effectiveSource("ConfigMap", &configMaps[i], r.configMapKeyFilter()))
+	}
+	for i := range secrets {
+		config.Sources = append(config.Sources, r.effectiveSource("Secret", &secrets[i], r.secretKeyFilter()))
+	}
+	return config, nil
+}
+
+// findWorkload returns the targeted workload of namespace named by ref.
+func (r *ConfigMapReconciler) findWorkload(ctx context.Context, namespace, ref string) (*workload, error) {
+	workloads, err := r.findWorkloads(ctx, r.workloadListOptions(namespace)...)
+	if err != nil {
+		return nil, err
+	}
+	kind, name, hasKind := strings.Cut(ref, "/")
+	if !hasKind {
+		kind, name = "", ref
+	}
+	for _, w := range workloads {
+		if w.obj.GetName() == name && (kind == "" || strings.EqualFold(w.kind, kind)) {
+			return w, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(corev1.Resource("workload"), ref)
+}
+
+// resolveWorkloadSettings adds the restart strategy of w, and the settings of that strategy, to config.
+func (r *ConfigMapReconciler) resolveWorkloadSettings(config *effectiveConfig, w *workload) {
+	annotations := w.obj.GetAnnotations()
+	layer := layerFlag
+	if _, ok := annotations[RestartStrategyAnnotation]; ok || w.kind == "CronJob" {
+		layer = layerWorkload
+	}
+	name, _, err := r.strategyFor(w)
+	strategy := effectiveSetting{Value: name, Layer: layer}
+	if err != nil {
+		strategy.Value = fmt.Sprintf("%s (invalid: %v)", name, err)
+	}
+	config.RestartStrategy = &strategy
+
+	settings := map[string]effectiveSetting{}
+	switch name {
+	case StrategyRestartedAt:
+		settings["restartedAtAnnotation"] = effectiveSetting{Value: r.restartedAtAnnotation(), Layer: layerFlag}
+	case StrategyEvict:
+		if w.kind == "StatefulSet" && r.ZoneTopologyKey != "" {
+			settings["zoneTopologyKey"] = effectiveSetting{Value: r.ZoneTopologyKey, Layer: layerFlag}
+		}
+	case StrategyVersioned:
+		settings["versionedCopiesRetention"] = effectiveSetting{Value: r.VersionedCopiesRetention, Layer: layerFlag}
+	case StrategyCanary:
+		size := effectiveSetting{Value: "1", Layer: layerFlag}
+		if value, ok := annotations[CanarySizeAnnotation]; ok {
+			size = effectiveSetting{Value: value, Layer: layerWorkload}
+		}
+		settings["canarySize"] = size
+		settings["canaryManualApproval"] = effectiveSetting{Value: r.CanaryManualApproval, Layer: layerFlag}
+	}
+	if len(settings) > 0 {
+		config.StrategySettings = settings
+	}
+}
+
+// effectiveSource resolves the class, policy, and key filters of one source.
+func (r *ConfigMapReconciler) effectiveSource(kind string, obj client.Object, filter keyFilter) effectiveSource {
+	source := effectiveSource{
+		Kind:         kind,
+		Name:         obj.GetName(),
+		IgnoredKeys:  effectiveSetting{Value: sortedKeys(filter.ignored), Layer: layerFlag},
+		IncludedKeys: effectiveSetting{Value: sortedKeys(filter.included), Layer: layerFlag},
+	}
+	if _, ok := obj.GetAnnotations()[IncludeKeysAnnotation]; ok {
+		source.IncludedKeys = effectiveSetting{Value: sortedKeys(filter.forSource(obj).included), Layer: layerSource}
+	}
+	rule, err := r.classifySource(obj)
+	if err != nil {
+		source.Error = err.Error()
+	}
+	source.Class = effectiveSetting{Value: rule.Class, Layer: layerFlag}
+	if _, ok := obj.GetAnnotations()[SourceClassAnnotation]; ok {
+		source.Class.Layer = layerSource
+	}
+	source.Policy = effectiveSetting{Value: rule.Policy, Layer: layerFlag}
+	if _, ok := obj.GetAnnotations()[SourcePolicyAnnotation]; ok {
+		source.Policy.Layer = layerSource
+	}
+	if rule.Policy == SourcePolicyDebounce {
+		source.Debounce = rule.Debounce.String()
+	}
+	return source
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEffectiveConfigLayersSettings(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{GradualRolloutWindowAnnotation: "10m"}}}
+	deploy := newTestDeployment(map[string]string{RestartStrategyAnnotation: StrategyCanary, CanarySizeAnnotation: "25%"})
+	labels := map[string]string{"app.kubernetes.io/name": "synapse"}
+	r := newTestReconciler(t, ns, deploy,
+		newTestConfigMap("homeserver", labels, map[string]string{IncludeKeysAnnotation: "homeserver.yaml"}, "a"),
+		newTestConfigMap("ca-bundle", labels, map[string]string{SourcePolicyAnnotation: SourcePolicyIgnore}, "b"))
+	r.SourceRules = DefaultSourceRules()
+	r.RestartStrategy = StrategyAnnotation
+	r.GradualRolloutWindow = time.Hour
+	r.IgnoredConfigMapKeys = parseTestKeys("upstreams.yaml")
+	handler := r.statusHandler()
+
+	var config effectiveConfig
+	getStatus(t, handler, "/debug/effective-config?namespace=matrix&workload=deployment/synapse", &config)
+	assert.Equal(t, "deployment/synapse", config.Workload)
+	assert.Equal(t, &effectiveSetting{Value: StrategyCanary, Layer: layerWorkload}, config.RestartStrategy)
+	assert.Equal(t, effectiveSetting{Value: "25%", Layer: layerWorkload}, config.StrategySettings["canarySize"])
+	assert.Equal(t, effectiveSetting{Value: "10m0s", Layer: layerNamespace}, config.GradualRolloutWindow)
+	assert.Equal(t, effectiveSetting{Value: testHashAnnotation, Layer: layerFlag}, config.ConfigHashAnnotation)
+	require.Len(t, config.Sources, 2)
+	byName := map[string]effectiveSource{}
+	for _, source := range config.Sources {
+		byName[source.Name] = source
+	}
+	bundle := byName["ca-bundle"]
+	assert.Equal(t, effectiveSetting{Value: SourceClassCABundle, Layer: layerFlag}, bundle.Class)
+	assert.Equal(t, effectiveSetting{Value: SourcePolicyIgnore, Layer: layerSource}, bundle.Policy)
+	assert.Empty(t, bundle.Debounce)
+	homeserver := byName["homeserver"]
+	assert.Equal(t, effectiveSetting{Value: []any{"homeserver.yaml"}, Layer: layerSource}, homeserver.IncludedKeys)
+	assert.Equal(t, effectiveSetting{Value: []any{"upstreams.yaml"}, Layer: layerFlag}, homeserver.IgnoredKeys)
+
+	var namespaceOnly effectiveConfig
+	getStatus(t, handler, "/debug/effective-config?namespace=matrix", &namespaceOnly)
+	assert.Nil(t, namespaceOnly.RestartStrategy, "without a workload only the namespace settings are resolved")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/effective-config?namespace=matrix&workload=statefulset/synapse", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func parseTestKeys(keys ...string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	return set
+}
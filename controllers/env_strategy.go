@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StrategyEnv sets the hash as an environment variable of a container in the pod template, next to the pod
+// template annotation, so the running process can see which config it was started with.
+const StrategyEnv = "env"
+
+const (
+	// ConfigHashEnvContainerAnnotation names the container the env strategy sets the hash on. Defaults to the
+	// first container of the pod template.
+	ConfigHashEnvContainerAnnotation = "synapse.gen0sec.com/config-hash-env-container"
+	// ConfigHashEnvVarAnnotation names the environment variable the env strategy sets. Defaults to
+	// DefaultConfigHashEnvVar.
+	ConfigHashEnvVarAnnotation = "synapse.gen0sec.com/config-hash-env-var"
+)
+
+// DefaultConfigHashEnvVar is the environment variable the env strategy sets unless a workload names another.
+const DefaultConfigHashEnvVar = "SYNAPSE_CONFIG_HASH"
+
+type envStrategy struct{}
+
+func (envStrategy) appliedHash(r *ConfigMapReconciler, w *workload) string {
+	container, name, err := envTarget(w)
+	if err != nil {
+		return ""
+	}
+	for _, env := range container.Env {
+		if env.Name == name && env.ValueFrom == nil {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+func (envStrategy) inject(r *ConfigMapReconciler, w *workload, hash string) {
+	container, name, err := envTarget(w)
+	if err != nil {
+		return
+	}
+	setContainerEnv(container, name, hash)
+	setTemplateAnnotation(w, r.ConfigHashAnnotation, hash)
+}
+
+func (envStrategy) requestRestart(r *ConfigMapReconciler, w *workload, at time.Time) {
+	stampRestartedAt(r, w, at)
+}
+
+func (s envStrategy) apply(ctx context.Context, r *ConfigMapReconciler, w *workload, hash string) (restartOutcome, error) {
+	if s.appliedHash(r, w) == hash {
+		return restartOutcome{}, nil
+	}
+	original := w.obj.DeepCopyObject().(client.Object)
+	container, name, err := envTarget(w)
+	if err != nil {
+		return restartOutcome{}, err
+	}
+	setContainerEnv(container, name, hash)
+	setTemplateAnnotation(w, r.ConfigHashAnnotation, hash)
+	stampRestartCause(ctx, w)
+	// A strategic merge patch only sends the changed variable, not the whole container list, which may be
+	// trimmed in the cache.
+	return restartOutcome{updated: true}, r.Patch(ctx, w.obj, client.StrategicMergeFrom(original))
+}
+
+// envTarget resolves the container and environment variable the env strategy writes for w.
+func envTarget(w *workload) (*corev1.Container, string, error) {
+	annotations := w.obj.GetAnnotations()
+	name := annotations[ConfigHashEnvVarAnnotation]
+	if name == "" {
+		name = DefaultConfigHashEnvVar
+	}
+	containers := w.template.Spec.Containers
+	containerName, named := annotations[ConfigHashEnvContainerAnnotation]
+	if !named {
+		if len(containers) == 0 {
+			return nil, "", fmt.Errorf("restart strategy %q needs a container in the pod template", StrategyEnv)
+		}
+		return &containers[0], name, nil
+	}
+	for i := range containers {
+		if containers[i].Name == containerName {
+			return &containers[i], name, nil
+		}
+	}
+	return nil, "", fmt.Errorf("container %q named by %s is not in the pod template", containerName, ConfigHashEnvContainerAnnotation)
+}
+
+// setContainerEnv sets the literal value of the environment variable name, replacing any reference it had.
+func setContainerEnv(container *corev1.Container, name, value string) {
+	for i := range container.Env {
+		if container.Env[i].Name == name {
+			container.Env[i].Value = value
+			container.Env[i].ValueFrom = nil
+			return
+		}
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
+}
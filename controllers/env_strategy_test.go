@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestEnvDeployment(annotations map[string]string) *appsv1.Deployment {
+	deploy := newTestDeployment(annotations)
+	deploy.Spec.Template.Spec.Containers = []corev1.Container{
+		{Name: "synapse", Env: []corev1.EnvVar{{Name: "SYNAPSE_SERVER_NAME", Value: "example.com"}}},
+		{Name: "sidecar"},
+	}
+	return deploy
+}
+
+func TestEnvStrategySetsVariableOnFirstContainer(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestEnvDeployment(nil))
+
+	workloads, err := r.listWorkloads(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, workloads, 1)
+
+	outcome, err := envStrategy{}.apply(ctx, r, workloads[0], "abc")
+	require.NoError(t, err)
+	assert.True(t, outcome.updated)
+
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "SYNAPSE_SERVER_NAME", Value: "example.com"},
+		{Name: DefaultConfigHashEnvVar, Value: "abc"},
+	}, deploy.Spec.Template.Spec.Containers[0].Env)
+	assert.Empty(t, deploy.Spec.Template.Spec.Containers[1].Env)
+	assert.Equal(t, "abc", deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Equal(t, "abc", envStrategy{}.appliedHash(r, deploymentWorkload(&deploy)))
+
+	outcome, err = envStrategy{}.apply(ctx, r, deploymentWorkload(&deploy), "abc")
+	require.NoError(t, err)
+	assert.False(t, outcome.updated)
+}
+
+func TestEnvStrategyUsesNamedContainerAndVariable(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestEnvDeployment(map[string]string{
+		ConfigHashEnvContainerAnnotation: "sidecar",
+		ConfigHashEnvVarAnnotation:       "CONFIG_HASH",
+	})
+	deploy.Spec.Template.Spec.Containers[1].Env = []corev1.EnvVar{{
+		Name:      "CONFIG_HASH",
+		ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "hash"}},
+	}}
+	r := newTestReconciler(t, deploy)
+
+	w := deploymentWorkload(deploy)
+	assert.Empty(t, envStrategy{}.appliedHash(r, w), "a referenced value is not a hash written by the operator")
+
+	outcome, err := envStrategy{}.apply(ctx, r, w, "abc")
+	require.NoError(t, err)
+	assert.True(t, outcome.updated)
+
+	var got appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &got))
+	assert.Equal(t, []corev1.EnvVar{{Name: "CONFIG_HASH", Value: "abc"}}, got.Spec.Template.Spec.Containers[1].Env)
+	assert.Equal(t, []corev1.EnvVar{{Name: "SYNAPSE_SERVER_NAME", Value: "example.com"}}, got.Spec.Template.Spec.Containers[0].Env)
+}
+
+func TestStrategyForRejectsMissingEnvContainer(t *testing.T) {
+	r := newTestReconciler(t)
+	_, _, err := r.strategyFor(deploymentWorkload(newTestEnvDeployment(map[string]string{
+		RestartStrategyAnnotation:        StrategyEnv,
+		ConfigHashEnvContainerAnnotation: "missing",
+	})))
+	assert.ErrorContains(t, err, `container "missing"`)
+
+	name, _, err := r.strategyFor(deploymentWorkload(newTestEnvDeployment(map[string]string{RestartStrategyAnnotation: StrategyEnv})))
+	require.NoError(t, err)
+	assert.Equal(t, StrategyEnv, name)
+}
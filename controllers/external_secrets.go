@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var externalSecretGVK = schema.GroupVersionKind{
+	Group:   "external-secrets.io",
+	Version: "v1",
+	Kind:    "ExternalSecret",
+}
+
+// externalSecretTarget returns the name of the Secret an ExternalSecret writes, which defaults to its own.
+func externalSecretTarget(u *unstructured.Unstructured) string {
+	if name, _, _ := unstructured.NestedString(u.Object, "spec", "target", "name"); name != "" {
+		return name
+	}
+	return u.GetName()
+}
+
+// watchExternalSecrets adds a watch on ExternalSecrets, reconciling the Secret each one targets. Updates that
+// only move status.refreshTime are dropped: they happen on every refresh interval whether or not anything
+// changed.
+func (r *ConfigMapReconciler) watchExternalSecrets(b *builder.Builder) *builder.Builder {
+	externalSecret := &unstructured.Unstructured{}
+	externalSecret.SetGroupVersionKind(externalSecretGVK)
+	return b.Watches(
+		externalSecret,
+		handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: externalSecretTarget(u)}}}
+		}),
+		builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, externalSecretSyncedPredicate())),
+	)
+}
+
+// externalSecretSyncedPredicate passes updates that change the synced resource version of an ExternalSecret.
+func externalSecretSyncedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldObj, okOld := e.ObjectOld.(*unstructured.Unstructured)
+			newObj, okNew := e.ObjectNew.(*unstructured.Unstructured)
+			if !okOld || !okNew {
+				return true
+			}
+			return externalSecretSyncedVersion(oldObj) != externalSecretSyncedVersion(newObj)
+		},
+	}
+}
+
+func externalSecretSyncedVersion(u *unstructured.Unstructured) string {
+	version, _, _ := unstructured.NestedString(u.Object, "status", "syncedResourceVersion")
+	return version
+}
+
+// externalSecretDigests hashes the spec and synced resource version of each ExternalSecret targeting one of
+// the namespace's config Secrets, or matching the source selector itself. With creationPolicy Merge an
+// ExternalSecret only owns some keys of a Secret written by someone else, so a change to its template or
+// keys is folded into the hash even before the Secret it merges into changes. status.refreshTime is left
+// out, since it moves on every refresh interval.
+func (r *ConfigMapReconciler) externalSecretDigests(ctx context.Context, namespace string, secrets []corev1.Secret) ([]sourceDigest, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(externalSecretGVK.GroupVersion().WithKind(externalSecretGVK.Kind + "List"))
+	if err := r.cacheReader().List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]struct{}, len(secrets))
+	for i := range secrets {
+		targets[secrets[i].Name] = struct{}{}
+	}
+	selector := r.sourceSelector()
+
+	var digests []sourceDigest
+	for i := range list.Items {
+		externalSecret := &list.Items[i]
+		_, targeted := targets[externalSecretTarget(externalSecret)]
+		if !targeted && !selector.Matches(labels.Set(externalSecret.GetLabels())) {
+			continue
+		}
+		spec, err := json.Marshal(externalSecret.Object["spec"])
+		if err != nil {
+			return nil, err
+		}
+		hasher := sha256.New()
+		hasher.Write(spec)
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(externalSecretSyncedVersion(externalSecret)))
+		digests = append(digests, sourceDigest{
+			key:  "externalsecret/" + externalSecret.GetName(),
+			hash: hex.EncodeToString(hasher.Sum(nil)),
+		})
+	}
+	return digests, nil
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func newTestExternalSecret(name, target, key, syncedVersion, refreshTime string) *unstructured.Unstructured {
+	externalSecret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"target": map[string]interface{}{"name": target, "creationPolicy": "Merge"},
+			"data":   []interface{}{map[string]interface{}{"secretKey": key}},
+		},
+		"status": map[string]interface{}{"syncedResourceVersion": syncedVersion, "refreshTime": refreshTime},
+	}}
+	externalSecret.SetGroupVersionKind(externalSecretGVK)
+	externalSecret.SetNamespace("matrix")
+	externalSecret.SetName(name)
+	return externalSecret
+}
+
+func TestExternalSecretDigests(t *testing.T) {
+	ctx := context.Background()
+	secrets := []corev1.Secret{{ObjectMeta: metav1.ObjectMeta{Name: "synapse-secrets", Namespace: "matrix"}}}
+	digestFor := func(externalSecrets ...*unstructured.Unstructured) []sourceDigest {
+		r := newTestReconciler(t)
+		r.SourceLabelSelector = labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "synapse"})
+		for _, externalSecret := range externalSecrets {
+			require.NoError(t, r.Create(ctx, externalSecret))
+		}
+		digests, err := r.externalSecretDigests(ctx, "matrix", secrets)
+		require.NoError(t, err)
+		return digests
+	}
+
+	base := digestFor(newTestExternalSecret("synapse", "synapse-secrets", "db-password", "1-abc", "2026-10-01T12:00:00Z"))
+	require.Len(t, base, 1)
+	assert.Equal(t, "externalsecret/synapse", base[0].key)
+	assert.Equal(t, base, digestFor(newTestExternalSecret("synapse", "synapse-secrets", "db-password", "1-abc", "2026-10-01T13:00:00Z")),
+		"a refresh without changes keeps the hash")
+	assert.NotEqual(t, base, digestFor(newTestExternalSecret("synapse", "synapse-secrets", "db-password", "1-def", "2026-10-01T13:00:00Z")))
+	assert.NotEqual(t, base, digestFor(newTestExternalSecret("synapse", "synapse-secrets", "signing-key", "1-abc", "2026-10-01T12:00:00Z")),
+		"a spec change alone changes the hash")
+	assert.Empty(t, digestFor(newTestExternalSecret("other", "other-secrets", "token", "1-abc", "")),
+		"an ExternalSecret neither targeting a config Secret nor selected is left out")
+}
+
+func TestExternalSecretSyncedPredicateIgnoresRefreshes(t *testing.T) {
+	p := externalSecretSyncedPredicate()
+	old := newTestExternalSecret("synapse", "synapse-secrets", "db-password", "1-abc", "2026-10-01T12:00:00Z")
+	assert.False(t, p.Update(event.UpdateEvent{ObjectOld: old,
+		ObjectNew: newTestExternalSecret("synapse", "synapse-secrets", "db-password", "1-abc", "2026-10-01T13:00:00Z")}))
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: old,
+		ObjectNew: newTestExternalSecret("synapse", "synapse-secrets", "db-password", "1-def", "2026-10-01T13:00:00Z")}))
+}
@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fairQueue is a workqueue that serves namespaces round-robin and never hands out two requests of the same
+// namespace at once. With several workers, a namespace with a burst of changes therefore cannot starve the
+// others, and reconciles of one namespace stay serialized, as rollouts of a namespace assume. Namespaces of
+// a higher priority are served before the others. It is the queue underneath the client-go delaying and rate
+// limiting queues, which keep one waiting entry per request with the earliest deadline.
+type fairQueue struct {
+	// priority, when set, returns the priority of a namespace; it is looked up as requests are added.
+	priority func(namespace string) int
+	metrics  *fairQueueMetrics
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// pending holds the queued requests of each namespace in FIFO order; queued deduplicates them.
+	pending map[string][]reconcile.Request
+	queued  map[reconcile.Request]struct{}
+	// order is the round-robin order of the namespaces with pending requests, and priorities their priority.
+	order      []string
+	priorities map[string]int
+	// active holds the namespaces with a request being processed.
+	active       map[string]struct{}
+	shuttingDown bool
+}
+
+// newFairQueue returns the rate limiting queue of the controller name, fair across namespaces. Its metrics
+// are reported under name like those of the default controller-runtime queue; an unnamed queue has none.
+func newFairQueue(name string, rateLimiter workqueue.TypedRateLimiter[reconcile.Request], priority func(namespace string) int) workqueue.TypedRateLimitingInterface[reconcile.Request] {
+	fair := &fairQueue{
+		priority:   priority,
+		priorities: map[string]int{},
+		pending:    map[string][]reconcile.Request{},
+		queued:     map[reconcile.Request]struct{}{},
+		active:     map[string]struct{}{},
+	}
+	fair.cond = sync.NewCond(&fair.mu)
+	if name != "" {
+		fair.metrics = newFairQueueMetrics(name)
+		go fair.updateUnfinishedWork()
+	}
+	delaying := workqueue.NewTypedDelayingQueueWithConfig(workqueue.TypedDelayingQueueConfig[reconcile.Request]{
+		Name:  name,
+		Queue: fair,
+	})
+	return workqueue.NewTypedRateLimitingQueueWithConfig(rateLimiter, workqueue.TypedRateLimitingQueueConfig[reconcile.Request]{
+		Name:          name,
+		DelayingQueue: delaying,
+	})
+}
+
+func (q *fairQueue) Add(item reconcile.Request) {
+	priority := 0
+	if q.priority != nil {
+		priority = q.priority(item.Namespace)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.queued[item]; ok {
+		return
+	}
+	q.metrics.add(item)
+	q.queued[item] = struct{}{}
+	q.priorities[item.Namespace] = priority
+	if len(q.pending[item.Namespace]) == 0 {
+		q.order = append(q.order, item.Namespace)
+	}
+	q.pending[item.Namespace] = append(q.pending[item.Namespace], item)
+	q.cond.Signal()
+}
+
+func (q *fairQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queued)
+}
+
+// Get returns the oldest request of the first namespace in round-robin order among those of the highest
+// priority that are not being processed.
+func (q *fairQueue) Get() (reconcile.Request, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.shuttingDown {
+			return reconcile.Request{}, true
+		}
+		next := -1
+		for i, namespace := range q.order {
+			if _, busy := q.active[namespace]; busy {
+				continue
+			}
+			if next < 0 || q.priorities[namespace] > q.priorities[q.order[next]] {
+				next = i
+			}
+		}
+		if next >= 0 {
+			namespace := q.order[next]
+			requests := q.pending[namespace]
+			item := requests[0]
+			q.order = append(q.order[:next:next], q.order[next+1:]...)
+			if len(requests) > 1 {
+				q.pending[namespace] = requests[1:]
+				q.order = append(q.order, namespace)
+			} else {
+				delete(q.pending, namespace)
+				delete(q.priorities, namespace)
+			}
+			delete(q.queued, item)
+			q.active[namespace] = struct{}{}
+			q.metrics.get(item)
+			return item, false
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *fairQueue) Done(item reconcile.Request) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.metrics.done(item)
+	delete(q.active, item.Namespace)
+	q.cond.Broadcast()
+}
+
+func (q *fairQueue) ShutDown() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// ShutDownWithDrain stops handing out requests and waits for those being processed to be done.
+func (q *fairQueue) ShutDownWithDrain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+	for len(q.active) > 0 {
+		q.cond.Wait()
+	}
+}
+
+func (q *fairQueue) ShuttingDown() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.shuttingDown
+}
+
+// updateUnfinishedWork refreshes the in-progress work metrics until the queue shuts down, as client-go
+// queues do.
+func (q *fairQueue) updateUnfinishedWork() {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		q.mu.Lock()
+		if q.shuttingDown {
+			q.mu.Unlock()
+			return
+		}
+		q.metrics.updateUnfinishedWork()
+		q.mu.Unlock()
+	}
+}
+
+// namespaceRateLimiter backs off failing requests exponentially and limits the retries of each namespace
+// with its own token bucket, instead of the single bucket shared by every namespace by default.
+type namespaceRateLimiter struct {
+	items workqueue.TypedRateLimiter[reconcile.Request]
+	qps   rate.Limit
+	burst int
+
+	mu         sync.Mutex
+	namespaces map[string]*rate.Limiter
+}
+
+func newNamespaceRateLimiter(qps float64, burst int) *namespaceRateLimiter {
+	return &namespaceRateLimiter{
+		items:      workqueue.NewTypedItemExponentialFailureRateLimiter[reconcile.Request](5*time.Millisecond, 1000*time.Second),
+		qps:        rate.Limit(qps),
+		burst:      burst,
+		namespaces: map[string]*rate.Limiter{},
+	}
+}
+
+func (l *namespaceRateLimiter) When(item reconcile.Request) time.Duration {
+	l.mu.Lock()
+	limiter, ok := l.namespaces[item.Namespace]
+	if !ok {
+		limiter = rate.NewLimiter(l.qps, l.burst)
+		l.namespaces[item.Namespace] = limiter
+	}
+	l.mu.Unlock()
+	delay := limiter.Reserve().Delay()
+	if backoff := l.items.When(item); backoff > delay {
+		delay = backoff
+	}
+	return delay
+}
+
+func (l *namespaceRateLimiter) Forget(item reconcile.Request) {
+	l.items.Forget(item)
+}
+
+func (l *namespaceRateLimiter) NumRequeues(item reconcile.Request) int {
+	return l.items.NumRequeues(item)
+}
+
+// fairQueueMetrics reports a fairQueue in the workqueue metrics controller-runtime registers for its own
+// queues, so the fair queue is observed like the default one. A nil fairQueueMetrics reports nothing.
+type fairQueueMetrics struct {
+	depth                   prometheus.Gauge
+	adds                    prometheus.Counter
+	latency                 prometheus.Observer
+	workDuration            prometheus.Observer
+	unfinishedWorkSeconds   prometheus.Gauge
+	longestRunningProcessor prometheus.Gauge
+
+	addTimes             map[reconcile.Request]time.Time
+	processingStartTimes map[reconcile.Request]time.Time
+}
+
+func newFairQueueMetrics(name string) *fairQueueMetrics {
+	collectors := workqueueCollectors()
+	return &fairQueueMetrics{
+		depth:                   collectors.depth.WithLabelValues(name, name, ""),
+		adds:                    collectors.adds.WithLabelValues(name, name),
+		latency:                 collectors.latency.WithLabelValues(name, name),
+		workDuration:            collectors.workDuration.WithLabelValues(name, name),
+		unfinishedWorkSeconds:   collectors.unfinished.WithLabelValues(name, name),
+		longestRunningProcessor: collectors.longestRunningProcessor.WithLabelValues(name, name),
+		addTimes:                map[reconcile.Request]time.Time{},
+		processingStartTimes:    map[reconcile.Request]time.Time{},
+	}
+}
+
+func (m *fairQueueMetrics) add(item reconcile.Request) {
+	if m == nil {
+		return
+	}
+	m.adds.Inc()
+	m.depth.Inc()
+	if _, ok := m.addTimes[item]; !ok {
+		m.addTimes[item] = time.Now()
+	}
+}
+
+func (m *fairQueueMetrics) get(item reconcile.Request) {
+	if m == nil {
+		return
+	}
+	m.depth.Dec()
+	m.processingStartTimes[item] = time.Now()
+	if start, ok := m.addTimes[item]; ok {
+		m.latency.Observe(time.Since(start).Seconds())
+		delete(m.addTimes, item)
+	}
+}
+
+func (m *fairQueueMetrics) done(item reconcile.Request) {
+	if m == nil {
+		return
+	}
+	if start, ok := m.processingStartTimes[item]; ok {
+		m.workDuration.Observe(time.Since(start).Seconds())
+		delete(m.processingStartTimes, item)
+	}
+}
+
+func (m *fairQueueMetrics) updateUnfinishedWork() {
+	var total, oldest float64
+	for _, start := range m.processingStartTimes {
+		age := time.Since(start).Seconds()
+		total += age
+		oldest = max(oldest, age)
+	}
+	m.unfinishedWorkSeconds.Set(total)
+	m.longestRunningProcessor.Set(oldest)
+}
+
+// workqueueCollectors returns the workqueue collectors of controller-runtime. They are declared here as it
+// declares them, and registering them again yields the ones it registered.
+var workqueueCollectors = sync.OnceValue(func() (c struct {
+	depth                   *prometheus.GaugeVec
+	adds                    *prometheus.CounterVec
+	latency                 *prometheus.HistogramVec
+	workDuration            *prometheus.HistogramVec
+	unfinished              *prometheus.GaugeVec
+	longestRunningProcessor *prometheus.GaugeVec
+}) {
+	durationOpts := func(name, help string) prometheus.HistogramOpts {
+		return prometheus.HistogramOpts{
+			Subsystem:                       metrics.WorkQueueSubsystem,
+			Name:                            name,
+			Help:                            help,
+			Buckets:                         prometheus.ExponentialBuckets(10e-9, 10, 12),
+			NativeHistogramBucketFactor:     1.1,
+			NativeHistogramMaxBucketNumber:  100,
+			NativeHistogramMinResetDuration: time.Hour,
+		}
+	}
+	labels := []string{"name", "controller"}
+	c.depth = registeredCollector(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metrics.WorkQueueSubsystem,
+		Name:      metrics.DepthKey,
+		Help:      "Current depth of workqueue by workqueue and priority",
+	}, []string{"name", "controller", "priority"}))
+	c.adds = registeredCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: metrics.WorkQueueSubsystem,
+		Name:      metrics.AddsKey,
+		Help:      "Total number of adds handled by workqueue",
+	}, labels))
+	c.latency = registeredCollector(prometheus.NewHistogramVec(durationOpts(metrics.QueueLatencyKey,
+		"How long in seconds an item stays in workqueue before being requested"), labels))
+	c.workDuration = registeredCollector(prometheus.NewHistogramVec(durationOpts(metrics.WorkDurationKey,
+		"How long in seconds processing an item from workqueue takes."), labels))
+	c.unfinished = registeredCollector(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metrics.WorkQueueSubsystem,
+		Name:      metrics.UnfinishedWorkKey,
+		Help: "How many seconds of work has been done that " +
+			"is in progress and hasn't been observed by work_duration. Large " +
+			"values indicate stuck threads. One can deduce the number of stuck " +
+			"threads by observing the rate at which this increases.",
+	}, labels))
+	c.longestRunningProcessor = registeredCollector(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: metrics.WorkQueueSubsystem,
+		Name:      metrics.LongestRunningProcessorKey,
+		Help: "How many seconds has the longest running " +
+			"processor for workqueue been running.",
+	}, labels))
+	return c
+})
+
+// registeredCollector registers collector with the controller-runtime registry, returning the collector
+// already registered under the same description if there is one.
+func registeredCollector[C prometheus.Collector](collector C) C {
+	var registered prometheus.AlreadyRegisteredError
+	if err := metrics.Registry.Register(collector); errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(C); ok {
+			return existing
+		}
+	}
+	return collector
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func request(namespace, name string) reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+}
+
+func TestFairQueueServesNamespacesRoundRobin(t *testing.T) {
+	q := newFairQueue("", workqueue.DefaultTypedControllerRateLimiter[reconcile.Request](), nil)
+	for _, name := range []string{"a", "b", "c"} {
+		q.Add(request("noisy", name))
+	}
+	q.Add(request("noisy", "a"))
+	q.Add(request("quiet", "a"))
+	assert.Equal(t, 4, q.Len())
+
+	first, _ := q.Get()
+	assert.Equal(t, request("noisy", "a"), first)
+	// noisy is being processed, so quiet is served next even though noisy has more queued.
+	second, _ := q.Get()
+	assert.Equal(t, request("quiet", "a"), second)
+
+	q.Done(first)
+	third, _ := q.Get()
+	assert.Equal(t, request("noisy", "b"), third)
+	q.Done(second)
+	q.Done(third)
+
+	fourth, _ := q.Get()
+	assert.Equal(t, request("noisy", "c"), fourth)
+	q.Done(fourth)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestFairQueueShutDown(t *testing.T) {
+	q := newFairQueue("", workqueue.DefaultTypedControllerRateLimiter[reconcile.Request](), nil)
+	done := make(chan bool)
+	go func() {
+		_, shutdown := q.Get()
+		done <- shutdown
+	}()
+	q.ShutDown()
+	select {
+	case shutdown := <-done:
+		assert.True(t, shutdown)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get did not return after ShutDown")
+	}
+	q.Add(request("matrix", "a"))
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestNamespaceRateLimiterKeepsNamespacesApart(t *testing.T) {
+	l := newNamespaceRateLimiter(1, 1)
+	assert.Less(t, l.When(request("noisy", "a")), 100*time.Millisecond)
+	assert.Greater(t, l.When(request("noisy", "b")), 500*time.Millisecond)
+	assert.Less(t, l.When(request("quiet", "a")), 100*time.Millisecond)
+}
+
+func TestFairQueueServesHigherPriorityFirst(t *testing.T) {
+	priorities := map[string]int{"production": priorityHigh, "dev": priorityLow}
+	q := newFairQueue("", workqueue.DefaultTypedControllerRateLimiter[reconcile.Request](), func(namespace string) int {
+		return priorities[namespace]
+	})
+	q.Add(request("dev", "shared"))
+	q.Add(request("staging", "shared"))
+	q.Add(request("production", "shared"))
+	q.Add(request("production", "homeserver"))
+
+	first, _ := q.Get()
+	assert.Equal(t, request("production", "shared"), first)
+	// production is being processed, so the next namespace by priority is served.
+	second, _ := q.Get()
+	assert.Equal(t, request("staging", "shared"), second)
+	q.Done(first)
+	third, _ := q.Get()
+	assert.Equal(t, request("production", "homeserver"), third)
+	fourth, _ := q.Get()
+	assert.Equal(t, request("dev", "shared"), fourth)
+}
+
+func TestFairQueueKeepsOneWaitingEntryPerRequest(t *testing.T) {
+	q := newFairQueue("", workqueue.DefaultTypedControllerRateLimiter[reconcile.Request](), nil)
+	defer q.ShutDown()
+	item := request("matrix", "homeserver")
+	q.AddAfter(item, time.Hour)
+	q.AddAfter(item, 50*time.Millisecond)
+	q.AddAfter(item, 100*time.Millisecond)
+
+	got := make(chan reconcile.Request)
+	go func() {
+		for {
+			item, shutdown := q.Get()
+			if shutdown {
+				return
+			}
+			got <- item
+			q.Done(item)
+		}
+	}()
+	select {
+	case first := <-got:
+		assert.Equal(t, item, first, "the earliest deadline wins")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the delayed request was never added")
+	}
+	select {
+	case <-got:
+		t.Fatal("a request waiting several times is added once")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestFairQueueReportsWorkqueueMetrics(t *testing.T) {
+	q := newFairQueue("fair-queue-test", workqueue.DefaultTypedControllerRateLimiter[reconcile.Request](), nil)
+	defer q.ShutDown()
+	q.Add(request("matrix", "homeserver"))
+	q.Add(request("matrix", "homeserver"))
+
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	depth := -1.0
+	for _, family := range families {
+		if family.GetName() != "workqueue_depth" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "name" && label.GetValue() == "fair-queue-test" {
+					depth = metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	assert.Equal(t, 1.0, depth, "the depth is reported in the controller-runtime workqueue metrics")
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GitOps compatibility modes accepted by --gitops-compat.
+const (
+	// GitOpsCompatOff adds no companion annotations.
+	GitOpsCompatOff = "off"
+	// GitOpsCompatArgoCD tells Argo CD not to report the operator's annotations as drift.
+	GitOpsCompatArgoCD = "argocd"
+	// GitOpsCompatFlux tells Flux to merge the operator's annotations instead of reverting them.
+	GitOpsCompatFlux = "flux"
+)
+
+// gitOpsCompanionAnnotations are the companion annotations each mode adds to managed workloads.
+var gitOpsCompanionAnnotations = map[string]map[string]string{
+	GitOpsCompatOff:    nil,
+	GitOpsCompatArgoCD: {"argocd.argoproj.io/compare-options": "IgnoreExtraneous"},
+	GitOpsCompatFlux:   {"kustomize.toolkit.fluxcd.io/ssa": "Merge"},
+}
+
+// ValidGitOpsCompatMode reports whether mode is a known GitOps compatibility mode.
+func ValidGitOpsCompatMode(mode string) bool {
+	_, ok := gitOpsCompanionAnnotations[mode]
+	return ok
+}
+
+// GitOpsCompanionAnnotations returns the companion annotations of mode, or the ones listed in override as
+// comma-separated key=value pairs when it is set. An override of "none" adds none.
+func GitOpsCompanionAnnotations(mode, override string) (map[string]string, error) {
+	override = strings.TrimSpace(override)
+	if override == "" {
+		return gitOpsCompanionAnnotations[mode], nil
+	}
+	if override == "none" {
+		return nil, nil
+	}
+	annotations := map[string]string{}
+	for _, item := range strings.Split(override, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("companion annotation %q is not key=value", item)
+		}
+		annotations[key] = strings.TrimSpace(value)
+	}
+	return annotations, nil
+}
+
+// setCompanionAnnotations adds GitOpsCompanionAnnotations to annotations and reports whether any was missing
+// or different.
+func (r *ConfigMapReconciler) setCompanionAnnotations(annotations map[string]string) bool {
+	changed := false
+	for key, value := range r.GitOpsCompanionAnnotations {
+		if existing, ok := annotations[key]; !ok || existing != value {
+			annotations[key] = value
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeCompanionAnnotations removes the GitOpsCompanionAnnotations that still hold the operator's value.
+func (r *ConfigMapReconciler) removeCompanionAnnotations(annotations map[string]string) {
+	for key, value := range r.GitOpsCompanionAnnotations {
+		if annotations[key] == value {
+			delete(annotations, key)
+		}
+	}
+}
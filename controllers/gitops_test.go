@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestGitOpsCompanionAnnotations(t *testing.T) {
+	annotations, err := GitOpsCompanionAnnotations(GitOpsCompatArgoCD, "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"argocd.argoproj.io/compare-options": "IgnoreExtraneous"}, annotations)
+
+	annotations, err = GitOpsCompanionAnnotations(GitOpsCompatFlux, "argocd.argoproj.io/compare-options=ServerSideDiff=true, example.com/owner=ops")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"argocd.argoproj.io/compare-options": "ServerSideDiff=true", "example.com/owner": "ops"}, annotations,
+		"the override replaces the mode's set")
+
+	annotations, err = GitOpsCompanionAnnotations(GitOpsCompatArgoCD, "none")
+	require.NoError(t, err)
+	assert.Empty(t, annotations)
+
+	_, err = GitOpsCompanionAnnotations(GitOpsCompatOff, "IgnoreExtraneous")
+	assert.Error(t, err)
+	assert.False(t, ValidGitOpsCompatMode("spinnaker"))
+}
+
+func TestCompanionAnnotationsFollowManagedBy(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	r := newTestReconciler(t, deploy)
+	r.CleanupReleasedWorkloads = true
+	r.LabelSelector = labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "synapse"})
+	r.GitOpsCompanionAnnotations = map[string]string{"kustomize.toolkit.fluxcd.io/ssa": "Merge"}
+	release := &workloadReleaseReconciler{
+		parent: r,
+		newObj: func() client.Object { return &appsv1.Deployment{} },
+		wrap:   func(obj client.Object) *workload { return deploymentWorkload(obj.(*appsv1.Deployment)) },
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deploy)}
+
+	_, err := r.rolloutWorkloads(ctx, "matrix", "one", logr.Discard())
+	require.NoError(t, err)
+	var current appsv1.Deployment
+	require.NoError(t, r.Get(ctx, req.NamespacedName, &current))
+	assert.Equal(t, "Merge", current.Annotations["kustomize.toolkit.fluxcd.io/ssa"])
+
+	// A workload marked before the companions were configured gets them on the next pass.
+	r.GitOpsCompanionAnnotations["argocd.argoproj.io/compare-options"] = "IgnoreExtraneous"
+	_, err = r.rolloutWorkloads(ctx, "matrix", "one", logr.Discard())
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, req.NamespacedName, &current))
+	assert.Equal(t, "IgnoreExtraneous", current.Annotations["argocd.argoproj.io/compare-options"])
+
+	current.Labels = nil
+	current.Annotations["argocd.argoproj.io/compare-options"] = "ServerSideDiff=true"
+	require.NoError(t, r.Update(ctx, &current))
+	_, err = release.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, req.NamespacedName, &current))
+	assert.NotContains(t, current.Annotations, "kustomize.toolkit.fluxcd.io/ssa")
+	assert.Equal(t, "ServerSideDiff=true", current.Annotations["argocd.argoproj.io/compare-options"], "a value set by someone else is kept")
+}
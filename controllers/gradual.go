@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// GradualRolloutWindowAnnotation on a Namespace overrides the gradual rollout window for it, as a Go
+// duration; "0" rolls out all workloads at once.
+const GradualRolloutWindowAnnotation = "synapse.gen0sec.com/gradual-rollout-window"
+
+// gradualStatePrefix namespaces gradual rollout plans in the state store.
+const gradualStatePrefix = "gradual/"
+
+// gradualPlan spreads the restarts for one hash evenly over the window. It is persisted so the pace
+// survives operator restarts.
+type gradualPlan struct {
+	Hash     string        `json:"hash"`
+	Interval time.Duration `json:"interval"`
+	// Next is the earliest time the next workload may be restarted.
+	Next time.Time `json:"next"`
+}
+
+// gradualWindow returns the rollout window for namespace, from its annotation or the configured default.
+func (r *ConfigMapReconciler) gradualWindow(ctx context.Context, namespace string) time.Duration {
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "failed to read namespace for gradual rollout window", "namespace", namespace)
+		}
+		return r.GradualRolloutWindow
+	}
+	value, ok := ns.Annotations[GradualRolloutWindowAnnotation]
+	if !ok {
+		return r.GradualRolloutWindow
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil {
+		r.event(ns, corev1.EventTypeWarning, "InvalidGradualRolloutWindow", fmt.Sprintf("%s: %v", GradualRolloutWindowAnnotation, err))
+		return r.GradualRolloutWindow
+	}
+	return window
+}
+
+// gradualSlots returns how many of the pending workloads in namespace may be restarted now and, while some
+// remain, how long until the next slot. Without a window every pending workload may restart at once.
+func (r *ConfigMapReconciler) gradualSlots(ctx context.Context, namespace, hash string, pending int, now time.Time) (int, time.Duration, error) {
+	if r.StateStore == nil {
+		return pending, 0, nil
+	}
+	key := gradualStatePrefix + namespace
+	if pending == 0 {
+		return 0, 0, r.StateStore.Delete(ctx, key)
+	}
+	window := r.gradualWindow(ctx, namespace)
+	if window <= 0 {
+		return pending, 0, r.StateStore.Delete(ctx, key)
+	}
+
+	plan := gradualPlan{}
+	raw, found, err := r.StateStore.Get(ctx, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if found {
+		if err := json.Unmarshal(raw, &plan); err != nil {
+			log.FromContext(ctx).Error(err, "discarding unreadable gradual rollout plan", "namespace", namespace)
+			found = false
+		}
+	}
+	if !found || plan.Hash != hash {
+		if pending == 1 {
+			return 1, 0, r.StateStore.Delete(ctx, key)
+		}
+		plan = gradualPlan{Hash: hash, Interval: window / time.Duration(pending), Next: now}
+	}
+	if now.Before(plan.Next) {
+		return 0, plan.Next.Sub(now), nil
+	}
+
+	plan.Next = now.Add(plan.Interval)
+	raw, err = json.Marshal(plan)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := r.StateStore.Put(ctx, key, raw); err != nil {
+		return 0, 0, err
+	}
+	if pending == 1 {
+		return 1, 0, nil
+	}
+	return 1, plan.Interval, nil
+}
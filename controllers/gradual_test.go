@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/state"
+)
+
+func TestGradualSlotsSpreadsRestartsOverWindow(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t)
+	r.StateStore = state.NewMemoryStore()
+	r.GradualRolloutWindow = 30 * time.Minute
+	now := time.Unix(1000, 0)
+
+	allowed, wait, err := r.gradualSlots(ctx, "matrix", "one", 20, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, allowed)
+	assert.Equal(t, 90*time.Second, wait)
+
+	allowed, wait, err = r.gradualSlots(ctx, "matrix", "one", 19, now.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 0, allowed)
+	assert.Equal(t, time.Minute, wait)
+
+	// A reconciler sharing the store, as after an operator restart, keeps the pace.
+	restarted := newTestReconciler(t)
+	restarted.StateStore = r.StateStore
+	restarted.GradualRolloutWindow = r.GradualRolloutWindow
+	allowed, _, err = restarted.gradualSlots(ctx, "matrix", "one", 19, now.Add(90*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, allowed)
+
+	// A new hash starts a new schedule.
+	allowed, wait, err = restarted.gradualSlots(ctx, "matrix", "two", 10, now.Add(100*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, allowed)
+	assert.Equal(t, 3*time.Minute, wait)
+
+	_, _, err = restarted.gradualSlots(ctx, "matrix", "two", 0, now.Add(200*time.Second))
+	require.NoError(t, err)
+	_, found, err := r.StateStore.Get(ctx, gradualStatePrefix+"matrix")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestRolloutWorkloadsDefersOutsideGradualSlot(t *testing.T) {
+	ctx := context.Background()
+	second := newTestDeployment(nil)
+	second.Name = "synapse-worker"
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{GradualRolloutWindowAnnotation: "10m"}}}
+	r := newTestReconciler(t, ns, newTestDeployment(nil), second)
+	r.StateStore = state.NewMemoryStore()
+
+	result, err := r.rolloutWorkloads(ctx, "matrix", "one", logr.Discard())
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+
+	var deployments appsv1.DeploymentList
+	require.NoError(t, r.List(ctx, &deployments, client.InNamespace("matrix")))
+	updated := 0
+	for _, deploy := range deployments.Items {
+		if deploy.Spec.Template.Annotations[testHashAnnotation] == "one" {
+			updated++
+		}
+	}
+	assert.Equal(t, 1, updated)
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// hashCacheEntry holds the digests of the local config sources of one scope at one set of source versions.
+type hashCacheEntry struct {
+	// versions lists the "<kind>/<name>/<uid>/<resourceVersion>" of every source, sorted.
+	versions string
+	digests  []sourceDigest
+}
+
+// localSourceDigests returns the digests of configMaps and secrets, the classified local sources of the
+// namespace or owner group ctx is scoped to. While neither the set of sources nor any of their versions
+// changed since the last call for the scope, the digests of that call are returned without looking at the
+// sources again, and with SecretMetadataOnly without reading the data of the Secrets from the API server.
+func (r *ConfigMapReconciler) localSourceDigests(ctx context.Context, namespace string, configMaps []corev1.ConfigMap, secrets []corev1.Secret) ([]sourceDigest, error) {
+	versions, ok := sourceVersions(configMaps, secrets)
+	if !ok {
+		return r.readSourceDigests(ctx, configMaps, secrets)
+	}
+	scope := r.hashScope(ctx, namespace)
+	if value, ok := r.hashCache.Load(scope); ok {
+		if entry := value.(*hashCacheEntry); entry.versions == versions {
+			hashCacheLookupsTotal.WithLabelValues("hit").Inc()
+			// Debouncing rewrites the digests in place.
+			return slices.Clone(entry.digests), nil
+		}
+	}
+	hashCacheLookupsTotal.WithLabelValues("miss").Inc()
+	digests, err := r.readSourceDigests(ctx, configMaps, secrets)
+	if err != nil {
+		return nil, err
+	}
+	r.hashCache.Store(scope, &hashCacheEntry{versions: versions, digests: slices.Clone(digests)})
+	return digests, nil
+}
+
+// readSourceDigests computes the digests of configMaps and secrets, reading the data of the Secrets first
+// with SecretMetadataOnly.
+func (r *ConfigMapReconciler) readSourceDigests(ctx context.Context, configMaps []corev1.ConfigMap, secrets []corev1.Secret) ([]sourceDigest, error) {
+	secrets, err := r.readSecretData(ctx, secrets)
+	if err != nil {
+		return nil, err
+	}
+	return configSourceDigests(r.digestIndex(), configMaps, secrets, r.configMapKeyFilter(), r.secretKeyFilter()), nil
+}
+
+// hashScope returns the scope of the hash computed for namespace: the namespace, or "<namespace>/<group UID>"
+// with ctx scoped to an owner group.
+func (r *ConfigMapReconciler) hashScope(ctx context.Context, namespace string) string {
+	if group, ok := r.groupScoped(ctx); ok {
+		return namespace + "/" + string(group.uid)
+	}
+	return namespace
+}
+
+// forgetHashScopes drops the cached digests of namespace and of each of its owner groups.
+func (r *ConfigMapReconciler) forgetHashScopes(namespace string) {
+	r.hashCache.Range(func(key, _ any) bool {
+		if scope := key.(string); scope == namespace || strings.HasPrefix(scope, namespace+"/") {
+			r.hashCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// sourceVersions fingerprints the identity and version of every source. It reports false when a source has
+// no resourceVersion, as objects not read from the API server do, and cannot be told apart from its edits.
+func sourceVersions(configMaps []corev1.ConfigMap, secrets []corev1.Secret) (string, bool) {
+	versions := make([]string, 0, len(configMaps)+len(secrets))
+	add := func(kind string, obj client.Object) bool {
+		if obj.GetResourceVersion() == "" {
+			return false
+		}
+		versions = append(versions, kind+"/"+obj.GetName()+"/"+string(obj.GetUID())+"/"+obj.GetResourceVersion())
+		return true
+	}
+	for i := range configMaps {
+		if !add("configmap", &configMaps[i]) {
+			return "", false
+		}
+	}
+	for i := range secrets {
+		if !add("secret", &secrets[i]) {
+			return "", false
+		}
+	}
+	slices.Sort(versions)
+	return strings.Join(versions, "\n"), true
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestHashCacheSkipsUnchangedSources(t *testing.T) {
+	ctx := context.Background()
+	cm := newTestConfigMap("homeserver", nil, nil, "a")
+	r := newTestReconciler(t, cm, newTestSecret("signing-key", "one"))
+	hits := func() float64 { return testutil.ToFloat64(hashCacheLookupsTotal.WithLabelValues("hit")) }
+
+	first, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	before := hits()
+	again, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+	assert.Equal(t, before+1, hits())
+
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cm), cm))
+	cm.Data["data"] = "b"
+	require.NoError(t, r.Update(ctx, cm))
+	changed, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, changed)
+	assert.Equal(t, before+1, hits())
+}
+
+func TestSourceVersionsNeedResourceVersions(t *testing.T) {
+	cm := newTestConfigMap("homeserver", nil, nil, "a")
+	_, ok := sourceVersions(nil, nil)
+	assert.True(t, ok)
+	cm.ResourceVersion = ""
+	_, ok = sourceVersions([]corev1.ConfigMap{*cm}, nil)
+	assert.False(t, ok)
+}
+
+func TestHashCacheForgetsDeletedNamespaces(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestConfigMap("homeserver", nil, nil, "a"))
+	_, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	r.hashCache.Store("matrix/group-uid", &hashCacheEntry{})
+	r.hashCache.Store("matrix-staging", &hashCacheEntry{})
+	r.hashCache.Store("element", &hashCacheEntry{})
+	cached := func(scope string) bool {
+		_, ok := r.hashCache.Load(scope)
+		return ok
+	}
+	require.True(t, cached("matrix"))
+
+	r.namespaceDeleted(toolscache.DeletedFinalStateUnknown{Key: "matrix", Obj: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix"}}})
+	assert.False(t, cached("matrix"))
+	assert.False(t, cached("matrix/group-uid"), "the owner groups of the namespace go with it")
+	assert.True(t, cached("matrix-staging"))
+	assert.True(t, cached("element"))
+
+	r.namespaceDeleted(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "element"}})
+	assert.False(t, cached("element"))
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// HashInjectionPath is where the webhook injecting the config hash into new workloads is served.
+const HashInjectionPath = "/mutate-workloads-config-hash"
+
+// hashInjectionSyncTimeout bounds how long an admission request waits for the cache to sync after a start.
+const hashInjectionSyncTimeout = 5 * time.Second
+
+// setupHashInjection serves the hash injection webhook on the manager's webhook server. It reads from the
+// manager's cache and shared indexes, like the controllers.
+func (r *ConfigMapReconciler) setupHashInjection(mgr ctrl.Manager) {
+	mgr.GetWebhookServer().Register(HashInjectionPath, &webhook.Admission{Handler: &hashInjector{r: r, cacheSynced: mgr.GetCache().WaitForCacheSync}})
+}
+
+// hashInjector records the current combined hash on targeted workloads as they are created. Their pods
+// start on the current config anyway, so without it the first reconcile would patch the hash in and roll
+// them a second time.
+type hashInjector struct {
+	r *ConfigMapReconciler
+	// cacheSynced waits for the cache to sync. The webhook server starts before the cache, and a hash
+	// computed from a partially filled cache would differ from the one the controllers roll out.
+	cacheSynced func(context.Context) bool
+}
+
+// Handle never rejects a workload: when the hash cannot be injected the workload is admitted unchanged and
+// the next reconcile rolls it out as before.
+func (h *hashInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("")
+	}
+	logger := log.FromContext(ctx).WithValues("kind", req.Kind.Kind, "namespace", req.Namespace, "name", req.Name)
+
+	var w *workload
+	switch req.Kind.Kind {
+	case "Deployment":
+		deploy := &appsv1.Deployment{}
+		if err := json.Unmarshal(req.Object.Raw, deploy); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		w = deploymentWorkload(deploy)
+	case "DaemonSet":
+		daemonSet := &appsv1.DaemonSet{}
+		if err := json.Unmarshal(req.Object.Raw, daemonSet); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		w = daemonSetWorkload(daemonSet)
+	case "StatefulSet":
+		statefulSet := &appsv1.StatefulSet{}
+		if err := json.Unmarshal(req.Object.Raw, statefulSet); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		w = statefulSetWorkload(statefulSet)
+	default:
+		return admission.Allowed("not a workload the operator rolls out")
+	}
+	if !h.r.targets(w) {
+		return admission.Allowed("not targeted")
+	}
+	_, strategy, err := h.r.strategyFor(w)
+	if err != nil {
+		// The first reconcile reports the invalid strategy on the created workload.
+		return admission.Allowed("invalid restart strategy")
+	}
+	if h.cacheSynced != nil {
+		syncCtx, cancel := context.WithTimeout(ctx, hashInjectionSyncTimeout)
+		synced := h.cacheSynced(syncCtx)
+		cancel()
+		if !synced {
+			logger.Info("Cache not synced yet; admitting new workload without a config hash")
+			return admission.Allowed("cache not synced")
+		}
+	}
+	hash, err := h.r.workloadHash(ctx, req.Namespace, w)
+	if err != nil {
+		logger.Error(err, "failed to compute config hash for new workload; admitting it without one")
+		return admission.Allowed("config hash unavailable")
+	}
+	if hash == "" {
+		return admission.Allowed("no config sources")
+	}
+
+	strategy.inject(h.r, w, hash)
+	annotations := w.obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[ManagedByAnnotation] = managedByValue
+	h.r.setCompanionAnnotations(annotations)
+	w.obj.SetAnnotations(annotations)
+	mutated, err := json.Marshal(w.obj)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	logger.Info("Injected config hash into new workload", "configHash", hash)
+	return admission.PatchResponseFromRaw(req.Object.Raw, mutated)
+}
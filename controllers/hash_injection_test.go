@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func createRequest(t *testing.T, deploy *appsv1.Deployment) admission.Request {
+	raw, err := json.Marshal(deploy)
+	require.NoError(t, err)
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Namespace: deploy.Namespace,
+		Name:      deploy.Name,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}
+
+func TestHashInjectorInjectsCurrentHash(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	h := &hashInjector{r: r}
+
+	resp := h.Handle(ctx, createRequest(t, newTestDeployment(nil)))
+	require.True(t, resp.Allowed)
+	paths := map[string]any{}
+	for _, op := range resp.Patches {
+		paths[op.Path] = op.Value
+	}
+	assert.Contains(t, paths, "/spec/template/metadata/annotations")
+	assert.Equal(t, map[string]any{testHashAnnotation: hash}, paths["/spec/template/metadata/annotations"])
+
+	r.RestartStrategy = StrategyEvict
+	resp = h.Handle(ctx, createRequest(t, newTestDeployment(nil)))
+	require.True(t, resp.Allowed)
+	paths = map[string]any{}
+	for _, op := range resp.Patches {
+		paths[op.Path] = op.Value
+	}
+	assert.Equal(t, map[string]any{testHashAnnotation: hash, ManagedByAnnotation: managedByValue}, paths["/metadata/annotations"])
+	assert.NotContains(t, paths, "/spec/template/metadata/annotations")
+}
+
+func TestHashInjectorSkipsUntargetedWorkloads(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	r.LabelSelector = labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "synapse"})
+	deploy := newTestDeployment(nil)
+	deploy.Labels = map[string]string{"app.kubernetes.io/name": "element"}
+
+	resp := (&hashInjector{r: r}).Handle(ctx, createRequest(t, deploy))
+	assert.True(t, resp.Allowed)
+	assert.Empty(t, resp.Patches)
+}
+
+func TestHashInjectorWaitsForCacheSync(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	h := &hashInjector{r: r, cacheSynced: func(context.Context) bool { return false }}
+
+	resp := h.Handle(ctx, createRequest(t, newTestDeployment(nil)))
+	require.True(t, resp.Allowed)
+	assert.Empty(t, resp.Patches, "a hash from an unsynced cache is never injected")
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"synapse-operator/state"
+)
+
+// lastKnownHashPrefix keys the lastKnownHash of each namespace, or owner group.
+const lastKnownHashPrefix = "hashes/"
+
+// lastKnownHash is the hash a namespace, or owner group, was last rolled out to in full, with a digest of
+// the versions of the sources it was computed from. The next operator process compares it with the sources
+// it finds to tell a config change made while it was down from its own restart.
+type lastKnownHash struct {
+	Hash     string `json:"hash"`
+	Versions string `json:"versions"`
+	// Adopted maps the key of each workload that kept its applied hash after a startup to that hash.
+	Adopted map[string]string `json:"adopted,omitempty"`
+}
+
+func (h lastKnownHash) equal(other lastKnownHash) bool {
+	return h.Hash == other.Hash && h.Versions == other.Versions && maps.Equal(h.Adopted, other.Adopted)
+}
+
+// hashedSourceVersions is sourceVersions hashed down to a fixed size for lastKnownHash.
+func hashedSourceVersions(configMaps []corev1.ConfigMap, secrets []corev1.Secret) (string, bool) {
+	versions, ok := sourceVersions(configMaps, secrets)
+	if !ok {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(versions))
+	return hex.EncodeToString(sum[:]), true
+}
+
+// hashStore returns HashStore, or StateStore without one.
+func (r *ConfigMapReconciler) hashStore() state.Store {
+	if r.HashStore != nil {
+		return r.HashStore
+	}
+	return r.StateStore
+}
+
+func (r *ConfigMapReconciler) loadLastKnownHash(ctx context.Context, scope string) (lastKnownHash, bool, error) {
+	var record lastKnownHash
+	store := r.hashStore()
+	if store == nil {
+		return record, false, nil
+	}
+	raw, found, err := store.Get(ctx, lastKnownHashPrefix+scope)
+	if err != nil || !found {
+		return record, false, err
+	}
+	if err := json.Unmarshal(raw, &record); err != nil {
+		log.FromContext(ctx).Error(err, "discarding unreadable last-known hash", "scope", scope)
+		return record, false, nil
+	}
+	return record, true, nil
+}
+
+// recordLastKnownHash stores the hash of pass as the lastKnownHash of its scope once every targeted workload
+// runs it, or kept its applied hash on startup. Nothing is stored while a workload is held back or deferred,
+// so a hash only becomes last-known once it was rolled out, nor when the sources carry no versions to compare.
+func (r *ConfigMapReconciler) recordLastKnownHash(ctx context.Context, pass *rolloutPass) error {
+	store := r.hashStore()
+	if store == nil || pass.Hash == "" || pass.State.held || len(pass.State.applied) < len(pass.State.planned) {
+		return nil
+	}
+	versions, ok := hashedSourceVersions(pass.State.configMaps, pass.State.secrets)
+	if !ok {
+		return nil
+	}
+	scope := r.hashScope(ctx, pass.Namespace)
+	record := lastKnownHash{Hash: pass.Hash, Versions: versions, Adopted: pass.State.adopted}
+	if last, ok := r.lastKnownHashes.Load(scope); ok && last.(lastKnownHash).equal(record) {
+		return nil
+	}
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := store.Put(ctx, lastKnownHashPrefix+scope, raw); err != nil {
+		return err
+	}
+	r.lastKnownHashes.Store(scope, record)
+	return nil
+}
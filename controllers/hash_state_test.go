@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"synapse-operator/state"
+)
+
+func TestRecordLastKnownHash(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	deploy.Spec.Strategy.Type = appsv1.RecreateDeploymentStrategyType
+	r := newTestReconciler(t, newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"), deploy)
+	r.HashStore = state.NewMemoryStore()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	_, found, err := r.loadLastKnownHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.False(t, found, "a held restart leaves the hash unknown")
+
+	r.AllowRecreateRestarts = true
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	record, found, err := r.loadLastKnownHash(ctx, "matrix")
+	require.NoError(t, err)
+	require.True(t, found)
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, hash, record.Hash)
+	assert.NotEmpty(t, record.Versions)
+	assert.Empty(t, record.Adopted)
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/audit"
+)
+
+// Release metadata Helm 3 records on every object it installs.
+const (
+	helmReleaseNameAnnotation      = "meta.helm.sh/release-name"
+	helmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+	// helmFieldManager is the field manager of Helm's writes.
+	helmFieldManager = "helm"
+)
+
+// HelmCoalescedHashAnnotation records, on workload metadata, a config hash the operator did not roll out
+// because the Helm upgrade that changed the config also changed the pod template, so the pods Helm's rollout
+// starts already run it.
+const HelmCoalescedHashAnnotation = "synapse.gen0sec.com/helm-coalesced-hash"
+
+// helmRelease returns "<namespace>/<name>" of the Helm release obj belongs to, or "" when Helm does not
+// manage it.
+func helmRelease(obj client.Object) string {
+	annotations := obj.GetAnnotations()
+	name := annotations[helmReleaseNameAnnotation]
+	if name == "" {
+		return ""
+	}
+	namespace := annotations[helmReleaseNamespaceAnnotation]
+	if namespace == "" {
+		namespace = obj.GetNamespace()
+	}
+	return namespace + "/" + name
+}
+
+// lastWrite returns the time and field manager of the newest write to obj recorded in its managed fields.
+// With onlyManager set, only the writes of that manager count.
+func lastWrite(obj client.Object, onlyManager string) (time.Time, string) {
+	var at time.Time
+	var manager string
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Time == nil || (onlyManager != "" && entry.Manager != onlyManager) {
+			continue
+		}
+		if entry.Time.After(at) {
+			at, manager = entry.Time.Time, entry.Manager
+		}
+	}
+	return at, manager
+}
+
+// helmUpgradeRolled reports whether the newest change to the config sources was written by the Helm release
+// of w within HelmCoalesceWindow of Helm's latest write to w. The upgrade then changed the pod template as
+// well, and the pods it rolls already read the new config, so patching the hash would roll them a second
+// time. A newer change from anyone else, or to a source of another release, is rolled out as usual.
+func (r *ConfigMapReconciler) helmUpgradeRolled(w *workload, configMaps []corev1.ConfigMap, secrets []corev1.Secret) bool {
+	release := helmRelease(w.obj)
+	if r.HelmCoalesceWindow <= 0 || release == "" {
+		return false
+	}
+	workloadAt, _ := lastWrite(w.obj, helmFieldManager)
+	if workloadAt.IsZero() {
+		return false
+	}
+	var newest client.Object
+	var newestAt time.Time
+	var newestManager string
+	consider := func(obj client.Object) {
+		if at, manager := lastWrite(obj, ""); newest == nil || at.After(newestAt) {
+			newest, newestAt, newestManager = obj, at, manager
+		}
+	}
+	for i := range configMaps {
+		consider(&configMaps[i])
+	}
+	for i := range secrets {
+		consider(&secrets[i])
+	}
+	if newest == nil || newestAt.IsZero() || newestManager != helmFieldManager || helmRelease(newest) != release {
+		return false
+	}
+	gap := workloadAt.Sub(newestAt)
+	if gap < 0 {
+		gap = -gap
+	}
+	return gap <= r.HelmCoalesceWindow
+}
+
+// coalesceHelmUpgrade reports whether w is left alone for hash because a Helm upgrade already rolled it onto
+// that config, recording the hash in HelmCoalescedHashAnnotation the first time so later passes agree.
+func (r *ConfigMapReconciler) coalesceHelmUpgrade(ctx context.Context, pass *rolloutPass, w *workload, hash string) (bool, error) {
+	annotations := w.obj.GetAnnotations()
+	if annotations[HelmCoalescedHashAnnotation] == hash {
+		return true, nil
+	}
+	if !r.helmUpgradeRolled(w, pass.State.configMaps, pass.State.secrets) {
+		return false, nil
+	}
+	original := w.obj.DeepCopyObject().(client.Object)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[HelmCoalescedHashAnnotation] = hash
+	w.obj.SetAnnotations(annotations)
+	if err := r.Patch(ctx, w.obj, client.MergeFrom(original)); err != nil {
+		return false, err
+	}
+	release := helmRelease(w.obj)
+	pass.Logger.Info("Skipping restart already done by a Helm upgrade", w.logKey(), w.obj.GetName(), "release", release, "configHash", hash)
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "helm-upgrade", Workload: w.key(), Detail: "pod template changed by Helm release " + release + " with the config"})
+	r.event(w.obj, corev1.EventTypeNormal, "HelmUpgradeCoalesced",
+		fmt.Sprintf("Config hash %s was not applied: Helm release %s changed the config and the pod template together, so its rollout already uses the new config", hash, release))
+	return true, nil
+}
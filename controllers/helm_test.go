@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// helmWrite marks obj as belonging to the synapse release, last written by manager at at.
+func helmWrite(obj client.Object, manager string, at time.Time) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[helmReleaseNameAnnotation] = "synapse"
+	annotations[helmReleaseNamespaceAnnotation] = "matrix"
+	obj.SetAnnotations(annotations)
+	apiVersion := "v1"
+	if _, ok := obj.(*appsv1.Deployment); ok {
+		apiVersion = "apps/v1"
+	}
+	entry := func(manager string, at time.Time) metav1.ManagedFieldsEntry {
+		return metav1.ManagedFieldsEntry{
+			Manager:    manager,
+			Operation:  metav1.ManagedFieldsOperationUpdate,
+			APIVersion: apiVersion,
+			Time:       &metav1.Time{Time: at},
+			FieldsType: "FieldsV1",
+			FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{}}`)},
+		}
+	}
+	obj.SetManagedFields([]metav1.ManagedFieldsEntry{entry("kubectl-client-side-apply", at.Add(-time.Hour)), entry(manager, at)})
+}
+
+func TestHelmUpgradeRolled(t *testing.T) {
+	upgradedAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	r := &ConfigMapReconciler{HelmCoalesceWindow: time.Minute}
+	deploy := newTestDeployment(nil)
+	helmWrite(deploy, helmFieldManager, upgradedAt)
+	w := deploymentWorkload(deploy)
+	homeserver := newTestConfigMap("homeserver", nil, nil, "a")
+	helmWrite(homeserver, helmFieldManager, upgradedAt.Add(-2*time.Second))
+	signing := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "signing", Namespace: "matrix"}}
+	signing.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: "vault-agent", Time: &metav1.Time{Time: upgradedAt.Add(-time.Hour)}}})
+
+	assert.True(t, r.helmUpgradeRolled(w, []corev1.ConfigMap{*homeserver}, []corev1.Secret{*signing}))
+
+	edited := homeserver.DeepCopy()
+	helmWrite(edited, "kubectl-edit", upgradedAt.Add(-2*time.Second))
+	assert.False(t, r.helmUpgradeRolled(w, []corev1.ConfigMap{*edited}, nil), "a change Helm did not write restarts")
+
+	assert.False(t, r.helmUpgradeRolled(w, []corev1.ConfigMap{*homeserver}, []corev1.Secret{func() corev1.Secret {
+		rotated := signing.DeepCopy()
+		rotated.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: "vault-agent", Time: &metav1.Time{Time: upgradedAt.Add(time.Second)}}})
+		return *rotated
+	}()}), "a newer change from elsewhere restarts")
+
+	other := homeserver.DeepCopy()
+	other.Annotations[helmReleaseNameAnnotation] = "element"
+	assert.False(t, r.helmUpgradeRolled(w, []corev1.ConfigMap{*other}, nil), "a source of another release restarts")
+
+	stale := homeserver.DeepCopy()
+	helmWrite(stale, helmFieldManager, upgradedAt.Add(-time.Hour))
+	assert.False(t, r.helmUpgradeRolled(w, []corev1.ConfigMap{*stale}, nil), "a config change outside the window restarts")
+}
+
+func TestHelmUpgradeIsNotRolledTwice(t *testing.T) {
+	ctx := context.Background()
+	upgradedAt := time.Now().Add(-time.Minute)
+	deploy := newTestDeployment(nil)
+	homeserver := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	r := newTestReconciler(t, deploy, homeserver)
+	r.HelmCoalesceWindow = time.Minute
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+
+	// The fake client drops managed fields, so the upgrade is seen on the objects as Helm left them.
+	helmWrite(deploy, helmFieldManager, upgradedAt)
+	helmWrite(homeserver, helmFieldManager, upgradedAt)
+	pass := &rolloutPass{Namespace: "matrix", Logger: logr.Discard(), Hash: hash}
+	pass.State.configMaps = []corev1.ConfigMap{*homeserver}
+	coalesced, err := r.coalesceHelmUpgrade(ctx, pass, deploymentWorkload(deploy), hash)
+	require.NoError(t, err)
+	assert.True(t, coalesced)
+
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	var current appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), &current))
+	assert.Equal(t, hash, current.Annotations[HelmCoalescedHashAnnotation])
+	assert.Empty(t, current.Spec.Template.Annotations[testHashAnnotation], "the pod template is left to Helm's rollout")
+}
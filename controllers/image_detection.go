@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ValidImagePattern reports whether pattern is a well-formed --detect-by-image glob.
+func ValidImagePattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// imageMatches reports whether image matches the glob pattern. The image is also tried without its
+// registry host and without Docker Hub's implicit "library/" prefix, so "matrixdotorg/synapse*" matches
+// "docker.io/matrixdotorg/synapse:v1.120.0".
+func imageMatches(pattern, image string) bool {
+	candidates := []string{image}
+	if host, rest, ok := strings.Cut(image, "/"); ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		candidates = append(candidates, rest)
+		if library, ok := strings.CutPrefix(rest, "library/"); ok {
+			candidates = append(candidates, library)
+		}
+	}
+	for _, candidate := range candidates {
+		if matched, _ := path.Match(pattern, candidate); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// runsDetectedImage reports whether any container of w runs an image matching DetectByImage.
+func (r *ConfigMapReconciler) runsDetectedImage(w *workload) bool {
+	spec := &w.template.Spec
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			if imageMatches(r.DetectByImage, container.Image) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// workloadListOptions returns the options selecting candidate workloads in namespace. With image detection
+// every workload is a candidate and filtered by image afterwards.
+func (r *ConfigMapReconciler) workloadListOptions(namespace string) []client.ListOption {
+	if r.DetectByImage != "" {
+		return []client.ListOption{client.InNamespace(namespace)}
+	}
+	return []client.ListOption{client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: r.workloadSelector()}}
+}
+
+// listConfigSources returns the ConfigMaps and Secrets in namespace that feed its combined hash: those
+// matching the label or annotation selector, or with image detection those the detected workloads mount or
+// read env from.
+//
+// With GroupByOwner or GroupByComponent and ctx scoped to a group, only the sources of that group are
+// returned.
+func (r *ConfigMapReconciler) listConfigSources(ctx context.Context, namespace string) ([]corev1.ConfigMap, []corev1.Secret, error) {
+	configMaps, secrets, err := r.listSelectedSources(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	group, ok := r.groupScoped(ctx)
+	if !ok {
+		return configMaps, secrets, nil
+	}
+	configMaps = slices.DeleteFunc(configMaps, func(cm corev1.ConfigMap) bool { return !r.inSourceGroup(group, &cm) })
+	secrets = slices.DeleteFunc(secrets, func(secret corev1.Secret) bool { return !r.inSourceGroup(group, &secret) })
+	return configMaps, secrets, nil
+}
+
+// listSelectedSources returns the config sources of namespace whatever their owner group. With
+// SecretMetadataOnly the Secrets hold digests of their values, see readSecretData.
+func (r *ConfigMapReconciler) listSelectedSources(ctx context.Context, namespace string) ([]corev1.ConfigMap, []corev1.Secret, error) {
+	return r.listCachedSources(ctx, namespace)
+}
+
+// listCachedSources returns the config sources of namespace as the cache holds them. With
+// ImmutableConfigSources, only the latest revision of each immutable ConfigMap series is a source.
+func (r *ConfigMapReconciler) listCachedSources(ctx context.Context, namespace string) ([]corev1.ConfigMap, []corev1.Secret, error) {
+	configMaps, secrets, err := r.listMatchingSources(ctx, namespace)
+	if err != nil || !r.ImmutableConfigSources {
+		return configMaps, secrets, err
+	}
+	latest, _ := splitConfigRevisions(configMaps)
+	return latest, secrets, nil
+}
+
+// listMatchingSources returns every ConfigMap and Secret of namespace the selectors pick, including
+// superseded immutable revisions.
+func (r *ConfigMapReconciler) listMatchingSources(ctx context.Context, namespace string) ([]corev1.ConfigMap, []corev1.Secret, error) {
+	opts := []client.ListOption{client.InNamespace(namespace)}
+	// Annotations cannot be selected on by the API server; with an annotation selector list everything and
+	// filter below. Image detection filters by reference below as well.
+	if r.DetectByImage == "" && r.AnnotationSelector == nil {
+		opts = append(opts, client.MatchingLabelsSelector{Selector: r.sourceSelector()})
+	}
+	configMaps := &corev1.ConfigMapList{}
+	if err := r.List(ctx, configMaps, opts...); err != nil {
+		return nil, nil, err
+	}
+	secrets := &corev1.SecretList{}
+	if !r.IgnoreSecrets {
+		if err := r.List(ctx, secrets, opts...); err != nil {
+			return nil, nil, err
+		}
+	}
+	// The immutable copies of the versioned restart strategy stand in for their sources in pod templates but
+	// are never sources themselves.
+	configMaps.Items = slices.DeleteFunc(configMaps.Items, func(cm corev1.ConfigMap) bool { return isVersionedCopy(&cm) })
+	secrets.Items = slices.DeleteFunc(secrets.Items, func(secret corev1.Secret) bool { return isVersionedCopy(&secret) })
+	if r.DetectByImage == "" {
+		if r.AnnotationSelector == nil {
+			return configMaps.Items, secrets.Items, nil
+		}
+		cms := slices.DeleteFunc(configMaps.Items, func(cm corev1.ConfigMap) bool { return !r.selectsConfigSource(&cm) })
+		secretItems := slices.DeleteFunc(secrets.Items, func(secret corev1.Secret) bool { return !r.selectsConfigSource(&secret) })
+		return cms, secretItems, nil
+	}
+
+	referencedConfigMaps, referencedSecrets, err := r.referencedSourceNames(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	var cms []corev1.ConfigMap
+	for _, cm := range configMaps.Items {
+		if _, ok := referencedConfigMaps[cm.Name]; ok {
+			cms = append(cms, cm)
+		}
+	}
+	var secretItems []corev1.Secret
+	for _, secret := range secrets.Items {
+		if _, ok := referencedSecrets[secret.Name]; ok {
+			secretItems = append(secretItems, secret)
+		}
+	}
+	return cms, secretItems, nil
+}
+
+// referencedSourceNames returns the names of the ConfigMaps and Secrets referenced by the detected
+// workloads in namespace.
+func (r *ConfigMapReconciler) referencedSourceNames(ctx context.Context, namespace string) (map[string]struct{}, map[string]struct{}, error) {
+	workloads, err := r.listWorkloads(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	configMaps := map[string]struct{}{}
+	secrets := map[string]struct{}{}
+	for _, w := range workloads {
+		collectPodSpecSources(&w.template.Spec, configMaps, secrets)
+		collectVersionedSources(w.template, configMaps, secrets)
+	}
+	return configMaps, secrets, nil
+}
+
+// selectsConfigSource reports whether obj matches the label selector or, if set, the annotation selector.
+func (r *ConfigMapReconciler) selectsConfigSource(obj client.Object) bool {
+	if isVersionedCopy(obj) {
+		return false
+	}
+	if r.sourceSelector().Matches(labels.Set(obj.GetLabels())) {
+		return true
+	}
+	return r.AnnotationSelector != nil && r.AnnotationSelector.Matches(labels.Set(obj.GetAnnotations()))
+}
+
+// configSourcePredicate admits events for objects that are config sources: those matching the label or
+// annotation selector, or with image detection those referenced by a detected workload in their namespace.
+func (r *ConfigMapReconciler) configSourcePredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		if obj == nil {
+			return false
+		}
+		if r.DetectByImage == "" {
+			return r.selectsConfigSource(obj)
+		}
+		ctx := context.Background()
+		workloads, err := r.workloadsReferencing(ctx, obj)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "failed to look up workloads referencing config source", "source", client.ObjectKeyFromObject(obj))
+			return false
+		}
+		return len(workloads) > 0
+	})
+}
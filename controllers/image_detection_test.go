@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestImageMatches(t *testing.T) {
+	for image, want := range map[string]bool{
+		"matrixdotorg/synapse:v1.120.0":                   true,
+		"docker.io/matrixdotorg/synapse:v1.120.0":         true,
+		"ghcr.io/element-hq/synapse:v1.120.0":             false,
+		"matrixdotorg/synapse@sha256:0123":                true,
+		"registry.local:5000/matrixdotorg/synapse-worker": true,
+		"nginx:1.27": false,
+	} {
+		assert.Equal(t, want, imageMatches("matrixdotorg/synapse*", image), image)
+	}
+	assert.True(t, imageMatches("nginx*", "docker.io/library/nginx:1.27"))
+	assert.False(t, ValidImagePattern("matrixdotorg/[synapse"))
+}
+
+func TestDetectByImageTargetsReferencedSources(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	deploy.Labels = nil
+	deploy.Spec.Template.Spec.Containers = []corev1.Container{{Name: "synapse", Image: "docker.io/matrixdotorg/synapse:v1.120.0"}}
+	deploy.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name:         "config",
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "homeserver"}}},
+	}}
+	other := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "matrix", Labels: map[string]string{"app.kubernetes.io/name": "synapse"}},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.27"}},
+		}}},
+	}
+	r := newTestReconciler(t, deploy, other,
+		newTestConfigMap("homeserver", nil, nil, "a"),
+		newTestConfigMap("unrelated", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "b"))
+	r.DetectByImage = "matrixdotorg/synapse*"
+
+	workloads, err := r.listWorkloads(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, workloads, 1)
+	assert.Equal(t, "deployment/synapse", workloads[0].key())
+
+	configMaps, _, err := r.listConfigSources(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, configMaps, 1)
+	assert.Equal(t, "homeserver", configMaps[0].Name)
+
+	isConfigSource := r.configSourcePredicate()
+	assert.True(t, isConfigSource.Generic(genericEvent(newTestConfigMap("homeserver", nil, nil, ""))))
+	assert.False(t, isConfigSource.Generic(genericEvent(newTestConfigMap("unrelated", nil, nil, ""))))
+}
+
+func TestAnnotationSelectorAlsoSelectsSources(t *testing.T) {
+	ctx := context.Background()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "signing-key", Namespace: "matrix", Annotations: map[string]string{"synapse.gen0sec.com/watch": "true"}},
+		Data:       map[string][]byte{"key": []byte("k")},
+	}
+	r := newTestReconciler(t, secret,
+		newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"),
+		newTestConfigMap("helm-owned", map[string]string{"app.kubernetes.io/name": "other"}, map[string]string{"synapse.gen0sec.com/watch": "true"}, "b"),
+		newTestConfigMap("unrelated", nil, map[string]string{"synapse.gen0sec.com/watch": "false"}, "c"))
+	r.LabelSelector = labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "synapse"})
+	var err error
+	r.AnnotationSelector, err = labels.Parse("synapse.gen0sec.com/watch=true")
+	require.NoError(t, err)
+
+	configMaps, secrets, err := r.listConfigSources(ctx, "matrix")
+	require.NoError(t, err)
+	names := []string{}
+	for _, cm := range configMaps {
+		names = append(names, cm.Name)
+	}
+	assert.ElementsMatch(t, []string{"homeserver", "helm-owned"}, names)
+	require.Len(t, secrets, 1)
+	assert.Equal(t, "signing-key", secrets[0].Name)
+
+	isConfigSource := r.configSourcePredicate()
+	assert.True(t, isConfigSource.Generic(genericEvent(newTestConfigMap("helm-owned", nil, map[string]string{"synapse.gen0sec.com/watch": "true"}, ""))))
+	assert.False(t, isConfigSource.Generic(genericEvent(newTestConfigMap("unrelated", nil, map[string]string{"synapse.gen0sec.com/watch": "false"}, ""))))
+}
+
+func genericEvent(cm *corev1.ConfigMap) event.GenericEvent {
+	return event.GenericEvent{Object: cm}
+}
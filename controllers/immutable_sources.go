@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ConfigSeriesLabel names the series an immutable ConfigMap is a revision of, such as synapse-config for
+// synapse-config-<rev>. Names alone cannot tell revisions from unrelated ConfigMaps like worker-1 and worker-2.
+const ConfigSeriesLabel = "synapse.gen0sec.com/config-series"
+
+// DefaultConfigRevisionsRetention is the number of revisions of each series kept by default.
+const DefaultConfigRevisionsRetention = 3
+
+// configSeries returns the series of cm, or "" if cm is mutable or in no series, and so is a source of its own.
+func configSeries(cm *corev1.ConfigMap) string {
+	if cm.Immutable == nil || !*cm.Immutable {
+		return ""
+	}
+	return cm.Labels[ConfigSeriesLabel]
+}
+
+// configRevisions groups the immutable ConfigMaps among configMaps by series, newest first by creation time,
+// then by name.
+func configRevisions(configMaps []corev1.ConfigMap) map[string][]corev1.ConfigMap {
+	series := map[string][]corev1.ConfigMap{}
+	for _, cm := range configMaps {
+		if name := configSeries(&cm); name != "" {
+			series[name] = append(series[name], cm)
+		}
+	}
+	for _, revisions := range series {
+		sort.Slice(revisions, func(i, j int) bool {
+			ti, tj := revisions[i].CreationTimestamp, revisions[j].CreationTimestamp
+			if !ti.Equal(&tj) {
+				return tj.Before(&ti)
+			}
+			return revisions[i].Name > revisions[j].Name
+		})
+	}
+	return series
+}
+
+// splitConfigRevisions splits configMaps into the latest revision of each immutable series, along with every
+// mutable ConfigMap, and the superseded revisions. Creating a revision therefore changes the config hash while
+// deleting a superseded one does not, and deleting the latest falls back to the previous revision.
+func splitConfigRevisions(configMaps []corev1.ConfigMap) ([]corev1.ConfigMap, []corev1.ConfigMap) {
+	latest := map[string]struct{}{}
+	for _, revisions := range configRevisions(configMaps) {
+		latest[revisions[0].Name] = struct{}{}
+	}
+	var current, superseded []corev1.ConfigMap
+	for _, cm := range configMaps {
+		if _, ok := latest[cm.Name]; ok || configSeries(&cm) == "" {
+			current = append(current, cm)
+		} else {
+			superseded = append(superseded, cm)
+		}
+	}
+	return current, superseded
+}
+
+// pruneSupersededConfigSources deletes the revisions of each series in namespace beyond the
+// ConfigRevisionsRetention newest, keeping any revision a workload, ReplicaSet or Pod in the namespace still
+// references, so `kubectl rollout undo` and pods recreated from an old ReplicaSet find their config. A
+// retention of zero keeps every revision.
+func (r *ConfigMapReconciler) pruneSupersededConfigSources(ctx context.Context, namespace string) error {
+	if !r.ImmutableConfigSources || !r.PruneSupersededConfigSources || r.ConfigRevisionsRetention <= 0 {
+		return nil
+	}
+	configMaps, _, err := r.listMatchingSources(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	var stale []corev1.ConfigMap
+	for _, revisions := range configRevisions(configMaps) {
+		stale = append(stale, revisions[min(len(revisions), r.ConfigRevisionsRetention):]...)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	referenced, _, err := r.configRevisionRefs(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Name < stale[j].Name })
+	for i := range stale {
+		cm := &stale[i]
+		if _, ok := referenced[cm.Name]; ok {
+			continue
+		}
+		if err := r.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		log.FromContext(ctx).Info("pruned superseded config revision", "configMap", cm.Name, "series", configSeries(cm))
+	}
+	return nil
+}
+
+// configRevisionRefs returns the names of the ConfigMaps and Secrets referenced by any workload, ReplicaSet or
+// Pod in namespace, so revisions and versioned copies an old ReplicaSet runs on survive for `kubectl rollout
+// undo`. ReplicaSets and Pods are read from the API server, which the manager does not cache them from.
+func (r *ConfigMapReconciler) configRevisionRefs(ctx context.Context, namespace string) (map[string]struct{}, map[string]struct{}, error) {
+	configMaps, secrets, err := r.namespaceSourceRefs(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	replicaSets := &appsv1.ReplicaSetList{}
+	if err := r.reader().List(ctx, replicaSets, client.InNamespace(namespace)); err != nil {
+		return nil, nil, err
+	}
+	for i := range replicaSets.Items {
+		collectPodSpecSources(&replicaSets.Items[i].Spec.Template.Spec, configMaps, secrets)
+	}
+	pods := &corev1.PodList{}
+	if err := r.reader().List(ctx, pods, client.InNamespace(namespace)); err != nil {
+		return nil, nil, err
+	}
+	for i := range pods.Items {
+		collectPodSpecSources(&pods.Items[i].Spec, configMaps, secrets)
+	}
+	return configMaps, secrets, nil
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func newTestConfigRevision(name string, created time.Time, data string) *corev1.ConfigMap {
+	cm := newTestConfigMap(name, map[string]string{"app.kubernetes.io/name": "synapse", ConfigSeriesLabel: "synapse-config"}, nil, data)
+	cm.CreationTimestamp = metav1.NewTime(created)
+	immutable := true
+	cm.Immutable = &immutable
+	return cm
+}
+
+func TestSplitConfigRevisions(t *testing.T) {
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	unlabelled := func(name string) corev1.ConfigMap {
+		cm := newTestConfigRevision(name, start, name)
+		delete(cm.Labels, ConfigSeriesLabel)
+		return *cm
+	}
+	configMaps := []corev1.ConfigMap{
+		*newTestConfigRevision("synapse-config-2", start.Add(time.Minute), "b"),
+		*newTestConfigRevision("synapse-config-1", start, "a"),
+		*newTestConfigRevision("synapse-config-3", start.Add(time.Minute), "c"),
+		*newTestConfigMap("homeserver", nil, nil, "h"),
+		unlabelled("worker-1"),
+		unlabelled("worker-2"),
+	}
+
+	current, superseded := splitConfigRevisions(configMaps)
+	var currentNames, supersededNames []string
+	for _, cm := range current {
+		currentNames = append(currentNames, cm.Name)
+	}
+	for _, cm := range superseded {
+		supersededNames = append(supersededNames, cm.Name)
+	}
+	assert.Equal(t, []string{"synapse-config-3", "homeserver", "worker-1", "worker-2"}, currentNames, "only labelled revisions form a series")
+	assert.Equal(t, []string{"synapse-config-2", "synapse-config-1"}, supersededNames)
+}
+
+func TestImmutableConfigRevisions(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+	r := newTestReconciler(t, newTestConfigRevision("synapse-config-1", start, "a"), newTestDeployment(nil))
+	r.ImmutableConfigSources = true
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "synapse-config-1"}}
+	hash := func() string {
+		t.Helper()
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		deploy := &appsv1.Deployment{}
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+		return deploy.Spec.Template.Annotations[testHashAnnotation]
+	}
+	first := hash()
+	require.NotEmpty(t, first)
+
+	require.NoError(t, r.Create(ctx, newTestConfigRevision("synapse-config-2", start.Add(time.Minute), "b")))
+	req.Name = "synapse-config-2"
+	second := hash()
+	assert.NotEqual(t, first, second, "a new revision rolls out")
+
+	require.NoError(t, r.Delete(ctx, newTestConfigRevision("synapse-config-1", start, "a")))
+	req.Name = "synapse-config-1"
+	assert.Equal(t, second, hash(), "deleting a superseded revision does not")
+}
+
+func TestPruneSupersededConfigSources(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+	configRef := func(name string) corev1.PodSpec {
+		return corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name:         "config",
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}}},
+		}}}
+	}
+	deploy := newTestDeployment(nil)
+	deploy.Spec.Template.Spec = configRef("synapse-config-5")
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "synapse-1", Namespace: "matrix"},
+		Spec:       appsv1.ReplicaSetSpec{Template: corev1.PodTemplateSpec{Spec: configRef("synapse-config-1")}},
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "synapse-1-abcde", Namespace: "matrix"}, Spec: configRef("synapse-config-2")}
+	objs := []client.Object{deploy, replicaSet, pod}
+	for i := range 6 {
+		objs = append(objs, newTestConfigRevision(fmt.Sprintf("synapse-config-%d", i), start.Add(time.Duration(i)*time.Minute), strconv.Itoa(i)))
+	}
+	r := newTestReconciler(t, objs...)
+	r.ImmutableConfigSources = true
+	r.PruneSupersededConfigSources = true
+	r.ConfigRevisionsRetention = 2
+
+	require.NoError(t, r.pruneSupersededConfigSources(ctx, "matrix"))
+	// 5 and 4 are retained, 5 is referenced by the Deployment as well, 1 by an old ReplicaSet and 2 by a pod.
+	for i, kept := range []bool{false, true, true, false, true, true} {
+		name := fmt.Sprintf("synapse-config-%d", i)
+		err := r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: name}, &corev1.ConfigMap{})
+		if kept {
+			assert.NoError(t, err, name)
+		} else {
+			assert.True(t, apierrors.IsNotFound(err), name)
+		}
+	}
+}
+
+func TestConfigSeriesLabelChangeRollsOut(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+	r := newTestReconciler(t)
+	r.ImmutableConfigSources = true
+	first := newTestConfigRevision("synapse-config-1", start, "a")
+	second := newTestConfigRevision("synapse-config-2", start.Add(time.Minute), "b")
+	delete(second.Labels, ConfigSeriesLabel)
+	require.NoError(t, r.Create(ctx, first))
+	require.NoError(t, r.Create(ctx, second))
+	alone, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+
+	updated := second.DeepCopy()
+	updated.Labels[ConfigSeriesLabel] = "synapse-config"
+	assert.True(t, r.sourceChangePredicate().Update(event.UpdateEvent{ObjectOld: second, ObjectNew: updated}), "a label-only series change is not dropped")
+	require.NoError(t, r.Update(ctx, updated))
+	series, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.NotEqual(t, alone, series, "synapse-config-1 is superseded once synapse-config-2 joins its series")
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RolloutImpact summarizes what restarting one workload is expected to disrupt.
+type RolloutImpact struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Pods is the number of live pods that will be replaced.
+	Pods int `json:"pods"`
+	// Nodes is the number of distinct nodes those pods run on.
+	Nodes int `json:"nodes"`
+	// PDBHeadroom is the smallest disruptionsAllowed across matching PodDisruptionBudgets, or -1 without any.
+	PDBHeadroom int32 `json:"pdbHeadroom"`
+	// PDBs lists the matching PodDisruptionBudgets.
+	PDBs []string `json:"pdbs,omitempty"`
+	// ExpectedSurge is the number of extra pods the rollout may create above the desired count.
+	ExpectedSurge int32 `json:"expectedSurge"`
+}
+
+func (i RolloutImpact) String() string {
+	headroom := "none"
+	if i.PDBHeadroom >= 0 {
+		headroom = fmt.Sprintf("%d", i.PDBHeadroom)
+	}
+	return fmt.Sprintf("%d pods on %d nodes, PDB headroom %s, expected surge %d", i.Pods, i.Nodes, headroom, i.ExpectedSurge)
+}
+
+// namespaceImpact is the response of GET /namespaces/{namespace}/impact: what rolling out Hash would disrupt.
+type namespaceImpact struct {
+	Namespace string `json:"namespace"`
+	Hash      string `json:"hash"`
+	// Holds are the namespace-wide holds of namespaceWorkloads.
+	Holds []string `json:"holds,omitempty"`
+	// Workloads estimates the impact of restarting each workload not yet on Hash.
+	Workloads []RolloutImpact `json:"workloads"`
+}
+
+// simulateImpact estimates, without changing anything, the impact of restarting every workload of namespace
+// that does not run its current hash yet.
+func (r *ConfigMapReconciler) simulateImpact(ctx context.Context, namespace string) (namespaceImpact, error) {
+	status, err := r.namespaceStatus(ctx, namespace)
+	if err != nil {
+		return namespaceImpact{}, err
+	}
+	result := namespaceImpact{Namespace: namespace, Hash: status.Hash, Holds: status.Holds, Workloads: []RolloutImpact{}}
+	pending := map[string]struct{}{}
+	for _, item := range status.Workloads {
+		if !item.Current {
+			pending[item.Kind+"/"+item.Name] = struct{}{}
+		}
+	}
+	if len(pending) == 0 {
+		return result, nil
+	}
+	workloads, err := r.listWorkloads(ctx, namespace)
+	if err != nil {
+		return result, err
+	}
+	for _, w := range workloads {
+		if _, ok := pending[w.kind+"/"+w.obj.GetName()]; !ok {
+			continue
+		}
+		impact, err := r.estimateImpact(ctx, w)
+		if err != nil {
+			return result, err
+		}
+		result.Workloads = append(result.Workloads, impact)
+	}
+	return result, nil
+}
+
+// reportImpact logs the estimated impact of restarting w and records it as an event. Estimation errors
+// are logged but never block the rollout.
+func (r *ConfigMapReconciler) reportImpact(ctx context.Context, w *workload, logger logr.Logger) {
+	impact, err := r.estimateImpact(ctx, w)
+	if err != nil {
+		logger.Error(err, "failed to estimate rollout impact")
+		return
+	}
+	logger.Info("Estimated rollout impact", "pods", impact.Pods, "nodes", impact.Nodes, "pdbHeadroom", impact.PDBHeadroom, "pdbs", impact.PDBs, "expectedSurge", impact.ExpectedSurge)
+	r.traceEvent(ctx, w.obj, corev1.EventTypeNormal, "RolloutImpact", "Restarting for config change: "+impact.String())
+}
+
+// estimateImpact inspects the pods and PodDisruptionBudgets of w to predict the effect of a restart.
+func (r *ConfigMapReconciler) estimateImpact(ctx context.Context, w *workload) (RolloutImpact, error) {
+	impact := RolloutImpact{
+		Kind:        w.kind,
+		Name:        w.obj.GetName(),
+		Namespace:   w.obj.GetNamespace(),
+		PDBHeadroom: -1,
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(w.selector)
+	if err != nil {
+		return impact, err
+	}
+	pods := &corev1.PodList{}
+	if err := r.reader().List(
+		ctx,
+		pods,
+		client.InNamespace(w.obj.GetNamespace()),
+		client.MatchingLabelsSelector{Selector: selector},
+	); err != nil {
+		return impact, err
+	}
+	nodes := map[string]struct{}{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		impact.Pods++
+		if pod.Spec.NodeName != "" {
+			nodes[pod.Spec.NodeName] = struct{}{}
+		}
+	}
+	impact.Nodes = len(nodes)
+
+	pdbs, err := r.matchingPDBs(ctx, w)
+	if err != nil {
+		return impact, err
+	}
+	for _, pdb := range pdbs {
+		impact.PDBs = append(impact.PDBs, pdb.Name)
+		if impact.PDBHeadroom < 0 || pdb.Status.DisruptionsAllowed < impact.PDBHeadroom {
+			impact.PDBHeadroom = pdb.Status.DisruptionsAllowed
+		}
+	}
+
+	impact.ExpectedSurge = expectedSurge(w)
+	return impact, nil
+}
+
+// matchingPDBs returns the PodDisruptionBudgets whose selector matches the pod template of w. As in policy/v1,
+// a budget without a selector matches no pods and one with an empty selector matches every pod.
+func (r *ConfigMapReconciler) matchingPDBs(ctx context.Context, w *workload) ([]policyv1.PodDisruptionBudget, error) {
+	list := &policyv1.PodDisruptionBudgetList{}
+	if err := r.reader().List(ctx, list, client.InNamespace(w.obj.GetNamespace())); err != nil {
+		return nil, err
+	}
+	podLabels := labels.Set(w.template.Labels)
+	matched := make([]policyv1.PodDisruptionBudget, 0, len(list.Items))
+	for i := range list.Items {
+		pdb := list.Items[i]
+		if pdb.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || !selector.Matches(podLabels) {
+			continue
+		}
+		matched = append(matched, pdb)
+	}
+	return matched, nil
+}
+
+// expectedSurge resolves the maxSurge of the workload's rolling update strategy against its replica count.
+func expectedSurge(w *workload) int32 {
+	switch obj := w.obj.(type) {
+	case *appsv1.Deployment:
+		if obj.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+			return 0
+		}
+		replicas := int32(1)
+		if obj.Spec.Replicas != nil {
+			replicas = *obj.Spec.Replicas
+		}
+		surge := intstr.FromString("25%")
+		if obj.Spec.Strategy.RollingUpdate != nil && obj.Spec.Strategy.RollingUpdate.MaxSurge != nil {
+			surge = *obj.Spec.Strategy.RollingUpdate.MaxSurge
+		}
+		value, err := intstr.GetScaledValueFromIntOrPercent(&surge, int(replicas), true)
+		if err != nil {
+			return 0
+		}
+		return int32(value)
+	case *appsv1.DaemonSet:
+		if obj.Spec.UpdateStrategy.RollingUpdate == nil || obj.Spec.UpdateStrategy.RollingUpdate.MaxSurge == nil {
+			return 0
+		}
+		value, err := intstr.GetScaledValueFromIntOrPercent(obj.Spec.UpdateStrategy.RollingUpdate.MaxSurge, int(obj.Status.DesiredNumberScheduled), true)
+		if err != nil {
+			return 0
+		}
+		return int32(value)
+	default:
+		// StatefulSets replace pods in place and never surge.
+		return 0
+	}
+}
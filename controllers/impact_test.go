@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestEstimateImpact(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	replicas := int32(4)
+	deploy.Spec.Replicas = &replicas
+	maxSurge := intstr.FromInt32(2)
+	deploy.Spec.Strategy = appsv1.DeploymentStrategy{
+		Type:          appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &maxSurge},
+	}
+	pod := func(name, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "matrix", Labels: map[string]string{"app": "synapse"}},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}
+	}
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "synapse", Namespace: "matrix"},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "synapse"}},
+		},
+		Status: policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 1},
+	}
+	r := newTestReconciler(t, deploy, pod("a", "node-1"), pod("b", "node-1"), pod("c", "node-2"), pdb)
+
+	impact, err := r.estimateImpact(ctx, deploymentWorkload(deploy))
+	require.NoError(t, err)
+	assert.Equal(t, 3, impact.Pods)
+	assert.Equal(t, 2, impact.Nodes)
+	assert.Equal(t, int32(1), impact.PDBHeadroom)
+	assert.Equal(t, []string{"synapse"}, impact.PDBs)
+	assert.Equal(t, int32(2), impact.ExpectedSurge)
+	assert.Equal(t, "3 pods on 2 nodes, PDB headroom 1, expected surge 2", impact.String())
+}
+
+func TestMatchingPDBsHonorEmptySelector(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	budget := func(name string, selector *metav1.LabelSelector) *policyv1.PodDisruptionBudget {
+		return &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "matrix"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: selector},
+		}
+	}
+	r := newTestReconciler(t, deploy,
+		budget("everything", &metav1.LabelSelector{}),
+		budget("nothing", nil),
+		budget("other", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "element"}}))
+
+	pdbs, err := r.matchingPDBs(ctx, deploymentWorkload(deploy))
+	require.NoError(t, err)
+	require.Len(t, pdbs, 1)
+	assert.Equal(t, "everything", pdbs[0].Name, "an empty selector matches every pod")
+}
+
+func TestStatusAPISimulatesImpact(t *testing.T) {
+	deploy := newTestDeployment(nil)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "synapse-a", Namespace: "matrix", Labels: map[string]string{"app": "synapse"}},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+	}
+	r := newTestReconciler(t, deploy, pod, newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	handler := r.statusHandler()
+
+	var impact namespaceImpact
+	getStatus(t, handler, "/namespaces/matrix/impact", &impact)
+	assert.NotEmpty(t, impact.Hash)
+	require.Len(t, impact.Workloads, 1)
+	assert.Equal(t, RolloutImpact{Kind: "Deployment", Name: "synapse", Namespace: "matrix", Pods: 1, Nodes: 1, PDBHeadroom: -1, ExpectedSurge: 1}, impact.Workloads[0])
+
+	deploy.Spec.Template.Annotations = map[string]string{testHashAnnotation: impact.Hash}
+	require.NoError(t, r.Update(context.Background(), deploy))
+	getStatus(t, handler, "/namespaces/matrix/impact", &impact)
+	assert.Empty(t, impact.Workloads, "a workload on the hash is not restarted")
+}
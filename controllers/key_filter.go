@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IncludeKeysAnnotation, on a ConfigMap or Secret, lists the only keys of that source that contribute to
+// the config hash, comma-separated. It replaces --include-configmap-keys or --include-secret-keys for the
+// source; the ignore lists still apply on top.
+const IncludeKeysAnnotation = "synapse.gen0sec.com/include-keys"
+
+// keyFilter decides which keys of a config source contribute to the config hash.
+type keyFilter struct {
+	ignored map[string]struct{}
+	// included, when not empty, is an allow-list: keys outside it do not contribute either.
+	included map[string]struct{}
+	// canonicalizer, when set, rewrites the values it recognizes before they are hashed.
+	canonicalizer ValueCanonicalizer
+}
+
+// ValueCanonicalizer rewrites config values whose bytes change without their meaning changing, such as
+// SOPS-encrypted documents re-encrypted with new IVs, into a form that only changes with their meaning.
+type ValueCanonicalizer interface {
+	// Canonical returns the canonical form of value, and false when value is not one it rewrites.
+	Canonical(value []byte) ([]byte, bool)
+}
+
+func (r *ConfigMapReconciler) configMapKeyFilter() keyFilter {
+	return keyFilter{ignored: r.IgnoredConfigMapKeys, included: r.IncludedConfigMapKeys, canonicalizer: r.SOPS}
+}
+
+func (r *ConfigMapReconciler) secretKeyFilter() keyFilter {
+	return keyFilter{ignored: r.IgnoredSecretKeys, included: r.IncludedSecretKeys, canonicalizer: r.SOPS}
+}
+
+// forSource applies the IncludeKeysAnnotation of obj, if any.
+func (f keyFilter) forSource(obj client.Object) keyFilter {
+	value := strings.TrimSpace(obj.GetAnnotations()[IncludeKeysAnnotation])
+	if value == "" {
+		return f
+	}
+	f.included = map[string]struct{}{}
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			f.included[key] = struct{}{}
+		}
+	}
+	return f
+}
+
+// skips reports whether key is left out of the hash.
+func (f keyFilter) skips(key string) bool {
+	if _, ok := f.ignored[key]; ok {
+		return true
+	}
+	if len(f.included) == 0 {
+		return false
+	}
+	_, ok := f.included[key]
+	return !ok
+}
+
+// value returns the form of value that is hashed.
+func (f keyFilter) value(value []byte) []byte {
+	if f.canonicalizer == nil {
+		return value
+	}
+	if canonical, ok := f.canonicalizer.Canonical(value); ok {
+		return canonical
+	}
+	return value
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestIncludedKeysLimitHashedKeys(t *testing.T) {
+	keys := keyFilter{included: map[string]struct{}{"homeserver.yaml": {}}}
+	cfg := &corev1.ConfigMap{Data: map[string]string{"homeserver.yaml": "a", "generated.yaml": "1"}}
+	hash := hashConfigMapContent(cfg, keys)
+
+	cfg.Data["generated.yaml"] = "2"
+	assert.Equal(t, hash, hashConfigMapContent(cfg, keys), "keys outside the include list do not count")
+
+	cfg.Data["homeserver.yaml"] = "b"
+	assert.NotEqual(t, hash, hashConfigMapContent(cfg, keys))
+
+	assert.Empty(t, hashConfigMapContent(&corev1.ConfigMap{Data: map[string]string{"other": "x"}}, keys), "a source without included keys contributes nothing")
+}
+
+func TestIncludeKeysAnnotationOverridesList(t *testing.T) {
+	keys := keyFilter{
+		ignored:  map[string]struct{}{"secret.yaml": {}},
+		included: map[string]struct{}{"homeserver.yaml": {}},
+	}
+	secret := &corev1.Secret{Data: map[string][]byte{"signing.key": []byte("k1"), "homeserver.yaml": []byte("a"), "secret.yaml": []byte("s")}}
+	secret.Annotations = map[string]string{IncludeKeysAnnotation: "signing.key, secret.yaml"}
+	hash := hashSecretContent(secret, keys)
+
+	secret.Data["homeserver.yaml"] = []byte("b")
+	secret.Data["secret.yaml"] = []byte("t")
+	assert.Equal(t, hash, hashSecretContent(secret, keys), "the annotation replaces the include list, ignores still apply")
+
+	secret.Data["signing.key"] = []byte("k2")
+	assert.NotEqual(t, hash, hashSecretContent(secret, keys))
+}
+
+// prefixCanonicalizer canonicalizes values starting with "enc:" to what follows the next colon, standing in
+// for a SOPS document whose ciphertext varies.
+type prefixCanonicalizer struct{}
+
+func (prefixCanonicalizer) Canonical(value []byte) ([]byte, bool) {
+	rest, ok := bytes.CutPrefix(value, []byte("enc:"))
+	if !ok {
+		return nil, false
+	}
+	_, canonical, _ := bytes.Cut(rest, []byte(":"))
+	return canonical, true
+}
+
+func TestCanonicalizerRewritesHashedValues(t *testing.T) {
+	keys := keyFilter{canonicalizer: prefixCanonicalizer{}}
+	secret := &corev1.Secret{Data: map[string][]byte{"secrets.yaml": []byte("enc:iv1:mac1"), "plain.yaml": []byte("a")}}
+	hash := hashSecretContent(secret, keys)
+
+	secret.Data["secrets.yaml"] = []byte("enc:iv2:mac1")
+	assert.Equal(t, hash, hashSecretContent(secret, keys), "re-encryption keeps the hash")
+
+	secret.Data["secrets.yaml"] = []byte("enc:iv3:mac2")
+	assert.NotEqual(t, hash, hashSecretContent(secret, keys))
+
+	cfg := &corev1.ConfigMap{Data: map[string]string{"plain.yaml": "a"}}
+	assert.Equal(t, hashConfigMapContent(cfg, keyFilter{}), hashConfigMapContent(cfg, keys), "other values are hashed as they are")
+}
+
+func TestCountSuppressedChangesOutsideIncludeList(t *testing.T) {
+	ctx := context.Background()
+	r := &ConfigMapReconciler{
+		SourceRules:           DefaultSourceRules(),
+		IncludedConfigMapKeys: map[string]struct{}{"data": {}},
+	}
+	namespace := "include-keys"
+	homeserver := newTestConfigMap("homeserver", nil, nil, "a")
+	homeserver.Data["generated.yaml"] = "1"
+
+	r.countSuppressedChanges(ctx, namespace, []corev1.ConfigMap{*homeserver.DeepCopy()}, nil)
+	assert.Zero(t, testutil.ToFloat64(restartsAvoidedTotal.WithLabelValues("", namespace, avoidedByIncludeKeys)), "the include rule starts at zero")
+
+	homeserver.Data["generated.yaml"] = "2"
+	r.countSuppressedChanges(ctx, namespace, []corev1.ConfigMap{*homeserver.DeepCopy()}, nil)
+	assert.Equal(t, float64(1), testutil.ToFloat64(restartsAvoidedTotal.WithLabelValues("", namespace, avoidedByIncludeKeys)))
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// workloadKindVerbs are the verbs the operator needs on a workload kind to roll it out.
+var workloadKindVerbs = []string{"list", "watch", "patch"}
+
+// probeWorkloadAccess asks the API server with SelfSubjectAccessReviews whether the operator may list, watch
+// and patch each workload kind in WatchedNamespace, or cluster-wide without one, and disables the kinds it
+// may not. It runs before anything watches workloads, as the cache would wait forever for an informer it is
+// not allowed to sync.
+func (r *ConfigMapReconciler) probeWorkloadAccess(ctx context.Context, c client.Client) error {
+	for _, kind := range r.workloadKinds() {
+		for _, verb := range workloadKindVerbs {
+			review := &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: r.WatchedNamespace,
+					Verb:      verb,
+					Group:     kind.group,
+					Resource:  kind.resource,
+				},
+			}}
+			if err := c.Create(ctx, review); err != nil {
+				return fmt.Errorf("checking access to %s: %w", kind.resource, err)
+			}
+			if !review.Status.Allowed {
+				r.denyKind(ctx, kind.name, verb, review.Status.Reason)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// denyKind disables the workload kind called name, which the operator may not access with verb, and reports
+// it in workloadKindDeniedGauge. The kind stays disabled until the operator restarts.
+func (r *ConfigMapReconciler) denyKind(ctx context.Context, name, verb, reason string) {
+	if _, loaded := r.deniedKinds.LoadOrStore(name, verb); loaded {
+		return
+	}
+	workloadKindDeniedGauge.WithLabelValues(r.ClusterName, name, verb).Set(1)
+	log.FromContext(ctx).Error(nil, "Not allowed to "+verb+" "+name+"s, leaving them out; grant the operator access and restart it to roll them out",
+		"kind", name, "verb", verb, "reason", reason)
+}
+
+// kindDenied reports whether the workload kind called name was disabled by denyKind.
+func (r *ConfigMapReconciler) kindDenied(name string) bool {
+	_, denied := r.deniedKinds.Load(name)
+	return denied
+}
+
+// patchDenied disables the kind of w when err is an RBAC denial with LeastPrivilege, so the rest of the
+// namespace rolls out instead of the reconcile failing on it, and reports whether it did.
+func (r *ConfigMapReconciler) patchDenied(ctx context.Context, w *workload, err error) bool {
+	if !r.LeastPrivilege || !apierrors.IsForbidden(err) {
+		return false
+	}
+	r.denyKind(ctx, w.logKey(), "patch", err.Error())
+	r.event(w.obj, corev1.EventTypeWarning, "WorkloadKindDenied",
+		fmt.Sprintf("Not allowed to patch %ss; the operator no longer rolls them out until it is granted patch and restarted", w.logKey()))
+	return true
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestProbeWorkloadAccess(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t)
+	r.WatchedNamespace = "matrix"
+	c := interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			review := obj.(*authorizationv1.SelfSubjectAccessReview)
+			attributes := review.Spec.ResourceAttributes
+			assert.Equal(t, "matrix", attributes.Namespace)
+			review.Status.Allowed = attributes.Resource != "daemonsets" || attributes.Verb != "watch"
+			return nil
+		},
+	})
+
+	require.NoError(t, r.probeWorkloadAccess(ctx, c))
+	var kinds []string
+	for _, kind := range r.workloadKinds() {
+		kinds = append(kinds, kind.name)
+	}
+	assert.Equal(t, []string{"deployment", "statefulset"}, kinds)
+	assert.Equal(t, float64(1), testutil.ToFloat64(workloadKindDeniedGauge.WithLabelValues("", "daemonset", "watch")))
+}
+
+func TestLeastPrivilegeSkipsDeniedPatches(t *testing.T) {
+	ctx := context.Background()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "media", Namespace: "matrix", Labels: map[string]string{"app.kubernetes.io/name": "synapse"}},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "media"}},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "media"}}},
+		},
+	}
+	r := newTestReconciler(t, cm, newTestDeployment(nil), statefulSet)
+	r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if _, ok := obj.(*appsv1.StatefulSet); ok {
+				return apierrors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "statefulsets"}, obj.GetName(), nil)
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	})
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	_, err := r.Reconcile(ctx, req)
+	assert.True(t, apierrors.IsForbidden(err), "without LeastPrivilege a denial fails the reconcile")
+
+	r.LeastPrivilege = true
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.NotEmpty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.True(t, r.kindDenied("statefulset"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(workloadKindDeniedGauge.WithLabelValues("", "statefulset", "patch")))
+
+	workloads, err := r.listWorkloads(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, workloads, 1)
+	assert.Equal(t, "Deployment", workloads[0].kind)
+}
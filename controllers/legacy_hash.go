@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// legacyHashVersion names the encoding of config hashes before ConfigHashVersion: bare hex, from fields
+// separated by NUL bytes.
+const legacyHashVersion = "v1"
+
+// isLegacyHash reports whether hash has the legacyHashVersion encoding.
+func isLegacyHash(hash string) bool {
+	if len(hash) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// legacyHashMemo holds the legacy hash of one namespace, or owner group, once computed.
+type legacyHashMemo struct {
+	computed bool
+	hash     string
+}
+
+// acceptsLegacyHash reports whether, with AcceptLegacyHashes, the applied hash of a workload behind hash is
+// the legacyHashVersion hash of the same config: the workload was rolled out by an operator from before
+// ConfigHashVersion and already runs the current config, so it needs no restart. The legacy hash is
+// computed from the sources listed by sources on the first call with memo.
+func (r *ConfigMapReconciler) acceptsLegacyHash(ctx context.Context, namespace, applied, hash string, memo *legacyHashMemo, sources func() ([]corev1.ConfigMap, []corev1.Secret, error)) (bool, error) {
+	if !r.AcceptLegacyHashes || applied == hash || !isLegacyHash(applied) {
+		return false, nil
+	}
+	if !memo.computed {
+		configMaps, secrets, err := sources()
+		if err != nil {
+			return false, err
+		}
+		if memo.hash, err = r.legacyHash(ctx, namespace, configMaps, secrets); err != nil {
+			return false, err
+		}
+		memo.computed = true
+	}
+	return memo.hash != "" && memo.hash == applied, nil
+}
+
+// legacyHash computes the combined hash of the sources of namespace the way combineSources does, in the
+// legacyHashVersion encoding. Remote sources were hashed differently before ConfigHashVersion, so a
+// namespace with remote sources has no legacy hash and "" is returned.
+func (r *ConfigMapReconciler) legacyHash(ctx context.Context, namespace string, configMaps []corev1.ConfigMap, secrets []corev1.Secret) (string, error) {
+	configMaps, secrets, _ = r.capSources(configMaps, secrets)
+	for i := range configMaps {
+		if _, ok := configMaps[i].Annotations[RemoteSourcesAnnotation]; ok {
+			return "", nil
+		}
+	}
+	for i := range secrets {
+		if _, ok := secrets[i].Annotations[RemoteSourcesAnnotation]; ok {
+			return "", nil
+		}
+	}
+	configMapItems, secretItems, _ := r.classifySources(ctx, "", slices.Clone(configMaps), slices.Clone(secrets))
+	digests := legacySourceDigests(configMapItems, secretItems, r.configMapKeyFilter(), r.secretKeyFilter())
+	external, err := r.externalSourceDigests(ctx, namespace, configMaps, secrets)
+	if err != nil {
+		return "", err
+	}
+	return combineLegacyDigests(append(digests, external...)), nil
+}
+
+func legacySourceDigests(configMaps []corev1.ConfigMap, secrets []corev1.Secret, configMapKeys, secretKeys keyFilter) []sourceDigest {
+	entries := make([]sourceDigest, 0, len(configMaps)+len(secrets))
+	for i := range configMaps {
+		cfg := &configMaps[i]
+		keys := configMapKeys.forSource(cfg)
+		var content []contentEntry
+		for k, v := range cfg.Data {
+			if !keys.skips(k) {
+				content = append(content, contentEntry{section: "s", key: k, value: keys.value([]byte(v))})
+			}
+		}
+		for k, v := range cfg.BinaryData {
+			if !keys.skips(k) {
+				content = append(content, contentEntry{section: "b", key: k, value: keys.value(v)})
+			}
+		}
+		if hash := hashLegacyContent(content); hash != "" {
+			entries = append(entries, sourceDigest{key: "configmap/" + cfg.Name, hash: hash})
+		}
+	}
+	for i := range secrets {
+		secret := &secrets[i]
+		keys := secretKeys.forSource(secret)
+		var content []contentEntry
+		for k, v := range secret.Data {
+			if !keys.skips(k) {
+				content = append(content, contentEntry{section: "d", key: k, value: keys.value(v)})
+			}
+		}
+		if hash := hashLegacyContent(content); hash != "" {
+			entries = append(entries, sourceDigest{key: "secret/" + secret.Name, hash: hash})
+		}
+	}
+	return entries
+}
+
+// hashLegacyContent hashes entries in the legacyHashVersion encoding: sorted by section and key, each written
+// as section, key, NUL, value, NUL.
+func hashLegacyContent(entries []contentEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].section+":"+entries[i].key < entries[j].section+":"+entries[j].key
+	})
+	hasher := sha256.New()
+	for _, entry := range entries {
+		hasher.Write([]byte(entry.section))
+		hasher.Write([]byte(entry.key))
+		hasher.Write([]byte{0})
+		hasher.Write(entry.value)
+		hasher.Write([]byte{0})
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// combineLegacyDigests is combineSourceDigests in the legacyHashVersion encoding.
+func combineLegacyDigests(entries []sourceDigest) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	hasher := sha256.New()
+	for _, entry := range entries {
+		hasher.Write([]byte(entry.key))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(entry.hash))
+		hasher.Write([]byte{0})
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
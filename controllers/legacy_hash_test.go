@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// testLegacyHash is the hash an operator from before the v2 encoding wrote for newTestConfigMap("homeserver",
+// ..., "a") alone.
+const testLegacyHash = "eb12eafc1624ff0e5ff5e6fd93dedfee57fa74e9f4760881d32ff607008b5273"
+
+func TestLegacyHash(t *testing.T) {
+	ctx := context.Background()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	r := newTestReconciler(t, cm)
+	configMaps, secrets, err := r.listConfigSources(ctx, "matrix")
+	require.NoError(t, err)
+
+	hash, err := r.legacyHash(ctx, "matrix", configMaps, secrets)
+	require.NoError(t, err)
+	assert.Equal(t, testLegacyHash, hash)
+	assert.True(t, isLegacyHash(hash))
+	assert.False(t, isLegacyHash(ConfigHashPrefix+hash))
+
+	configMaps[0].Annotations = map[string]string{RemoteSourcesAnnotation: "configmap/platform/ca"}
+	hash, err = r.legacyHash(ctx, "matrix", configMaps, secrets)
+	require.NoError(t, err)
+	assert.Empty(t, hash, "remote sources were hashed differently")
+}
+
+func TestAcceptLegacyHashes(t *testing.T) {
+	ctx := context.Background()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	deploy := newTestDeployment(nil)
+	deploy.Spec.Template.Annotations = map[string]string{testHashAnnotation: testLegacyHash}
+	r := newTestReconciler(t, cm, deploy)
+	r.AcceptLegacyHashes = true
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+	deployKey := client.ObjectKeyFromObject(deploy)
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, deployKey, deploy))
+	assert.Equal(t, testLegacyHash, deploy.Spec.Template.Annotations[testHashAnnotation], "the upgrade restarts nothing")
+	pending, err := r.PendingRestarts(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cm), cm))
+	cm.Data["data"] = "b"
+	require.NoError(t, r.Update(ctx, cm))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, deployKey, deploy))
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, hash, deploy.Spec.Template.Annotations[testHashAnnotation], "a config change rolls out the v2 hash")
+}
+
+func TestLegacyHashesRestartWithoutAcceptLegacyHashes(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	deploy.Spec.Template.Annotations = map[string]string{testHashAnnotation: testLegacyHash}
+	r := newTestReconciler(t, newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"), deploy)
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	var updated appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), &updated))
+	assert.Contains(t, updated.Spec.Template.Annotations[testHashAnnotation], ConfigHashPrefix)
+}
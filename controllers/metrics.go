@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	rolloutsPausedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_rollouts_paused",
+			Help: "1 while automatic rollouts in the namespace are paused.",
+		},
+		[]string{"cluster", "namespace"},
+	)
+	pendingConfigHashInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_pending_config_hash_info",
+			Help: "Config hash that would be rolled out in a paused namespace.",
+		},
+		[]string{"cluster", "namespace", "hash"},
+	)
+	approvalPendingInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_rollout_approval_pending_info",
+			Help: "Config hash waiting for approval in a namespace that requires approval.",
+		},
+		[]string{"cluster", "namespace", "hash"},
+	)
+	rolloutBlockedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_rollout_blocked",
+			Help: "1 while a pending config hash is held back from a workload, by reason.",
+		},
+		[]string{"cluster", "namespace", "workload", "reason"},
+	)
+	rolloutStalledGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_rollout_stalled",
+			Help: "1 while a workload restarted for a config change has not become ready within the progress timeout.",
+		},
+		[]string{"cluster", "namespace", "workload"},
+	)
+	rolloutInProgressGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_rollout_in_progress",
+			Help: "1 while a workload restarted for a config change is rolling out, until it is available again.",
+		},
+		[]string{"cluster", "namespace", "workload"},
+	)
+	rolloutDurationGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_rollout_duration_seconds",
+			Help: "Seconds the last completed config rollout of a workload took, from its restart until it was available again.",
+		},
+		[]string{"cluster", "namespace", "workload"},
+	)
+	workloadPendingHashGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_workload_pending_hash",
+			Help: "1 while a targeted workload does not run the latest config hash of its namespace yet, 0 once it does.",
+		},
+		[]string{"cluster", "namespace", "workload"},
+	)
+	restartsAvoidedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_restarts_avoided_total",
+			Help: "Config source changes that left the config hash unchanged, by the ignore rule that suppressed them.",
+		},
+		[]string{"cluster", "namespace", "rule"},
+	)
+	rolloutLockHeldGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_rollout_lock_held",
+			Help: "1 while an external deploy tool holds the rollout lock of the namespace.",
+		},
+		[]string{"cluster", "namespace"},
+	)
+	rolloutLockContentionTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_rollout_lock_contention_total",
+			Help: "Reconciles that held back a rollout because the namespace's rollout lock was held.",
+		},
+		[]string{"cluster", "namespace"},
+	)
+	workloadPatchRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_workload_patch_retries_total",
+			Help: "Workload writes retried after a conflict or a transient API error, by reason.",
+		},
+		[]string{"cluster", "namespace", "reason"},
+	)
+	workloadPatchRetriesExhaustedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_workload_patch_retries_exhausted_total",
+			Help: "Workload writes that still failed with a retriable error after the last attempt.",
+		},
+		[]string{"cluster", "namespace"},
+	)
+	sealedSecretResealsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_sealed_secret_reseals_total",
+			Help: "Updates of Secrets controlled by a SealedSecret dropped because their data was unchanged.",
+		},
+		[]string{"cluster", "namespace"},
+	)
+	sourceUpdatesSkippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_source_updates_skipped_total",
+			Help: "Updates of ConfigMaps and Secrets dropped because they changed nothing read from a config source.",
+		},
+		[]string{"cluster", "namespace"},
+	)
+	workloadKindDeniedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_workload_kind_denied",
+			Help: "Set to 1 for each workload kind left out because the operator may not access it with the verb.",
+		},
+		[]string{"cluster", "kind", "verb"},
+	)
+	secretDataReadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_secret_data_reads_total",
+			Help: "Secrets read from the API server for their data because the cache only holds their metadata.",
+		},
+		[]string{"cluster", "namespace"},
+	)
+	hashCacheLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_hash_cache_lookups_total",
+			Help: "Lookups of the digests of a namespace's config sources by their versions, by result (hit or miss).",
+		},
+		[]string{"result"},
+	)
+	configHashDriftTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_config_hash_drift_repaired_total",
+			Help: "Config hashes restored on workloads after another controller removed or altered them, by drift repair mode.",
+		},
+		[]string{"cluster", "namespace", "mode"},
+	)
+	manualRestartsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_manual_restarts_total",
+			Help: "Workloads restarted on a restart-now request, by whether the workload or its namespace was annotated.",
+		},
+		[]string{"cluster", "namespace", "scope"},
+	)
+	workloadRestartsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_workload_restarts_total",
+			Help: "Workloads updated to roll out a new config hash, by restart strategy; exemplars carry the trigger ID.",
+		},
+		[]string{"cluster", "namespace", "strategy"},
+	)
+	configSourcesHashedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_config_sources_hashed",
+			Help: "Config sources that contributed to the namespace's latest config hash.",
+		},
+		[]string{"cluster", "namespace"},
+	)
+	configSchemaInvalidGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_config_schema_invalid",
+			Help: "1 while a config source fails the JSON Schema it is annotated with, holding rollouts of its namespace back.",
+		},
+		[]string{"cluster", "namespace", "source"},
+	)
+	configChecksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_config_checks_total",
+			Help: "Config hashes posted to the config check endpoint before rolling out, by result (passed, rejected, error).",
+		},
+		[]string{"cluster", "namespace", "result"},
+	)
+	validationJobsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_validation_jobs_total",
+			Help: "Validation Jobs run for a config hash before rolling it out, by result (passed, failed).",
+		},
+		[]string{"cluster", "namespace", "result"},
+	)
+	rolloutBudgetRollingGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_rollout_budget_rolling",
+			Help: "Namespaces holding a slot of --max-rolling-namespaces.",
+		},
+		[]string{"cluster"},
+	)
+	rolloutBudgetQueuedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_rollout_budget_queued",
+			Help: "Namespaces waiting for a slot of --max-rolling-namespaces.",
+		},
+		[]string{"cluster"},
+	)
+	rolloutBudgetWaitsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_rollout_budget_waits_total",
+			Help: "Rollouts that had to wait for a slot of --max-rolling-namespaces.",
+		},
+		[]string{"cluster", "namespace"},
+	)
+	clusterConnectedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_cluster_connected",
+			Help: "Remote clusters registered through kubeconfig Secrets: 1 while connected, 0 while the connection is retried.",
+		},
+		[]string{"cluster"},
+	)
+	clusterReconcilesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_cluster_reconciles_total",
+			Help: "Reconciles of config sources in remote clusters, by result.",
+		},
+		[]string{"cluster", "result"},
+	)
+	clusterRestartsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_cluster_workload_restarts_total",
+			Help: "Workloads in remote clusters updated to roll out a new config hash, by restart strategy.",
+		},
+		[]string{"cluster", "strategy"},
+	)
+	configSourcesDroppedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "synapse_operator_config_sources_dropped",
+			Help: "Selected ConfigMaps and Secrets left out of the namespace's latest config hash by --max-sources-per-namespace.",
+		},
+		[]string{"cluster", "namespace"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(rolloutsPausedGauge, pendingConfigHashInfo, approvalPendingInfo, rolloutBlockedGauge, rolloutStalledGauge, restartsAvoidedTotal, rolloutLockHeldGauge, rolloutLockContentionTotal, workloadPatchRetriesTotal, workloadPatchRetriesExhaustedTotal, sealedSecretResealsTotal, sourceUpdatesSkippedTotal, workloadKindDeniedGauge, secretDataReadsTotal, hashCacheLookupsTotal, configHashDriftTotal, manualRestartsTotal, workloadRestartsTotal, configSourcesHashedGauge, configSourcesDroppedGauge, configSchemaInvalidGauge, configChecksTotal, validationJobsTotal, rolloutInProgressGauge, rolloutDurationGauge, workloadPendingHashGauge, rolloutBudgetRollingGauge, rolloutBudgetQueuedGauge, rolloutBudgetWaitsTotal, clusterConnectedGauge, clusterReconcilesTotal, clusterRestartsTotal)
+}
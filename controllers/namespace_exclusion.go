@@ -0,0 +1,12 @@
+package controllers
+
+// DefaultExcludedNamespaces are the namespaces --exclude-namespaces protects by default: those of the
+// Kubernetes control plane and node heartbeats.
+const DefaultExcludedNamespaces = "kube-system,kube-public,kube-node-lease"
+
+// namespaceExcluded reports whether namespace is in ExcludedNamespaces, where the operator never restarts,
+// releases, or repairs a workload, whatever its labels.
+func (r *ConfigMapReconciler) namespaceExcluded(namespace string) bool {
+	_, ok := r.ExcludedNamespaces[namespace]
+	return ok
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestExcludedNamespacesAreNeverRolledOut(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	deploy.Namespace = "kube-system"
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	cm.Namespace = "kube-system"
+	r := newTestReconciler(t, deploy, cm)
+	r.ExcludedNamespaces = parseTestKeySet("kube-system", "kube-public")
+
+	assert.False(t, r.targets(deploymentWorkload(deploy)))
+	workloads, err := r.listWorkloads(ctx, "kube-system")
+	require.NoError(t, err)
+	assert.Empty(t, workloads)
+
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "kube-system", Name: "homeserver"}})
+	require.NoError(t, err)
+	var current appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), &current))
+	assert.Empty(t, current.Spec.Template.Annotations[testHashAnnotation])
+
+	r.ExcludedNamespaces = nil
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "kube-system", Name: "homeserver"}})
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), &current))
+	assert.NotEmpty(t, current.Spec.Template.Annotations[testHashAnnotation])
+}
+
+func parseTestKeySet(keys ...string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	return set
+}
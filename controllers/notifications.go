@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"synapse-operator/audit"
+	"synapse-operator/notify"
+)
+
+// notify tells the notifier about the outcome of a finished decision when it rolled workloads out, or
+// failed while rolling out a computed hash.
+func (r *ConfigMapReconciler) notify(rec *audit.Record) {
+	if r.Notifier == nil {
+		return
+	}
+	ev := notify.Event{
+		Time:        rec.Time,
+		Namespace:   rec.Namespace,
+		Source:      rec.Trigger,
+		Hash:        rec.CombinedHash,
+		Diff:        rec.Diff,
+		Transaction: rec.ID,
+		TriggerID:   rec.TriggerID,
+	}
+	switch {
+	case rec.Result == audit.ResultRolledOut:
+		ev.Type = notify.EventRolloutTriggered
+	case rec.Result == audit.ResultFailed && rec.CombinedHash != "":
+		ev.Type = notify.EventRolloutFailed
+		ev.Error = rec.Error
+	default:
+		return
+	}
+	for _, action := range rec.Actions {
+		if action.Updated || action.Error != "" {
+			ev.Workloads = append(ev.Workloads, action.Workload)
+		}
+	}
+	r.Notifier.Notify(ev)
+}
@@ -0,0 +1,372 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Onboarding policies accepted by --onboarding-policy.
+const (
+	// OnboardingOff disables onboarding.
+	OnboardingOff = "off"
+	// OnboardingReport records an OnboardingCandidate event on the Namespace listing what would be labelled.
+	OnboardingReport = "report"
+	// OnboardingLabel applies the selector labels to detected workloads and the config sources they mount.
+	OnboardingLabel = "label"
+)
+
+// OnboardingAnnotation set to "disabled" on a Namespace keeps onboarding out of it.
+const OnboardingAnnotation = "synapse.gen0sec.com/onboarding"
+
+// DefaultOnboardingImagePattern matches the upstream Synapse images.
+const DefaultOnboardingImagePattern = `(^|/)matrixdotorg/synapse([:@]|$)`
+
+// DefaultOnboardingChartSelector matches workloads of the community matrix-synapse Helm chart.
+const DefaultOnboardingChartSelector = "app.kubernetes.io/name=matrix-synapse"
+
+// ValidOnboardingPolicy reports whether policy is a known onboarding policy.
+func ValidOnboardingPolicy(policy string) bool {
+	switch policy {
+	case OnboardingOff, OnboardingReport, OnboardingLabel:
+		return true
+	}
+	return false
+}
+
+// SelectorLabels returns the label set that satisfies selector, for stamping onto onboarded objects. Only
+// equality requirements (or set requirements with a single value) can be satisfied this way.
+func SelectorLabels(selector labels.Selector) (map[string]string, error) {
+	requirements, _ := selector.Requirements()
+	if len(requirements) == 0 {
+		return nil, fmt.Errorf("selector %q selects everything; onboarding needs labels to apply", selector)
+	}
+	set := make(map[string]string, len(requirements))
+	for _, requirement := range requirements {
+		values := requirement.Values().List()
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+			if len(values) == 1 {
+				set[requirement.Key()] = values[0]
+				continue
+			}
+		}
+		return nil, fmt.Errorf("selector requirement %q cannot be applied as a label", requirement.String())
+	}
+	return set, nil
+}
+
+// OnboardingReconciler finds Synapse workloads in a namespace that the label selector does not match yet,
+// by image or chart labels, and onboards them and the ConfigMaps and Secrets they mount by applying the
+// selector labels. Requests are keyed by namespace name.
+type OnboardingReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	// Selector is the operator's label selector; objects it already matches are left alone.
+	Selector labels.Selector
+	// Labels is applied to onboarded objects and must satisfy Selector.
+	Labels map[string]string
+	// SourceSelector and SourceLabels, when set, take the place of Selector and Labels for the ConfigMaps and
+	// Secrets, with --source-label-selector.
+	SourceSelector labels.Selector
+	SourceLabels   map[string]string
+	// ImagePattern detects Synapse containers by image.
+	ImagePattern *regexp.Regexp
+	// ChartSelector detects Synapse workloads by the labels of their Helm chart.
+	ChartSelector labels.Selector
+	// Policy is OnboardingReport or OnboardingLabel.
+	Policy string
+}
+
+func (r *OnboardingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("namespace", req.Name)
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: req.Name}, ns); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if ns.Annotations[OnboardingAnnotation] == "disabled" {
+		return ctrl.Result{}, nil
+	}
+
+	workloads, err := r.detectWorkloads(ctx, req.Name)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(workloads) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	var candidates []client.Object
+	for _, w := range workloads {
+		candidates = append(candidates, w.obj)
+	}
+	sources, err := r.referencedSources(ctx, req.Name, workloads)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	candidates = append(candidates, sources...)
+
+	var pending, conflicting []string
+	var toLabel []client.Object
+	for _, obj := range candidates {
+		if selector, _ := r.selection(obj); selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		name := onboardingKey(obj)
+		if key, ok := r.conflictingLabel(obj); ok {
+			conflicting = append(conflicting, fmt.Sprintf("%s (label %s)", name, key))
+			continue
+		}
+		pending = append(pending, name)
+		toLabel = append(toLabel, obj)
+	}
+	if len(conflicting) > 0 {
+		r.event(ns, corev1.EventTypeWarning, "OnboardingConflict",
+			fmt.Sprintf("Not relabelling %s: the label already has another value; widen --label-selector instead", strings.Join(conflicting, ", ")))
+	}
+	if len(pending) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	if r.Policy != OnboardingLabel {
+		logger.Info("Found Synapse objects to onboard", "objects", pending)
+		r.event(ns, corev1.EventTypeNormal, "OnboardingCandidate", "Would label "+strings.Join(pending, ", "))
+		return ctrl.Result{}, nil
+	}
+	for _, obj := range toLabel {
+		patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+		objLabels := obj.GetLabels()
+		if objLabels == nil {
+			objLabels = map[string]string{}
+		}
+		_, selectorLabels := r.selection(obj)
+		for k, v := range selectorLabels {
+			objLabels[k] = v
+		}
+		obj.SetLabels(objLabels)
+		if err := r.Patch(ctx, obj, patch); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("labelling %s: %w", onboardingKey(obj), err)
+		}
+	}
+	logger.Info("Onboarded Synapse objects", "objects", pending)
+	r.event(ns, corev1.EventTypeNormal, "Onboarded", "Labelled "+strings.Join(pending, ", "))
+	return ctrl.Result{}, nil
+}
+
+// detectWorkloads returns the workloads in namespace that look like Synapse.
+func (r *OnboardingReconciler) detectWorkloads(ctx context.Context, namespace string) ([]*workload, error) {
+	deployments := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deployments, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := r.List(ctx, daemonSets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, statefulSets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	var all []*workload
+	for i := range deployments.Items {
+		all = append(all, deploymentWorkload(&deployments.Items[i]))
+	}
+	for i := range daemonSets.Items {
+		all = append(all, daemonSetWorkload(&daemonSets.Items[i]))
+	}
+	for i := range statefulSets.Items {
+		all = append(all, statefulSetWorkload(&statefulSets.Items[i]))
+	}
+	var detected []*workload
+	for _, w := range all {
+		if r.isSynapse(w) {
+			detected = append(detected, w)
+		}
+	}
+	return detected, nil
+}
+
+func (r *OnboardingReconciler) isSynapse(w *workload) bool {
+	if r.ChartSelector != nil && !r.ChartSelector.Empty() && r.ChartSelector.Matches(labels.Set(w.obj.GetLabels())) {
+		return true
+	}
+	if r.ImagePattern == nil {
+		return false
+	}
+	spec := &w.template.Spec
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			if r.ImagePattern.MatchString(container.Image) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// referencedSources returns the existing ConfigMaps and Secrets mounted or referenced from the environment
+// of workloads.
+func (r *OnboardingReconciler) referencedSources(ctx context.Context, namespace string, workloads []*workload) ([]client.Object, error) {
+	configMaps := map[string]struct{}{}
+	secrets := map[string]struct{}{}
+	for _, w := range workloads {
+		collectPodSpecSources(&w.template.Spec, configMaps, secrets)
+	}
+
+	var sources []client.Object
+	for _, name := range sortedKeys(configMaps) {
+		cm := &corev1.ConfigMap{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		sources = append(sources, cm)
+	}
+	for _, name := range sortedKeys(secrets) {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		sources = append(sources, secret)
+	}
+	return sources, nil
+}
+
+// collectPodSpecSources adds the names of the ConfigMaps and Secrets spec mounts or reads env from.
+func collectPodSpecSources(spec *corev1.PodSpec, configMaps, secrets map[string]struct{}) {
+	for _, volume := range spec.Volumes {
+		if volume.ConfigMap != nil {
+			configMaps[volume.ConfigMap.Name] = struct{}{}
+		}
+		if volume.Secret != nil {
+			secrets[volume.Secret.SecretName] = struct{}{}
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					configMaps[source.ConfigMap.Name] = struct{}{}
+				}
+				if source.Secret != nil {
+					secrets[source.Secret.Name] = struct{}{}
+				}
+			}
+		}
+	}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			for _, envFrom := range container.EnvFrom {
+				if envFrom.ConfigMapRef != nil {
+					configMaps[envFrom.ConfigMapRef.Name] = struct{}{}
+				}
+				if envFrom.SecretRef != nil {
+					secrets[envFrom.SecretRef.Name] = struct{}{}
+				}
+			}
+			for _, env := range container.Env {
+				if env.ValueFrom == nil {
+					continue
+				}
+				if env.ValueFrom.ConfigMapKeyRef != nil {
+					configMaps[env.ValueFrom.ConfigMapKeyRef.Name] = struct{}{}
+				}
+				if env.ValueFrom.SecretKeyRef != nil {
+					secrets[env.ValueFrom.SecretKeyRef.Name] = struct{}{}
+				}
+			}
+		}
+	}
+}
+
+// conflictingLabel reports a selector label obj already carries with a different value. Onboarding never
+// overwrites labels, which may be owned by Helm or used by other selectors.
+func (r *OnboardingReconciler) conflictingLabel(obj client.Object) (string, bool) {
+	_, selectorLabels := r.selection(obj)
+	for k, v := range selectorLabels {
+		if current, ok := obj.GetLabels()[k]; ok && current != v {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// selection returns the selector obj is matched against and the labels that satisfy it.
+func (r *OnboardingReconciler) selection(obj client.Object) (labels.Selector, map[string]string) {
+	switch obj.(type) {
+	case *corev1.ConfigMap, *corev1.Secret:
+		if r.SourceSelector != nil {
+			return r.SourceSelector, r.SourceLabels
+		}
+	}
+	return r.Selector, r.Labels
+}
+
+func (r *OnboardingReconciler) event(obj client.Object, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(obj, eventType, reason, message)
+}
+
+func onboardingKey(obj client.Object) string {
+	switch obj.(type) {
+	case *corev1.ConfigMap:
+		return "configmap/" + obj.GetName()
+	case *corev1.Secret:
+		return "secret/" + obj.GetName()
+	case *appsv1.Deployment:
+		return "deployment/" + obj.GetName()
+	case *appsv1.DaemonSet:
+		return "daemonset/" + obj.GetName()
+	case *appsv1.StatefulSet:
+		return "statefulset/" + obj.GetName()
+	}
+	return obj.GetName()
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetupWithManager reconciles a namespace whenever a workload in it, or its onboarding annotation, changes.
+func (r *OnboardingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	byNamespace := handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: obj.GetNamespace()}}}
+	})
+	namespace := handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: obj.GetName()}}}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("onboarding").
+		Watches(&appsv1.Deployment{}, byNamespace).
+		Watches(&appsv1.DaemonSet{}, byNamespace).
+		Watches(&appsv1.StatefulSet{}, byNamespace).
+		Watches(&corev1.Namespace{}, namespace, builder.WithPredicates(predicate.AnnotationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestSelectorLabels(t *testing.T) {
+	selector, err := labels.Parse("app.kubernetes.io/name=synapse,tier in (homeserver)")
+	require.NoError(t, err)
+	set, err := SelectorLabels(selector)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"app.kubernetes.io/name": "synapse", "tier": "homeserver"}, set)
+
+	for _, invalid := range []string{"", "tier in (a,b)", "app!=synapse", "app"} {
+		selector, err := labels.Parse(invalid)
+		require.NoError(t, err)
+		_, err = SelectorLabels(selector)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestOnboardingLabelsWorkloadAndSources(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	deploy.Labels = nil
+	deploy.Spec.Template.Spec.Containers = []corev1.Container{{
+		Name:    "synapse",
+		Image:   "docker.io/matrixdotorg/synapse:v1.120.0",
+		EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "synapse-env"}}}},
+	}}
+	deploy.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name:         "config",
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "homeserver"}}},
+	}}
+	other := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "matrix"}}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "synapse-env", Namespace: "matrix", Labels: map[string]string{"app.kubernetes.io/name": "mautrix"}}}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix"}}
+	c := newTestReconciler(t, ns, deploy, other, secret, newTestConfigMap("homeserver", nil, nil, "a")).Client
+
+	selector, err := labels.Parse("app.kubernetes.io/name=synapse")
+	require.NoError(t, err)
+	r := &OnboardingReconciler{
+		Client:       c,
+		Selector:     selector,
+		Labels:       map[string]string{"app.kubernetes.io/name": "synapse"},
+		ImagePattern: regexp.MustCompile(DefaultOnboardingImagePattern),
+		Policy:       OnboardingReport,
+	}
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: "matrix"}})
+	require.NoError(t, err)
+	var current appsv1.Deployment
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(deploy), &current))
+	assert.Empty(t, current.Labels)
+
+	r.Policy = OnboardingLabel
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: "matrix"}})
+	require.NoError(t, err)
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(deploy), &current))
+	assert.Equal(t, "synapse", current.Labels["app.kubernetes.io/name"])
+	var cm corev1.ConfigMap
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "homeserver"}, &cm))
+	assert.Equal(t, "synapse", cm.Labels["app.kubernetes.io/name"])
+
+	// Conflicting labels are never overwritten, and unrelated workloads are left alone.
+	var currentSecret corev1.Secret
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(secret), &currentSecret))
+	assert.Equal(t, "mautrix", currentSecret.Labels["app.kubernetes.io/name"])
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(other), &current))
+	assert.Empty(t, current.Labels)
+}
+
+func TestOnboardingSourceSelection(t *testing.T) {
+	workloadLabels := map[string]string{"app.kubernetes.io/name": "synapse"}
+	sourceLabels := map[string]string{"config.example.com/synapse": "true"}
+	r := &OnboardingReconciler{Selector: labels.SelectorFromSet(workloadLabels), Labels: workloadLabels}
+	_, got := r.selection(&corev1.ConfigMap{})
+	assert.Equal(t, workloadLabels, got)
+
+	r.SourceSelector, r.SourceLabels = labels.SelectorFromSet(sourceLabels), sourceLabels
+	_, got = r.selection(&corev1.Secret{})
+	assert.Equal(t, sourceLabels, got)
+	_, got = r.selection(&appsv1.Deployment{})
+	assert.Equal(t, workloadLabels, got)
+}
@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ComponentLabel names the component, such as "bridge-telegram", of a config source or workload. With
+// GroupByComponent each component's sources are hashed separately and rolled out only to its workloads.
+const ComponentLabel = "synapse.gen0sec.com/component"
+
+// SignalHomeserverAnnotation set to "true" on a config source of a group also feeds it into the hash of the
+// shared group, so changing it restarts the homeserver as well, for example when a bridge's change has to be
+// picked up by Synapse.
+const SignalHomeserverAnnotation = "synapse.gen0sec.com/signal-homeserver"
+
+// ownerGroup is the config sources and workloads of a namespace controlled by one owner, such as a release
+// of a Helm operator, or with GroupByComponent labelled with one component. The zero ownerGroup holds the
+// sources without a controller or component, which every group shares, and the workloads of no group with
+// config sources.
+type ownerGroup struct {
+	// uid is the UID of the owner, or "component/<name>" for a component.
+	uid types.UID
+	// ref names the owner as "<kind>/<name>", or the component as "component/<name>", for logs.
+	ref string
+}
+
+type ownerGroupKey struct{}
+
+// withOwnerGroup scopes the config sources and workloads listed with ctx to group.
+func withOwnerGroup(ctx context.Context, group ownerGroup) context.Context {
+	return context.WithValue(ctx, ownerGroupKey{}, group)
+}
+
+// withoutOwnerGroup lifts the group scope of ctx.
+func withoutOwnerGroup(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ownerGroupKey{}, nil)
+}
+
+// ownerGroupFrom returns the group ctx is scoped to, if any.
+func ownerGroupFrom(ctx context.Context) (ownerGroup, bool) {
+	group, ok := ctx.Value(ownerGroupKey{}).(ownerGroup)
+	return group, ok
+}
+
+// controllerGroup returns the group of the controller of obj, or the zero group when it has none.
+func controllerGroup(obj client.Object) ownerGroup {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil {
+		return ownerGroup{}
+	}
+	return ownerGroup{uid: owner.UID, ref: strings.ToLower(owner.Kind) + "/" + owner.Name}
+}
+
+// componentGroup returns the group of the ComponentLabel of obj, or the zero group when it has none.
+func componentGroup(obj client.Object) ownerGroup {
+	component := obj.GetLabels()[ComponentLabel]
+	if component == "" {
+		return ownerGroup{}
+	}
+	ref := "component/" + component
+	return ownerGroup{uid: types.UID(ref), ref: ref}
+}
+
+// grouped reports whether config sources and workloads are grouped, by owner or by component.
+func (r *ConfigMapReconciler) grouped() bool {
+	return r.GroupByOwner || r.GroupByComponent
+}
+
+// objectGroup returns the group of obj: that of its component with GroupByComponent, or else of its
+// controller.
+func (r *ConfigMapReconciler) objectGroup(obj client.Object) ownerGroup {
+	if r.GroupByComponent {
+		return componentGroup(obj)
+	}
+	return controllerGroup(obj)
+}
+
+// signalsHomeserver reports whether the config source obj also feeds the hash of the shared group.
+func signalsHomeserver(obj client.Object) bool {
+	return obj.GetAnnotations()[SignalHomeserverAnnotation] == "true"
+}
+
+// ownerGroups lists the groups of namespace with config sources: the zero group first if any source has no
+// controller or component, or signals the homeserver, then one per owner or component of a config source,
+// ordered by ref.
+func (r *ConfigMapReconciler) ownerGroups(ctx context.Context, namespace string) ([]ownerGroup, error) {
+	// Grouping only needs metadata, so the cached sources do.
+	configMaps, secrets, err := r.listCachedSources(withoutOwnerGroup(ctx), namespace)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[types.UID]ownerGroup{}
+	add := func(obj client.Object) {
+		group := r.objectGroup(obj)
+		seen[group.uid] = group
+		if signalsHomeserver(obj) {
+			seen[""] = ownerGroup{}
+		}
+	}
+	for i := range configMaps {
+		add(&configMaps[i])
+	}
+	for i := range secrets {
+		add(&secrets[i])
+	}
+	groups := make([]ownerGroup, 0, len(seen))
+	for _, group := range seen {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].ref != groups[j].ref {
+			return groups[i].ref < groups[j].ref
+		}
+		return groups[i].uid < groups[j].uid
+	})
+	return groups, nil
+}
+
+// groupScoped reports whether listings with ctx are limited to one owner group, and which.
+func (r *ConfigMapReconciler) groupScoped(ctx context.Context) (ownerGroup, bool) {
+	if !r.grouped() {
+		return ownerGroup{}, false
+	}
+	return ownerGroupFrom(ctx)
+}
+
+// inSourceGroup reports whether the config source obj feeds the hash of group: the group's own sources and
+// the shared ones do, and for the zero group also those signalling the homeserver.
+func (r *ConfigMapReconciler) inSourceGroup(group ownerGroup, obj client.Object) bool {
+	uid := r.objectGroup(obj).uid
+	return uid == "" || uid == group.uid || (group.uid == "" && signalsHomeserver(obj))
+}
+
+// ownSources returns the sources of group, leaving out the shared ones unless group is the zero group, which
+// only has shared ones and those signalling the homeserver.
+func (r *ConfigMapReconciler) ownSources(group ownerGroup, configMaps []corev1.ConfigMap, secrets []corev1.Secret) ([]corev1.ConfigMap, []corev1.Secret) {
+	if group.uid == "" {
+		return configMaps, secrets
+	}
+	own := func(obj client.Object) bool { return r.objectGroup(obj).uid == group.uid }
+	var ownConfigMaps []corev1.ConfigMap
+	for i := range configMaps {
+		if own(&configMaps[i]) {
+			ownConfigMaps = append(ownConfigMaps, configMaps[i])
+		}
+	}
+	var ownSecrets []corev1.Secret
+	for i := range secrets {
+		if own(&secrets[i]) {
+			ownSecrets = append(ownSecrets, secrets[i])
+		}
+	}
+	return ownConfigMaps, ownSecrets
+}
+
+// workloadGroup returns the group w belongs to among groups: that of its controller or component if it has
+// config sources, or else the zero group.
+func (r *ConfigMapReconciler) workloadGroup(groups []ownerGroup, w *workload) ownerGroup {
+	owner := r.objectGroup(w.obj)
+	if owner.uid == "" {
+		return ownerGroup{}
+	}
+	for _, group := range groups {
+		if group.uid == owner.uid {
+			return group
+		}
+	}
+	return ownerGroup{}
+}
+
+// workloadHash computes the hash w should run: that of namespace, or of the group of w with GroupByOwner or
+// GroupByComponent.
+func (r *ConfigMapReconciler) workloadHash(ctx context.Context, namespace string, w *workload) (string, error) {
+	if r.grouped() {
+		groups, err := r.ownerGroups(ctx, namespace)
+		if err != nil {
+			return "", err
+		}
+		ctx = withOwnerGroup(ctx, r.workloadGroup(groups, w))
+	}
+	hash, _, err := r.computeCombinedHash(ctx, namespace)
+	return hash, err
+}
+
+// scopeWorkloads keeps the workloads of namespace in the group ctx is scoped to, if any.
+func (r *ConfigMapReconciler) scopeWorkloads(ctx context.Context, namespace string, workloads []*workload) ([]*workload, error) {
+	group, ok := r.groupScoped(ctx)
+	if !ok {
+		return workloads, nil
+	}
+	groups, err := r.ownerGroups(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	scoped := workloads[:0]
+	for _, w := range workloads {
+		if r.workloadGroup(groups, w).uid == group.uid {
+			scoped = append(scoped, w)
+		}
+	}
+	return scoped, nil
+}
+
+// triggeredGroups returns the groups whose hash a change of the object req names can affect: only its own
+// when it is a config source with a controller or component, along with the zero group when it signals the
+// homeserver, or else every group of the namespace.
+func (r *ConfigMapReconciler) triggeredGroups(ctx context.Context, req ctrl.Request) ([]ownerGroup, error) {
+	groups, err := r.ownerGroups(ctx, req.Namespace)
+	if err != nil || len(groups) == 0 {
+		// Without sources a single pass reports that there is nothing to roll out.
+		return []ownerGroup{{}}, err
+	}
+	for _, obj := range []client.Object{&corev1.ConfigMap{}, &corev1.Secret{}} {
+		if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		trigger := r.objectGroup(obj)
+		if trigger.uid != "" && slices.Contains(groups, trigger) {
+			if signalsHomeserver(obj) {
+				return []ownerGroup{{}, trigger}, nil
+			}
+			return []ownerGroup{trigger}, nil
+		}
+		break
+	}
+	return groups, nil
+}
+
+// addGroupStatus adds the workloads of every group of the namespace to status, each compared with the
+// hash of its group. The namespace hash is then a digest of the group hashes, so it changes with any of them.
+func (r *ConfigMapReconciler) addGroupStatus(ctx context.Context, status *namespaceWorkloads) error {
+	groups, err := r.ownerGroups(ctx, status.Namespace)
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 || groups[0].uid != "" {
+		// Workloads outside every group are still listed, with no hash to run.
+		groups = append([]ownerGroup{{}}, groups...)
+	}
+	var digests []sourceDigest
+	for _, group := range groups {
+		groupCtx := withOwnerGroup(ctx, group)
+		hash, _, err := r.computeCombinedHash(groupCtx, status.Namespace)
+		if err != nil {
+			return err
+		}
+		workloads, err := r.listWorkloads(groupCtx, status.Namespace)
+		if err != nil {
+			return err
+		}
+		r.addWorkloadStatus(groupCtx, status, workloads, hash, group.ref)
+		if hash != "" {
+			digests = append(digests, sourceDigest{key: "owner/" + string(group.uid), hash: hash})
+		}
+	}
+	status.Hash = combineSourceDigests(digests)
+	return nil
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func ownedBy(obj client.Object, release string) client.Object {
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "helm.example.com/v1",
+		Kind:       "Release",
+		Name:       release,
+		UID:        types.UID("uid-" + release),
+		Controller: ptr.To(true),
+	}})
+	return obj
+}
+
+func newGroupedDeployment(name string) *appsv1.Deployment {
+	deploy := newTestDeployment(nil)
+	deploy.Name = name
+	return deploy
+}
+
+func TestGroupByOwnerRollsOutEachReleaseSeparately(t *testing.T) {
+	ctx := context.Background()
+	labels := map[string]string{"app.kubernetes.io/name": "synapse"}
+	r := newTestReconciler(t,
+		ownedBy(newGroupedDeployment("synapse-a"), "a"),
+		ownedBy(newGroupedDeployment("synapse-b"), "b"),
+		newGroupedDeployment("standalone"),
+		ownedBy(newTestConfigMap("config-a", labels, nil, "a1"), "a"),
+		ownedBy(newTestConfigMap("config-b", labels, nil, "b1"), "b"),
+		newTestConfigMap("shared", labels, nil, "s1"),
+	)
+	r.GroupByOwner = true
+	hashOf := func(name string) string {
+		deploy := &appsv1.Deployment{}
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: name}, deploy))
+		return deploy.Spec.Template.Annotations[testHashAnnotation]
+	}
+	reconcile := func(name string) {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: name}})
+		require.NoError(t, err)
+	}
+
+	reconcile("config-a")
+	assert.NotEmpty(t, hashOf("synapse-a"))
+	assert.Empty(t, hashOf("synapse-b"), "a change of release a does not touch release b")
+	assert.Empty(t, hashOf("standalone"))
+
+	reconcile("shared")
+	hashA, hashB, hashStandalone := hashOf("synapse-a"), hashOf("synapse-b"), hashOf("standalone")
+	assert.NotEmpty(t, hashB)
+	assert.NotEmpty(t, hashStandalone)
+	assert.NotEqual(t, hashA, hashB)
+	assert.NotEqual(t, hashA, hashStandalone)
+
+	configB := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "config-b"}, configB))
+	configB.Data["data"] = "b2"
+	require.NoError(t, r.Update(ctx, configB))
+	reconcile("config-b")
+	assert.Equal(t, hashA, hashOf("synapse-a"))
+	assert.Equal(t, hashStandalone, hashOf("standalone"))
+	assert.NotEqual(t, hashB, hashOf("synapse-b"))
+
+	status, err := r.namespaceStatus(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, status.Workloads, 3)
+	for _, workload := range status.Workloads {
+		assert.True(t, workload.Current, workload.Name)
+		assert.Equal(t, hashOf(workload.Name), workload.Hash)
+	}
+	assert.True(t, status.rolledOut(status.Hash))
+}
+
+func TestGroupByOwnerInjectsHashOfOwnGroup(t *testing.T) {
+	labels := map[string]string{"app.kubernetes.io/name": "synapse"}
+	r := newTestReconciler(t,
+		ownedBy(newTestConfigMap("config-a", labels, nil, "a1"), "a"),
+		newTestConfigMap("shared", labels, nil, "s1"),
+	)
+	r.GroupByOwner = true
+	ctx := context.Background()
+
+	owned, err := r.workloadHash(ctx, "matrix", deploymentWorkload(ownedBy(newGroupedDeployment("synapse-a"), "a").(*appsv1.Deployment)))
+	require.NoError(t, err)
+	standalone, err := r.workloadHash(ctx, "matrix", deploymentWorkload(newGroupedDeployment("standalone")))
+	require.NoError(t, err)
+	assert.NotEmpty(t, owned)
+	assert.NotEmpty(t, standalone)
+	assert.NotEqual(t, owned, standalone)
+}
+
+func component(obj client.Object, name string) client.Object {
+	labels := map[string]string{ComponentLabel: name}
+	for k, v := range obj.GetLabels() {
+		labels[k] = v
+	}
+	obj.SetLabels(labels)
+	return obj
+}
+
+func TestGroupByComponentRestartsOnlyTheBridge(t *testing.T) {
+	ctx := context.Background()
+	labels := map[string]string{"app.kubernetes.io/name": "synapse"}
+	signal := component(newTestConfigMap("signal-config", labels, map[string]string{SignalHomeserverAnnotation: "true"}, "s1"), "bridge-signal")
+	r := newTestReconciler(t,
+		newGroupedDeployment("synapse"),
+		component(newGroupedDeployment("telegram"), "bridge-telegram"),
+		component(newGroupedDeployment("signal"), "bridge-signal"),
+		newTestConfigMap("homeserver", labels, nil, "h1"),
+		component(newTestConfigMap("telegram-config", labels, nil, "t1"), "bridge-telegram"),
+		signal,
+	)
+	r.GroupByComponent = true
+	hashOf := func(name string) string {
+		deploy := &appsv1.Deployment{}
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: name}, deploy))
+		return deploy.Spec.Template.Annotations[testHashAnnotation]
+	}
+	reconcile := func(name string) {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: name}})
+		require.NoError(t, err)
+	}
+
+	reconcile("telegram-config")
+	assert.NotEmpty(t, hashOf("telegram"))
+	assert.Empty(t, hashOf("synapse"), "a bridge's config change leaves the homeserver alone")
+	assert.Empty(t, hashOf("signal"))
+
+	reconcile("homeserver")
+	hashSynapse, hashTelegram := hashOf("synapse"), hashOf("telegram")
+	require.NotEmpty(t, hashSynapse)
+	require.NotEmpty(t, hashOf("signal"))
+
+	config := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "signal-config"}, config))
+	config.Data["data"] = "s2"
+	require.NoError(t, r.Update(ctx, config))
+	hashSignal := hashOf("signal")
+	reconcile("signal-config")
+	assert.NotEqual(t, hashSignal, hashOf("signal"))
+	assert.NotEqual(t, hashSynapse, hashOf("synapse"), "a source signalling the homeserver restarts it too")
+	assert.Equal(t, hashTelegram, hashOf("telegram"))
+
+	status, err := r.namespaceStatus(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, status.Workloads, 3)
+	for _, workload := range status.Workloads {
+		assert.True(t, workload.Current, workload.Name)
+	}
+}
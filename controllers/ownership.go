@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ManagedByAnnotation marks the metadata of every workload the operator rolls out. It is removed when the
+// workload stops being targeted, so the annotation always shows which workloads are currently managed.
+const ManagedByAnnotation = "synapse.gen0sec.com/managed-by"
+
+// managedByValue is the value of ManagedByAnnotation.
+const managedByValue = "synapse-operator"
+
+// markManaged records ownership of w on its metadata, along with the GitOps companion annotations, once.
+func (r *ConfigMapReconciler) markManaged(ctx context.Context, w *workload) error {
+	original := w.obj.DeepCopyObject().(client.Object)
+	annotations := w.obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	companions := r.setCompanionAnnotations(annotations)
+	if annotations[ManagedByAnnotation] == managedByValue && !companions {
+		return nil
+	}
+	annotations[ManagedByAnnotation] = managedByValue
+	w.obj.SetAnnotations(annotations)
+	return r.Patch(ctx, w.obj, client.MergeFrom(original))
+}
+
+// targets reports whether the operator currently rolls out w: it is outside ExcludedNamespaces and matches
+// the label selector or, with image detection, runs a detected image.
+func (r *ConfigMapReconciler) targets(w *workload) bool {
+	if r.namespaceExcluded(w.obj.GetNamespace()) {
+		return false
+	}
+	if r.DetectByImage != "" {
+		return r.runsDetectedImage(w)
+	}
+	return r.workloadSelector().Matches(labels.Set(w.obj.GetLabels()))
+}
+
+// releaseWorkload stops tracking a workload that is no longer targeted: it records a Released event saying
+// why, drops any hash held back from it, removes ManagedByAnnotation and, with CleanupReleasedWorkloads, the
+// other annotations the operator keeps on workload metadata. The pod template is only touched with
+// CleanupReleasedPodTemplates, which removes the stale config hash from it and so restarts the workload once.
+func (r *ConfigMapReconciler) releaseWorkload(ctx context.Context, w *workload) error {
+	r.clearBlocked(w, blockedReasonRecreate)
+	r.clearBlocked(w, blockedReasonCanaryApproval)
+	r.clearBlocked(w, blockedReasonRateLimited)
+	r.clearBlocked(w, blockedReasonPDB)
+	r.forgetProgress(w)
+
+	original := w.obj.DeepCopyObject().(client.Object)
+	annotations := w.obj.GetAnnotations()
+	delete(annotations, ManagedByAnnotation)
+	if r.CleanupReleasedWorkloads {
+		for _, key := range []string{r.ConfigHashAnnotation, RolloutHistoryAnnotation, restartRequestedAtAnnotation, canaryHashAnnotation, ConfigSourcesAnnotation, RestartNowHandledAnnotation, namespaceRestartNowHandledAnnotation} {
+			delete(annotations, key)
+		}
+		r.removeCompanionAnnotations(annotations)
+	}
+	w.obj.SetAnnotations(annotations)
+	cleanTemplate := r.CleanupReleasedPodTemplates && hasAnyKey(w.template.Annotations, r.ConfigHashAnnotation, versionedSourcesAnnotation)
+	if cleanTemplate {
+		delete(w.template.Annotations, r.ConfigHashAnnotation)
+		delete(w.template.Annotations, versionedSourcesAnnotation)
+		delete(w.template.Annotations, RestartCauseAnnotation)
+		delete(w.template.Annotations, RestartCauseTimeAnnotation)
+	}
+	if err := r.Patch(ctx, w.obj, client.MergeFrom(original)); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("No longer targeted by the operator because %s; config changes will not restart this %s", r.releaseReason(w), w.kind)
+	if r.CleanupReleasedWorkloads {
+		message += "; operator annotations removed from its metadata"
+	}
+	if cleanTemplate {
+		message += "; stale config hash removed from its pod template"
+	}
+	log.FromContext(ctx).Info("Released workload that is no longer targeted", w.logKey(), w.obj.GetName(), "namespace", w.obj.GetNamespace())
+	r.event(w.obj, corev1.EventTypeNormal, "Released", message)
+	return nil
+}
+
+// releaseReason explains why w is no longer targeted.
+func (r *ConfigMapReconciler) releaseReason(w *workload) string {
+	switch {
+	case r.namespaceExcluded(w.obj.GetNamespace()):
+		return "its namespace is excluded"
+	case r.DetectByImage != "":
+		return "it no longer runs an image matching the image detection pattern"
+	default:
+		return fmt.Sprintf("its labels no longer match the workload selector %q", r.workloadSelector().String())
+	}
+}
+
+func hasAnyKey(m map[string]string, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := m[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// workloadKind is one kind of workload the operator rolls out, for controllers watching workloads.
+type workloadKind struct {
+	name string
+	// group and resource name the kind in RBAC rules.
+	group    string
+	resource string
+	newObj   func() client.Object
+	newList  func() client.ObjectList
+	wrap     func(client.Object) *workload
+}
+
+// workloadKinds returns the kinds of workload the operator rolls out: CronJobs only with ManageCronJobs, and
+// with LeastPrivilege only the kinds it has not been denied access to.
+func (r *ConfigMapReconciler) workloadKinds() []workloadKind {
+	kinds := []workloadKind{
+		{
+			name:     "deployment",
+			group:    "apps",
+			resource: "deployments",
+			newObj:   func() client.Object { return &appsv1.Deployment{} },
+			newList:  func() client.ObjectList { return &appsv1.DeploymentList{} },
+			wrap:     func(obj client.Object) *workload { return deploymentWorkload(obj.(*appsv1.Deployment)) },
+		},
+		{
+			name:     "daemonset",
+			group:    "apps",
+			resource: "daemonsets",
+			newObj:   func() client.Object { return &appsv1.DaemonSet{} },
+			newList:  func() client.ObjectList { return &appsv1.DaemonSetList{} },
+			wrap:     func(obj client.Object) *workload { return daemonSetWorkload(obj.(*appsv1.DaemonSet)) },
+		},
+		{
+			name:     "statefulset",
+			group:    "apps",
+			resource: "statefulsets",
+			newObj:   func() client.Object { return &appsv1.StatefulSet{} },
+			newList:  func() client.ObjectList { return &appsv1.StatefulSetList{} },
+			wrap:     func(obj client.Object) *workload { return statefulSetWorkload(obj.(*appsv1.StatefulSet)) },
+		},
+	}
+	if r.ManageCronJobs {
+		kinds = append(kinds, workloadKind{
+			name:     "cronjob",
+			group:    "batch",
+			resource: "cronjobs",
+			newObj:   func() client.Object { return &batchv1.CronJob{} },
+			newList:  func() client.ObjectList { return &batchv1.CronJobList{} },
+			wrap:     func(obj client.Object) *workload { return cronJobWorkload(obj.(*batchv1.CronJob)) },
+		})
+	}
+	return slices.DeleteFunc(kinds, func(kind workloadKind) bool { return r.kindDenied(kind.name) })
+}
+
+// workloadReleaseReconciler releases managed workloads of one kind once they stop being targeted.
+type workloadReleaseReconciler struct {
+	parent *ConfigMapReconciler
+	name   string
+	newObj func() client.Object
+	wrap   func(client.Object) *workload
+}
+
+func (r *workloadReleaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	obj := r.newObj()
+	if err := r.parent.Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	w := r.wrap(obj)
+	if obj.GetAnnotations()[ManagedByAnnotation] != managedByValue || r.parent.targets(w) {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, r.parent.releaseWorkload(ctx, w)
+}
+
+// setupWorkloadRelease watches workloads for ones that carry ManagedByAnnotation but are no longer targeted.
+func (r *ConfigMapReconciler) setupWorkloadRelease(mgr ctrl.Manager) error {
+	var kinds []workloadReleaseReconciler
+	for _, kind := range r.workloadKinds() {
+		kinds = append(kinds, workloadReleaseReconciler{name: "release-" + kind.name, newObj: kind.newObj, wrap: kind.wrap})
+	}
+	for i := range kinds {
+		release := &kinds[i]
+		release.parent = r
+		released := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetAnnotations()[ManagedByAnnotation] == managedByValue && !r.namespaceExcluded(obj.GetNamespace()) && !r.targets(release.wrap(obj))
+		})
+		if err := ctrl.NewControllerManagedBy(mgr).
+			Named(release.name).
+			For(release.newObj(), builder.WithPredicates(released, predicate.Funcs{
+				DeleteFunc: func(event.DeleteEvent) bool { return false },
+			})).
+			Complete(release); err != nil {
+			return err
+		}
+	}
+	return nil
+}
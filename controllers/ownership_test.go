@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestReleaseWorkloadAfterLabelRemoval(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(map[string]string{RestartStrategyAnnotation: StrategyRestartedAt})
+	r := newTestReconciler(t, deploy)
+	r.CleanupReleasedWorkloads = true
+	r.LabelSelector = labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "synapse"})
+	release := &workloadReleaseReconciler{
+		parent: r,
+		newObj: func() client.Object { return &appsv1.Deployment{} },
+		wrap:   func(obj client.Object) *workload { return deploymentWorkload(obj.(*appsv1.Deployment)) },
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deploy)}
+
+	_, err := r.rolloutWorkloads(ctx, "matrix", "one", logr.Discard())
+	require.NoError(t, err)
+	var current appsv1.Deployment
+	require.NoError(t, r.Get(ctx, req.NamespacedName, &current))
+	assert.Equal(t, managedByValue, current.Annotations[ManagedByAnnotation])
+	assert.Equal(t, "one", current.Annotations[testHashAnnotation])
+
+	// Still targeted: nothing to release.
+	_, err = release.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, req.NamespacedName, &current))
+	assert.Contains(t, current.Annotations, ManagedByAnnotation)
+
+	current.Labels = nil
+	require.NoError(t, r.Update(ctx, &current))
+	_, err = release.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, req.NamespacedName, &current))
+	assert.NotContains(t, current.Annotations, ManagedByAnnotation)
+	assert.NotContains(t, current.Annotations, testHashAnnotation)
+	assert.Equal(t, StrategyRestartedAt, current.Annotations[RestartStrategyAnnotation])
+	assert.NotEmpty(t, current.Spec.Template.Annotations[DefaultRestartedAtAnnotation])
+}
+
+func TestReleaseWorkloadCleansPodTemplate(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(map[string]string{ManagedByAnnotation: managedByValue})
+	deploy.Labels = map[string]string{"app.kubernetes.io/name": "element"}
+	deploy.Spec.Template.Annotations = map[string]string{testHashAnnotation: "one", "prometheus.io/scrape": "true"}
+	recorder := record.NewFakeRecorder(10)
+	r := newTestReconciler(t, deploy)
+	r.Recorder = recorder
+	r.CleanupReleasedPodTemplates = true
+	r.LabelSelector = labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "synapse"})
+
+	require.NoError(t, r.releaseWorkload(ctx, deploymentWorkload(deploy)))
+	var current appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), &current))
+	assert.Equal(t, map[string]string{"prometheus.io/scrape": "true"}, current.Spec.Template.Annotations)
+	event := <-recorder.Events
+	assert.Contains(t, event, "Released")
+	assert.Contains(t, event, `no longer match the workload selector "app.kubernetes.io/name=synapse"`)
+	assert.Contains(t, event, "stale config hash removed from its pod template")
+}
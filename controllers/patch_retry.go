@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/tracing"
+)
+
+// maxPatchRetryBackoff caps the wait between two attempts of a workload write.
+const maxPatchRetryBackoff = 30 * time.Second
+
+// patchRetryReason classifies an error of a workload write that is worth retrying, or returns "" for one
+// that is not.
+func patchRetryReason(err error) string {
+	switch {
+	case err == nil, isFieldManagerConflict(err):
+		return ""
+	case apierrors.IsConflict(err):
+		return "conflict"
+	case apierrors.IsTooManyRequests(err):
+		return "throttled"
+	case apierrors.IsServerTimeout(err), apierrors.IsTimeout(err):
+		return "timeout"
+	case apierrors.IsServiceUnavailable(err), apierrors.IsInternalError(err):
+		return "unavailable"
+	}
+	return ""
+}
+
+// applyWithRetry applies hash to the workload of p with its strategy. A conflict or a transient API error is
+// retried up to PatchRetryAttempts times in all, after a jittered exponential backoff starting at
+// PatchRetryBackoff, each time on a fresh copy of the workload read from the API server. p.w is replaced with
+// the copy the last attempt wrote.
+func (r *ConfigMapReconciler) applyWithRetry(ctx context.Context, p *plannedRestart, hash string, logger logr.Logger) (restartOutcome, error) {
+	ctx, span := tracing.Start(ctx, "Patch "+p.w.kind,
+		tracing.NamespaceKey.String(p.w.obj.GetNamespace()),
+		tracing.WorkloadKindKey.String(p.w.kind),
+		tracing.WorkloadNameKey.String(p.w.obj.GetName()),
+		tracing.StrategyKey.String(p.strategyName),
+		tracing.ConfigHashKey.String(hash))
+	outcome, err := r.retryApply(ctx, p, hash, logger)
+	span.SetAttributes(tracing.UpdatedKey.Bool(outcome.updated))
+	tracing.End(span, err)
+	return outcome, err
+}
+
+// retryApply is applyWithRetry within its span.
+func (r *ConfigMapReconciler) retryApply(ctx context.Context, p *plannedRestart, hash string, logger logr.Logger) (restartOutcome, error) {
+	attempts := max(r.PatchRetryAttempts, 1)
+	delay := r.PatchRetryBackoff
+	var updated bool
+	for attempt := 1; ; attempt++ {
+		outcome, err := p.strategy.apply(ctx, r, p.w, hash)
+		// A strategy writing in several steps may have written some before failing.
+		updated = updated || outcome.updated
+		outcome.updated = updated
+		reason := patchRetryReason(err)
+		if reason == "" {
+			return outcome, err
+		}
+		if attempt >= attempts {
+			if attempts > 1 {
+				workloadPatchRetriesExhaustedTotal.WithLabelValues(r.ClusterName, p.w.obj.GetNamespace()).Inc()
+				err = fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+			}
+			return outcome, err
+		}
+		workloadPatchRetriesTotal.WithLabelValues(r.ClusterName, p.w.obj.GetNamespace(), reason).Inc()
+		pause := wait.Jitter(delay, 0.5)
+		logger.Info("Retrying "+p.w.logKey()+" update", "reason", reason, "attempt", attempt, "retryIn", pause, "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return outcome, err
+		case <-time.After(pause):
+		}
+		delay = min(2*delay, maxPatchRetryBackoff)
+		fresh, err := r.freshWorkload(ctx, p.w)
+		if err != nil {
+			return outcome, err
+		}
+		p.w = fresh
+	}
+}
+
+// freshWorkload reads the current version of the object of w from the API server.
+func (r *ConfigMapReconciler) freshWorkload(ctx context.Context, w *workload) (*workload, error) {
+	key := client.ObjectKeyFromObject(w.obj)
+	switch w.obj.(type) {
+	case *appsv1.Deployment:
+		obj := &appsv1.Deployment{}
+		if err := r.reader().Get(ctx, key, obj); err != nil {
+			return nil, err
+		}
+		return deploymentWorkload(obj), nil
+	case *appsv1.DaemonSet:
+		obj := &appsv1.DaemonSet{}
+		if err := r.reader().Get(ctx, key, obj); err != nil {
+			return nil, err
+		}
+		return daemonSetWorkload(obj), nil
+	case *appsv1.StatefulSet:
+		obj := &appsv1.StatefulSet{}
+		if err := r.reader().Get(ctx, key, obj); err != nil {
+			return nil, err
+		}
+		return statefulSetWorkload(obj), nil
+	case *batchv1.CronJob:
+		obj := &batchv1.CronJob{}
+		if err := r.reader().Get(ctx, key, obj); err != nil {
+			return nil, err
+		}
+		return cronJobWorkload(obj), nil
+	}
+	return nil, fmt.Errorf("cannot re-read %s %s", w.kind, key)
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// conflictingPatches fails the first failures patches writing a config hash to a Deployment with a conflict.
+func conflictingPatches(r *ConfigMapReconciler, failures int) {
+	r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if deploy, ok := obj.(*appsv1.Deployment); ok && deploy.Spec.Template.Annotations[testHashAnnotation] != "" && failures > 0 {
+				failures--
+				return apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, obj.GetName(), nil)
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	})
+}
+
+func TestPatchConflictIsRetried(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", nil, nil, "a"))
+	r.PatchRetryAttempts = 3
+	conflictingPatches(r, 1)
+	retries := testutil.ToFloat64(workloadPatchRetriesTotal.WithLabelValues("", "matrix", "conflict"))
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.NotEmpty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Equal(t, retries+1, testutil.ToFloat64(workloadPatchRetriesTotal.WithLabelValues("", "matrix", "conflict")))
+}
+
+func TestPatchRetriesExhausted(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", nil, nil, "a"))
+	r.PatchRetryAttempts = 2
+	conflictingPatches(r, 5)
+	exhausted := testutil.ToFloat64(workloadPatchRetriesExhaustedTotal.WithLabelValues("", "matrix"))
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.Error(t, err)
+	assert.True(t, apierrors.IsConflict(err))
+	assert.Contains(t, err.Error(), "giving up after 2 attempts")
+	assert.Equal(t, exhausted+1, testutil.ToFloat64(workloadPatchRetriesExhaustedTotal.WithLabelValues("", "matrix")))
+}
+
+func TestPatchRetryReason(t *testing.T) {
+	resource := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	assert.Equal(t, "conflict", patchRetryReason(apierrors.NewConflict(resource, "synapse", nil)))
+	assert.Equal(t, "throttled", patchRetryReason(apierrors.NewTooManyRequests("slow down", 1)))
+	assert.Equal(t, "timeout", patchRetryReason(apierrors.NewServerTimeout(resource, "patch", 1)))
+	assert.Equal(t, "unavailable", patchRetryReason(apierrors.NewServiceUnavailable("down")))
+	assert.Empty(t, patchRetryReason(apierrors.NewForbidden(resource, "synapse", nil)))
+	assert.Empty(t, patchRetryReason(nil))
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// RolloutsPausedAnnotation on a Namespace suspends automatic restarts in it while "true". The would-be
+// hash is still computed and exposed; unpausing rolls out the latest one.
+const RolloutsPausedAnnotation = "synapse.gen0sec.com/rollouts-paused"
+
+// RolloutsPausedByAnnotation, RolloutsPausedAtAnnotation and RolloutsPausedReasonAnnotation record who paused
+// the rollouts of a Namespace with `synapse-operator pause`, when, and why. They are informational only.
+const (
+	RolloutsPausedByAnnotation     = "synapse.gen0sec.com/rollouts-paused-by"
+	RolloutsPausedAtAnnotation     = "synapse.gen0sec.com/rollouts-paused-at"
+	RolloutsPausedReasonAnnotation = "synapse.gen0sec.com/rollouts-paused-reason"
+)
+
+// rolloutsPaused returns the Namespace and whether its rollouts are paused.
+func (r *ConfigMapReconciler) rolloutsPaused(ctx context.Context, namespace string) (*corev1.Namespace, bool, error) {
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return ns, ns.Annotations[RolloutsPausedAnnotation] == "true", nil
+}
+
+// reportPaused exposes hash as pending for the paused namespace, recording an event when it changes.
+func (r *ConfigMapReconciler) reportPaused(ns *corev1.Namespace, hash string) {
+	if previous, ok := r.pendingHashes.Load(ns.Name); ok && previous == hash {
+		return
+	}
+	r.pendingHashes.Store(ns.Name, hash)
+	pendingConfigHashInfo.DeletePartialMatch(prometheus.Labels{"cluster": r.ClusterName, "namespace": ns.Name})
+	pendingConfigHashInfo.WithLabelValues(r.ClusterName, ns.Name, hash).Set(1)
+	rolloutsPausedGauge.WithLabelValues(r.ClusterName, ns.Name).Set(1)
+	message := fmt.Sprintf("Rollouts are paused; config hash %s is pending", hash)
+	if reason := ns.Annotations[RolloutsPausedReasonAnnotation]; reason != "" {
+		message += " (paused: " + reason + ")"
+	}
+	r.event(ns, corev1.EventTypeNormal, "RolloutsPaused", message)
+}
+
+// reportUnpaused clears the pending hash of a namespace that is no longer paused.
+func (r *ConfigMapReconciler) reportUnpaused(ns *corev1.Namespace) {
+	if _, ok := r.pendingHashes.LoadAndDelete(ns.Name); !ok {
+		return
+	}
+	rolloutsPausedGauge.DeleteLabelValues(r.ClusterName, ns.Name)
+	pendingConfigHashInfo.DeletePartialMatch(prometheus.Labels{"cluster": r.ClusterName, "namespace": ns.Name})
+	r.event(ns, corev1.EventTypeNormal, "RolloutsResumed", "Rollouts resumed; applying the latest config hash")
+}
+
+// enqueueForNamespace reconciles one config source of a Namespace whose pause annotation may have changed,
+// which is enough to re-evaluate the whole namespace.
+func (r *ConfigMapReconciler) enqueueForNamespace() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+		configMaps, secrets, err := r.listConfigSources(ctx, obj.GetName())
+		if err != nil {
+			log.FromContext(ctx).Error(err, "failed to list config sources of namespace", "namespace", obj.GetName())
+			return nil
+		}
+		names := make([]string, 0, len(configMaps))
+		for i := range configMaps {
+			names = append(names, configMaps[i].Name)
+		}
+		if len(names) == 0 {
+			for i := range secrets {
+				names = append(names, secrets[i].Name)
+			}
+		}
+		if len(names) == 0 {
+			return nil
+		}
+		sort.Strings(names)
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: obj.GetName(), Name: names[0]}}}
+	})
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestPausedNamespaceHoldsRollout(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "matrix",
+		Annotations: map[string]string{RolloutsPausedAnnotation: "true"},
+	}}
+	r := newTestReconciler(t, ns, newTestDeployment(nil), newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutsPausedGauge.WithLabelValues("", "matrix")))
+	pending, ok := r.pendingHashes.Load("matrix")
+	require.True(t, ok)
+
+	delete(ns.Annotations, RolloutsPausedAnnotation)
+	require.NoError(t, r.Update(ctx, ns))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Equal(t, pending, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Equal(t, 0, testutil.CollectAndCount(rolloutsPausedGauge))
+}
+
+func TestPausedMetricsKeepClustersApart(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix"}}
+	hub := &ConfigMapReconciler{}
+	spoke := &ConfigMapReconciler{ClusterName: "spoke"}
+	hub.reportPaused(ns, "abc")
+	spoke.reportPaused(ns, "def")
+	assert.Equal(t, 2, testutil.CollectAndCount(rolloutsPausedGauge))
+
+	hub.reportUnpaused(ns)
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutsPausedGauge.WithLabelValues("spoke", "matrix")), "resuming the hub namespace leaves the spoke's alone")
+	assert.Equal(t, float64(1), testutil.ToFloat64(pendingConfigHashInfo.WithLabelValues("spoke", "matrix", "def")))
+	spoke.reportUnpaused(ns)
+	assert.Equal(t, 0, testutil.CollectAndCount(rolloutsPausedGauge))
+}
@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"context"
+	"time"
+)
+
+// blockedReasonPDB marks a restart held because a PodDisruptionBudget of the workload allows no disruption.
+const blockedReasonPDB = "PDBAllowsNoDisruption"
+
+// pdbRecheckInterval is how often a restart held by a PodDisruptionBudget checks it again.
+const pdbRecheckInterval = 30 * time.Second
+
+// blockingPDB returns the name of a PodDisruptionBudget matching the pods of w that currently allows no
+// disruption, or "". A workload that is not available is never held: restarting it cannot take away
+// availability it does not have, and its new config may be the fix.
+func (r *ConfigMapReconciler) blockingPDB(ctx context.Context, w *workload) (string, error) {
+	if !w.available() {
+		return "", nil
+	}
+	pdbs, err := r.matchingPDBs(ctx, w)
+	if err != nil {
+		return "", err
+	}
+	for _, pdb := range pdbs {
+		// A budget whose status lags behind its spec says nothing yet.
+		if pdb.Status.ObservedGeneration < pdb.Generation {
+			continue
+		}
+		if pdb.Status.DisruptionsAllowed <= 0 {
+			return pdb.Name, nil
+		}
+	}
+	return "", nil
+}
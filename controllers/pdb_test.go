@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestPDB(disruptionsAllowed int32) *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "synapse", Namespace: "matrix"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "synapse"}}},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: disruptionsAllowed},
+	}
+}
+
+func TestDeferRestartsOnPDB(t *testing.T) {
+	ctx := context.Background()
+	pdb := newTestPDB(0)
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	r := newTestReconciler(t, cm, newTestDeployment(nil), pdb)
+	r.DeferRestartsOnPDB = true
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+	deployKey := client.ObjectKey{Namespace: "matrix", Name: "synapse"}
+
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, pdbRecheckInterval, result.RequeueAfter)
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, deployKey, &deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutBlockedGauge.WithLabelValues("", "matrix", "deployment/synapse", blockedReasonPDB)))
+
+	pdb.Status.DisruptionsAllowed = 1
+	require.NoError(t, r.Status().Update(ctx, pdb))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, deployKey, &deploy))
+	assert.NotEmpty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Zero(t, testutil.CollectAndCount(rolloutBlockedGauge.MustCurryWith(map[string]string{"reason": blockedReasonPDB})))
+}
+
+func TestBlockingPDBIgnoresUnavailableWorkloads(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	r := newTestReconciler(t, newTestPDB(0))
+
+	pdb, err := r.blockingPDB(ctx, deploymentWorkload(deploy))
+	require.NoError(t, err)
+	assert.Equal(t, "synapse", pdb)
+
+	// A crash-looping workload gets its new config, which may be the fix.
+	deploy.Status.AvailableReplicas = 0
+	pdb, err = r.blockingPDB(ctx, deploymentWorkload(deploy))
+	require.NoError(t, err)
+	assert.Empty(t, pdb)
+}
@@ -0,0 +1,566 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"synapse-operator/audit"
+	"synapse-operator/pipeline"
+	"synapse-operator/tracing"
+)
+
+// rolloutState is what the stages of a reconcile hand down to each other.
+type rolloutState struct {
+	// trigger is the ConfigMap or Secret whose event started the reconcile.
+	trigger types.NamespacedName
+	// triggerSource is the trigger as "<kind>/<name>", set by Collect once the source is found.
+	triggerSource string
+	// configMaps and secrets are the config sources of the namespace, set by Collect.
+	configMaps []corev1.ConfigMap
+	secrets    []corev1.Secret
+	// secretData holds secrets with their values, read by the first stage that needs them; see
+	// readSecretData.
+	secretData     []corev1.Secret
+	secretDataRead bool
+	// hashed lists the "<kind>/<name>" keys of the sources that went into the hash, set by Hash.
+	hashed []string
+	// legacy is the legacy hash of the sources, computed by Schedule for the first workload that needs it.
+	legacy legacyHashMemo
+	// planned holds the targeted workloads with their restart strategy; pending counts those behind the hash.
+	planned []plannedRestart
+	pending int
+	// held is set when Schedule held a workload behind the hash back; adopted maps the key of each workload
+	// that kept its applied hash on startup to that hash.
+	held    bool
+	adopted map[string]string
+	// allowed is how many pending workloads may restart in this pass; slotWait is the wait for the next slot.
+	allowed  int
+	slotWait time.Duration
+	// applied holds the workloads the Apply stage went through, in order.
+	applied []appliedRestart
+	// applyErr stops the Apply stage; Verify still follows up on the workloads applied before it.
+	applyErr error
+	// pluginRollout is the rollout handed to plugin gates, listed by the first one.
+	pluginRollout *pipeline.Rollout
+}
+
+type rolloutPass = pipeline.Pass[rolloutState]
+
+// appliedRestart is a planned restart with the outcome of its strategy.
+type appliedRestart struct {
+	plannedRestart
+	outcome restartOutcome
+}
+
+// decisionGate holds a rollout back by halting the pass, or lets it through.
+type decisionGate func(ctx context.Context, pass *rolloutPass) error
+
+// reconcilePipeline returns the stages of a full reconcile.
+func (r *ConfigMapReconciler) reconcilePipeline() pipeline.Pipeline[rolloutState] {
+	return pipeline.Pipeline[rolloutState]{
+		stage(pipeline.Collect, r.collectSources),
+		stage(pipeline.Hash, r.hashSources),
+		stage(pipeline.Decide, r.decide),
+		stage(pipeline.Schedule, r.scheduleRestarts),
+		stage(pipeline.Apply, r.applyRestarts),
+		stage(pipeline.Verify, r.verifyRestarts),
+	}
+}
+
+// rolloutPipeline returns the stages that roll a known hash out to the workloads of a namespace.
+func (r *ConfigMapReconciler) rolloutPipeline() pipeline.Pipeline[rolloutState] {
+	return pipeline.Pipeline[rolloutState]{
+		stage(pipeline.Schedule, r.scheduleRestarts),
+		stage(pipeline.Apply, r.applyRestarts),
+		stage(pipeline.Verify, r.verifyRestarts),
+	}
+}
+
+// stage wraps run as a pipeline stage. Its work is labelled with the stage and namespace in CPU profiles,
+// so profiles taken through --pprof-bind-address can be broken down by stage with `-tagfocus`, and traced
+// as a span named after the stage.
+func stage(name string, run func(context.Context, *rolloutPass) error) pipeline.Stage[rolloutState] {
+	return pipeline.StageFunc[rolloutState]{StageName: name, Func: func(ctx context.Context, pass *rolloutPass) error {
+		ctx, span := tracing.Start(ctx, name, tracing.NamespaceKey.String(pass.Namespace))
+		var err error
+		pprof.Do(ctx, pprof.Labels("stage", name, "namespace", pass.Namespace), func(ctx context.Context) {
+			err = run(ctx, pass)
+		})
+		if pass.Hash != "" {
+			span.SetAttributes(tracing.ConfigHashKey.String(pass.Hash))
+		}
+		if _, reason := pass.Halted(); reason != "" {
+			span.SetAttributes(tracing.HaltReasonKey.String(reason))
+		}
+		tracing.End(span, err)
+		return err
+	}}
+}
+
+// decisionGates returns the gates of the Decide stage, in order.
+func (r *ConfigMapReconciler) decisionGates() []decisionGate {
+	gates := []decisionGate{r.pausedGate}
+	if r.RolloutLock {
+		gates = append(gates, r.rolloutLockGate)
+	}
+	gates = append(gates, r.startupSettleGate, r.configSchemaGate)
+	if r.ConfigCheckURL != "" {
+		gates = append(gates, r.configCheckGate)
+	}
+	if r.ValidationJobImage != "" {
+		gates = append(gates, r.validationJobGate)
+	}
+	if r.CanaryNamespaces {
+		gates = append(gates, r.canaryNamespaceGate)
+	}
+	gates = append(gates, r.rolloutDependencyGate)
+	if len(r.RolloutTiers) > 0 {
+		gates = append(gates, r.rolloutTierGate)
+	}
+	gates = append(gates, r.approvalGate)
+	for _, gate := range r.Gates {
+		gates = append(gates, r.pluginGate(gate))
+	}
+	return gates
+}
+
+// collectSources reports the diff of the triggering source and lists the config sources of the namespace.
+func (r *ConfigMapReconciler) collectSources(ctx context.Context, pass *rolloutPass) error {
+	trigger := pass.State.trigger
+	var cfg corev1.ConfigMap
+	if err := r.Get(ctx, trigger, &cfg); err == nil {
+		pass.Logger = pass.Logger.WithValues("kind", "ConfigMap")
+		trace.SpanFromContext(ctx).SetAttributes(tracing.SourceKindKey.String("ConfigMap"), tracing.SourceNameKey.String(trigger.Name))
+		audit.FromContext(ctx).SetTrigger("configmap/" + trigger.Name)
+		pass.State.triggerSource = "configmap/" + trigger.Name
+		if err := r.reportConfigDiff(ctx, &cfg, pass.Logger); err != nil {
+			pass.Logger.Error(err, "failed to render config diff")
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	} else {
+		r.forgetConfigSnapshot(trigger)
+		var secret corev1.Secret
+		if err := r.Get(ctx, trigger, &secret); err == nil {
+			pass.Logger = pass.Logger.WithValues("kind", "Secret")
+			trace.SpanFromContext(ctx).SetAttributes(tracing.SourceKindKey.String("Secret"), tracing.SourceNameKey.String(trigger.Name))
+			audit.FromContext(ctx).SetTrigger("secret/" + trigger.Name)
+			pass.State.triggerSource = "secret/" + trigger.Name
+			r.reportSecretDiff(&secret, pass.Logger)
+		} else if !apierrors.IsNotFound(err) {
+			return err
+		} else {
+			r.forgetSecretSnapshot(trigger)
+		}
+	}
+
+	configMaps, secrets, err := r.listConfigSources(ctx, pass.Namespace)
+	if err != nil {
+		return err
+	}
+	pass.State.configMaps, pass.State.secrets = configMaps, secrets
+	return nil
+}
+
+// hashSources combines the collected sources into the config hash and halts when there are none.
+func (r *ConfigMapReconciler) hashSources(ctx context.Context, pass *rolloutPass) error {
+	hashCtx, sources := withHashedSources(ctx)
+	hash, settleAfter, err := r.combineSources(hashCtx, pass.Namespace, pass.State.configMaps, pass.State.secrets)
+	if err != nil {
+		return err
+	}
+	r.reportHashedSources(pass.Namespace, sources)
+	pass.State.hashed = sources.hashed
+	configMaps, secrets := pass.State.configMaps, pass.State.secrets
+	if group, ok := r.groupScoped(ctx); ok {
+		configMaps, secrets = r.ownSources(group, configMaps, secrets)
+	}
+	r.countSuppressedChanges(ctx, pass.Namespace, configMaps, secrets)
+	pass.RequeueAfter(settleAfter)
+	// Vault rotations raise no Kubernetes events, so they are only noticed by reading the secrets again.
+	pass.RequeueAfter(r.vaultPollInterval(pass.State.configMaps, pass.State.secrets))
+	if settleAfter > 0 {
+		pass.Logger.Info("Holding back debounced config source changes", "settleAfter", settleAfter)
+	}
+	if hash == "" {
+		pass.Logger.Info("No config sources found, skipping rollout")
+		audit.FromContext(ctx).Finish(audit.ResultNoSources, nil)
+		// Nothing reaches verify any more to give the slot back.
+		r.dropRollout(pass.Namespace)
+		pass.Halt("no config sources")
+		return nil
+	}
+	audit.FromContext(ctx).SetHash(hash)
+	pass.Hash = hash
+	return nil
+}
+
+// decide runs the decision gates until one holds the rollout back.
+func (r *ConfigMapReconciler) decide(ctx context.Context, pass *rolloutPass) error {
+	for _, gate := range r.decisionGates() {
+		if err := gate(ctx, pass); err != nil {
+			return err
+		}
+		if _, reason := pass.Halted(); reason != "" {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *ConfigMapReconciler) pausedGate(ctx context.Context, pass *rolloutPass) error {
+	ns, paused, err := r.rolloutsPaused(ctx, pass.Namespace)
+	if err != nil {
+		return err
+	}
+	if !paused {
+		if ns != nil {
+			r.reportUnpaused(ns)
+		}
+		return nil
+	}
+	pass.Logger.Info("Rollouts are paused in namespace, holding config hash", "configHash", pass.Hash)
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "rollouts-paused", Detail: RolloutsPausedAnnotation + " is set on the namespace"})
+	r.reportPaused(ns, pass.Hash)
+	pass.Halt("rollouts paused")
+	return nil
+}
+
+func (r *ConfigMapReconciler) rolloutLockGate(ctx context.Context, pass *rolloutPass) error {
+	holder, wait, err := r.rolloutLocked(ctx, pass.Namespace, time.Now())
+	if err != nil || holder == "" {
+		return err
+	}
+	pass.Logger.Info("Holding rollout while the rollout lock is held", "configHash", pass.Hash, "holder", holder)
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "rollout-lock", Detail: fmt.Sprintf("Lease %s held by %s", RolloutLockName, holder)})
+	pass.RequeueAfter(wait)
+	pass.Halt("rollout lock held")
+	return nil
+}
+
+func (r *ConfigMapReconciler) startupSettleGate(ctx context.Context, pass *rolloutPass) error {
+	wait := r.startupSettleRemaining(time.Now())
+	if wait <= 0 {
+		return nil
+	}
+	pass.Logger.Info("Holding back rollout until the operator has settled after startup", "configHash", pass.Hash, "remaining", wait)
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "startup-settle", Detail: fmt.Sprintf("rollouts resume in %s", wait.Round(time.Second))})
+	pass.RequeueAfter(wait)
+	pass.Halt("startup settle delay")
+	return nil
+}
+
+// scheduleRestarts resolves the restart strategy of every targeted workload, holds back the ones that need
+// confirmation, and works out how many may restart in this pass.
+func (r *ConfigMapReconciler) scheduleRestarts(ctx context.Context, pass *rolloutPass) error {
+	workloads, err := r.listWorkloads(ctx, pass.Namespace)
+	if err != nil {
+		return err
+	}
+	// High-priority workloads come first, so they take the gradual rollout slots first.
+	sortByPriority(workloads)
+
+	hash := pass.Hash
+	rec := audit.FromContext(ctx)
+	now := time.Now()
+	adoption, firstPass, err := r.startupAdoption(ctx, pass)
+	if err != nil {
+		return err
+	}
+	planned := make([]plannedRestart, 0, len(workloads))
+	pending := 0
+	for _, w := range workloads {
+		logger := pass.Logger.WithValues(w.logKey(), w.obj.GetName())
+		name, strategy, err := r.strategyFor(w)
+		if err != nil {
+			logger.Error(err, "skipping workload with invalid restart strategy")
+			r.traceEvent(ctx, w.obj, corev1.EventTypeWarning, "InvalidRestartStrategy", err.Error())
+			rec.AddGate(audit.Gate{Name: "restart-strategy", Workload: w.key(), Detail: err.Error()})
+			pass.State.held = true
+			continue
+		}
+		if err := r.markManaged(ctx, w); err != nil {
+			logger.Error(err, "failed to mark workload as managed")
+		}
+		p := plannedRestart{w: w, strategyName: name, strategy: strategy, appliedHash: strategy.appliedHash(r, w)}
+		legacy, err := r.acceptsLegacyHash(ctx, pass.Namespace, p.appliedHash, hash, &pass.State.legacy, func() ([]corev1.ConfigMap, []corev1.Secret, error) {
+			secrets, err := r.passSecretData(ctx, pass)
+			return pass.State.configMaps, secrets, err
+		})
+		if err != nil {
+			return err
+		}
+		if firstPass && adoption.behind && p.appliedHash != hash && p.appliedHash != "" {
+			adoption.applied[w.key()] = p.appliedHash
+		}
+		if p.appliedHash != hash && adoption.adopted(w, p.appliedHash) {
+			if firstPass {
+				logger.Info("Keeping the config hash the operator found on startup; the workload restarts with the next config change", "appliedHash", p.appliedHash, "configHash", hash)
+			}
+			if pass.State.adopted == nil {
+				pass.State.adopted = map[string]string{}
+			}
+			pass.State.adopted[w.key()] = p.appliedHash
+			rec.AddGate(audit.Gate{Name: "startup-sync", Workload: w.key(), Detail: "applied hash adopted on startup, no source changed while the operator was down"})
+			continue
+		}
+		if legacy {
+			logger.V(1).Info("Keeping the "+legacyHashVersion+" config hash, which matches the current config", "appliedHash", p.appliedHash, "configHash", hash)
+			rec.AddGate(audit.Gate{Name: "legacy-hash", Workload: w.key(), Detail: "applied " + legacyHashVersion + " hash matches the current config"})
+			continue
+		}
+		if p.appliedHash != hash && r.HelmCoalesceWindow > 0 {
+			coalesced, err := r.coalesceHelmUpgrade(ctx, pass, w, hash)
+			if err != nil {
+				return err
+			}
+			if coalesced {
+				pass.State.held = true
+				continue
+			}
+		}
+		if p.appliedHash != hash && r.recreateBlocked(w) {
+			logger.Info("Holding restart of Recreate deployment until confirmed", "configHash", hash)
+			rec.AddGate(audit.Gate{Name: "recreate-confirmation", Workload: w.key(), Detail: "Recreate strategy without " + AllowRecreateRestartsAnnotation})
+			r.reportBlocked(w, hash, blockedReasonRecreate, fmt.Sprintf("Config hash %s is pending: the Recreate strategy takes every pod down at once; annotate %s=true to allow the restart", hash, AllowRecreateRestartsAnnotation))
+			pass.State.held = true
+			continue
+		}
+		r.clearBlocked(w, blockedReasonRecreate)
+		if p.appliedHash != hash {
+			interval, err := r.minRestartInterval(w)
+			if err != nil {
+				logger.Error(err, "using the default minimum restart interval")
+				r.traceEvent(ctx, w.obj, corev1.EventTypeWarning, "InvalidMinRestartInterval", err.Error())
+			}
+			// A later hash replaces the held one, so only the latest config is rolled out once the wait is over.
+			wait, err := r.restartRateLimitWait(ctx, w, interval, now)
+			if err != nil {
+				return err
+			}
+			if wait > 0 {
+				logger.Info("Holding restart until the minimum restart interval has passed", "configHash", hash, "remaining", wait)
+				rec.AddGate(audit.Gate{Name: "restart-rate-limit", Workload: w.key(), Detail: fmt.Sprintf("restarted less than %s ago, next restart in %s", interval, wait.Round(time.Second))})
+				r.reportBlocked(w, hash, blockedReasonRateLimited, fmt.Sprintf("Config hash %s is pending: the workload restarted less than %s ago; it restarts with the latest config in %s", hash, interval, wait.Round(time.Second)))
+				pass.RequeueAfter(wait)
+				pass.State.held = true
+				continue
+			}
+			if r.DeferRestartsOnPDB {
+				pdb, err := r.blockingPDB(ctx, w)
+				if err != nil {
+					return err
+				}
+				if pdb != "" {
+					logger.Info("Holding restart while its PodDisruptionBudget allows no disruption", "configHash", hash, "pdb", pdb)
+					rec.AddGate(audit.Gate{Name: "pdb", Workload: w.key(), Detail: "PodDisruptionBudget " + pdb + " allows no disruption"})
+					r.reportBlocked(w, hash, blockedReasonPDB, fmt.Sprintf("Config hash %s is pending: PodDisruptionBudget %s allows no disruption, so a restart would stall; it is retried until the budget has room", hash, pdb))
+					pass.RequeueAfter(pdbRecheckInterval)
+					pass.State.held = true
+					continue
+				}
+			}
+			pending++
+		}
+		r.clearBlocked(w, blockedReasonRateLimited)
+		r.clearBlocked(w, blockedReasonPDB)
+		planned = append(planned, p)
+	}
+
+	if r.holdForRolloutBudget(ctx, pass, pending) {
+		return nil
+	}
+	allowed, slotWait, err := r.gradualSlots(ctx, pass.Namespace, hash, pending, now)
+	if err != nil {
+		return err
+	}
+	pass.RequeueAfter(slotWait)
+	pass.State.planned, pass.State.pending = planned, pending
+	pass.State.allowed, pass.State.slotWait = allowed, slotWait
+	return nil
+}
+
+// applyRestarts applies the hash to the planned workloads with their strategy, deferring pending restarts
+// beyond the allowed ones to a later gradual rollout slot.
+func (r *ConfigMapReconciler) applyRestarts(ctx context.Context, pass *rolloutPass) error {
+	hash := pass.Hash
+	rec := audit.FromContext(ctx)
+	allowed := pass.State.allowed
+	if r.AnnotateRestartCause && pass.State.triggerSource != "" {
+		ctx = withRestartCause(ctx, pass.State.triggerSource, time.Now())
+	}
+	for _, p := range pass.State.planned {
+		w := p.w
+		logger := pass.Logger.WithValues(w.logKey(), w.obj.GetName(), "strategy", p.strategyName)
+		if p.appliedHash != hash {
+			if allowed == 0 {
+				logger.Info("Deferring restart to a later gradual rollout slot", "nextSlotIn", pass.State.slotWait)
+				rec.AddGate(audit.Gate{Name: "gradual-rollout", Workload: w.key(), Detail: fmt.Sprintf("deferred, next slot in %s", pass.State.slotWait.Round(time.Second))})
+				continue
+			}
+			allowed--
+			if r.ReportImpact {
+				r.reportImpact(ctx, w, logger)
+			}
+			// The routes move first, so a failure to update them holds the restart back.
+			if r.SyncRoutes {
+				if err := r.syncRoutes(ctx, w); err != nil {
+					logger.Error(err, "failed to update the routes of "+w.logKey())
+					rec.AddAction(audit.Action{Workload: w.key(), Strategy: p.strategyName, FromHash: p.appliedHash, ToHash: hash, Error: err.Error()})
+					pass.State.applyErr = err
+					return nil
+				}
+			}
+		}
+
+		outcome, err := r.applyWithRetry(ctx, &p, hash, logger)
+		w = p.w
+		if p.appliedHash != hash || outcome.updated || err != nil {
+			action := audit.Action{Workload: w.key(), Strategy: p.strategyName, FromHash: p.appliedHash, ToHash: hash, Updated: outcome.updated}
+			if err != nil {
+				action.Error = err.Error()
+			}
+			rec.AddAction(action)
+		}
+		if r.patchDenied(ctx, w, err) {
+			continue
+		}
+		if err != nil {
+			logger.Error(err, "failed to update "+w.logKey()+" with new config hash")
+			pass.State.applyErr = err
+			return nil
+		}
+		if outcome.updated {
+			logger.Info("Updated "+w.logKey()+" to trigger restart", "configHash", hash)
+			if err := r.recordRestart(ctx, w, time.Now()); err != nil {
+				logger.Error(err, "failed to record restart time")
+			}
+			if err := r.stampTriggerID(ctx, w, p.strategyName); err != nil {
+				logger.Error(err, "failed to record trigger ID")
+			}
+			if r.RecordConfigSources {
+				if err := r.stampConfigSources(ctx, w, configSourcesProvenance(pass.State.hashed, pass.State.configMaps, pass.State.secrets)); err != nil {
+					logger.Error(err, "failed to record config sources")
+				}
+			}
+			if err := r.recordRolloutHistory(ctx, w, hash); err != nil {
+				logger.Error(err, "failed to record rollout history")
+			}
+		} else {
+			logger.V(1).Info(w.kind + " already up to date with config hash")
+		}
+		pass.RequeueAfter(outcome.requeueAfter)
+		pass.State.applied = append(pass.State.applied, appliedRestart{plannedRestart: p, outcome: outcome})
+	}
+	return nil
+}
+
+// verifyRestarts follows up on the applied workloads: it tracks their progress, restarts the in-flight Jobs
+// of CronJobs, and records the rollout in the namespace's history and, once complete, its last-known hash.
+func (r *ConfigMapReconciler) verifyRestarts(ctx context.Context, pass *rolloutPass) error {
+	var updated []string
+	for _, a := range pass.State.applied {
+		w := a.w
+		pass.RequeueAfter(r.trackProgress(ctx, w, pass.Hash, a.outcome.updated, time.Now()))
+		if w.kind == "CronJob" && r.RestartInFlightJobs {
+			wait, err := r.restartInFlightJobs(ctx, w, pass.Hash)
+			if err != nil {
+				pass.Logger.WithValues(w.logKey(), w.obj.GetName(), "strategy", a.strategyName).Error(err, "failed to restart in-flight jobs")
+				return err
+			}
+			pass.RequeueAfter(wait)
+		}
+		if a.outcome.updated {
+			updated = append(updated, w.key())
+		}
+	}
+	if pass.State.applyErr != nil {
+		return pass.State.applyErr
+	}
+	if err := r.recordLastKnownHash(ctx, pass); err != nil {
+		pass.Logger.Error(err, "failed to record last-known hash")
+	}
+	r.releaseRollout(pass.Namespace, pass.State.allowed < pass.State.pending)
+	if err := r.observeTierRollout(ctx, pass); err != nil {
+		pass.Logger.Error(err, "failed to record tier rollout")
+	}
+	if err := r.pruneSupersededConfigSources(ctx, pass.Namespace); err != nil {
+		pass.Logger.Error(err, "failed to prune superseded config revisions")
+	}
+
+	if len(updated) > 0 && r.RolloutHistoryRetention > 0 {
+		if err := r.recordRolloutResource(ctx, pass.Namespace, pass.Hash, updated, time.Now()); err != nil {
+			pass.Logger.Error(err, "failed to record rollout in "+RolloutHistoryGVK.Kind)
+		}
+	}
+	return r.runVerifiers(ctx, pass)
+}
+
+// pluginGate adapts a compiled-in Gate to the Decide stage.
+func (r *ConfigMapReconciler) pluginGate(gate pipeline.Gate) decisionGate {
+	return func(ctx context.Context, pass *rolloutPass) error {
+		rollout, err := r.pluginRollout(ctx, pass)
+		if err != nil {
+			return err
+		}
+		decision, err := gate.Check(ctx, rollout)
+		if err != nil {
+			return fmt.Errorf("gate %s: %w", gate.Name(), err)
+		}
+		if !decision.Hold {
+			return nil
+		}
+		pass.Logger.Info("Holding rollout on plugin gate", "gate", gate.Name(), "configHash", pass.Hash, "reason", decision.Reason, "retryAfter", decision.RetryAfter)
+		audit.FromContext(ctx).AddGate(audit.Gate{Name: gate.Name(), Detail: decision.Reason})
+		pass.RequeueAfter(decision.RetryAfter)
+		pass.Halt(gate.Name() + ": " + decision.Reason)
+		return nil
+	}
+}
+
+// pluginRollout describes the rollout of the pass to gates: the targeted workloads with the hash they run.
+// It is listed once per pass.
+func (r *ConfigMapReconciler) pluginRollout(ctx context.Context, pass *rolloutPass) (pipeline.Rollout, error) {
+	if pass.State.pluginRollout != nil {
+		return *pass.State.pluginRollout, nil
+	}
+	workloads, err := r.listWorkloads(ctx, pass.Namespace)
+	if err != nil {
+		return pipeline.Rollout{}, err
+	}
+	rollout := pipeline.Rollout{Namespace: pass.Namespace, Hash: pass.Hash}
+	for _, w := range workloads {
+		_, strategy, err := r.strategyFor(w)
+		if err != nil {
+			continue
+		}
+		rollout.Workloads = append(rollout.Workloads, pipeline.Workload{Kind: w.kind, Name: w.obj.GetName(), AppliedHash: strategy.appliedHash(r, w)})
+	}
+	pass.State.pluginRollout = &rollout
+	return rollout, nil
+}
+
+// runVerifiers hands the workloads Apply went through to the compiled-in verifiers.
+func (r *ConfigMapReconciler) runVerifiers(ctx context.Context, pass *rolloutPass) error {
+	if len(r.Verifiers) == 0 || len(pass.State.applied) == 0 {
+		return nil
+	}
+	rollout := pipeline.Rollout{Namespace: pass.Namespace, Hash: pass.Hash}
+	for _, a := range pass.State.applied {
+		rollout.Workloads = append(rollout.Workloads, pipeline.Workload{Kind: a.w.kind, Name: a.w.obj.GetName(), AppliedHash: a.appliedHash, Updated: a.outcome.updated})
+	}
+	for _, verifier := range r.Verifiers {
+		if err := verifier.Verify(ctx, rollout); err != nil {
+			pass.Logger.Error(err, "Rollout verification failed", "verifier", verifier.Name(), "configHash", pass.Hash)
+			return fmt.Errorf("verifier %s: %w", verifier.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"synapse-operator/pipeline"
+	"synapse-operator/tracing"
+)
+
+func TestDecideStageHaltsAtFirstHoldingGate(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{RolloutsPausedAnnotation: "true"}}}
+	r := newTestReconciler(t, ns)
+	r.StartupSettleDelay = time.Minute
+	pass := &rolloutPass{Namespace: "matrix", Logger: logr.Discard(), Hash: "one"}
+
+	require.NoError(t, r.decide(context.Background(), pass))
+	_, reason := pass.Halted()
+	assert.Equal(t, "rollouts paused", reason)
+	assert.Zero(t, pass.Requeue(), "the startup settle gate did not run")
+}
+
+func TestReconcilePipelineHaltsWithoutSources(t *testing.T) {
+	r := newTestReconciler(t, newTestDeployment(nil))
+	pass := &rolloutPass{Namespace: "matrix", Logger: logr.Discard(), State: rolloutState{trigger: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}}
+
+	require.NoError(t, r.reconcilePipeline().Run(context.Background(), pass))
+	stage, _ := pass.Halted()
+	assert.Equal(t, pipeline.Hash, stage)
+	assert.Empty(t, pass.Hash)
+}
+
+func TestScheduleStagePlansWorkloadsBehindHash(t *testing.T) {
+	deploy := newTestDeployment(nil)
+	deploy.Spec.Template.Annotations = map[string]string{testHashAnnotation: "one"}
+	r := newTestReconciler(t, deploy)
+	pass := &rolloutPass{Namespace: "matrix", Logger: logr.Discard(), Hash: "two"}
+
+	require.NoError(t, r.scheduleRestarts(context.Background(), pass))
+	require.Len(t, pass.State.planned, 1)
+	assert.Equal(t, "one", pass.State.planned[0].appliedHash)
+	assert.Equal(t, 1, pass.State.pending)
+	assert.Equal(t, 1, pass.State.allowed)
+}
+
+type testGate struct {
+	decision pipeline.Decision
+	seen     pipeline.Rollout
+}
+
+func (g *testGate) Name() string { return "change-calendar" }
+
+func (g *testGate) Check(_ context.Context, rollout pipeline.Rollout) (pipeline.Decision, error) {
+	g.seen = rollout
+	return g.decision, nil
+}
+
+type testVerifier struct {
+	err  error
+	seen pipeline.Rollout
+}
+
+func (v *testVerifier) Name() string { return "smoke-test" }
+
+func (v *testVerifier) Verify(_ context.Context, rollout pipeline.Rollout) error {
+	v.seen = rollout
+	return v.err
+}
+
+func TestPluginGateHoldsRollout(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	gate := &testGate{decision: pipeline.Decision{Hold: true, Reason: "change freeze", RetryAfter: time.Minute}}
+	r.Gates = []pipeline.Gate{gate}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, result.RequeueAfter)
+	require.Len(t, gate.seen.Workloads, 1)
+	assert.Equal(t, pipeline.Workload{Kind: "Deployment", Name: "synapse"}, gate.seen.Workloads[0])
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.NotContains(t, deploy.Spec.Template.Annotations, testHashAnnotation)
+
+	gate.decision = pipeline.Decision{}
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.Contains(t, deploy.Spec.Template.Annotations, testHashAnnotation)
+}
+
+func TestPluginVerifierFailsReconcile(t *testing.T) {
+	r := newTestReconciler(t, newTestDeployment(nil))
+	verifier := &testVerifier{err: errors.New("homeserver not answering")}
+	r.Verifiers = []pipeline.Verifier{verifier}
+
+	_, err := r.rolloutWorkloads(context.Background(), "matrix", "one", logr.Discard())
+	assert.ErrorContains(t, err, "verifier smoke-test: homeserver not answering")
+	require.Len(t, verifier.seen.Workloads, 1)
+	assert.True(t, verifier.seen.Workloads[0].Updated)
+	assert.Equal(t, "one", verifier.seen.Hash)
+}
+
+func TestStagesAreLabelledForProfiles(t *testing.T) {
+	var stageLabel, namespaceLabel string
+	s := stage(pipeline.Hash, func(ctx context.Context, _ *rolloutPass) error {
+		stageLabel, _ = pprof.Label(ctx, "stage")
+		namespaceLabel, _ = pprof.Label(ctx, "namespace")
+		return errors.New("failed")
+	})
+	err := s.Run(context.Background(), &rolloutPass{Namespace: "matrix"})
+	assert.EqualError(t, err, "failed")
+	assert.Equal(t, pipeline.Hash, stageLabel)
+	assert.Equal(t, "matrix", namespaceLabel)
+}
+
+func TestReconcileIsTraced(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	require.Contains(t, spans, "Reconcile")
+	root := spans["Reconcile"].SpanContext.SpanID()
+	for _, name := range []string{pipeline.Collect, pipeline.Hash, pipeline.Decide, pipeline.Schedule, pipeline.Apply, pipeline.Verify} {
+		require.Contains(t, spans, name)
+		assert.Equal(t, root, spans[name].Parent.SpanID(), name)
+	}
+	assert.Contains(t, spans[pipeline.Collect].Attributes, tracing.SourceKindKey.String("ConfigMap"))
+	assert.Contains(t, spans[pipeline.Hash].Attributes, tracing.ConfigHashKey.String(hash))
+	require.Contains(t, spans, "Patch Deployment")
+	patch := spans["Patch Deployment"]
+	assert.Equal(t, spans[pipeline.Apply].SpanContext.SpanID(), patch.Parent.SpanID())
+	assert.Contains(t, patch.Attributes, tracing.WorkloadNameKey.String("synapse"))
+	assert.Contains(t, patch.Attributes, tracing.UpdatedKey.Bool(true))
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+)
+
+// PendingRestart is a targeted workload whose applied config hash is not the hash the operator computes for
+// it, so the operator restarts it on its first reconcile.
+type PendingRestart struct {
+	Kind        string `json:"kind"`
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+	AppliedHash string `json:"appliedHash"`
+	Hash        string `json:"hash"`
+}
+
+// FormatChange reports whether the applied hash was computed with another hash encoding than ConfigHashPrefix,
+// such as the unprefixed hashes of older operators. Such a restart comes from the upgrade, not from a
+// config change.
+func (p PendingRestart) FormatChange() bool {
+	return p.AppliedHash != "" && !strings.HasPrefix(p.AppliedHash, ConfigHashPrefix)
+}
+
+// PendingRestarts lists the targeted workloads of namespace that this operator would restart, read without
+// changing anything. Workloads held back by their restart strategy are left out; the namespace-wide gates are
+// not consulted.
+func (r *ConfigMapReconciler) PendingRestarts(ctx context.Context, namespace string) ([]PendingRestart, error) {
+	status, err := r.namespaceWorkloadStatus(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var pending []PendingRestart
+	for _, w := range status.Workloads {
+		if w.Current || len(w.Holds) > 0 {
+			continue
+		}
+		hash := status.Hash
+		if w.Hash != "" {
+			hash = w.Hash
+		}
+		pending = append(pending, PendingRestart{Kind: w.Kind, Name: w.Name, Namespace: namespace, AppliedHash: w.AppliedHash, Hash: hash})
+	}
+	return pending, nil
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingRestartsDetectsHashFormatChange(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	deploy.Spec.Template.Annotations = map[string]string{testHashAnnotation: "0123456789abcdef"}
+	r := newTestReconciler(t, deploy, newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+
+	pending, err := r.PendingRestarts(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, PendingRestart{Kind: "Deployment", Name: "synapse", Namespace: "matrix", AppliedHash: "0123456789abcdef", Hash: hash}, pending[0])
+	assert.True(t, pending[0].FormatChange())
+
+	_, err = r.rolloutWorkloads(ctx, "matrix", hash, logr.Discard())
+	require.NoError(t, err)
+	pending, err = r.PendingRestarts(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestPendingRestartFormatChange(t *testing.T) {
+	assert.False(t, PendingRestart{}.FormatChange())
+	assert.False(t, PendingRestart{AppliedHash: ConfigHashPrefix + "abc"}.FormatChange())
+	assert.True(t, PendingRestart{AppliedHash: "v1:sha256:abc"}.FormatChange())
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PriorityLabel on a Namespace orders its reconciles in the queue against other namespaces, and on a
+// workload orders its restart against the other workloads of its namespace: "high", "normal" (the default)
+// or "low". With a shared source changing cluster-wide, a high-priority production homeserver then rolls
+// out before low-priority dev namespaces, and takes the first gradual rollout slots of its namespace.
+const PriorityLabel = "synapse.gen0sec.com/priority"
+
+// Rollout priorities, higher first.
+const (
+	priorityLow    = -1
+	priorityNormal = 0
+	priorityHigh   = 1
+)
+
+// rolloutPriority returns the priority obj is labeled with. A missing or unknown value is normal.
+func rolloutPriority(obj client.Object) int {
+	switch obj.GetLabels()[PriorityLabel] {
+	case "high":
+		return priorityHigh
+	case "low":
+		return priorityLow
+	}
+	return priorityNormal
+}
+
+// namespacePriority returns the priority of namespace, normal if it cannot be read.
+func (r *ConfigMapReconciler) namespacePriority(namespace string) int {
+	ns := &corev1.Namespace{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: namespace}, ns); err != nil {
+		return priorityNormal
+	}
+	return rolloutPriority(ns)
+}
+
+// sortByPriority orders workloads by their priority, highest first, keeping the order of equal ones.
+func sortByPriority(workloads []*workload) {
+	slices.SortStableFunc(workloads, func(a, b *workload) int {
+		return rolloutPriority(b.obj) - rolloutPriority(a.obj)
+	})
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"synapse-operator/state"
+)
+
+func TestHighPriorityWorkloadTakesFirstGradualSlot(t *testing.T) {
+	ctx := context.Background()
+	// "a-worker" sorts first by name, so only its priority puts the homeserver ahead of it.
+	worker := newTestDeployment(nil)
+	worker.Name = "a-worker"
+	homeserver := newTestDeployment(nil)
+	homeserver.Labels[PriorityLabel] = "high"
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{GradualRolloutWindowAnnotation: "10m"}}}
+	r := newTestReconciler(t, ns, worker, homeserver)
+	r.StateStore = state.NewMemoryStore()
+
+	_, err := r.rolloutWorkloads(ctx, "matrix", "one", logr.Discard())
+	require.NoError(t, err)
+	hashOf := func(name string) string {
+		deploy := &appsv1.Deployment{}
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: name}, deploy))
+		return deploy.Spec.Template.Annotations[testHashAnnotation]
+	}
+	assert.Equal(t, "one", hashOf("synapse"))
+	assert.Empty(t, hashOf("a-worker"))
+}
+
+func TestNamespacePriority(t *testing.T) {
+	r := newTestReconciler(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "production", Labels: map[string]string{PriorityLabel: "high"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{PriorityLabel: "low"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "typo", Labels: map[string]string{PriorityLabel: "urgent"}}},
+	)
+	assert.Equal(t, priorityHigh, r.namespacePriority("production"))
+	assert.Equal(t, priorityLow, r.namespacePriority("dev"))
+	assert.Equal(t, priorityNormal, r.namespacePriority("typo"))
+	assert.Equal(t, priorityNormal, r.namespacePriority("missing"))
+}
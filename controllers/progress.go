@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"synapse-operator/notify"
+)
+
+// progressCheckInterval is how often a restarted workload is checked until it is ready again.
+const progressCheckInterval = 15 * time.Second
+
+// rolloutProgress tracks a workload restarted for hash until it becomes available.
+type rolloutProgress struct {
+	hash    string
+	started time.Time
+	stalled bool
+}
+
+// trackProgress follows the rollout of w onto hash after a restart, started is true when w was just
+// patched, and exposes it as in progress until w becomes available. With RolloutProgressTimeout, once the
+// rollout has not completed within it, or a Deployment reports its progress deadline exceeded, w is reported
+// as stalled until it becomes available. It returns when to check again; zero once the rollout is complete or
+// nothing is tracked.
+func (r *ConfigMapReconciler) trackProgress(ctx context.Context, w *workload, hash string, started bool, now time.Time) time.Duration {
+	key := w.obj.GetNamespace() + "/" + w.key()
+	deadlineExceeded := progressDeadlineExceeded(w)
+	if w.available() && !deadlineExceeded {
+		value, ok := r.progress.LoadAndDelete(key)
+		if !ok {
+			return 0
+		}
+		p := value.(*rolloutProgress)
+		rolloutInProgressGauge.DeleteLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key())
+		if p.hash == hash {
+			rolloutDurationGauge.WithLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key()).Set(now.Sub(p.started).Seconds())
+		}
+		if p.stalled {
+			rolloutStalledGauge.DeleteLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key())
+			r.traceEvent(ctx, w.obj, corev1.EventTypeNormal, "RolloutRecovered", fmt.Sprintf("Rollout of config hash %s completed", hash))
+		}
+		return 0
+	}
+
+	value, ok := r.progress.Load(key)
+	p, _ := value.(*rolloutProgress)
+	if !ok || p.hash != hash {
+		if !started {
+			// Not restarted by the operator, or tracked before an operator restart: not ours to judge.
+			return 0
+		}
+		if ok && p.stalled {
+			rolloutStalledGauge.DeleteLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key())
+		}
+		p = &rolloutProgress{hash: hash, started: now}
+		r.progress.Store(key, p)
+		rolloutInProgressGauge.WithLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key()).Set(1)
+	}
+	if r.RolloutProgressTimeout <= 0 || p.stalled {
+		return progressCheckInterval
+	}
+
+	elapsed := now.Sub(p.started)
+	if elapsed < r.RolloutProgressTimeout && !deadlineExceeded {
+		return min(r.RolloutProgressTimeout-elapsed, progressCheckInterval)
+	}
+	p.stalled = true
+	message := fmt.Sprintf("%s has not become ready %s after restarting for config hash %s", w.kind, elapsed.Round(time.Second), hash)
+	if deadlineExceeded {
+		message = fmt.Sprintf("%s exceeded its progress deadline after restarting for config hash %s", w.kind, hash)
+	}
+	log.FromContext(ctx).Info("Rollout stalled", w.logKey(), w.obj.GetName(), "namespace", w.obj.GetNamespace(), "configHash", hash, "elapsed", elapsed)
+	rolloutStalledGauge.WithLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key()).Set(1)
+	r.traceEvent(ctx, w.obj, corev1.EventTypeWarning, "RolloutStalled", message)
+	r.Notifier.Notify(notify.Event{
+		Type:      notify.EventRolloutStalled,
+		Time:      now,
+		Namespace: w.obj.GetNamespace(),
+		Hash:      hash,
+		Workloads: []string{w.key()},
+		Error:     message,
+	})
+	if r.AutoRollback {
+		if err := r.autoRollback(ctx, w.obj.GetNamespace(), hash, now); err != nil {
+			log.FromContext(ctx).Error(err, "failed to request automatic rollback", "namespace", w.obj.GetNamespace(), "configHash", hash)
+		}
+	}
+	return progressCheckInterval
+}
+
+// forgetProgress stops tracking the rollout of w and drops its progress metrics.
+func (r *ConfigMapReconciler) forgetProgress(w *workload) {
+	if _, ok := r.progress.LoadAndDelete(w.obj.GetNamespace() + "/" + w.key()); ok {
+		rolloutStalledGauge.DeleteLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key())
+		rolloutInProgressGauge.DeleteLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key())
+	}
+	rolloutDurationGauge.DeleteLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key())
+	workloadPendingHashGauge.DeleteLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key())
+}
+
+// reportPendingHash exposes, for every targeted workload of pass, whether it still runs another hash than the
+// one pass computed, counting the workloads pass restarted as current.
+func (r *ConfigMapReconciler) reportPendingHash(ctx context.Context, pass *rolloutPass) {
+	if pass.Hash == "" {
+		return
+	}
+	workloads, err := r.listWorkloads(ctx, pass.Namespace)
+	if err != nil {
+		pass.Logger.Error(err, "failed to list workloads for the pending hash metric")
+		return
+	}
+	updated := map[string]bool{}
+	for _, a := range pass.State.applied {
+		if a.outcome.updated {
+			updated[a.w.key()] = true
+		}
+	}
+	for _, w := range workloads {
+		_, strategy, err := r.strategyFor(w)
+		if err != nil {
+			continue
+		}
+		pending := 0.0
+		if !updated[w.key()] && strategy.appliedHash(r, w) != pass.Hash {
+			pending = 1
+		}
+		workloadPendingHashGauge.WithLabelValues(r.ClusterName, pass.Namespace, w.key()).Set(pending)
+	}
+}
+
+// progressDeadlineExceeded reports whether w is a Deployment whose controller gave up on the current rollout.
+func progressDeadlineExceeded(w *workload) bool {
+	deploy, ok := w.obj.(*appsv1.Deployment)
+	if !ok || deploy.Status.ObservedGeneration < deploy.Generation {
+		return false
+	}
+	for _, condition := range deploy.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse && condition.Reason == "ProgressDeadlineExceeded" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestTrackProgressReportsStalledRollout(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	deploy.Name = "synapse-stalled"
+	deploy.Generation = 2
+	deploy.Status.ObservedGeneration = 2
+	deploy.Status.AvailableReplicas = 0
+	recorder := record.NewFakeRecorder(10)
+	r := &ConfigMapReconciler{RolloutProgressTimeout: time.Minute, Recorder: recorder}
+	w := deploymentWorkload(deploy)
+	now := time.Unix(1000, 0)
+
+	assert.Equal(t, progressCheckInterval, r.trackProgress(ctx, w, "one", true, now))
+	assert.Equal(t, 5*time.Second, r.trackProgress(ctx, w, "one", false, now.Add(55*time.Second)))
+	assert.Equal(t, progressCheckInterval, r.trackProgress(ctx, w, "one", false, now.Add(time.Minute)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutStalledGauge.WithLabelValues("", "matrix", "deployment/synapse-stalled")))
+	assert.Contains(t, <-recorder.Events, "RolloutStalled")
+
+	// Reported once, then watched until it recovers.
+	assert.Equal(t, progressCheckInterval, r.trackProgress(ctx, w, "one", false, now.Add(2*time.Minute)))
+	assert.Empty(t, recorder.Events)
+
+	deploy.Status.AvailableReplicas = 1
+	assert.Zero(t, r.trackProgress(ctx, w, "one", false, now.Add(3*time.Minute)))
+	assert.Contains(t, <-recorder.Events, "RolloutRecovered")
+	assert.Equal(t, 0, testutil.CollectAndCount(rolloutStalledGauge))
+}
+
+func TestTrackProgressIgnoresRolloutsItDidNotStart(t *testing.T) {
+	deploy := newTestDeployment(nil)
+	deploy.Status.AvailableReplicas = 0
+	r := &ConfigMapReconciler{RolloutProgressTimeout: time.Minute}
+
+	assert.Zero(t, r.trackProgress(context.Background(), deploymentWorkload(deploy), "one", false, time.Now()))
+	_, ok := r.progress.Load("matrix/deployment/synapse")
+	assert.False(t, ok)
+}
+
+func TestTrackProgressStallsOnProgressDeadline(t *testing.T) {
+	deploy := newTestDeployment(nil)
+	deploy.Name = "synapse-deadline"
+	deploy.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:   appsv1.DeploymentProgressing,
+		Status: corev1.ConditionFalse,
+		Reason: "ProgressDeadlineExceeded",
+	}}
+	r := &ConfigMapReconciler{RolloutProgressTimeout: time.Hour}
+	w := deploymentWorkload(deploy)
+
+	assert.Equal(t, progressCheckInterval, r.trackProgress(context.Background(), w, "one", true, time.Now()))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutStalledGauge.WithLabelValues("", "matrix", "deployment/synapse-deadline")))
+	r.forgetProgress(w)
+	assert.Equal(t, 0, testutil.CollectAndCount(rolloutStalledGauge))
+}
+
+func TestTrackProgressExportsRolloutMetrics(t *testing.T) {
+	deploy := newTestDeployment(nil)
+	deploy.Name = "synapse-metrics"
+	deploy.Status.AvailableReplicas = 0
+	r := &ConfigMapReconciler{}
+	w := deploymentWorkload(deploy)
+	now := time.Unix(1000, 0)
+
+	// Without a progress timeout rollouts are followed but never reported as stalled.
+	assert.Equal(t, progressCheckInterval, r.trackProgress(context.Background(), w, "one", true, now))
+	assert.Equal(t, progressCheckInterval, r.trackProgress(context.Background(), w, "one", false, now.Add(time.Hour)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutInProgressGauge.WithLabelValues("", "matrix", "deployment/synapse-metrics")))
+	assert.Equal(t, 0, testutil.CollectAndCount(rolloutStalledGauge))
+
+	deploy.Status.AvailableReplicas = 1
+	assert.Zero(t, r.trackProgress(context.Background(), w, "one", false, now.Add(90*time.Minute)))
+	assert.False(t, rolloutInProgressGauge.DeleteLabelValues("", "matrix", "deployment/synapse-metrics"), "completed rollouts are no longer in progress")
+	assert.Equal(t, float64(5400), testutil.ToFloat64(rolloutDurationGauge.WithLabelValues("", "matrix", "deployment/synapse-metrics")))
+
+	r.forgetProgress(w)
+	assert.False(t, rolloutDurationGauge.DeleteLabelValues("", "matrix", "deployment/synapse-metrics"))
+}
+
+func TestReconcileReportsWorkloadsPendingHash(t *testing.T) {
+	ctx := context.Background()
+	pdb := newTestPDB(0)
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	r := newTestReconciler(t, cm, newTestDeployment(nil), pdb)
+	r.DeferRestartsOnPDB = true
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(workloadPendingHashGauge.WithLabelValues("", "matrix", "deployment/synapse")))
+
+	pdb.Status.DisruptionsAllowed = 1
+	require.NoError(t, r.Status().Update(ctx, pdb))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), testutil.ToFloat64(workloadPendingHashGauge.WithLabelValues("", "matrix", "deployment/synapse")))
+}
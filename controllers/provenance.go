@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConfigSourcesAnnotation records on the metadata of a restarted workload which sources, at which
+// resourceVersions, produced the config hash it was restarted for, as
+// "configmap/homeserver@12345,secret/tls@678". Remote and external sources are listed without a version.
+const ConfigSourcesAnnotation = "synapse.gen0sec.com/config-sources"
+
+// configSourcesProvenance formats the hashed source keys with the resourceVersions of the listed ConfigMaps
+// and Secrets they name.
+func configSourcesProvenance(hashed []string, configMaps []corev1.ConfigMap, secrets []corev1.Secret) string {
+	versions := make(map[string]string, len(configMaps)+len(secrets))
+	for i := range configMaps {
+		versions["configmap/"+configMaps[i].Name] = configMaps[i].ResourceVersion
+	}
+	for i := range secrets {
+		versions["secret/"+secrets[i].Name] = secrets[i].ResourceVersion
+	}
+	entries := make([]string, 0, len(hashed))
+	for _, key := range hashed {
+		if version := versions[key]; version != "" {
+			key += "@" + version
+		}
+		entries = append(entries, key)
+	}
+	return strings.Join(entries, ",")
+}
+
+// stampConfigSources records provenance in ConfigSourcesAnnotation on the metadata of the restarted workload
+// w, where it neither restarts the pods nor feeds the hash.
+func (r *ConfigMapReconciler) stampConfigSources(ctx context.Context, w *workload, provenance string) error {
+	if provenance == "" || w.obj.GetAnnotations()[ConfigSourcesAnnotation] == provenance {
+		return nil
+	}
+	original := w.obj.DeepCopyObject().(client.Object)
+	setMetadataAnnotation(w, ConfigSourcesAnnotation, provenance)
+	return r.Patch(ctx, w.obj, client.MergeFrom(original))
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRecordConfigSources(t *testing.T) {
+	ctx := context.Background()
+	selected := map[string]string{"app.kubernetes.io/name": "synapse"}
+	tls := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "matrix", Labels: selected},
+		Data:       map[string][]byte{"tls.crt": []byte("cert")},
+	}
+	deploy := newTestDeployment(nil)
+	r := newTestReconciler(t, deploy, newTestConfigMap("homeserver", selected, nil, "a"), tls)
+	r.RecordConfigSources = true
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+	provenance := func() string {
+		var homeserver corev1.ConfigMap
+		require.NoError(t, r.Get(ctx, req.NamespacedName, &homeserver))
+		require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(tls), tls))
+		return "configmap/homeserver@" + homeserver.ResourceVersion + ",secret/tls@" + tls.ResourceVersion
+	}
+	annotation := func() string {
+		var current appsv1.Deployment
+		require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), &current))
+		return current.Annotations[ConfigSourcesAnnotation]
+	}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, provenance(), annotation())
+
+	tls.Data["tls.crt"] = []byte("renewed")
+	require.NoError(t, r.Update(ctx, tls))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, provenance(), annotation(), "the restart for the renewed certificate records its new version")
+}
+
+func TestConfigSourcesProvenance(t *testing.T) {
+	homeserver := newTestConfigMap("homeserver", nil, nil, "a")
+	homeserver.ResourceVersion = "12345"
+	assert.Equal(t, "configmap/homeserver@12345,vault/secret/data/synapse",
+		configSourcesProvenance([]string{"configmap/homeserver", "vault/secret/data/synapse"}, []corev1.ConfigMap{*homeserver}, nil))
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// MinRestartIntervalAnnotation on a workload overrides MinRestartInterval for it with a duration such as
+// "30m"; "0" removes the limit.
+const MinRestartIntervalAnnotation = "synapse.gen0sec.com/min-restart-interval"
+
+// blockedReasonRateLimited marks a restart held because the workload restarted less than its minimum
+// restart interval ago.
+const blockedReasonRateLimited = "RestartRateLimited"
+
+// lastRestartStatePrefix namespaces the time of the last restart of each rate-limited workload in the
+// state store, under "<namespace>/<kind>/<name>".
+const lastRestartStatePrefix = "restarts/"
+
+// minRestartInterval returns the minimum interval between two restarts of w.
+func (r *ConfigMapReconciler) minRestartInterval(w *workload) (time.Duration, error) {
+	value, ok := w.obj.GetAnnotations()[MinRestartIntervalAnnotation]
+	if !ok {
+		return r.MinRestartInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return r.MinRestartInterval, fmt.Errorf("invalid %s %q: want a duration such as 10m", MinRestartIntervalAnnotation, value)
+	}
+	return interval, nil
+}
+
+// restartRateLimitWait returns how long w must wait at now before it may restart again with interval
+// between restarts, or 0. The last restart is read from the state store, so the limit survives operator
+// restarts with a persistent backend.
+func (r *ConfigMapReconciler) restartRateLimitWait(ctx context.Context, w *workload, interval time.Duration, now time.Time) (time.Duration, error) {
+	if interval <= 0 || r.StateStore == nil {
+		return 0, nil
+	}
+	raw, found, err := r.StateStore.Get(ctx, lastRestartStatePrefix+w.obj.GetNamespace()+"/"+w.key())
+	if err != nil || !found {
+		return 0, err
+	}
+	last, err := time.Parse(time.RFC3339Nano, string(raw))
+	if err != nil {
+		log.FromContext(ctx).Error(err, "discarding unreadable last restart time", "workload", w.key())
+		return 0, nil
+	}
+	return max(last.Add(interval).Sub(now), 0), nil
+}
+
+// recordRestart stores that w restarted at now, for the rate limit of its next restart. Workloads without a
+// minimum restart interval are not recorded.
+func (r *ConfigMapReconciler) recordRestart(ctx context.Context, w *workload, now time.Time) error {
+	if interval, _ := r.minRestartInterval(w); interval <= 0 || r.StateStore == nil {
+		return nil
+	}
+	return r.StateStore.Put(ctx, lastRestartStatePrefix+w.obj.GetNamespace()+"/"+w.key(), []byte(now.UTC().Format(time.RFC3339Nano)))
+}
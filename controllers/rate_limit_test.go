@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/state"
+)
+
+func TestMinRestartIntervalRollsOutLatestConfig(t *testing.T) {
+	ctx := context.Background()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	r := newTestReconciler(t, cm, newTestDeployment(nil))
+	r.MinRestartInterval = 10 * time.Minute
+	r.StateStore = state.NewMemoryStore()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+	deployKey := client.ObjectKey{Namespace: "matrix", Name: "synapse"}
+	edit := func(data string) {
+		require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cm), cm))
+		cm.Data["data"] = data
+		require.NoError(t, r.Update(ctx, cm))
+	}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, deployKey, &deploy))
+	first := deploy.Spec.Template.Annotations[testHashAnnotation]
+	require.NotEmpty(t, first)
+
+	edit("b")
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.InDelta(t, 10*time.Minute, result.RequeueAfter, float64(time.Second))
+	require.NoError(t, r.Get(ctx, deployKey, &deploy))
+	assert.Equal(t, first, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutBlockedGauge.WithLabelValues("", "matrix", "deployment/synapse", blockedReasonRateLimited)))
+
+	edit("c")
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, deployKey, &deploy))
+	assert.Equal(t, first, deploy.Spec.Template.Annotations[testHashAnnotation])
+
+	// Once the interval has passed only the latest config is rolled out.
+	require.NoError(t, r.StateStore.Put(ctx, lastRestartStatePrefix+"matrix/deployment/synapse", []byte(time.Now().Add(-11*time.Minute).Format(time.RFC3339Nano))))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, deployKey, &deploy))
+	latest, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, latest, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.Zero(t, testutil.CollectAndCount(rolloutBlockedGauge.MustCurryWith(map[string]string{"reason": blockedReasonRateLimited})))
+}
+
+func TestMinRestartIntervalAnnotation(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(map[string]string{MinRestartIntervalAnnotation: "0"})
+	r := newTestReconciler(t)
+	r.MinRestartInterval = time.Hour
+	r.StateStore = state.NewMemoryStore()
+	w := deploymentWorkload(deploy)
+
+	require.NoError(t, r.recordRestart(ctx, w, time.Now()))
+	_, found, err := r.StateStore.Get(ctx, lastRestartStatePrefix+"matrix/deployment/synapse")
+	require.NoError(t, err)
+	assert.False(t, found, "a workload without a limit is not recorded")
+
+	deploy.Annotations[MinRestartIntervalAnnotation] = "soon"
+	interval, err := r.minRestartInterval(w)
+	assert.Error(t, err)
+	assert.Equal(t, time.Hour, interval)
+	require.NoError(t, r.recordRestart(ctx, w, time.Now()))
+	wait, err := r.restartRateLimitWait(ctx, w, interval, time.Now())
+	require.NoError(t, err)
+	assert.Positive(t, wait)
+
+	wait, err = r.restartRateLimitWait(ctx, w, 0, time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AllowRecreateRestartsAnnotation on a Deployment with the Recreate update strategy confirms, when "true",
+// that hash-triggered restarts may take it down; without it (or --allow-recreate-restarts) the new hash is
+// held and the Deployment reported as blocked.
+const AllowRecreateRestartsAnnotation = "synapse.gen0sec.com/allow-recreate-restarts"
+
+// blockedReasonRecreate marks a restart held because it would recreate all pods at once.
+const blockedReasonRecreate = "RecreateNotConfirmed"
+
+// recreateBlocked reports whether restarting w needs a confirmation it does not have.
+func (r *ConfigMapReconciler) recreateBlocked(w *workload) bool {
+	deploy, ok := w.obj.(*appsv1.Deployment)
+	if !ok || deploy.Spec.Strategy.Type != appsv1.RecreateDeploymentStrategyType {
+		return false
+	}
+	if value, ok := deploy.Annotations[AllowRecreateRestartsAnnotation]; ok {
+		return value != "true"
+	}
+	return !r.AllowRecreateRestarts
+}
+
+// reportBlocked exposes hash as held for w for reason, recording a warning event with message when it
+// changes.
+func (r *ConfigMapReconciler) reportBlocked(w *workload, hash, reason, message string) {
+	key := w.obj.GetNamespace() + "/" + w.key() + "/" + reason
+	if previous, ok := r.blockedHashes.Load(key); ok && previous == hash {
+		return
+	}
+	r.blockedHashes.Store(key, hash)
+	rolloutBlockedGauge.WithLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key(), reason).Set(1)
+	r.event(w.obj, corev1.EventTypeWarning, "RolloutBlocked", message)
+}
+
+// clearBlocked drops the blocked state of w once it is no longer held.
+func (r *ConfigMapReconciler) clearBlocked(w *workload, reason string) {
+	if _, ok := r.blockedHashes.LoadAndDelete(w.obj.GetNamespace() + "/" + w.key() + "/" + reason); !ok {
+		return
+	}
+	rolloutBlockedGauge.DeleteLabelValues(r.ClusterName, w.obj.GetNamespace(), w.key(), reason)
+}
+
+// clearNamespaceBlocked drops the blocked state for reason of every workload of namespace.
+func (r *ConfigMapReconciler) clearNamespaceBlocked(namespace, reason string) {
+	prefix, suffix := namespace+"/", "/"+reason
+	r.blockedHashes.Range(func(key, _ any) bool {
+		k := key.(string)
+		if strings.HasPrefix(k, prefix) && strings.HasSuffix(k, suffix) {
+			r.blockedHashes.Delete(k)
+			rolloutBlockedGauge.DeleteLabelValues(r.ClusterName, namespace, strings.TrimSuffix(strings.TrimPrefix(k, prefix), suffix), reason)
+		}
+		return true
+	})
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRolloutWorkloadsHoldsUnconfirmedRecreate(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(nil)
+	deploy.Spec.Strategy.Type = appsv1.RecreateDeploymentStrategyType
+	r := newTestReconciler(t, deploy)
+
+	_, err := r.rolloutWorkloads(ctx, "matrix", "one", logr.Discard())
+	require.NoError(t, err)
+	var current appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), &current))
+	assert.Empty(t, current.Spec.Template.Annotations[testHashAnnotation])
+	held, ok := r.blockedHashes.Load("matrix/deployment/synapse/" + blockedReasonRecreate)
+	require.True(t, ok)
+	assert.Equal(t, "one", held)
+
+	current.Annotations = map[string]string{AllowRecreateRestartsAnnotation: "true"}
+	require.NoError(t, r.Update(ctx, &current))
+	_, err = r.rolloutWorkloads(ctx, "matrix", "one", logr.Discard())
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), &current))
+	assert.Equal(t, "one", current.Spec.Template.Annotations[testHashAnnotation])
+	_, ok = r.blockedHashes.Load("matrix/deployment/synapse/" + blockedReasonRecreate)
+	assert.False(t, ok)
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// RemoteSourcesAnnotation, set on a matching ConfigMap or Secret, lists config sources in other namespaces
+// folded into the combined hash of its own namespace, as comma-separated "configmap/<namespace>/<name>" or
+// "secret/<namespace>/<name>" references.
+const RemoteSourcesAnnotation = "synapse.gen0sec.com/remote-sources"
+
+const (
+	remoteKindConfigMap = "configmap"
+	remoteKindSecret    = "secret"
+)
+
+// remoteSourceRef identifies one config source in another namespace.
+type remoteSourceRef struct {
+	kind string
+	key  types.NamespacedName
+}
+
+func (ref remoteSourceRef) String() string {
+	return ref.kind + "/" + ref.key.Namespace + "/" + ref.key.Name
+}
+
+// parseRemoteSources parses the value of RemoteSourcesAnnotation.
+func parseRemoteSources(value string) ([]remoteSourceRef, error) {
+	var refs []remoteSourceRef
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, "/")
+		if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("remote source %q is not <kind>/<namespace>/<name>", item)
+		}
+		kind := strings.ToLower(parts[0])
+		if kind != remoteKindConfigMap && kind != remoteKindSecret {
+			return nil, fmt.Errorf("remote source %q must be a configmap or secret", item)
+		}
+		refs = append(refs, remoteSourceRef{kind: kind, key: types.NamespacedName{Namespace: parts[1], Name: parts[2]}})
+	}
+	return refs, nil
+}
+
+// remoteSourceIndex maps each referenced remote source to the local sources referencing it, so a change to
+// the remote source can be routed to the namespaces that depend on it.
+type remoteSourceIndex struct {
+	mu          sync.Mutex
+	referrers   map[remoteSourceRef]map[types.NamespacedName]struct{}
+	byNamespace map[string][]remoteSourceRef
+}
+
+func newRemoteSourceIndex() *remoteSourceIndex {
+	return &remoteSourceIndex{
+		referrers:   map[remoteSourceRef]map[types.NamespacedName]struct{}{},
+		byNamespace: map[string][]remoteSourceRef{},
+	}
+}
+
+// set replaces the references declared in namespace.
+func (i *remoteSourceIndex) set(namespace string, refs map[remoteSourceRef][]types.NamespacedName) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, ref := range i.byNamespace[namespace] {
+		for referrer := range i.referrers[ref] {
+			if referrer.Namespace == namespace {
+				delete(i.referrers[ref], referrer)
+			}
+		}
+		if len(i.referrers[ref]) == 0 {
+			delete(i.referrers, ref)
+		}
+	}
+	delete(i.byNamespace, namespace)
+	for ref, referrers := range refs {
+		if i.referrers[ref] == nil {
+			i.referrers[ref] = map[types.NamespacedName]struct{}{}
+		}
+		for _, referrer := range referrers {
+			i.referrers[ref][referrer] = struct{}{}
+		}
+		i.byNamespace[namespace] = append(i.byNamespace[namespace], ref)
+	}
+}
+
+func (i *remoteSourceIndex) lookup(ref remoteSourceRef) []types.NamespacedName {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	referrers := make([]types.NamespacedName, 0, len(i.referrers[ref]))
+	for referrer := range i.referrers[ref] {
+		referrers = append(referrers, referrer)
+	}
+	return referrers
+}
+
+// enqueueRemoteReferrers returns a handler that reconciles the local sources referencing a changed remote
+// source of kind.
+func (r *ConfigMapReconciler) enqueueRemoteReferrers(kind string) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+		if r.remotes == nil {
+			return nil
+		}
+		referrers := r.remotes.lookup(remoteSourceRef{kind: kind, key: client.ObjectKeyFromObject(obj)})
+		requests := make([]reconcile.Request, 0, len(referrers))
+		for _, referrer := range referrers {
+			requests = append(requests, reconcile.Request{NamespacedName: referrer})
+		}
+		return requests
+	})
+}
+
+// remoteSourceDigests fetches the remote sources referenced from namespace and digests them under their
+// class policies. A source that cannot be read because of RBAC fails the whole hash rather than silently
+// dropping out of it, which would trigger a rollout; a source that does not exist is left out, like a
+// deleted local source.
+func (r *ConfigMapReconciler) remoteSourceDigests(ctx context.Context, namespace string, configMaps []corev1.ConfigMap, secrets []corev1.Secret, now time.Time) ([]sourceDigest, time.Duration, error) {
+	refs := map[remoteSourceRef][]client.Object{}
+	collect := func(obj client.Object) {
+		value, ok := obj.GetAnnotations()[RemoteSourcesAnnotation]
+		if !ok {
+			return
+		}
+		parsed, err := parseRemoteSources(value)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "ignoring invalid remote sources", "source", client.ObjectKeyFromObject(obj))
+			r.event(obj, corev1.EventTypeWarning, "InvalidRemoteSource", err.Error())
+			return
+		}
+		for _, ref := range parsed {
+			refs[ref] = append(refs[ref], obj)
+		}
+	}
+	for i := range configMaps {
+		collect(&configMaps[i])
+	}
+	for i := range secrets {
+		collect(&secrets[i])
+	}
+	if r.remotes == nil {
+		r.remotes = newRemoteSourceIndex()
+	}
+	index := make(map[remoteSourceRef][]types.NamespacedName, len(refs))
+	for ref, referrers := range refs {
+		for _, referrer := range referrers {
+			index[ref] = append(index[ref], client.ObjectKeyFromObject(referrer))
+		}
+	}
+	r.remotes.set(namespace, index)
+	if len(refs) == 0 {
+		return nil, 0, nil
+	}
+
+	type remoteSources struct {
+		configMaps []corev1.ConfigMap
+		secrets    []corev1.Secret
+	}
+	byNamespace := map[string]*remoteSources{}
+	for ref, referrers := range refs {
+		if ref.kind == remoteKindSecret && r.IgnoreSecrets {
+			continue
+		}
+		group := byNamespace[ref.key.Namespace]
+		if group == nil {
+			group = &remoteSources{}
+			byNamespace[ref.key.Namespace] = group
+		}
+		var obj client.Object
+		if ref.kind == remoteKindConfigMap {
+			obj = &corev1.ConfigMap{}
+		} else {
+			obj = &corev1.Secret{}
+		}
+		// Remote namespaces may be outside the cache, so read them directly.
+		if err := r.reader().Get(ctx, ref.key, obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				log.FromContext(ctx).Info("Remote config source not found", "remoteSource", ref.String())
+				continue
+			}
+			if apierrors.IsForbidden(err) {
+				r.event(referrers[0], corev1.EventTypeWarning, "RemoteSourceForbidden",
+					fmt.Sprintf("Not allowed to read remote source %s; grant the operator get on it", ref))
+			}
+			return nil, 0, fmt.Errorf("reading remote source %s: %w", ref, err)
+		}
+		switch source := obj.(type) {
+		case *corev1.ConfigMap:
+			group.configMaps = append(group.configMaps, *source)
+		case *corev1.Secret:
+			group.secrets = append(group.secrets, *source)
+		}
+	}
+
+	remoteNamespaces := make([]string, 0, len(byNamespace))
+	for remoteNamespace := range byNamespace {
+		remoteNamespaces = append(remoteNamespaces, remoteNamespace)
+	}
+	sort.Strings(remoteNamespaces)
+
+	var digests []sourceDigest
+	var settleAfter time.Duration
+	for _, remoteNamespace := range remoteNamespaces {
+		group := byNamespace[remoteNamespace]
+		prefix := "remote/" + remoteNamespace + "/"
+		configMapItems, secretItems, debounced := r.classifySources(ctx, prefix, group.configMaps, group.secrets)
+		remote := configSourceDigests(r.digestIndex(), configMapItems, secretItems, r.configMapKeyFilter(), r.secretKeyFilter())
+		for i := range remote {
+			remote[i].key = prefix + remote[i].key
+		}
+		remote, wait := r.debounceSources(ctx, namespace, remote, debounced, now)
+		digests = append(digests, remote...)
+		if wait > 0 && (settleAfter == 0 || wait < settleAfter) {
+			settleAfter = wait
+		}
+	}
+	return digests, settleAfter, nil
+}
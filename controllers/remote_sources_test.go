@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestParseRemoteSources(t *testing.T) {
+	refs, err := parseRemoteSources("configmap/platform-certs/synapse-ca, Secret/platform-certs/signing-key,")
+	require.NoError(t, err)
+	require.Len(t, refs, 2)
+	assert.Equal(t, "configmap/platform-certs/synapse-ca", refs[0].String())
+	assert.Equal(t, "secret/platform-certs/signing-key", refs[1].String())
+
+	for _, invalid := range []string{"platform-certs/synapse-ca", "pod/platform-certs/synapse", "configmap//synapse-ca"} {
+		_, err := parseRemoteSources(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestComputeCombinedHashFoldsRemoteSources(t *testing.T) {
+	ctx := context.Background()
+	local := newTestConfigMap("homeserver", nil, map[string]string{RemoteSourcesAnnotation: "configmap/platform-certs/synapse-ca"}, "a")
+	remote := newTestConfigMap("synapse-ca", nil, nil, "pem-1")
+	remote.Namespace = "platform-certs"
+	r := newTestReconciler(t, local, remote)
+
+	before, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, []types.NamespacedName{{Namespace: "matrix", Name: "homeserver"}},
+		r.remotes.lookup(remoteSourceRef{kind: remoteKindConfigMap, key: client.ObjectKeyFromObject(remote)}))
+
+	remote.Data["data"] = "pem-2"
+	require.NoError(t, r.Update(ctx, remote))
+	after, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+
+	require.NoError(t, r.Delete(ctx, remote))
+	missing, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	alone, _, err := newTestReconciler(t, newTestConfigMap("homeserver", nil, nil, "a")).computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, alone, missing)
+}
+
+func TestRemoteSourceForbiddenFailsHash(t *testing.T) {
+	ctx := context.Background()
+	local := newTestConfigMap("homeserver", nil, map[string]string{RemoteSourcesAnnotation: "secret/platform-certs/signing-key"}, "a")
+	r := newTestReconciler(t, local)
+	r.APIReader = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if key.Namespace == "platform-certs" {
+				return apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, key.Name, nil)
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+	})
+
+	_, _, err := r.computeCombinedHash(ctx, "matrix")
+	assert.True(t, apierrors.IsForbidden(err))
+}
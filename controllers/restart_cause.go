@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// RestartCauseAnnotation records on the pod template of a restarted workload the config source whose change
+	// restarted it, as "<kind>/<name>" such as "secret/tls-cert", so it can be read off the pods themselves.
+	RestartCauseAnnotation = "synapse.gen0sec.com/restart-cause"
+	// RestartCauseTimeAnnotation records alongside RestartCauseAnnotation when the change was rolled out.
+	RestartCauseTimeAnnotation = "synapse.gen0sec.com/restart-cause-time"
+)
+
+// restartCause is the config source change a pass restarts workloads for.
+type restartCause struct {
+	source string
+	at     time.Time
+}
+
+type restartCauseKey struct{}
+
+// withRestartCause returns a context whose pod template writes are stamped with the change of source at at.
+func withRestartCause(ctx context.Context, source string, at time.Time) context.Context {
+	return context.WithValue(ctx, restartCauseKey{}, restartCause{source: source, at: at})
+}
+
+// restartCauseAnnotations returns the pod template annotations recording the restart cause of ctx, or nil.
+func restartCauseAnnotations(ctx context.Context) map[string]string {
+	cause, ok := ctx.Value(restartCauseKey{}).(restartCause)
+	if !ok {
+		return nil
+	}
+	return map[string]string{
+		RestartCauseAnnotation:     cause.source,
+		RestartCauseTimeAnnotation: cause.at.UTC().Format(time.RFC3339),
+	}
+}
+
+// stampRestartCause sets the restart cause of ctx on the pod template of w, which the strategy restarting w
+// is about to patch.
+func stampRestartCause(ctx context.Context, w *workload) {
+	for key, value := range restartCauseAnnotations(ctx) {
+		setTemplateAnnotation(w, key, value)
+	}
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestAnnotateRestartCause(t *testing.T) {
+	ctx := context.Background()
+	labels := map[string]string{"app.kubernetes.io/name": "synapse"}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tls-cert", Namespace: "matrix", Labels: labels},
+		Data:       map[string][]byte{"tls.crt": []byte("a")},
+	}
+	r := newTestReconciler(t, newTestConfigMap("homeserver", labels, nil, "a"), secret, newTestDeployment(nil))
+	r.AnnotateRestartCause = true
+
+	before := time.Now().UTC().Truncate(time.Second)
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "tls-cert"}})
+	require.NoError(t, err)
+
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	annotations := deploy.Spec.Template.Annotations
+	assert.NotEmpty(t, annotations[testHashAnnotation])
+	assert.Equal(t, "secret/tls-cert", annotations[RestartCauseAnnotation])
+	at, err := time.Parse(time.RFC3339, annotations[RestartCauseTimeAnnotation])
+	require.NoError(t, err)
+	assert.False(t, at.Before(before))
+}
+
+func TestRestartCauseOffByDefault(t *testing.T) {
+	ctx := context.Background()
+	labels := map[string]string{"app.kubernetes.io/name": "synapse"}
+	r := newTestReconciler(t, newTestConfigMap("homeserver", labels, nil, "a"), newTestDeployment(nil))
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.NotEmpty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.NotContains(t, deploy.Spec.Template.Annotations, RestartCauseAnnotation)
+}
@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"synapse-operator/audit"
+	"synapse-operator/tracing"
+)
+
+const (
+	// RestartNowAnnotation on a targeted workload, or on a Namespace for all of its targeted workloads,
+	// requests a restart without a config change. Its value, typically a timestamp, identifies the request:
+	// each new value restarts the workloads once.
+	RestartNowAnnotation = "synapse.gen0sec.com/restart-now"
+	// RestartNowHandledAnnotation records on workload metadata the last RestartNowAnnotation value of the
+	// workload that was acted on.
+	RestartNowHandledAnnotation = "synapse.gen0sec.com/restart-now-handled"
+	// namespaceRestartNowHandledAnnotation records on workload metadata the last RestartNowAnnotation value
+	// of its Namespace that was acted on, so the operator never needs to write Namespaces.
+	namespaceRestartNowHandledAnnotation = "synapse.gen0sec.com/namespace-restart-now-handled"
+)
+
+// restartNowStrategy labels manual restarts in synapse_operator_workload_restarts_total and audit records.
+const restartNowStrategy = "restart-now"
+
+// restartNowRequested reports whether obj carries a RestartNowAnnotation value not acted on yet, as recorded
+// under handledKey.
+func restartNowRequested(obj client.Object, handledKey string) bool {
+	annotations := obj.GetAnnotations()
+	requested := annotations[RestartNowAnnotation]
+	return requested != "" && requested != annotations[handledKey]
+}
+
+// restartNowPredates reports whether the request value is a timestamp from before w was created: a workload
+// created with the annotation, or after it was set on its Namespace, already runs fresh pods.
+func restartNowPredates(w *workload, value string) bool {
+	requestedAt, err := time.Parse(time.RFC3339, value)
+	return err == nil && requestedAt.Before(w.obj.GetCreationTimestamp().Time)
+}
+
+// restartNowRecheckInterval is how often a restart request held by paused rollouts or the rollout lock is
+// checked again.
+const restartNowRecheckInterval = time.Minute
+
+// workloadRestartNowReconciler restarts the workloads of one kind whose RestartNowAnnotation changed.
+type workloadRestartNowReconciler struct {
+	parent *ConfigMapReconciler
+	workloadKind
+}
+
+func (m *workloadRestartNowReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r := m.parent
+	if r.namespaceExcluded(req.Namespace) {
+		return ctrl.Result{}, nil
+	}
+	defer r.lockNamespace(req.Namespace)()
+	obj := m.newObj()
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	w := m.wrap(obj)
+	if !r.targets(w) {
+		return ctrl.Result{}, nil
+	}
+	requested := restartNowRequested(w.obj, RestartNowHandledAnnotation)
+	if wait, err := r.restartNowHeld(ctx, req.Namespace, requested); wait > 0 || err != nil {
+		return ctrl.Result{RequeueAfter: wait}, err
+	}
+	if !requested {
+		// Only requeues get here: an eviction started by an earlier request goes on.
+		return r.continueRestarts(ctx, req.Namespace, []*workload{w})
+	}
+	value := w.obj.GetAnnotations()[RestartNowAnnotation]
+	return r.restartNow(ctx, req.Namespace, "workload", fmt.Sprintf("%s=%s on %s", RestartNowAnnotation, value, w.key()), []*workload{w}, RestartNowHandledAnnotation, value)
+}
+
+// namespaceRestartNowReconciler restarts the targeted workloads of the Namespaces whose RestartNowAnnotation
+// changed.
+type namespaceRestartNowReconciler struct {
+	parent *ConfigMapReconciler
+}
+
+func (m *namespaceRestartNowReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r := m.parent
+	if r.namespaceExcluded(req.Name) {
+		return ctrl.Result{}, nil
+	}
+	defer r.lockNamespace(req.Name)()
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, req.NamespacedName, ns); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	value := ns.Annotations[RestartNowAnnotation]
+	if value == "" {
+		return ctrl.Result{}, nil
+	}
+	workloads, err := r.listWorkloads(ctx, req.Name)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	targeted := workloads[:0]
+	for _, w := range workloads {
+		if w.kind != "CronJob" && r.targets(w) {
+			targeted = append(targeted, w)
+		}
+	}
+	var pending []*workload
+	for _, w := range targeted {
+		if w.obj.GetAnnotations()[namespaceRestartNowHandledAnnotation] != value {
+			pending = append(pending, w)
+		}
+	}
+	if wait, err := r.restartNowHeld(ctx, req.Name, len(pending) > 0); wait > 0 || err != nil {
+		return ctrl.Result{RequeueAfter: wait}, err
+	}
+	if len(pending) == 0 {
+		return r.continueRestarts(ctx, req.Name, targeted)
+	}
+	return r.restartNow(ctx, req.Name, "namespace", fmt.Sprintf("%s=%s on namespace %s", RestartNowAnnotation, value, req.Name), pending, namespaceRestartNowHandledAnnotation, value)
+}
+
+// restartNowHeld returns how long to wait before acting on restart requests in namespace: a manual restart
+// honours paused rollouts and the rollout lock like a config rollout, and a new one waits for a slot of
+// MaxRollingNamespaces. It is the config hash that approval holds, so a manual restart needs none.
+func (r *ConfigMapReconciler) restartNowHeld(ctx context.Context, namespace string, restart bool) (time.Duration, error) {
+	logger := log.FromContext(ctx).WithValues("namespace", namespace)
+	_, paused, err := r.rolloutsPaused(ctx, namespace)
+	if err != nil {
+		return 0, err
+	}
+	if paused {
+		logger.Info("Rollouts are paused in namespace, holding restart request")
+		return restartNowRecheckInterval, nil
+	}
+	holder, wait, err := r.rolloutLocked(ctx, namespace, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if holder != "" {
+		logger.Info("Holding restart request while the rollout lock is held", "holder", holder)
+		return max(wait, time.Second), nil
+	}
+	if restart && !r.admitRollout(namespace, 1, time.Now()) {
+		logger.Info("Holding restart request until a rollout slot is free", "position", r.queuedRolloutPosition(namespace))
+		return rolloutBudgetRecheckInterval, nil
+	}
+	return 0, nil
+}
+
+// restartNow restarts workloads on the manual request described by trigger. Each workload records value
+// under handledKey in the same patch that requests the restart from its restart strategy, which then
+// carries it out at the config hash the workload already runs: evicting its pods with the evict strategy,
+// rolling its pod template like `kubectl rollout restart` otherwise. It is traced, audited, counted and
+// notified like a config rollout, under its own trigger ID. Workloads created after a timestamp value are
+// only marked as handled.
+func (r *ConfigMapReconciler) restartNow(ctx context.Context, namespace, scope, trigger string, workloads []*workload, handledKey, value string) (ctrl.Result, error) {
+	ctx, triggerID := withTriggerID(ctx)
+	ctx, span := tracing.Start(ctx, "RestartNow", tracing.NamespaceKey.String(namespace), tracing.TriggerIDKey.String(triggerID))
+	logger := log.FromContext(ctx).WithValues("namespace", namespace, "triggerID", triggerID, "request", value)
+	rec := audit.NewRecord(namespace, trigger, time.Now())
+	rec.TriggerID = triggerID
+
+	var result ctrl.Result
+	var err error
+	for _, w := range workloads {
+		var name string
+		var strategy restartStrategy
+		name, strategy, err = r.strategyFor(w)
+		if err != nil {
+			rec.AddAction(audit.Action{Workload: w.key(), Strategy: name, Error: err.Error()})
+			logger.Error(err, "failed to resolve the restart strategy of "+w.logKey(), w.logKey(), w.obj.GetName())
+			break
+		}
+		original := w.obj.DeepCopyObject().(client.Object)
+		setMetadataAnnotation(w, handledKey, value)
+		fresh := restartNowPredates(w, value)
+		if !fresh {
+			strategy.requestRestart(r, w, time.Now())
+		}
+		if err = r.Patch(ctx, w.obj, client.MergeFrom(original)); err != nil {
+			rec.AddAction(audit.Action{Workload: w.key(), Strategy: name, Error: err.Error()})
+			logger.Error(err, "failed to restart "+w.logKey()+" on request", w.logKey(), w.obj.GetName())
+			break
+		}
+		if fresh {
+			logger.V(1).Info("Skipping restart of "+w.logKey()+" created after the request", w.logKey(), w.obj.GetName())
+			continue
+		}
+		var outcome restartOutcome
+		if outcome, err = strategy.apply(ctx, r, w, strategy.appliedHash(r, w)); err != nil {
+			rec.AddAction(audit.Action{Workload: w.key(), Strategy: name, Error: err.Error()})
+			logger.Error(err, "failed to restart "+w.logKey()+" on request", w.logKey(), w.obj.GetName())
+			break
+		}
+		result.RequeueAfter = shorterRequeue(result.RequeueAfter, outcome.requeueAfter)
+		result.RequeueAfter = shorterRequeue(result.RequeueAfter, r.trackProgress(ctx, w, strategy.appliedHash(r, w), true, time.Now()))
+		rec.AddAction(audit.Action{Workload: w.key(), Strategy: name, Updated: true})
+		manualRestartsTotal.WithLabelValues(r.ClusterName, namespace, scope).Inc()
+		logger.Info("Restarted "+w.logKey()+" on request", w.logKey(), w.obj.GetName(), "strategy", name)
+		r.traceEvent(ctx, w.obj, corev1.EventTypeNormal, "ManualRestart", fmt.Sprintf("Restarted on request of %s", trigger))
+		if err := r.recordRestart(ctx, w, time.Now()); err != nil {
+			logger.Error(err, "failed to record restart time")
+		}
+		if err := r.stampTriggerID(ctx, w, restartNowStrategy); err != nil {
+			logger.Error(err, "failed to record trigger ID")
+		}
+	}
+	// The slot is kept while a restarted workload is tracked as rolling; continueRestarts gives it back.
+	r.releaseRollout(namespace, false)
+
+	rec.Finish("", err)
+	r.notify(rec)
+	if r.Audit != nil && rec.Notable() {
+		if writeErr := r.Audit.Write(ctx, rec); writeErr != nil {
+			logger.Error(writeErr, "failed to write audit record", "transaction", rec.ID)
+		}
+	}
+	tracing.End(span, err)
+	return result, err
+}
+
+// continueRestarts applies to workloads of namespace the config hash each already runs, which goes on with
+// the evictions of an earlier restart request, and follows their progress until they are available again,
+// when the rollout slot of namespace is given back.
+func (r *ConfigMapReconciler) continueRestarts(ctx context.Context, namespace string, workloads []*workload) (ctrl.Result, error) {
+	var result ctrl.Result
+	for _, w := range workloads {
+		_, strategy, err := r.strategyFor(w)
+		if err != nil {
+			continue
+		}
+		hash := strategy.appliedHash(r, w)
+		outcome, err := strategy.apply(ctx, r, w, hash)
+		if err != nil {
+			return result, err
+		}
+		result.RequeueAfter = shorterRequeue(result.RequeueAfter, outcome.requeueAfter)
+		result.RequeueAfter = shorterRequeue(result.RequeueAfter, r.trackProgress(ctx, w, hash, false, time.Now()))
+	}
+	r.releaseRollout(namespace, false)
+	return result, nil
+}
+
+// setupRestartNow watches the targeted workloads and the Namespaces for new RestartNowAnnotation values.
+func (r *ConfigMapReconciler) setupRestartNow(mgr ctrl.Manager) error {
+	for _, kind := range r.workloadKinds() {
+		if kind.name == "cronjob" {
+			// A CronJob has no running pods to restart.
+			continue
+		}
+		requested := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return restartNowRequested(obj, RestartNowHandledAnnotation)
+		})
+		if err := ctrl.NewControllerManagedBy(mgr).
+			Named("restart-now-"+kind.name).
+			For(kind.newObj(), builder.WithPredicates(requested)).
+			Complete(&workloadRestartNowReconciler{parent: r, workloadKind: kind}); err != nil {
+			return err
+		}
+	}
+	requested := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetAnnotations()[RestartNowAnnotation] != ""
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("restart-now-namespace").
+		For(&corev1.Namespace{}, builder.WithPredicates(requested, predicate.AnnotationChangedPredicate{})).
+		Complete(&namespaceRestartNowReconciler{parent: r})
+}
+
+// shorterRequeue returns the sooner of two requeue delays, where 0 asks for none.
+func shorterRequeue(a, b time.Duration) time.Duration {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
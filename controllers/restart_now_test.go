@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRestartNowOnWorkload(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(map[string]string{RestartNowAnnotation: "2026-10-15T10:00:00Z"}))
+	m := &workloadRestartNowReconciler{parent: r, workloadKind: r.workloadKinds()[0]}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "synapse"}}
+	before := testutil.ToFloat64(manualRestartsTotal.WithLabelValues("", "matrix", "workload"))
+
+	_, err := m.Reconcile(ctx, req)
+	require.NoError(t, err)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, req.NamespacedName, deploy))
+	restartedAt := deploy.Spec.Template.Annotations[DefaultRestartedAtAnnotation]
+	assert.NotEmpty(t, restartedAt)
+	assert.Equal(t, "2026-10-15T10:00:00Z", deploy.Annotations[RestartNowHandledAnnotation])
+	assert.Equal(t, before+1, testutil.ToFloat64(manualRestartsTotal.WithLabelValues("", "matrix", "workload")))
+
+	deploy.Spec.Template.Annotations[DefaultRestartedAtAnnotation] = "unchanged"
+	require.NoError(t, r.Update(ctx, deploy))
+	_, err = m.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, req.NamespacedName, deploy))
+	assert.Equal(t, "unchanged", deploy.Spec.Template.Annotations[DefaultRestartedAtAnnotation], "a request is acted on once")
+}
+
+func TestRestartNowOnNamespace(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{RestartNowAnnotation: "2026-10-15T10:00:00Z"}}}
+	old := newTestDeployment(nil)
+	old.CreationTimestamp = metav1.NewTime(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+	created := newTestDeployment(nil)
+	created.Name = "synapse-worker"
+	created.CreationTimestamp = metav1.NewTime(time.Date(2026, 10, 15, 11, 0, 0, 0, time.UTC))
+	unselected := newTestDeployment(nil)
+	unselected.Name = "postgres"
+	unselected.Labels = map[string]string{"app.kubernetes.io/name": "postgres"}
+	r := newTestReconciler(t, ns, old, created, unselected)
+	r.LabelSelector = labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "synapse"})
+	m := &namespaceRestartNowReconciler{parent: r}
+
+	_, err := m.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "matrix"}})
+	require.NoError(t, err)
+	for name, restarted := range map[string]bool{"synapse": true, "synapse-worker": false} {
+		deploy := &appsv1.Deployment{}
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: name}, deploy))
+		assert.Equal(t, restarted, deploy.Spec.Template.Annotations[DefaultRestartedAtAnnotation] != "", name)
+		assert.Equal(t, "2026-10-15T10:00:00Z", deploy.Annotations[namespaceRestartNowHandledAnnotation], name)
+	}
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "postgres"}, deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations, "untargeted workloads are left alone")
+}
+
+func TestRestartNowHeldWhileRolloutsPaused(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{RolloutsPausedAnnotation: "true"}}}
+	r := newTestReconciler(t, ns, newTestDeployment(map[string]string{RestartNowAnnotation: "2026-10-15T10:00:00Z"}))
+	m := &workloadRestartNowReconciler{parent: r, workloadKind: r.workloadKinds()[0]}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "synapse"}}
+
+	result, err := m.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, restartNowRecheckInterval, result.RequeueAfter)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, req.NamespacedName, deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations, "nothing restarts while rollouts are paused")
+	assert.NotContains(t, deploy.Annotations, RestartNowHandledAnnotation, "the request stays pending")
+
+	delete(ns.Annotations, RolloutsPausedAnnotation)
+	require.NoError(t, r.Update(ctx, ns))
+	_, err = m.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, req.NamespacedName, deploy))
+	assert.NotEmpty(t, deploy.Spec.Template.Annotations[DefaultRestartedAtAnnotation], "the request is acted on once resumed")
+}
+
+func TestRestartNowWithEvictStrategy(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t,
+		newTestDeployment(map[string]string{RestartNowAnnotation: "2026-10-15T10:00:00Z", RestartStrategyAnnotation: StrategyEvict}),
+		newTestPod("synapse-a", time.Now().Add(-2*time.Hour), true),
+		newTestPod("synapse-b", time.Now().Add(-time.Hour), true))
+	m := &workloadRestartNowReconciler{parent: r, workloadKind: r.workloadKinds()[0]}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "synapse"}}
+	pods := func() []string {
+		var list corev1.PodList
+		require.NoError(t, r.List(ctx, &list, client.InNamespace("matrix")))
+		var names []string
+		for _, pod := range list.Items {
+			names = append(names, pod.Name)
+		}
+		return names
+	}
+
+	result, err := m.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, evictRetryInterval, result.RequeueAfter)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, req.NamespacedName, deploy))
+	assert.NotEmpty(t, deploy.Annotations[restartRequestedAtAnnotation])
+	assert.Empty(t, deploy.Spec.Template.Annotations, "the evict strategy restarts by evicting pods, not through the template")
+	assert.Equal(t, []string{"synapse-b"}, pods(), "the oldest pod is evicted first")
+
+	// The requeue goes on evicting the pods of the handled request.
+	_, err = m.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Empty(t, pods())
+}
+
+func TestRestartNowKeepsRolloutSlotUntilAvailable(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestDeployment(map[string]string{RestartNowAnnotation: "2026-10-15T10:00:00Z"})
+	deploy.Status.AvailableReplicas = 0
+	r := newTestReconciler(t, deploy)
+	r.MaxRollingNamespaces = 1
+	m := &workloadRestartNowReconciler{parent: r, workloadKind: r.workloadKinds()[0]}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "synapse"}}
+
+	result, err := m.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Positive(t, result.RequeueAfter, "the restarted workload is followed until available")
+	assert.False(t, r.admitRollout("element", 1, time.Now()), "matrix keeps its slot while the restart rolls out")
+
+	require.NoError(t, r.Get(ctx, req.NamespacedName, deploy))
+	deploy.Status.AvailableReplicas = 1
+	require.NoError(t, r.Status().Update(ctx, deploy))
+	result, err = m.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	assert.True(t, r.admitRollout("element", 1, time.Now()), "the slot is given back once the workload is available")
+}
@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Restart strategy names accepted by --restart-strategy and the restart-strategy workload annotation.
+const (
+	// StrategyAnnotation writes the hash into the pod template annotations, letting the workload controller roll pods.
+	StrategyAnnotation = "annotation"
+	// StrategyEvict records the hash on workload metadata and evicts outdated pods one at a time through
+	// the eviction API, leaving the pod template untouched for GitOps-managed workloads.
+	StrategyEvict = "evict"
+	// StrategyRestartedAt stamps a timestamp annotation on the pod template exactly like `kubectl rollout
+	// restart`, and records the hash on workload metadata only.
+	StrategyRestartedAt = "restarted-at"
+)
+
+// DefaultRestartedAtAnnotation is the pod template annotation written by `kubectl rollout restart`.
+const DefaultRestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+const (
+	// RestartStrategyAnnotation selects the restart strategy for a single workload.
+	RestartStrategyAnnotation = "synapse.gen0sec.com/restart-strategy"
+	// restartRequestedAtAnnotation marks when the evict strategy started replacing pods; older pods are outdated.
+	restartRequestedAtAnnotation = "synapse.gen0sec.com/restart-requested-at"
+)
+
+// evictRetryInterval is how long the evict strategy waits before checking on a restart in progress.
+const evictRetryInterval = 10 * time.Second
+
+// restartOutcome reports what a strategy did for one workload.
+type restartOutcome struct {
+	// updated is true when the strategy wrote anything for the new hash.
+	updated bool
+	// requeueAfter is set while the restart is still in progress and needs another pass.
+	requeueAfter time.Duration
+}
+
+// restartStrategy rolls a workload onto a new config hash.
+type restartStrategy interface {
+	// appliedHash returns the hash w was last rolled to by this strategy.
+	appliedHash(r *ConfigMapReconciler, w *workload) string
+	apply(ctx context.Context, r *ConfigMapReconciler, w *workload, hash string) (restartOutcome, error)
+	// inject records hash as applied on a workload that is being created, without restarting anything.
+	inject(r *ConfigMapReconciler, w *workload, hash string)
+	// requestRestart records on w a restart requested at at without a config change; the caller patches w,
+	// then applies the hash w already runs to carry the restart out.
+	requestRestart(r *ConfigMapReconciler, w *workload, at time.Time)
+}
+
+var restartStrategies = map[string]restartStrategy{
+	StrategyAnnotation:  templateAnnotationStrategy{},
+	StrategyEvict:       evictStrategy{},
+	StrategyRestartedAt: restartedAtStrategy{},
+	StrategyCanary:      canaryStrategy{},
+	StrategyEnv:         envStrategy{},
+	StrategyVersioned:   versionedStrategy{},
+}
+
+// ValidRestartStrategy reports whether name is a known restart strategy.
+func ValidRestartStrategy(name string) bool {
+	_, ok := restartStrategies[name]
+	return ok
+}
+
+// strategyFor resolves the strategy of w from its annotation, falling back to the configured default.
+func (r *ConfigMapReconciler) strategyFor(w *workload) (string, restartStrategy, error) {
+	if w.kind == "CronJob" {
+		// Only the job template can carry the hash: there are no running pods to restart or evict.
+		if override, ok := w.obj.GetAnnotations()[RestartStrategyAnnotation]; ok && override != StrategyAnnotation {
+			return override, nil, fmt.Errorf("restart strategy %q is not supported for CronJobs", override)
+		}
+		return StrategyAnnotation, restartStrategies[StrategyAnnotation], nil
+	}
+	name := r.RestartStrategy
+	if override, ok := w.obj.GetAnnotations()[RestartStrategyAnnotation]; ok {
+		name = override
+	}
+	if name == "" {
+		name = StrategyAnnotation
+	}
+	strategy, ok := restartStrategies[name]
+	if !ok {
+		return name, nil, fmt.Errorf("unknown restart strategy %q", name)
+	}
+	if name == StrategyVersioned && name != r.RestartStrategy && r.ConformanceMode {
+		return name, nil, fmt.Errorf("restart strategy %q is only allowed in conformance mode with --restart-strategy=%s", name, name)
+	}
+	if name == StrategyEnv {
+		if _, _, err := envTarget(w); err != nil {
+			return name, nil, err
+		}
+	}
+	return name, strategy, nil
+}
+
+type templateAnnotationStrategy struct{}
+
+func (templateAnnotationStrategy) appliedHash(r *ConfigMapReconciler, w *workload) string {
+	return w.template.Annotations[r.ConfigHashAnnotation]
+}
+
+func (templateAnnotationStrategy) apply(ctx context.Context, r *ConfigMapReconciler, w *workload, hash string) (restartOutcome, error) {
+	if r.ServerSideApply {
+		updated, err := r.applyTemplateAnnotation(ctx, w, r.ConfigHashAnnotation, hash)
+		return restartOutcome{updated: updated}, err
+	}
+	updated, err := patchTemplateAnnotation(ctx, r.Client, w, r.ConfigHashAnnotation, hash)
+	return restartOutcome{updated: updated}, err
+}
+
+func (templateAnnotationStrategy) inject(r *ConfigMapReconciler, w *workload, hash string) {
+	setTemplateAnnotation(w, r.ConfigHashAnnotation, hash)
+}
+
+func (templateAnnotationStrategy) requestRestart(r *ConfigMapReconciler, w *workload, at time.Time) {
+	stampRestartedAt(r, w, at)
+}
+
+// stampRestartedAt rolls the pods of w like `kubectl rollout restart`, for the strategies that roll pods
+// through their template.
+func stampRestartedAt(r *ConfigMapReconciler, w *workload, at time.Time) {
+	setTemplateAnnotation(w, r.restartedAtAnnotation(), at.UTC().Format(time.RFC3339))
+}
+
+func patchTemplateAnnotation(ctx context.Context, c client.Client, w *workload, annotationKey, hash string) (bool, error) {
+	original := w.obj.DeepCopyObject().(client.Object)
+	if w.template.Annotations == nil {
+		w.template.Annotations = map[string]string{}
+	}
+	if existing := w.template.Annotations[annotationKey]; existing == hash {
+		return false, nil
+	}
+	w.template.Annotations[annotationKey] = hash
+	stampRestartCause(ctx, w)
+	return true, c.Patch(ctx, w.obj, client.MergeFrom(original))
+}
+
+type restartedAtStrategy struct{}
+
+func (restartedAtStrategy) appliedHash(r *ConfigMapReconciler, w *workload) string {
+	return w.obj.GetAnnotations()[r.ConfigHashAnnotation]
+}
+
+func (restartedAtStrategy) inject(r *ConfigMapReconciler, w *workload, hash string) {
+	setMetadataAnnotation(w, r.ConfigHashAnnotation, hash)
+}
+
+func (restartedAtStrategy) requestRestart(r *ConfigMapReconciler, w *workload, at time.Time) {
+	stampRestartedAt(r, w, at)
+}
+
+func (restartedAtStrategy) apply(ctx context.Context, r *ConfigMapReconciler, w *workload, hash string) (restartOutcome, error) {
+	annotations := w.obj.GetAnnotations()
+	if annotations[r.ConfigHashAnnotation] == hash {
+		return restartOutcome{}, nil
+	}
+	original := w.obj.DeepCopyObject().(client.Object)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[r.ConfigHashAnnotation] = hash
+	w.obj.SetAnnotations(annotations)
+	if w.template.Annotations == nil {
+		w.template.Annotations = map[string]string{}
+	}
+	w.template.Annotations[r.restartedAtAnnotation()] = time.Now().UTC().Format(time.RFC3339)
+	stampRestartCause(ctx, w)
+	return restartOutcome{updated: true}, r.Patch(ctx, w.obj, client.MergeFrom(original))
+}
+
+func (r *ConfigMapReconciler) restartedAtAnnotation() string {
+	if r.RestartedAtAnnotation == "" {
+		return DefaultRestartedAtAnnotation
+	}
+	return r.RestartedAtAnnotation
+}
+
+type evictStrategy struct{}
+
+func (evictStrategy) appliedHash(r *ConfigMapReconciler, w *workload) string {
+	return w.obj.GetAnnotations()[r.ConfigHashAnnotation]
+}
+
+func (evictStrategy) inject(r *ConfigMapReconciler, w *workload, hash string) {
+	setMetadataAnnotation(w, r.ConfigHashAnnotation, hash)
+}
+
+// requestRestart marks the pods created before at as outdated, so applying the current hash evicts them,
+// which also restarts OnDelete StatefulSets.
+func (evictStrategy) requestRestart(_ *ConfigMapReconciler, w *workload, at time.Time) {
+	setMetadataAnnotation(w, restartRequestedAtAnnotation, at.UTC().Format(time.RFC3339))
+}
+
+func (evictStrategy) apply(ctx context.Context, r *ConfigMapReconciler, w *workload, hash string) (restartOutcome, error) {
+	outcome := restartOutcome{}
+	annotations := w.obj.GetAnnotations()
+	if annotations[r.ConfigHashAnnotation] != hash {
+		original := w.obj.DeepCopyObject().(client.Object)
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[r.ConfigHashAnnotation] = hash
+		annotations[restartRequestedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+		w.obj.SetAnnotations(annotations)
+		if err := r.Patch(ctx, w.obj, client.MergeFrom(original)); err != nil {
+			return outcome, err
+		}
+		outcome.updated = true
+	}
+
+	requestedAt, err := time.Parse(time.RFC3339, annotations[restartRequestedAtAnnotation])
+	if err != nil {
+		// Nothing was requested by this strategy (or the marker was edited away); nothing to evict.
+		return outcome, nil
+	}
+
+	stale, err := r.outdatedPods(ctx, w, requestedAt)
+	if err != nil || len(stale) == 0 {
+		return outcome, err
+	}
+	outcome.requeueAfter = evictRetryInterval
+	if r.ZoneTopologyKey != "" && w.kind == "StatefulSet" {
+		return outcome, r.evictByZone(ctx, w, stale, hash)
+	}
+	if !w.available() {
+		// Wait for the previous replacement to become available before taking down the next pod.
+		return outcome, nil
+	}
+	return outcome, r.evictPod(ctx, w, stale[0], hash)
+}
+
+// evictPod evicts pod of w through the eviction API. An eviction refused by a PodDisruptionBudget, or of a
+// pod already gone, is left for the next pass.
+func (r *ConfigMapReconciler) evictPod(ctx context.Context, w *workload, pod *corev1.Pod, hash string) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	}
+	if err := r.SubResource("eviction").Create(ctx, pod, eviction); err != nil {
+		if apierrors.IsTooManyRequests(err) {
+			// A PodDisruptionBudget is blocking the eviction; try again later.
+			return nil
+		}
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	r.traceEvent(ctx, w.obj, corev1.EventTypeNormal, "PodEvicted", fmt.Sprintf("Evicted pod %s to apply config hash %s", pod.Name, hash))
+	return nil
+}
+
+func setTemplateAnnotation(w *workload, key, value string) {
+	if w.template.Annotations == nil {
+		w.template.Annotations = map[string]string{}
+	}
+	w.template.Annotations[key] = value
+}
+
+func setMetadataAnnotation(w *workload, key, value string) {
+	annotations := w.obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	w.obj.SetAnnotations(annotations)
+}
+
+// workloadPods lists the pods of w.
+func (r *ConfigMapReconciler) workloadPods(ctx context.Context, w *workload) (*corev1.PodList, error) {
+	selector, err := metav1.LabelSelectorAsSelector(w.selector)
+	if err != nil {
+		return nil, err
+	}
+	pods := &corev1.PodList{}
+	if err := r.reader().List(
+		ctx,
+		pods,
+		client.InNamespace(w.obj.GetNamespace()),
+		client.MatchingLabelsSelector{Selector: selector},
+	); err != nil {
+		return nil, err
+	}
+	return pods, nil
+}
+
+// outdatedPods returns the running pods of w created before requestedAt, oldest first.
+func (r *ConfigMapReconciler) outdatedPods(ctx context.Context, w *workload, requestedAt time.Time) ([]*corev1.Pod, error) {
+	pods, err := r.workloadPods(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+
+	stale := make([]*corev1.Pod, 0, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || !pod.CreationTimestamp.Time.Before(requestedAt) {
+			continue
+		}
+		stale = append(stale, pod)
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		if stale[i].CreationTimestamp.Equal(&stale[j].CreationTimestamp) {
+			return stale[i].Name < stale[j].Name
+		}
+		return stale[i].CreationTimestamp.Before(&stale[j].CreationTimestamp)
+	})
+	return stale, nil
+}
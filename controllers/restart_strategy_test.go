@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testHashAnnotation = "synapse.gen0sec.com/config-hash"
+
+func newTestReconciler(t *testing.T, objs ...client.Object) *ConfigMapReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...)
+	for _, index := range sourceRefIndexes {
+		builder = builder.WithIndex(index.obj, index.field, index.extract)
+	}
+	c := builder.Build()
+	return &ConfigMapReconciler{
+		Client:               c,
+		Scheme:               scheme,
+		ConfigHashAnnotation: testHashAnnotation,
+	}
+}
+
+func newTestDeployment(annotations map[string]string) *appsv1.Deployment {
+	replicas := int32(1)
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "synapse",
+			Namespace:   "matrix",
+			Annotations: annotations,
+			Labels:      map[string]string{"app.kubernetes.io/name": "synapse"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "synapse"}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "synapse"}},
+			},
+		},
+		Status: appsv1.DeploymentStatus{UpdatedReplicas: 1, AvailableReplicas: 1},
+	}
+}
+
+func TestTemplateAnnotationStrategy(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil))
+
+	workloads, err := r.listWorkloads(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, workloads, 1)
+
+	outcome, err := templateAnnotationStrategy{}.apply(ctx, r, workloads[0], "abc")
+	require.NoError(t, err)
+	assert.True(t, outcome.updated)
+
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Equal(t, "abc", deploy.Spec.Template.Annotations[testHashAnnotation])
+
+	outcome, err = templateAnnotationStrategy{}.apply(ctx, r, deploymentWorkload(&deploy), "abc")
+	require.NoError(t, err)
+	assert.False(t, outcome.updated)
+}
+
+func TestEvictStrategy(t *testing.T) {
+	ctx := context.Background()
+	old := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "synapse-old",
+			Namespace:         "matrix",
+			Labels:            map[string]string{"app": "synapse"},
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+		},
+	}
+	r := newTestReconciler(t, newTestDeployment(map[string]string{RestartStrategyAnnotation: StrategyEvict}), old)
+
+	workloads, err := r.listWorkloads(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, workloads, 1)
+
+	name, strategy, err := r.strategyFor(workloads[0])
+	require.NoError(t, err)
+	assert.Equal(t, StrategyEvict, name)
+
+	outcome, err := strategy.apply(ctx, r, workloads[0], "abc")
+	require.NoError(t, err)
+	assert.True(t, outcome.updated)
+	assert.Equal(t, evictRetryInterval, outcome.requeueAfter)
+
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Equal(t, "abc", deploy.Annotations[testHashAnnotation])
+	assert.NotEmpty(t, deploy.Annotations[restartRequestedAtAnnotation])
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+
+	var pods corev1.PodList
+	require.NoError(t, r.List(ctx, &pods, client.InNamespace("matrix")))
+	assert.Empty(t, pods.Items)
+
+	outcome, err = strategy.apply(ctx, r, deploymentWorkload(&deploy), "abc")
+	require.NoError(t, err)
+	assert.False(t, outcome.updated)
+	assert.Zero(t, outcome.requeueAfter)
+}
+
+func TestStrategyForRejectsUnknown(t *testing.T) {
+	r := newTestReconciler(t)
+	_, _, err := r.strategyFor(deploymentWorkload(newTestDeployment(map[string]string{RestartStrategyAnnotation: "bogus"})))
+	assert.Error(t, err)
+	assert.True(t, ValidRestartStrategy(StrategyAnnotation))
+	assert.False(t, ValidRestartStrategy("bogus"))
+}
+
+func TestRestartedAtStrategy(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil))
+
+	workloads, err := r.listWorkloads(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, workloads, 1)
+
+	outcome, err := restartedAtStrategy{}.apply(ctx, r, workloads[0], "abc")
+	require.NoError(t, err)
+	assert.True(t, outcome.updated)
+
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Equal(t, "abc", deploy.Annotations[testHashAnnotation])
+	_, err = time.Parse(time.RFC3339, deploy.Spec.Template.Annotations[DefaultRestartedAtAnnotation])
+	assert.NoError(t, err)
+	assert.NotContains(t, deploy.Spec.Template.Annotations, testHashAnnotation)
+	assert.Equal(t, "abc", restartedAtStrategy{}.appliedHash(r, deploymentWorkload(&deploy)))
+
+	outcome, err = restartedAtStrategy{}.apply(ctx, r, deploymentWorkload(&deploy), "abc")
+	require.NoError(t, err)
+	assert.False(t, outcome.updated)
+}
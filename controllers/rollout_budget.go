@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"synapse-operator/audit"
+)
+
+// rolloutBudgetRecheckInterval is how often a namespace waiting for a slot of MaxRollingNamespaces checks
+// whether one is free.
+const rolloutBudgetRecheckInterval = 10 * time.Second
+
+// rolloutBudgetQueueTTL is how long a namespace keeps its place in the queue without checking again, so a
+// namespace that stopped waiting, for example because its change was reverted, does not hold up the others.
+const rolloutBudgetQueueTTL = 3 * rolloutBudgetRecheckInterval
+
+// rolloutBudget hands out the slots of MaxRollingNamespaces to namespaces in the order they asked for one.
+type rolloutBudget struct {
+	mu sync.Mutex
+	// rolling holds the namespaces with a slot; queue the namespaces waiting for one, with when each last asked.
+	rolling map[string]struct{}
+	queue   []queuedRollout
+}
+
+type queuedRollout struct {
+	namespace string
+	seen      time.Time
+}
+
+// admitRollout reports whether namespace may restart its pending workloads with MaxRollingNamespaces. A
+// namespace keeps its slot from its first restart until releaseRollout finds nothing left to roll out, and
+// otherwise gets one when one is free and no namespace that asked earlier still waits. A namespace with
+// nothing pending needs no slot and leaves the queue.
+func (r *ConfigMapReconciler) admitRollout(namespace string, pending int, now time.Time) bool {
+	if r.MaxRollingNamespaces <= 0 {
+		return true
+	}
+	b := &r.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if pending == 0 {
+		// Nothing to restart any more, so the namespace stops waiting.
+		b.queue = slices.DeleteFunc(b.queue, func(q queuedRollout) bool { return q.namespace == namespace })
+		b.updateMetrics(r.ClusterName)
+		return true
+	}
+	if b.rolling == nil {
+		b.rolling = map[string]struct{}{}
+	}
+	if _, ok := b.rolling[namespace]; ok {
+		return true
+	}
+	b.queue = slices.DeleteFunc(b.queue, func(q queuedRollout) bool {
+		return q.namespace != namespace && now.Sub(q.seen) > rolloutBudgetQueueTTL
+	})
+	position := slices.IndexFunc(b.queue, func(q queuedRollout) bool { return q.namespace == namespace })
+	if len(b.rolling) < r.MaxRollingNamespaces && (position == 0 || len(b.queue) == 0) {
+		if position == 0 {
+			b.queue = b.queue[1:]
+		}
+		b.rolling[namespace] = struct{}{}
+		b.updateMetrics(r.ClusterName)
+		return true
+	}
+	if position < 0 {
+		b.queue = append(b.queue, queuedRollout{namespace: namespace, seen: now})
+		rolloutBudgetWaitsTotal.WithLabelValues(r.ClusterName, namespace).Inc()
+	} else {
+		b.queue[position].seen = now
+	}
+	b.updateMetrics(r.ClusterName)
+	return false
+}
+
+// releaseRollout gives the slot of namespace back once none of its restarts is deferred or still rolling.
+func (r *ConfigMapReconciler) releaseRollout(namespace string, deferred bool) {
+	if r.MaxRollingNamespaces <= 0 || deferred || r.rollingInNamespace(namespace) {
+		return
+	}
+	b := &r.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.rolling[namespace]; !ok {
+		return
+	}
+	delete(b.rolling, namespace)
+	b.updateMetrics(r.ClusterName)
+}
+
+// dropRollout gives the slot of namespace back and takes it out of the queue, whatever it still rolls out,
+// for a namespace that has no config sources left or was deleted and so is never verified again.
+func (r *ConfigMapReconciler) dropRollout(namespace string) {
+	b := &r.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, rolling := b.rolling[namespace]
+	queued := slices.ContainsFunc(b.queue, func(q queuedRollout) bool { return q.namespace == namespace })
+	if !rolling && !queued {
+		return
+	}
+	delete(b.rolling, namespace)
+	b.queue = slices.DeleteFunc(b.queue, func(q queuedRollout) bool { return q.namespace == namespace })
+	b.updateMetrics(r.ClusterName)
+}
+
+// rollingInNamespace reports whether a workload of namespace restarted by the operator is not available yet.
+func (r *ConfigMapReconciler) rollingInNamespace(namespace string) bool {
+	rolling := false
+	r.progress.Range(func(key, _ any) bool {
+		rolling = strings.HasPrefix(key.(string), namespace+"/")
+		return !rolling
+	})
+	return rolling
+}
+
+// queuedRolloutPosition returns the place of namespace in the queue for a slot, counting from 1, or 0 when
+// it does not wait.
+func (r *ConfigMapReconciler) queuedRolloutPosition(namespace string) int {
+	b := &r.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.IndexFunc(b.queue, func(q queuedRollout) bool { return q.namespace == namespace }) + 1
+}
+
+// updateMetrics reports the budget of cluster, and must be called with mu held.
+func (b *rolloutBudget) updateMetrics(cluster string) {
+	rolloutBudgetRollingGauge.WithLabelValues(cluster).Set(float64(len(b.rolling)))
+	rolloutBudgetQueuedGauge.WithLabelValues(cluster).Set(float64(len(b.queue)))
+}
+
+// holdForRolloutBudget halts pass while namespace waits for a slot of MaxRollingNamespaces, and reports
+// whether it did.
+func (r *ConfigMapReconciler) holdForRolloutBudget(ctx context.Context, pass *rolloutPass, pending int) bool {
+	if r.admitRollout(pass.Namespace, pending, time.Now()) {
+		return false
+	}
+	position := r.queuedRolloutPosition(pass.Namespace)
+	pass.Logger.Info("Holding rollout until fewer namespaces are rolling out", "configHash", pass.Hash, "maxRollingNamespaces", r.MaxRollingNamespaces, "queuePosition", position)
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "rollout-budget", Detail: fmt.Sprintf("%d namespaces rolling out, queued at position %d", r.MaxRollingNamespaces, position)})
+	pass.RequeueAfter(rolloutBudgetRecheckInterval)
+	pass.Halt("rollout budget exhausted")
+	return true
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestAdmitRolloutInOrder(t *testing.T) {
+	r := &ConfigMapReconciler{MaxRollingNamespaces: 1}
+	now := time.Unix(1000, 0)
+
+	assert.True(t, r.admitRollout("a", 0, now), "nothing to restart needs no slot")
+	assert.True(t, r.admitRollout("a", 1, now))
+	assert.True(t, r.admitRollout("a", 1, now), "a namespace keeps its slot")
+	assert.False(t, r.admitRollout("b", 1, now))
+	assert.False(t, r.admitRollout("c", 1, now))
+	assert.Equal(t, 2, r.queuedRolloutPosition("c"))
+
+	r.releaseRollout("a", true)
+	assert.False(t, r.admitRollout("c", 1, now), "a deferred restart keeps the slot")
+	r.releaseRollout("a", false)
+	assert.False(t, r.admitRollout("c", 1, now), "b waits longer")
+	assert.True(t, r.admitRollout("b", 1, now))
+	assert.Equal(t, 1, r.queuedRolloutPosition("c"))
+
+	r.releaseRollout("b", false)
+	assert.False(t, r.admitRollout("d", 1, now))
+	later := now.Add(rolloutBudgetQueueTTL + time.Second)
+	assert.True(t, r.admitRollout("d", 1, later), "c stopped waiting")
+	assert.Zero(t, r.queuedRolloutPosition("c"))
+
+	assert.False(t, r.admitRollout("e", 1, later))
+	assert.True(t, r.admitRollout("e", 0, later))
+	assert.Zero(t, r.queuedRolloutPosition("e"), "a namespace with nothing pending stops waiting")
+}
+
+func TestMaxRollingNamespaces(t *testing.T) {
+	ctx := context.Background()
+	labels := map[string]string{"app.kubernetes.io/name": "synapse"}
+	var objs []client.Object
+	for _, namespace := range []string{"matrix", "element"} {
+		cm := newTestConfigMap("homeserver", labels, nil, "a")
+		cm.Namespace = namespace
+		deploy := newTestDeployment(nil)
+		deploy.Namespace = namespace
+		deploy.Status.AvailableReplicas = 0
+		objs = append(objs, cm, deploy)
+	}
+	r := newTestReconciler(t, objs...)
+	r.MaxRollingNamespaces = 1
+	reconcile := func(namespace string) {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "homeserver"}})
+		require.NoError(t, err)
+	}
+	appliedHash := func(namespace string) string {
+		deploy := &appsv1.Deployment{}
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "synapse"}, deploy))
+		return deploy.Spec.Template.Annotations[testHashAnnotation]
+	}
+
+	reconcile("matrix")
+	assert.NotEmpty(t, appliedHash("matrix"))
+	reconcile("element")
+	assert.Empty(t, appliedHash("element"), "element waits while matrix rolls out")
+	status, err := r.namespaceStatus(ctx, "element")
+	require.NoError(t, err)
+	assert.Contains(t, status.Holds, "rollout-budget: queued at position 1")
+
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	deploy.Status.AvailableReplicas = 1
+	require.NoError(t, r.Status().Update(ctx, deploy))
+	reconcile("matrix")
+	reconcile("element")
+	assert.NotEmpty(t, appliedHash("element"), "element rolls out once matrix is done")
+}
+
+func TestRolloutBudgetMetricsPerCluster(t *testing.T) {
+	now := time.Unix(1000, 0)
+	hub := &ConfigMapReconciler{MaxRollingNamespaces: 1}
+	spoke := &ConfigMapReconciler{MaxRollingNamespaces: 1, ClusterName: "budget-spoke"}
+
+	assert.True(t, hub.admitRollout("matrix", 1, now))
+	assert.True(t, spoke.admitRollout("matrix", 1, now), "each cluster has its own budget")
+	assert.False(t, spoke.admitRollout("element", 1, now))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutBudgetRollingGauge.WithLabelValues("")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(rolloutBudgetQueuedGauge.WithLabelValues("")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutBudgetRollingGauge.WithLabelValues("budget-spoke")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutBudgetQueuedGauge.WithLabelValues("budget-spoke")))
+
+	hub.releaseRollout("matrix", false)
+	assert.Equal(t, float64(0), testutil.ToFloat64(rolloutBudgetRollingGauge.WithLabelValues("")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutBudgetRollingGauge.WithLabelValues("budget-spoke")))
+}
+
+func TestRolloutSlotReleasedWithoutSources(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t)
+	r.MaxRollingNamespaces = 1
+	now := time.Now()
+	require.True(t, r.admitRollout("matrix", 1, now))
+
+	// The config sources of matrix were deleted while it held the slot.
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	assert.True(t, r.admitRollout("element", 1, now))
+}
+
+func TestRolloutSlotReleasedOnDeletion(t *testing.T) {
+	ctx := context.Background()
+	r := &ConfigMapReconciler{MaxRollingNamespaces: 1}
+	now := time.Now()
+	deploy := newTestDeployment(nil)
+	deploy.Status.AvailableReplicas = 0
+	r.trackProgress(ctx, deploymentWorkload(deploy), "abc", true, now)
+	require.True(t, r.admitRollout("matrix", 1, now))
+	r.releaseRollout("matrix", false)
+	require.False(t, r.admitRollout("element", 1, now), "matrix still rolls out")
+
+	r.workloadDeleted(r.workloadKinds()[0])(toolscache.DeletedFinalStateUnknown{Key: "matrix/synapse", Obj: deploy})
+	r.releaseRollout("matrix", false)
+	assert.True(t, r.admitRollout("element", 1, now), "a deleted workload no longer rolls out")
+
+	require.False(t, r.admitRollout("matrix", 1, now))
+	r.namespaceDeleted(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "element"}})
+	assert.True(t, r.admitRollout("matrix", 1, now), "a deleted namespace gives its slot back")
+}
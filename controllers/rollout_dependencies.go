@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/audit"
+)
+
+// RolloutAfterAnnotation on a Namespace lists, comma-separated, the namespaces whose rollouts must complete
+// before config changes of this namespace roll out, such as bridge namespaces depending on the homeserver.
+const RolloutAfterAnnotation = "synapse.gen0sec.com/rollout-after"
+
+// blockedReasonDependency marks a restart held until the namespaces it depends on have rolled out.
+const blockedReasonDependency = "RolloutDependencyPending"
+
+// rolloutDependencyPollInterval is how often a rollout waiting on another namespace checks on it.
+const rolloutDependencyPollInterval = 15 * time.Second
+
+// rolloutDependencies returns the namespaces ns declares to roll out after.
+func rolloutDependencies(ns *corev1.Namespace) []string {
+	var dependencies []string
+	for _, name := range strings.Split(ns.Annotations[RolloutAfterAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			dependencies = append(dependencies, name)
+		}
+	}
+	return dependencies
+}
+
+// rolloutDependencyGate holds a rollout until every namespace it depends on runs its own current hash on
+// available workloads. A dependency cycle holds it until the cycle is broken. Held workloads are reported
+// as blocked.
+func (r *ConfigMapReconciler) rolloutDependencyGate(ctx context.Context, pass *rolloutPass) error {
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pass.Namespace}, ns); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if len(rolloutDependencies(ns)) == 0 {
+		r.clearNamespaceBlocked(pass.Namespace, blockedReasonDependency)
+		return nil
+	}
+	behind, err := r.workloadsBehind(ctx, pass.Namespace, pass.Hash)
+	if err != nil {
+		return err
+	}
+	var waitingFor string
+	if len(behind) > 0 {
+		if waitingFor, err = r.pendingRolloutDependency(ctx, pass.Namespace); err != nil {
+			return err
+		}
+	}
+	if waitingFor == "" {
+		r.clearNamespaceBlocked(pass.Namespace, blockedReasonDependency)
+		return nil
+	}
+
+	pass.Logger.Info("Holding rollout until the namespaces it depends on have rolled out", "waitingFor", waitingFor, "configHash", pass.Hash)
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "rollout-dependency", Detail: "waiting for " + waitingFor})
+	for _, w := range behind {
+		r.reportBlocked(w, pass.Hash, blockedReasonDependency, fmt.Sprintf("Config hash %s is pending, waiting for %s", pass.Hash, waitingFor))
+	}
+	pass.RequeueAfter(rolloutDependencyPollInterval)
+	pass.Halt("waiting for " + waitingFor)
+	return nil
+}
+
+// pendingRolloutDependency describes what the rollout of namespace is waiting for among its dependencies,
+// or returns "" when it is waiting for nothing.
+func (r *ConfigMapReconciler) pendingRolloutDependency(ctx context.Context, namespace string) (string, error) {
+	cycle, err := r.rolloutDependencyCycle(ctx, namespace)
+	if err != nil {
+		return "", err
+	}
+	if cycle != nil {
+		return "the dependency cycle " + strings.Join(cycle, " -> ") + " to be broken", nil
+	}
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	for _, dependency := range rolloutDependencies(ns) {
+		status, err := r.namespaceWorkloadStatus(ctx, dependency)
+		if err != nil {
+			return "", err
+		}
+		// A namespace without config sources has nothing to roll out.
+		if status.Hash != "" && !status.rolledOut(status.Hash) {
+			return "namespace " + dependency + " to roll out", nil
+		}
+	}
+	return "", nil
+}
+
+// rolloutDependencyCycle returns a dependency cycle reachable from namespace, as the namespaces along it
+// with the first repeated at the end, or nil if there is none. Namespaces that do not exist have no
+// dependencies.
+func (r *ConfigMapReconciler) rolloutDependencyCycle(ctx context.Context, namespace string) ([]string, error) {
+	var path []string
+	visited := map[string]bool{}
+	var visit func(name string) ([]string, error)
+	visit = func(name string) ([]string, error) {
+		if i := slices.Index(path, name); i >= 0 {
+			return append(slices.Clone(path[i:]), name), nil
+		}
+		if visited[name] {
+			return nil, nil
+		}
+		visited[name] = true
+		ns := &corev1.Namespace{}
+		if err := r.Get(ctx, client.ObjectKey{Name: name}, ns); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		path = append(path, name)
+		for _, dependency := range rolloutDependencies(ns) {
+			if cycle, err := visit(dependency); cycle != nil || err != nil {
+				return cycle, err
+			}
+		}
+		path = path[:len(path)-1]
+		return nil, nil
+	}
+	return visit(namespace)
+}
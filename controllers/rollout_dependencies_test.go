@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestRolloutDependencyHoldsUntilDependencyRolledOut(t *testing.T) {
+	ctx := context.Background()
+	bridges := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{RolloutAfterAnnotation: "matrix-core"}}}
+	coreDeploy := newTestDeployment(nil)
+	coreDeploy.Namespace = "matrix-core"
+	coreConfig := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "core")
+	coreConfig.Namespace = "matrix-core"
+	r := newTestReconciler(t, bridges, newTestDeployment(nil), coreDeploy, coreConfig, newTestConfigMap("bridge", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	bridgeRequest := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "bridge"}}
+	bridgeHash := func() string {
+		deploy := &appsv1.Deployment{}
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+		return deploy.Spec.Template.Annotations[testHashAnnotation]
+	}
+
+	result, err := r.Reconcile(ctx, bridgeRequest)
+	require.NoError(t, err)
+	assert.Equal(t, rolloutDependencyPollInterval, result.RequeueAfter)
+	assert.Empty(t, bridgeHash())
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutBlockedGauge.WithLabelValues("", "matrix", "deployment/synapse", blockedReasonDependency)))
+	holds, err := r.namespaceHolds(ctx, "matrix", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"rollout-dependency: waiting for namespace matrix-core to roll out"}, holds)
+
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix-core", Name: "homeserver"}})
+	require.NoError(t, err)
+	_, err = r.Reconcile(ctx, bridgeRequest)
+	require.NoError(t, err)
+	assert.NotEmpty(t, bridgeHash())
+	_, blocked := r.blockedHashes.Load("matrix/deployment/synapse/" + blockedReasonDependency)
+	assert.False(t, blocked)
+}
+
+func TestRolloutDependencyCycleHoldsRollout(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{RolloutAfterAnnotation: "bridges, missing"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bridges", Annotations: map[string]string{RolloutAfterAnnotation: "matrix"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "standalone", Annotations: map[string]string{RolloutAfterAnnotation: "missing"}}},
+		newTestDeployment(nil),
+		newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"),
+	)
+
+	cycle, err := r.rolloutDependencyCycle(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"matrix", "bridges", "matrix"}, cycle)
+	cycle, err = r.rolloutDependencyCycle(ctx, "standalone")
+	require.NoError(t, err)
+	assert.Nil(t, cycle, "a namespace that does not exist has no dependencies")
+
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RolloutHistoryAnnotation holds the recent config hashes a workload was rolled to, oldest first.
+const RolloutHistoryAnnotation = "synapse.gen0sec.com/rollout-history"
+
+// rolloutHistoryMaxBytes caps the encoded history so it stays readable in `kubectl describe` and well
+// below the metadata size limit; the oldest entries are dropped first.
+const rolloutHistoryMaxBytes = 1024
+
+// RolloutHistoryEntry is one rollout recorded in the history annotation.
+type RolloutHistoryEntry struct {
+	Hash string    `json:"hash"`
+	Time time.Time `json:"time"`
+}
+
+// ParseRolloutHistory decodes the history annotation value. A missing or malformed value yields no entries.
+func ParseRolloutHistory(value string) []RolloutHistoryEntry {
+	if value == "" {
+		return nil
+	}
+	var entries []RolloutHistoryEntry
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		return nil
+	}
+	return entries
+}
+
+// appendRolloutHistory adds entry to the encoded history, keeping at most size entries within
+// rolloutHistoryMaxBytes.
+func appendRolloutHistory(value string, entry RolloutHistoryEntry, size int) (string, error) {
+	entries := append(ParseRolloutHistory(value), entry)
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+	for {
+		encoded, err := json.Marshal(entries)
+		if err != nil {
+			return "", err
+		}
+		if len(encoded) <= rolloutHistoryMaxBytes || len(entries) == 1 {
+			return string(encoded), nil
+		}
+		entries = entries[1:]
+	}
+}
+
+// recordRolloutHistory appends hash to the rollout history annotation of w when history is enabled.
+func (r *ConfigMapReconciler) recordRolloutHistory(ctx context.Context, w *workload, hash string) error {
+	if r.RolloutHistorySize <= 0 {
+		return nil
+	}
+	annotations := w.obj.GetAnnotations()
+	value, err := appendRolloutHistory(annotations[RolloutHistoryAnnotation], RolloutHistoryEntry{
+		Hash: hash,
+		Time: time.Now().UTC().Truncate(time.Second),
+	}, r.RolloutHistorySize)
+	if err != nil {
+		return err
+	}
+
+	original := w.obj.DeepCopyObject().(client.Object)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[RolloutHistoryAnnotation] = value
+	w.obj.SetAnnotations(annotations)
+	return r.Patch(ctx, w.obj, client.MergeFrom(original))
+}
@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// RolloutHistoryGVK identifies the SynapseRolloutHistory resource listing the recent rollouts of a namespace.
+// It is accessed as unstructured so the operator does not need generated API types for it.
+var RolloutHistoryGVK = schema.GroupVersionKind{
+	Group:   "synapse.gen0sec.com",
+	Version: "v1alpha1",
+	Kind:    "SynapseRolloutHistory",
+}
+
+// RolloutHistoryResourceName is the name of the SynapseRolloutHistory kept in each namespace.
+const RolloutHistoryResourceName = "synapse-rollout-history"
+
+// RollbackToAnnotation on a SynapseRolloutHistory requests a rollback to the rollout with that revision.
+// The operator removes it once the rollback has been applied.
+const RollbackToAnnotation = "synapse.gen0sec.com/rollback-to"
+
+// RolloutRecord is one triggered rollout in a SynapseRolloutHistory.
+type RolloutRecord struct {
+	Revision  int64           `json:"revision"`
+	Hash      string          `json:"hash"`
+	Time      time.Time       `json:"time"`
+	Sources   []RolloutSource `json:"sources,omitempty"`
+	Workloads []string        `json:"workloads,omitempty"`
+	// ConfigMaps snapshots the content of the ConfigMap sources, keyed by name, for rollback. Secret content
+	// is never copied; Secrets are listed in Sources by resourceVersion only.
+	ConfigMaps map[string]ConfigMapSnapshot `json:"configMaps,omitempty"`
+	// Stalled is set when a workload restarted for this rollout did not become ready in time.
+	Stalled bool `json:"stalled,omitempty"`
+}
+
+// RolloutSource is a config source as it was when a rollout was triggered.
+type RolloutSource struct {
+	Kind            string `json:"kind"`
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// ConfigMapSnapshot is the content of a ConfigMap source at a rollout.
+type ConfigMapSnapshot struct {
+	Labels     map[string]string `json:"labels,omitempty"`
+	Data       map[string]string `json:"data,omitempty"`
+	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+}
+
+// readRolloutRecords returns the records in a SynapseRolloutHistory, oldest first.
+func readRolloutRecords(obj *unstructured.Unstructured) ([]RolloutRecord, error) {
+	raw, found, err := unstructured.NestedSlice(obj.Object, "spec", "rollouts")
+	if err != nil || !found {
+		return nil, err
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var records []RolloutRecord
+	if err := json.Unmarshal(encoded, &records); err != nil {
+		return nil, fmt.Errorf("decoding %s %s/%s: %w", RolloutHistoryGVK.Kind, obj.GetNamespace(), obj.GetName(), err)
+	}
+	return records, nil
+}
+
+// LatestRollout returns the latest record in the SynapseRolloutHistory of namespace, or nil when there is
+// none, including when the SynapseRolloutHistory resource is not installed.
+func LatestRollout(ctx context.Context, c client.Reader, namespace string) (*RolloutRecord, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(RolloutHistoryGVK)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: RolloutHistoryResourceName}, obj); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	records, err := readRolloutRecords(obj)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return &records[len(records)-1], nil
+}
+
+func writeRolloutRecords(obj *unstructured.Unstructured, records []RolloutRecord) error {
+	encoded, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	var raw []interface{}
+	if err := json.Unmarshal(encoded, &raw); err != nil {
+		return err
+	}
+	return unstructured.SetNestedSlice(obj.Object, raw, "spec", "rollouts")
+}
+
+// recordRolloutResource adds the rollout of hash to the updated workloads to the SynapseRolloutHistory of
+// namespace. Workloads restarted for the same hash over several reconciles, such as during a gradual
+// rollout, are added to the latest record instead of starting a new one.
+func (r *ConfigMapReconciler) recordRolloutResource(ctx context.Context, namespace, hash string, updated []string, now time.Time) error {
+	configMaps, secrets, err := r.listConfigSources(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(RolloutHistoryGVK)
+		key := client.ObjectKey{Namespace: namespace, Name: RolloutHistoryResourceName}
+		exists := true
+		if err := r.reader().Get(ctx, key, obj); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+			exists = false
+			obj.SetGroupVersionKind(RolloutHistoryGVK)
+			obj.SetNamespace(namespace)
+			obj.SetName(RolloutHistoryResourceName)
+			obj.SetLabels(map[string]string{"app.kubernetes.io/managed-by": "synapse-operator"})
+		}
+		records, err := readRolloutRecords(obj)
+		if err != nil {
+			return err
+		}
+
+		if n := len(records); n > 0 && records[n-1].Hash == hash {
+			last := &records[n-1]
+			last.Workloads = mergeSorted(last.Workloads, updated)
+		} else {
+			record := RolloutRecord{Hash: hash, Time: now.UTC(), Workloads: mergeSorted(nil, updated), ConfigMaps: map[string]ConfigMapSnapshot{}}
+			if n > 0 {
+				record.Revision = records[n-1].Revision
+			}
+			record.Revision++
+			for i := range configMaps {
+				cm := &configMaps[i]
+				record.Sources = append(record.Sources, RolloutSource{Kind: "ConfigMap", Name: cm.Name, ResourceVersion: cm.ResourceVersion})
+				record.ConfigMaps[cm.Name] = ConfigMapSnapshot{Labels: cm.Labels, Data: cm.Data, BinaryData: cm.BinaryData}
+			}
+			for i := range secrets {
+				secret := &secrets[i]
+				record.Sources = append(record.Sources, RolloutSource{Kind: "Secret", Name: secret.Name, ResourceVersion: secret.ResourceVersion})
+			}
+			records = append(records, record)
+			if retention := r.RolloutHistoryRetention; retention > 0 && len(records) > retention {
+				records = records[len(records)-retention:]
+			}
+		}
+		if err := writeRolloutRecords(obj, records); err != nil {
+			return err
+		}
+		if !exists {
+			return r.Create(ctx, obj)
+		}
+		return r.Update(ctx, obj)
+	})
+}
+
+// mergeSorted returns the sorted union of existing and added.
+func mergeSorted(existing, added []string) []string {
+	seen := make(map[string]struct{}, len(existing)+len(added))
+	merged := make([]string, 0, len(existing)+len(added))
+	for _, item := range append(append([]string(nil), existing...), added...) {
+		if _, ok := seen[item]; ok {
+			continue
+		}
+		seen[item] = struct{}{}
+		merged = append(merged, item)
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// RolloutHistoryReconciler applies rollbacks requested with RollbackToAnnotation by restoring the ConfigMap
+// content snapshotted at that revision. The config controller then rolls the restored content out like any
+// other change, which yields the recorded hash as long as the Secret sources are unchanged.
+type RolloutHistoryReconciler struct {
+	client.Client
+	// APIReader reads config sources and the history directly, bypassing the cache.
+	APIReader client.Reader
+	Recorder  record.EventRecorder
+}
+
+func (r *RolloutHistoryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(RolloutHistoryGVK)
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	value, ok := obj.GetAnnotations()[RollbackToAnnotation]
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+
+	records, err := readRolloutRecords(obj)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	var target *RolloutRecord
+	if revision, err := strconv.ParseInt(value, 10, 64); err == nil {
+		for i := range records {
+			if records[i].Revision == revision {
+				target = &records[i]
+			}
+		}
+	}
+	if target == nil {
+		r.event(obj, corev1.EventTypeWarning, "RollbackFailed", fmt.Sprintf("No rollout with revision %q in the history", value))
+		return ctrl.Result{}, r.clearRollback(ctx, obj)
+	}
+
+	names := make([]string, 0, len(target.ConfigMaps))
+	for name := range target.ConfigMaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := r.restoreConfigMap(ctx, req.Namespace, name, target.ConfigMaps[name]); err != nil {
+			return ctrl.Result{}, fmt.Errorf("restoring ConfigMap %s/%s: %w", req.Namespace, name, err)
+		}
+	}
+
+	message := fmt.Sprintf("Restored %d ConfigMaps to revision %d (config hash %s)", len(names), target.Revision, target.Hash)
+	if changed, err := r.changedSecrets(ctx, req.Namespace, target); err != nil {
+		return ctrl.Result{}, err
+	} else if len(changed) > 0 {
+		message += fmt.Sprintf("; Secrets %v changed since and are not restored, so the rolled out hash will differ", changed)
+	}
+	logger.Info("Rolled back config sources", "revision", target.Revision, "configHash", target.Hash)
+	r.event(obj, corev1.EventTypeNormal, "RolledBack", message)
+	return ctrl.Result{}, r.clearRollback(ctx, obj)
+}
+
+func (r *RolloutHistoryReconciler) restoreConfigMap(ctx context.Context, namespace, name string, snapshot ConfigMapSnapshot) error {
+	cm := &corev1.ConfigMap{}
+	if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, cm); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: snapshot.Labels},
+			Data:       snapshot.Data,
+			BinaryData: snapshot.BinaryData,
+		}
+		return r.Create(ctx, cm)
+	}
+	cm.Data = snapshot.Data
+	cm.BinaryData = snapshot.BinaryData
+	return r.Update(ctx, cm)
+}
+
+// changedSecrets lists the Secret sources of target whose resourceVersion has changed since.
+func (r *RolloutHistoryReconciler) changedSecrets(ctx context.Context, namespace string, target *RolloutRecord) ([]string, error) {
+	var changed []string
+	for _, source := range target.Sources {
+		if source.Kind != "Secret" {
+			continue
+		}
+		secret := &corev1.Secret{}
+		if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: source.Name}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				changed = append(changed, source.Name)
+				continue
+			}
+			return nil, err
+		}
+		if secret.ResourceVersion != source.ResourceVersion {
+			changed = append(changed, source.Name)
+		}
+	}
+	return changed, nil
+}
+
+func (r *RolloutHistoryReconciler) clearRollback(ctx context.Context, obj *unstructured.Unstructured) error {
+	patch := client.MergeFrom(obj.DeepCopy())
+	annotations := obj.GetAnnotations()
+	delete(annotations, RollbackToAnnotation)
+	obj.SetAnnotations(annotations)
+	return r.Patch(ctx, obj, patch)
+}
+
+func (r *RolloutHistoryReconciler) event(obj *unstructured.Unstructured, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(obj, eventType, reason, message)
+}
+
+// SetupWithManager watches SynapseRolloutHistory resources for rollback requests.
+func (r *RolloutHistoryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(RolloutHistoryGVK)
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("rollouthistory").
+		For(obj, builder.WithPredicates(predicate.AnnotationChangedPredicate{})).
+		Complete(r)
+}
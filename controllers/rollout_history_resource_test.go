@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func getRolloutHistory(t *testing.T, c client.Client) *unstructured.Unstructured {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(RolloutHistoryGVK)
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "matrix", Name: RolloutHistoryResourceName}, obj))
+	return obj
+}
+
+func TestRolloutHistoryResourceRecordsAndRollsBack(t *testing.T) {
+	ctx := context.Background()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "v1")
+	r := newTestReconciler(t, cm, newTestDeployment(nil))
+	r.RolloutHistoryRetention = 2
+
+	for _, content := range []string{"v1", "v2", "v3"} {
+		current := &corev1.ConfigMap{}
+		require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cm), current))
+		current.Data["data"] = content
+		require.NoError(t, r.Update(ctx, current))
+		_, err := r.rolloutWorkloads(ctx, "matrix", "hash-"+content, logr.Discard())
+		require.NoError(t, err)
+	}
+
+	history := getRolloutHistory(t, r.Client)
+	records, err := readRolloutRecords(history)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, int64(2), records[0].Revision)
+	assert.Equal(t, "hash-v2", records[0].Hash)
+	assert.Equal(t, []string{"deployment/synapse"}, records[0].Workloads)
+	assert.Equal(t, "v2", records[0].ConfigMaps["homeserver"].Data["data"])
+
+	history.SetAnnotations(map[string]string{RollbackToAnnotation: "2"})
+	require.NoError(t, r.Update(ctx, history))
+	rollback := &RolloutHistoryReconciler{Client: r.Client, APIReader: r.Client}
+	_, err = rollback.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(history)})
+	require.NoError(t, err)
+
+	restored := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cm), restored))
+	assert.Equal(t, "v2", restored.Data["data"])
+	assert.NotContains(t, getRolloutHistory(t, r.Client).GetAnnotations(), RollbackToAnnotation)
+}
+
+func TestRecordRolloutResourceMergesSameHash(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t)
+	r.RolloutHistoryRetention = 5
+	now := time.Unix(1000, 0)
+
+	require.NoError(t, r.recordRolloutResource(ctx, "matrix", "one", []string{"deployment/worker"}, now))
+	require.NoError(t, r.recordRolloutResource(ctx, "matrix", "one", []string{"deployment/synapse"}, now.Add(time.Minute)))
+
+	records, err := readRolloutRecords(getRolloutHistory(t, r.Client))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, []string{"deployment/synapse", "deployment/worker"}, records[0].Workloads)
+}
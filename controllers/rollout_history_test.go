@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestAppendRolloutHistoryKeepsNewest(t *testing.T) {
+	value := ""
+	for i := 0; i < 5; i++ {
+		var err error
+		value, err = appendRolloutHistory(value, RolloutHistoryEntry{Hash: fmt.Sprint(i), Time: time.Unix(int64(i), 0).UTC()}, 3)
+		require.NoError(t, err)
+	}
+	entries := ParseRolloutHistory(value)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "2", entries[0].Hash)
+	assert.Equal(t, "4", entries[2].Hash)
+
+	long := strings.Repeat("a", 400)
+	value = ""
+	for i := 0; i < 5; i++ {
+		var err error
+		value, err = appendRolloutHistory(value, RolloutHistoryEntry{Hash: long + fmt.Sprint(i)}, 10)
+		require.NoError(t, err)
+	}
+	assert.LessOrEqual(t, len(value), rolloutHistoryMaxBytes)
+	entries = ParseRolloutHistory(value)
+	assert.Equal(t, long+"4", entries[len(entries)-1].Hash)
+
+	assert.Nil(t, ParseRolloutHistory("not json"))
+}
+
+func TestRolloutWorkloadsRecordsHistory(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil))
+	r.RolloutHistorySize = 2
+
+	for _, hash := range []string{"one", "two", "two", "three"} {
+		_, err := r.rolloutWorkloads(ctx, "matrix", hash, logr.Discard())
+		require.NoError(t, err)
+	}
+
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	entries := ParseRolloutHistory(deploy.Annotations[RolloutHistoryAnnotation])
+	require.Len(t, entries, 2)
+	assert.Equal(t, "two", entries[0].Hash)
+	assert.Equal(t, "three", entries[1].Hash)
+}
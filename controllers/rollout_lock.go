@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RolloutLockName is the Lease in a namespace that external deploy tools hold to make the operator hold
+// rollouts there. The lock expires leaseDurationSeconds after its last renewal.
+const RolloutLockName = "synapse-rollout-lock"
+
+// rolloutLockPollInterval bounds how long a rollout held by the lock waits before checking it again, so an
+// early release is noticed without watching every Lease in the cluster.
+const rolloutLockPollInterval = 30 * time.Second
+
+// RolloutLockHolder returns the holder of the rollout lock lease and how long until it expires, or "" when
+// it is free. A lease without a holder or duration is free.
+func RolloutLockHolder(lease *coordinationv1.Lease, now time.Time) (string, time.Duration) {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" || spec.LeaseDurationSeconds == nil {
+		return "", 0
+	}
+	renewed := spec.RenewTime
+	if renewed == nil {
+		renewed = spec.AcquireTime
+	}
+	if renewed == nil {
+		return "", 0
+	}
+	remaining := renewed.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second).Sub(now)
+	if remaining <= 0 {
+		return "", 0
+	}
+	return *spec.HolderIdentity, remaining
+}
+
+// rolloutLocked returns the holder of the rollout lock of namespace and how long to wait before checking it
+// again, or "" when rollouts may proceed. It also keeps the lock metrics up to date.
+func (r *ConfigMapReconciler) rolloutLocked(ctx context.Context, namespace string, now time.Time) (string, time.Duration, error) {
+	lease := &coordinationv1.Lease{}
+	if err := r.reader().Get(ctx, client.ObjectKey{Namespace: namespace, Name: RolloutLockName}, lease); err != nil {
+		if apierrors.IsNotFound(err) {
+			rolloutLockHeldGauge.DeleteLabelValues(r.ClusterName, namespace)
+			return "", 0, nil
+		}
+		return "", 0, err
+	}
+	holder, remaining := RolloutLockHolder(lease, now)
+	if holder == "" {
+		rolloutLockHeldGauge.DeleteLabelValues(r.ClusterName, namespace)
+		return "", 0, nil
+	}
+	rolloutLockHeldGauge.WithLabelValues(r.ClusterName, namespace).Set(1)
+	rolloutLockContentionTotal.WithLabelValues(r.ClusterName, namespace).Inc()
+	return holder, min(remaining, rolloutLockPollInterval), nil
+}
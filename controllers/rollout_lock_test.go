@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func newTestRolloutLock(holder string, renewed time.Time, duration int32) *coordinationv1.Lease {
+	renewTime := metav1.NewMicroTime(renewed)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "matrix", Name: RolloutLockName},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To(holder),
+			RenewTime:            &renewTime,
+			LeaseDurationSeconds: ptr.To(duration),
+		},
+	}
+}
+
+func TestRolloutLockHolder(t *testing.T) {
+	now := time.Unix(1000, 0)
+	holder, remaining := RolloutLockHolder(newTestRolloutLock("pipeline", now.Add(-time.Minute), 120), now)
+	assert.Equal(t, "pipeline", holder)
+	assert.Equal(t, time.Minute, remaining)
+
+	holder, _ = RolloutLockHolder(newTestRolloutLock("pipeline", now.Add(-3*time.Minute), 120), now)
+	assert.Empty(t, holder, "expired")
+	holder, _ = RolloutLockHolder(newTestRolloutLock("", now, 120), now)
+	assert.Empty(t, holder, "no holder")
+	lease := newTestRolloutLock("pipeline", now, 120)
+	lease.Spec.LeaseDurationSeconds = nil
+	holder, _ = RolloutLockHolder(lease, now)
+	assert.Empty(t, holder, "never expires")
+}
+
+func TestReconcileHoldsRolloutWhileLocked(t *testing.T) {
+	ctx := context.Background()
+	lock := newTestRolloutLock("pipeline", time.Now(), 600)
+	r := newTestReconciler(t, lock, newTestDeployment(nil), newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	r.RolloutLock = true
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, rolloutLockPollInterval, result.RequeueAfter)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.NotContains(t, deploy.Spec.Template.Annotations, testHashAnnotation)
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutLockHeldGauge.WithLabelValues("", "matrix")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rolloutLockContentionTotal.WithLabelValues("", "matrix")))
+
+	require.NoError(t, r.Delete(ctx, lock))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.Contains(t, deploy.Spec.Template.Annotations, testHashAnnotation)
+	assert.Equal(t, 0, testutil.CollectAndCount(rolloutLockHeldGauge))
+}
@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"synapse-operator/audit"
+)
+
+// RolloutTierLabel on a Namespace names its tier among RolloutTiers, such as dev, staging or prod.
+const RolloutTierLabel = "synapse.gen0sec.com/rollout-tier"
+
+// blockedReasonTier marks a restart held until the namespaces of earlier tiers have rolled out and soaked.
+const blockedReasonTier = "RolloutTierPending"
+
+// rolloutTierPollInterval is how often a rollout waiting on an earlier tier checks on it.
+const rolloutTierPollInterval = 15 * time.Second
+
+// tierStatePrefix keys the tierRollout of each namespace.
+const tierStatePrefix = "tiers/"
+
+// tierRollout is when a namespace was first seen running Hash on available workloads, which its soak time
+// counts from.
+type tierRollout struct {
+	Hash  string    `json:"hash"`
+	Since time.Time `json:"since"`
+}
+
+// rolloutTier returns the position of the tier of ns in RolloutTiers, or -1 when it has none of them.
+func (r *ConfigMapReconciler) rolloutTier(ns *corev1.Namespace) int {
+	tier, ok := ns.Labels[RolloutTierLabel]
+	if !ok {
+		return -1
+	}
+	return slices.Index(r.RolloutTiers, tier)
+}
+
+// rolloutTierGate holds the rollout of a namespace in a tier until every namespace of the earlier tiers runs
+// its own current hash on available workloads and has done so for RolloutTierSoak, so a change to a shared
+// source reaches dev, then staging, then prod. A failed rollout in an earlier tier, one that stalled, halts
+// the later tiers until it recovers. Held workloads are reported as blocked.
+func (r *ConfigMapReconciler) rolloutTierGate(ctx context.Context, pass *rolloutPass) error {
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pass.Namespace}, ns); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	tier := r.rolloutTier(ns)
+	if tier <= 0 {
+		r.clearNamespaceBlocked(pass.Namespace, blockedReasonTier)
+		return nil
+	}
+	behind, err := r.workloadsBehind(ctx, pass.Namespace, pass.Hash)
+	if err != nil {
+		return err
+	}
+	var waitingFor string
+	var wait time.Duration
+	if len(behind) > 0 {
+		if waitingFor, wait, err = r.pendingEarlierTier(ctx, tier, time.Now()); err != nil {
+			return err
+		}
+	}
+	if waitingFor == "" {
+		r.clearNamespaceBlocked(pass.Namespace, blockedReasonTier)
+		return nil
+	}
+
+	pass.Logger.Info("Holding rollout until the earlier tiers have rolled out", "tier", r.RolloutTiers[tier], "waitingFor", waitingFor, "configHash", pass.Hash)
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "rollout-tier", Detail: "waiting for " + waitingFor})
+	for _, w := range behind {
+		r.reportBlocked(w, pass.Hash, blockedReasonTier, fmt.Sprintf("Config hash %s is pending in tier %s, waiting for %s", pass.Hash, r.RolloutTiers[tier], waitingFor))
+	}
+	pass.RequeueAfter(min(max(wait, time.Second), rolloutTierPollInterval))
+	pass.Halt("waiting for " + waitingFor)
+	return nil
+}
+
+// pendingEarlierTier describes what a rollout in the tier at position tier is waiting for among the
+// namespaces of the earlier tiers, and how long a soak has left, or returns "" when it is waiting for nothing.
+func (r *ConfigMapReconciler) pendingEarlierTier(ctx context.Context, tier int, now time.Time) (string, time.Duration, error) {
+	earlier, err := labels.NewRequirement(RolloutTierLabel, selection.In, r.RolloutTiers[:tier])
+	if err != nil {
+		return "", 0, err
+	}
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaces, client.MatchingLabelsSelector{Selector: labels.NewSelector().Add(*earlier)}); err != nil {
+		return "", 0, err
+	}
+	slices.SortFunc(namespaces.Items, func(a, b corev1.Namespace) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for i := range namespaces.Items {
+		name := namespaces.Items[i].Name
+		if r.namespaceExcluded(name) {
+			continue
+		}
+		if r.stalledInNamespace(name) {
+			return "namespace " + name + " to recover from a failed rollout", rolloutTierPollInterval, nil
+		}
+		status, err := r.namespaceWorkloadStatus(ctx, name)
+		if err != nil {
+			return "", 0, err
+		}
+		// A namespace without config sources has nothing to roll out.
+		if status.Hash == "" {
+			continue
+		}
+		if !status.rolledOut(status.Hash) {
+			return "namespace " + name + " to roll out", rolloutTierPollInterval, nil
+		}
+		since, err := r.tierRolledOutSince(ctx, name, status.Hash, now)
+		if err != nil {
+			return "", 0, err
+		}
+		if remaining := since.Add(r.RolloutTierSoak).Sub(now); remaining > 0 {
+			return fmt.Sprintf("namespace %s to soak for %s", name, remaining.Round(time.Second)), remaining, nil
+		}
+	}
+	return "", 0, nil
+}
+
+// stalledInNamespace reports whether a workload of namespace restarted by the operator stalled.
+func (r *ConfigMapReconciler) stalledInNamespace(namespace string) bool {
+	stalled := false
+	r.progress.Range(func(key, value any) bool {
+		stalled = strings.HasPrefix(key.(string), namespace+"/") && value.(*rolloutProgress).stalled
+		return !stalled
+	})
+	return stalled
+}
+
+// tierRolledOutSince returns since when namespace has run hash on available workloads, recording now when
+// its tierRollout is of another hash.
+func (r *ConfigMapReconciler) tierRolledOutSince(ctx context.Context, namespace, hash string, now time.Time) (time.Time, error) {
+	key := tierStatePrefix + namespace
+	if r.StateStore == nil {
+		value, _ := r.tierRollouts.LoadOrStore(key, tierRollout{Hash: hash, Since: now})
+		if record := value.(tierRollout); record.Hash == hash {
+			return record.Since, nil
+		}
+		r.tierRollouts.Store(key, tierRollout{Hash: hash, Since: now})
+		return now, nil
+	}
+	raw, found, err := r.StateStore.Get(ctx, key)
+	if err != nil {
+		return now, err
+	}
+	if found {
+		var record tierRollout
+		if err := json.Unmarshal(raw, &record); err != nil {
+			log.FromContext(ctx).Error(err, "discarding unreadable tier rollout record", "namespace", namespace)
+		} else if record.Hash == hash {
+			return record.Since, nil
+		}
+	}
+	raw, err = json.Marshal(tierRollout{Hash: hash, Since: now})
+	if err != nil {
+		return now, err
+	}
+	return now, r.StateStore.Put(ctx, key, raw)
+}
+
+// observeTierRollout starts the soak time of the namespace of pass once its hash is rolled out: nothing is
+// held back or deferred and every restarted workload is available again.
+func (r *ConfigMapReconciler) observeTierRollout(ctx context.Context, pass *rolloutPass) error {
+	if len(r.RolloutTiers) == 0 || pass.Hash == "" || pass.State.held || len(pass.State.applied) < len(pass.State.planned) || r.rollingInNamespace(pass.Namespace) {
+		return nil
+	}
+	_, err := r.tierRolledOutSince(ctx, pass.Namespace, pass.Hash, time.Now())
+	return err
+}
+
+// tierHold describes what keeps namespace from rolling out in its tier, or returns "".
+func (r *ConfigMapReconciler) tierHold(ctx context.Context, ns *corev1.Namespace) (string, error) {
+	tier := r.rolloutTier(ns)
+	if tier <= 0 {
+		return "", nil
+	}
+	waitingFor, _, err := r.pendingEarlierTier(ctx, tier, time.Now())
+	if err != nil || waitingFor == "" {
+		return "", err
+	}
+	return "rollout-tier: waiting for " + waitingFor, nil
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/state"
+)
+
+func newTieredTestReconciler(t *testing.T) *ConfigMapReconciler {
+	t.Helper()
+	labels := map[string]string{"app.kubernetes.io/name": "synapse"}
+	var objs []client.Object
+	for namespace, tier := range map[string]string{"matrix-dev": "dev", "matrix": "prod"} {
+		cm := newTestConfigMap("homeserver", labels, nil, "a")
+		cm.Namespace = namespace
+		deploy := newTestDeployment(nil)
+		deploy.Namespace = namespace
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{RolloutTierLabel: tier}}}
+		objs = append(objs, ns, cm, deploy)
+	}
+	r := newTestReconciler(t, objs...)
+	r.RolloutTiers = []string{"dev", "prod"}
+	r.RolloutTierSoak = time.Hour
+	return r
+}
+
+func reconcileHomeserver(t *testing.T, r *ConfigMapReconciler, namespace string) {
+	t.Helper()
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "homeserver"}})
+	require.NoError(t, err)
+}
+
+func appliedHomeserverHash(t *testing.T, r *ConfigMapReconciler, namespace string) string {
+	t.Helper()
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: "synapse"}, deploy))
+	return deploy.Spec.Template.Annotations[testHashAnnotation]
+}
+
+func TestRolloutTiers(t *testing.T) {
+	ctx := context.Background()
+	r := newTieredTestReconciler(t)
+	r.StateStore = state.NewMemoryStore()
+
+	reconcileHomeserver(t, r, "matrix")
+	assert.Empty(t, appliedHomeserverHash(t, r, "matrix"), "prod waits for dev to roll out")
+	status, err := r.namespaceStatus(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Contains(t, status.Holds, "rollout-tier: waiting for namespace matrix-dev to roll out")
+
+	reconcileHomeserver(t, r, "matrix-dev")
+	assert.NotEmpty(t, appliedHomeserverHash(t, r, "matrix-dev"))
+	reconcileHomeserver(t, r, "matrix")
+	assert.Empty(t, appliedHomeserverHash(t, r, "matrix"), "prod waits for dev to soak")
+	status, err = r.namespaceStatus(ctx, "matrix")
+	require.NoError(t, err)
+	require.Len(t, status.Holds, 1)
+	assert.Contains(t, status.Holds[0], "rollout-tier: waiting for namespace matrix-dev to soak for")
+
+	_, found, err := r.StateStore.Get(ctx, tierStatePrefix+"matrix-dev")
+	require.NoError(t, err)
+	assert.True(t, found, "the end of the rollout of dev is recorded")
+
+	r.RolloutTierSoak = 0
+	reconcileHomeserver(t, r, "matrix")
+	assert.Equal(t, appliedHomeserverHash(t, r, "matrix-dev"), appliedHomeserverHash(t, r, "matrix"), "prod rolls out once dev has soaked")
+}
+
+func TestRolloutTiersHaltOnStalledRollout(t *testing.T) {
+	ctx := context.Background()
+	r := newTieredTestReconciler(t)
+	r.RolloutTierSoak = 0
+	reconcileHomeserver(t, r, "matrix-dev")
+	r.progress.Store("matrix-dev/Deployment/synapse", &rolloutProgress{hash: appliedHomeserverHash(t, r, "matrix-dev"), stalled: true})
+
+	reconcileHomeserver(t, r, "matrix")
+	assert.Empty(t, appliedHomeserverHash(t, r, "matrix"), "prod halts while dev is stalled")
+	status, err := r.namespaceStatus(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Contains(t, status.Holds, "rollout-tier: waiting for namespace matrix-dev to recover from a failed rollout")
+
+	r.progress.Delete("matrix-dev/Deployment/synapse")
+	reconcileHomeserver(t, r, "matrix")
+	assert.NotEmpty(t, appliedHomeserverHash(t, r, "matrix"))
+}
+
+func TestRolloutTiersIgnoreDeletedStalledWorkload(t *testing.T) {
+	r := newTieredTestReconciler(t)
+	r.RolloutTierSoak = 0
+	reconcileHomeserver(t, r, "matrix-dev")
+	r.progress.Store("matrix-dev/deployment/synapse", &rolloutProgress{hash: appliedHomeserverHash(t, r, "matrix-dev"), stalled: true})
+	require.True(t, r.stalledInNamespace("matrix-dev"))
+
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "matrix-dev", Name: "synapse"}, deploy))
+	r.workloadDeleted(r.workloadKinds()[0])(deploy)
+	assert.False(t, r.stalledInNamespace("matrix-dev"), "a deleted workload no longer counts as stalled")
+	reconcileHomeserver(t, r, "matrix")
+	assert.NotEmpty(t, appliedHomeserverHash(t, r, "matrix"), "prod is not halted by the deleted workload")
+}
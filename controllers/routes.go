@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+// RoutesAnnotation on a workload lists the Ingresses and HTTPRoutes of its namespace that route to the
+// listeners of its Synapse config, as comma-separated <kind>/<name>=<resource> entries, where <resource> is a
+// Synapse listener resource such as client or federation: "Ingress/matrix=client,HTTPRoute/federation=federation".
+// With SyncRoutes, restarting the workload for a config change first moves those routes to the listener now
+// serving the resource, so routing and config change in the same rollout.
+const RoutesAnnotation = "synapse.gen0sec.com/routes"
+
+// ingressBackendProtocolAnnotation selects HTTP or HTTPS to the backend in ingress-nginx. It follows the tls
+// setting of the listener on Ingresses that already carry it.
+const ingressBackendProtocolAnnotation = "nginx.ingress.kubernetes.io/backend-protocol"
+
+var httpRouteGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+
+// routeRef is an entry of RoutesAnnotation.
+type routeRef struct {
+	kind     string
+	name     string
+	resource string
+}
+
+func (ref routeRef) String() string {
+	return ref.kind + "/" + ref.name
+}
+
+// parseRoutes parses the value of RoutesAnnotation.
+func parseRoutes(value string) ([]routeRef, error) {
+	var refs []routeRef
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, resource, ok := strings.Cut(entry, "=")
+		kind, name, hasName := strings.Cut(target, "/")
+		if !ok || !hasName || name == "" || resource == "" {
+			return nil, fmt.Errorf("invalid route %q: expected <kind>/<name>=<resource>", entry)
+		}
+		switch {
+		case strings.EqualFold(kind, "Ingress"):
+			kind = "Ingress"
+		case strings.EqualFold(kind, httpRouteGVK.Kind):
+			kind = httpRouteGVK.Kind
+		default:
+			return nil, fmt.Errorf("invalid route %q: kind must be Ingress or HTTPRoute", entry)
+		}
+		refs = append(refs, routeRef{kind: kind, name: name, resource: resource})
+	}
+	return refs, nil
+}
+
+// synapseListener is a listener of the Synapse config.
+type synapseListener struct {
+	Port      int32 `json:"port"`
+	TLS       bool  `json:"tls"`
+	Resources []struct {
+		Names []string `json:"names"`
+	} `json:"resources"`
+}
+
+// serves reports whether l serves the listener resource name.
+func (l synapseListener) serves(name string) bool {
+	for _, resource := range l.Resources {
+		for _, n := range resource.Names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// synapseListeners returns the listeners of the Synapse config of w: those of the first key, in the order of
+// the ConfigMaps and then the Secrets w mounts or reads env from, that parses as YAML with listeners.
+func (r *ConfigMapReconciler) synapseListeners(ctx context.Context, w *workload) ([]synapseListener, error) {
+	configMaps, secrets := map[string]struct{}{}, map[string]struct{}{}
+	collectPodSpecSources(&w.template.Spec, configMaps, secrets)
+	var documents [][]byte
+	for _, name := range sortedKeys(configMaps) {
+		cm := &corev1.ConfigMap{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: w.obj.GetNamespace(), Name: name}, cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, key := range slices.Sorted(maps.Keys(cm.Data)) {
+			documents = append(documents, []byte(cm.Data[key]))
+		}
+	}
+	for _, name := range sortedKeys(secrets) {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: w.obj.GetNamespace(), Name: name}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, key := range slices.Sorted(maps.Keys(secret.Data)) {
+			documents = append(documents, secret.Data[key])
+		}
+	}
+	for _, document := range documents {
+		var config struct {
+			Listeners []synapseListener `json:"listeners"`
+		}
+		if err := yaml.Unmarshal(document, &config); err == nil && len(config.Listeners) > 0 {
+			return config.Listeners, nil
+		}
+	}
+	return nil, nil
+}
+
+// syncRoutes moves the routes of RoutesAnnotation on w to the listeners of its current config. Entries that
+// cannot be resolved are reported as RoutesNotUpdated events and skipped; a failed write is returned and holds
+// the restart of w back.
+func (r *ConfigMapReconciler) syncRoutes(ctx context.Context, w *workload) error {
+	value := w.obj.GetAnnotations()[RoutesAnnotation]
+	if value == "" {
+		return nil
+	}
+	refs, err := parseRoutes(value)
+	if err != nil {
+		r.traceEvent(ctx, w.obj, corev1.EventTypeWarning, "RoutesNotUpdated", err.Error())
+		return nil
+	}
+	listeners, err := r.synapseListeners(ctx, w)
+	if err != nil {
+		return err
+	}
+	services, err := r.workloadServices(ctx, w)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		var listener *synapseListener
+		for i := range listeners {
+			if listeners[i].serves(ref.resource) {
+				listener = &listeners[i]
+				break
+			}
+		}
+		if listener == nil {
+			r.traceEvent(ctx, w.obj, corev1.EventTypeWarning, "RoutesNotUpdated", fmt.Sprintf("No Synapse listener serves %s for %s", ref.resource, ref))
+			continue
+		}
+		updated, err := r.syncRoute(ctx, w.obj.GetNamespace(), ref, services, *listener)
+		if apierrors.IsNotFound(err) {
+			r.traceEvent(ctx, w.obj, corev1.EventTypeWarning, "RoutesNotUpdated", fmt.Sprintf("%s not found", ref))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("updating %s: %w", ref, err)
+		}
+		if updated {
+			log.FromContext(ctx).Info("Moved route to Synapse listener", "route", ref.String(), "port", listener.Port, "tls", listener.TLS)
+			r.traceEvent(ctx, w.obj, corev1.EventTypeNormal, "RouteUpdated", fmt.Sprintf("Moved %s to the %s listener on port %d", ref, ref.resource, listener.Port))
+		}
+	}
+	return nil
+}
+
+// workloadServices returns the names of the Services of the namespace of w that select its pods.
+func (r *ConfigMapReconciler) workloadServices(ctx context.Context, w *workload) (map[string]struct{}, error) {
+	list := &corev1.ServiceList{}
+	if err := r.reader().List(ctx, list, client.InNamespace(w.obj.GetNamespace())); err != nil {
+		return nil, err
+	}
+	services := map[string]struct{}{}
+	for _, service := range list.Items {
+		if len(service.Spec.Selector) > 0 && labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(w.template.Labels)) {
+			services[service.Name] = struct{}{}
+		}
+	}
+	return services, nil
+}
+
+// syncRoute points the backends of the route ref at services, numbered by port, to the port of listener,
+// reporting whether it changed the route. Backends naming their port follow the Service and are left alone.
+func (r *ConfigMapReconciler) syncRoute(ctx context.Context, namespace string, ref routeRef, services map[string]struct{}, listener synapseListener) (bool, error) {
+	key := client.ObjectKey{Namespace: namespace, Name: ref.name}
+	if ref.kind == httpRouteGVK.Kind {
+		route := &unstructured.Unstructured{}
+		route.SetGroupVersionKind(httpRouteGVK)
+		if err := r.reader().Get(ctx, key, route); err != nil {
+			return false, err
+		}
+		original := route.DeepCopy()
+		if !syncHTTPRoute(route, services, listener) {
+			return false, nil
+		}
+		return true, r.Patch(ctx, route, client.MergeFrom(original))
+	}
+	ingress := &networkingv1.Ingress{}
+	if err := r.reader().Get(ctx, key, ingress); err != nil {
+		return false, err
+	}
+	original := ingress.DeepCopy()
+	if !syncIngress(ingress, services, listener) {
+		return false, nil
+	}
+	return true, r.Patch(ctx, ingress, client.MergeFrom(original))
+}
+
+func syncIngress(ingress *networkingv1.Ingress, services map[string]struct{}, listener synapseListener) bool {
+	changed := false
+	sync := func(backend *networkingv1.IngressBackend) {
+		if backend == nil || backend.Service == nil {
+			return
+		}
+		if _, ok := services[backend.Service.Name]; !ok || backend.Service.Port.Name != "" || backend.Service.Port.Number == listener.Port {
+			return
+		}
+		backend.Service.Port.Number = listener.Port
+		changed = true
+	}
+	sync(ingress.Spec.DefaultBackend)
+	for i := range ingress.Spec.Rules {
+		if http := ingress.Spec.Rules[i].HTTP; http != nil {
+			for j := range http.Paths {
+				sync(&http.Paths[j].Backend)
+			}
+		}
+	}
+	if protocol, ok := ingress.Annotations[ingressBackendProtocolAnnotation]; ok {
+		want := "HTTP"
+		if listener.TLS {
+			want = "HTTPS"
+		}
+		if protocol != want {
+			ingress.Annotations[ingressBackendProtocolAnnotation] = want
+			changed = true
+		}
+	}
+	return changed
+}
+
+func syncHTTPRoute(route *unstructured.Unstructured, services map[string]struct{}, listener synapseListener) bool {
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	changed := false
+	for _, rule := range rules {
+		rule, ok := rule.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		backends, _, _ := unstructured.NestedSlice(rule, "backendRefs")
+		for i, backend := range backends {
+			backend, ok := backend.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			group, _, _ := unstructured.NestedString(backend, "group")
+			kind, _, _ := unstructured.NestedString(backend, "kind")
+			name, _, _ := unstructured.NestedString(backend, "name")
+			port, _, _ := unstructured.NestedInt64(backend, "port")
+			if _, ok := services[name]; !ok || group != "" || (kind != "" && kind != "Service") || port == int64(listener.Port) {
+				continue
+			}
+			backend["port"] = int64(listener.Port)
+			backends[i] = backend
+			changed = true
+		}
+		if changed {
+			rule["backendRefs"] = backends
+		}
+	}
+	if changed {
+		_ = unstructured.SetNestedSlice(route.Object, rules, "spec", "rules")
+	}
+	return changed
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+const testHomeserverConfig = `
+server_name: example.com
+listeners:
+  - port: 8008
+    tls: false
+    type: http
+    resources:
+      - names: [client]
+  - port: 8448
+    tls: true
+    type: http
+    resources:
+      - names: [federation]
+`
+
+func TestParseRoutes(t *testing.T) {
+	refs, err := parseRoutes("ingress/matrix=client, HTTPRoute/federation=federation")
+	require.NoError(t, err)
+	assert.Equal(t, []routeRef{{kind: "Ingress", name: "matrix", resource: "client"}, {kind: "HTTPRoute", name: "federation", resource: "federation"}}, refs)
+
+	for _, value := range []string{"Ingress/matrix", "matrix=client", "Service/matrix=client", "Ingress/=client"} {
+		_, err := parseRoutes(value)
+		assert.Error(t, err, value)
+	}
+}
+
+// newRoutesTestObjects returns a Deployment routed by an Ingress and an HTTPRoute through its Service, with
+// the Synapse config it mounts.
+func newRoutesTestObjects() []client.Object {
+	deploy := newTestDeployment(map[string]string{RoutesAnnotation: "Ingress/matrix=client,HTTPRoute/federation=federation"})
+	deploy.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name:         "config",
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "homeserver"}}},
+	}}
+	config := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "homeserver", Namespace: "matrix", Labels: map[string]string{"app.kubernetes.io/name": "synapse"}},
+		Data:       map[string]string{"homeserver.yaml": testHomeserverConfig},
+	}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "synapse", Namespace: "matrix"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "synapse"}},
+	}
+	backend := func(name string, port int32) networkingv1.IngressBackend {
+		return networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: name, Port: networkingv1.ServiceBackendPort{Number: port}}}
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "matrix", Namespace: "matrix", Annotations: map[string]string{ingressBackendProtocolAnnotation: "HTTPS"}},
+		Spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{
+				{Path: "/_matrix", Backend: backend("synapse", 8000)},
+				{Path: "/", Backend: backend("element", 80)},
+			}}},
+		}}},
+	}
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	route.SetNamespace("matrix")
+	route.SetName("federation")
+	_ = unstructured.SetNestedSlice(route.Object, []interface{}{
+		map[string]interface{}{"backendRefs": []interface{}{map[string]interface{}{"name": "synapse", "port": int64(8000)}}},
+	}, "spec", "rules")
+	return []client.Object{deploy, config, service, ingress, route}
+}
+
+func TestSyncRoutesBeforeRestart(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newRoutesTestObjects()...)
+	r.SyncRoutes = true
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+
+	_, err = r.rolloutWorkloads(ctx, "matrix", hash, logr.Discard())
+	require.NoError(t, err)
+
+	var ingress networkingv1.Ingress
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "matrix"}, &ingress))
+	paths := ingress.Spec.Rules[0].HTTP.Paths
+	assert.Equal(t, int32(8008), paths[0].Backend.Service.Port.Number)
+	assert.Equal(t, int32(80), paths[1].Backend.Service.Port.Number, "backends of other Services are left alone")
+	assert.Equal(t, "HTTP", ingress.Annotations[ingressBackendProtocolAnnotation])
+
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "federation"}, route))
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	backends, _, _ := unstructured.NestedSlice(rules[0].(map[string]interface{}), "backendRefs")
+	port, _, _ := unstructured.NestedInt64(backends[0].(map[string]interface{}), "port")
+	assert.Equal(t, int64(8448), port)
+
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Equal(t, hash, deploy.Spec.Template.Annotations[testHashAnnotation])
+}
+
+func TestSyncRoutesFailureHoldsRestart(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newRoutesTestObjects()...)
+	r.SyncRoutes = true
+	r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if _, ok := obj.(*networkingv1.Ingress); ok {
+				return errors.New("admission denied")
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	})
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+
+	_, err = r.rolloutWorkloads(ctx, "matrix", hash, logr.Discard())
+	require.Error(t, err)
+
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"bytes"
+	"maps"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// sealedSecretsGroup is the API group of SealedSecrets.
+const sealedSecretsGroup = "bitnami.com"
+
+// isSealedSecret reports whether obj is a Secret controlled by a SealedSecret.
+func isSealedSecret(obj client.Object) bool {
+	if _, ok := obj.(*corev1.Secret); !ok {
+		return false
+	}
+	owner := metav1.GetControllerOf(obj)
+	return owner != nil && owner.Kind == "SealedSecret" && strings.HasPrefix(owner.APIVersion, sealedSecretsGroup+"/")
+}
+
+// resealPredicate drops the updates of Secrets controlled by a SealedSecret that leave their type and data
+// unchanged, as a re-seal of the same plaintext does, and counts them in sealedSecretResealsTotal. The
+// sealed-secrets controller rewrites the Secret on every re-seal, so these would otherwise each trigger a
+// reconcile.
+func (r *ConfigMapReconciler) resealPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			before, ok := e.ObjectOld.(*corev1.Secret)
+			if !ok || !isSealedSecret(e.ObjectNew) {
+				return true
+			}
+			after := e.ObjectNew.(*corev1.Secret)
+			if before.Type != after.Type || !maps.EqualFunc(before.Data, after.Data, bytes.Equal) {
+				return true
+			}
+			sealedSecretResealsTotal.WithLabelValues(r.ClusterName, after.Namespace).Inc()
+			return false
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func newSealedSecret(value string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "signing-key",
+			Namespace: "matrix",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "bitnami.com/v1alpha1",
+				Kind:       "SealedSecret",
+				Name:       "signing-key",
+				UID:        "uid-sealed",
+				Controller: ptr.To(true),
+			}},
+		},
+		Data: map[string][]byte{"signing.key": []byte(value)},
+	}
+}
+
+func TestResealPredicateDropsUnchangedData(t *testing.T) {
+	reseals := testutil.ToFloat64(sealedSecretResealsTotal.WithLabelValues("", "matrix"))
+	before, resealed := newSealedSecret("a"), newSealedSecret("a")
+	resealed.ResourceVersion = "2"
+	resealed.Annotations = map[string]string{"sealedsecrets.bitnami.com/managed": "true"}
+
+	p := (&ConfigMapReconciler{}).resealPredicate()
+	assert.False(t, p.Update(event.UpdateEvent{ObjectOld: before, ObjectNew: resealed}))
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: before, ObjectNew: newSealedSecret("b")}))
+	assert.Equal(t, reseals+1, testutil.ToFloat64(sealedSecretResealsTotal.WithLabelValues("", "matrix")))
+
+	plain, touched := newSealedSecret("a"), newSealedSecret("a")
+	plain.OwnerReferences, touched.OwnerReferences = nil, nil
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: plain, ObjectNew: touched}), "other Secrets are left to the other predicates")
+}
+
+func TestSealedSecretsAreDebounced(t *testing.T) {
+	r := newTestReconciler(t)
+	r.SourceRules = DefaultSourceRules()
+
+	rule, err := r.classifySource(newSealedSecret("a"))
+	require.NoError(t, err)
+	assert.Equal(t, SourceClassSealedSecret, rule.Class)
+	assert.Equal(t, SourcePolicyDebounce, rule.Policy)
+
+	other := newSealedSecret("a")
+	other.OwnerReferences[0].APIVersion = "example.com/v1"
+	rule, err = r.classifySource(other)
+	require.NoError(t, err)
+	assert.Equal(t, SourceClassGenerated, rule.Class)
+}
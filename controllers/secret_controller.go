@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// SecretReconciler watches config Secrets with a controller of its own, so their rotations are queued and
+// tuned apart from ConfigMap edits, and rolls out the namespaces they change with Rollouts.
+type SecretReconciler struct {
+	// Rollouts reconciles the namespaces of changed Secrets; it is set up with the manager separately.
+	Rollouts *ConfigMapReconciler
+	// MaxConcurrentReconciles is the number of namespaces reconciled in parallel for Secret changes.
+	MaxConcurrentReconciles int
+	// Debounce, when positive, lets a Secret change into the config hash only once the Secret has stayed
+	// unchanged this long, unless its source class debounces it for longer.
+	Debounce time.Duration
+	// IgnoredNames are path.Match patterns of Secret names that are neither watched nor hashed.
+	IgnoredNames []string
+}
+
+// Reconcile rolls out the namespace of a changed Secret.
+func (r *SecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.Rollouts.Reconcile(ctx, req)
+}
+
+// SetupWithManager configures the controller to watch the Secrets selected as config sources, and those
+// referenced across namespaces, with its own queue.
+func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	for _, pattern := range r.IgnoredNames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid ignored Secret pattern %q: %w", pattern, err)
+		}
+	}
+	r.Rollouts.secrets = r
+	notIgnored := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return !r.ignores(obj.GetName())
+	})
+	changed := r.Rollouts.sourceChangePredicate()
+
+	options := controller.Options{
+		MaxConcurrentReconciles: max(r.MaxConcurrentReconciles, 1),
+		NewQueue: func(name string, rateLimiter workqueue.TypedRateLimiter[reconcile.Request]) workqueue.TypedRateLimitingInterface[reconcile.Request] {
+			return newFairQueue(name, rateLimiter, r.Rollouts.namespacePriority)
+		},
+	}
+	if r.Rollouts.NamespaceQPS > 0 {
+		options.RateLimiter = newNamespaceRateLimiter(r.Rollouts.NamespaceQPS, max(r.Rollouts.NamespaceBurst, 1))
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("secret").
+		For(
+			&corev1.Secret{},
+			builder.WithPredicates(r.Rollouts.configSourcePredicate(), r.Rollouts.resealPredicate(), changed, notIgnored),
+		).
+		Watches(&corev1.Secret{}, r.Rollouts.enqueueRemoteReferrers(remoteKindSecret), builder.WithPredicates(r.Rollouts.resealPredicate(), changed, notIgnored)).
+		WithOptions(options).
+		Complete(r)
+}
+
+// ignores reports whether the Secret called name is left out of the config hash. A nil r ignores nothing.
+func (r *SecretReconciler) ignores(name string) bool {
+	if r == nil {
+		return false
+	}
+	for _, pattern := range r.IgnoredNames {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// debounce returns how long a Secret change is held back at least. A nil r holds nothing back.
+func (r *SecretReconciler) debounce() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return r.Debounce
+}
+
+// lockNamespace serializes the reconciles of namespace across the ConfigMap and Secret controllers, whose
+// queues each serialize only their own requests, and returns the unlock function.
+func (r *ConfigMapReconciler) lockNamespace(namespace string) func() {
+	value, _ := r.namespaceLocks.LoadOrStore(namespace, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
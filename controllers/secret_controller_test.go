@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestSecret(name, value string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "matrix", Labels: map[string]string{"app.kubernetes.io/name": "synapse"}},
+		Data:       map[string][]byte{"data": []byte(value)},
+	}
+}
+
+func TestSecretReconcilerTuning(t *testing.T) {
+	ctx := context.Background()
+	token := newTestSecret("synapse-token", "one")
+	signing := newTestSecret("signing-key", "one")
+	r := newTestReconciler(t, newTestConfigMap("homeserver", nil, nil, "a"), token, signing)
+	r.secrets = &SecretReconciler{Rollouts: r, Debounce: time.Minute, IgnoredNames: []string{"*-token"}}
+	rotate := func(secret *corev1.Secret, value string) {
+		require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(secret), secret))
+		secret.Data["data"] = []byte(value)
+		require.NoError(t, r.Update(ctx, secret))
+	}
+
+	first, settleAfter, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Zero(t, settleAfter)
+
+	rotate(token, "two")
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, first, hash, "ignored Secrets are not hashed")
+
+	rotate(signing, "two")
+	hash, settleAfter, err = r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, first, hash, "Secret changes are held back until they settle")
+	assert.InDelta(t, time.Minute, settleAfter, float64(time.Second))
+}
+
+func TestIgnoreSecrets(t *testing.T) {
+	ctx := context.Background()
+	secret := newTestSecret("signing-key", "one")
+	r := newTestReconciler(t, newTestConfigMap("homeserver", nil, nil, "a"), secret)
+	r.IgnoreSecrets = true
+
+	configMaps, secrets, err := r.listConfigSources(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Len(t, configMaps, 1)
+	assert.Empty(t, secrets)
+}
+
+func TestLockNamespaceSerializesReconciles(t *testing.T) {
+	r := &ConfigMapReconciler{}
+	unlock := r.lockNamespace("matrix")
+	locked := make(chan struct{})
+	go func() {
+		defer r.lockNamespace("matrix")()
+		close(locked)
+	}()
+	r.lockNamespace("other")()
+
+	select {
+	case <-locked:
+		t.Fatal("namespace locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// lastAppliedConfigAnnotation is where kubectl apply keeps the applied object, data included.
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// StripSecretData is a cache transform for Secrets that replaces every value with its SHA-256 digest and
+// drops the last-applied configuration, which repeats the data, so the informer cache holds no secret
+// material. Cached Secrets keep their keys, type and metadata, and comparing their data still tells whether
+// the content changed. Install it with SecretMetadataOnly.
+func StripSecretData(obj interface{}) (interface{}, error) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return obj, nil
+	}
+	for key, value := range secret.Data {
+		sum := sha256.Sum256(value)
+		secret.Data[key] = sum[:]
+	}
+	secret.StringData = nil
+	delete(secret.Annotations, lastAppliedConfigAnnotation)
+	return secret, nil
+}
+
+// readSecretData returns secrets, listed from the cache, replaced by their current version read from the API
+// server when the cache holds them stripped by StripSecretData. Secrets deleted since are dropped. Only
+// what needs the values calls it, after everything that can do without: the config hash reads them on a
+// miss of the hash cache alone.
+func (r *ConfigMapReconciler) readSecretData(ctx context.Context, secrets []corev1.Secret) ([]corev1.Secret, error) {
+	if !r.SecretMetadataOnly || len(secrets) == 0 {
+		return secrets, nil
+	}
+	// The callers' Secrets stay stripped.
+	secrets = slices.Clone(secrets)
+	deleted := map[string]bool{}
+	for i := range secrets {
+		key := client.ObjectKeyFromObject(&secrets[i])
+		secretDataReadsTotal.WithLabelValues(r.ClusterName, key.Namespace).Inc()
+		if err := r.reader().Get(ctx, key, &secrets[i]); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+			deleted[key.Name] = true
+		}
+	}
+	return slices.DeleteFunc(secrets, func(secret corev1.Secret) bool { return deleted[secret.Name] }), nil
+}
+
+// passSecretData returns the Secrets of pass with their values, reading them once per pass.
+func (r *ConfigMapReconciler) passSecretData(ctx context.Context, pass *rolloutPass) ([]corev1.Secret, error) {
+	if !pass.State.secretDataRead {
+		secrets, err := r.readSecretData(ctx, pass.State.secrets)
+		if err != nil {
+			return nil, err
+		}
+		pass.State.secretData, pass.State.secretDataRead = secrets, true
+	}
+	return pass.State.secretData, nil
+}
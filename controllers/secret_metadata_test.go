@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStripSecretData(t *testing.T) {
+	secret := newTestSecret("signing-key", "ed25519 a_key")
+	secret.Annotations = map[string]string{lastAppliedConfigAnnotation: `{"data":{"data":"ZWQyNTUxOSBhX2tleQ=="}}`}
+
+	stripped, err := StripSecretData(secret.DeepCopy())
+	require.NoError(t, err)
+	again, err := StripSecretData(secret.DeepCopy())
+	require.NoError(t, err)
+	assert.Len(t, stripped.(*corev1.Secret).Data["data"], 32)
+	assert.NotContains(t, string(stripped.(*corev1.Secret).Data["data"]), "a_key")
+	assert.Empty(t, stripped.(*corev1.Secret).Annotations)
+	assert.Equal(t, stripped, again)
+}
+
+func TestSecretMetadataOnlyHashesData(t *testing.T) {
+	ctx := context.Background()
+	secret := newTestSecret("signing-key", "ed25519 a_key")
+	cm := newTestConfigMap("homeserver", nil, nil, "a")
+	want, _, err := newTestReconciler(t, cm, secret).computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+
+	stripped, err := StripSecretData(secret.DeepCopy())
+	require.NoError(t, err)
+	r := newTestReconciler(t, cm, stripped.(*corev1.Secret))
+	r.APIReader = fake.NewClientBuilder().WithScheme(r.Scheme).WithObjects(secret).Build()
+	r.SecretMetadataOnly = true
+
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, want, hash)
+}
+
+func TestSecretMetadataOnlyReadsDataOnHashCacheMiss(t *testing.T) {
+	ctx := context.Background()
+	secret := newTestSecret("signing-key", "ed25519 a_key")
+	stripped, err := StripSecretData(secret.DeepCopy())
+	require.NoError(t, err)
+	r := newTestReconciler(t, newTestConfigMap("homeserver", nil, nil, "a"), stripped.(*corev1.Secret))
+	r.APIReader = fake.NewClientBuilder().WithScheme(r.Scheme).WithObjects(secret).Build()
+	r.SecretMetadataOnly = true
+	reads := func() float64 { return testutil.ToFloat64(secretDataReadsTotal.WithLabelValues("", "matrix")) }
+	before := reads()
+
+	first, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, before+1, reads())
+	again, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+	assert.Equal(t, before+1, reads(), "unchanged Secrets are not read again")
+
+	cached := &corev1.Secret{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(secret), cached))
+	cached.Labels = map[string]string{"team": "matrix"}
+	require.NoError(t, r.Update(ctx, cached))
+	_, _, err = r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, before+2, reads(), "a new resourceVersion is read")
+}
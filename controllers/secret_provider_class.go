@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var (
+	secretProviderClassGVK = schema.GroupVersionKind{
+		Group:   "secrets-store.csi.x-k8s.io",
+		Version: "v1",
+		Kind:    "SecretProviderClass",
+	}
+	// secretProviderClassPodStatusGVK objects are written by the CSI driver per mounting pod and carry the
+	// versions of the external objects currently mounted; they change on every rotation.
+	secretProviderClassPodStatusGVK = schema.GroupVersionKind{
+		Group:   "secrets-store.csi.x-k8s.io",
+		Version: "v1",
+		Kind:    "SecretProviderClassPodStatus",
+	}
+)
+
+// watchSecretProviderClasses adds watches on SecretProviderClasses matching the selector and on the pod
+// statuses that reference them.
+func (r *ConfigMapReconciler) watchSecretProviderClasses(b *builder.Builder, matchesSelector predicate.Predicate) *builder.Builder {
+	spc := &unstructured.Unstructured{}
+	spc.SetGroupVersionKind(secretProviderClassGVK)
+	podStatus := &unstructured.Unstructured{}
+	podStatus.SetGroupVersionKind(secretProviderClassPodStatusGVK)
+
+	return b.
+		Watches(
+			spc,
+			&handler.EnqueueRequestForObject{},
+			builder.WithPredicates(matchesSelector),
+		).
+		Watches(
+			podStatus,
+			handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+				u, ok := obj.(*unstructured.Unstructured)
+				if !ok {
+					return nil
+				}
+				name, _, _ := unstructured.NestedString(u.Object, "status", "secretProviderClassName")
+				if name == "" {
+					return nil
+				}
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}}}
+			}),
+		)
+}
+
+// secretProviderClassDigests hashes each matching SecretProviderClass spec together with the distinct object
+// versions its pods currently mount, so external rotations change the combined hash.
+func (r *ConfigMapReconciler) secretProviderClassDigests(ctx context.Context, namespace string) ([]sourceDigest, error) {
+	classes := &unstructured.UnstructuredList{}
+	classes.SetGroupVersionKind(secretProviderClassGVK.GroupVersion().WithKind(secretProviderClassGVK.Kind + "List"))
+	if err := r.cacheReader().List(
+		ctx,
+		classes,
+		client.InNamespace(namespace),
+		client.MatchingLabelsSelector{Selector: r.sourceSelector()},
+	); err != nil {
+		return nil, err
+	}
+	if len(classes.Items) == 0 {
+		return nil, nil
+	}
+
+	statuses := &unstructured.UnstructuredList{}
+	statuses.SetGroupVersionKind(secretProviderClassPodStatusGVK.GroupVersion().WithKind(secretProviderClassPodStatusGVK.Kind + "List"))
+	if err := r.cacheReader().List(ctx, statuses, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	versions := map[string]map[string]struct{}{}
+	for i := range statuses.Items {
+		status := statuses.Items[i].Object
+		className, _, _ := unstructured.NestedString(status, "status", "secretProviderClassName")
+		objects, _, _ := unstructured.NestedSlice(status, "status", "objects")
+		for _, item := range objects {
+			object, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, _, _ := unstructured.NestedString(object, "id")
+			version, _, _ := unstructured.NestedString(object, "version")
+			if versions[className] == nil {
+				versions[className] = map[string]struct{}{}
+			}
+			versions[className][id+"="+version] = struct{}{}
+		}
+	}
+
+	digests := make([]sourceDigest, 0, len(classes.Items))
+	for i := range classes.Items {
+		class := &classes.Items[i]
+		spec, err := json.Marshal(class.Object["spec"])
+		if err != nil {
+			return nil, err
+		}
+		mounted := make([]string, 0, len(versions[class.GetName()]))
+		for v := range versions[class.GetName()] {
+			mounted = append(mounted, v)
+		}
+		sort.Strings(mounted)
+
+		hasher := sha256.New()
+		hasher.Write(spec)
+		hasher.Write([]byte{0})
+		for _, v := range mounted {
+			hasher.Write([]byte(v))
+			hasher.Write([]byte{0})
+		}
+		digests = append(digests, sourceDigest{
+			key:  "secretproviderclass/" + class.GetName(),
+			hash: hex.EncodeToString(hasher.Sum(nil)),
+		})
+	}
+	return digests, nil
+}
+
+// cacheReader returns the reader for unstructured kinds backed by the optional watches.
+func (r *ConfigMapReconciler) cacheReader() client.Reader {
+	if r.CacheReader != nil {
+		return r.CacheReader
+	}
+	return r.Client
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newTestSecretProviderClass() *unstructured.Unstructured {
+	spc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"provider": "vault"},
+	}}
+	spc.SetGroupVersionKind(secretProviderClassGVK)
+	spc.SetNamespace("matrix")
+	spc.SetName("synapse-secrets")
+	spc.SetLabels(map[string]string{"app.kubernetes.io/name": "synapse"})
+	return spc
+}
+
+func newTestPodStatus(name, version string) *unstructured.Unstructured {
+	status := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{
+			"secretProviderClassName": "synapse-secrets",
+			"objects": []interface{}{
+				map[string]interface{}{"id": "secret/db-password", "version": version},
+			},
+		},
+	}}
+	status.SetGroupVersionKind(secretProviderClassPodStatusGVK)
+	status.SetNamespace("matrix")
+	status.SetName(name)
+	return status
+}
+
+func TestSecretProviderClassDigestsTrackMountedVersions(t *testing.T) {
+	ctx := context.Background()
+	digestFor := func(statuses ...*unstructured.Unstructured) string {
+		r := newTestReconciler(t, newTestSecretProviderClass())
+		for _, s := range statuses {
+			require.NoError(t, r.Create(ctx, s))
+		}
+		digests, err := r.secretProviderClassDigests(ctx, "matrix")
+		require.NoError(t, err)
+		require.Len(t, digests, 1)
+		assert.Equal(t, "secretproviderclass/synapse-secrets", digests[0].key)
+		return digests[0].hash
+	}
+
+	v1 := digestFor(newTestPodStatus("pod-a", "1"))
+	assert.Equal(t, v1, digestFor(newTestPodStatus("pod-a", "1"), newTestPodStatus("pod-b", "1")))
+	assert.NotEqual(t, v1, digestFor(newTestPodStatus("pod-a", "2")))
+}
+
+func TestExternalSourceDigestsDisabled(t *testing.T) {
+	r := newTestReconciler(t, newTestSecretProviderClass())
+	digests, err := r.externalSourceDigests(context.Background(), "matrix", nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, digests)
+}
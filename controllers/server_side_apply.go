@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	batchv1ac "k8s.io/client-go/applyconfigurations/batch/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FieldManager is the field manager of the operator's server-side applies.
+const FieldManager = "synapse-operator"
+
+// applyTemplateAnnotation sets annotation key to value on the pod template of w with a server-side apply
+// that holds nothing else but the restart cause of ctx, so FieldManager owns exactly those annotations.
+// Another manager owning it, such as
+// a GitOps controller applying the same annotation, is reported as a FieldManagerConflict event and not
+// overwritten unless ForceServerSideApply is set.
+func (r *ConfigMapReconciler) applyTemplateAnnotation(ctx context.Context, w *workload, key, value string) (bool, error) {
+	if w.template.Annotations[key] == value {
+		return false, nil
+	}
+	annotations := map[string]string{key: value}
+	for causeKey, causeValue := range restartCauseAnnotations(ctx) {
+		annotations[causeKey] = causeValue
+	}
+	config, err := templateAnnotationApplyConfiguration(w, annotations)
+	if err != nil {
+		return false, err
+	}
+	opts := []client.ApplyOption{client.FieldOwner(FieldManager)}
+	if r.ForceServerSideApply {
+		opts = append(opts, client.ForceOwnership)
+	}
+	if err := r.Apply(ctx, config, opts...); err != nil {
+		if isFieldManagerConflict(err) {
+			r.traceEvent(ctx, w.obj, corev1.EventTypeWarning, "FieldManagerConflict", fmt.Sprintf("Another field manager owns pod template annotation %s: %v; set --server-side-apply-force to take it over", key, err))
+		}
+		return false, err
+	}
+	for key, value := range annotations {
+		setTemplateAnnotation(w, key, value)
+	}
+	return true, nil
+}
+
+// templateAnnotationApplyConfiguration returns the apply configuration of the kind of w that sets only the
+// pod template annotations.
+func templateAnnotationApplyConfiguration(w *workload, annotations map[string]string) (runtime.ApplyConfiguration, error) {
+	name, namespace := w.obj.GetName(), w.obj.GetNamespace()
+	template := corev1ac.PodTemplateSpec().WithAnnotations(annotations)
+	switch w.obj.(type) {
+	case *appsv1.Deployment:
+		return appsv1ac.Deployment(name, namespace).WithSpec(appsv1ac.DeploymentSpec().WithTemplate(template)), nil
+	case *appsv1.DaemonSet:
+		return appsv1ac.DaemonSet(name, namespace).WithSpec(appsv1ac.DaemonSetSpec().WithTemplate(template)), nil
+	case *appsv1.StatefulSet:
+		return appsv1ac.StatefulSet(name, namespace).WithSpec(appsv1ac.StatefulSetSpec().WithTemplate(template)), nil
+	case *batchv1.CronJob:
+		job := batchv1ac.JobTemplateSpec().WithSpec(batchv1ac.JobSpec().WithTemplate(template))
+		return batchv1ac.CronJob(name, namespace).WithSpec(batchv1ac.CronJobSpec().WithJobTemplate(job)), nil
+	}
+	return nil, fmt.Errorf("server-side apply is not supported for %s", w.kind)
+}
+
+// isFieldManagerConflict reports whether err is a server-side apply conflict with another field manager,
+// which retrying cannot resolve.
+func isFieldManagerConflict(err error) bool {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || !apierrors.IsConflict(err) || status.Status().Details == nil {
+		return false
+	}
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestServerSideApplyOwnsHashAnnotation(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", nil, nil, "a"))
+	r.ServerSideApply = true
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.NotEmpty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+
+	// The operator owns the annotation, so another manager applying it conflicts.
+	override := appsv1ac.Deployment("synapse", "matrix").WithSpec(appsv1ac.DeploymentSpec().WithTemplate(
+		corev1ac.PodTemplateSpec().WithAnnotations(map[string]string{testHashAnnotation: "pinned"})))
+	assert.True(t, isFieldManagerConflict(r.Apply(ctx, override, client.FieldOwner("argocd-controller"))))
+}
+
+func TestServerSideApplyReportsFieldManagerConflict(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", nil, nil, "a"))
+	r.ServerSideApply = true
+	r.PatchRetryAttempts = 3
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	// A GitOps controller applying the annotation itself owns it.
+	pinned := appsv1ac.Deployment("synapse", "matrix").WithSpec(appsv1ac.DeploymentSpec().WithTemplate(
+		corev1ac.PodTemplateSpec().WithAnnotations(map[string]string{testHashAnnotation: "pinned"})))
+	require.NoError(t, r.Apply(ctx, pinned, client.FieldOwner("argocd-controller")))
+	retries := testutil.ToFloat64(workloadPatchRetriesTotal.WithLabelValues("", "matrix", "conflict"))
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	_, err := r.Reconcile(ctx, request)
+	require.Error(t, err)
+	assert.True(t, isFieldManagerConflict(err))
+	assert.Equal(t, retries, testutil.ToFloat64(workloadPatchRetriesTotal.WithLabelValues("", "matrix", "conflict")), "a field manager conflict is not retried")
+	assert.Contains(t, <-recorder.Events, "FieldManagerConflict")
+
+	r.ForceServerSideApply = true
+	_, err = r.Reconcile(ctx, request)
+	require.NoError(t, err)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.NotEqual(t, "pinned", deploy.Spec.Template.Annotations[testHashAnnotation])
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sharedIndexes are the indexes over the manager's cache that the controllers and the hash injection webhook
+// decide from: the source reference field indexes, from each config source to the workloads referencing it,
+// and the digest of each config source, computed once per resourceVersion by whichever hashes it first. They
+// are kept on the informers the controllers already watch with, so they add no list or watch load of their
+// own, and are registered once per cache however many components ask for them.
+type sharedIndexes struct {
+	digests *sourceDigestIndex
+}
+
+var (
+	sharedIndexesMu sync.Mutex
+	// registeredIndexes maps each cache.Cache to its *sharedIndexes.
+	registeredIndexes = map[cache.Cache]*sharedIndexes{}
+)
+
+// sharedIndexesFor returns the indexes of c, registering them on the first call. Only the workload kinds in
+// kinds are indexed, and so watched.
+func sharedIndexesFor(ctx context.Context, c cache.Cache, indexer client.FieldIndexer, kinds []workloadKind) (*sharedIndexes, error) {
+	sharedIndexesMu.Lock()
+	defer sharedIndexesMu.Unlock()
+	if indexes, ok := registeredIndexes[c]; ok {
+		return indexes, nil
+	}
+	if err := registerSourceRefIndexes(ctx, indexer, kinds); err != nil {
+		return nil, err
+	}
+	indexes := &sharedIndexes{digests: newSourceDigestIndex()}
+	for _, kind := range []struct {
+		obj  client.Object
+		name string
+	}{{&corev1.ConfigMap{}, "configmap"}, {&corev1.Secret{}, "secret"}} {
+		informer, err := c.GetInformer(ctx, kind.obj, cache.BlockUntilSynced(false))
+		if err != nil {
+			return nil, err
+		}
+		name := kind.name
+		if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if source, ok := obj.(client.Object); ok {
+					indexes.digests.forget(name, source)
+				}
+			},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	registeredIndexes[c] = indexes
+	return indexes, nil
+}
+
+// sourceDigestIndex holds the content digest of each config source at its current resourceVersion. Entries
+// of changed sources are replaced as they are hashed again, and those of deleted sources are dropped.
+type sourceDigestIndex struct {
+	// entries maps "<kind>/<namespace>/<name>" to a digestEntry.
+	entries sync.Map
+}
+
+type digestEntry struct {
+	uid             types.UID
+	resourceVersion string
+	hash            string
+}
+
+func newSourceDigestIndex() *sourceDigestIndex {
+	return &sourceDigestIndex{}
+}
+
+// digest returns the digest of obj, of kind "configmap" or "secret", from the index when it was computed at
+// the same resourceVersion, or else from compute. The index must only ever be used with the same key
+// filters, which the digests depend on. A nil index always computes.
+func (i *sourceDigestIndex) digest(kind string, obj client.Object, compute func() string) string {
+	if i == nil || obj.GetResourceVersion() == "" {
+		return compute()
+	}
+	key := kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+	if value, ok := i.entries.Load(key); ok {
+		if entry := value.(digestEntry); entry.uid == obj.GetUID() && entry.resourceVersion == obj.GetResourceVersion() {
+			return entry.hash
+		}
+	}
+	hash := compute()
+	i.entries.Store(key, digestEntry{uid: obj.GetUID(), resourceVersion: obj.GetResourceVersion(), hash: hash})
+	return hash
+}
+
+// forget drops the digest of obj.
+func (i *sourceDigestIndex) forget(kind string, obj client.Object) {
+	i.entries.Delete(kind + "/" + obj.GetNamespace() + "/" + obj.GetName())
+}
+
+// digestIndex returns the shared digest index, or nil before SetupWithManager.
+func (r *ConfigMapReconciler) digestIndex() *sourceDigestIndex {
+	if r.indexes == nil {
+		return nil
+	}
+	return r.indexes.digests
+}
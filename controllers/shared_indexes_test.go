@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSourceDigestIndexFollowsResourceVersion(t *testing.T) {
+	index := newSourceDigestIndex()
+	cm := newTestConfigMap("homeserver", nil, nil, "a")
+	cm.UID, cm.ResourceVersion = types.UID("uid-1"), "1"
+	computed := 0
+	compute := func() string {
+		computed++
+		return hashConfigMapContent(cm, keyFilter{})
+	}
+
+	first := index.digest("configmap", cm, compute)
+	assert.Equal(t, first, index.digest("configmap", cm, compute))
+	assert.Equal(t, 1, computed, "an unchanged source is hashed once")
+
+	cm.Data["data"], cm.ResourceVersion = "b", "2"
+	assert.NotEqual(t, first, index.digest("configmap", cm, compute))
+	cm.UID = types.UID("uid-2")
+	index.digest("configmap", cm, compute)
+	assert.Equal(t, 3, computed, "a new resourceVersion or a recreated source is hashed again")
+
+	index.forget("configmap", cm)
+	index.digest("configmap", cm, compute)
+	assert.Equal(t, 4, computed)
+}
+
+func TestCombinedHashUsesSharedDigests(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	want, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+
+	r.indexes = &sharedIndexes{digests: newSourceDigestIndex()}
+	got, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "homeserver"}, cm))
+	cm.Data["data"] = "b"
+	require.NoError(t, r.Update(ctx, cm))
+	changed, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.NotEqual(t, want, changed, "an update is hashed at its new resourceVersion")
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"bytes"
+	"maps"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// sourceLabels are the labels of a config source read besides the source selector: for grouping,
+// priority, source classes and immutable config series.
+var sourceLabels = []string{ComponentLabel, PriorityLabel, TrustManagerBundleLabel, VersionedFromLabel, ConfigSeriesLabel, "owner"}
+
+// sourceChangePredicate drops the updates of ConfigMaps and Secrets that change nothing the operator reads
+// from a config source, such as a label only other tools use, a new managedFields entry or an informer
+// resync, and counts them in sourceUpdatesSkippedTotal. These would otherwise each enqueue a reconcile that
+// hashes every source of the namespace only to find the hash unchanged.
+func (r *ConfigMapReconciler) sourceChangePredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil || r.sourceChanged(e.ObjectOld, e.ObjectNew) {
+				return true
+			}
+			sourceUpdatesSkippedTotal.WithLabelValues(r.ClusterName, e.ObjectNew.GetNamespace()).Inc()
+			return false
+		},
+	}
+}
+
+// sourceChanged reports whether after differs from before in its data, or in the annotations, owners or
+// labels read from config sources. A ConfigMap or Secret whose selection by the source selector changes
+// counts as changed.
+func (r *ConfigMapReconciler) sourceChanged(before, after client.Object) bool {
+	switch after := after.(type) {
+	case *corev1.ConfigMap:
+		before, ok := before.(*corev1.ConfigMap)
+		if !ok || !maps.Equal(before.Data, after.Data) || !maps.EqualFunc(before.BinaryData, after.BinaryData, bytes.Equal) {
+			return true
+		}
+	case *corev1.Secret:
+		// With SecretMetadataOnly both hold digests of the values, which compare the same way.
+		before, ok := before.(*corev1.Secret)
+		if !ok || before.Type != after.Type || !maps.EqualFunc(before.Data, after.Data, bytes.Equal) {
+			return true
+		}
+	default:
+		return true
+	}
+	if !equalAnnotations(before.GetAnnotations(), after.GetAnnotations()) {
+		return true
+	}
+	if !equality.Semantic.DeepEqual(before.GetOwnerReferences(), after.GetOwnerReferences()) {
+		return true
+	}
+	for _, key := range sourceLabels {
+		if before.GetLabels()[key] != after.GetLabels()[key] {
+			return true
+		}
+	}
+	return r.selectsConfigSource(before) != r.selectsConfigSource(after)
+}
+
+// equalAnnotations reports whether before and after hold the same annotations, apart from the copy kubectl
+// apply keeps of the object, which changes along with what it copies.
+func equalAnnotations(before, after map[string]string) bool {
+	before, after = maps.Clone(before), maps.Clone(after)
+	delete(before, lastAppliedConfigAnnotation)
+	delete(after, lastAppliedConfigAnnotation)
+	return maps.Equal(before, after)
+}
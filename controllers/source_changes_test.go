@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestSourceChangePredicate(t *testing.T) {
+	r := newTestReconciler(t)
+	p := r.sourceChangePredicate()
+	before := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, map[string]string{lastAppliedConfigAnnotation: "{}"}, "a")
+	updated := func(edit func(after *metav1.ObjectMeta)) bool {
+		after := before.DeepCopy()
+		edit(&after.ObjectMeta)
+		return p.Update(event.UpdateEvent{ObjectOld: before, ObjectNew: after})
+	}
+
+	assert.False(t, updated(func(after *metav1.ObjectMeta) { after.ResourceVersion = "2" }), "a resync is dropped")
+	assert.False(t, updated(func(after *metav1.ObjectMeta) { after.Labels["team"] = "matrix" }), "labels only other tools use are dropped")
+	assert.False(t, updated(func(after *metav1.ObjectMeta) { after.Annotations[lastAppliedConfigAnnotation] = "{\"x\":1}" }))
+	assert.True(t, updated(func(after *metav1.ObjectMeta) { after.Labels[ComponentLabel] = "media" }))
+	assert.True(t, updated(func(after *metav1.ObjectMeta) { after.Labels[ConfigSeriesLabel] = "synapse-config" }), "joining a config series changes the hash")
+	assert.True(t, updated(func(after *metav1.ObjectMeta) { after.Annotations[IncludeKeysAnnotation] = "data" }))
+
+	after := before.DeepCopy()
+	after.Data["data"] = "b"
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: before, ObjectNew: after}))
+
+	secret := newTestSecret("signing-key", "one")
+	rotated := secret.DeepCopy()
+	rotated.Data["data"] = []byte("two")
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: secret, ObjectNew: rotated}))
+	rotated = secret.DeepCopy()
+	rotated.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}
+	assert.False(t, p.Update(event.UpdateEvent{ObjectOld: secret, ObjectNew: rotated}))
+}
+
+func TestSourceChangeSelection(t *testing.T) {
+	r := newTestReconciler(t)
+	r.LabelSelector = labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "synapse"})
+	before := newTestConfigMap("homeserver", nil, nil, "a")
+	after := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+
+	assert.True(t, r.sourceChanged(before, after), "a ConfigMap that became a source changed")
+}
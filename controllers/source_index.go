@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Field indexes mapping workloads to the names of the ConfigMaps and Secrets their pod templates mount or
+// read env from, so the workloads referencing a source are found without scanning the namespace.
+const (
+	configMapRefsIndex = "synapse.gen0sec.com/configmap-refs"
+	secretRefsIndex    = "synapse.gen0sec.com/secret-refs"
+)
+
+// sourceRefIndexes lists the indexes registered for each workload kind.
+var sourceRefIndexes = []struct {
+	kind    string
+	obj     client.Object
+	field   string
+	extract client.IndexerFunc
+}{
+	{"deployment", &appsv1.Deployment{}, configMapRefsIndex, indexConfigMapRefs},
+	{"deployment", &appsv1.Deployment{}, secretRefsIndex, indexSecretRefs},
+	{"daemonset", &appsv1.DaemonSet{}, configMapRefsIndex, indexConfigMapRefs},
+	{"daemonset", &appsv1.DaemonSet{}, secretRefsIndex, indexSecretRefs},
+	{"statefulset", &appsv1.StatefulSet{}, configMapRefsIndex, indexConfigMapRefs},
+	{"statefulset", &appsv1.StatefulSet{}, secretRefsIndex, indexSecretRefs},
+	{"cronjob", &batchv1.CronJob{}, configMapRefsIndex, indexConfigMapRefs},
+	{"cronjob", &batchv1.CronJob{}, secretRefsIndex, indexSecretRefs},
+}
+
+// registerSourceRefIndexes adds the source reference indexes of kinds to indexer. Other kinds are not
+// indexed, and so not watched.
+func registerSourceRefIndexes(ctx context.Context, indexer client.FieldIndexer, kinds []workloadKind) error {
+	for _, index := range sourceRefIndexes {
+		if !slices.ContainsFunc(kinds, func(kind workloadKind) bool { return kind.name == index.kind }) {
+			continue
+		}
+		if err := indexer.IndexField(ctx, index.obj, index.field, index.extract); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexConfigMapRefs(obj client.Object) []string {
+	configMaps, _ := podTemplateSourceRefs(obj)
+	return configMaps
+}
+
+func indexSecretRefs(obj client.Object) []string {
+	_, secrets := podTemplateSourceRefs(obj)
+	return secrets
+}
+
+// podTemplateSourceRefs returns the ConfigMap and Secret names referenced by the pod template of a workload,
+// including the sources the versioned restart strategy replaced with copies.
+func podTemplateSourceRefs(obj client.Object) ([]string, []string) {
+	var template *corev1.PodTemplateSpec
+	switch w := obj.(type) {
+	case *appsv1.Deployment:
+		template = &w.Spec.Template
+	case *appsv1.DaemonSet:
+		template = &w.Spec.Template
+	case *appsv1.StatefulSet:
+		template = &w.Spec.Template
+	case *batchv1.CronJob:
+		template = &w.Spec.JobTemplate.Spec.Template
+	default:
+		return nil, nil
+	}
+	configMaps := map[string]struct{}{}
+	secrets := map[string]struct{}{}
+	collectPodSpecSources(&template.Spec, configMaps, secrets)
+	collectVersionedSources(template, configMaps, secrets)
+	return sortedKeys(configMaps), sortedKeys(secrets)
+}
+
+// workloadsReferencing returns the targeted workloads whose pod templates reference the config source obj,
+// using the source reference indexes.
+func (r *ConfigMapReconciler) workloadsReferencing(ctx context.Context, obj client.Object) ([]*workload, error) {
+	field := configMapRefsIndex
+	if _, ok := obj.(*corev1.Secret); ok {
+		field = secretRefsIndex
+	}
+	opts := append(r.workloadListOptions(obj.GetNamespace()), client.MatchingFields{field: obj.GetName()})
+	return r.findWorkloads(ctx, opts...)
+}
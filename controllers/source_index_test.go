@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWorkloadsReferencingUsesSourceIndex(t *testing.T) {
+	ctx := context.Background()
+	mounting := newTestDeployment(nil)
+	mounting.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+			{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "homeserver"}}},
+		}}},
+	}}
+	envReader := newTestDeployment(nil)
+	envReader.Name = "synapse-worker"
+	envReader.Spec.Template.Spec.Containers = []corev1.Container{{
+		Name: "worker",
+		Env: []corev1.EnvVar{{Name: "SIGNING_KEY", ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "signing-key"}, Key: "key"},
+		}}},
+	}}
+	r := newTestReconciler(t, mounting, envReader)
+
+	workloads, err := r.workloadsReferencing(ctx, newTestConfigMap("homeserver", nil, nil, ""))
+	require.NoError(t, err)
+	require.Len(t, workloads, 1)
+	assert.Equal(t, "deployment/synapse", workloads[0].key())
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "signing-key", Namespace: "matrix"}}
+	workloads, err = r.workloadsReferencing(ctx, secret)
+	require.NoError(t, err)
+	require.Len(t, workloads, 1)
+	assert.Equal(t, "deployment/synapse-worker", workloads[0].key())
+
+	workloads, err = r.workloadsReferencing(ctx, newTestConfigMap("signing-key", nil, nil, ""))
+	require.NoError(t, err)
+	assert.Empty(t, workloads)
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxListedDroppedSources bounds how many left-out sources a SourceLimitExceeded event names.
+const maxListedDroppedSources = 10
+
+// hashedSources collects the sources that went into the config hashes computed with a context, keyed by
+// "<kind>/<name>", and the ConfigMaps and Secrets MaxSourcesPerNamespace left out of them.
+type hashedSources struct {
+	hashed  []string
+	dropped []string
+}
+
+type hashedSourcesKey struct{}
+
+// withHashedSources returns a context that records the sources of the config hashes computed with it.
+func withHashedSources(ctx context.Context) (context.Context, *hashedSources) {
+	sources := &hashedSources{}
+	return context.WithValue(ctx, hashedSourcesKey{}, sources), sources
+}
+
+// recordHashedSources adds the sources of one hash to the collector of ctx, if any.
+func recordHashedSources(ctx context.Context, digests []sourceDigest, dropped []string) {
+	sources, ok := ctx.Value(hashedSourcesKey{}).(*hashedSources)
+	if !ok {
+		return
+	}
+	for _, digest := range digests {
+		sources.hashed = append(sources.hashed, digest.key)
+	}
+	sources.dropped = append(sources.dropped, dropped...)
+	// Owner groups are hashed one at a time with the same context and may share sources.
+	slices.Sort(sources.hashed)
+	sources.hashed = slices.Compact(sources.hashed)
+	slices.Sort(sources.dropped)
+	sources.dropped = slices.Compact(sources.dropped)
+}
+
+// capSources keeps the first MaxSourcesPerNamespace of the listed ConfigMaps and Secrets, in the order of
+// their "<kind>/<name>" keys so the same ones are kept on every pass, and returns the keys of the others.
+func (r *ConfigMapReconciler) capSources(configMaps []corev1.ConfigMap, secrets []corev1.Secret) ([]corev1.ConfigMap, []corev1.Secret, []string) {
+	limit := r.MaxSourcesPerNamespace
+	if limit <= 0 || len(configMaps)+len(secrets) <= limit {
+		return configMaps, secrets, nil
+	}
+	configMaps = slices.SortedFunc(slices.Values(configMaps), func(a, b corev1.ConfigMap) int { return strings.Compare(a.Name, b.Name) })
+	secrets = slices.SortedFunc(slices.Values(secrets), func(a, b corev1.Secret) int { return strings.Compare(a.Name, b.Name) })
+	var dropped []string
+	if len(configMaps) > limit {
+		for _, cm := range configMaps[limit:] {
+			dropped = append(dropped, "configmap/"+cm.Name)
+		}
+		configMaps = configMaps[:limit]
+	}
+	keep := limit - len(configMaps)
+	for _, secret := range secrets[keep:] {
+		dropped = append(dropped, "secret/"+secret.Name)
+	}
+	return configMaps, secrets[:keep], dropped
+}
+
+// reportHashedSources publishes the number of sources hashed for namespace and warns on the Namespace when
+// MaxSourcesPerNamespace left some of them out.
+func (r *ConfigMapReconciler) reportHashedSources(namespace string, sources *hashedSources) {
+	configSourcesHashedGauge.WithLabelValues(r.ClusterName, namespace).Set(float64(len(sources.hashed)))
+	configSourcesDroppedGauge.WithLabelValues(r.ClusterName, namespace).Set(float64(len(sources.dropped)))
+	if len(sources.dropped) == 0 {
+		return
+	}
+	listed := sources.dropped
+	if len(listed) > maxListedDroppedSources {
+		listed = append(slices.Clip(listed[:maxListedDroppedSources]), fmt.Sprintf("and %d more", len(sources.dropped)-maxListedDroppedSources))
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+	r.event(ns, corev1.EventTypeWarning, "SourceLimitExceeded",
+		fmt.Sprintf("%d config sources exceed --max-sources-per-namespace=%d and are left out of the config hash: %s",
+			len(sources.dropped), r.MaxSourcesPerNamespace, strings.Join(listed, ", ")))
+}
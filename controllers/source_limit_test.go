@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestMaxSourcesPerNamespace(t *testing.T) {
+	ctx := context.Background()
+	selected := map[string]string{"app.kubernetes.io/name": "synapse"}
+	keys := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: "matrix", Labels: selected},
+		Data:       map[string][]byte{"SIGNING_KEY": []byte("k1")},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("workers", selected, nil, "w"),
+		newTestConfigMap("homeserver", selected, nil, "a"), newTestConfigMap("logging", selected, nil, "l"), keys)
+	r.Recorder = recorder
+	r.MaxSourcesPerNamespace = 2
+
+	capped, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	expected := hashConfigSources([]corev1.ConfigMap{*newTestConfigMap("homeserver", selected, nil, "a"), *newTestConfigMap("logging", selected, nil, "l")}, nil, keyFilter{}, keyFilter{})
+	assert.Equal(t, expected, capped, "the first sources by kind and name are hashed")
+
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	assert.Equal(t, "Warning SourceLimitExceeded 2 config sources exceed --max-sources-per-namespace=2 and are left out of the config hash: configmap/workers, secret/keys", <-recorder.Events)
+	assert.Equal(t, 2.0, testutil.ToFloat64(configSourcesHashedGauge.WithLabelValues("", "matrix")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(configSourcesDroppedGauge.WithLabelValues("", "matrix")))
+
+	var status namespaceHashStatus
+	getStatus(t, r.statusHandler(), "/namespaces/matrix/hash", &status)
+	assert.Equal(t, []string{"configmap/homeserver", "configmap/logging"}, status.Sources)
+	assert.Equal(t, []string{"configmap/workers", "secret/keys"}, status.DroppedSources)
+}
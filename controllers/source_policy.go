@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"synapse-operator/audit"
+)
+
+// Source policies decide how a change to one config source reaches the workloads.
+const (
+	// SourcePolicyRestart folds the source into the config hash, restarting workloads on every change.
+	SourcePolicyRestart = "restart"
+	// SourcePolicyIgnore leaves the source out of the config hash, for content the application reloads
+	// itself from the projected volume.
+	SourcePolicyIgnore = "ignore"
+	// SourcePolicyDebounce only lets a change into the config hash once the source has been stable for the
+	// rule's debounce period, coalescing bursts of rotations into one restart.
+	SourcePolicyDebounce = "debounce"
+)
+
+// Source classes, in the order they are inferred.
+const (
+	// SourceClassHelmRelease is Helm's own release storage, which changes on every upgrade alongside the
+	// config it rendered.
+	SourceClassHelmRelease = "helm-release"
+	// SourceClassSealedSecret is a Secret decrypted by the sealed-secrets controller from a SealedSecret,
+	// which rewrites it on every re-seal.
+	SourceClassSealedSecret = "sealed-secret"
+	// SourceClassTLSSecret is a kubernetes.io/tls Secret.
+	SourceClassTLSSecret = "tls-secret"
+	// SourceClassCABundle is a CA bundle, such as those written by trust-manager.
+	SourceClassCABundle = "ca-bundle"
+	// SourceClassGenerated is a source written by another controller.
+	SourceClassGenerated = "generated"
+	// SourceClassAppConfig is hand-written application config; everything else falls into it.
+	SourceClassAppConfig = "app-config"
+)
+
+const (
+	// SourceClassAnnotation forces the class of a single ConfigMap or Secret.
+	SourceClassAnnotation = "synapse.gen0sec.com/source-class"
+	// SourcePolicyAnnotation overrides the policy of its class on a single ConfigMap or Secret.
+	SourcePolicyAnnotation = "synapse.gen0sec.com/source-policy"
+	// TrustManagerBundleLabel is set by trust-manager on the ConfigMaps and Secrets it writes for a Bundle.
+	TrustManagerBundleLabel = "trust.cert-manager.io/bundle"
+)
+
+const helmReleaseSecretType = corev1.SecretType("helm.sh/release.v1")
+
+var caBundleName = regexp.MustCompile(`(^|[-.])ca-?bundle$`)
+
+// ValidSourcePolicy reports whether policy is a known source policy.
+func ValidSourcePolicy(policy string) bool {
+	switch policy {
+	case SourcePolicyRestart, SourcePolicyIgnore, SourcePolicyDebounce:
+		return true
+	}
+	return false
+}
+
+// SourceRule infers a source class and holds the policy applied to sources of that class.
+type SourceRule struct {
+	Class string
+	// Match reports whether a source belongs to the class when it is not forced by annotation.
+	Match  func(obj client.Object) bool
+	Policy string
+	// Debounce is how long a source must stay unchanged before a change is rolled out, for SourcePolicyDebounce.
+	Debounce time.Duration
+}
+
+// DefaultSourceRules returns the built-in classes with their default policies, in inference order.
+func DefaultSourceRules() []SourceRule {
+	return []SourceRule{
+		{Class: SourceClassHelmRelease, Match: isHelmRelease, Policy: SourcePolicyIgnore},
+		{Class: SourceClassSealedSecret, Match: isSealedSecret, Policy: SourcePolicyDebounce, Debounce: time.Minute},
+		{Class: SourceClassTLSSecret, Match: isTLSSecret, Policy: SourcePolicyRestart},
+		{Class: SourceClassCABundle, Match: isCABundle, Policy: SourcePolicyDebounce, Debounce: 5 * time.Minute},
+		{Class: SourceClassGenerated, Match: isGenerated, Policy: SourcePolicyRestart},
+		{Class: SourceClassAppConfig, Match: func(client.Object) bool { return true }, Policy: SourcePolicyRestart},
+	}
+}
+
+// OverrideSourceClassPolicies applies a comma-separated list of class=policy[/debounce] overrides, such as
+// "ca-bundle=debounce/10m,helm-release=restart", to rules.
+func OverrideSourceClassPolicies(rules []SourceRule, overrides string) ([]SourceRule, error) {
+	for _, item := range strings.Split(overrides, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		class, policy, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("source class override %q is not class=policy", item)
+		}
+		policy, debounceValue, hasDebounce := strings.Cut(policy, "/")
+		if !ValidSourcePolicy(policy) {
+			return nil, fmt.Errorf("unknown source policy %q for class %q", policy, class)
+		}
+		var debounce time.Duration
+		if hasDebounce {
+			var err error
+			if debounce, err = time.ParseDuration(debounceValue); err != nil {
+				return nil, fmt.Errorf("invalid debounce for class %q: %w", class, err)
+			}
+		}
+		found := false
+		for i := range rules {
+			if rules[i].Class != class {
+				continue
+			}
+			found = true
+			rules[i].Policy = policy
+			if hasDebounce {
+				rules[i].Debounce = debounce
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown source class %q", class)
+		}
+	}
+	return rules, nil
+}
+
+func isHelmRelease(obj client.Object) bool {
+	if secret, ok := obj.(*corev1.Secret); ok && secret.Type == helmReleaseSecretType {
+		return true
+	}
+	return obj.GetLabels()["owner"] == "helm" && strings.HasPrefix(obj.GetName(), "sh.helm.release.")
+}
+
+func isTLSSecret(obj client.Object) bool {
+	secret, ok := obj.(*corev1.Secret)
+	return ok && secret.Type == corev1.SecretTypeTLS
+}
+
+func isCABundle(obj client.Object) bool {
+	if _, ok := obj.GetLabels()[TrustManagerBundleLabel]; ok {
+		return true
+	}
+	return caBundleName.MatchString(obj.GetName())
+}
+
+func isGenerated(obj client.Object) bool {
+	return metav1.GetControllerOf(obj) != nil
+}
+
+// classifySource returns the rule for obj: the class forced by SourceClassAnnotation, or else the first
+// matching rule, with its policy replaced by the source's own SourcePolicyAnnotation if set. Sources no rule
+// matches are app config restarting on change.
+func (r *ConfigMapReconciler) classifySource(obj client.Object) (SourceRule, error) {
+	rule := SourceRule{Class: SourceClassAppConfig, Policy: SourcePolicyRestart}
+	for _, candidate := range r.SourceRules {
+		if candidate.Match != nil && candidate.Match(obj) {
+			rule = candidate
+			break
+		}
+	}
+	annotations := obj.GetAnnotations()
+	if class, ok := annotations[SourceClassAnnotation]; ok && class != rule.Class {
+		found := false
+		for _, candidate := range r.SourceRules {
+			if candidate.Class == class {
+				rule, found = candidate, true
+				break
+			}
+		}
+		if !found {
+			return rule, fmt.Errorf("unknown source class %q", class)
+		}
+	}
+	if override, ok := annotations[SourcePolicyAnnotation]; ok {
+		if !ValidSourcePolicy(override) {
+			return rule, fmt.Errorf("unknown source policy %q", override)
+		}
+		rule.Policy = override
+	}
+	return rule, nil
+}
+
+// sourceDebouncer tracks, per source, the digest currently let into the config hash and any newer digest
+// waiting to settle.
+type sourceDebouncer struct {
+	mu    sync.Mutex
+	items map[string]*debouncedSource
+}
+
+type debouncedSource struct {
+	settled string
+	pending string
+	since   time.Time
+}
+
+func newSourceDebouncer() *sourceDebouncer {
+	return &sourceDebouncer{items: map[string]*debouncedSource{}}
+}
+
+// observe returns the digest to use for key and, while a change is held back, how long until it settles.
+// The first observation of a source is used as-is so restarts of the operator do not delay anything.
+func (d *sourceDebouncer) observe(key, digest string, debounce time.Duration, now time.Time) (string, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	item, ok := d.items[key]
+	if !ok {
+		d.items[key] = &debouncedSource{settled: digest}
+		return digest, 0
+	}
+	if digest == item.settled {
+		item.pending = ""
+		return digest, 0
+	}
+	if digest != item.pending {
+		item.pending = digest
+		item.since = now
+	}
+	if wait := debounce - now.Sub(item.since); wait > 0 {
+		return item.settled, wait
+	}
+	item.settled = digest
+	item.pending = ""
+	return digest, 0
+}
+
+// classifySources drops sources with SourcePolicyIgnore, and Secrets ignored by the SecretReconciler, and
+// returns the debounce period of each debounced source, keyed like its sourceDigest with prefix prepended.
+// Sources with an invalid override fall back to what was inferred.
+func (r *ConfigMapReconciler) classifySources(ctx context.Context, prefix string, configMaps []corev1.ConfigMap, secrets []corev1.Secret) ([]corev1.ConfigMap, []corev1.Secret, map[string]time.Duration) {
+	debounced := map[string]time.Duration{}
+	include := func(obj client.Object, key string) bool {
+		rule, err := r.classifySource(obj)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "ignoring invalid source class or policy override", "source", key)
+			r.event(obj, corev1.EventTypeWarning, "InvalidSourcePolicy", err.Error())
+		}
+		log.FromContext(ctx).V(1).Info("Classified config source", "source", key, "class", rule.Class, "policy", rule.Policy)
+		audit.FromContext(ctx).Source(key, func(source *audit.Source) {
+			source.Class, source.Policy = rule.Class, rule.Policy
+		})
+		switch rule.Policy {
+		case SourcePolicyIgnore:
+			return false
+		case SourcePolicyDebounce:
+			debounced[key] = rule.Debounce
+		}
+		return true
+	}
+
+	keptConfigMaps := configMaps[:0]
+	for i := range configMaps {
+		if include(&configMaps[i], prefix+"configmap/"+configMaps[i].Name) {
+			keptConfigMaps = append(keptConfigMaps, configMaps[i])
+		}
+	}
+	keptSecrets := secrets[:0]
+	for i := range secrets {
+		key := prefix + "secret/" + secrets[i].Name
+		if r.secrets.ignores(secrets[i].Name) {
+			audit.FromContext(ctx).Source(key, func(source *audit.Source) {
+				source.Excluded = "name matches an ignored Secret pattern"
+			})
+			continue
+		}
+		if include(&secrets[i], key) {
+			keptSecrets = append(keptSecrets, secrets[i])
+			if debounce := r.secrets.debounce(); debounce > debounced[key] {
+				debounced[key] = debounce
+			}
+		}
+	}
+	return keptConfigMaps, keptSecrets, debounced
+}
+
+// debounceSources replaces the digests of debounced sources with their settled digest and returns the
+// shortest wait until a held-back change settles.
+func (r *ConfigMapReconciler) debounceSources(ctx context.Context, namespace string, digests []sourceDigest, debounced map[string]time.Duration, now time.Time) ([]sourceDigest, time.Duration) {
+	if len(debounced) == 0 {
+		return digests, 0
+	}
+	if r.debouncer == nil {
+		r.debouncer = newSourceDebouncer()
+	}
+	var wait time.Duration
+	for i := range digests {
+		debounce, ok := debounced[digests[i].key]
+		if !ok {
+			continue
+		}
+		var remaining time.Duration
+		digests[i].hash, remaining = r.debouncer.observe(namespace+"/"+digests[i].key, digests[i].hash, debounce, now)
+		if remaining > 0 {
+			audit.FromContext(ctx).Source(digests[i].key, func(source *audit.Source) {
+				source.Excluded = fmt.Sprintf("change held back by debounce, settles in %s", remaining.Round(time.Second))
+			})
+		}
+		if remaining > 0 && (wait == 0 || remaining < wait) {
+			wait = remaining
+		}
+	}
+	return digests, wait
+}
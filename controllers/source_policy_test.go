@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestConfigMap(name string, labels, annotations map[string]string, data string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "matrix", Labels: labels, Annotations: annotations},
+		Data:       map[string]string{"data": data},
+	}
+}
+
+func TestClassifySource(t *testing.T) {
+	rules, err := OverrideSourceClassPolicies(DefaultSourceRules(), "ca-bundle=ignore")
+	require.NoError(t, err)
+	r := &ConfigMapReconciler{SourceRules: rules}
+
+	classified, err := r.classifySource(newTestConfigMap("homeserver", nil, nil, ""))
+	require.NoError(t, err)
+	assert.Equal(t, SourceClassAppConfig, classified.Class)
+	assert.Equal(t, SourcePolicyRestart, classified.Policy)
+
+	bundle := map[string]string{TrustManagerBundleLabel: "ca"}
+	classified, err = r.classifySource(newTestConfigMap("ca", bundle, nil, ""))
+	require.NoError(t, err)
+	assert.Equal(t, SourceClassCABundle, classified.Class)
+	assert.Equal(t, SourcePolicyIgnore, classified.Policy)
+
+	classified, err = r.classifySource(newTestConfigMap("synapse-ca-bundle", nil, nil, ""))
+	require.NoError(t, err)
+	assert.Equal(t, SourceClassCABundle, classified.Class)
+
+	classified, err = r.classifySource(newTestConfigMap("ca", bundle, map[string]string{SourcePolicyAnnotation: SourcePolicyRestart}, ""))
+	require.NoError(t, err)
+	assert.Equal(t, SourcePolicyRestart, classified.Policy)
+
+	classified, err = r.classifySource(newTestConfigMap("homeserver", nil, map[string]string{SourceClassAnnotation: SourceClassHelmRelease}, ""))
+	require.NoError(t, err)
+	assert.Equal(t, SourceClassHelmRelease, classified.Class)
+	assert.Equal(t, SourcePolicyIgnore, classified.Policy)
+
+	tls := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "synapse-tls"}, Type: corev1.SecretTypeTLS}
+	classified, err = r.classifySource(tls)
+	require.NoError(t, err)
+	assert.Equal(t, SourceClassTLSSecret, classified.Class)
+
+	release := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "sh.helm.release.v1.synapse.v3"}, Type: helmReleaseSecretType}
+	classified, err = r.classifySource(release)
+	require.NoError(t, err)
+	assert.Equal(t, SourceClassHelmRelease, classified.Class)
+
+	_, err = r.classifySource(newTestConfigMap("ca", bundle, map[string]string{SourcePolicyAnnotation: "reload"}, ""))
+	assert.Error(t, err)
+	classified, err = r.classifySource(newTestConfigMap("ca", bundle, map[string]string{SourceClassAnnotation: "unknown"}, ""))
+	assert.Error(t, err)
+	assert.Equal(t, SourceClassCABundle, classified.Class)
+}
+
+func TestOverrideSourceClassPolicies(t *testing.T) {
+	rules, err := OverrideSourceClassPolicies(DefaultSourceRules(), "ca-bundle=debounce/10m, generated=ignore")
+	require.NoError(t, err)
+	for _, rule := range rules {
+		switch rule.Class {
+		case SourceClassCABundle:
+			assert.Equal(t, 10*time.Minute, rule.Debounce)
+		case SourceClassGenerated:
+			assert.Equal(t, SourcePolicyIgnore, rule.Policy)
+		}
+	}
+
+	for _, invalid := range []string{"ca-bundle", "ca-bundle=reload", "unknown=restart", "ca-bundle=debounce/soon"} {
+		_, err := OverrideSourceClassPolicies(DefaultSourceRules(), invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSourceDebouncer(t *testing.T) {
+	d := newSourceDebouncer()
+	start := time.Now()
+
+	digest, wait := d.observe("matrix/configmap/ca", "a", time.Minute, start)
+	assert.Equal(t, "a", digest)
+	assert.Zero(t, wait)
+
+	digest, wait = d.observe("matrix/configmap/ca", "b", time.Minute, start.Add(10*time.Second))
+	assert.Equal(t, "a", digest)
+	assert.Equal(t, time.Minute, wait)
+
+	// A further rotation restarts the quiet period.
+	digest, wait = d.observe("matrix/configmap/ca", "c", time.Minute, start.Add(30*time.Second))
+	assert.Equal(t, "a", digest)
+	assert.Equal(t, time.Minute, wait)
+
+	digest, wait = d.observe("matrix/configmap/ca", "c", time.Minute, start.Add(90*time.Second))
+	assert.Equal(t, "c", digest)
+	assert.Zero(t, wait)
+}
+
+func TestComputeCombinedHashAppliesSourcePolicies(t *testing.T) {
+	ctx := context.Background()
+	bundle := map[string]string{TrustManagerBundleLabel: "ca"}
+	rules, err := OverrideSourceClassPolicies(DefaultSourceRules(), "ca-bundle=ignore")
+	require.NoError(t, err)
+
+	withBundle := newTestReconciler(t, newTestConfigMap("homeserver", nil, nil, "a"), newTestConfigMap("ca", bundle, nil, "pem"))
+	withBundle.SourceRules = rules
+	withoutBundle := newTestReconciler(t, newTestConfigMap("homeserver", nil, nil, "a"))
+
+	got, settleAfter, err := withBundle.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Zero(t, settleAfter)
+	want, _, err := withoutBundle.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+// startupSettleRemaining returns how long rollouts are still held back after the operator started, which is
+// taken to be its first reconcile: controllers only run once the caches have synced and, with leader
+// election, leadership was acquired.
+func (r *ConfigMapReconciler) startupSettleRemaining(now time.Time) time.Duration {
+	if r.StartupSettleDelay <= 0 {
+		return 0
+	}
+	r.settleOnce.Do(func() { r.settledAt = now.Add(r.StartupSettleDelay) })
+	if remaining := r.settledAt.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// startupAdoption holds the workloads whose applied hash the first pass after startup adopted in one
+// namespace, or owner group, for as long as its hash stays the one of that pass.
+type startupAdoption struct {
+	hash string
+	// behind is set while the first pass adopts every workload behind hash.
+	behind bool
+	// applied maps the key of each adopted workload to its applied hash; nil once nothing is adopted.
+	applied map[string]string
+}
+
+// adopted reports whether w, with applied hash applied, keeps that hash instead of restarting.
+func (a *startupAdoption) adopted(w *workload, applied string) bool {
+	return a != nil && applied != "" && a.applied[w.key()] == applied
+}
+
+// startupAdoption returns the adoption of the scope of pass with SuppressStartupRollouts, and whether pass
+// is its first pass since the operator started. The first pass compares the sources with the lastKnownHash
+// of the scope: when they changed since it was rolled out, the restarts catch up on that change and nothing
+// is adopted. Otherwise the workloads the previous process adopted stay adopted, and, when the hash is not
+// the last-known one, every workload behind it is adopted too, the change being the operator's own. Without
+// a lastKnownHash the first pass adopts every workload behind the hash. A hash other than the one adopted
+// ends the adoption.
+func (r *ConfigMapReconciler) startupAdoption(ctx context.Context, pass *rolloutPass) (*startupAdoption, bool, error) {
+	if !r.SuppressStartupRollouts {
+		return nil, false, nil
+	}
+	scope := r.hashScope(ctx, pass.Namespace)
+	if value, ok := r.startupAdoptions.Load(scope); ok {
+		adoption := value.(*startupAdoption)
+		adoption.behind = false
+		if adoption.hash != pass.Hash {
+			adoption.applied = nil
+		}
+		return adoption, false, nil
+	}
+
+	adoption := &startupAdoption{hash: pass.Hash}
+	versions, ok := hashedSourceVersions(pass.State.configMaps, pass.State.secrets)
+	previous, found, err := r.loadLastKnownHash(ctx, scope)
+	if err != nil {
+		return nil, false, err
+	}
+	switch {
+	case !ok:
+	case !found:
+		adoption.behind, adoption.applied = true, map[string]string{}
+	case previous.Versions == versions:
+		adoption.behind = previous.Hash != pass.Hash
+		adoption.applied = maps.Clone(previous.Adopted)
+		if adoption.applied == nil {
+			adoption.applied = map[string]string{}
+		}
+	}
+	r.startupAdoptions.Store(scope, adoption)
+	return adoption, true, nil
+}
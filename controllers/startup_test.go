@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/state"
+)
+
+func TestReconcileHoldsBackRolloutsUntilStartupSettled(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	r.StartupSettleDelay = time.Minute
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Greater(t, result.RequeueAfter, 50*time.Second)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.NotContains(t, deploy.Spec.Template.Annotations, testHashAnnotation)
+
+	r.settledAt = time.Now().Add(-time.Second)
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.Contains(t, deploy.Spec.Template.Annotations, testHashAnnotation)
+}
+
+func TestStartupSettleRemainingStartsOnFirstCall(t *testing.T) {
+	r := &ConfigMapReconciler{StartupSettleDelay: time.Minute}
+	start := time.Unix(1000, 0)
+	assert.Equal(t, time.Minute, r.startupSettleRemaining(start))
+	assert.Equal(t, 30*time.Second, r.startupSettleRemaining(start.Add(30*time.Second)))
+	assert.Zero(t, r.startupSettleRemaining(start.Add(2*time.Minute)))
+
+	assert.Zero(t, (&ConfigMapReconciler{}).startupSettleRemaining(start))
+}
+
+func TestSuppressStartupRollouts(t *testing.T) {
+	const applied = ConfigHashPrefix + "0000"
+	ctx := context.Background()
+	for name, tc := range map[string]struct {
+		// record returns the lastKnownHash of the previous process for the current sources, if any.
+		record  func(hash, versions string) *lastKnownHash
+		restart bool
+	}{
+		"no record": {
+			record: func(string, string) *lastKnownHash { return nil },
+		},
+		"hash changed without source changes": {
+			record: func(_, versions string) *lastKnownHash {
+				return &lastKnownHash{Hash: ConfigHashPrefix + "1111", Versions: versions}
+			},
+		},
+		"sources changed while down": {
+			record: func(string, string) *lastKnownHash {
+				return &lastKnownHash{Hash: ConfigHashPrefix + "1111", Versions: "before"}
+			},
+			restart: true,
+		},
+		"hash already rolled out": {
+			record: func(hash, versions string) *lastKnownHash {
+				return &lastKnownHash{Hash: hash, Versions: versions}
+			},
+			restart: true,
+		},
+		"adopted by the previous process": {
+			record: func(hash, versions string) *lastKnownHash {
+				return &lastKnownHash{Hash: hash, Versions: versions, Adopted: map[string]string{"deployment/synapse": applied}}
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+			deploy := newTestDeployment(nil)
+			deploy.Spec.Template.Annotations = map[string]string{testHashAnnotation: applied}
+			r := newTestReconciler(t, cm, deploy)
+			r.SuppressStartupRollouts = true
+			r.HashStore = state.NewMemoryStore()
+			hash, _, err := r.computeCombinedHash(ctx, "matrix")
+			require.NoError(t, err)
+			configMaps, secrets, err := r.listConfigSources(ctx, "matrix")
+			require.NoError(t, err)
+			versions, _ := hashedSourceVersions(configMaps, secrets)
+			if record := tc.record(hash, versions); record != nil {
+				raw, err := json.Marshal(record)
+				require.NoError(t, err)
+				require.NoError(t, r.HashStore.Put(ctx, lastKnownHashPrefix+"matrix", raw))
+			}
+			req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+			appliedHash := func() string {
+				require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), deploy))
+				return deploy.Spec.Template.Annotations[testHashAnnotation]
+			}
+
+			_, err = r.Reconcile(ctx, req)
+			require.NoError(t, err)
+			if tc.restart {
+				assert.Equal(t, hash, appliedHash())
+				return
+			}
+			assert.Equal(t, applied, appliedHash())
+			_, err = r.Reconcile(ctx, req)
+			require.NoError(t, err)
+			assert.Equal(t, applied, appliedHash(), "later passes keep the adopted hash")
+			status, err := r.namespaceWorkloadStatus(ctx, "matrix")
+			require.NoError(t, err)
+			assert.True(t, status.Workloads[0].Current)
+
+			r.startupAdoptions.Clear()
+			_, err = r.Reconcile(ctx, req)
+			require.NoError(t, err)
+			assert.Equal(t, applied, appliedHash(), "the next process keeps the adopted hash")
+
+			require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cm), cm))
+			cm.Data["data"] = "b"
+			require.NoError(t, r.Update(ctx, cm))
+			_, err = r.Reconcile(ctx, req)
+			require.NoError(t, err)
+			hash, _, err = r.computeCombinedHash(ctx, "matrix")
+			require.NoError(t, err)
+			assert.Equal(t, hash, appliedHash(), "the next config change rolls out")
+		})
+	}
+}
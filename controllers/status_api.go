@@ -0,0 +1,356 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// statusReadHeaderTimeout bounds how long a status API client may take to send its request headers.
+const statusReadHeaderTimeout = 10 * time.Second
+
+const (
+	// statusWaitPollInterval is how often a wait request checks on the rollout.
+	statusWaitPollInterval = 2 * time.Second
+	// statusWaitDefaultTimeout and statusWaitMaxTimeout bound how long a wait request is held open.
+	statusWaitDefaultTimeout = 5 * time.Minute
+	statusWaitMaxTimeout     = 30 * time.Minute
+)
+
+// namespaceHashStatus is the response of GET /namespaces/{namespace}/hash.
+type namespaceHashStatus struct {
+	Namespace string `json:"namespace"`
+	// Hash is the combined hash of the namespace's config sources; empty without sources.
+	Hash string `json:"hash"`
+	// RolledOut is set once every targeted workload runs Hash and is available.
+	RolledOut bool `json:"rolledOut"`
+	// Sources lists the "<kind>/<name>" keys of the sources that contributed to Hash, and DroppedSources the
+	// selected ConfigMaps and Secrets --max-sources-per-namespace left out of it.
+	Sources        []string `json:"sources,omitempty"`
+	DroppedSources []string `json:"droppedSources,omitempty"`
+}
+
+// workloadStatus describes one targeted workload in the status API.
+type workloadStatus struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Owner and Hash are the owner group of the workload and its hash, with --group-by-owner.
+	Owner       string `json:"owner,omitempty"`
+	Hash        string `json:"hash,omitempty"`
+	Strategy    string `json:"strategy"`
+	AppliedHash string `json:"appliedHash"`
+	// Current is set when AppliedHash is the namespace's hash, or that of its owner group.
+	Current   bool `json:"current"`
+	Available bool `json:"available"`
+	// Holds lists what keeps the hash from a workload that is not current.
+	Holds []string `json:"holds,omitempty"`
+}
+
+// namespaceWorkloads is the response of GET /namespaces/{namespace}/workloads and .../pending.
+type namespaceWorkloads struct {
+	Namespace string `json:"namespace"`
+	Hash      string `json:"hash"`
+	// Holds lists what keeps the whole namespace from rolling out Hash.
+	Holds     []string         `json:"holds,omitempty"`
+	Workloads []workloadStatus `json:"workloads"`
+	// Sources and DroppedSources are those of namespaceHashStatus.
+	Sources        []string `json:"sources,omitempty"`
+	DroppedSources []string `json:"droppedSources,omitempty"`
+}
+
+// statusServer serves the status API on every replica, not just the leader.
+type statusServer struct {
+	addr    string
+	handler http.Handler
+}
+
+func (s *statusServer) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *statusServer) Start(ctx context.Context) error {
+	server := &http.Server{Addr: s.addr, Handler: s.handler, ReadHeaderTimeout: statusReadHeaderTimeout}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	log.FromContext(ctx).Info("Serving status API", "address", s.addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// setupStatusAPI serves the status API on StatusAPIBindAddress.
+func (r *ConfigMapReconciler) setupStatusAPI(mgr ctrl.Manager) error {
+	return mgr.Add(&statusServer{addr: r.StatusAPIBindAddress, handler: r.statusHandler()})
+}
+
+// statusHandler serves the read-only status API CI pipelines poll to learn whether a config change has been
+// rolled out.
+func (r *ConfigMapReconciler) statusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /namespaces/{namespace}/hash", func(w http.ResponseWriter, req *http.Request) {
+		status, err := r.namespaceStatus(req.Context(), req.PathValue("namespace"))
+		if err != nil {
+			writeStatusError(w, err)
+			return
+		}
+		writeStatusJSON(w, namespaceHashStatus{Namespace: status.Namespace, Hash: status.Hash, RolledOut: status.rolledOut(status.Hash), Sources: status.Sources, DroppedSources: status.DroppedSources})
+	})
+	mux.HandleFunc("GET /namespaces/{namespace}/impact", func(w http.ResponseWriter, req *http.Request) {
+		impact, err := r.simulateImpact(req.Context(), req.PathValue("namespace"))
+		if err != nil {
+			writeStatusError(w, err)
+			return
+		}
+		writeStatusJSON(w, impact)
+	})
+	mux.HandleFunc("GET /namespaces/{namespace}/wait", r.waitForRollout)
+	mux.HandleFunc("GET /debug/effective-config", r.serveEffectiveConfig)
+	mux.HandleFunc("GET /namespaces/{namespace}/workloads", func(w http.ResponseWriter, req *http.Request) {
+		status, err := r.namespaceStatus(req.Context(), req.PathValue("namespace"))
+		if err != nil {
+			writeStatusError(w, err)
+			return
+		}
+		writeStatusJSON(w, status)
+	})
+	mux.HandleFunc("GET /namespaces/{namespace}/pending", func(w http.ResponseWriter, req *http.Request) {
+		status, err := r.namespaceStatus(req.Context(), req.PathValue("namespace"))
+		if err != nil {
+			writeStatusError(w, err)
+			return
+		}
+		pending := status.Workloads[:0]
+		for _, workload := range status.Workloads {
+			if !workload.Current {
+				pending = append(pending, workload)
+			}
+		}
+		status.Workloads = pending
+		writeStatusJSON(w, status)
+	})
+	return mux
+}
+
+// rolledOut reports whether every targeted workload runs hash and is available.
+func (s namespaceWorkloads) rolledOut(hash string) bool {
+	if hash == "" || hash != s.Hash {
+		return false
+	}
+	for _, workload := range s.Workloads {
+		if !workload.Current || !workload.Available {
+			return false
+		}
+	}
+	return true
+}
+
+// waitForRollout holds the request until every targeted workload of the namespace runs the expected hash
+// and is available, answering 200 with the hash status, or 408 once the timeout passes. The expected hash
+// is the hash query parameter, or else the current hash of the namespace at each check.
+func (r *ConfigMapReconciler) waitForRollout(w http.ResponseWriter, req *http.Request) {
+	namespace := req.PathValue("namespace")
+	timeout := statusWaitDefaultTimeout
+	if value := req.URL.Query().Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "timeout must be a positive duration such as 10m", http.StatusBadRequest)
+			return
+		}
+		timeout = min(parsed, statusWaitMaxTimeout)
+	}
+	expected := req.URL.Query().Get("hash")
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(statusWaitPollInterval)
+	defer ticker.Stop()
+	for {
+		status, err := r.namespaceStatus(ctx, namespace)
+		if err != nil && ctx.Err() == nil {
+			writeStatusError(w, err)
+			return
+		}
+		hash := expected
+		if hash == "" {
+			hash = status.Hash
+		}
+		if err == nil && status.rolledOut(hash) {
+			done := namespaceHashStatus{Namespace: namespace, Hash: hash, RolledOut: true}
+			if hash == status.Hash {
+				done.Sources, done.DroppedSources = status.Sources, status.DroppedSources
+			}
+			writeStatusJSON(w, done)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestTimeout)
+			_ = json.NewEncoder(w).Encode(namespaceHashStatus{Namespace: namespace, Hash: hash})
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// namespaceStatus reads the hash and workloads of namespace without changing anything, so it can be served
+// from any replica.
+func (r *ConfigMapReconciler) namespaceStatus(ctx context.Context, namespace string) (namespaceWorkloads, error) {
+	status, err := r.namespaceWorkloadStatus(ctx, namespace)
+	if err != nil {
+		return status, err
+	}
+	status.Holds, err = r.namespaceHolds(ctx, namespace, status.Hash)
+	return status, err
+}
+
+// namespaceWorkloadStatus is namespaceStatus without the namespace-wide holds.
+func (r *ConfigMapReconciler) namespaceWorkloadStatus(ctx context.Context, namespace string) (namespaceWorkloads, error) {
+	status := namespaceWorkloads{Namespace: namespace, Workloads: []workloadStatus{}}
+	ctx, sources := withHashedSources(ctx)
+	if r.grouped() {
+		err := r.addGroupStatus(ctx, &status)
+		status.Sources, status.DroppedSources = sources.hashed, sources.dropped
+		return status, err
+	}
+	var err error
+	if status.Hash, _, err = r.computeCombinedHash(ctx, namespace); err != nil {
+		return status, err
+	}
+	status.Sources, status.DroppedSources = sources.hashed, sources.dropped
+	workloads, err := r.listWorkloads(ctx, namespace)
+	if err != nil {
+		return status, err
+	}
+	r.addWorkloadStatus(ctx, &status, workloads, status.Hash, "")
+	return status, nil
+}
+
+// addWorkloadStatus adds workloads to status, comparing them with hash, the hash of their owner group, which
+// ctx is scoped to. Workloads whose legacy or startup hash is kept count as current.
+func (r *ConfigMapReconciler) addWorkloadStatus(ctx context.Context, status *namespaceWorkloads, workloads []*workload, hash, owner string) {
+	var legacy legacyHashMemo
+	sources := func() ([]corev1.ConfigMap, []corev1.Secret, error) {
+		return r.listConfigSources(ctx, status.Namespace)
+	}
+	for _, w := range workloads {
+		name, strategy, err := r.strategyFor(w)
+		item := workloadStatus{Kind: w.kind, Name: w.obj.GetName(), Owner: owner, Strategy: name, Available: w.available()}
+		if r.grouped() {
+			item.Hash = hash
+		}
+		if err != nil {
+			item.Holds = append(item.Holds, "restart-strategy: "+err.Error())
+			status.Workloads = append(status.Workloads, item)
+			continue
+		}
+		item.AppliedHash = strategy.appliedHash(r, w)
+		item.Current = item.AppliedHash == hash
+		if accepted, err := r.acceptsLegacyHash(ctx, status.Namespace, item.AppliedHash, hash, &legacy, sources); err == nil && accepted {
+			item.Current = true
+		}
+		if value, ok := r.startupAdoptions.Load(r.hashScope(ctx, status.Namespace)); ok && !item.Current {
+			adoption := value.(*startupAdoption)
+			item.Current = adoption.hash == hash && adoption.adopted(w, item.AppliedHash)
+		}
+		if !item.Current && r.recreateBlocked(w) {
+			item.Holds = append(item.Holds, "recreate-confirmation")
+		}
+		if !item.Current {
+			interval, _ := r.minRestartInterval(w)
+			if wait, err := r.restartRateLimitWait(ctx, w, interval, time.Now()); err == nil && wait > 0 {
+				item.Holds = append(item.Holds, fmt.Sprintf("restart-rate-limit: next restart in %s", wait.Round(time.Second)))
+			}
+			if r.DeferRestartsOnPDB {
+				if pdb, err := r.blockingPDB(ctx, w); err == nil && pdb != "" {
+					item.Holds = append(item.Holds, "pdb: "+pdb+" allows no disruption")
+				}
+			}
+		}
+		status.Workloads = append(status.Workloads, item)
+	}
+}
+
+// namespaceHolds lists the namespace-wide gates holding hash back, read from the namespace and its rollout
+// lock.
+func (r *ConfigMapReconciler) namespaceHolds(ctx context.Context, namespace, hash string) ([]string, error) {
+	var holds []string
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, ns); err == nil {
+		if ns.Annotations[RolloutsPausedAnnotation] == "true" {
+			holds = append(holds, "rollouts-paused")
+		}
+		if hash != "" && r.approvalRequired(ns) && ns.Annotations[ApprovedConfigHashAnnotation] != hash {
+			holds = append(holds, "approval-required")
+		}
+		if len(rolloutDependencies(ns)) > 0 {
+			waitingFor, err := r.pendingRolloutDependency(ctx, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if waitingFor != "" {
+				holds = append(holds, "rollout-dependency: waiting for "+waitingFor)
+			}
+		}
+		if hash != "" && len(r.RolloutTiers) > 0 {
+			hold, err := r.tierHold(ctx, ns)
+			if err != nil {
+				return nil, err
+			}
+			if hold != "" {
+				holds = append(holds, hold)
+			}
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	holds = append(holds, r.schemaHolds(namespace)...)
+	if hold := r.configCheckHold(namespace, hash); hold != "" {
+		holds = append(holds, hold)
+	}
+	if hold := r.validationJobHold(namespace, hash); hold != "" {
+		holds = append(holds, hold)
+	}
+	if position := r.queuedRolloutPosition(namespace); position > 0 {
+		holds = append(holds, fmt.Sprintf("rollout-budget: queued at position %d", position))
+	}
+	if r.RolloutLock {
+		lease := &coordinationv1.Lease{}
+		if err := r.reader().Get(ctx, client.ObjectKey{Namespace: namespace, Name: RolloutLockName}, lease); err == nil {
+			if holder, _ := RolloutLockHolder(lease, time.Now()); holder != "" {
+				holds = append(holds, "rollout-lock: held by "+holder)
+			}
+		} else if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return holds, nil
+}
+
+func writeStatusJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeStatusError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if apierrors.IsForbidden(err) {
+		code = http.StatusForbidden
+	}
+	http.Error(w, err.Error(), code)
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func getStatus(t *testing.T, handler http.Handler, path string, into any) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), into))
+}
+
+func TestStatusAPIReportsRolloutProgress(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{RolloutsPausedAnnotation: "true"}}}
+	r := newTestReconciler(t, ns, newTestDeployment(nil), newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	handler := r.statusHandler()
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+
+	var before namespaceHashStatus
+	getStatus(t, handler, "/namespaces/matrix/hash", &before)
+	assert.Equal(t, namespaceHashStatus{Namespace: "matrix", Hash: hash, Sources: []string{"configmap/homeserver"}}, before)
+
+	var pending namespaceWorkloads
+	getStatus(t, handler, "/namespaces/matrix/pending", &pending)
+	assert.Equal(t, []string{"rollouts-paused"}, pending.Holds)
+	require.Len(t, pending.Workloads, 1)
+	assert.Equal(t, workloadStatus{Kind: "Deployment", Name: "synapse", Strategy: StrategyAnnotation, Available: true}, pending.Workloads[0])
+
+	_, err = r.rolloutWorkloads(ctx, "matrix", hash, logr.Discard())
+	require.NoError(t, err)
+
+	var after namespaceHashStatus
+	getStatus(t, handler, "/namespaces/matrix/hash", &after)
+	assert.True(t, after.RolledOut)
+	getStatus(t, handler, "/namespaces/matrix/pending", &pending)
+	assert.Empty(t, pending.Workloads)
+	var workloads namespaceWorkloads
+	getStatus(t, handler, "/namespaces/matrix/workloads", &workloads)
+	require.Len(t, workloads.Workloads, 1)
+	assert.Equal(t, hash, workloads.Workloads[0].AppliedHash)
+	assert.True(t, workloads.Workloads[0].Current)
+}
+
+func TestStatusAPIRejectsWrites(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestReconciler(t).statusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/namespaces/matrix/hash", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestStatusAPIWaitsForRollout(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a"))
+	handler := r.statusHandler()
+	hash, _, err := r.computeCombinedHash(ctx, "matrix")
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/namespaces/matrix/wait?timeout=10ms", nil))
+	assert.Equal(t, http.StatusRequestTimeout, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/namespaces/matrix/wait?timeout=soon", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	_, err = r.rolloutWorkloads(ctx, "matrix", hash, logr.Discard())
+	require.NoError(t, err)
+	var done namespaceHashStatus
+	getStatus(t, handler, "/namespaces/matrix/wait?hash="+hash, &done)
+	assert.Equal(t, namespaceHashStatus{Namespace: "matrix", Hash: hash, RolledOut: true, Sources: []string{"configmap/homeserver"}}, done)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/namespaces/matrix/wait?timeout=10ms&hash=other", nil))
+	assert.Equal(t, http.StatusRequestTimeout, rec.Code, "the namespace does not run the expected hash")
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Rule label prefixes of restartsAvoidedTotal.
+const (
+	avoidedByClass          = "class:"
+	avoidedByPolicyOverride = "policy-annotation"
+	avoidedByConfigMapKey   = "configmap-key:"
+	avoidedBySecretKey      = "secret-key:"
+	// avoidedByIncludeKeys counts changes of keys left out by an include list.
+	avoidedByIncludeKeys = "include-keys"
+)
+
+// sourceKeyDigests maps each key of a config source to a digest of its value.
+type sourceKeyDigests map[string]string
+
+// countSuppressedChanges compares the sources of namespace with those seen by the previous reconcile and
+// counts every change that left the config hash untouched in restartsAvoidedTotal, by the rule that
+// suppressed it. The rules configured for the namespace start at zero, so rules that never fire show up.
+func (r *ConfigMapReconciler) countSuppressedChanges(ctx context.Context, namespace string, configMaps []corev1.ConfigMap, secrets []corev1.Secret) {
+	current := map[string]sourceKeyDigests{}
+	for i := range configMaps {
+		cfg := &configMaps[i]
+		digests := sourceKeyDigests{}
+		for key, value := range cfg.Data {
+			digests[key] = shortDigest([]byte(value))
+		}
+		for key, value := range cfg.BinaryData {
+			digests["binary:"+key] = shortDigest(value)
+		}
+		current["configmap/"+cfg.Name] = digests
+	}
+	for i := range secrets {
+		digests := sourceKeyDigests{}
+		for key, value := range secrets[i].Data {
+			digests[key] = shortDigest(value)
+		}
+		current["secret/"+secrets[i].Name] = digests
+	}
+
+	scope := namespace
+	if group, ok := r.groupScoped(ctx); ok {
+		// Each owner group sees only its own sources; the shared ones are counted by the zero group.
+		scope += "/" + string(group.uid)
+	}
+	stored, seen := r.sourceKeyDigests.Swap(scope, current)
+	if !seen {
+		r.initAvoidedRules(namespace)
+		return
+	}
+	previous := stored.(map[string]sourceKeyDigests)
+	for i := range configMaps {
+		r.countSuppressedChange(ctx, namespace, &configMaps[i], "configmap/"+configMaps[i].Name, previous, current, avoidedByConfigMapKey, r.configMapKeyFilter())
+	}
+	for i := range secrets {
+		r.countSuppressedChange(ctx, namespace, &secrets[i], "secret/"+secrets[i].Name, previous, current, avoidedBySecretKey, r.secretKeyFilter())
+	}
+}
+
+func (r *ConfigMapReconciler) countSuppressedChange(ctx context.Context, namespace string, obj client.Object, key string, previous, current map[string]sourceKeyDigests, keyRulePrefix string, keys keyFilter) {
+	before, ok := previous[key]
+	if !ok {
+		return
+	}
+	changed := changedKeys(before, current[key])
+	if len(changed) == 0 {
+		return
+	}
+
+	var rules []string
+	rule, _ := r.classifySource(obj)
+	if rule.Policy == SourcePolicyIgnore {
+		if obj.GetAnnotations()[SourcePolicyAnnotation] == SourcePolicyIgnore {
+			rules = []string{avoidedByPolicyOverride}
+		} else {
+			rules = []string{avoidedByClass + rule.Class}
+		}
+	} else {
+		keys = keys.forSource(obj)
+		for _, changedKey := range changed {
+			plain := strings.TrimPrefix(changedKey, "binary:")
+			if !keys.skips(plain) {
+				// The change reaches the hash.
+				return
+			}
+			if _, ignored := keys.ignored[plain]; ignored {
+				rules = append(rules, keyRulePrefix+plain)
+			} else {
+				rules = append(rules, avoidedByIncludeKeys)
+			}
+		}
+	}
+	log.FromContext(ctx).V(1).Info("Config source change did not change the config hash", "source", key, "rules", rules)
+	for _, rule := range rules {
+		restartsAvoidedTotal.WithLabelValues(r.ClusterName, namespace, rule).Inc()
+	}
+}
+
+// initAvoidedRules exposes every rule that can suppress a change in namespace at zero.
+func (r *ConfigMapReconciler) initAvoidedRules(namespace string) {
+	for _, rule := range r.SourceRules {
+		if rule.Policy == SourcePolicyIgnore {
+			restartsAvoidedTotal.WithLabelValues(r.ClusterName, namespace, avoidedByClass+rule.Class)
+		}
+	}
+	for key := range r.IgnoredConfigMapKeys {
+		restartsAvoidedTotal.WithLabelValues(r.ClusterName, namespace, avoidedByConfigMapKey+key)
+	}
+	for key := range r.IgnoredSecretKeys {
+		restartsAvoidedTotal.WithLabelValues(r.ClusterName, namespace, avoidedBySecretKey+key)
+	}
+	if len(r.IncludedConfigMapKeys) > 0 || len(r.IncludedSecretKeys) > 0 {
+		restartsAvoidedTotal.WithLabelValues(r.ClusterName, namespace, avoidedByIncludeKeys)
+	}
+}
+
+// changedKeys returns the keys added, removed, or changed between before and after, sorted.
+func changedKeys(before, after sourceKeyDigests) []string {
+	var changed []string
+	for key, digest := range after {
+		if before[key] != digest {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCountSuppressedChanges(t *testing.T) {
+	ctx := context.Background()
+	r := &ConfigMapReconciler{
+		SourceRules:          DefaultSourceRules(),
+		IgnoredConfigMapKeys: map[string]struct{}{"generated-at": {}, "never-changes": {}},
+	}
+	namespace := "suppressions"
+	homeserver := newTestConfigMap("homeserver", nil, nil, "a")
+	homeserver.Data["generated-at"] = "monday"
+	values := newTestConfigMap("values", nil, map[string]string{SourcePolicyAnnotation: SourcePolicyIgnore}, "v1")
+	configMaps := func() []corev1.ConfigMap { return []corev1.ConfigMap{*homeserver.DeepCopy(), *values.DeepCopy()} }
+
+	r.countSuppressedChanges(ctx, namespace, configMaps(), nil)
+	assert.Zero(t, testutil.ToFloat64(restartsAvoidedTotal.WithLabelValues("", namespace, "configmap-key:never-changes")), "configured rules start at zero")
+	assert.Zero(t, testutil.ToFloat64(restartsAvoidedTotal.WithLabelValues("", namespace, "class:helm-release")))
+
+	homeserver.Data["generated-at"] = "tuesday"
+	values.Data["data"] = "v2"
+	r.countSuppressedChanges(ctx, namespace, configMaps(), nil)
+	assert.Equal(t, float64(1), testutil.ToFloat64(restartsAvoidedTotal.WithLabelValues("", namespace, "configmap-key:generated-at")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(restartsAvoidedTotal.WithLabelValues("", namespace, avoidedByPolicyOverride)))
+
+	homeserver.Data["generated-at"] = "wednesday"
+	homeserver.Data["data"] = "b"
+	r.countSuppressedChanges(ctx, namespace, configMaps(), nil)
+	assert.Equal(t, float64(1), testutil.ToFloat64(restartsAvoidedTotal.WithLabelValues("", namespace, "configmap-key:generated-at")), "a change that reaches the hash is not suppressed")
+
+	r.countSuppressedChanges(ctx, namespace, configMaps(), nil)
+	assert.Equal(t, float64(1), testutil.ToFloat64(restartsAvoidedTotal.WithLabelValues("", namespace, "configmap-key:generated-at")), "unchanged sources count nothing")
+}
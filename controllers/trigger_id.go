@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TriggerIDAnnotation records on the metadata of a restarted workload the ID of the reconcile that
+// restarted it. The same ID annotates the Events of that reconcile and is carried by its audit record, its
+// notifications, and the exemplars of synapse_operator_workload_restarts_total, so one trigger can be traced
+// through everything it produced.
+const TriggerIDAnnotation = "synapse.gen0sec.com/trigger-id"
+
+type triggerIDKey struct{}
+
+// withTriggerID starts a trigger with a fresh ID, returned along with the context that carries it.
+func withTriggerID(ctx context.Context) (context.Context, string) {
+	id := string(uuid.NewUUID())
+	return context.WithValue(ctx, triggerIDKey{}, id), id
+}
+
+// triggerIDFrom returns the ID of the trigger ctx belongs to, or "".
+func triggerIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(triggerIDKey{}).(string)
+	return id
+}
+
+// traceEvent records an event like event, annotated with the trigger ID of ctx.
+func (r *ConfigMapReconciler) traceEvent(ctx context.Context, obj runtime.Object, eventType, reason, message string) {
+	id := triggerIDFrom(ctx)
+	if r.Recorder == nil || id == "" {
+		r.event(obj, eventType, reason, message)
+		return
+	}
+	r.Recorder.AnnotatedEventf(obj, map[string]string{TriggerIDAnnotation: id}, eventType, reason, "%s", message)
+}
+
+// stampTriggerID records the trigger ID of ctx on the metadata of the restarted workload w and counts the
+// restart, with the ID as exemplar.
+func (r *ConfigMapReconciler) stampTriggerID(ctx context.Context, w *workload, strategy string) error {
+	id := triggerIDFrom(ctx)
+	restarts := workloadRestartsTotal.WithLabelValues(r.ClusterName, w.obj.GetNamespace(), strategy)
+	if r.ClusterName != "" {
+		clusterRestartsTotal.WithLabelValues(r.ClusterName, strategy).Inc()
+	}
+	if id == "" {
+		restarts.Inc()
+		return nil
+	}
+	restarts.(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"trigger_id": id})
+	if w.obj.GetAnnotations()[TriggerIDAnnotation] == id {
+		return nil
+	}
+	original := w.obj.DeepCopyObject().(client.Object)
+	setMetadataAnnotation(w, TriggerIDAnnotation, id)
+	return r.Patch(ctx, w.obj, client.MergeFrom(original))
+}
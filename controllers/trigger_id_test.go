@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"synapse-operator/audit"
+	"synapse-operator/state"
+)
+
+func TestTriggerIDTracesRollout(t *testing.T) {
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver", nil, nil, "a"))
+	r.Audit = &audit.Log{Store: store, Retention: 10}
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	r.ReportImpact = true
+	restarts := testutil.ToFloat64(workloadRestartsTotal.WithLabelValues("", "matrix", StrategyAnnotation))
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+
+	entries, err := store.List(ctx, "audit/")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	var rec audit.Record
+	for _, raw := range entries {
+		require.NoError(t, json.Unmarshal(raw, &rec))
+	}
+	require.NotEmpty(t, rec.TriggerID)
+
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.Equal(t, rec.TriggerID, deploy.Annotations[TriggerIDAnnotation])
+	assert.Contains(t, <-recorder.Events, rec.TriggerID, "events of the reconcile carry the trigger ID")
+	assert.Equal(t, restarts+1, testutil.ToFloat64(workloadRestartsTotal.WithLabelValues("", "matrix", StrategyAnnotation)))
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/audit"
+)
+
+// ValidationJobLabel marks the Jobs the operator runs to validate a config hash.
+const ValidationJobLabel = "synapse.gen0sec.com/validation-job"
+
+// Mount points of the config sources in a validation Job, each source in a directory named after it.
+const (
+	ValidationConfigMapsPath = "/config/configmaps"
+	ValidationSecretsPath    = "/config/secrets"
+)
+
+const (
+	// validationJobPrefix starts the name of every validation Job, followed by a digest of its hash.
+	validationJobPrefix = "synapse-config-validation-"
+	// validationJobPollInterval is how often a rollout waiting on its validation Job checks on it.
+	validationJobPollInterval = 10 * time.Second
+	// defaultValidationJobTimeout bounds a validation Job when ValidationJobTimeout is not set.
+	defaultValidationJobTimeout = 5 * time.Minute
+	// validationJobTTL is how long a finished validation Job, and the logs of its pod, are kept.
+	validationJobTTL = int32(24 * 60 * 60)
+)
+
+// validationResult is the outcome of the last validation Job that finished in a namespace.
+type validationResult struct {
+	hash    string
+	job     string
+	passed  bool
+	message string
+}
+
+// validationJobGate runs a Job mounting the config sources of a new hash before it rolls out, and holds the
+// rollout until the Job succeeds. A failed Job holds the hash until the config changes.
+func (r *ConfigMapReconciler) validationJobGate(ctx context.Context, pass *rolloutPass) error {
+	behind, err := r.workloadsBehind(ctx, pass.Namespace, pass.Hash)
+	if err != nil || len(behind) == 0 {
+		return err
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: pass.Namespace}}
+	name := validationJobPrefix + shortDigest([]byte(pass.Hash))
+	job := &batchv1.Job{}
+	if err := r.reader().Get(ctx, client.ObjectKey{Namespace: pass.Namespace, Name: name}, job); apierrors.IsNotFound(err) {
+		job = r.validationJob(pass, name)
+		if err := r.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		r.event(ns, corev1.EventTypeNormal, "ValidationJobStarted", fmt.Sprintf("Validating config hash %s with Job %s before rolling it out", pass.Hash, name))
+		r.holdForValidationJob(ctx, pass, name, "running")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var result validationResult
+	switch {
+	case jobConditionTrue(job, batchv1.JobComplete):
+		result = validationResult{hash: pass.Hash, job: name, passed: true}
+	case jobConditionTrue(job, batchv1.JobFailed):
+		message, err := r.validationJobOutput(ctx, job)
+		if err != nil {
+			return err
+		}
+		result = validationResult{hash: pass.Hash, job: name, message: message}
+	default:
+		r.holdForValidationJob(ctx, pass, name, "running")
+		return nil
+	}
+	if previous, loaded := r.validationJobs.Swap(pass.Namespace, result); !loaded || previous.(validationResult).hash != pass.Hash {
+		if result.passed {
+			validationJobsTotal.WithLabelValues(r.ClusterName, pass.Namespace, "passed").Inc()
+		} else {
+			validationJobsTotal.WithLabelValues(r.ClusterName, pass.Namespace, "failed").Inc()
+			r.event(ns, corev1.EventTypeWarning, "ValidationJobFailed",
+				fmt.Sprintf("Config hash %s failed validation Job %s and is not rolled out: %s", pass.Hash, name, result.message))
+		}
+	}
+	if !result.passed {
+		r.holdForValidationJob(ctx, pass, name, "failed: "+result.message)
+	}
+	return nil
+}
+
+// holdForValidationJob halts pass while its validation Job is running, or after it failed.
+func (r *ConfigMapReconciler) holdForValidationJob(ctx context.Context, pass *rolloutPass, job, state string) {
+	pass.Logger.Info("Holding rollout until its validation Job succeeds", "job", job, "configHash", pass.Hash, "state", state)
+	audit.FromContext(ctx).AddGate(audit.Gate{Name: "validation-job", Detail: job + ": " + state})
+	if state == "running" {
+		pass.RequeueAfter(validationJobPollInterval)
+		pass.Halt("validation job running")
+		return
+	}
+	pass.Halt("validation job failed")
+}
+
+// validationJobHold reports the failed validation Job holding hash back in namespace, if any.
+func (r *ConfigMapReconciler) validationJobHold(namespace, hash string) string {
+	value, ok := r.validationJobs.Load(namespace)
+	if !ok {
+		return ""
+	}
+	if last := value.(validationResult); last.hash == hash && !last.passed {
+		return "validation-job: " + last.job + " failed: " + last.message
+	}
+	return ""
+}
+
+// validationJob builds the Job validating the config sources of pass: a single try of the configured
+// command with every ConfigMap and Secret of the namespace mounted read-only.
+func (r *ConfigMapReconciler) validationJob(pass *rolloutPass, name string) *batchv1.Job {
+	timeout := r.ValidationJobTimeout
+	if timeout <= 0 {
+		timeout = defaultValidationJobTimeout
+	}
+	container := corev1.Container{
+		Name:    "validate",
+		Image:   r.ValidationJobImage,
+		Command: r.ValidationJobCommand,
+		Env: []corev1.EnvVar{
+			{Name: "SYNAPSE_CONFIG_HASH", Value: pass.Hash},
+			{Name: "SYNAPSE_CONFIGMAPS_PATH", Value: ValidationConfigMapsPath},
+			{Name: "SYNAPSE_SECRETS_PATH", Value: ValidationSecretsPath},
+		},
+		// The tail of the output becomes the termination message, which the failure event quotes.
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	var volumes []corev1.Volume
+	for _, cm := range pass.State.configMaps {
+		volume := "configmap-" + shortDigest([]byte(cm.Name))
+		volumes = append(volumes, corev1.Volume{Name: volume, VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: cm.Name}},
+		}})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: volume, MountPath: ValidationConfigMapsPath + "/" + cm.Name, ReadOnly: true})
+	}
+	for _, secret := range pass.State.secrets {
+		volume := "secret-" + shortDigest([]byte(secret.Name))
+		volumes = append(volumes, corev1.Volume{Name: volume, VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: secret.Name},
+		}})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: volume, MountPath: ValidationSecretsPath + "/" + secret.Name, ReadOnly: true})
+	}
+	labels := map[string]string{ValidationJobLabel: "true"}
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   pass.Namespace,
+			Labels:      labels,
+			Annotations: map[string]string{r.ConfigHashAnnotation: pass.Hash},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            ptr.To(int32(0)),
+			ActiveDeadlineSeconds:   ptr.To(int64(timeout.Seconds())),
+			TTLSecondsAfterFinished: ptr.To(validationJobTTL),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: r.ValidationJobServiceAccount,
+					Containers:         []corev1.Container{container},
+					Volumes:            volumes,
+				},
+			},
+		},
+	}
+}
+
+// validationJobOutput describes why job failed, quoting the termination message of its pod, which holds
+// the tail of its output.
+func (r *ConfigMapReconciler) validationJobOutput(ctx context.Context, job *batchv1.Job) (string, error) {
+	pods := &corev1.PodList{}
+	if err := r.reader().List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{batchv1.JobNameLabel: job.Name}); err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+				output := strings.TrimSpace(terminated.Message)
+				if output == "" {
+					return fmt.Sprintf("exited with code %d", terminated.ExitCode), nil
+				}
+				return fmt.Sprintf("exited with code %d: %s", terminated.ExitCode, output), nil
+			}
+		}
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return strings.TrimSpace(condition.Reason + ": " + condition.Message), nil
+		}
+	}
+	return "failed", nil
+}
+
+// jobConditionTrue reports whether job has the condition conditionType.
+func jobConditionTrue(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	return slices.ContainsFunc(job.Status.Conditions, func(condition batchv1.JobCondition) bool {
+		return condition.Type == conditionType && condition.Status == corev1.ConditionTrue
+	})
+}
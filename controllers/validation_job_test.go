@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// finishValidationJob marks the only validation Job of matrix as finished with conditionType.
+func finishValidationJob(t *testing.T, r *ConfigMapReconciler, conditionType batchv1.JobConditionType) *batchv1.Job {
+	t.Helper()
+	jobs := &batchv1.JobList{}
+	require.NoError(t, r.List(context.Background(), jobs, client.InNamespace("matrix"), client.MatchingLabels{ValidationJobLabel: "true"}))
+	require.Len(t, jobs.Items, 1)
+	job := &jobs.Items[0]
+	job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{Type: conditionType, Status: corev1.ConditionTrue})
+	require.NoError(t, r.Status().Update(context.Background(), job))
+	return job
+}
+
+func TestValidationJobGatesRollout(t *testing.T) {
+	ctx := context.Background()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	r := newTestReconciler(t, cm, newTestDeployment(nil))
+	r.ValidationJobImage = "matrixdotorg/synapse:latest"
+	r.ValidationJobCommand = []string{"/bin/sh", "-c", "python -m synapse.config read server_name -c /config/configmaps/homeserver/data"}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, validationJobPollInterval, result.RequeueAfter)
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+
+	job := finishValidationJob(t, r, batchv1.JobComplete)
+	hash := job.Annotations[testHashAnnotation]
+	require.NotEmpty(t, hash)
+	spec := job.Spec.Template.Spec
+	require.Len(t, spec.Containers, 1)
+	assert.Equal(t, r.ValidationJobCommand, spec.Containers[0].Command)
+	assert.Equal(t, corev1.RestartPolicyNever, spec.RestartPolicy)
+	require.Len(t, spec.Volumes, 1)
+	assert.Equal(t, "homeserver", spec.Volumes[0].ConfigMap.Name)
+	assert.Equal(t, "/config/configmaps/homeserver", spec.Containers[0].VolumeMounts[0].MountPath)
+
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Equal(t, hash, deploy.Spec.Template.Annotations[testHashAnnotation])
+}
+
+func TestValidationJobFailureQuotesOutput(t *testing.T) {
+	ctx := context.Background()
+	cm := newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"}, nil, "a")
+	r := newTestReconciler(t, cm, newTestDeployment(nil))
+	r.ValidationJobImage = "matrixdotorg/synapse:latest"
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Contains(t, <-recorder.Events, "ValidationJobStarted")
+	job := finishValidationJob(t, r, batchv1.JobFailed)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-x7k2p", Namespace: "matrix", Labels: map[string]string{batchv1.JobNameLabel: job.Name}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "validate",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: "Error in configuration at 'listeners': port 8008 is used twice\n"}},
+		}}},
+	}
+	require.NoError(t, r.Create(ctx, pod))
+
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	var deploy appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation])
+	event := <-recorder.Events
+	assert.Contains(t, event, "ValidationJobFailed")
+	assert.Contains(t, event, "exited with code 1: Error in configuration at 'listeners': port 8008 is used twice")
+	assert.Equal(t, "validation-job: "+job.Name+" failed: exited with code 1: Error in configuration at 'listeners': port 8008 is used twice",
+		r.validationJobHold("matrix", job.Annotations[testHashAnnotation]))
+	assert.Equal(t, float64(1), testutil.ToFloat64(validationJobsTotal.WithLabelValues("", "matrix", "failed")))
+
+	// The failure is reported once.
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Empty(t, recorder.Events)
+}
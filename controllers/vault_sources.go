@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"synapse-operator/vault"
+)
+
+// VaultPathsAnnotation, set on a matching ConfigMap or Secret, lists Vault KV v2 secrets whose versions are
+// folded into the combined hash of its namespace, as comma-separated data paths such as
+// "secret/data/synapse/db", the form the Vault Agent injector annotations use.
+const VaultPathsAnnotation = "synapse.gen0sec.com/vault-paths"
+
+// DefaultVaultPollInterval is how often the Vault secrets of a namespace are read again by default.
+const DefaultVaultPollInterval = time.Minute
+
+// VaultReader reads the current version of a Vault KV v2 secret; *vault.Client implements it.
+type VaultReader interface {
+	Version(ctx context.Context, path string) (string, error)
+}
+
+// vaultPaths returns the Vault paths referenced by the sources, each with the first source referencing it.
+func (r *ConfigMapReconciler) vaultPaths(ctx context.Context, configMaps []corev1.ConfigMap, secrets []corev1.Secret) map[string]client.Object {
+	paths := map[string]client.Object{}
+	collect := func(obj client.Object) {
+		value, ok := obj.GetAnnotations()[VaultPathsAnnotation]
+		if !ok {
+			return
+		}
+		for _, path := range strings.Split(value, ",") {
+			path = strings.TrimSpace(path)
+			if path == "" {
+				continue
+			}
+			if _, err := vault.MetadataPath(path); err != nil {
+				log.FromContext(ctx).Error(err, "ignoring invalid vault path", "source", client.ObjectKeyFromObject(obj))
+				r.event(obj, corev1.EventTypeWarning, "InvalidVaultPath", err.Error())
+				continue
+			}
+			if _, ok := paths[path]; !ok {
+				paths[path] = obj
+			}
+		}
+	}
+	for i := range configMaps {
+		collect(&configMaps[i])
+	}
+	for i := range secrets {
+		collect(&secrets[i])
+	}
+	return paths
+}
+
+// vaultDigests digests the current version of each Vault secret the sources reference. A secret that cannot
+// be read fails the whole hash rather than dropping out of it, which would trigger a rollout; a secret that
+// does not exist is left out, like a deleted source.
+func (r *ConfigMapReconciler) vaultDigests(ctx context.Context, configMaps []corev1.ConfigMap, secrets []corev1.Secret) ([]sourceDigest, error) {
+	if r.Vault == nil {
+		return nil, nil
+	}
+	paths := r.vaultPaths(ctx, configMaps, secrets)
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	digests := make([]sourceDigest, 0, len(sorted))
+	for _, path := range sorted {
+		version, err := r.Vault.Version(ctx, path)
+		if errors.Is(err, vault.ErrNotFound) {
+			log.FromContext(ctx).Info("Vault secret not found", "vaultPath", path)
+			continue
+		}
+		if err != nil {
+			r.event(paths[path], corev1.EventTypeWarning, "VaultSecretUnreadable", fmt.Sprintf("Cannot read the version of %s: %v", path, err))
+			return nil, err
+		}
+		sum := sha256.Sum256([]byte(version))
+		digests = append(digests, sourceDigest{key: "vault/" + path, hash: hex.EncodeToString(sum[:])})
+	}
+	return digests, nil
+}
+
+// vaultPollInterval returns how long until the Vault secrets referenced by the sources are read again, or
+// zero when there are none.
+func (r *ConfigMapReconciler) vaultPollInterval(configMaps []corev1.ConfigMap, secrets []corev1.Secret) time.Duration {
+	if r.Vault == nil {
+		return 0
+	}
+	referenced := false
+	for i := range configMaps {
+		_, ok := configMaps[i].Annotations[VaultPathsAnnotation]
+		referenced = referenced || ok
+	}
+	for i := range secrets {
+		_, ok := secrets[i].Annotations[VaultPathsAnnotation]
+		referenced = referenced || ok
+	}
+	if !referenced {
+		return 0
+	}
+	if r.VaultPollInterval <= 0 {
+		return DefaultVaultPollInterval
+	}
+	return r.VaultPollInterval
+}
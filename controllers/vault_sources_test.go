@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/vault"
+)
+
+// fakeVault serves versions by path; a missing path is not found.
+type fakeVault map[string]string
+
+func (v fakeVault) Version(_ context.Context, path string) (string, error) {
+	if version, ok := v[path]; ok {
+		return version, nil
+	}
+	return "", vault.ErrNotFound
+}
+
+func TestVaultRotationRollsWorkloads(t *testing.T) {
+	ctx := context.Background()
+	secrets := fakeVault{"secret/data/synapse/db": "1@2026-10-01T12:00:00Z"}
+	r := newTestReconciler(t, newTestDeployment(nil), newTestConfigMap("homeserver",
+		map[string]string{"app.kubernetes.io/name": "synapse"},
+		map[string]string{VaultPathsAnnotation: "secret/data/synapse/db, secret/data/synapse/missing"}, "a"))
+	r.Vault = secrets
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+	applied := func() string {
+		var deploy appsv1.Deployment
+		require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+		return deploy.Spec.Template.Annotations[testHashAnnotation]
+	}
+
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultVaultPollInterval, result.RequeueAfter, "the Vault secrets are polled")
+	before := applied()
+	require.NotEmpty(t, before)
+
+	secrets["secret/data/synapse/db"] = "2@2026-10-02T12:00:00Z"
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.NotEqual(t, before, applied(), "a new version rolls the workloads")
+}
+
+func TestVaultReadFailureFailsTheHash(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestConfigMap("homeserver", map[string]string{"app.kubernetes.io/name": "synapse"},
+		map[string]string{VaultPathsAnnotation: "secret/data/synapse/db,not-a-kv2-path"}, "a"))
+	r.Vault = unreadableVault{}
+
+	_, _, err := r.computeCombinedHash(ctx, "matrix")
+	assert.ErrorIs(t, err, vault.ErrPermissionDenied)
+}
+
+type unreadableVault struct{}
+
+func (unreadableVault) Version(context.Context, string) (string, error) {
+	return "", vault.ErrPermissionDenied
+}
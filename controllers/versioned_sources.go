@@ -0,0 +1,402 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// StrategyVersioned copies each config source the pod template references into an immutable ConfigMap or
+// Secret named after its content, and points the pod template at the copies, so every pod runs the exact
+// config it was created with and rolling back the workload rolls back its config.
+const StrategyVersioned = "versioned"
+
+const (
+	// VersionedFromLabel marks an immutable copy made by the versioned strategy with the name of the source it
+	// was copied from. Copies are never config sources themselves.
+	VersionedFromLabel = "synapse.gen0sec.com/versioned-from"
+	// versionedSourcesAnnotation, on the pod template, maps each source the versioned strategy rewrote, as
+	// "<configmap|secret>/<name>", to the copy the template references instead.
+	versionedSourcesAnnotation = "synapse.gen0sec.com/versioned-sources"
+)
+
+// DefaultVersionedCopiesRetention is the number of copies of each source kept by default.
+const DefaultVersionedCopiesRetention = 3
+
+// versionedCopyHashLength is the number of hex digits of the content hash suffixed to copy names.
+const versionedCopyHashLength = 10
+
+type versionedStrategy struct{}
+
+func (versionedStrategy) appliedHash(r *ConfigMapReconciler, w *workload) string {
+	return w.template.Annotations[r.ConfigHashAnnotation]
+}
+
+// inject does nothing: the copies cannot be created from the admission webhook, so a workload created
+// referencing its sources directly is rolled onto copies by its first reconcile.
+func (versionedStrategy) inject(*ConfigMapReconciler, *workload, string) {}
+
+func (versionedStrategy) requestRestart(r *ConfigMapReconciler, w *workload, at time.Time) {
+	stampRestartedAt(r, w, at)
+}
+
+func (s versionedStrategy) apply(ctx context.Context, r *ConfigMapReconciler, w *workload, hash string) (restartOutcome, error) {
+	// The copies are only made for a new hash: a change to keys left out of the hash, which the copies would
+	// still pick up, must not roll the workload.
+	if s.appliedHash(r, w) == hash {
+		return restartOutcome{}, r.pruneVersionedCopies(ctx, w.obj.GetNamespace())
+	}
+	configMaps, secrets, err := r.listSelectedSources(ctx, w.obj.GetNamespace())
+	if err != nil {
+		return restartOutcome{}, err
+	}
+	// The copies carry the values.
+	if secrets, err = r.readSecretData(ctx, secrets); err != nil {
+		return restartOutcome{}, err
+	}
+	selectedConfigMaps := make(map[string]*corev1.ConfigMap, len(configMaps))
+	for i := range configMaps {
+		selectedConfigMaps[configMaps[i].Name] = &configMaps[i]
+	}
+	selectedSecrets := make(map[string]*corev1.Secret, len(secrets))
+	for i := range secrets {
+		selectedSecrets[secrets[i].Name] = &secrets[i]
+	}
+
+	previous := parseVersionedSources(w.template.Annotations[versionedSourcesAnnotation])
+	origins := make(map[string]string, len(previous))
+	for source, copyName := range previous {
+		kind, _, _ := strings.Cut(source, "/")
+		origins[kind+"/"+copyName] = source
+	}
+	// source resolves a reference of the pod template to the source it stands for.
+	source := func(kind, name string) string {
+		if origin, ok := origins[kind+"/"+name]; ok {
+			return origin
+		}
+		return kind + "/" + name
+	}
+
+	referencedConfigMaps := map[string]struct{}{}
+	referencedSecrets := map[string]struct{}{}
+	collectPodSpecSources(&w.template.Spec, referencedConfigMaps, referencedSecrets)
+	copies := make(map[string]string, len(previous))
+	for _, name := range sortedKeys(referencedConfigMaps) {
+		src := source("configmap", name)
+		cm, ok := selectedConfigMaps[strings.TrimPrefix(src, "configmap/")]
+		if !ok {
+			if copyName, rewritten := previous[src]; rewritten {
+				copies[src] = copyName
+			}
+			continue
+		}
+		copyName, err := r.ensureConfigMapCopy(ctx, cm)
+		if err != nil {
+			return restartOutcome{}, err
+		}
+		copies[src] = copyName
+	}
+	for _, name := range sortedKeys(referencedSecrets) {
+		src := source("secret", name)
+		secret, ok := selectedSecrets[strings.TrimPrefix(src, "secret/")]
+		if !ok {
+			if copyName, rewritten := previous[src]; rewritten {
+				copies[src] = copyName
+			}
+			continue
+		}
+		copyName, err := r.ensureSecretCopy(ctx, secret)
+		if err != nil {
+			return restartOutcome{}, err
+		}
+		copies[src] = copyName
+	}
+
+	mapping := formatVersionedSources(copies)
+	original := w.obj.DeepCopyObject().(client.Object)
+	renamePodSpecSources(&w.template.Spec, func(kind, name string) string {
+		if copyName, ok := copies[source(kind, name)]; ok {
+			return copyName
+		}
+		return name
+	})
+	if mapping == "" {
+		delete(w.template.Annotations, versionedSourcesAnnotation)
+	} else {
+		setTemplateAnnotation(w, versionedSourcesAnnotation, mapping)
+	}
+	setTemplateAnnotation(w, r.ConfigHashAnnotation, hash)
+	stampRestartCause(ctx, w)
+	// Renamed env references change containers, which may be trimmed in the cache; a strategic merge patch
+	// only sends the renamed references.
+	if err := r.Patch(ctx, w.obj, client.StrategicMergeFrom(original)); err != nil {
+		return restartOutcome{}, err
+	}
+	return restartOutcome{updated: true}, r.pruneVersionedCopies(ctx, w.obj.GetNamespace())
+}
+
+// versionedCopyName names the copy of source holding content with contentHash, truncating source so the
+// name stays a valid object name.
+func versionedCopyName(source, contentHash string) string {
+	if contentHash == "" {
+		sum := sha256.Sum256(nil)
+		contentHash = hex.EncodeToString(sum[:])
+	}
+	suffix := "-" + contentHash[:versionedCopyHashLength]
+	const maxNameLength = 253
+	if len(source)+len(suffix) > maxNameLength {
+		source = strings.TrimRight(source[:maxNameLength-len(suffix)], "-.")
+	}
+	return source + suffix
+}
+
+// ensureConfigMapCopy creates the immutable copy of the current content of cm, if it does not exist yet, and
+// returns its name. Every key is copied, whether or not it contributes to the config hash.
+func (r *ConfigMapReconciler) ensureConfigMapCopy(ctx context.Context, cm *corev1.ConfigMap) (string, error) {
+	immutable := true
+	copied := &corev1.ConfigMap{
+		ObjectMeta: versionedCopyMeta(cm, hashConfigMapContent(cm, keyFilter{})),
+		Data:       cm.Data,
+		BinaryData: cm.BinaryData,
+		Immutable:  &immutable,
+	}
+	return copied.Name, r.createVersionedCopy(ctx, copied)
+}
+
+// ensureSecretCopy creates the immutable copy of the current content of secret, if it does not exist yet,
+// and returns its name.
+func (r *ConfigMapReconciler) ensureSecretCopy(ctx context.Context, secret *corev1.Secret) (string, error) {
+	immutable := true
+	copied := &corev1.Secret{
+		ObjectMeta: versionedCopyMeta(secret, hashSecretContent(secret, keyFilter{})),
+		Type:       secret.Type,
+		Data:       secret.Data,
+		Immutable:  &immutable,
+	}
+	return copied.Name, r.createVersionedCopy(ctx, copied)
+}
+
+// versionedCopyMeta returns the metadata of the copy of source. The copy carries none of the source's labels,
+// so that no selector picks it up in place of the source.
+func versionedCopyMeta(source client.Object, contentHash string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      versionedCopyName(source.GetName(), contentHash),
+		Namespace: source.GetNamespace(),
+		Labels:    map[string]string{VersionedFromLabel: source.GetName()},
+	}
+}
+
+// createVersionedCopy creates copied. Copies are named after their content, so one that already exists holds
+// the same content.
+func (r *ConfigMapReconciler) createVersionedCopy(ctx context.Context, copied client.Object) error {
+	if err := r.Create(ctx, copied); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	log.FromContext(ctx).Info("created versioned config copy", "copy", copied.GetName(), "source", copied.GetLabels()[VersionedFromLabel])
+	return nil
+}
+
+// pruneVersionedCopies deletes the copies of each source in namespace beyond the VersionedCopiesRetention
+// newest, keeping any copy a workload, ReplicaSet or Pod in the namespace still references, so rolling a
+// workload back rolls back its config. A retention of zero keeps every copy.
+func (r *ConfigMapReconciler) pruneVersionedCopies(ctx context.Context, namespace string) error {
+	if r.VersionedCopiesRetention <= 0 {
+		return nil
+	}
+	referencedConfigMaps, referencedSecrets, err := r.configRevisionRefs(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	opts := []client.ListOption{client.InNamespace(namespace), client.HasLabels{VersionedFromLabel}}
+	configMaps := &corev1.ConfigMapList{}
+	if err := r.List(ctx, configMaps, opts...); err != nil {
+		return err
+	}
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, opts...); err != nil {
+		return err
+	}
+	var stale []client.Object
+	copies := make([]client.Object, 0, len(configMaps.Items))
+	for i := range configMaps.Items {
+		copies = append(copies, &configMaps.Items[i])
+	}
+	stale = append(stale, r.staleVersionedCopies(copies, referencedConfigMaps)...)
+	copies = make([]client.Object, 0, len(secrets.Items))
+	for i := range secrets.Items {
+		copies = append(copies, &secrets.Items[i])
+	}
+	stale = append(stale, r.staleVersionedCopies(copies, referencedSecrets)...)
+
+	for _, obj := range stale {
+		if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		log.FromContext(ctx).Info("pruned versioned config copy", "copy", obj.GetName(), "source", obj.GetLabels()[VersionedFromLabel])
+	}
+	return nil
+}
+
+// namespaceSourceRefs returns the names of the ConfigMaps and Secrets referenced by any workload in namespace,
+// targeted or not: a workload released from the operator, or in another owner group, may still run on copies.
+func (r *ConfigMapReconciler) namespaceSourceRefs(ctx context.Context, namespace string) (map[string]struct{}, map[string]struct{}, error) {
+	configMaps := map[string]struct{}{}
+	secrets := map[string]struct{}{}
+	for _, kind := range r.workloadKinds() {
+		list := kind.newList()
+		if err := r.List(ctx, list, client.InNamespace(namespace)); err != nil {
+			return nil, nil, err
+		}
+		if err := meta.EachListItem(list, func(item runtime.Object) error {
+			obj, ok := item.(client.Object)
+			if !ok {
+				return nil
+			}
+			cms, secretNames := podTemplateSourceRefs(obj)
+			for _, name := range cms {
+				configMaps[name] = struct{}{}
+			}
+			for _, name := range secretNames {
+				secrets[name] = struct{}{}
+			}
+			return nil
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return configMaps, secrets, nil
+}
+
+// staleVersionedCopies returns the copies, all of one kind, beyond the retention of their source that are
+// not referenced.
+func (r *ConfigMapReconciler) staleVersionedCopies(copies []client.Object, referenced map[string]struct{}) []client.Object {
+	bySource := map[string][]client.Object{}
+	for _, obj := range copies {
+		source := obj.GetLabels()[VersionedFromLabel]
+		bySource[source] = append(bySource[source], obj)
+	}
+	var stale []client.Object
+	for _, versions := range bySource {
+		sort.Slice(versions, func(i, j int) bool {
+			ti, tj := versions[i].GetCreationTimestamp(), versions[j].GetCreationTimestamp()
+			if !ti.Equal(&tj) {
+				return tj.Before(&ti)
+			}
+			return versions[i].GetName() > versions[j].GetName()
+		})
+		for _, obj := range versions[min(len(versions), r.VersionedCopiesRetention):] {
+			if _, ok := referenced[obj.GetName()]; !ok {
+				stale = append(stale, obj)
+			}
+		}
+	}
+	return stale
+}
+
+// isVersionedCopy reports whether obj is a copy made by the versioned strategy.
+func isVersionedCopy(obj client.Object) bool {
+	_, ok := obj.GetLabels()[VersionedFromLabel]
+	return ok
+}
+
+// parseVersionedSources parses the value of versionedSourcesAnnotation.
+func parseVersionedSources(value string) map[string]string {
+	copies := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		source, copyName, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && source != "" && copyName != "" {
+			copies[source] = copyName
+		}
+	}
+	return copies
+}
+
+// formatVersionedSources formats copies as the value of versionedSourcesAnnotation.
+func formatVersionedSources(copies map[string]string) string {
+	entries := make([]string, 0, len(copies))
+	for source, copyName := range copies {
+		entries = append(entries, source+"="+copyName)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// collectVersionedSources adds the names of the sources the versioned strategy replaced with copies in
+// template, so the workload still counts as referencing them.
+func collectVersionedSources(template *corev1.PodTemplateSpec, configMaps, secrets map[string]struct{}) {
+	for source := range parseVersionedSources(template.Annotations[versionedSourcesAnnotation]) {
+		kind, name, _ := strings.Cut(source, "/")
+		switch kind {
+		case "configmap":
+			configMaps[name] = struct{}{}
+		case "secret":
+			secrets[name] = struct{}{}
+		}
+	}
+}
+
+// renamePodSpecSources replaces every ConfigMap and Secret name spec mounts or reads env from with what
+// rename returns for it, called with kind "configmap" or "secret".
+func renamePodSpecSources(spec *corev1.PodSpec, rename func(kind, name string) string) {
+	for i := range spec.Volumes {
+		volume := &spec.Volumes[i]
+		if volume.ConfigMap != nil {
+			volume.ConfigMap.Name = rename("configmap", volume.ConfigMap.Name)
+		}
+		if volume.Secret != nil {
+			volume.Secret.SecretName = rename("secret", volume.Secret.SecretName)
+		}
+		if volume.Projected != nil {
+			for j := range volume.Projected.Sources {
+				source := &volume.Projected.Sources[j]
+				if source.ConfigMap != nil {
+					source.ConfigMap.Name = rename("configmap", source.ConfigMap.Name)
+				}
+				if source.Secret != nil {
+					source.Secret.Name = rename("secret", source.Secret.Name)
+				}
+			}
+		}
+	}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			container := &containers[i]
+			for j := range container.EnvFrom {
+				envFrom := &container.EnvFrom[j]
+				if envFrom.ConfigMapRef != nil {
+					envFrom.ConfigMapRef.Name = rename("configmap", envFrom.ConfigMapRef.Name)
+				}
+				if envFrom.SecretRef != nil {
+					envFrom.SecretRef.Name = rename("secret", envFrom.SecretRef.Name)
+				}
+			}
+			for j := range container.Env {
+				valueFrom := container.Env[j].ValueFrom
+				if valueFrom == nil {
+					continue
+				}
+				if valueFrom.ConfigMapKeyRef != nil {
+					valueFrom.ConfigMapKeyRef.Name = rename("configmap", valueFrom.ConfigMapKeyRef.Name)
+				}
+				if valueFrom.SecretKeyRef != nil {
+					valueFrom.SecretKeyRef.Name = rename("secret", valueFrom.SecretKeyRef.Name)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestVersionedDeployment() *appsv1.Deployment {
+	deploy := newTestDeployment(map[string]string{RestartStrategyAnnotation: StrategyVersioned})
+	deploy.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name:         "config",
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "homeserver"}}},
+	}}
+	deploy.Spec.Template.Spec.Containers = []corev1.Container{{
+		Name:    "synapse",
+		EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "keys"}}}},
+		Env: []corev1.EnvVar{{Name: "EXTERNAL", ValueFrom: &corev1.EnvVarSource{
+			ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "unselected"}, Key: "value"},
+		}}},
+	}}
+	return deploy
+}
+
+func TestVersionedStrategyPointsTemplateAtImmutableCopies(t *testing.T) {
+	ctx := context.Background()
+	selected := map[string]string{"app.kubernetes.io/name": "synapse"}
+	keys := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: "matrix", Labels: selected},
+		Data:       map[string][]byte{"SIGNING_KEY": []byte("k1")},
+	}
+	r := newTestReconciler(t, newTestVersionedDeployment(), newTestConfigMap("homeserver", selected, nil, "a"), keys)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}}
+	deployment := func() *appsv1.Deployment {
+		var deploy appsv1.Deployment
+		require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "synapse"}, &deploy))
+		return &deploy
+	}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	deploy := deployment()
+	configName := deploy.Spec.Template.Spec.Volumes[0].ConfigMap.Name
+	secretName := deploy.Spec.Template.Spec.Containers[0].EnvFrom[0].SecretRef.Name
+	assert.Regexp(t, `^homeserver-[0-9a-f]{10}$`, configName)
+	assert.Regexp(t, `^keys-[0-9a-f]{10}$`, secretName)
+	assert.Equal(t, "unselected", deploy.Spec.Template.Spec.Containers[0].Env[0].ValueFrom.ConfigMapKeyRef.Name, "unselected sources are left alone")
+	assert.Equal(t, "configmap/homeserver="+configName+",secret/keys="+secretName, deploy.Spec.Template.Annotations[versionedSourcesAnnotation])
+	hash := deploy.Spec.Template.Annotations[testHashAnnotation]
+	require.NotEmpty(t, hash)
+
+	var copied corev1.ConfigMap
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: configName}, &copied))
+	assert.Equal(t, map[string]string{"data": "a"}, copied.Data)
+	require.NotNil(t, copied.Immutable)
+	assert.True(t, *copied.Immutable)
+	assert.Equal(t, map[string]string{VersionedFromLabel: "homeserver"}, copied.Labels)
+
+	refs, secretRefs := podTemplateSourceRefs(deploy)
+	assert.Contains(t, refs, "homeserver", "the workload still references the source it copied")
+	assert.Contains(t, secretRefs, "keys")
+
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, hash, deployment().Spec.Template.Annotations[testHashAnnotation], "the copies do not feed the hash")
+
+	var homeserver corev1.ConfigMap
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "homeserver"}, &homeserver))
+	homeserver.Data["data"] = "b"
+	require.NoError(t, r.Update(ctx, &homeserver))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	deploy = deployment()
+	assert.NotEqual(t, hash, deploy.Spec.Template.Annotations[testHashAnnotation])
+	assert.NotEqual(t, configName, deploy.Spec.Template.Spec.Volumes[0].ConfigMap.Name, "a new version gets a new copy")
+	assert.Equal(t, secretName, deploy.Spec.Template.Spec.Containers[0].EnvFrom[0].SecretRef.Name, "an unchanged source keeps its copy")
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: configName}, &copied), "the previous copy is kept for rollbacks")
+}
+
+func TestVersionedCopiesArePrunedBeyondRetention(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	oldCopy := func(name string, age time.Duration) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "matrix",
+			Labels:            map[string]string{VersionedFromLabel: "homeserver"},
+			CreationTimestamp: metav1.NewTime(base.Add(-age)),
+		}}
+	}
+	deploy := newTestVersionedDeployment()
+	// The oldest copy is still referenced, by a workload the operator released.
+	other := newTestDeployment(nil)
+	other.Name = "media"
+	other.Labels = nil
+	other.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name:         "config",
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "homeserver-0000000001"}}},
+	}}
+	r := newTestReconciler(t, deploy, other, newTestConfigMap("homeserver", nil, nil, "a"),
+		oldCopy("homeserver-0000000001", 4*time.Hour), oldCopy("homeserver-0000000002", 3*time.Hour),
+		oldCopy("homeserver-0000000003", 2*time.Hour), oldCopy("homeserver-0000000004", time.Hour))
+	r.VersionedCopiesRetention = 2
+
+	w := deploymentWorkload(deploy)
+	outcome, err := versionedStrategy{}.apply(ctx, r, w, "abc")
+	require.NoError(t, err)
+	assert.True(t, outcome.updated)
+
+	exists := func(name string) bool {
+		err := r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: name}, &corev1.ConfigMap{})
+		if apierrors.IsNotFound(err) {
+			return false
+		}
+		require.NoError(t, err)
+		return true
+	}
+	assert.True(t, exists(w.template.Spec.Volumes[0].ConfigMap.Name), "the copy in use is kept")
+	assert.True(t, exists("homeserver-0000000004"))
+	assert.True(t, exists("homeserver-0000000003"))
+	assert.False(t, exists("homeserver-0000000002"), "copies beyond the retention are pruned")
+	assert.True(t, exists("homeserver-0000000001"), "a referenced copy is kept")
+}
+
+func TestVersionedCopyName(t *testing.T) {
+	assert.Equal(t, "homeserver-0123456789", versionedCopyName("homeserver", "0123456789abcdef"))
+	long := versionedCopyName(strings.Repeat("a", 300), "0123456789abcdef")
+	assert.Len(t, long, 253)
+}
+
+func TestVersionedAnnotationRefusedInConformanceMode(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestVersionedDeployment()
+	r := newTestReconciler(t, deploy, newTestConfigMap("homeserver", nil, nil, "a"))
+	r.ConformanceMode = true
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "matrix", Name: "homeserver"}})
+	require.NoError(t, err)
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), deploy))
+	assert.Empty(t, deploy.Spec.Template.Annotations[testHashAnnotation], "the workload is held, not restarted")
+	copies := &corev1.ConfigMapList{}
+	require.NoError(t, r.List(ctx, copies, client.HasLabels{VersionedFromLabel}))
+	assert.Empty(t, copies.Items, "no copy is written outside the allow-list")
+
+	r.RestartStrategy = StrategyVersioned
+	_, _, err = r.strategyFor(deploymentWorkload(deploy))
+	assert.NoError(t, err, "the default strategy grants the writes")
+}
+
+func TestVersionedCopiesOfOldReplicaSetsAreKept(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	meta := func(name, source string, age time.Duration) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "matrix",
+			Labels:            map[string]string{VersionedFromLabel: source},
+			CreationTimestamp: metav1.NewTime(base.Add(-age)),
+		}
+	}
+	// The ReplicaSet `kubectl rollout undo` would return to still runs on the oldest copies.
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "synapse-1", Namespace: "matrix"},
+		Spec: appsv1.ReplicaSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{
+				Name:         "config",
+				VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "homeserver-0000000001"}}},
+			}},
+			Containers: []corev1.Container{{
+				Name:    "synapse",
+				EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "keys-0000000001"}}}},
+			}},
+		}}},
+	}
+	r := newTestReconciler(t, replicaSet,
+		&corev1.ConfigMap{ObjectMeta: meta("homeserver-0000000001", "homeserver", 3*time.Hour)},
+		&corev1.ConfigMap{ObjectMeta: meta("homeserver-0000000002", "homeserver", 2*time.Hour)},
+		&corev1.ConfigMap{ObjectMeta: meta("homeserver-0000000003", "homeserver", time.Hour)},
+		&corev1.Secret{ObjectMeta: meta("keys-0000000001", "keys", 2*time.Hour)},
+		&corev1.Secret{ObjectMeta: meta("keys-0000000002", "keys", time.Hour)})
+	r.VersionedCopiesRetention = 1
+
+	require.NoError(t, r.pruneVersionedCopies(ctx, "matrix"))
+	for name, kept := range map[string]bool{
+		"homeserver-0000000001": true,
+		"homeserver-0000000002": false,
+		"homeserver-0000000003": true,
+	} {
+		err := r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: name}, &corev1.ConfigMap{})
+		assert.Equal(t, !kept, apierrors.IsNotFound(err), name)
+	}
+	require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: "keys-0000000001"}, &corev1.Secret{}), "the Secret copy of the old ReplicaSet is kept")
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/yaml"
+)
+
+// WorkerTopologyAnnotation on a ConfigMap makes its WorkerTopologyKey the desired worker topology of the
+// Synapse Deployment the annotation names: the worker Deployments are copies of it.
+const WorkerTopologyAnnotation = "synapse.gen0sec.com/worker-topology"
+
+// WorkerTopologyKey is the ConfigMap key holding the worker topology.
+const WorkerTopologyKey = "upstreams.yaml"
+
+// Labels of the worker Deployments: WorkerTopologyLabel names the topology ConfigMap they belong to and
+// WorkerNameLabel the upstream they run, also on their pods and in their selector.
+const (
+	WorkerTopologyLabel = "synapse.gen0sec.com/worker-topology"
+	WorkerNameLabel     = "synapse.gen0sec.com/worker"
+)
+
+// DefaultWorkerApp is the Synapse worker app of an upstream that names none.
+const DefaultWorkerApp = "synapse.app.generic_worker"
+
+// Environment variables set on the first container of a worker: the Synapse image starts the app named in
+// SYNAPSE_WORKER, and the worker config reads its worker_name from SYNAPSE_WORKER_NAME.
+const (
+	workerAppEnv  = "SYNAPSE_WORKER"
+	workerNameEnv = "SYNAPSE_WORKER_NAME"
+)
+
+// workerTopology is the WorkerTopologyKey document.
+type workerTopology struct {
+	Upstreams []workerUpstream `json:"upstreams"`
+}
+
+// workerUpstream declares one worker Deployment.
+type workerUpstream struct {
+	Name string `json:"name"`
+	// App is the Synapse worker app; DefaultWorkerApp when empty.
+	App string `json:"app,omitempty"`
+	// Replicas defaults to 1.
+	Replicas *int32 `json:"replicas,omitempty"`
+}
+
+// parseWorkerTopology parses and validates a worker topology.
+func parseWorkerTopology(data string) (workerTopology, error) {
+	var topology workerTopology
+	if err := yaml.UnmarshalStrict([]byte(data), &topology); err != nil {
+		return topology, err
+	}
+	seen := map[string]bool{}
+	for i := range topology.Upstreams {
+		upstream := &topology.Upstreams[i]
+		if errs := validation.IsDNS1123Label(upstream.Name); len(errs) > 0 {
+			return topology, fmt.Errorf("upstream %q: %s", upstream.Name, strings.Join(errs, ", "))
+		}
+		if seen[upstream.Name] {
+			return topology, fmt.Errorf("upstream %q is declared twice", upstream.Name)
+		}
+		seen[upstream.Name] = true
+		if upstream.App == "" {
+			upstream.App = DefaultWorkerApp
+		}
+		if upstream.Replicas == nil {
+			upstream.Replicas = ptr.To[int32](1)
+		}
+		if *upstream.Replicas < 0 {
+			return topology, fmt.Errorf("upstream %q: replicas must not be negative", upstream.Name)
+		}
+	}
+	return topology, nil
+}
+
+// WorkerTopologyReconciler treats the WorkerTopologyKey of ConfigMaps annotated with WorkerTopologyAnnotation
+// as a desired-state document: it creates a worker Deployment, a copy of the annotated Synapse Deployment,
+// for every upstream declared in it, scales it to the declared replicas, and deletes the worker Deployments
+// of upstreams no longer declared. Worker Deployments keep their pod template once created, so the config
+// operator rolls them like any other workload, and are owned by the ConfigMap so deleting it removes them.
+// Requests are keyed by ConfigMap.
+type WorkerTopologyReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+func (r *WorkerTopologyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("configMap", req.NamespacedName)
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(ctx, req.NamespacedName, cm); err != nil {
+		// Deleting the ConfigMap garbage-collects its workers.
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	existing := &appsv1.DeploymentList{}
+	if err := r.List(ctx, existing, client.InNamespace(cm.Namespace), client.MatchingLabels{WorkerTopologyLabel: cm.Name}); err != nil {
+		return ctrl.Result{}, err
+	}
+	workers := map[string]*appsv1.Deployment{}
+	for i := range existing.Items {
+		workers[existing.Items[i].Labels[WorkerNameLabel]] = &existing.Items[i]
+	}
+
+	var upstreams []workerUpstream
+	if templateName := cm.Annotations[WorkerTopologyAnnotation]; templateName != "" {
+		topology, err := parseWorkerTopology(cm.Data[WorkerTopologyKey])
+		if err != nil {
+			r.event(cm, corev1.EventTypeWarning, "InvalidWorkerTopology", fmt.Sprintf("Leaving the workers unchanged: %s: %v", WorkerTopologyKey, err))
+			return ctrl.Result{}, nil
+		}
+		upstreams = topology.Upstreams
+		if len(upstreams) > 0 {
+			template := &appsv1.Deployment{}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: cm.Namespace, Name: templateName}, template); err != nil {
+				if apierrors.IsNotFound(err) {
+					r.event(cm, corev1.EventTypeWarning, "InvalidWorkerTopology", fmt.Sprintf("Leaving the workers unchanged: Deployment %s does not exist", templateName))
+					return ctrl.Result{}, nil
+				}
+				return ctrl.Result{}, err
+			}
+			for _, upstream := range upstreams {
+				if err := r.ensureWorker(ctx, cm, template, upstream, workers[upstream.Name]); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+		}
+	}
+
+	declared := map[string]bool{}
+	for _, upstream := range upstreams {
+		declared[upstream.Name] = true
+	}
+	for name, worker := range workers {
+		if declared[name] {
+			continue
+		}
+		if err := r.Delete(ctx, worker); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("deleting worker Deployment %s: %w", worker.Name, err)
+		}
+		logger.Info("Deleted worker Deployment", "deployment", worker.Name, "upstream", name)
+		r.event(cm, corev1.EventTypeNormal, "WorkerDeleted", fmt.Sprintf("Deleted worker Deployment %s: upstream %s is no longer declared", worker.Name, name))
+	}
+	return ctrl.Result{}, nil
+}
+
+// ensureWorker creates the worker Deployment of upstream from template, or scales the existing one.
+func (r *WorkerTopologyReconciler) ensureWorker(ctx context.Context, cm *corev1.ConfigMap, template *appsv1.Deployment, upstream workerUpstream, worker *appsv1.Deployment) error {
+	logger := log.FromContext(ctx)
+	if worker == nil {
+		created, err := r.newWorkerDeployment(cm, template, upstream)
+		if err != nil {
+			return err
+		}
+		if err := r.Create(ctx, created); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				r.event(cm, corev1.EventTypeWarning, "WorkerConflict", fmt.Sprintf("Deployment %s exists and does not belong to this worker topology; rename upstream %s or remove it", created.Name, upstream.Name))
+				return nil
+			}
+			return fmt.Errorf("creating worker Deployment %s: %w", created.Name, err)
+		}
+		logger.Info("Created worker Deployment", "deployment", created.Name, "upstream", upstream.Name, "replicas", *upstream.Replicas)
+		r.event(cm, corev1.EventTypeNormal, "WorkerCreated", fmt.Sprintf("Created worker Deployment %s running %s with %d replica(s)", created.Name, upstream.App, *upstream.Replicas))
+		return nil
+	}
+	if ptr.Deref(worker.Spec.Replicas, 1) == *upstream.Replicas {
+		return nil
+	}
+	original := worker.DeepCopy()
+	from := ptr.Deref(worker.Spec.Replicas, 1)
+	worker.Spec.Replicas = ptr.To(*upstream.Replicas)
+	if err := r.Patch(ctx, worker, client.MergeFrom(original)); err != nil {
+		return fmt.Errorf("scaling worker Deployment %s: %w", worker.Name, err)
+	}
+	logger.Info("Scaled worker Deployment", "deployment", worker.Name, "upstream", upstream.Name, "from", from, "to", *upstream.Replicas)
+	r.event(cm, corev1.EventTypeNormal, "WorkerScaled", fmt.Sprintf("Scaled worker Deployment %s from %d to %d replica(s)", worker.Name, from, *upstream.Replicas))
+	return nil
+}
+
+// newWorkerDeployment copies template into the worker Deployment of upstream, owned by cm. The worker's
+// labels, selector and pod labels add WorkerNameLabel, so its pods are never selected by the template's
+// Deployment.
+func (r *WorkerTopologyReconciler) newWorkerDeployment(cm *corev1.ConfigMap, template *appsv1.Deployment, upstream workerUpstream) (*appsv1.Deployment, error) {
+	labels := maps.Clone(template.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[WorkerTopologyLabel] = cm.Name
+	labels[WorkerNameLabel] = upstream.Name
+	spec := *template.Spec.DeepCopy()
+	spec.Replicas = ptr.To(*upstream.Replicas)
+	if spec.Selector == nil {
+		spec.Selector = &metav1.LabelSelector{}
+	}
+	if spec.Selector.MatchLabels == nil {
+		spec.Selector.MatchLabels = map[string]string{}
+	}
+	spec.Selector.MatchLabels[WorkerNameLabel] = upstream.Name
+	if spec.Template.Labels == nil {
+		spec.Template.Labels = map[string]string{}
+	}
+	spec.Template.Labels[WorkerNameLabel] = upstream.Name
+	if len(spec.Template.Spec.Containers) > 0 {
+		container := &spec.Template.Spec.Containers[0]
+		container.Env = setEnv(container.Env, workerAppEnv, upstream.App)
+		container.Env = setEnv(container.Env, workerNameEnv, upstream.Name)
+	}
+	worker := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        template.Name + "-" + upstream.Name,
+			Namespace:   template.Namespace,
+			Labels:      labels,
+			Annotations: maps.Clone(template.Annotations),
+		},
+		Spec: spec,
+	}
+	// The operator's bookkeeping on the template's metadata belongs to the template.
+	for _, key := range []string{ManagedByAnnotation, RolloutHistoryAnnotation, TriggerIDAnnotation, ConfigSourcesAnnotation, HelmCoalescedHashAnnotation} {
+		delete(worker.Annotations, key)
+	}
+	return worker, controllerutil.SetControllerReference(cm, worker, r.Scheme())
+}
+
+// setEnv sets name to value in env, replacing an existing variable of that name.
+func setEnv(env []corev1.EnvVar, name, value string) []corev1.EnvVar {
+	for i := range env {
+		if env[i].Name == name {
+			env[i] = corev1.EnvVar{Name: name, Value: value}
+			return env
+		}
+	}
+	return append(env, corev1.EnvVar{Name: name, Value: value})
+}
+
+func (r *WorkerTopologyReconciler) event(obj client.Object, eventType, reason, message string) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(obj, eventType, reason, message)
+}
+
+// SetupWithManager reconciles a topology ConfigMap whenever it changes, and whenever one of its worker
+// Deployments changes, so a worker scaled or deleted by hand is put back.
+func (r *WorkerTopologyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	annotated := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		_, ok := obj.GetAnnotations()[WorkerTopologyAnnotation]
+		return ok
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("worker-topology").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(predicate.Or(annotated, predicate.AnnotationChangedPredicate{}))).
+		Owns(&appsv1.Deployment{}).
+		Complete(r)
+}
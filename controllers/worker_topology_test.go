@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestParseWorkerTopology(t *testing.T) {
+	topology, err := parseWorkerTopology("upstreams:\n- name: sync\n  replicas: 2\n- name: federation-sender\n  app: synapse.app.federation_sender\n")
+	require.NoError(t, err)
+	require.Len(t, topology.Upstreams, 2)
+	assert.Equal(t, DefaultWorkerApp, topology.Upstreams[0].App)
+	assert.Equal(t, int32(2), *topology.Upstreams[0].Replicas)
+	assert.Equal(t, int32(1), *topology.Upstreams[1].Replicas)
+
+	for _, invalid := range []string{
+		"upstreams:\n- name: Sync\n",
+		"upstreams:\n- name: sync\n- name: sync\n",
+		"upstreams:\n- name: sync\n  replicas: -1\n",
+		"upstreams:\n- name: sync\n  replica: 2\n",
+	} {
+		_, err := parseWorkerTopology(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestWorkerTopologyReconciler(t *testing.T) {
+	ctx := context.Background()
+	topology := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "workers", Namespace: "matrix", UID: "cm-uid", Annotations: map[string]string{WorkerTopologyAnnotation: "synapse"}},
+		Data:       map[string]string{WorkerTopologyKey: "upstreams:\n- name: sync\n  replicas: 2\n- name: media\n"},
+	}
+	synapse := newTestDeployment(map[string]string{ManagedByAnnotation: managedByValue})
+	synapse.Spec.Template.Spec.Containers = []corev1.Container{{Name: "synapse", Env: []corev1.EnvVar{{Name: "SYNAPSE_WORKER", Value: "synapse.app.homeserver"}}}}
+	recorder := record.NewFakeRecorder(10)
+	r := &WorkerTopologyReconciler{Client: newTestReconciler(t, topology, synapse).Client, Recorder: recorder}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(topology)}
+	worker := func(name string) *appsv1.Deployment {
+		var deploy appsv1.Deployment
+		err := r.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: name}, &deploy)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		require.NoError(t, err)
+		return &deploy
+	}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	sync := worker("synapse-sync")
+	require.NotNil(t, sync)
+	assert.Equal(t, int32(2), *sync.Spec.Replicas)
+	assert.Equal(t, map[string]string{"app.kubernetes.io/name": "synapse", WorkerTopologyLabel: "workers", WorkerNameLabel: "sync"}, sync.Labels)
+	assert.Equal(t, map[string]string{"app": "synapse", WorkerNameLabel: "sync"}, sync.Spec.Selector.MatchLabels)
+	assert.Equal(t, map[string]string{"app": "synapse", WorkerNameLabel: "sync"}, sync.Spec.Template.Labels)
+	assert.Equal(t, []corev1.EnvVar{{Name: "SYNAPSE_WORKER", Value: DefaultWorkerApp}, {Name: "SYNAPSE_WORKER_NAME", Value: "sync"}}, sync.Spec.Template.Spec.Containers[0].Env)
+	assert.NotContains(t, sync.Annotations, ManagedByAnnotation)
+	require.Len(t, sync.OwnerReferences, 1)
+	assert.Equal(t, "workers", sync.OwnerReferences[0].Name)
+	require.NotNil(t, worker("synapse-media"))
+	assert.Equal(t, "synapse.app.homeserver", worker("synapse").Spec.Template.Spec.Containers[0].Env[0].Value, "the template is left alone")
+	assert.Equal(t, "Normal WorkerCreated Created worker Deployment synapse-sync running synapse.app.generic_worker with 2 replica(s)", <-recorder.Events)
+	<-recorder.Events
+
+	topology.Data[WorkerTopologyKey] = "upstreams:\n- name: sync\n  replicas: 4\n"
+	require.NoError(t, r.Update(ctx, topology))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), *worker("synapse-sync").Spec.Replicas)
+	assert.Nil(t, worker("synapse-media"), "an upstream no longer declared is deleted")
+	assert.Equal(t, "Normal WorkerScaled Scaled worker Deployment synapse-sync from 2 to 4 replica(s)", <-recorder.Events)
+	assert.Equal(t, "Normal WorkerDeleted Deleted worker Deployment synapse-media: upstream media is no longer declared", <-recorder.Events)
+
+	topology.Data[WorkerTopologyKey] = "upstreams: [sync"
+	require.NoError(t, r.Update(ctx, topology))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.NotNil(t, worker("synapse-sync"), "an invalid document leaves the workers alone")
+	assert.Contains(t, <-recorder.Events, "Warning InvalidWorkerTopology")
+
+	delete(topology.Annotations, WorkerTopologyAnnotation)
+	require.NoError(t, r.Update(ctx, topology))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Nil(t, worker("synapse-sync"), "removing the annotation removes the workers")
+}
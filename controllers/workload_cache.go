@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// TrimWorkload returns a cache transform for Deployments, DaemonSets and StatefulSets that drops what the
+// operator never reads from them: the last-applied configuration, every container field but the name, image
+// and environment, and, unless keepManagedFields is set, the managed fields. The status, which availability
+// and progress are judged from, is kept. Helm coalescing dates writes from the managed fields, so keep them
+// with HelmCoalesceWindow.
+//
+// Writes to trimmed workloads must be patches computed from the cached object, never updates, and changes to
+// containers strategic merge patches, which only send the fields that changed.
+func TrimWorkload(keepManagedFields bool) toolscache.TransformFunc {
+	return func(obj interface{}) (interface{}, error) {
+		var spec *corev1.PodSpec
+		switch w := obj.(type) {
+		case *appsv1.Deployment:
+			spec = &w.Spec.Template.Spec
+		case *appsv1.DaemonSet:
+			spec = &w.Spec.Template.Spec
+		case *appsv1.StatefulSet:
+			spec = &w.Spec.Template.Spec
+		default:
+			return obj, nil
+		}
+		meta := obj.(metav1.Object)
+		delete(meta.GetAnnotations(), lastAppliedConfigAnnotation)
+		if !keepManagedFields {
+			meta.SetManagedFields(nil)
+		}
+		spec.InitContainers = trimContainers(spec.InitContainers)
+		spec.Containers = trimContainers(spec.Containers)
+		return obj, nil
+	}
+}
+
+// trimContainers keeps the fields of containers the operator reads: name, image and environment.
+func trimContainers(containers []corev1.Container) []corev1.Container {
+	for i, c := range containers {
+		containers[i] = corev1.Container{Name: c.Name, Image: c.Image, Env: c.Env, EnvFrom: c.EnvFrom}
+	}
+	return containers
+}
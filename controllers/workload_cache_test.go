@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestTrimmableDeployment() *appsv1.Deployment {
+	deploy := newTestEnvDeployment(map[string]string{lastAppliedConfigAnnotation: "{}"})
+	deploy.Spec.Template.Spec.Containers[0].Image = "matrixdotorg/synapse:v1.120.0"
+	deploy.Spec.Template.Spec.Containers[0].Args = []string{"run"}
+	deploy.Spec.Template.Spec.Containers[0].ReadinessProbe = &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"true"}}},
+	}
+	return deploy
+}
+
+func TestTrimWorkload(t *testing.T) {
+	deploy := newTestTrimmableDeployment()
+	deploy.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: helmFieldManager, Time: &metav1.Time{Time: time.Now()}}}
+
+	obj, err := TrimWorkload(false)(deploy.DeepCopy())
+	require.NoError(t, err)
+	trimmed := obj.(*appsv1.Deployment)
+	assert.Equal(t, corev1.Container{
+		Name:  "synapse",
+		Image: "matrixdotorg/synapse:v1.120.0",
+		Env:   []corev1.EnvVar{{Name: "SYNAPSE_SERVER_NAME", Value: "example.com"}},
+	}, trimmed.Spec.Template.Spec.Containers[0])
+	assert.NotContains(t, trimmed.Annotations, lastAppliedConfigAnnotation)
+	assert.Empty(t, trimmed.ManagedFields)
+	assert.Equal(t, deploy.Status, trimmed.Status)
+
+	obj, err = TrimWorkload(true)(deploy.DeepCopy())
+	require.NoError(t, err)
+	assert.Len(t, obj.(*appsv1.Deployment).ManagedFields, 1, "Helm coalescing keeps the managed fields")
+}
+
+func TestEnvStrategyKeepsTrimmedContainerFields(t *testing.T) {
+	ctx := context.Background()
+	deploy := newTestTrimmableDeployment()
+	r := newTestReconciler(t, deploy)
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), deploy))
+	obj, err := TrimWorkload(false)(deploy.DeepCopy())
+	require.NoError(t, err)
+
+	_, err = envStrategy{}.apply(ctx, r, deploymentWorkload(obj.(*appsv1.Deployment)), "abc")
+	require.NoError(t, err)
+	var patched appsv1.Deployment
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(deploy), &patched))
+	container := patched.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, []string{"run"}, container.Args)
+	assert.NotNil(t, container.ReadinessProbe)
+	assert.Equal(t, "abc", container.Env[1].Value)
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// workload adapts Deployments, DaemonSets, StatefulSets, and CronJobs to the handful of operations restart
+// strategies need, so strategies are written once instead of per kind.
+type workload struct {
+	kind string
+	obj  client.Object
+	// template points into obj, so mutations are reflected in patches built from obj.
+	template *corev1.PodTemplateSpec
+	// selector selects the pods of the workload; nil for CronJobs.
+	selector *metav1.LabelSelector
+	// available reports whether every desired replica is updated and available.
+	available func() bool
+}
+
+func (w *workload) logKey() string {
+	return strings.ToLower(w.kind)
+}
+
+// key identifies the workload within its namespace as "<kind>/<name>".
+func (w *workload) key() string {
+	return w.logKey() + "/" + w.obj.GetName()
+}
+
+func deploymentWorkload(deploy *appsv1.Deployment) *workload {
+	return &workload{
+		kind:     "Deployment",
+		obj:      deploy,
+		template: &deploy.Spec.Template,
+		selector: deploy.Spec.Selector,
+		available: func() bool {
+			desired := int32(1)
+			if deploy.Spec.Replicas != nil {
+				desired = *deploy.Spec.Replicas
+			}
+			return deploy.Status.ObservedGeneration >= deploy.Generation &&
+				deploy.Status.UpdatedReplicas >= desired &&
+				deploy.Status.AvailableReplicas >= desired
+		},
+	}
+}
+
+func daemonSetWorkload(daemonSet *appsv1.DaemonSet) *workload {
+	return &workload{
+		kind:     "DaemonSet",
+		obj:      daemonSet,
+		template: &daemonSet.Spec.Template,
+		selector: daemonSet.Spec.Selector,
+		available: func() bool {
+			desired := daemonSet.Status.DesiredNumberScheduled
+			return daemonSet.Status.ObservedGeneration >= daemonSet.Generation &&
+				daemonSet.Status.UpdatedNumberScheduled >= desired &&
+				daemonSet.Status.NumberAvailable >= desired
+		},
+	}
+}
+
+func statefulSetWorkload(statefulSet *appsv1.StatefulSet) *workload {
+	return &workload{
+		kind:     "StatefulSet",
+		obj:      statefulSet,
+		template: &statefulSet.Spec.Template,
+		selector: statefulSet.Spec.Selector,
+		available: func() bool {
+			desired := int32(1)
+			if statefulSet.Spec.Replicas != nil {
+				desired = *statefulSet.Spec.Replicas
+			}
+			return statefulSet.Status.ObservedGeneration >= statefulSet.Generation &&
+				statefulSet.Status.UpdatedReplicas >= desired &&
+				statefulSet.Status.ReadyReplicas >= desired
+		},
+	}
+}
+
+// listWorkloads returns the Deployments, DaemonSets, StatefulSets, and with ManageCronJobs CronJobs in
+// namespace matching the selector, or running a detected image. With GroupByOwner or GroupByComponent and
+// ctx scoped to a group, only the workloads of that group are returned.
+func (r *ConfigMapReconciler) listWorkloads(ctx context.Context, namespace string) ([]*workload, error) {
+	workloads, err := r.findWorkloads(ctx, r.workloadListOptions(namespace)...)
+	if err != nil {
+		return nil, err
+	}
+	return r.scopeWorkloads(ctx, namespace, workloads)
+}
+
+// findWorkloads lists the workloads of each kind in workloadKinds selected by opts, keeping only those
+// running a detected image when image detection is enabled.
+func (r *ConfigMapReconciler) findWorkloads(ctx context.Context, opts ...client.ListOption) ([]*workload, error) {
+	var workloads []*workload
+	for _, kind := range r.workloadKinds() {
+		list := kind.newList()
+		if err := r.List(ctx, list, opts...); err != nil {
+			return nil, err
+		}
+		if err := meta.EachListItem(list, func(item runtime.Object) error {
+			workloads = append(workloads, kind.wrap(item.(client.Object)))
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	workloads = slices.DeleteFunc(workloads, func(w *workload) bool { return r.namespaceExcluded(w.obj.GetNamespace()) })
+	if r.DetectByImage != "" {
+		detected := workloads[:0]
+		for _, w := range workloads {
+			if r.runsDetectedImage(w) {
+				detected = append(detected, w)
+			}
+		}
+		workloads = detected
+	}
+	return workloads, nil
+}
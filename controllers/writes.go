@@ -0,0 +1,68 @@
+package controllers
+
+// WriteOptions are the settings that decide which annotations the operator writes on workloads.
+type WriteOptions struct {
+	ConfigHashAnnotation  string
+	RestartedAtAnnotation string
+	// RolloutHistory is set when --rollout-history-size keeps the history annotation.
+	RolloutHistory      bool
+	ManageCronJobs      bool
+	RestartInFlightJobs bool
+	// RestartCause is set when --annotate-restart-cause records the restart cause on pod templates.
+	RestartCause bool
+}
+
+// WorkloadWrite lists what the operator patches on one workload kind.
+type WorkloadWrite struct {
+	Group string
+	Kind  string
+	// Metadata and Template are the annotations written on the object metadata and on its pod template.
+	Metadata []string
+	Template []string
+	// Fields are other fields written, as JSON paths.
+	Fields []string
+}
+
+// WorkloadWrites describes the patches the operator may make on workloads with opts, for admission policy
+// exemptions. The restart strategy can be overridden per workload, so the annotations of every strategy
+// are included.
+func WorkloadWrites(opts WriteOptions) []WorkloadWrite {
+	restartedAt := opts.RestartedAtAnnotation
+	if restartedAt == "" {
+		restartedAt = DefaultRestartedAtAnnotation
+	}
+	metadata := []string{ManagedByAnnotation, opts.ConfigHashAnnotation, restartRequestedAtAnnotation, canaryHashAnnotation, RestartNowHandledAnnotation, namespaceRestartNowHandledAnnotation}
+	if opts.RolloutHistory {
+		metadata = append(metadata, RolloutHistoryAnnotation)
+	}
+	template := []string{opts.ConfigHashAnnotation, restartedAt}
+	if opts.RestartCause {
+		template = append(template, RestartCauseAnnotation, RestartCauseTimeAnnotation)
+	}
+
+	writes := []WorkloadWrite{
+		{Group: "apps", Kind: "Deployment", Metadata: metadata, Template: template},
+		{Group: "apps", Kind: "DaemonSet", Metadata: metadata, Template: template},
+		{Group: "apps", Kind: "StatefulSet", Metadata: metadata, Template: template},
+	}
+	if opts.ManageCronJobs {
+		cronJobMetadata := []string{ManagedByAnnotation}
+		if opts.RolloutHistory {
+			cronJobMetadata = append(cronJobMetadata, RolloutHistoryAnnotation)
+		}
+		cronJobTemplate := []string{opts.ConfigHashAnnotation}
+		if opts.RestartCause {
+			cronJobTemplate = append(cronJobTemplate, RestartCauseAnnotation, RestartCauseTimeAnnotation)
+		}
+		writes = append(writes, WorkloadWrite{Group: "batch", Kind: "CronJob", Metadata: cronJobMetadata, Template: cronJobTemplate})
+	}
+	if opts.RestartInFlightJobs {
+		writes = append(writes, WorkloadWrite{
+			Group:    "batch",
+			Kind:     "Job",
+			Metadata: []string{jobSuspendedForAnnotation, opts.ConfigHashAnnotation},
+			Fields:   []string{"spec.suspend"},
+		})
+	}
+	return writes
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// evictByZone evicts the oldest outdated pod of each zone in which no pod of w is restarting, so a StatefulSet
+// replicated across zones, such as a stream writer, never loses more than one replica per zone to the
+// rollout and keeps its quorum. A pod is restarting while it is being deleted or is not ready. Pods on nodes
+// without the zone label share one zone, and a pod not yet scheduled holds back every zone, since the zone it
+// will land in is unknown.
+func (r *ConfigMapReconciler) evictByZone(ctx context.Context, w *workload, stale []*corev1.Pod, hash string) error {
+	pods, err := r.workloadPods(ctx, w)
+	if err != nil {
+		return err
+	}
+	zones := map[string]string{}
+	busy := map[string]bool{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp == nil && podReady(pod) {
+			continue
+		}
+		if pod.Spec.NodeName == "" {
+			return nil
+		}
+		zone, err := r.nodeZone(ctx, pod.Spec.NodeName, zones)
+		if err != nil {
+			return err
+		}
+		busy[zone] = true
+	}
+	for _, pod := range stale {
+		zone, err := r.nodeZone(ctx, pod.Spec.NodeName, zones)
+		if err != nil {
+			return err
+		}
+		if busy[zone] {
+			continue
+		}
+		busy[zone] = true
+		if err := r.evictPod(ctx, w, pod, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeZone returns the ZoneTopologyKey label of node, memoized in zones. A node that is gone or unnamed has
+// no zone.
+func (r *ConfigMapReconciler) nodeZone(ctx context.Context, node string, zones map[string]string) (string, error) {
+	if node == "" {
+		return "", nil
+	}
+	if zone, ok := zones[node]; ok {
+		return zone, nil
+	}
+	obj := &corev1.Node{}
+	if err := r.reader().Get(ctx, client.ObjectKey{Name: node}, obj); err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	zones[node] = obj.Labels[r.ZoneTopologyKey]
+	return zones[node], nil
+}
+
+// podReady reports whether pod has the Ready condition.
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
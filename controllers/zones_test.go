@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestZonedPod(name, node string, age time.Duration) *corev1.Pod {
+	pod := newTestPod(name, time.Now().Add(-age), true)
+	pod.Spec.NodeName = node
+	return pod
+}
+
+func TestEvictStrategyRestartsOnePodPerZone(t *testing.T) {
+	ctx := context.Background()
+	replicas := int32(4)
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "stream-writer",
+			Namespace:   "matrix",
+			Annotations: map[string]string{RestartStrategyAnnotation: StrategyEvict},
+			Labels:      map[string]string{"app.kubernetes.io/name": "synapse"},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "synapse"}},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "synapse"}}},
+		},
+	}
+	node := func(name, zone string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{corev1.LabelTopologyZone: zone}}}
+	}
+	r := newTestReconciler(t, statefulSet, node("node-a", "a"), node("node-b", "b"), node("node-c", "c"),
+		newTestZonedPod("stream-writer-0", "node-a", 4*time.Hour), newTestZonedPod("stream-writer-1", "node-a", 3*time.Hour),
+		newTestZonedPod("stream-writer-2", "node-b", 2*time.Hour), newTestZonedPod("stream-writer-3", "node-b", time.Hour))
+	r.ZoneTopologyKey = corev1.LabelTopologyZone
+	remaining := func() []string {
+		var pods corev1.PodList
+		require.NoError(t, r.List(ctx, &pods, client.InNamespace("matrix")))
+		var names []string
+		for _, pod := range pods.Items {
+			names = append(names, pod.Name)
+		}
+		return names
+	}
+
+	outcome, err := evictStrategy{}.apply(ctx, r, statefulSetWorkload(statefulSet), "abc")
+	require.NoError(t, err)
+	assert.True(t, outcome.updated)
+	assert.Equal(t, evictRetryInterval, outcome.requeueAfter)
+	assert.ElementsMatch(t, []string{"stream-writer-1", "stream-writer-3"}, remaining(), "the oldest pod of each zone is evicted")
+
+	// The replacement in zone a is not ready yet; zone b may move on, zone a waits.
+	replacement := newTestZonedPod("stream-writer-0", "node-a", 0)
+	replacement.Status.Conditions = nil
+	require.NoError(t, r.Create(ctx, replacement))
+	require.NoError(t, r.Create(ctx, newTestZonedPod("stream-writer-2", "node-b", 0)))
+	current := &appsv1.StatefulSet{}
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(statefulSet), current))
+	_, err = evictStrategy{}.apply(ctx, r, statefulSetWorkload(current), "abc")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"stream-writer-0", "stream-writer-1", "stream-writer-2"}, remaining())
+
+	// A pod not yet scheduled could land in any zone and holds back every eviction.
+	pending := newTestZonedPod("stream-writer-3", "", 0)
+	pending.Status.Conditions = nil
+	require.NoError(t, r.Create(ctx, pending))
+	require.NoError(t, r.Delete(ctx, replacement))
+	require.NoError(t, r.Create(ctx, newTestZonedPod("stream-writer-0", "node-a", 0)))
+	_, err = evictStrategy{}.apply(ctx, r, statefulSetWorkload(current), "abc")
+	require.NoError(t, err)
+	assert.Contains(t, remaining(), "stream-writer-1")
+}
@@ -0,0 +1,237 @@
+// Package dryrun previews the operator's writes against a real API server. Client sends every patch, update
+// and server-side apply as a server-side dry run first and logs the diff between the live object and what
+// the server would store, so the content of new restart strategies can be checked without guessing.
+package dryrun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Modes accepted by --dry-run-patches.
+const (
+	// ModeOff sends writes unchanged.
+	ModeOff = "off"
+	// ModeLog dry-runs and logs the diff of every patch and update, then sends it for real.
+	ModeLog = "log"
+	// ModeOnly dry-runs every write and never sends it for real; patches and updates are still diffed.
+	ModeOnly = "only"
+)
+
+// ValidMode reports whether mode is a known --dry-run-patches mode.
+func ValidMode(mode string) bool {
+	return mode == ModeOff || mode == ModeLog || mode == ModeOnly
+}
+
+// Client previews the writes made through the wrapped client. Reads pass through.
+type Client struct {
+	client.Client
+	only bool
+}
+
+// NewClient wraps c for ModeLog or, with only set, ModeOnly.
+func NewClient(c client.Client, only bool) *Client {
+	return &Client{Client: c, only: only}
+}
+
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if c.only {
+		opts = append(opts, client.DryRunAll)
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	preview := obj.DeepCopyObject().(client.Object)
+	if err := c.Client.Update(ctx, preview, append(opts, client.DryRunAll)...); err != nil {
+		c.logFailure(obj, "update", err)
+		if c.only {
+			return err
+		}
+	} else {
+		c.logDiff(ctx, obj, preview, "update")
+	}
+	if c.only {
+		return copyInto(obj, preview)
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	preview := obj.DeepCopyObject().(client.Object)
+	if err := c.Client.Patch(ctx, preview, patch, append(opts, client.DryRunAll)...); err != nil {
+		c.logFailure(obj, "patch", err)
+		if c.only {
+			return err
+		}
+	} else {
+		c.logDiff(ctx, obj, preview, "patch")
+	}
+	if c.only {
+		return copyInto(obj, preview)
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// Apply dry-runs a server-side apply first. An apply configuration holds nothing but the fields the operator
+// sets, so it is logged as-is rather than diffed.
+func (c *Client) Apply(ctx context.Context, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+	encoded, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	preview := &unstructured.Unstructured{}
+	if err := preview.UnmarshalJSON(encoded); err != nil {
+		return err
+	}
+	logger := ctrl.Log.WithName("dry-run").WithValues("verb", "apply", "namespace", preview.GetNamespace(), "name", preview.GetName())
+	// The dry run writes what the server would store into the configuration, which must stay intact for the
+	// real apply; in ModeOnly it is returned to the caller, as for patches.
+	dryRun := client.ApplyConfigurationFromUnstructured(preview)
+	if c.only {
+		dryRun = obj
+	}
+	if err := c.Client.Apply(ctx, dryRun, append(opts, client.DryRunAll)...); err != nil {
+		logger.Error(err, "Dry run rejected by the API server")
+		if c.only {
+			return err
+		}
+	} else if configuration, err := yaml.JSONToYAML(encoded); err == nil {
+		logger.Info("Dry run: the API server would accept this apply", "kind", preview.GetKind(), "configuration", string(configuration))
+	}
+	if c.only {
+		return nil
+	}
+	return c.Client.Apply(ctx, obj, opts...)
+}
+
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if c.only {
+		opts = append(opts, client.DryRunAll)
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *Client) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	if c.only {
+		opts = append(opts, client.DryRunAll)
+	}
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *Client) Status() client.SubResourceWriter {
+	return c.SubResource("status")
+}
+
+func (c *Client) SubResource(subResource string) client.SubResourceClient {
+	return &subResourceClient{SubResourceClient: c.Client.SubResource(subResource), only: c.only}
+}
+
+// subResourceClient dry-runs subresource writes, such as evictions, in ModeOnly.
+type subResourceClient struct {
+	client.SubResourceClient
+	only bool
+}
+
+func (s *subResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	if s.only {
+		opts = append(opts, client.DryRunAll)
+	}
+	return s.SubResourceClient.Create(ctx, obj, subResource, opts...)
+}
+
+func (s *subResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	if s.only {
+		opts = append(opts, client.DryRunAll)
+	}
+	return s.SubResourceClient.Update(ctx, obj, opts...)
+}
+
+func (s *subResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	if s.only {
+		opts = append(opts, client.DryRunAll)
+	}
+	return s.SubResourceClient.Patch(ctx, obj, patch, opts...)
+}
+
+// logDiff logs the difference between the live obj and preview, the object the server would store.
+func (c *Client) logDiff(ctx context.Context, obj, preview client.Object, verb string) {
+	logger := ctrl.Log.WithName("dry-run").WithValues("verb", verb, "namespace", obj.GetNamespace(), "name", obj.GetName())
+	live := obj.DeepCopyObject().(client.Object)
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), live); err != nil {
+		logger.Error(err, "failed to read live object for dry-run diff")
+		return
+	}
+	diff, err := Diff(live, preview)
+	if err != nil {
+		logger.Error(err, "failed to render dry-run diff")
+		return
+	}
+	if diff == "" {
+		logger.Info("Dry run: no change")
+		return
+	}
+	logger.Info("Dry run: the API server would apply this change", "kind", fmt.Sprintf("%T", obj), "diff", diff)
+}
+
+func (c *Client) logFailure(obj client.Object, verb string, err error) {
+	ctrl.Log.WithName("dry-run").Error(err, "Dry run rejected by the API server", "verb", verb, "namespace", obj.GetNamespace(), "name", obj.GetName())
+}
+
+// Diff renders a unified diff between the YAML of before and after, leaving out the fields every write
+// changes (resourceVersion, managedFields).
+func Diff(before, after runtime.Object) (string, error) {
+	a, err := comparableYAML(before)
+	if err != nil {
+		return "", err
+	}
+	b, err := comparableYAML(after)
+	if err != nil {
+		return "", err
+	}
+	if a == b {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: "live",
+		ToFile:   "dry-run",
+		Context:  2,
+	})
+}
+
+func comparableYAML(obj runtime.Object) (string, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return "", err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetManagedFields(nil)
+	u.SetResourceVersion("")
+	encoded, err := yaml.Marshal(u.Object)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(encoded)) + "\n", nil
+}
+
+// copyInto replaces the content of dst with src, which must have the same type.
+func copyInto(dst, src client.Object) error {
+	d, s := reflect.ValueOf(dst), reflect.ValueOf(src)
+	if d.Type() != s.Type() || d.Kind() != reflect.Ptr {
+		return fmt.Errorf("cannot copy %T into %T", src, dst)
+	}
+	d.Elem().Set(s.Elem())
+	return nil
+}
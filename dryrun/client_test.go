@@ -0,0 +1,111 @@
+package dryrun
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func newDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "synapse", Namespace: "matrix"}}
+}
+
+func TestClientOnlyModeDoesNotWrite(t *testing.T) {
+	ctx := context.Background()
+	deploy := newDeployment()
+	base := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(deploy).Build()
+	c := NewClient(base, true)
+
+	original := deploy.DeepCopy()
+	deploy.Spec.Template.Annotations = map[string]string{"synapse.gen0sec.com/config-hash": "abc"}
+	require.NoError(t, c.Patch(ctx, deploy, client.MergeFrom(original)))
+	assert.Equal(t, "abc", deploy.Spec.Template.Annotations["synapse.gen0sec.com/config-hash"], "the dry-run result is returned to the caller")
+
+	stored := &appsv1.Deployment{}
+	require.NoError(t, base.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, stored))
+	assert.Empty(t, stored.Spec.Template.Annotations)
+
+	require.NoError(t, c.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "matrix"}}))
+	assert.Error(t, base.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "state"}, &corev1.ConfigMap{}))
+}
+
+func TestClientLogModeWrites(t *testing.T) {
+	ctx := context.Background()
+	deploy := newDeployment()
+	base := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(deploy).Build()
+	c := NewClient(base, false)
+
+	original := deploy.DeepCopy()
+	deploy.Spec.Template.Annotations = map[string]string{"synapse.gen0sec.com/config-hash": "abc"}
+	require.NoError(t, c.Patch(ctx, deploy, client.MergeFrom(original)))
+
+	stored := &appsv1.Deployment{}
+	require.NoError(t, base.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, stored))
+	assert.Equal(t, "abc", stored.Spec.Template.Annotations["synapse.gen0sec.com/config-hash"])
+}
+
+func TestDiff(t *testing.T) {
+	before := newDeployment()
+	before.ResourceVersion = "1"
+	after := before.DeepCopy()
+	after.ResourceVersion = "2"
+
+	diff, err := Diff(before, after)
+	require.NoError(t, err)
+	assert.Empty(t, diff, "resourceVersion alone is not a change")
+
+	after.Spec.Template.Annotations = map[string]string{"synapse.gen0sec.com/config-hash": "abc"}
+	diff, err = Diff(before, after)
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+        synapse.gen0sec.com/config-hash: abc")
+	assert.Contains(t, diff, "--- live")
+}
+
+func TestValidMode(t *testing.T) {
+	for _, mode := range []string{ModeOff, ModeLog, ModeOnly} {
+		assert.True(t, ValidMode(mode), mode)
+	}
+	assert.False(t, ValidMode("yes"))
+}
+
+func TestClientApply(t *testing.T) {
+	ctx := context.Background()
+	config := appsv1ac.Deployment("synapse", "matrix").WithSpec(appsv1ac.DeploymentSpec().WithTemplate(
+		corev1ac.PodTemplateSpec().WithAnnotations(map[string]string{"synapse.gen0sec.com/config-hash": "abc"})))
+	// The fake client ignores dry runs of applies, so record what is sent instead.
+	var dryRuns []bool
+	base := interceptor.NewClient(fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(newDeployment()).Build(), interceptor.Funcs{
+		Apply: func(ctx context.Context, c client.WithWatch, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+			applyOpts := &client.ApplyOptions{}
+			applyOpts.ApplyOptions(opts)
+			dryRuns = append(dryRuns, len(applyOpts.DryRun) > 0)
+			if len(applyOpts.DryRun) > 0 {
+				return nil
+			}
+			return c.Apply(ctx, obj, opts...)
+		},
+	})
+
+	require.NoError(t, NewClient(base, true).Apply(ctx, config, client.FieldOwner("synapse-operator")))
+	assert.Equal(t, []bool{true}, dryRuns)
+
+	dryRuns = nil
+	require.NoError(t, NewClient(base, false).Apply(ctx, config, client.FieldOwner("synapse-operator")))
+	assert.Equal(t, []bool{true, false}, dryRuns)
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, base.Get(ctx, types.NamespacedName{Namespace: "matrix", Name: "synapse"}, deploy))
+	assert.Equal(t, "abc", deploy.Spec.Template.Annotations["synapse.gen0sec.com/config-hash"])
+}
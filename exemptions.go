@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/controllers"
+	"synapse-operator/exemptions"
+)
+
+// exemptionOperatorFlags are the operator flags that shape its writes. `exemptions --from-deployment` reads
+// them from the args of the running operator.
+var exemptionOperatorFlags = map[string]struct{}{
+	"namespace":               {},
+	"config-hash-annotation":  {},
+	"restarted-at-annotation": {},
+	"rollout-history-size":    {},
+	"manage-cronjobs":         {},
+	"restart-in-flight-jobs":  {},
+	"annotate-restart-cause":  {},
+}
+
+// runExemptions implements `synapse-operator exemptions`, which prints the Kyverno PolicyException or
+// Gatekeeper Config letting the operator's patches through mutation-blocking admission policies.
+func runExemptions(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("exemptions", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	format := fs.String("format", exemptions.FormatKyverno, "Output format: kyverno (PolicyException) or gatekeeper (Config).")
+	fromDeployment := fs.String("from-deployment", "", "<namespace>/<name> of the operator Deployment to read the service account and operator flags from.")
+	serviceAccount := fs.String("service-account", "synapse-system/synapse-operator", "<namespace>/<name> of the operator's service account.")
+	var policies stringList
+	fs.Var(&policies, "policy", "Kyverno policy to exempt from, as <name> or <name>:<rule>,<rule>. Repeatable.")
+	var targetNamespaces stringList
+	fs.Var(&targetNamespaces, "target-namespace", "Namespace the operator patches workloads in, when it watches every namespace. Repeatable.")
+	exceptionNamespace := fs.String("exception-namespace", "kyverno", "Namespace of the generated PolicyException.")
+	gatekeeperNamespace := fs.String("gatekeeper-namespace", "gatekeeper-system", "Namespace Gatekeeper runs in.")
+	// The operator flags that shape its writes, with the operator's defaults.
+	namespace := fs.String("namespace", "", "Namespace the operator watches.")
+	configHashAnnotation := fs.String("config-hash-annotation", "synapse.gen0sec.com/config-hash", "The operator's --config-hash-annotation.")
+	restartedAtAnnotation := fs.String("restarted-at-annotation", controllers.DefaultRestartedAtAnnotation, "The operator's --restarted-at-annotation.")
+	rolloutHistorySize := fs.Int("rollout-history-size", 0, "The operator's --rollout-history-size.")
+	manageCronJobs := fs.Bool("manage-cronjobs", false, "The operator's --manage-cronjobs.")
+	restartInFlightJobs := fs.Bool("restart-in-flight-jobs", false, "The operator's --restart-in-flight-jobs.")
+	annotateRestartCause := fs.Bool("annotate-restart-cause", false, "The operator's --annotate-restart-cause.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *fromDeployment != "" {
+		key, err := parseNamespacedName(*fromDeployment)
+		if err != nil {
+			fmt.Fprintf(stderr, "exemptions: --from-deployment: %v\n", err)
+			return 2
+		}
+		deploy, err := getOperatorDeployment(context.Background(), key)
+		if err != nil {
+			fmt.Fprintf(stderr, "exemptions: %v\n", err)
+			return 1
+		}
+		// Flags given on the command line take precedence over the Deployment's.
+		if err := fs.Parse(operatorFlagArgs(fs, deploy, exemptionOperatorFlags)); err != nil {
+			return 2
+		}
+		sa := deploy.Spec.Template.Spec.ServiceAccountName
+		if sa == "" {
+			sa = "default"
+		}
+		*serviceAccount = deploy.Namespace + "/" + sa
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+	}
+
+	sa, err := parseNamespacedName(*serviceAccount)
+	if err != nil {
+		fmt.Fprintf(stderr, "exemptions: --service-account: %v\n", err)
+		return 2
+	}
+	spec := exemptions.Spec{
+		ServiceAccount: sa,
+		Namespaces:     targetNamespaces,
+		Writes: controllers.WorkloadWrites(controllers.WriteOptions{
+			ConfigHashAnnotation:  *configHashAnnotation,
+			RestartedAtAnnotation: *restartedAtAnnotation,
+			RolloutHistory:        *rolloutHistorySize > 0,
+			ManageCronJobs:        *manageCronJobs,
+			RestartInFlightJobs:   *restartInFlightJobs,
+			RestartCause:          *annotateRestartCause,
+		}),
+		ExceptionNamespace:  *exceptionNamespace,
+		GatekeeperNamespace: *gatekeeperNamespace,
+	}
+	if *namespace != "" {
+		spec.Namespaces = []string{*namespace}
+	}
+	for _, value := range policies {
+		policy, err := exemptions.ParsePolicy(value)
+		if err != nil {
+			fmt.Fprintf(stderr, "exemptions: %v\n", err)
+			return 2
+		}
+		spec.Policies = append(spec.Policies, policy)
+	}
+	if err := exemptions.Write(stdout, *format, spec); err != nil {
+		fmt.Fprintf(stderr, "exemptions: %v\n", err)
+		return 2
+	}
+	return 0
+}
+
+func getOperatorDeployment(ctx context.Context, key types.NamespacedName) (*appsv1.Deployment, error) {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	deploy := &appsv1.Deployment{}
+	if err := c.Get(ctx, key, deploy); err != nil {
+		return nil, err
+	}
+	return deploy, nil
+}
+
+// operatorFlagArgs returns the args of the manager container of deploy that set one of operatorFlags, in a
+// form fs parses.
+func operatorFlagArgs(fs *flag.FlagSet, deploy *appsv1.Deployment, operatorFlags map[string]struct{}) []string {
+	var containerArgs []string
+	for _, container := range deploy.Spec.Template.Spec.Containers {
+		containerArgs = append(containerArgs, container.Command...)
+		containerArgs = append(containerArgs, container.Args...)
+	}
+	var out []string
+	for i := 0; i < len(containerArgs); i++ {
+		arg := containerArgs[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		f := fs.Lookup(name)
+		if _, ok := operatorFlags[name]; !ok || f == nil {
+			continue
+		}
+		out = append(out, arg)
+		if isBool, ok := f.Value.(interface{ IsBoolFlag() bool }); hasValue || (ok && isBool.IsBoolFlag()) {
+			continue
+		}
+		if i+1 < len(containerArgs) {
+			i++
+			out = append(out, containerArgs[i])
+		}
+	}
+	return out
+}
+
+func parseNamespacedName(value string) (types.NamespacedName, error) {
+	namespace, name, ok := strings.Cut(value, "/")
+	if !ok || namespace == "" || name == "" {
+		return types.NamespacedName{}, fmt.Errorf("expected <namespace>/<name>, got %q", value)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, nil
+}
@@ -0,0 +1,207 @@
+// Package exemptions generates the admission policy exemptions that let the operator's patches through
+// clusters whose Kyverno or Gatekeeper policies block mutations of workloads.
+package exemptions
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	"synapse-operator/controllers"
+)
+
+// Output formats accepted by Write.
+const (
+	FormatKyverno    = "kyverno"
+	FormatGatekeeper = "gatekeeper"
+)
+
+// Spec describes the exemptions to generate.
+type Spec struct {
+	// ServiceAccount is the operator's service account; the exemptions only apply to its requests.
+	ServiceAccount types.NamespacedName
+	// Namespaces are those the operator patches workloads in; empty means every namespace.
+	Namespaces []string
+	Writes     []controllers.WorkloadWrite
+	// Policies are the Kyverno policies to exempt from, with the rules of each; no rules means all of them.
+	Policies []Policy
+	// ExceptionNamespace is the namespace of the Kyverno PolicyException.
+	ExceptionNamespace string
+	// GatekeeperNamespace is the namespace Gatekeeper runs in and reads its Config from.
+	GatekeeperNamespace string
+}
+
+// Policy is a Kyverno policy and the rules of it to exempt from.
+type Policy struct {
+	Name  string
+	Rules []string
+}
+
+// ParsePolicy parses a --policy value: "<name>" or "<name>:<rule>,<rule>".
+func ParsePolicy(value string) (Policy, error) {
+	name, rules, _ := strings.Cut(value, ":")
+	if name == "" {
+		return Policy{}, fmt.Errorf("invalid policy %q: expected <name> or <name>:<rule>,<rule>", value)
+	}
+	policy := Policy{Name: name}
+	if rules != "" {
+		policy.Rules = strings.Split(rules, ",")
+	}
+	return policy, nil
+}
+
+// Write renders the exemptions of spec in format to w, preceded by a comment listing the exact patches
+// they cover so reviewers can narrow them further.
+func Write(w io.Writer, format string, spec Spec) error {
+	var obj map[string]interface{}
+	var err error
+	switch format {
+	case FormatKyverno:
+		obj, err = kyvernoException(spec)
+	case FormatGatekeeper:
+		obj, err = gatekeeperConfig(spec)
+	default:
+		return fmt.Errorf("unknown format %q: expected %s or %s", format, FormatKyverno, FormatGatekeeper)
+	}
+	if err != nil {
+		return err
+	}
+	encoded, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	comment := header(spec)
+	if format == FormatGatekeeper {
+		comment += "# Gatekeeper reads a single Config named config: merge this match entry into the existing one.\n"
+	}
+	if _, err := io.WriteString(w, comment); err != nil {
+		return err
+	}
+	_, err = w.Write(encoded)
+	return err
+}
+
+// header describes the writes covered by the exemptions as YAML comments.
+func header(spec Spec) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by `synapse-operator exemptions` for %s.\n", username(spec.ServiceAccount))
+	b.WriteString("# The operator only patches these fields:\n")
+	for _, write := range spec.Writes {
+		for _, annotation := range write.Metadata {
+			fmt.Fprintf(&b, "#   %s metadata.annotations[%s]\n", write.Kind, annotation)
+		}
+		for _, annotation := range write.Template {
+			fmt.Fprintf(&b, "#   %s %s.metadata.annotations[%s]\n", write.Kind, templatePath(write.Kind), annotation)
+		}
+		for _, field := range write.Fields {
+			fmt.Fprintf(&b, "#   %s %s\n", write.Kind, field)
+		}
+	}
+	b.WriteString("# and creates Pod evictions for the evict restart strategy.\n")
+	return b.String()
+}
+
+func templatePath(kind string) string {
+	if kind == "CronJob" {
+		return "spec.jobTemplate.spec.template"
+	}
+	return "spec.template"
+}
+
+func username(sa types.NamespacedName) string {
+	return "system:serviceaccount:" + sa.Namespace + ":" + sa.Name
+}
+
+// kyvernoException builds a PolicyException exempting the operator's service account from the policies,
+// for updates of the written workload kinds and Pod evictions only.
+func kyvernoException(spec Spec) (map[string]interface{}, error) {
+	if len(spec.Policies) == 0 {
+		return nil, fmt.Errorf("kyverno exemptions need at least one policy to exempt from")
+	}
+	exceptions := make([]interface{}, 0, len(spec.Policies))
+	for _, policy := range spec.Policies {
+		rules := policy.Rules
+		if len(rules) == 0 {
+			rules = []string{"*"}
+		}
+		exceptions = append(exceptions, map[string]interface{}{
+			"policyName": policy.Name,
+			"ruleNames":  toInterfaces(rules),
+		})
+	}
+
+	kinds := make([]string, 0, len(spec.Writes))
+	for _, write := range spec.Writes {
+		kinds = append(kinds, write.Kind)
+	}
+	sort.Strings(kinds)
+	subjects := []interface{}{map[string]interface{}{
+		"kind":      "ServiceAccount",
+		"name":      spec.ServiceAccount.Name,
+		"namespace": spec.ServiceAccount.Namespace,
+	}}
+	match := func(kinds []string, operation string) map[string]interface{} {
+		resources := map[string]interface{}{
+			"kinds":      toInterfaces(kinds),
+			"operations": []interface{}{operation},
+		}
+		if len(spec.Namespaces) > 0 {
+			resources["namespaces"] = toInterfaces(spec.Namespaces)
+		}
+		return map[string]interface{}{"resources": resources, "subjects": subjects}
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "kyverno.io/v2",
+		"kind":       "PolicyException",
+		"metadata": map[string]interface{}{
+			"name":      spec.ServiceAccount.Name,
+			"namespace": spec.ExceptionNamespace,
+			"labels":    map[string]interface{}{"app.kubernetes.io/managed-by": "synapse-operator"},
+		},
+		"spec": map[string]interface{}{
+			"exceptions": exceptions,
+			"match": map[string]interface{}{
+				"any": []interface{}{
+					match(kinds, "UPDATE"),
+					match([]string{"Pod/eviction"}, "CREATE"),
+				},
+			},
+		},
+	}, nil
+}
+
+// gatekeeperConfig builds the Gatekeeper Config excluding the operator's namespaces from the admission
+// webhook. Gatekeeper cannot exempt a single service account, so this is namespace-wide; the header lists
+// the patches for narrowing constraints on userInfo instead.
+func gatekeeperConfig(spec Spec) (map[string]interface{}, error) {
+	if len(spec.Namespaces) == 0 {
+		return nil, fmt.Errorf("gatekeeper exemptions are namespace-wide and need the namespaces the operator patches")
+	}
+	return map[string]interface{}{
+		"apiVersion": "config.gatekeeper.sh/v1alpha1",
+		"kind":       "Config",
+		"metadata": map[string]interface{}{
+			"name":      "config",
+			"namespace": spec.GatekeeperNamespace,
+		},
+		"spec": map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{
+				"excludedNamespaces": toInterfaces(spec.Namespaces),
+				"processes":          []interface{}{"webhook"},
+			}},
+		},
+	}, nil
+}
+
+func toInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, value := range values {
+		out[i] = value
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package exemptions
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	"synapse-operator/controllers"
+)
+
+func testSpec() Spec {
+	return Spec{
+		ServiceAccount:      types.NamespacedName{Namespace: "synapse-system", Name: "synapse-operator"},
+		Namespaces:          []string{"matrix"},
+		Writes:              controllers.WorkloadWrites(controllers.WriteOptions{ConfigHashAnnotation: "example.com/hash"}),
+		ExceptionNamespace:  "kyverno",
+		GatekeeperNamespace: "gatekeeper-system",
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("disallow-mutations:block-annotations,block-labels")
+	require.NoError(t, err)
+	assert.Equal(t, Policy{Name: "disallow-mutations", Rules: []string{"block-annotations", "block-labels"}}, policy)
+
+	policy, err = ParsePolicy("disallow-mutations")
+	require.NoError(t, err)
+	assert.Empty(t, policy.Rules)
+
+	_, err = ParsePolicy(":rule")
+	assert.Error(t, err)
+}
+
+func TestWriteKyvernoException(t *testing.T) {
+	spec := testSpec()
+	spec.Policies = []Policy{{Name: "disallow-mutations", Rules: []string{"block-annotations"}}, {Name: "restrict-updates"}}
+	var out bytes.Buffer
+	require.NoError(t, Write(&out, FormatKyverno, spec))
+	assert.Contains(t, out.String(), "#   Deployment spec.template.metadata.annotations[example.com/hash]\n")
+	assert.Contains(t, out.String(), "system:serviceaccount:synapse-system:synapse-operator")
+
+	var obj map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(out.Bytes(), &obj))
+	assert.Equal(t, "PolicyException", obj["kind"])
+	specObj := obj["spec"].(map[string]interface{})
+	exceptions := specObj["exceptions"].([]interface{})
+	require.Len(t, exceptions, 2)
+	assert.Equal(t, []interface{}{"*"}, exceptions[1].(map[string]interface{})["ruleNames"])
+	matches := specObj["match"].(map[string]interface{})["any"].([]interface{})
+	require.Len(t, matches, 2)
+	resources := matches[0].(map[string]interface{})["resources"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"DaemonSet", "Deployment", "StatefulSet"}, resources["kinds"])
+	assert.Equal(t, []interface{}{"matrix"}, resources["namespaces"])
+	assert.Equal(t, []interface{}{"UPDATE"}, resources["operations"])
+
+	spec.Policies = nil
+	assert.Error(t, Write(&out, FormatKyverno, spec))
+}
+
+func TestWriteGatekeeperConfig(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, Write(&out, FormatGatekeeper, testSpec()))
+	var obj map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(out.Bytes(), &obj))
+	assert.Equal(t, "Config", obj["kind"])
+	match := obj["spec"].(map[string]interface{})["match"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, []interface{}{"matrix"}, match["excludedNamespaces"])
+
+	spec := testSpec()
+	spec.Namespaces = nil
+	assert.Error(t, Write(&out, FormatGatekeeper, spec))
+	assert.Error(t, Write(&out, "opa", testSpec()))
+}
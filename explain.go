@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/audit"
+	"synapse-operator/state"
+)
+
+// runExplain implements `synapse-operator explain`, which replays a recorded rollout decision from the audit
+// records in the state store.
+func runExplain(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	id := fs.String("id", "", "Transaction ID of the decision to explain.")
+	namespace := fs.String("namespace", "", "Namespace of the decision, when looking it up by time.")
+	at := fs.String("at", "", "RFC 3339 time; explains the latest decision in --namespace at or before it.")
+	output := fs.String("output", "text", "Output format: text or json.")
+	stateBackend := fs.String("state-store", state.BackendConfigMap, "Backend the operator keeps its state in: configmap or crd.")
+	stateNamespace := fs.String("state-namespace", defaultStateNamespace(), "Namespace of the state ConfigMap or SynapseOperatorState resource.")
+	stateName := fs.String("state-name", "synapse-operator-state", "Name of the state ConfigMap or SynapseOperatorState resource.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (*id == "") == (*namespace == "" || *at == "") {
+		fmt.Fprintln(stderr, "explain: pass either --id, or --namespace and --at")
+		return 2
+	}
+	if *stateBackend == state.BackendMemory {
+		fmt.Fprintln(stderr, "explain: the memory state store is not readable outside the operator")
+		return 2
+	}
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "explain: %v\n", err)
+		return 1
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintf(stderr, "explain: %v\n", err)
+		return 1
+	}
+	store, err := state.New(*stateBackend, c, c, types.NamespacedName{Namespace: *stateNamespace, Name: *stateName})
+	if err != nil {
+		fmt.Fprintf(stderr, "explain: %v\n", err)
+		return 2
+	}
+
+	rec, err := findAuditRecord(context.Background(), &audit.Log{Store: store}, *id, *namespace, *at)
+	if err != nil {
+		fmt.Fprintf(stderr, "explain: %v\n", err)
+		return 1
+	}
+	if err := writeExplanation(stdout, rec, *output); err != nil {
+		fmt.Fprintf(stderr, "explain: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func findAuditRecord(ctx context.Context, log *audit.Log, id, namespace, at string) (*audit.Record, error) {
+	if id != "" {
+		return log.Find(ctx, id)
+	}
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --at: %w", err)
+	}
+	return log.FindAt(ctx, namespace, t)
+}
+
+func writeExplanation(w io.Writer, rec *audit.Record, output string) error {
+	switch output {
+	case "text":
+		return audit.Explain(w, rec)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rec)
+	default:
+		return fmt.Errorf("unknown output format %q", output)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/controllers"
+)
+
+// runLock implements `synapse-operator lock acquire|release`, which deploy pipelines use to hold the
+// operator's rollouts in a namespace while they roll out themselves.
+func runLock(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || (args[0] != "acquire" && args[0] != "release") {
+		fmt.Fprintln(stderr, "usage: synapse-operator lock acquire|release --namespace <namespace> --holder <id> [--duration <duration>]")
+		return 2
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("lock "+action, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	namespace := fs.String("namespace", "", "Namespace whose rollouts to hold.")
+	hostname, _ := os.Hostname()
+	holder := fs.String("holder", hostname, "Identity of the lock holder, e.g. the pipeline run. Acquiring again with the same holder renews the lock.")
+	duration := fs.Duration("duration", 15*time.Minute, "How long the lock is held without renewal before it expires.")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *namespace == "" || *holder == "" {
+		fmt.Fprintln(stderr, "lock: --namespace and --holder are required")
+		return 2
+	}
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "lock: %v\n", err)
+		return 1
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintf(stderr, "lock: %v\n", err)
+		return 1
+	}
+	ctx := context.Background()
+	if action == "acquire" {
+		err = acquireRolloutLock(ctx, c, *namespace, *holder, *duration, time.Now())
+	} else {
+		err = releaseRolloutLock(ctx, c, *namespace, *holder)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "lock: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s rollout lock in %s for %s\n", map[string]string{"acquire": "Acquired", "release": "Released"}[action], *namespace, *holder)
+	return 0
+}
+
+// acquireRolloutLock takes or renews the rollout lock of namespace for holder. It fails while another
+// holder's lock has not expired.
+func acquireRolloutLock(ctx context.Context, c client.Client, namespace, holder string, duration time.Duration, now time.Time) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		lease := &coordinationv1.Lease{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: controllers.RolloutLockName}, lease)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		exists := err == nil
+		current, _ := controllers.RolloutLockHolder(lease, now)
+		if current != "" && current != holder {
+			return fmt.Errorf("rollout lock in %s is held by %s", namespace, current)
+		}
+
+		renewTime := metav1.NewMicroTime(now)
+		if current != holder {
+			lease.Spec.AcquireTime = &renewTime
+			lease.Spec.LeaseTransitions = ptr.To(ptr.Deref(lease.Spec.LeaseTransitions, 0) + 1)
+		}
+		lease.Spec.HolderIdentity = ptr.To(holder)
+		lease.Spec.RenewTime = &renewTime
+		lease.Spec.LeaseDurationSeconds = ptr.To(int32(duration.Seconds()))
+		if !exists {
+			lease.Namespace = namespace
+			lease.Name = controllers.RolloutLockName
+			return c.Create(ctx, lease)
+		}
+		return c.Update(ctx, lease)
+	})
+}
+
+// releaseRolloutLock deletes the rollout lock of namespace if holder holds it.
+func releaseRolloutLock(ctx context.Context, c client.Client, namespace, holder string) error {
+	lease := &coordinationv1.Lease{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: controllers.RolloutLockName}, lease); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if current, _ := controllers.RolloutLockHolder(lease, time.Now()); current != "" && current != holder {
+		return fmt.Errorf("rollout lock in %s is held by %s", namespace, current)
+	}
+	return client.IgnoreNotFound(c.Delete(ctx, lease, client.Preconditions{ResourceVersion: &lease.ResourceVersion}))
+}
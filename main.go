@@ -1,23 +1,50 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"path"
+	"regexp"
+	goruntime "runtime"
 	"strings"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"synapse-operator/audit"
+	"synapse-operator/bootstrap"
+	"synapse-operator/conformance"
 	"synapse-operator/controllers"
+	"synapse-operator/dryrun"
+	"synapse-operator/notify"
+	"synapse-operator/pipeline"
+	"synapse-operator/sops"
+	"synapse-operator/state"
+	"synapse-operator/tracing"
+	"synapse-operator/vault"
 )
 
 var (
@@ -32,14 +59,149 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		os.Exit(runExplain(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lock" {
+		os.Exit(runLock(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && (os.Args[1] == "pause" || os.Args[1] == "resume") {
+		os.Exit(runPause(os.Args[1], os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		os.Exit(runStatus(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "exemptions" {
+		os.Exit(runExemptions(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "preflight" {
+		os.Exit(runPreflight(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	var metricsAddr string
 	var probeAddr string
+	var pprofAddr string
+	var blockProfileRate int
+	var mutexProfileFraction int
+	var otlpEndpoint string
+	var otlpInsecure bool
+	var traceSampleRatio float64
 	var enableLeaderElection bool
+	var leaderElectionNamespace string
+	var leaseDuration time.Duration
+	var renewDeadline time.Duration
+	var retryPeriod time.Duration
 	var watchedNamespace string
+	var clusterKubeconfigNamespace string
+	var clusterKubeconfigSelector string
+	var excludeNamespaces string
 	var labelSelector string
+	var sourceLabelSelector string
+	var workloadLabelSelector string
+	var annotationSelector string
 	var configHashAnnotation string
+	var acceptLegacyHashes bool
 	var ignoredConfigMapKeys string
 	var ignoredSecretKeys string
+	var includedConfigMapKeys string
+	var includedSecretKeys string
+	var stateBackend string
+	var stateNamespace string
+	var stateName string
+	var configDiff bool
+	var configChangeLogging bool
+	var configDiffMaxBytes int
+	var configDiffRedact stringList
+	var restartStrategy string
+	var rolloutImpact bool
+	var restartedAtAnnotation string
+	var versionedCopiesRetention int
+	var immutableConfigSources bool
+	var pruneSupersededConfigSources bool
+	var configRevisionsRetention int
+	var zoneTopologyKey string
+	var conformanceMode bool
+	var watchSecretProviderClasses bool
+	var watchExternalSecrets bool
+	var watchCertificates bool
+	var vaultAddress string
+	var vaultRole string
+	var vaultAuthMount string
+	var vaultNamespace string
+	var vaultPollInterval time.Duration
+	var sopsAwareHashing bool
+	var sopsAgeKeyFile string
+	var rolloutHistorySize int
+	var gradualRolloutWindow time.Duration
+	var maxRollingNamespaces int
+	var rolloutTiers string
+	var rolloutTierSoak time.Duration
+	var allowRecreateRestarts bool
+	var canaryManualApproval bool
+	var groupByOwner bool
+	var groupByComponent bool
+	var requireApproval bool
+	var injectConfigHash bool
+	var statusAPIAddr string
+	var canaryNamespaces bool
+	var webhookPort int
+	var webhookCertDir string
+	var dryRunPatches string
+	var rolloutHistoryRetention int
+	var onboardingPolicy string
+	var onboardingImagePattern string
+	var onboardingChartSelector string
+	var manageAppservices bool
+	var appservicesSecretName string
+	var appservicesMountPath string
+	var manageWorkerTopology bool
+	var renderConfigTemplates bool
+	var notificationConfig string
+	var notificationSinks stringList
+	var notificationTimeout time.Duration
+	var detectByImage string
+	var maxConcurrentReconciles int
+	var namespaceQPS float64
+	var namespaceBurst int
+	var watchSecrets bool
+	var secretMaxConcurrentReconciles int
+	var secretDebounce time.Duration
+	var ignoredSecrets string
+	var secretMetadataOnly bool
+	var trimWorkloadCache bool
+	var leastPrivilege bool
+	var cleanupReleasedWorkloads bool
+	var cleanupReleasedPodTemplates bool
+	var helmCoalesceWindow time.Duration
+	var maxSourcesPerNamespace int
+	var recordConfigSources bool
+	var annotateRestartCause bool
+	var minRestartInterval time.Duration
+	var deferRestartsOnPDB bool
+	var configCheckURL string
+	var configCheckTimeout time.Duration
+	var configCheckSecrets bool
+	var validationJobImage string
+	var validationJobCommand stringList
+	var validationJobServiceAccount string
+	var validationJobTimeout time.Duration
+	var startupSettleDelay time.Duration
+	var suppressStartupRollouts bool
+	var driftRepair string
+	var gitOpsCompat string
+	var gitOpsCompanionAnnotations string
+	var serverSideApply bool
+	var serverSideApplyForce bool
+	var patchRetryAttempts int
+	var patchRetryBackoff time.Duration
+	var syncRoutes bool
+	var rolloutProgressTimeout time.Duration
+	var autoRollback bool
+	var rolloutLock bool
+	var manageCronJobs bool
+	var restartInFlightJobs bool
+	var sourceClassPolicies string
+	var auditRetention int
 
 	opts := zap.Options{
 		Development: true,
@@ -48,12 +210,128 @@ func main() {
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metrics endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the health probe endpoint binds to.")
+	flag.StringVar(&pprofAddr, "pprof-bind-address", "", "The address the pprof endpoints (/debug/pprof/) bind to. Empty disables them; never expose them outside the cluster.")
+	flag.IntVar(&blockProfileRate, "block-profile-rate", 0, "Record one blocking event per this many nanoseconds blocked in the block profile (runtime.SetBlockProfileRate). 0 disables the block profile.")
+	flag.IntVar(&mutexProfileFraction, "mutex-profile-fraction", 0, "Record one in this many mutex contention events in the mutex profile (runtime.SetMutexProfileFraction). 0 disables the mutex profile.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP endpoint to export OpenTelemetry traces of reconciles, hash computations, and workload patches to, as host:port or a URL such as http://otel-collector:4318/v1/traces. Empty disables tracing.")
+	flag.BoolVar(&otlpInsecure, "otlp-insecure", false, "Export traces over plain HTTP to a host:port --otlp-endpoint.")
+	flag.Float64Var(&traceSampleRatio, "trace-sample-ratio", 1, "Fraction of reconciles traced, from 0 to 1.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false, "Enable leader election for controller manager.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "Namespace holding the leader election Lease. Defaults to the namespace the operator runs in.")
+	flag.DurationVar(&leaseDuration, "leader-election-lease-duration", 15*time.Second, "Duration non-leader candidates wait before trying to acquire leadership.")
+	flag.DurationVar(&renewDeadline, "leader-election-renew-deadline", 10*time.Second, "Duration the leader retries refreshing leadership before giving up.")
+	flag.DurationVar(&retryPeriod, "leader-election-retry-period", 2*time.Second, "Duration leader election clients wait between attempts.")
 	flag.StringVar(&watchedNamespace, "namespace", "", "Namespace to watch. Defaults to all namespaces.")
-	flag.StringVar(&labelSelector, "label-selector", "app.kubernetes.io/name=synapse", "Label selector for config sources and workloads.")
+	flag.StringVar(&clusterKubeconfigNamespace, "cluster-kubeconfig-namespace", "", "Namespace of the kubeconfig Secrets registering remote clusters, in the Cluster API layout, whose config changes the operator rolls out as well. Empty disables multi-cluster mode.")
+	flag.StringVar(&clusterKubeconfigSelector, "cluster-kubeconfig-selector", controllers.ClusterNameLabel, "Label selector for the kubeconfig Secrets in --cluster-kubeconfig-namespace.")
+	flag.StringVar(&excludeNamespaces, "exclude-namespaces", controllers.DefaultExcludedNamespaces, "Comma-separated namespaces whose workloads are never restarted, released, or repaired, even when they match the selector. Pass an empty value to protect none.")
+	flag.StringVar(&labelSelector, "label-selector", "app.kubernetes.io/name=synapse", "Label selector for config sources and workloads, unless --source-label-selector or --workload-label-selector overrides it.")
+	flag.StringVar(&sourceLabelSelector, "source-label-selector", "", "Label selector for config sources (ConfigMaps, Secrets, SecretProviderClasses). Defaults to --label-selector.")
+	flag.StringVar(&workloadLabelSelector, "workload-label-selector", "", "Label selector for workloads (Deployments, DaemonSets, StatefulSets, CronJobs). Defaults to --label-selector.")
+	flag.BoolVar(&groupByOwner, "group-by-owner", false, "Hash the config sources of each controller (e.g. a release of a Helm operator) separately and restart only the workloads with the same controller. Sources without a controller feed every group. Cannot be combined with --require-approval, --gradual-rollout-window, --canary-namespaces, or --rollout-history-retention.")
+	flag.BoolVar(&groupByComponent, "group-by-component", false, "Hash the config sources of each component, named by the synapse.gen0sec.com/component label (e.g. bridge-telegram), separately and restart only the workloads of the same component. Sources without a component feed every group. Cannot be combined with --group-by-owner, --require-approval, --gradual-rollout-window, --canary-namespaces, or --rollout-history-retention.")
+	flag.StringVar(&annotationSelector, "annotation-selector", "", "Also select config sources whose annotations match this selector (e.g. synapse.gen0sec.com/watch=true), whatever their labels. Sources are then listed unfiltered and matched in the operator.")
+	flag.StringVar(&detectByImage, "detect-by-image", "", "Target workloads running an image matching this glob (e.g. matrixdotorg/synapse*) instead of those matching --label-selector, and hash the ConfigMaps and Secrets they mount or read env from.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "Number of namespaces reconciled in parallel. Namespaces are served round-robin and each is reconciled by one worker at a time.")
+	flag.Float64Var(&namespaceQPS, "namespace-qps", 0, "Per-namespace rate limit, in requests per second, for retried reconciles. 0 uses the default limiter shared by all namespaces.")
+	flag.IntVar(&namespaceBurst, "namespace-burst", 10, "Burst allowed by --namespace-qps.")
+	flag.BoolVar(&watchSecrets, "watch-secrets", true, "Watch selected Secrets as config sources with a controller of their own. false leaves Secrets out of the config hash entirely, so Secret rotations never restart workloads.")
+	flag.IntVar(&secretMaxConcurrentReconciles, "secret-max-concurrent-reconciles", 1, "Number of namespaces reconciled in parallel for Secret changes, separately from --max-concurrent-reconciles. A namespace is still reconciled by one worker at a time.")
+	flag.DurationVar(&secretDebounce, "secret-debounce", 0, "Roll out a Secret change only once the Secret has stayed unchanged for this long, coalescing bursts of rotations into one restart. Source classes that debounce longer keep their period.")
+	flag.BoolVar(&secretMetadataOnly, "secret-metadata-only", false, "Cache Secrets without their data: values are kept as SHA-256 digests only, and the data of selected Secrets is read from the API server whenever they are hashed. Cannot be combined with --manage-appservices, --render-config-templates, or --sync-routes, which read Secret data from the cache.")
+	flag.BoolVar(&trimWorkloadCache, "trim-workload-cache", false, "Cache Deployments, DaemonSets and StatefulSets without what the operator never reads: container fields other than name, image and environment, the last-applied configuration, and managed fields (kept with --helm-coalesce-window). Cannot be combined with --manage-worker-topology, which copies whole Deployments.")
+	flag.BoolVar(&leastPrivilege, "least-privilege", false, "Check at startup which of Deployments, DaemonSets, StatefulSets and CronJobs the operator may list, watch and patch, and leave the others out with a warning instead of failing every reconcile; a kind whose patch is denied later is left out too. Cannot be combined with --onboarding-policy or --manage-worker-topology, which watch workloads on their own.")
+	flag.StringVar(&ignoredSecrets, "ignore-secrets", "", "Comma-separated name patterns (e.g. *-token,sh.helm.*) of Secrets that are neither watched nor hashed, even when selected.")
+	flag.BoolVar(&cleanupReleasedWorkloads, "cleanup-released-workloads", false, "When a managed workload stops being targeted (e.g. its labels are removed), also remove the config hash, rollout history, and restart bookkeeping annotations from its metadata. The pod template is never changed.")
+	flag.BoolVar(&cleanupReleasedPodTemplates, "cleanup-released-pod-templates", false, "When a managed workload stops being targeted, also remove the config hash annotation from its pod template, so no stale hash is left behind. This restarts the workload once.")
+	flag.DurationVar(&helmCoalesceWindow, "helm-coalesce-window", 0, "Skip restarting a Helm-managed workload for a config change its Helm release wrote within this duration of changing the workload itself, since the upgrade already rolled the pods onto the new config. 0 always restarts.")
+	flag.IntVar(&maxSourcesPerNamespace, "max-sources-per-namespace", 0, "Hash at most this many selected ConfigMaps and Secrets per namespace, in order of kind and name; the rest are left out of the config hash and reported with a SourceLimitExceeded warning event on the namespace. 0 hashes every source.")
+	flag.BoolVar(&recordConfigSources, "record-config-sources", false, "Record on the metadata of every restarted workload which sources, at which resourceVersions, produced the config hash it was restarted for, in the synapse.gen0sec.com/config-sources annotation.")
+	flag.BoolVar(&annotateRestartCause, "annotate-restart-cause", false, "Record on the pod template of every workload restarted for a changed source which source changed and when, in the "+controllers.RestartCauseAnnotation+" and "+controllers.RestartCauseTimeAnnotation+" annotations, so its pods carry them.")
+	flag.DurationVar(&minRestartInterval, "min-restart-interval", 0, "Restart each workload at most once per this duration; config changes arriving sooner are held, and the latest of them is rolled out once the interval has passed. Override it per workload with the synapse.gen0sec.com/min-restart-interval annotation. 0 does not limit restarts.")
+	flag.BoolVar(&deferRestartsOnPDB, "defer-restarts-on-pdb", false, "Hold the restart of an available workload while a PodDisruptionBudget matching its pods allows no disruption, and retry until it has room, instead of starting a rollout that stalls.")
+	flag.StringVar(&configCheckURL, "config-check-url", "", "POST the config of every new hash as JSON to this URL before rolling it out, and hold the rollout unless it answers with a 2xx status. 5xx answers and unreachable endpoints are retried.")
+	flag.DurationVar(&configCheckTimeout, "config-check-timeout", 30*time.Second, "How long a --config-check-url request may take before it counts as failed and is retried.")
+	flag.BoolVar(&configCheckSecrets, "config-check-secrets", false, "Also send the data of the namespace's Secrets to --config-check-url. Only enable it for an endpoint inside the cluster or behind TLS.")
+	flag.StringVar(&validationJobImage, "validation-job-image", "", "Before rolling out a new config hash, run a Job of this image in the namespace with every selected ConfigMap and Secret mounted under /config, and hold the rollout unless it exits 0.")
+	flag.Var(&validationJobCommand, "validation-job-command", "Command of the validation Job, one argument per flag, e.g. --validation-job-command=/bin/sh --validation-job-command=-c --validation-job-command='...'. Empty runs the image's entrypoint.")
+	flag.StringVar(&validationJobServiceAccount, "validation-job-service-account", "", "Service account the validation Job runs as, in the namespace of the config; empty uses the namespace's default.")
+	flag.DurationVar(&validationJobTimeout, "validation-job-timeout", 5*time.Minute, "How long a validation Job may run before it counts as failed.")
+	flag.DurationVar(&startupSettleDelay, "startup-settle-delay", 0, "Hold back all rollouts for this long after the operator starts reconciling, so caches and the burst of initial events (e.g. a GitOps re-sync after cluster maintenance) settle into a single rollout per namespace. 0 rolls out immediately.")
+	flag.BoolVar(&suppressStartupRollouts, "suppress-startup-rollouts", false, "Restart nothing on the first reconcile of each namespace after the operator starts unless its config sources changed while the operator was down, as recorded in the state store; without a record from a previous run, that reconcile restarts nothing. Workloads passed over take the next config change. Use a persistent --state-store, or changes made while the operator was down are only rolled out with the next one.")
+	flag.StringVar(&driftRepair, "drift-repair", controllers.DriftRepairOff, "Watch managed workloads and repair their config hash when another controller, such as a GitOps tool, removes or alters it while the config is unchanged: off, restore (put the hash back), or restart (put it back and restart the pods like kubectl rollout restart, treating the edit as a restart request).")
+	flag.StringVar(&gitOpsCompat, "gitops-compat", controllers.GitOpsCompatOff, "Add companion annotations to managed workloads so GitOps tools do not report the operator's writes as drift: off, argocd (argocd.argoproj.io/compare-options=IgnoreExtraneous) or flux (kustomize.toolkit.fluxcd.io/ssa=Merge).")
+	flag.StringVar(&gitOpsCompanionAnnotations, "gitops-companion-annotations", "", "Comma-separated key=value companion annotations added to managed workloads in place of the ones of --gitops-compat, or none to add none.")
+	flag.BoolVar(&serverSideApply, "server-side-apply", false, "Write the config hash annotation of the annotation restart strategy with a server-side apply as field manager synapse-operator instead of a merge patch, so the operator's ownership of the annotation is explicit and a conflict with another manager, such as a GitOps controller, is reported as a FieldManagerConflict event instead of overwritten.")
+	flag.BoolVar(&serverSideApplyForce, "server-side-apply-force", false, "With --server-side-apply, take the config hash annotation over from other field managers instead of reporting the conflict.")
+	flag.IntVar(&patchRetryAttempts, "patch-retry-attempts", 3, "Number of times in all a workload update failing with a conflict or a transient API error (throttling, timeout, unavailable) is tried, each retry on a freshly read workload. 1 disables retries.")
+	flag.DurationVar(&patchRetryBackoff, "patch-retry-backoff", 200*time.Millisecond, "Wait before the first retry of a workload update, doubled for each further retry (up to 30s) and jittered by up to half.")
+	flag.BoolVar(&syncRoutes, "sync-routes", false, "Before restarting a workload for a config change, move the Ingresses and HTTPRoutes listed in its synapse.gen0sec.com/routes annotation to the port (and, for ingress-nginx, the backend protocol) of the Synapse listener now serving each route.")
+	flag.BoolVar(&manageCronJobs, "manage-cronjobs", false, "Also write the config hash into the job template of CronJobs matching the label selector (or running a detected image), so their next run uses the new config. CronJobs always use the annotation restart strategy.")
+	flag.BoolVar(&restartInFlightJobs, "restart-in-flight-jobs", false, "With --manage-cronjobs, restart the running Jobs of a CronJob created before a config change by suspending them and resuming them once their pods are gone.")
+	flag.DurationVar(&rolloutProgressTimeout, "rollout-progress-timeout", 0, "Track every workload the operator restarts and report it as stalled (RolloutStalled event, synapse_operator_rollout_stalled metric, stalled notification) if it is not ready within this duration, or a Deployment exceeds its progress deadline. 0 disables tracking.")
+	flag.BoolVar(&autoRollback, "auto-rollback", false, "When a workload restarted for a config change stalls, restore the ConfigMap sources of the previous rollout from the SynapseRolloutHistory. Requires --rollout-progress-timeout and --rollout-history-retention.")
+	flag.BoolVar(&canaryNamespaces, "canary-namespaces", false, "Replay the ConfigMaps of namespaces annotated with synapse.gen0sec.com/canary-namespace into that canary namespace and hold their rollout until it has rolled out, is available, and passes the verifiers.")
+	flag.StringVar(&statusAPIAddr, "status-api-bind-address", "", "Address of the read-only HTTP status API exposing the config hash, workloads, and pending rollouts of each namespace. Empty disables it.")
+	flag.BoolVar(&injectConfigHash, "inject-config-hash", false, "Serve a mutating webhook that records the current config hash on targeted Deployments, DaemonSets, and StatefulSets as they are created, so they are not rolled again right after creation.")
+	flag.IntVar(&webhookPort, "webhook-port", 9443, "Port the webhook server listens on with --inject-config-hash.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", "", "Directory holding tls.crt and tls.key for the webhook server. Defaults to controller-runtime's serving-certs directory under the temp dir.")
+	flag.BoolVar(&requireApproval, "require-approval", false, "Hold every config change until the namespace approves its hash with the synapse.gen0sec.com/approved-config-hash annotation, except in namespaces annotated synapse.gen0sec.com/approval-required=false.")
+	flag.BoolVar(&rolloutLock, "rollout-lock", false, "Hold rollouts in a namespace while an external deploy tool holds its synapse-rollout-lock Lease (see `synapse-operator lock`).")
 	flag.StringVar(&configHashAnnotation, "config-hash-annotation", "synapse.gen0sec.com/config-hash", "Annotation key to store the config hash.")
+	flag.BoolVar(&acceptLegacyHashes, "accept-legacy-hashes", false, "Leave workloads whose applied config hash is the unprefixed hash of their current config, as written by operators from before the v2 encoding, as they are instead of restarting them on upgrade. They take a v2 hash with their next config change.")
 	flag.StringVar(&ignoredConfigMapKeys, "ignore-configmap-keys", "upstreams.yaml", "Comma-separated ConfigMap keys to ignore when hashing.")
 	flag.StringVar(&ignoredSecretKeys, "ignore-secret-keys", "", "Comma-separated Secret keys to ignore when hashing.")
+	flag.StringVar(&includedConfigMapKeys, "include-configmap-keys", "", "Comma-separated ConfigMap keys that are the only ones hashed, if set. Overridable per ConfigMap with the synapse.gen0sec.com/include-keys annotation.")
+	flag.StringVar(&includedSecretKeys, "include-secret-keys", "", "Comma-separated Secret keys that are the only ones hashed, if set. Overridable per Secret with the synapse.gen0sec.com/include-keys annotation.")
+	flag.StringVar(&stateBackend, "state-store", state.BackendMemory, "Backend for operator state: memory, configmap, or crd.")
+	flag.StringVar(&stateNamespace, "state-namespace", defaultStateNamespace(), "Namespace of the state ConfigMap or SynapseOperatorState resource.")
+	flag.StringVar(&stateName, "state-name", "synapse-operator-state", "Name of the state ConfigMap or SynapseOperatorState resource.")
+	flag.BoolVar(&configDiff, "config-diff", false, "Emit a redacted unified diff of ConfigMap changes in events, logs, audit records and notifications. Secrets are never diffed.")
+	flag.BoolVar(&configChangeLogging, "config-change-logging", true, "Log a structured per-key summary of ConfigMap changes (keys added, removed, modified, line counts) and Secret changes (key names and value digests only). Set to false to disable config diffing entirely, including --config-diff.")
+	flag.IntVar(&configDiffMaxBytes, "config-diff-max-bytes", 1024, "Maximum size of a rendered ConfigMap diff.")
+	flag.Var(&configDiffRedact, "config-diff-redact-patterns", fmt.Sprintf("Regular expression selecting config lines whose values are redacted in diffs. Repeatable; replaces the default %q. An empty pattern redacts nothing but private keys.", controllers.DefaultConfigDiffRedactPatterns))
+	flag.StringVar(&restartStrategy, "restart-strategy", controllers.StrategyAnnotation, "Default restart strategy: annotation (patch the pod template with the hash), restarted-at (stamp a kubectl-style restartedAt timestamp), evict (evict outdated pods, respecting PodDisruptionBudgets), canary (evict a few pods first and roll the rest once they are ready), env (set the hash as a container environment variable as well), or versioned (point the pod template at immutable copies of the config sources named after their content). Overridable per workload with the synapse.gen0sec.com/restart-strategy annotation.")
+	flag.BoolVar(&rolloutImpact, "rollout-impact", false, "Log and record an event with the estimated impact (pods, nodes, PDB headroom, surge) before restarting a workload.")
+	flag.IntVar(&rolloutHistorySize, "rollout-history-size", 0, "Number of recent config hashes, with timestamps, kept in the synapse.gen0sec.com/rollout-history annotation of each workload. 0 disables the history.")
+	flag.DurationVar(&gradualRolloutWindow, "gradual-rollout-window", 0, "Spread the restarts of all outdated workloads in a namespace evenly over this duration, persisted in the state store so the pace survives operator restarts. 0 restarts them all at once. Overridable per namespace with the synapse.gen0sec.com/gradual-rollout-window annotation.")
+	flag.IntVar(&maxRollingNamespaces, "max-rolling-namespaces", 0, "Maximum number of namespaces restarting workloads at once; the others wait in line, in the order their changes arrived, until a rolling namespace's restarted workloads are available again. 0 means no limit.")
+	flag.StringVar(&rolloutTiers, "rollout-tiers", "", "Comma-separated tiers, in rollout order (e.g. dev,staging,prod), that namespaces are assigned to with the "+controllers.RolloutTierLabel+" label. A namespace rolls out only once every namespace of the earlier tiers has rolled out and soaked. Empty disables tiers.")
+	flag.DurationVar(&rolloutTierSoak, "rollout-tier-soak", 10*time.Minute, "How long the namespaces of a tier must run their new hash on available workloads before the next tier rolls out.")
+	flag.BoolVar(&canaryManualApproval, "canary-manual-approval", false, "Hold healthy canaries of the canary restart strategy until the workload is annotated with synapse.gen0sec.com/canary-approved=<config hash>.")
+	flag.BoolVar(&allowRecreateRestarts, "allow-recreate-restarts", false, "Restart Deployments using the Recreate update strategy on config changes without the synapse.gen0sec.com/allow-recreate-restarts confirmation annotation.")
+	flag.IntVar(&rolloutHistoryRetention, "rollout-history-retention", 0, "Number of rollouts, with snapshots of their ConfigMap sources, kept in the SynapseRolloutHistory of each namespace; annotate it with synapse.gen0sec.com/rollback-to=<revision> to roll back. Requires the SynapseRolloutHistory CRD. 0 disables the history.")
+	flag.StringVar(&onboardingPolicy, "onboarding-policy", controllers.OnboardingOff, "Onboarding of Synapse workloads the label selector does not match yet: off, report (record an OnboardingCandidate event on the Namespace), or label (apply the selector labels to the workloads and the ConfigMaps and Secrets they mount).")
+	flag.StringVar(&onboardingImagePattern, "onboarding-image-pattern", controllers.DefaultOnboardingImagePattern, "Regular expression matching container images that identify a Synapse workload for onboarding.")
+	flag.StringVar(&onboardingChartSelector, "onboarding-chart-selector", controllers.DefaultOnboardingChartSelector, "Label selector matching workloads of Synapse Helm charts for onboarding. Empty disables chart detection.")
+	flag.BoolVar(&manageAppservices, "manage-appservices", false, "Assemble the appservice registrations in Secrets labelled synapse.gen0sec.com/appservice-registration=true into a generated Secret listing them in app_service_config_files, so adding or removing a bridge rolls the homeserver.")
+	flag.StringVar(&appservicesSecretName, "appservices-secret-name", controllers.DefaultAppservicesSecretName, "Name of the Secret generated in each namespace with appservice registrations, with --manage-appservices.")
+	flag.StringVar(&appservicesMountPath, "appservices-mount-path", controllers.DefaultAppservicesMountPath, "Path the homeserver mounts the generated appservices Secret at, with --manage-appservices.")
+	flag.BoolVar(&manageWorkerTopology, "manage-worker-topology", false, "Create, scale and delete Synapse worker Deployments as declared in the upstreams.yaml of ConfigMaps annotated with synapse.gen0sec.com/worker-topology=<synapse Deployment>.")
+	flag.BoolVar(&renderConfigTemplates, "render-config-templates", false, "Render ConfigMaps annotated with synapse.gen0sec.com/config-template, with the values sources they list, into the ConfigMap or Secret the annotation names, labelled to be hashed like any other config source.")
+	flag.StringVar(&notificationConfig, "notification-config", "", "Path to a YAML or JSON file listing notification sinks for triggered and failed rollouts. Mount it from a Secret: webhook URLs are credentials.")
+	flag.Var(&notificationSinks, "notification-sink", "Notification sink as <type>=<url>, where type is webhook, slack, or teams. Repeatable; added to the sinks from --notification-config.")
+	flag.DurationVar(&notificationTimeout, "notification-timeout", 10*time.Second, "Timeout for delivering one notification to one sink.")
+	flag.StringVar(&restartedAtAnnotation, "restarted-at-annotation", controllers.DefaultRestartedAtAnnotation, "Pod template annotation stamped with the restart time by the restarted-at strategy.")
+	flag.BoolVar(&immutableConfigSources, "immutable-config-sources", false, "Treat the immutable ConfigMaps sharing a "+controllers.ConfigSeriesLabel+" label, such as synapse-config-<rev>, as revisions of one config source: only the latest revision is hashed, so creating one rolls out and deleting a superseded one does not.")
+	flag.BoolVar(&pruneSupersededConfigSources, "prune-superseded-config-sources", false, "With --immutable-config-sources, delete the revisions of each series beyond --config-revisions-retention that no workload, ReplicaSet or Pod in their namespace references anymore.")
+	flag.IntVar(&configRevisionsRetention, "config-revisions-retention", controllers.DefaultConfigRevisionsRetention, "Number of revisions of each immutable config series --prune-superseded-config-sources keeps for rollbacks, the latest included, besides those still referenced. 0 keeps every revision.")
+	flag.IntVar(&versionedCopiesRetention, "versioned-copies-retention", controllers.DefaultVersionedCopiesRetention, "Number of immutable copies of each config source the versioned restart strategy keeps for rollbacks, besides those still referenced by a workload. 0 keeps every copy.")
+	flag.StringVar(&zoneTopologyKey, "zone-topology-key", "", "Node label placing pods in zones, such as topology.kubernetes.io/zone. When set, the evict strategy restarts StatefulSets with at most one pod restarting per zone at a time. Empty disables zone awareness.")
+	flag.BoolVar(&conformanceMode, "conformance-mode", false, "Refuse, log, and count any write outside the allow-list derived from the enabled features.")
+	flag.StringVar(&dryRunPatches, "dry-run-patches", dryrun.ModeOff, "Development aid: send every patch and update as a server-side dry run first and log the diff the API server would apply. One of off, log (then write for real), or only (never write).")
+	flag.BoolVar(&watchSecretProviderClasses, "watch-secret-provider-classes", false, "Include matching Secrets Store CSI SecretProviderClasses and the object versions mounted from them in the config hash. Requires the secrets-store.csi.x-k8s.io CRDs.")
+	flag.BoolVar(&watchExternalSecrets, "watch-external-secrets", false, "Include the spec and synced version of External Secrets Operator ExternalSecrets targeting config Secrets (or matching the source selector) in the config hash. Requires the external-secrets.io CRDs.")
+	flag.BoolVar(&watchCertificates, "watch-certificates", false, "Include the issuance (status.revision and status.notAfter) of cert-manager Certificates matching the source selector in the config hash, so re-issued certificates roll workloads even when their Secrets are not labelled. Requires the cert-manager.io CRDs.")
+	flag.StringVar(&vaultAddress, "vault-address", "", "Vault address, such as https://vault.vault:8200. When set, the versions of the Vault KV v2 secrets listed in the synapse.gen0sec.com/vault-paths annotation of config sources are included in the config hash.")
+	flag.StringVar(&vaultRole, "vault-role", "", "Vault Kubernetes auth role to log in with using the operator's service account token. Empty uses the token in the VAULT_TOKEN environment variable.")
+	flag.StringVar(&vaultAuthMount, "vault-auth-mount", vault.DefaultAuthMount, "Path the Vault Kubernetes auth method is enabled at.")
+	flag.StringVar(&vaultNamespace, "vault-namespace", "", "Vault Enterprise namespace of the secrets.")
+	flag.DurationVar(&vaultPollInterval, "vault-poll-interval", controllers.DefaultVaultPollInterval, "How often the Vault secrets referenced by a namespace are read again to detect rotations.")
+	flag.BoolVar(&sopsAwareHashing, "sops-aware-hashing", false, "Hash SOPS-encrypted YAML and JSON values by their key structure and MAC instead of their ciphertext, so re-encrypting them (sops updatekeys, data key rotation) does not roll workloads.")
+	flag.StringVar(&sopsAgeKeyFile, "sops-age-key-file", "", "Path to an age identity file, mounted from a Secret, that opens the data key of SOPS documents with --sops-aware-hashing. The MAC is then hashed decrypted, so re-encrypting with a new data key does not roll workloads either.")
+	flag.StringVar(&sourceClassPolicies, "source-class-policies", "", "Comma-separated class=policy[/debounce] overrides of the default source class policies, e.g. ca-bundle=debounce/10m,helm-release=restart. Classes: helm-release, tls-secret, ca-bundle, generated, app-config. Policies: restart, ignore, debounce.")
+	flag.IntVar(&auditRetention, "audit-retention", 0, "Number of rollout decision records kept in the state store for `synapse-operator explain`. 0 disables auditing.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
@@ -63,11 +341,173 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := validateLeaderElectionTimings(leaseDuration, renewDeadline, retryPeriod); err != nil {
+		setupLog.Error(err, "invalid leader election timings")
+		os.Exit(1)
+	}
+
 	selector, err := parseLabelSelector(labelSelector)
 	if err != nil {
 		setupLog.Error(err, "invalid label selector", "selector", labelSelector)
 		os.Exit(1)
 	}
+	sourceSelector, err := parseOptionalLabelSelector(sourceLabelSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid source label selector", "selector", sourceLabelSelector)
+		os.Exit(1)
+	}
+	workloadSelector, err := parseOptionalLabelSelector(workloadLabelSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid workload label selector", "selector", workloadLabelSelector)
+		os.Exit(1)
+	}
+	var sourceAnnotationSelector labels.Selector
+	if strings.TrimSpace(annotationSelector) != "" {
+		if sourceAnnotationSelector, err = labels.Parse(annotationSelector); err != nil {
+			setupLog.Error(err, "invalid annotation selector", "selector", annotationSelector)
+			os.Exit(1)
+		}
+	}
+
+	if !controllers.ValidRestartStrategy(restartStrategy) {
+		setupLog.Error(nil, "unknown restart strategy", "strategy", restartStrategy)
+		os.Exit(1)
+	}
+
+	sourceRules, err := controllers.OverrideSourceClassPolicies(controllers.DefaultSourceRules(), sourceClassPolicies)
+	if err != nil {
+		setupLog.Error(err, "invalid source-class-policies")
+		os.Exit(1)
+	}
+
+	if detectByImage != "" && !controllers.ValidImagePattern(detectByImage) {
+		setupLog.Error(nil, "invalid detect-by-image pattern", "pattern", detectByImage)
+		os.Exit(1)
+	}
+
+	if !dryrun.ValidMode(dryRunPatches) {
+		setupLog.Error(nil, "unknown dry-run-patches mode", "mode", dryRunPatches)
+		os.Exit(1)
+	}
+
+	if !controllers.ValidOnboardingPolicy(onboardingPolicy) {
+		setupLog.Error(nil, "unknown onboarding policy", "policy", onboardingPolicy)
+		os.Exit(1)
+	}
+
+	excludedNamespaces := parseKeySet(excludeNamespaces)
+	if _, ok := excludedNamespaces[watchedNamespace]; ok {
+		setupLog.Error(nil, "the watched namespace is excluded by exclude-namespaces", "namespace", watchedNamespace)
+		os.Exit(1)
+	}
+
+	if groupByOwner && (requireApproval || gradualRolloutWindow > 0 || canaryNamespaces || rolloutHistoryRetention > 0 || rolloutTiers != "") {
+		setupLog.Error(nil, "group-by-owner cannot be combined with require-approval, gradual-rollout-window, canary-namespaces, rollout-history-retention, or rollout-tiers, which track one hash per namespace")
+		os.Exit(1)
+	}
+	if rolloutTiers != "" && watchedNamespace != "" {
+		setupLog.Error(nil, "rollout-tiers needs an operator watching all namespaces, so it cannot be combined with namespace")
+		os.Exit(1)
+	}
+	if pruneSupersededConfigSources && !immutableConfigSources {
+		setupLog.Error(nil, "prune-superseded-config-sources requires immutable-config-sources")
+		os.Exit(1)
+	}
+	if configRevisionsRetention < 0 {
+		setupLog.Error(nil, "config-revisions-retention must not be negative", "configRevisionsRetention", configRevisionsRetention)
+		os.Exit(1)
+	}
+	if rolloutTierSoak < 0 {
+		setupLog.Error(nil, "rollout-tier-soak must not be negative", "rolloutTierSoak", rolloutTierSoak)
+		os.Exit(1)
+	}
+	if groupByComponent && (groupByOwner || requireApproval || gradualRolloutWindow > 0 || canaryNamespaces || rolloutHistoryRetention > 0) {
+		setupLog.Error(nil, "group-by-component cannot be combined with group-by-owner, require-approval, gradual-rollout-window, canary-namespaces, or rollout-history-retention")
+		os.Exit(1)
+	}
+	if secretMetadataOnly && (manageAppservices || renderConfigTemplates || syncRoutes) {
+		setupLog.Error(nil, "secret-metadata-only cannot be combined with manage-appservices, render-config-templates, or sync-routes, which read Secret data from the cache")
+		os.Exit(1)
+	}
+	if trimWorkloadCache && manageWorkerTopology {
+		setupLog.Error(nil, "trim-workload-cache cannot be combined with manage-worker-topology, which copies whole Deployments from the cache")
+		os.Exit(1)
+	}
+	if leastPrivilege && (onboardingPolicy != controllers.OnboardingOff || manageWorkerTopology) {
+		setupLog.Error(nil, "least-privilege cannot be combined with onboarding-policy or manage-worker-topology, which watch workloads regardless of the operator's access")
+		os.Exit(1)
+	}
+	if clusterKubeconfigNamespace != "" && watchedNamespace != "" && clusterKubeconfigNamespace != watchedNamespace {
+		setupLog.Error(nil, "cluster-kubeconfig-namespace must be the watched namespace when namespace is set")
+		os.Exit(1)
+	}
+	clusterSelector, err := labels.Parse(clusterKubeconfigSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid cluster-kubeconfig-selector")
+		os.Exit(1)
+	}
+	if autoRollback && (rolloutProgressTimeout <= 0 || rolloutHistoryRetention <= 0) {
+		setupLog.Error(nil, "auto-rollback requires rollout-progress-timeout and rollout-history-retention")
+		os.Exit(1)
+	}
+
+	if !controllers.ValidDriftRepairMode(driftRepair) {
+		setupLog.Error(nil, "unknown drift-repair mode", "mode", driftRepair)
+		os.Exit(1)
+	}
+	if !controllers.ValidGitOpsCompatMode(gitOpsCompat) {
+		setupLog.Error(nil, "unknown gitops-compat mode", "mode", gitOpsCompat)
+		os.Exit(1)
+	}
+	companionAnnotations, err := controllers.GitOpsCompanionAnnotations(gitOpsCompat, gitOpsCompanionAnnotations)
+	if err != nil {
+		setupLog.Error(err, "invalid gitops-companion-annotations")
+		os.Exit(1)
+	}
+	if serverSideApplyForce && !serverSideApply {
+		setupLog.Error(nil, "server-side-apply-force requires server-side-apply")
+		os.Exit(1)
+	}
+	if restartInFlightJobs && !manageCronJobs {
+		setupLog.Error(nil, "restart-in-flight-jobs requires manage-cronjobs")
+		os.Exit(1)
+	}
+
+	if configDiff && !configChangeLogging {
+		setupLog.Info("config-change-logging=false disables config diffing entirely; ignoring --config-diff")
+		configDiff = false
+	}
+
+	if len(configDiffRedact) == 0 {
+		configDiffRedact = controllers.DefaultConfigDiffRedactPatterns
+	}
+	redactPatterns, err := controllers.CompileRedactPatterns(configDiffRedact)
+	if err != nil {
+		setupLog.Error(err, "invalid config-diff-redact-patterns")
+		os.Exit(1)
+	}
+
+	if blockProfileRate < 0 || mutexProfileFraction < 0 {
+		setupLog.Error(nil, "block-profile-rate and mutex-profile-fraction must not be negative")
+		os.Exit(1)
+	}
+	if blockProfileRate > 0 {
+		goruntime.SetBlockProfileRate(blockProfileRate)
+	}
+	if mutexProfileFraction > 0 {
+		goruntime.SetMutexProfileFraction(mutexProfileFraction)
+	}
+
+	shutdownTracing := func(context.Context) error { return nil }
+	if otlpEndpoint != "" {
+		shutdown, err := tracing.Setup(context.Background(), tracing.Options{Endpoint: otlpEndpoint, Insecure: otlpInsecure, SampleRatio: traceSampleRatio})
+		if err != nil {
+			setupLog.Error(err, "unable to set up tracing")
+			os.Exit(1)
+		}
+		shutdownTracing = shutdown
+		setupLog.Info("tracing enabled", "endpoint", otlpEndpoint, "sampleRatio", traceSampleRatio)
+	}
 
 	ignoredConfigMapSet := parseKeySet(ignoredConfigMapKeys)
 	ignoredSecretSet := parseKeySet(ignoredSecretKeys)
@@ -76,12 +516,38 @@ func main() {
 		Scheme: scheme,
 		Metrics: metricsserver.Options{
 			BindAddress: metricsAddr,
+			// The default endpoint does not negotiate OpenMetrics, which carries the trigger ID exemplars.
+			ExtraHandlers: map[string]http.Handler{
+				"/metrics/openmetrics": promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{
+					ErrorHandling:     promhttp.HTTPErrorOnError,
+					EnableOpenMetrics: true,
+				}),
+			},
 		},
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "86a223f3.synapse.gen0sec.com",
+		HealthProbeBindAddress:  probeAddr,
+		PprofBindAddress:        pprofAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        "86a223f3.synapse.gen0sec.com",
+		LeaderElectionNamespace: leaderElectionNamespace,
+		LeaseDuration:           &leaseDuration,
+		RenewDeadline:           &renewDeadline,
+		RetryPeriod:             &retryPeriod,
 	}
 
+	if injectConfigHash {
+		mgrOptions.WebhookServer = webhook.NewServer(webhook.Options{Port: webhookPort, CertDir: webhookCertDir})
+	}
+
+	mgrOptions.Cache.ByObject = map[client.Object]cache.ByObject{}
+	if secretMetadataOnly {
+		mgrOptions.Cache.ByObject[&corev1.Secret{}] = cache.ByObject{Transform: controllers.StripSecretData}
+	}
+	if trimWorkloadCache {
+		trim := controllers.TrimWorkload(helmCoalesceWindow > 0)
+		for _, obj := range []client.Object{&appsv1.Deployment{}, &appsv1.DaemonSet{}, &appsv1.StatefulSet{}} {
+			mgrOptions.Cache.ByObject[obj] = cache.ByObject{Transform: trim}
+		}
+	}
 	if watchedNamespace != "" {
 		mgrOptions.Cache.DefaultNamespaces = map[string]cache.Config{
 			watchedNamespace: {},
@@ -94,17 +560,333 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.ConfigMapReconciler{
-		Client:               mgr.GetClient(),
-		Scheme:               mgr.GetScheme(),
-		LabelSelector:        selector,
-		ConfigHashAnnotation: configHashAnnotation,
-		IgnoredConfigMapKeys: ignoredConfigMapSet,
-		IgnoredSecretKeys:    ignoredSecretSet,
-	}).SetupWithManager(mgr); err != nil {
+	var rules []conformance.Rule
+	if conformanceMode {
+		rules = conformanceRules(stateNamespace, conformanceFeatures{
+			RolloutHistory:  rolloutHistoryRetention > 0,
+			Onboarding:      onboardingPolicy == controllers.OnboardingLabel,
+			CronJobs:        manageCronJobs,
+			InFlightJobs:    restartInFlightJobs,
+			CanaryReplay:    canaryNamespaces,
+			Routes:          syncRoutes,
+			Appservices:     manageAppservices,
+			WorkerTopology:  manageWorkerTopology,
+			ConfigTemplates: renderConfigTemplates,
+			ValidationJobs:  validationJobImage != "",
+			VersionedCopies: restartStrategy == controllers.StrategyVersioned,
+			ConfigRevisions: pruneSupersededConfigSources,
+		})
+		setupLog.Info("conformance mode enabled", "allowed", rules)
+	}
+	if dryRunPatches != dryrun.ModeOff {
+		setupLog.Info("dry-run patches enabled; writes are previewed against the API server", "mode", dryRunPatches)
+	}
+	// operatorClient wraps the client of a manager, of this cluster or a remote one, for conformance mode and
+	// dry-run patches.
+	operatorClient := func(c client.Client) client.Client {
+		if conformanceMode {
+			c = conformance.NewClient(c, rules)
+		}
+		if dryRunPatches != dryrun.ModeOff {
+			c = dryrun.NewClient(c, dryRunPatches == dryrun.ModeOnly)
+		}
+		return c
+	}
+	k8sClient := operatorClient(mgr.GetClient())
+
+	for _, gate := range pipeline.Gates() {
+		setupLog.Info("plugin gate registered", "gate", gate.Name())
+	}
+	for _, verifier := range pipeline.Verifiers() {
+		setupLog.Info("plugin verifier registered", "verifier", verifier.Name())
+	}
+
+	stateStore, err := state.New(stateBackend, k8sClient, mgr.GetAPIReader(), types.NamespacedName{
+		Namespace: stateNamespace,
+		Name:      stateName,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create state store", "backend", stateBackend)
+		os.Exit(1)
+	}
+	// The last-known hashes live in an object of their own, so they can be read and reset apart from the rest
+	// of the state.
+	hashStore, err := state.New(stateBackend, k8sClient, mgr.GetAPIReader(), types.NamespacedName{
+		Namespace: stateNamespace,
+		Name:      stateName + "-hashes",
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create hash store", "backend", stateBackend)
+		os.Exit(1)
+	}
+
+	var auditLog *audit.Log
+	if auditRetention > 0 {
+		if stateBackend == state.BackendMemory {
+			setupLog.Info("audit records are kept in memory and cannot be read by explain; use a persistent --state-store")
+		}
+		auditLog = &audit.Log{Store: stateStore, Retention: auditRetention}
+	}
+
+	sopsCanonicalizer, err := newSOPSCanonicalizer(sopsAwareHashing, sopsAgeKeyFile)
+	if err != nil {
+		setupLog.Error(err, "invalid sops configuration")
+		os.Exit(1)
+	}
+
+	vaultReader, err := newVaultReader(vaultAddress, vaultRole, vaultAuthMount, vaultNamespace, vaultPollInterval)
+	if err != nil {
+		setupLog.Error(err, "invalid vault configuration")
+		os.Exit(1)
+	}
+
+	notifier, err := newNotifier(notificationConfig, notificationSinks, notificationTimeout)
+	if err != nil {
+		setupLog.Error(err, "invalid notification configuration")
+		os.Exit(1)
+	}
+	if notifier != nil {
+		if err := mgr.Add(notifier); err != nil {
+			setupLog.Error(err, "unable to set up notifications")
+			os.Exit(1)
+		}
+	}
+
+	if err := mgr.Add(&bootstrap.Task{
+		Bootstrapper: bootstrap.Bootstrapper{
+			Client: k8sClient,
+			Owner:  "state",
+			Kinds:  []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("ConfigMap"), state.StateGVK},
+		},
+		Namespace: stateNamespace,
+		Desired:   append(state.Artifacts(stateBackend, stateName), state.Artifacts(stateBackend, stateName+"-hashes")...),
+	}); err != nil {
+		setupLog.Error(err, "unable to set up state bootstrap")
+		os.Exit(1)
+	}
+
+	// newReconciler builds the ConfigMapReconciler of this cluster, with cluster empty, or of the remote cluster
+	// called cluster, on its manager.
+	newReconciler := func(cluster string, mgr ctrl.Manager, c client.Client, stateStore, hashStore state.Store) *controllers.ConfigMapReconciler {
+		return &controllers.ConfigMapReconciler{
+			ClusterName:                  cluster,
+			Client:                       c,
+			Scheme:                       mgr.GetScheme(),
+			LabelSelector:                selector,
+			SourceLabelSelector:          sourceSelector,
+			WorkloadLabelSelector:        workloadSelector,
+			AnnotationSelector:           sourceAnnotationSelector,
+			DetectByImage:                detectByImage,
+			ManageCronJobs:               manageCronJobs,
+			RestartInFlightJobs:          restartInFlightJobs,
+			MaxConcurrentReconciles:      maxConcurrentReconciles,
+			NamespaceQPS:                 namespaceQPS,
+			NamespaceBurst:               namespaceBurst,
+			CleanupReleasedWorkloads:     cleanupReleasedWorkloads,
+			CleanupReleasedPodTemplates:  cleanupReleasedPodTemplates,
+			HelmCoalesceWindow:           helmCoalesceWindow,
+			MaxSourcesPerNamespace:       maxSourcesPerNamespace,
+			RecordConfigSources:          recordConfigSources,
+			AnnotateRestartCause:         annotateRestartCause,
+			MinRestartInterval:           minRestartInterval,
+			DeferRestartsOnPDB:           deferRestartsOnPDB,
+			ConfigCheckURL:               configCheckURL,
+			ConfigCheckTimeout:           configCheckTimeout,
+			ConfigCheckSecrets:           configCheckSecrets,
+			ValidationJobImage:           validationJobImage,
+			ValidationJobCommand:         validationJobCommand,
+			ValidationJobServiceAccount:  validationJobServiceAccount,
+			ValidationJobTimeout:         validationJobTimeout,
+			GitOpsCompanionAnnotations:   companionAnnotations,
+			RolloutProgressTimeout:       rolloutProgressTimeout,
+			AutoRollback:                 autoRollback,
+			RolloutLock:                  rolloutLock,
+			RequireApproval:              requireApproval,
+			GroupByOwner:                 groupByOwner,
+			GroupByComponent:             groupByComponent,
+			InjectConfigHash:             injectConfigHash,
+			StatusAPIBindAddress:         statusAPIAddr,
+			CanaryNamespaces:             canaryNamespaces,
+			Gates:                        pipeline.Gates(),
+			Verifiers:                    pipeline.Verifiers(),
+			StartupSettleDelay:           startupSettleDelay,
+			SuppressStartupRollouts:      suppressStartupRollouts,
+			DriftRepair:                  driftRepair,
+			ServerSideApply:              serverSideApply,
+			ForceServerSideApply:         serverSideApplyForce,
+			PatchRetryAttempts:           patchRetryAttempts,
+			PatchRetryBackoff:            patchRetryBackoff,
+			SyncRoutes:                   syncRoutes,
+			ExcludedNamespaces:           excludedNamespaces,
+			ConfigHashAnnotation:         configHashAnnotation,
+			AcceptLegacyHashes:           acceptLegacyHashes,
+			IgnoredConfigMapKeys:         ignoredConfigMapSet,
+			IgnoredSecretKeys:            ignoredSecretSet,
+			IncludedConfigMapKeys:        parseKeySet(includedConfigMapKeys),
+			IncludedSecretKeys:           parseKeySet(includedSecretKeys),
+			StateStore:                   stateStore,
+			HashStore:                    hashStore,
+			Recorder:                     mgr.GetEventRecorderFor("synapse-operator"),
+			APIReader:                    mgr.GetAPIReader(),
+			RestartStrategy:              restartStrategy,
+			ConformanceMode:              conformanceMode,
+			ReportImpact:                 rolloutImpact,
+			RolloutHistorySize:           rolloutHistorySize,
+			GradualRolloutWindow:         gradualRolloutWindow,
+			MaxRollingNamespaces:         maxRollingNamespaces,
+			RolloutTiers:                 parseList(rolloutTiers),
+			RolloutTierSoak:              rolloutTierSoak,
+			AllowRecreateRestarts:        allowRecreateRestarts,
+			CanaryManualApproval:         canaryManualApproval,
+			RolloutHistoryRetention:      rolloutHistoryRetention,
+			SourceRules:                  sourceRules,
+			Audit:                        auditLog,
+			Notifier:                     notifier,
+			RestartedAtAnnotation:        restartedAtAnnotation,
+			VersionedCopiesRetention:     versionedCopiesRetention,
+			ImmutableConfigSources:       immutableConfigSources,
+			PruneSupersededConfigSources: pruneSupersededConfigSources,
+			ConfigRevisionsRetention:     configRevisionsRetention,
+			ZoneTopologyKey:              zoneTopologyKey,
+			WatchSecretProviderClasses:   watchSecretProviderClasses,
+			WatchExternalSecrets:         watchExternalSecrets,
+			WatchCertificates:            watchCertificates,
+			IgnoreSecrets:                !watchSecrets,
+			SecretMetadataOnly:           secretMetadataOnly,
+			LeastPrivilege:               leastPrivilege,
+			WatchedNamespace:             watchedNamespace,
+			Vault:                        vaultReader,
+			VaultPollInterval:            vaultPollInterval,
+			SOPS:                         sopsCanonicalizer,
+			CacheReader:                  mgr.GetCache(),
+			ConfigDiff: controllers.ConfigDiffOptions{
+				Enabled:        configDiff,
+				Structured:     configChangeLogging,
+				MaxBytes:       configDiffMaxBytes,
+				RedactPatterns: redactPatterns,
+			},
+		}
+	}
+	reconciler := newReconciler("", mgr, k8sClient, stateStore, hashStore)
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ConfigMap")
 		os.Exit(1)
 	}
+	if watchSecrets {
+		if err = (&controllers.SecretReconciler{
+			Rollouts:                reconciler,
+			MaxConcurrentReconciles: secretMaxConcurrentReconciles,
+			Debounce:                secretDebounce,
+			IgnoredNames:            parseList(ignoredSecrets),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Secret")
+			os.Exit(1)
+		}
+	}
+
+	if clusterKubeconfigNamespace != "" {
+		registry := &controllers.ClusterRegistry{
+			APIReader: mgr.GetAPIReader(),
+			Recorder:  mgr.GetEventRecorderFor("synapse-operator"),
+			Namespace: clusterKubeconfigNamespace,
+			Selector:  clusterSelector,
+			Connect: func(name string, config *rest.Config) (manager.Runnable, error) {
+				// The hub serves metrics, probes and webhooks and holds the leadership for every cluster.
+				options := mgrOptions
+				options.Metrics = metricsserver.Options{BindAddress: "0"}
+				options.HealthProbeBindAddress = ""
+				options.PprofBindAddress = ""
+				options.LeaderElection = false
+				options.WebhookServer = nil
+				options.Logger = ctrl.Log.WithValues("cluster", name)
+				options.Controller.SkipNameValidation = ptr.To(true)
+				clusterMgr, err := ctrl.NewManager(config, options)
+				if err != nil {
+					return nil, err
+				}
+				// Remote clusters share the state objects of the hub, each under a prefix of its own.
+				prefix := "clusters/" + name + "/"
+				clusterReconciler := newReconciler(name, clusterMgr, operatorClient(clusterMgr.GetClient()), state.WithPrefix(stateStore, prefix), state.WithPrefix(hashStore, prefix))
+				clusterReconciler.StatusAPIBindAddress = ""
+				clusterReconciler.InjectConfigHash = false
+				clusterReconciler.RolloutHistoryRetention = 0
+				if err := clusterReconciler.SetupWithManager(clusterMgr); err != nil {
+					return nil, err
+				}
+				if watchSecrets {
+					if err := (&controllers.SecretReconciler{
+						Rollouts:                clusterReconciler,
+						MaxConcurrentReconciles: secretMaxConcurrentReconciles,
+						Debounce:                secretDebounce,
+						IgnoredNames:            parseList(ignoredSecrets),
+					}).SetupWithManager(clusterMgr); err != nil {
+						return nil, err
+					}
+				}
+				return clusterMgr, nil
+			},
+		}
+		if err := registry.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ClusterRegistry")
+			os.Exit(1)
+		}
+		setupLog.Info("multi-cluster mode enabled", "namespace", clusterKubeconfigNamespace, "selector", clusterSelector.String())
+	}
+
+	if rolloutHistoryRetention > 0 {
+		if err = (&controllers.RolloutHistoryReconciler{
+			Client:    k8sClient,
+			APIReader: mgr.GetAPIReader(),
+			Recorder:  mgr.GetEventRecorderFor("synapse-operator"),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", controllers.RolloutHistoryGVK.Kind)
+			os.Exit(1)
+		}
+	}
+
+	if onboardingPolicy != controllers.OnboardingOff {
+		onboarding, err := newOnboardingReconciler(k8sClient, mgr, selector, sourceSelector, workloadSelector, onboardingPolicy, onboardingImagePattern, onboardingChartSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid onboarding configuration")
+			os.Exit(1)
+		}
+		if err := onboarding.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Onboarding")
+			os.Exit(1)
+		}
+	}
+
+	if manageAppservices {
+		appservices, err := newAppserviceReconciler(k8sClient, mgr, selector, sourceSelector, appservicesSecretName, appservicesMountPath)
+		if err != nil {
+			setupLog.Error(err, "invalid appservices configuration")
+			os.Exit(1)
+		}
+		if err := appservices.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Appservices")
+			os.Exit(1)
+		}
+	}
+
+	if renderConfigTemplates {
+		configTemplates, err := newConfigTemplateReconciler(k8sClient, mgr, selector, sourceSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid config template configuration")
+			os.Exit(1)
+		}
+		if err := configTemplates.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ConfigTemplates")
+			os.Exit(1)
+		}
+	}
+
+	if manageWorkerTopology {
+		workerTopology := &controllers.WorkerTopologyReconciler{Client: k8sClient, Recorder: mgr.GetEventRecorderFor("synapse-operator")}
+		if err := workerTopology.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "WorkerTopology")
+			os.Exit(1)
+		}
+	}
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
@@ -117,12 +899,332 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctrl.SetupSignalHandler())
+	// Flush the spans of the last reconciles before exiting.
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if shutdownErr := shutdownTracing(flushCtx); shutdownErr != nil {
+		setupLog.Error(shutdownErr, "failed to flush traces")
+	}
+	cancel()
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
 
+// conformanceFeatures are the optional features that widen the conformance allow-list.
+type conformanceFeatures struct {
+	RolloutHistory  bool
+	Onboarding      bool
+	CronJobs        bool
+	InFlightJobs    bool
+	CanaryReplay    bool
+	Routes          bool
+	Appservices     bool
+	WorkerTopology  bool
+	ConfigTemplates bool
+	ValidationJobs  bool
+	// VersionedCopies is set when the versioned restart strategy is the default. Workloads cannot select it
+	// otherwise in conformance mode.
+	VersionedCopies bool
+	// ConfigRevisions is set when superseded immutable config revisions are pruned.
+	ConfigRevisions bool
+}
+
+// conformanceRules lists every write the operator's features may perform. Keep it in sync with new
+// features: anything missing here is refused at runtime when --conformance-mode is set.
+func conformanceRules(stateNamespace string, features conformanceFeatures) []conformance.Rule {
+	rules := []conformance.Rule{
+		// Restart strategies.
+		{Group: "apps", Resource: "deployments", Verb: "patch"},
+		{Group: "apps", Resource: "daemonsets", Verb: "patch"},
+		{Group: "apps", Resource: "statefulsets", Verb: "patch"},
+		{Resource: "pods/eviction", Verb: "create"},
+	}
+	// State store and its bootstrap, which also prunes the objects of a previously used backend.
+	for _, resource := range []struct{ group, resource string }{
+		{"", "configmaps"},
+		{state.StateGVK.Group, "synapseoperatorstates"},
+	} {
+		for _, verb := range []string{"create", "update", "patch", "delete"} {
+			rules = append(rules, conformance.Rule{Group: resource.group, Resource: resource.resource, Verb: verb, Namespace: stateNamespace})
+		}
+	}
+	if features.RolloutHistory {
+		// Rollout history records, their rollback annotation, and the ConfigMaps a rollback restores.
+		for _, verb := range []string{"create", "update", "patch"} {
+			rules = append(rules, conformance.Rule{Group: controllers.RolloutHistoryGVK.Group, Resource: "synapserollouthistories", Verb: verb})
+		}
+		rules = append(rules,
+			conformance.Rule{Resource: "configmaps", Verb: "create"},
+			conformance.Rule{Resource: "configmaps", Verb: "update"},
+		)
+	}
+	if features.CronJobs {
+		rules = append(rules, conformance.Rule{Group: "batch", Resource: "cronjobs", Verb: "patch"})
+	}
+	if features.InFlightJobs {
+		// Suspending and resuming the running Jobs of a CronJob.
+		rules = append(rules, conformance.Rule{Group: "batch", Resource: "jobs", Verb: "patch"})
+	}
+	if features.CanaryReplay {
+		// ConfigMaps replayed into canary namespaces.
+		rules = append(rules,
+			conformance.Rule{Resource: "configmaps", Verb: "create"},
+			conformance.Rule{Resource: "configmaps", Verb: "update"},
+		)
+	}
+	if features.Routes {
+		// Backend ports of the routes moved by --sync-routes.
+		rules = append(rules,
+			conformance.Rule{Group: "networking.k8s.io", Resource: "ingresses", Verb: "patch"},
+			conformance.Rule{Group: "gateway.networking.k8s.io", Resource: "httproutes", Verb: "patch"},
+		)
+	}
+	if features.Appservices {
+		// The generated Secret listing the appservice registrations.
+		rules = append(rules,
+			conformance.Rule{Resource: "secrets", Verb: "create"},
+			conformance.Rule{Resource: "secrets", Verb: "update"},
+		)
+	}
+	if features.ConfigTemplates {
+		// The objects config templates are rendered into.
+		for _, resource := range []string{"configmaps", "secrets"} {
+			rules = append(rules,
+				conformance.Rule{Resource: resource, Verb: "create"},
+				conformance.Rule{Resource: resource, Verb: "update"},
+			)
+		}
+	}
+	if features.WorkerTopology {
+		// Worker Deployments declared in upstreams.yaml, scaled with the patch above.
+		rules = append(rules,
+			conformance.Rule{Group: "apps", Resource: "deployments", Verb: "create"},
+			conformance.Rule{Group: "apps", Resource: "deployments", Verb: "delete"},
+		)
+	}
+	if features.ValidationJobs {
+		// The Jobs validating each config hash before it rolls out.
+		rules = append(rules, conformance.Rule{Group: "batch", Resource: "jobs", Verb: "create"})
+	}
+	if features.VersionedCopies {
+		// Immutable copies of the config sources, and their pruning.
+		for _, resource := range []string{"configmaps", "secrets"} {
+			rules = append(rules,
+				conformance.Rule{Resource: resource, Verb: "create"},
+				conformance.Rule{Resource: resource, Verb: "delete"},
+			)
+		}
+	}
+	if features.ConfigRevisions {
+		// Superseded immutable config revisions no workload references.
+		rules = append(rules, conformance.Rule{Resource: "configmaps", Verb: "delete"})
+	}
+	if features.Onboarding {
+		// Selector labels applied to onboarded config sources; workloads are covered by the restart strategies.
+		rules = append(rules,
+			conformance.Rule{Resource: "configmaps", Verb: "patch"},
+			conformance.Rule{Resource: "secrets", Verb: "patch"},
+		)
+	}
+	return rules
+}
+
+// notificationQueueSize bounds the notifications waiting for delivery; further ones are dropped.
+const notificationQueueSize = 100
+
+// newNotifier builds the notification dispatcher from its flags, or returns nil when no sink is configured.
+func newNotifier(configPath string, sinkFlags []string, timeout time.Duration) (*notify.Dispatcher, error) {
+	var configs []notify.SinkConfig
+	if configPath != "" {
+		cfg, err := notify.LoadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg.Sinks...)
+	}
+	for _, value := range sinkFlags {
+		cfg, err := notify.ParseSinkFlag(value)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	sinks, err := notify.NewSinks(configs, &http.Client{})
+	if err != nil {
+		return nil, err
+	}
+	return notify.NewDispatcher(sinks, notificationQueueSize, timeout), nil
+}
+
+// newVaultReader builds the Vault client from its flags, or returns nil when --vault-address is not set.
+func newVaultReader(address, role, authMount, namespace string, pollInterval time.Duration) (controllers.VaultReader, error) {
+	if address == "" {
+		return nil, nil
+	}
+	if pollInterval <= 0 {
+		return nil, fmt.Errorf("--vault-poll-interval must be positive")
+	}
+	c, err := vault.New(vault.Options{
+		Address:   address,
+		Role:      role,
+		AuthMount: authMount,
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newSOPSCanonicalizer builds the SOPS canonicalizer from its flags, or returns nil when --sops-aware-hashing
+// is not set.
+func newSOPSCanonicalizer(enabled bool, ageKeyFile string) (controllers.ValueCanonicalizer, error) {
+	if !enabled {
+		if ageKeyFile != "" {
+			return nil, fmt.Errorf("--sops-age-key-file requires --sops-aware-hashing")
+		}
+		return nil, nil
+	}
+	c := &sops.Canonicalizer{}
+	if ageKeyFile != "" {
+		identities, err := sops.LoadAgeIdentities(ageKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Identities = identities
+	}
+	return c, nil
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// newOnboardingReconciler builds the onboarding controller from its flags.
+func newOnboardingReconciler(c client.Client, mgr ctrl.Manager, selector, sourceSelector, workloadSelector labels.Selector, policy, imagePattern, chartSelector string) (*controllers.OnboardingReconciler, error) {
+	if workloadSelector != nil {
+		selector = workloadSelector
+	}
+	selectorLabels, err := controllers.SelectorLabels(selector)
+	if err != nil {
+		return nil, err
+	}
+	var sourceLabels map[string]string
+	if sourceSelector != nil {
+		if sourceLabels, err = controllers.SelectorLabels(sourceSelector); err != nil {
+			return nil, err
+		}
+	}
+	image, err := regexp.Compile(imagePattern)
+	if err != nil {
+		return nil, fmt.Errorf("onboarding-image-pattern: %w", err)
+	}
+	var chart labels.Selector
+	if strings.TrimSpace(chartSelector) != "" {
+		if chart, err = labels.Parse(chartSelector); err != nil {
+			return nil, fmt.Errorf("onboarding-chart-selector: %w", err)
+		}
+	}
+	return &controllers.OnboardingReconciler{
+		Client:         c,
+		Recorder:       mgr.GetEventRecorderFor("synapse-operator"),
+		Selector:       selector,
+		Labels:         selectorLabels,
+		SourceSelector: sourceSelector,
+		SourceLabels:   sourceLabels,
+		ImagePattern:   image,
+		ChartSelector:  chart,
+		Policy:         policy,
+	}, nil
+}
+
+// newConfigTemplateReconciler builds the config template controller. Rendered objects are labelled to match
+// the source selector, so they are hashed like any other config source.
+func newConfigTemplateReconciler(c client.Client, mgr ctrl.Manager, selector, sourceSelector labels.Selector) (*controllers.ConfigTemplateReconciler, error) {
+	if sourceSelector != nil {
+		selector = sourceSelector
+	}
+	selectorLabels, err := controllers.SelectorLabels(selector)
+	if err != nil {
+		return nil, err
+	}
+	return &controllers.ConfigTemplateReconciler{
+		Client:   c,
+		Recorder: mgr.GetEventRecorderFor("synapse-operator"),
+		Labels:   selectorLabels,
+	}, nil
+}
+
+// newAppserviceReconciler builds the appservices controller from its flags. The generated Secret is labelled
+// to match the source selector, so it is hashed like any other config source.
+func newAppserviceReconciler(c client.Client, mgr ctrl.Manager, selector, sourceSelector labels.Selector, secretName, mountPath string) (*controllers.AppserviceReconciler, error) {
+	if sourceSelector != nil {
+		selector = sourceSelector
+	}
+	selectorLabels, err := controllers.SelectorLabels(selector)
+	if err != nil {
+		return nil, err
+	}
+	if errs := validation.IsDNS1123Subdomain(secretName); len(errs) > 0 {
+		return nil, fmt.Errorf("appservices-secret-name: %s", strings.Join(errs, ", "))
+	}
+	if !path.IsAbs(mountPath) {
+		return nil, fmt.Errorf("appservices-mount-path: %q is not an absolute path", mountPath)
+	}
+	return &controllers.AppserviceReconciler{
+		Client:     c,
+		Recorder:   mgr.GetEventRecorderFor("synapse-operator"),
+		SecretName: secretName,
+		MountPath:  mountPath,
+		Labels:     selectorLabels,
+	}, nil
+}
+
+// validateLeaderElectionTimings enforces the ordering client-go requires: lease > renew deadline > retry period.
+func validateLeaderElectionTimings(lease, renew, retry time.Duration) error {
+	if retry <= 0 {
+		return fmt.Errorf("retry period must be positive, got %s", retry)
+	}
+	if renew <= retry {
+		return fmt.Errorf("renew deadline (%s) must be greater than retry period (%s)", renew, retry)
+	}
+	if lease <= renew {
+		return fmt.Errorf("lease duration (%s) must be greater than renew deadline (%s)", lease, renew)
+	}
+	return nil
+}
+
+// defaultStateNamespace prefers the namespace the operator runs in, as exposed through the downward API.
+func defaultStateNamespace() string {
+	if ns := strings.TrimSpace(os.Getenv("POD_NAMESPACE")); ns != "" {
+		return ns
+	}
+	return "synapse-system"
+}
+
+// parseOptionalLabelSelector parses a selector that defaults to another one, returning nil when value is
+// empty.
+func parseOptionalLabelSelector(value string) (labels.Selector, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	return labels.Parse(value)
+}
+
 func parseLabelSelector(value string) (labels.Selector, error) {
 	if strings.TrimSpace(value) == "" {
 		return labels.Everything(), nil
@@ -130,6 +1232,17 @@ func parseLabelSelector(value string) (labels.Selector, error) {
 	return labels.Parse(value)
 }
 
+// parseList splits a comma-separated flag value, dropping empty items.
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func parseKeySet(value string) map[string]struct{} {
 	items := strings.Split(value, ",")
 	if len(items) == 0 {
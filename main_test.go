@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"synapse-operator/controllers"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -277,6 +287,19 @@ func TestParseLabelSelector(t *testing.T) {
 	assert.True(t, selector.Matches(labels.Set{"anything": "goes"}))
 }
 
+func TestParseOptionalLabelSelector(t *testing.T) {
+	selector, err := parseOptionalLabelSelector("")
+	require.NoError(t, err)
+	assert.Nil(t, selector)
+
+	selector, err = parseOptionalLabelSelector("config.example.com/synapse=true")
+	require.NoError(t, err)
+	assert.True(t, selector.Matches(labels.Set{"config.example.com/synapse": "true"}))
+
+	_, err = parseOptionalLabelSelector("=")
+	assert.Error(t, err)
+}
+
 func TestParseKeySet(t *testing.T) {
 	set := parseKeySet("a,b, c , ,")
 	assert.Len(t, set, 3)
@@ -289,3 +312,95 @@ func TestParseKeySet(t *testing.T) {
 
 	assert.Nil(t, parseKeySet(""))
 }
+
+func TestStringListKeepsCommas(t *testing.T) {
+	var patterns stringList
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&patterns, "config-diff-redact-patterns", "")
+	require.NoError(t, fs.Parse([]string{"-config-diff-redact-patterns", `key_[a-z]{1,3}`, "-config-diff-redact-patterns", "(api,bot)_token"}))
+	assert.Equal(t, stringList{`key_[a-z]{1,3}`, "(api,bot)_token"}, patterns)
+}
+
+func TestValidateLeaderElectionTimings(t *testing.T) {
+	assert.NoError(t, validateLeaderElectionTimings(15*time.Second, 10*time.Second, 2*time.Second))
+	assert.Error(t, validateLeaderElectionTimings(10*time.Second, 10*time.Second, 2*time.Second))
+	assert.Error(t, validateLeaderElectionTimings(15*time.Second, 2*time.Second, 2*time.Second))
+	assert.Error(t, validateLeaderElectionTimings(15*time.Second, 10*time.Second, 0))
+}
+
+func TestOperatorFlagArgs(t *testing.T) {
+	fs := flag.NewFlagSet("exemptions", flag.ContinueOnError)
+	fs.String("namespace", "", "")
+	fs.String("config-hash-annotation", "", "")
+	fs.Bool("manage-cronjobs", false, "")
+	deploy := &appsv1.Deployment{}
+	deploy.Spec.Template.Spec.Containers = []corev1.Container{{
+		Command: []string{"/app/manager"},
+		Args: []string{
+			"--leader-elect",
+			"--namespace", "matrix",
+			"--config-hash-annotation=example.com/hash",
+			"--manage-cronjobs",
+			"--label-selector", "app=synapse",
+		},
+	}}
+
+	args := operatorFlagArgs(fs, deploy, exemptionOperatorFlags)
+	assert.Equal(t, []string{"--namespace", "matrix", "--config-hash-annotation=example.com/hash", "--manage-cronjobs"}, args)
+	require.NoError(t, fs.Parse(args))
+	assert.Equal(t, "matrix", fs.Lookup("namespace").Value.String())
+}
+
+func TestRolloutLockAcquireAndRelease(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	now := time.Now()
+
+	require.NoError(t, acquireRolloutLock(ctx, c, "matrix", "pipeline-1", 10*time.Minute, now))
+	assert.Error(t, acquireRolloutLock(ctx, c, "matrix", "pipeline-2", 10*time.Minute, now))
+	require.NoError(t, acquireRolloutLock(ctx, c, "matrix", "pipeline-1", 10*time.Minute, now.Add(time.Minute)), "renewal")
+	assert.Error(t, releaseRolloutLock(ctx, c, "matrix", "pipeline-2"))
+
+	// An expired lock can be taken over.
+	require.NoError(t, acquireRolloutLock(ctx, c, "matrix", "pipeline-2", 10*time.Minute, now.Add(time.Hour)))
+	lease := &coordinationv1.Lease{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: controllers.RolloutLockName}, lease))
+	assert.Equal(t, "pipeline-2", *lease.Spec.HolderIdentity)
+	assert.Equal(t, int32(2), *lease.Spec.LeaseTransitions)
+
+	require.NoError(t, releaseRolloutLock(ctx, c, "matrix", "pipeline-2"))
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, client.ObjectKey{Namespace: "matrix", Name: controllers.RolloutLockName}, lease)))
+	require.NoError(t, releaseRolloutLock(ctx, c, "matrix", "pipeline-2"), "releasing a free lock")
+}
+
+func TestPauseAndResumeRollouts(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix", Annotations: map[string]string{"owner": "chat"}}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ns, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "element"}}).Build()
+	now := time.Date(2026, 3, 1, 14, 2, 0, 0, time.UTC)
+
+	require.NoError(t, pauseRollouts(ctx, c, "matrix", "alice", "INC-42", now))
+	require.NoError(t, acquireRolloutLock(ctx, c, "matrix", "pipeline-1", 10*time.Minute, now))
+	statuses, err := rolloutStatuses(ctx, c, nil, now)
+	require.NoError(t, err)
+	require.Len(t, statuses, 1, "only paused namespaces are listed by default")
+	assert.Equal(t, "matrix", statuses[0].Namespace)
+	assert.True(t, statuses[0].Paused)
+	assert.Equal(t, "alice", statuses[0].PausedBy)
+	assert.Equal(t, "INC-42", statuses[0].PausedReason)
+	assert.Equal(t, now, *statuses[0].PausedAt)
+	assert.Equal(t, "pipeline-1", statuses[0].LockHolder)
+
+	var out strings.Builder
+	require.NoError(t, writeRolloutStatuses(&out, statuses, "text"))
+	assert.Contains(t, out.String(), "INC-42")
+
+	require.NoError(t, resumeRollouts(ctx, c, "matrix"))
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "matrix"}, ns))
+	assert.Equal(t, map[string]string{"owner": "chat"}, ns.Annotations, "only the pause annotations are removed")
+	statuses, err = rolloutStatuses(ctx, c, []string{"matrix", "element"}, now)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "element", statuses[0].Namespace)
+	assert.False(t, statuses[1].Paused)
+}
@@ -0,0 +1,187 @@
+// Package notify delivers rollout notifications to external sinks (generic webhooks, Slack, Microsoft
+// Teams). Delivery is asynchronous: reconciles hand events to a Dispatcher, which never blocks them.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	deliveriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_notifications_total",
+			Help: "Notifications delivered to sinks, by sink and result.",
+		},
+		[]string{"sink", "result"},
+	)
+	droppedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "synapse_operator_notifications_dropped_total",
+			Help: "Notifications dropped because the delivery queue was full.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(deliveriesTotal, droppedTotal)
+}
+
+// Event types.
+const (
+	EventRolloutTriggered = "triggered"
+	EventRolloutFailed    = "failed"
+	// EventRolloutStalled is sent when a restarted workload does not become ready within the progress timeout.
+	EventRolloutStalled = "stalled"
+	// EventRollbackTriggered is sent when a stalled rollout is rolled back automatically.
+	EventRollbackTriggered = "rollback"
+)
+
+// Event is one rollout worth telling someone about.
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	// Source is the config source whose change started the rollout, as "<kind>/<name>".
+	Source    string   `json:"source,omitempty"`
+	Hash      string   `json:"hash,omitempty"`
+	Workloads []string `json:"workloads,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	// Diff is the redacted, size-capped unified diff of the triggering ConfigMap, if --config-diff is set.
+	Diff string `json:"diff,omitempty"`
+	// Transaction is the ID of the decision record, for `synapse-operator explain`.
+	Transaction string `json:"transaction,omitempty"`
+	// TriggerID is the ID stamped on the workloads and Events of the rollout.
+	TriggerID string `json:"triggerId,omitempty"`
+}
+
+// Summary renders ev as one line of text for chat sinks.
+func (ev Event) Summary() string {
+	var b strings.Builder
+	switch ev.Type {
+	case EventRolloutFailed:
+		fmt.Fprintf(&b, "Rollout failed in %s", ev.Namespace)
+	case EventRolloutStalled:
+		fmt.Fprintf(&b, "Rollout stalled in %s", ev.Namespace)
+	case EventRollbackTriggered:
+		fmt.Fprintf(&b, "Rolling back in %s", ev.Namespace)
+	default:
+		fmt.Fprintf(&b, "Rollout triggered in %s", ev.Namespace)
+	}
+	if ev.Source != "" {
+		fmt.Fprintf(&b, " by %s", ev.Source)
+	}
+	if ev.Hash != "" {
+		hash := ev.Hash[strings.LastIndexByte(ev.Hash, ':')+1:]
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		fmt.Fprintf(&b, ": config hash %s", hash)
+	}
+	if len(ev.Workloads) > 0 {
+		fmt.Fprintf(&b, " -> %s", strings.Join(ev.Workloads, ", "))
+	}
+	if ev.Error != "" {
+		fmt.Fprintf(&b, " (error: %s)", ev.Error)
+	}
+	if ev.Transaction != "" {
+		fmt.Fprintf(&b, " [transaction %s]", ev.Transaction)
+	}
+	return b.String()
+}
+
+// text renders ev for chat sinks: its summary, followed by the diff as a code block.
+func (ev Event) text() string {
+	if ev.Diff == "" {
+		return ev.Summary()
+	}
+	return ev.Summary() + "\n```\n" + breakBacktickRuns(strings.TrimSuffix(ev.Diff, "\n")) + "\n```"
+}
+
+// breakBacktickRuns separates adjacent backticks in s with a zero-width space, so config values quoting
+// Markdown cannot close the code block of text early. Chat sinks have no longer fence to fall back to.
+func breakBacktickRuns(s string) string {
+	if !strings.Contains(s, "``") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '`' && i > 0 && s[i-1] == '`' {
+			b.WriteString("\u200b")
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Sink delivers events to one destination.
+type Sink interface {
+	// Name identifies the sink in logs and metrics.
+	Name() string
+	Send(ctx context.Context, ev Event) error
+}
+
+// Dispatcher queues events and delivers them to every sink from a background goroutine. Its methods are safe
+// to call on a nil Dispatcher, which drops every event.
+type Dispatcher struct {
+	sinks   []Sink
+	queue   chan Event
+	timeout time.Duration
+}
+
+// NewDispatcher returns a Dispatcher buffering up to queueSize events, each delivered to each sink with the
+// given timeout. It delivers nothing until started.
+func NewDispatcher(sinks []Sink, queueSize int, timeout time.Duration) *Dispatcher {
+	return &Dispatcher{sinks: sinks, queue: make(chan Event, queueSize), timeout: timeout}
+}
+
+// Notify queues ev for delivery. It never blocks: when the queue is full the event is dropped and counted.
+func (d *Dispatcher) Notify(ev Event) {
+	if d == nil || len(d.sinks) == 0 {
+		return
+	}
+	select {
+	case d.queue <- ev:
+	default:
+		droppedTotal.Inc()
+		ctrl.Log.WithName("notify").Info("Dropping notification, delivery queue is full", "namespace", ev.Namespace, "type", ev.Type)
+	}
+}
+
+// Start delivers queued events until ctx is done. It implements manager.Runnable.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	logger := ctrl.Log.WithName("notify")
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-d.queue:
+			for _, sink := range d.sinks {
+				if filtered, ok := sink.(filteredSink); ok && !filtered.accepts(ev) {
+					continue
+				}
+				sendCtx, cancel := context.WithTimeout(ctx, d.timeout)
+				err := sink.Send(sendCtx, ev)
+				cancel()
+				if err != nil {
+					deliveriesTotal.WithLabelValues(sink.Name(), "failed").Inc()
+					logger.Error(err, "failed to deliver notification", "sink", sink.Name(), "namespace", ev.Namespace)
+					continue
+				}
+				deliveriesTotal.WithLabelValues(sink.Name(), "sent").Inc()
+			}
+		}
+	}
+}
+
+// NeedLeaderElection lets every replica drain its own queue; only the leader reconciles, so only the
+// leader queues events.
+func (d *Dispatcher) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatcherDeliversToSinks(t *testing.T) {
+	received := make(chan map[string]interface{}, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		body["path"] = req.URL.Path
+		received <- body
+	}))
+	defer server.Close()
+
+	sinks, err := NewSinks([]SinkConfig{
+		{Type: SinkSlack, URL: server.URL + "/slack"},
+		{Type: SinkWebhook, URL: server.URL + "/webhook", Events: []string{EventRolloutFailed}},
+	}, server.Client())
+	require.NoError(t, err)
+	d := NewDispatcher(sinks, 10, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = d.Start(ctx) }()
+
+	d.Notify(Event{Type: EventRolloutTriggered, Namespace: "matrix", Source: "configmap/homeserver", Hash: "0123456789abcdef", Workloads: []string{"deployment/synapse"}})
+	d.Notify(Event{Type: EventRolloutFailed, Namespace: "matrix", Error: "boom"})
+
+	var bodies []map[string]interface{}
+	for i := 0; i < 3; i++ {
+		select {
+		case body := <-received:
+			bodies = append(bodies, body)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d notifications delivered", len(bodies))
+		}
+	}
+	assert.Equal(t, "/slack", bodies[0]["path"])
+	assert.Equal(t, "Rollout triggered in matrix by configmap/homeserver: config hash 0123456789ab -> deployment/synapse", bodies[0]["text"])
+	assert.Equal(t, "/slack", bodies[1]["path"])
+	assert.Equal(t, "/webhook", bodies[2]["path"])
+	assert.Equal(t, "boom", bodies[2]["error"])
+}
+
+func TestNotifyNeverBlocks(t *testing.T) {
+	sinks, err := NewSinks([]SinkConfig{{Type: SinkWebhook, URL: "http://127.0.0.1:1"}}, nil)
+	require.NoError(t, err)
+	d := NewDispatcher(sinks, 1, time.Second)
+	d.Notify(Event{Namespace: "matrix"})
+	d.Notify(Event{Namespace: "matrix"})
+
+	var nilDispatcher *Dispatcher
+	nilDispatcher.Notify(Event{Namespace: "matrix"})
+}
+
+func TestSinkConfiguration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("sinks:\n- type: teams\n  url: https://example.invalid/hook\n  events: [failed]\n"), 0o600))
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Sinks, 1)
+	assert.Equal(t, SinkTeams, cfg.Sinks[0].Type)
+
+	sink, err := ParseSinkFlag("slack=https://hooks.slack.invalid/services/T0/B0/secret")
+	require.NoError(t, err)
+	assert.Equal(t, SinkSlack, sink.Type)
+	_, err = ParseSinkFlag("slack")
+	assert.Error(t, err)
+
+	_, err = NewSinks([]SinkConfig{{Type: "pager", URL: "https://example.invalid"}}, nil)
+	assert.Error(t, err)
+	_, err = NewSinks([]SinkConfig{{Type: SinkSlack, URL: "https://example.invalid", Events: []string{"started"}}}, nil)
+	assert.Error(t, err)
+}
+
+func TestChatTextCarriesDiff(t *testing.T) {
+	ev := Event{Type: EventRolloutTriggered, Namespace: "matrix", Diff: "-log_level: INFO\n+log_level: DEBUG\n"}
+	assert.Equal(t, "Rollout triggered in matrix\n```\n-log_level: INFO\n+log_level: DEBUG\n```", ev.text())
+	ev.Diff = ""
+	assert.Equal(t, ev.Summary(), ev.text())
+}
+
+func TestChatTextBreaksBacktickRuns(t *testing.T) {
+	ev := Event{Type: EventRolloutTriggered, Namespace: "matrix", Diff: "+motd: \"```\\n@room\"\n"}
+	text := ev.text()
+	assert.Equal(t, 2, strings.Count(text, "```"), "only the fences of the code block remain")
+	assert.Contains(t, text, "+motd: \"`\u200b`\u200b`\\n@room\"")
+	assert.Equal(t, "a `b` c", breakBacktickRuns("a `b` c"), "single backticks are kept")
+}
@@ -0,0 +1,188 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Sink types accepted in the notification config and --notification-sink.
+const (
+	SinkWebhook = "webhook"
+	SinkSlack   = "slack"
+	SinkTeams   = "teams"
+)
+
+// SinkConfig configures one sink.
+type SinkConfig struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// Events limits the sink to these event types; empty sends every event.
+	Events []string `json:"events,omitempty"`
+}
+
+// Config is the notification config file.
+type Config struct {
+	Sinks []SinkConfig `json:"sinks"`
+}
+
+// LoadConfig reads a YAML or JSON notification config file. Keep it in a Secret: webhook URLs are
+// credentials.
+func LoadConfig(path string) (Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := yaml.UnmarshalStrict(raw, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseSinkFlag parses a "<type>=<url>" sink given on the command line. Errors never echo the URL.
+func ParseSinkFlag(value string) (SinkConfig, error) {
+	kind, target, ok := strings.Cut(value, "=")
+	if !ok || target == "" {
+		return SinkConfig{}, fmt.Errorf("notification sink of type %q has no url", kind)
+	}
+	return SinkConfig{Type: strings.TrimSpace(kind), URL: strings.TrimSpace(target)}, nil
+}
+
+// NewSinks builds the sinks described by configs.
+func NewSinks(configs []SinkConfig, client *http.Client) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(configs))
+	for _, cfg := range configs {
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("%s notification sink has no url", cfg.Type)
+		}
+		for _, event := range cfg.Events {
+			switch event {
+			case EventRolloutTriggered, EventRolloutFailed, EventRolloutStalled, EventRollbackTriggered:
+			default:
+				return nil, fmt.Errorf("unknown notification event %q", event)
+			}
+		}
+		post := httpPoster{client: client, url: cfg.URL}
+		var sink Sink
+		switch cfg.Type {
+		case SinkWebhook:
+			sink = webhookSink{post}
+		case SinkSlack:
+			sink = slackSink{post}
+		case SinkTeams:
+			sink = teamsSink{post}
+		default:
+			return nil, fmt.Errorf("unknown notification sink type %q", cfg.Type)
+		}
+		if len(cfg.Events) > 0 {
+			sink = filteredSink{Sink: sink, events: cfg.Events}
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// httpPoster posts JSON documents to one URL. The URL is never logged or included in errors, since chat
+// webhook URLs embed their credentials.
+type httpPoster struct {
+	client *http.Client
+	url    string
+}
+
+func (p httpPoster) post(ctx context.Context, body interface{}) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("building request: invalid url")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := p.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting notification to %s: %w", req.URL.Host, errorWithoutURL(err))
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("posting notification to %s: status %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// errorWithoutURL strips the request URL net/http wraps transport errors with.
+func errorWithoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// webhookSink posts the event itself as JSON.
+type webhookSink struct{ httpPoster }
+
+func (webhookSink) Name() string { return SinkWebhook }
+
+func (s webhookSink) Send(ctx context.Context, ev Event) error {
+	return s.post(ctx, ev)
+}
+
+// slackSink posts to a Slack incoming webhook.
+type slackSink struct{ httpPoster }
+
+func (slackSink) Name() string { return SinkSlack }
+
+func (s slackSink) Send(ctx context.Context, ev Event) error {
+	return s.post(ctx, map[string]string{"text": ev.text()})
+}
+
+// teamsSink posts a MessageCard to a Microsoft Teams incoming webhook.
+type teamsSink struct{ httpPoster }
+
+func (teamsSink) Name() string { return SinkTeams }
+
+func (s teamsSink) Send(ctx context.Context, ev Event) error {
+	color := "2EB886"
+	if ev.Type != EventRolloutTriggered {
+		color = "D00000"
+	}
+	return s.post(ctx, map[string]string{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    "Synapse rollout " + ev.Type,
+		"themeColor": color,
+		"text":       ev.text(),
+	})
+}
+
+// filteredSink forwards only the configured event types.
+type filteredSink struct {
+	Sink
+	events []string
+}
+
+// accepts reports whether ev is one of the configured event types.
+func (s filteredSink) accepts(ev Event) bool {
+	for _, event := range s.events {
+		if event == ev.Type {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/controllers"
+)
+
+// runPause implements `synapse-operator pause|resume`, which on-call engineers use to freeze the operator's
+// restarts in namespaces during an incident, and to let them roll out the latest config again.
+func runPause(action string, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet(action, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var namespaces stringList
+	fs.Var(&namespaces, "namespace", "Namespace to "+action+" the rollouts of. Repeatable.")
+	by := fs.String("by", os.Getenv("USER"), "Who pauses the rollouts, recorded on the Namespace.")
+	reason := fs.String("reason", "", "Why the rollouts are paused, such as an incident ID, recorded on the Namespace.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(namespaces) == 0 {
+		fmt.Fprintf(stderr, "%s: --namespace is required\n", action)
+		return 2
+	}
+
+	c, err := newCLIClient()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", action, err)
+		return 1
+	}
+	ctx := context.Background()
+	for _, namespace := range namespaces {
+		if action == "pause" {
+			err = pauseRollouts(ctx, c, namespace, *by, *reason, time.Now())
+		} else {
+			err = resumeRollouts(ctx, c, namespace)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", action, err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "%s rollouts in %s\n", map[string]string{"pause": "Paused", "resume": "Resumed"}[action], namespace)
+	}
+	return 0
+}
+
+// runStatus implements `synapse-operator status`, which shows whether the rollouts of namespaces are held
+// and what was rolled out last.
+func runStatus(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var namespaces stringList
+	fs.Var(&namespaces, "namespace", "Namespace to show. Repeatable; defaults to every namespace with paused rollouts.")
+	output := fs.String("output", "text", "Output format: text or json.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "status: unknown output format %q\n", *output)
+		return 2
+	}
+
+	c, err := newCLIClient()
+	if err != nil {
+		fmt.Fprintf(stderr, "status: %v\n", err)
+		return 1
+	}
+	statuses, err := rolloutStatuses(context.Background(), c, namespaces, time.Now())
+	if err != nil {
+		fmt.Fprintf(stderr, "status: %v\n", err)
+		return 1
+	}
+	if err := writeRolloutStatuses(stdout, statuses, *output); err != nil {
+		fmt.Fprintf(stderr, "status: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func newCLIClient() (client.Client, error) {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(cfg, client.Options{Scheme: scheme})
+}
+
+// pauseRollouts sets RolloutsPausedAnnotation on namespace, recording who paused it, when, and why.
+func pauseRollouts(ctx context.Context, c client.Client, namespace, by, reason string, now time.Time) error {
+	return patchNamespaceAnnotations(ctx, c, namespace, func(annotations map[string]string) {
+		annotations[controllers.RolloutsPausedAnnotation] = "true"
+		annotations[controllers.RolloutsPausedAtAnnotation] = now.UTC().Format(time.RFC3339)
+		setOrDelete(annotations, controllers.RolloutsPausedByAnnotation, by)
+		setOrDelete(annotations, controllers.RolloutsPausedReasonAnnotation, reason)
+	})
+}
+
+// resumeRollouts removes the pause annotations from namespace, which rolls out its latest config hash.
+func resumeRollouts(ctx context.Context, c client.Client, namespace string) error {
+	return patchNamespaceAnnotations(ctx, c, namespace, func(annotations map[string]string) {
+		for _, key := range []string{controllers.RolloutsPausedAnnotation, controllers.RolloutsPausedAtAnnotation, controllers.RolloutsPausedByAnnotation, controllers.RolloutsPausedReasonAnnotation} {
+			delete(annotations, key)
+		}
+	})
+}
+
+func setOrDelete(annotations map[string]string, key, value string) {
+	if value == "" {
+		delete(annotations, key)
+		return
+	}
+	annotations[key] = value
+}
+
+// patchNamespaceAnnotations changes the annotations of namespace with edit in a merge patch, which touches
+// nothing else.
+func patchNamespaceAnnotations(ctx context.Context, c client.Client, namespace string, edit func(map[string]string)) error {
+	ns := &corev1.Namespace{}
+	if err := c.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return err
+	}
+	original := ns.DeepCopy()
+	annotations := ns.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	edit(annotations)
+	ns.SetAnnotations(annotations)
+	return c.Patch(ctx, ns, client.MergeFrom(original))
+}
+
+// namespaceRolloutStatus is what holds the rollouts of a namespace, and what it rolled out last.
+type namespaceRolloutStatus struct {
+	Namespace    string     `json:"namespace"`
+	Paused       bool       `json:"paused"`
+	PausedBy     string     `json:"pausedBy,omitempty"`
+	PausedAt     *time.Time `json:"pausedAt,omitempty"`
+	PausedReason string     `json:"pausedReason,omitempty"`
+	// LockHolder holds the rollout lock of the namespace, if anyone does.
+	LockHolder  string       `json:"lockHolder,omitempty"`
+	LastRollout *lastRollout `json:"lastRollout,omitempty"`
+}
+
+// lastRollout is the latest record of the SynapseRolloutHistory of a namespace.
+type lastRollout struct {
+	Revision int64     `json:"revision"`
+	Hash     string    `json:"hash"`
+	Time     time.Time `json:"time"`
+	Stalled  bool      `json:"stalled,omitempty"`
+}
+
+// rolloutStatuses returns the status of each of namespaces, or of every namespace with paused rollouts when
+// none are given.
+func rolloutStatuses(ctx context.Context, c client.Client, namespaces []string, now time.Time) ([]namespaceRolloutStatus, error) {
+	var objs []corev1.Namespace
+	if len(namespaces) == 0 {
+		list := &corev1.NamespaceList{}
+		if err := c.List(ctx, list); err != nil {
+			return nil, err
+		}
+		for _, ns := range list.Items {
+			if ns.Annotations[controllers.RolloutsPausedAnnotation] == "true" {
+				objs = append(objs, ns)
+			}
+		}
+	} else {
+		for _, name := range namespaces {
+			ns := corev1.Namespace{}
+			if err := c.Get(ctx, client.ObjectKey{Name: name}, &ns); err != nil {
+				return nil, err
+			}
+			objs = append(objs, ns)
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Name < objs[j].Name })
+
+	statuses := make([]namespaceRolloutStatus, 0, len(objs))
+	for _, ns := range objs {
+		status := namespaceRolloutStatus{
+			Namespace:    ns.Name,
+			Paused:       ns.Annotations[controllers.RolloutsPausedAnnotation] == "true",
+			PausedBy:     ns.Annotations[controllers.RolloutsPausedByAnnotation],
+			PausedReason: ns.Annotations[controllers.RolloutsPausedReasonAnnotation],
+		}
+		if at, err := time.Parse(time.RFC3339, ns.Annotations[controllers.RolloutsPausedAtAnnotation]); err == nil {
+			status.PausedAt = &at
+		}
+		lease := &coordinationv1.Lease{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: controllers.RolloutLockName}, lease); err == nil {
+			status.LockHolder, _ = controllers.RolloutLockHolder(lease, now)
+		} else if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		record, err := controllers.LatestRollout(ctx, c, ns.Name)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			status.LastRollout = &lastRollout{Revision: record.Revision, Hash: record.Hash, Time: record.Time, Stalled: record.Stalled}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func writeRolloutStatuses(w io.Writer, statuses []namespaceRolloutStatus, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	}
+	if len(statuses) == 0 {
+		_, err := fmt.Fprintln(w, "No namespace has its rollouts paused.")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tPAUSED\tSINCE\tBY\tREASON\tLOCKED BY\tLAST ROLLOUT")
+	for _, s := range statuses {
+		since := "-"
+		if s.PausedAt != nil {
+			since = s.PausedAt.Format(time.RFC3339)
+		}
+		last := "-"
+		if s.LastRollout != nil {
+			last = fmt.Sprintf("revision %d, %s at %s", s.LastRollout.Revision, s.LastRollout.Hash, s.LastRollout.Time.Format(time.RFC3339))
+			if s.LastRollout.Stalled {
+				last += " (stalled)"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%t\t%s\t%s\t%s\t%s\t%s\n", s.Namespace, s.Paused, since, orDash(s.PausedBy), orDash(s.PausedReason), orDash(s.LockHolder), last)
+	}
+	return tw.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
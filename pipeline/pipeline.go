@@ -0,0 +1,99 @@
+// Package pipeline runs a reconcile as a fixed sequence of stages: Collect gathers the config sources, Hash
+// combines them into the config hash, Decide applies the gates that can hold a rollout, Schedule plans which
+// workloads restart now, Apply patches them, and Verify follows up on what was applied. Each stage only
+// sees the Pass handed down by the previous ones, so stages can be tested on their own.
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Stage names, in the order the stages run.
+const (
+	Collect  = "collect"
+	Hash     = "hash"
+	Decide   = "decide"
+	Schedule = "schedule"
+	Apply    = "apply"
+	Verify   = "verify"
+)
+
+// Stage is one step of a pipeline. S is the state the stages of a pipeline share through the Pass.
+type Stage[S any] interface {
+	Name() string
+	Run(ctx context.Context, pass *Pass[S]) error
+}
+
+// Pass carries one run of a pipeline from stage to stage.
+type Pass[S any] struct {
+	// Namespace is the namespace being reconciled.
+	Namespace string
+	// Logger is the logger of the run; stages may add values to it for the stages after them.
+	Logger logr.Logger
+	// Hash is the combined config hash, set by the Hash stage.
+	Hash string
+	// State is the state the stages of the pipeline share.
+	State S
+
+	requeueAfter time.Duration
+	haltedBy     string
+	haltReason   string
+}
+
+// RequeueAfter asks for the namespace to be reconciled again after d. The soonest request wins; d <= 0
+// is ignored.
+func (p *Pass[S]) RequeueAfter(d time.Duration) {
+	if d > 0 && (p.requeueAfter == 0 || d < p.requeueAfter) {
+		p.requeueAfter = d
+	}
+}
+
+// Requeue returns the soonest requeue asked for, or 0 for none.
+func (p *Pass[S]) Requeue() time.Duration {
+	return p.requeueAfter
+}
+
+// Halt stops the pipeline after the current stage. The stages after it do not run.
+func (p *Pass[S]) Halt(reason string) {
+	p.haltReason = reason
+}
+
+// Halted returns the stage that halted the pipeline and why, or empty strings if it ran to the end.
+func (p *Pass[S]) Halted() (stage, reason string) {
+	return p.haltedBy, p.haltReason
+}
+
+// Pipeline is an ordered list of stages.
+type Pipeline[S any] []Stage[S]
+
+// Run runs the stages in order until one fails or halts the pass. The error of a failing stage is returned
+// unchanged.
+func (p Pipeline[S]) Run(ctx context.Context, pass *Pass[S]) error {
+	for _, stage := range p {
+		if err := stage.Run(ctx, pass); err != nil {
+			return err
+		}
+		if pass.haltReason != "" {
+			pass.haltedBy = stage.Name()
+			return nil
+		}
+	}
+	return nil
+}
+
+// StageFunc adapts a function to a Stage.
+type StageFunc[S any] struct {
+	StageName string
+	Func      func(ctx context.Context, pass *Pass[S]) error
+}
+
+func (s StageFunc[S]) Name() string {
+	return s.StageName
+}
+
+func (s StageFunc[S]) Run(ctx context.Context, pass *Pass[S]) error {
+	return s.Func(ctx, pass)
+}
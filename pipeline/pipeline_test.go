@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recordingStage(name string, run func(*Pass[[]string])) Stage[[]string] {
+	return StageFunc[[]string]{StageName: name, Func: func(_ context.Context, pass *Pass[[]string]) error {
+		pass.State = append(pass.State, name)
+		if run != nil {
+			run(pass)
+		}
+		return nil
+	}}
+}
+
+func TestPipelineRunsStagesInOrderUntilHalted(t *testing.T) {
+	p := Pipeline[[]string]{
+		recordingStage(Collect, nil),
+		recordingStage(Hash, func(pass *Pass[[]string]) { pass.RequeueAfter(time.Minute) }),
+		recordingStage(Decide, func(pass *Pass[[]string]) {
+			pass.RequeueAfter(10 * time.Second)
+			pass.RequeueAfter(0)
+			pass.Halt("paused")
+		}),
+		recordingStage(Schedule, nil),
+	}
+	pass := &Pass[[]string]{Namespace: "matrix", Logger: logr.Discard()}
+
+	require.NoError(t, p.Run(context.Background(), pass))
+	assert.Equal(t, []string{Collect, Hash, Decide}, pass.State)
+	assert.Equal(t, 10*time.Second, pass.Requeue(), "the soonest requeue wins")
+	stage, reason := pass.Halted()
+	assert.Equal(t, Decide, stage)
+	assert.Equal(t, "paused", reason)
+}
+
+func TestPipelineStopsAtFailingStage(t *testing.T) {
+	failure := errors.New("boom")
+	p := Pipeline[[]string]{
+		StageFunc[[]string]{StageName: Collect, Func: func(context.Context, *Pass[[]string]) error { return failure }},
+		recordingStage(Hash, nil),
+	}
+	pass := &Pass[[]string]{}
+
+	assert.ErrorIs(t, p.Run(context.Background(), pass), failure)
+	assert.Empty(t, pass.State)
+	stage, _ := pass.Halted()
+	assert.Empty(t, stage)
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Gate is a Decide-stage plugin: it can hold the rollout of a namespace, for example while an
+// organization's change calendar or CMDB forbids it. Gates are compiled in and registered with RegisterGate
+// from an init function, so a fork adds one with a blank import in main.
+type Gate interface {
+	// Name identifies the gate in logs, events, and `synapse-operator explain`.
+	Name() string
+	// Check decides on the rollout of a hash. An error fails the reconcile, which is retried with backoff.
+	Check(ctx context.Context, rollout Rollout) (Decision, error)
+}
+
+// Verifier is a Verify-stage plugin, run after the workloads of a namespace have been rolled.
+type Verifier interface {
+	// Name identifies the verifier in logs.
+	Name() string
+	// Verify checks the rollout after Apply. An error fails the reconcile, which is retried with backoff.
+	Verify(ctx context.Context, rollout Rollout) error
+}
+
+// Rollout describes the rollout plugins decide on or verify.
+type Rollout struct {
+	Namespace string
+	Hash      string
+	// Workloads are the targeted workloads; for verifiers, the ones Apply went through.
+	Workloads []Workload
+}
+
+// Workload is a targeted workload of a Rollout.
+type Workload struct {
+	Kind string
+	Name string
+	// AppliedHash is the hash the workload ran before this rollout.
+	AppliedHash string
+	// Updated is set for verifiers when this pass wrote the new hash to the workload.
+	Updated bool
+}
+
+// Decision is what a Gate decided.
+type Decision struct {
+	// Hold keeps the rollout back; Reason says why.
+	Hold   bool
+	Reason string
+	// RetryAfter is when to check again while held; zero waits for the next config or namespace change.
+	RetryAfter time.Duration
+}
+
+var (
+	registryMu sync.Mutex
+	gates      = map[string]Gate{}
+	verifiers  = map[string]Verifier{}
+)
+
+// RegisterGate adds a Decide-stage gate. It panics when a gate of the same name is already registered.
+func RegisterGate(gate Gate) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := gates[gate.Name()]; ok {
+		panic(fmt.Sprintf("pipeline: gate %q registered twice", gate.Name()))
+	}
+	gates[gate.Name()] = gate
+}
+
+// RegisterVerifier adds a Verify-stage verifier. It panics when a verifier of the same name is already
+// registered.
+func RegisterVerifier(verifier Verifier) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := verifiers[verifier.Name()]; ok {
+		panic(fmt.Sprintf("pipeline: verifier %q registered twice", verifier.Name()))
+	}
+	verifiers[verifier.Name()] = verifier
+}
+
+// Gates returns the registered gates sorted by name.
+func Gates() []Gate {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registered := make([]Gate, 0, len(gates))
+	for _, gate := range gates {
+		registered = append(registered, gate)
+	}
+	sort.Slice(registered, func(i, j int) bool { return registered[i].Name() < registered[j].Name() })
+	return registered
+}
+
+// Verifiers returns the registered verifiers sorted by name.
+func Verifiers() []Verifier {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registered := make([]Verifier, 0, len(verifiers))
+	for _, verifier := range verifiers {
+		registered = append(registered, verifier)
+	}
+	sort.Slice(registered, func(i, j int) bool { return registered[i].Name() < registered[j].Name() })
+	return registered
+}
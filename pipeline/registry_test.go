@@ -0,0 +1,40 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type namedGate string
+
+func (g namedGate) Name() string { return string(g) }
+
+func (namedGate) Check(context.Context, Rollout) (Decision, error) { return Decision{}, nil }
+
+type namedVerifier string
+
+func (v namedVerifier) Name() string { return string(v) }
+
+func (namedVerifier) Verify(context.Context, Rollout) error { return nil }
+
+func TestRegisterGateKeepsNameOrder(t *testing.T) {
+	RegisterGate(namedGate("test-registry-zeta"))
+	RegisterGate(namedGate("test-registry-alpha"))
+
+	var names []string
+	for _, gate := range Gates() {
+		names = append(names, gate.Name())
+	}
+	assert.Subset(t, names, []string{"test-registry-alpha", "test-registry-zeta"})
+	assert.IsIncreasing(t, names)
+	assert.Panics(t, func() { RegisterGate(namedGate("test-registry-alpha")) })
+}
+
+func TestRegisterVerifier(t *testing.T) {
+	RegisterVerifier(namedVerifier("test-registry-smoke"))
+
+	assert.Contains(t, Verifiers(), Verifier(namedVerifier("test-registry-smoke")))
+	assert.Panics(t, func() { RegisterVerifier(namedVerifier("test-registry-smoke")) })
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/controllers"
+	"synapse-operator/preflight"
+	"synapse-operator/state"
+)
+
+// preflightOperatorFlags are the operator flags that decide what the operator needs from the cluster and
+// which workloads it would restart. `preflight --from-deployment` reads them from the args of the running
+// operator.
+var preflightOperatorFlags = map[string]struct{}{
+	"namespace":                 {},
+	"exclude-namespaces":        {},
+	"label-selector":            {},
+	"source-label-selector":     {},
+	"workload-label-selector":   {},
+	"detect-by-image":           {},
+	"group-by-owner":            {},
+	"group-by-component":        {},
+	"config-hash-annotation":    {},
+	"accept-legacy-hashes":      {},
+	"restart-strategy":          {},
+	"ignore-configmap-keys":     {},
+	"ignore-secret-keys":        {},
+	"include-configmap-keys":    {},
+	"include-secret-keys":       {},
+	"watch-secrets":             {},
+	"source-class-policies":     {},
+	"manage-cronjobs":           {},
+	"sync-routes":               {},
+	"manage-appservices":        {},
+	"manage-worker-topology":    {},
+	"render-config-templates":   {},
+	"validation-job-image":      {},
+	"zone-topology-key":         {},
+	"state-store":               {},
+	"state-namespace":           {},
+	"rollout-history-retention": {},
+	"leader-elect":              {},
+	"leader-election-namespace": {},
+	"inject-config-hash":        {},
+}
+
+// runPreflight implements `synapse-operator preflight`, which checks a live cluster before an upgrade to
+// this version of the operator and prints a go/no-go report. It exits 1 on no-go.
+func runPreflight(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fromDeployment := fs.String("from-deployment", "", "<namespace>/<name> of the operator Deployment to read the service account and operator flags from.")
+	serviceAccount := fs.String("service-account", "synapse-system/synapse-operator", "<namespace>/<name> of the operator's service account.")
+	webhook := fs.String("webhook", "synapse-operator-config-hash", "MutatingWebhookConfiguration checked with --inject-config-hash.")
+	webhookCertSecret := fs.String("webhook-cert-secret", "synapse-system/synapse-operator-webhook-cert", "<namespace>/<name> of the webhook's serving certificate Secret, checked against the CA bundle with --inject-config-hash. Empty skips it.")
+	maxRestarts := fs.Int("max-restarts", 10, "Number of workloads a hash format change may restart at once before the verdict is no-go.")
+	output := fs.String("output", "text", "Output format: text or json.")
+	// The operator flags the checks depend on, with the operator's defaults.
+	namespace := fs.String("namespace", "", "The operator's --namespace.")
+	excludeNamespaces := fs.String("exclude-namespaces", controllers.DefaultExcludedNamespaces, "The operator's --exclude-namespaces.")
+	labelSelector := fs.String("label-selector", "app.kubernetes.io/name=synapse", "The operator's --label-selector.")
+	sourceLabelSelector := fs.String("source-label-selector", "", "The operator's --source-label-selector.")
+	workloadLabelSelector := fs.String("workload-label-selector", "", "The operator's --workload-label-selector.")
+	detectByImage := fs.String("detect-by-image", "", "The operator's --detect-by-image.")
+	groupByOwner := fs.Bool("group-by-owner", false, "The operator's --group-by-owner.")
+	groupByComponent := fs.Bool("group-by-component", false, "The operator's --group-by-component.")
+	configHashAnnotation := fs.String("config-hash-annotation", "synapse.gen0sec.com/config-hash", "The operator's --config-hash-annotation.")
+	acceptLegacyHashes := fs.Bool("accept-legacy-hashes", false, "The operator's --accept-legacy-hashes.")
+	restartStrategy := fs.String("restart-strategy", controllers.StrategyAnnotation, "The operator's --restart-strategy.")
+	immutableConfigSources := fs.Bool("immutable-config-sources", false, "The operator's --immutable-config-sources.")
+	pruneSupersededConfigSources := fs.Bool("prune-superseded-config-sources", false, "The operator's --prune-superseded-config-sources.")
+	ignoredConfigMapKeys := fs.String("ignore-configmap-keys", "upstreams.yaml", "The operator's --ignore-configmap-keys.")
+	ignoredSecretKeys := fs.String("ignore-secret-keys", "", "The operator's --ignore-secret-keys.")
+	includedConfigMapKeys := fs.String("include-configmap-keys", "", "The operator's --include-configmap-keys.")
+	includedSecretKeys := fs.String("include-secret-keys", "", "The operator's --include-secret-keys.")
+	watchSecrets := fs.Bool("watch-secrets", true, "The operator's --watch-secrets.")
+	sourceClassPolicies := fs.String("source-class-policies", "", "The operator's --source-class-policies.")
+	manageCronJobs := fs.Bool("manage-cronjobs", false, "The operator's --manage-cronjobs.")
+	syncRoutes := fs.Bool("sync-routes", false, "The operator's --sync-routes.")
+	manageAppservices := fs.Bool("manage-appservices", false, "The operator's --manage-appservices.")
+	manageWorkerTopology := fs.Bool("manage-worker-topology", false, "The operator's --manage-worker-topology.")
+	renderConfigTemplates := fs.Bool("render-config-templates", false, "The operator's --render-config-templates.")
+	validationJobImage := fs.String("validation-job-image", "", "The operator's --validation-job-image.")
+	zoneTopologyKey := fs.String("zone-topology-key", "", "The operator's --zone-topology-key.")
+	stateBackend := fs.String("state-store", state.BackendMemory, "The operator's --state-store.")
+	stateNamespace := fs.String("state-namespace", defaultStateNamespace(), "The operator's --state-namespace.")
+	rolloutHistoryRetention := fs.Int("rollout-history-retention", 0, "The operator's --rollout-history-retention.")
+	leaderElect := fs.Bool("leader-elect", false, "The operator's --leader-elect.")
+	leaderElectionNamespace := fs.String("leader-election-namespace", "", "The operator's --leader-election-namespace.")
+	injectConfigHash := fs.Bool("inject-config-hash", false, "The operator's --inject-config-hash.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *fromDeployment != "" {
+		key, err := parseNamespacedName(*fromDeployment)
+		if err != nil {
+			fmt.Fprintf(stderr, "preflight: --from-deployment: %v\n", err)
+			return 2
+		}
+		deploy, err := getOperatorDeployment(context.Background(), key)
+		if err != nil {
+			fmt.Fprintf(stderr, "preflight: %v\n", err)
+			return 1
+		}
+		// Flags given on the command line take precedence over the Deployment's.
+		if err := fs.Parse(operatorFlagArgs(fs, deploy, preflightOperatorFlags)); err != nil {
+			return 2
+		}
+		sa := deploy.Spec.Template.Spec.ServiceAccountName
+		if sa == "" {
+			sa = "default"
+		}
+		*serviceAccount = deploy.Namespace + "/" + sa
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+	}
+
+	sa, err := parseNamespacedName(*serviceAccount)
+	if err != nil {
+		fmt.Fprintf(stderr, "preflight: --service-account: %v\n", err)
+		return 2
+	}
+	selector, err := parseLabelSelector(*labelSelector)
+	if err != nil {
+		fmt.Fprintf(stderr, "preflight: --label-selector: %v\n", err)
+		return 2
+	}
+	sourceSelector, err := parseOptionalLabelSelector(*sourceLabelSelector)
+	if err != nil {
+		fmt.Fprintf(stderr, "preflight: --source-label-selector: %v\n", err)
+		return 2
+	}
+	workloadSelector, err := parseOptionalLabelSelector(*workloadLabelSelector)
+	if err != nil {
+		fmt.Fprintf(stderr, "preflight: --workload-label-selector: %v\n", err)
+		return 2
+	}
+	sourceRules, err := controllers.OverrideSourceClassPolicies(controllers.DefaultSourceRules(), *sourceClassPolicies)
+	if err != nil {
+		fmt.Fprintf(stderr, "preflight: --source-class-policies: %v\n", err)
+		return 2
+	}
+	if !controllers.ValidRestartStrategy(*restartStrategy) {
+		fmt.Fprintf(stderr, "preflight: unknown restart strategy %q\n", *restartStrategy)
+		return 2
+	}
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "preflight: %v\n", err)
+		return 1
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintf(stderr, "preflight: %v\n", err)
+		return 1
+	}
+	// A reconciler configured like the upgraded operator computes the hashes it would apply, without a
+	// manager: nothing is started and nothing is written.
+	reconciler := &controllers.ConfigMapReconciler{
+		Client:                 c,
+		Scheme:                 scheme,
+		APIReader:              c,
+		LabelSelector:          selector,
+		SourceLabelSelector:    sourceSelector,
+		WorkloadLabelSelector:  workloadSelector,
+		DetectByImage:          *detectByImage,
+		GroupByOwner:           *groupByOwner,
+		GroupByComponent:       *groupByComponent,
+		ManageCronJobs:         *manageCronJobs,
+		ConfigHashAnnotation:   *configHashAnnotation,
+		AcceptLegacyHashes:     *acceptLegacyHashes,
+		RestartStrategy:        *restartStrategy,
+		ImmutableConfigSources: *immutableConfigSources,
+		IgnoredConfigMapKeys:   parseKeySet(*ignoredConfigMapKeys),
+		IgnoredSecretKeys:      parseKeySet(*ignoredSecretKeys),
+		IncludedConfigMapKeys:  parseKeySet(*includedConfigMapKeys),
+		IncludedSecretKeys:     parseKeySet(*includedSecretKeys),
+		IgnoreSecrets:          !*watchSecrets,
+		SourceRules:            sourceRules,
+		ExcludedNamespaces:     parseKeySet(*excludeNamespaces),
+	}
+	spec := preflight.Spec{
+		Features: preflight.Features{
+			StateStore:              *stateBackend,
+			StateNamespace:          *stateNamespace,
+			RolloutHistory:          *rolloutHistoryRetention > 0,
+			ManageCronJobs:          *manageCronJobs,
+			SyncRoutes:              *syncRoutes,
+			Appservices:             *manageAppservices,
+			WorkerTopology:          *manageWorkerTopology,
+			ConfigTemplates:         *renderConfigTemplates,
+			ValidationJobs:          *validationJobImage != "",
+			VersionedCopies:         *restartStrategy == controllers.StrategyVersioned,
+			PruneConfigRevisions:    *immutableConfigSources && *pruneSupersededConfigSources,
+			ZoneAware:               *zoneTopologyKey != "",
+			LeaderElection:          *leaderElect,
+			LeaderElectionNamespace: *leaderElectionNamespace,
+		},
+		ServiceAccount:  sa,
+		Namespace:       *namespace,
+		PendingRestarts: reconciler.PendingRestarts,
+		MaxRestarts:     *maxRestarts,
+		Now:             time.Now(),
+	}
+	if *injectConfigHash {
+		spec.Webhook = *webhook
+		if *webhookCertSecret != "" {
+			if spec.WebhookCertSecret, err = parseNamespacedName(*webhookCertSecret); err != nil {
+				fmt.Fprintf(stderr, "preflight: --webhook-cert-secret: %v\n", err)
+				return 2
+			}
+		}
+	}
+
+	report := preflight.Run(context.Background(), c, spec)
+	if err := report.Write(stdout, *output); err != nil {
+		fmt.Fprintf(stderr, "preflight: %v\n", err)
+		return 2
+	}
+	if !report.Go() {
+		return 1
+	}
+	return 0
+}
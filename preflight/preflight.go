@@ -0,0 +1,513 @@
+// Package preflight checks a cluster before an operator upgrade: the CRDs, RBAC and webhook certificate the
+// new operator needs, and how many workloads its first reconcile would restart. It ends in a go/no-go verdict.
+package preflight
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"synapse-operator/controllers"
+	"synapse-operator/state"
+)
+
+// Status is the outcome of one check.
+type Status string
+
+const (
+	// StatusOK passed.
+	StatusOK Status = "ok"
+	// StatusWarn passed but needs a look before upgrading.
+	StatusWarn Status = "warn"
+	// StatusFail makes the verdict no-go.
+	StatusFail Status = "fail"
+)
+
+// certExpiryWarning is how close to expiry a webhook certificate is reported.
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// Result is the outcome of one check.
+type Result struct {
+	Check  string `json:"check"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// Report holds the results of every check.
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Go reports whether no check failed.
+func (r *Report) Go() bool {
+	for _, result := range r.Results {
+		if result.Status == StatusFail {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Report) add(check string, status Status, format string, args ...interface{}) {
+	r.Results = append(r.Results, Result{Check: check, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Write renders the report to w as text or json.
+func (r *Report) Write(w io.Writer, output string) error {
+	switch output {
+	case "text":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHECK\tSTATUS\tDETAIL")
+		for _, result := range r.Results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Check, result.Status, result.Detail)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		verdict := "GO: no check failed"
+		if !r.Go() {
+			verdict = "NO-GO: fix the failed checks before upgrading"
+		}
+		_, err := fmt.Fprintln(w, "\n"+verdict)
+		return err
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Go bool `json:"go"`
+			*Report
+		}{r.Go(), r})
+	default:
+		return fmt.Errorf("unknown output format %q", output)
+	}
+}
+
+// Features are the operator settings that decide what the upgraded operator needs from the cluster.
+type Features struct {
+	// StateStore is the --state-store backend.
+	StateStore      string
+	StateNamespace  string
+	RolloutHistory  bool
+	ManageCronJobs  bool
+	SyncRoutes      bool
+	Appservices     bool
+	WorkerTopology  bool
+	ConfigTemplates bool
+	// ValidationJobs is set by --validation-job-image.
+	ValidationJobs bool
+	// VersionedCopies is set by --restart-strategy=versioned.
+	VersionedCopies bool
+	// PruneConfigRevisions is set by --immutable-config-sources with --prune-superseded-config-sources.
+	PruneConfigRevisions bool
+	// ZoneAware is set by --zone-topology-key.
+	ZoneAware      bool
+	LeaderElection bool
+	// LeaderElectionNamespace holds the Lease; empty means the namespace of the service account.
+	LeaderElectionNamespace string
+}
+
+// Spec describes the upgraded operator and what to check.
+type Spec struct {
+	Features
+	// ServiceAccount is the operator's service account, whose permissions are checked.
+	ServiceAccount types.NamespacedName
+	// Namespace is the namespace the operator watches; empty means every namespace.
+	Namespace string
+	// Webhook is the MutatingWebhookConfiguration of --inject-config-hash; empty skips the webhook checks.
+	Webhook string
+	// WebhookCertSecret holds the webhook's serving certificate, checked against the CA bundle when set.
+	WebhookCertSecret types.NamespacedName
+	// PendingRestarts lists the workloads of a namespace the upgraded operator would restart.
+	PendingRestarts func(ctx context.Context, namespace string) ([]controllers.PendingRestart, error)
+	// MaxRestarts is the number of restarts caused by a hash format change above which the verdict is no-go.
+	MaxRestarts int
+	Now         time.Time
+}
+
+// CRD is a CustomResourceDefinition the operator needs, and the setting that needs it.
+type CRD struct {
+	Name    string
+	Version string
+	Reason  string
+}
+
+// Permission is an API access the operator needs. An empty Namespace means the watched namespaces.
+type Permission struct {
+	Namespace   string
+	Group       string
+	Resource    string
+	Subresource string
+	Verbs       []string
+}
+
+// CRDs returns the CustomResourceDefinitions features need.
+func CRDs(features Features) []CRD {
+	var crds []CRD
+	if features.StateStore == state.BackendCRD {
+		crds = append(crds, crdFor(state.StateGVK, "synapseoperatorstates", "--state-store=crd"))
+	}
+	if features.RolloutHistory {
+		crds = append(crds, crdFor(controllers.RolloutHistoryGVK, "synapserollouthistories", "--rollout-history-retention"))
+	}
+	return crds
+}
+
+func crdFor(gvk schema.GroupVersionKind, plural, reason string) CRD {
+	return CRD{Name: plural + "." + gvk.Group, Version: gvk.Version, Reason: reason}
+}
+
+// Permissions returns the API access the operator needs with features, mirroring config/rbac.yaml.
+func Permissions(features Features, serviceAccount types.NamespacedName) []Permission {
+	read := []string{"get", "list", "watch"}
+	workload := []string{"get", "list", "watch", "patch"}
+	permissions := []Permission{
+		{Resource: "configmaps", Verbs: read},
+		{Resource: "secrets", Verbs: read},
+		{Resource: "namespaces", Verbs: read},
+		{Resource: "pods", Verbs: []string{"get", "list"}},
+		{Resource: "pods", Subresource: "eviction", Verbs: []string{"create"}},
+		{Resource: "events", Verbs: []string{"create", "patch"}},
+		{Group: "apps", Resource: "deployments", Verbs: workload},
+		{Group: "apps", Resource: "daemonsets", Verbs: workload},
+		{Group: "apps", Resource: "statefulsets", Verbs: workload},
+		{Group: "policy", Resource: "poddisruptionbudgets", Verbs: []string{"get", "list"}},
+	}
+	if features.ManageCronJobs {
+		permissions = append(permissions,
+			Permission{Group: "batch", Resource: "cronjobs", Verbs: workload},
+			Permission{Group: "batch", Resource: "jobs", Verbs: []string{"get", "list", "patch"}})
+	}
+	if features.SyncRoutes {
+		permissions = append(permissions,
+			Permission{Resource: "services", Verbs: []string{"list"}},
+			Permission{Group: "networking.k8s.io", Resource: "ingresses", Verbs: []string{"get", "patch"}},
+			Permission{Group: "gateway.networking.k8s.io", Resource: "httproutes", Verbs: []string{"get", "patch"}})
+	}
+	if features.Appservices {
+		permissions = append(permissions, Permission{Resource: "secrets", Verbs: []string{"create", "update"}})
+	}
+	if features.ConfigTemplates {
+		permissions = append(permissions,
+			Permission{Resource: "configmaps", Verbs: []string{"create", "update"}},
+			Permission{Resource: "secrets", Verbs: []string{"create", "update"}})
+	}
+	if features.WorkerTopology {
+		permissions = append(permissions, Permission{Group: "apps", Resource: "deployments", Verbs: []string{"create", "delete"}})
+	}
+	if features.ValidationJobs {
+		permissions = append(permissions, Permission{Group: "batch", Resource: "jobs", Verbs: []string{"get", "list", "create"}})
+	}
+	if features.VersionedCopies {
+		permissions = append(permissions,
+			Permission{Resource: "configmaps", Verbs: []string{"create", "delete"}},
+			Permission{Resource: "secrets", Verbs: []string{"create", "delete"}},
+			Permission{Group: "apps", Resource: "replicasets", Verbs: []string{"list"}})
+	}
+	if features.PruneConfigRevisions {
+		permissions = append(permissions,
+			Permission{Resource: "configmaps", Verbs: []string{"delete"}},
+			Permission{Group: "apps", Resource: "replicasets", Verbs: []string{"list"}})
+	}
+	if features.ZoneAware {
+		permissions = append(permissions, Permission{Resource: "nodes", Verbs: []string{"get"}})
+	}
+	switch features.StateStore {
+	case state.BackendConfigMap:
+		permissions = append(permissions, Permission{Namespace: features.StateNamespace, Resource: "configmaps", Verbs: []string{"create", "update"}})
+	case state.BackendCRD:
+		permissions = append(permissions, Permission{Namespace: features.StateNamespace, Group: state.StateGVK.Group, Resource: "synapseoperatorstates", Verbs: []string{"get", "create", "update"}})
+	}
+	if features.RolloutHistory {
+		permissions = append(permissions, Permission{Group: controllers.RolloutHistoryGVK.Group, Resource: "synapserollouthistories", Verbs: []string{"get", "list", "watch", "create", "update"}})
+	}
+	if features.LeaderElection {
+		namespace := features.LeaderElectionNamespace
+		if namespace == "" {
+			namespace = serviceAccount.Namespace
+		}
+		permissions = append(permissions, Permission{Namespace: namespace, Group: "coordination.k8s.io", Resource: "leases", Verbs: []string{"get", "create", "update"}})
+	}
+	return permissions
+}
+
+// Run checks the cluster c talks to against spec. The checks only read, apart from the SubjectAccessReviews
+// asking the API server about the service account's permissions.
+func Run(ctx context.Context, c client.Client, spec Spec) *Report {
+	report := &Report{}
+	checkCRDs(ctx, c, report, CRDs(spec.Features))
+	checkRBAC(ctx, c, report, spec)
+	if spec.Webhook != "" {
+		checkWebhook(ctx, c, report, spec)
+	}
+	if spec.PendingRestarts != nil {
+		checkRestarts(ctx, c, report, spec)
+	}
+	return report
+}
+
+// checkCRDs verifies each CRD is installed and serves the version the operator uses.
+func checkCRDs(ctx context.Context, c client.Client, report *Report, crds []CRD) {
+	for _, crd := range crds {
+		check := "crd/" + crd.Name
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"})
+		if err := c.Get(ctx, client.ObjectKey{Name: crd.Name}, obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				report.add(check, StatusFail, "not installed; %s needs it", crd.Reason)
+			} else {
+				report.add(check, StatusFail, "cannot read: %v", err)
+			}
+			continue
+		}
+		versions, _, _ := unstructured.NestedSlice(obj.Object, "spec", "versions")
+		var served []string
+		found := false
+		for _, v := range versions {
+			version, _ := v.(map[string]interface{})
+			name, _, _ := unstructured.NestedString(version, "name")
+			if ok, _, _ := unstructured.NestedBool(version, "served"); !ok {
+				continue
+			}
+			served = append(served, name)
+			found = found || name == crd.Version
+		}
+		if !found {
+			report.add(check, StatusFail, "serves %s, the operator needs %s; apply the CRD from config/crd first", strings.Join(served, ", "), crd.Version)
+			continue
+		}
+		report.add(check, StatusOK, "serves %s", crd.Version)
+	}
+}
+
+// checkRBAC asks the API server whether the service account may do everything the operator needs.
+func checkRBAC(ctx context.Context, c client.Client, report *Report, spec Spec) {
+	user := "system:serviceaccount:" + spec.ServiceAccount.Namespace + ":" + spec.ServiceAccount.Name
+	groups := []string{"system:serviceaccounts", "system:serviceaccounts:" + spec.ServiceAccount.Namespace, "system:authenticated"}
+	var denied []string
+	for _, permission := range Permissions(spec.Features, spec.ServiceAccount) {
+		namespace := permission.Namespace
+		if namespace == "" {
+			namespace = spec.Namespace
+		}
+		for _, verb := range permission.Verbs {
+			review := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:   user,
+				Groups: groups,
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        verb,
+					Group:       permission.Group,
+					Resource:    permission.Resource,
+					Subresource: permission.Subresource,
+				},
+			}}
+			if err := c.Create(ctx, review); err != nil {
+				report.add("rbac", StatusFail, "cannot create SubjectAccessReviews: %v", err)
+				return
+			}
+			if !review.Status.Allowed {
+				denied = append(denied, describePermission(review.Spec.ResourceAttributes))
+			}
+		}
+	}
+	if len(denied) > 0 {
+		report.add("rbac", StatusFail, "%s may not %s; apply config/rbac.yaml", user, strings.Join(denied, ", "))
+		return
+	}
+	report.add("rbac", StatusOK, "%s has every permission the operator needs", user)
+}
+
+func describePermission(attributes *authorizationv1.ResourceAttributes) string {
+	resource := attributes.Resource
+	if attributes.Subresource != "" {
+		resource += "/" + attributes.Subresource
+	}
+	if attributes.Group != "" {
+		resource += "." + attributes.Group
+	}
+	if attributes.Namespace != "" {
+		resource += " in " + attributes.Namespace
+	}
+	return attributes.Verb + " " + resource
+}
+
+// checkWebhook verifies the CA bundle of each webhook of spec.Webhook holds a valid certificate and, with
+// spec.WebhookCertSecret, that it signs the serving certificate.
+func checkWebhook(ctx context.Context, c client.Client, report *Report, spec Spec) {
+	check := "webhook/" + spec.Webhook
+	config := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	if err := c.Get(ctx, client.ObjectKey{Name: spec.Webhook}, config); err != nil {
+		report.add(check, StatusFail, "cannot read the MutatingWebhookConfiguration: %v", err)
+		return
+	}
+	if len(config.Webhooks) == 0 {
+		report.add(check, StatusFail, "has no webhooks")
+		return
+	}
+	roots := x509.NewCertPool()
+	for _, webhook := range config.Webhooks {
+		certs, err := parseCertificates(webhook.ClientConfig.CABundle)
+		if err != nil {
+			report.add(check, StatusFail, "%s: caBundle: %v; is the CA injected?", webhook.Name, err)
+			return
+		}
+		for _, cert := range certs {
+			roots.AddCert(cert)
+		}
+		if !checkExpiry(report, check, webhook.Name+" caBundle", certs[0], spec.Now) {
+			return
+		}
+	}
+	if spec.WebhookCertSecret.Name == "" {
+		return
+	}
+	secretCheck := "webhook-cert/" + spec.WebhookCertSecret.String()
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, spec.WebhookCertSecret, secret); err != nil {
+		report.add(secretCheck, StatusFail, "cannot read the serving certificate: %v", err)
+		return
+	}
+	certs, err := parseCertificates(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		report.add(secretCheck, StatusFail, "%s: %v", corev1.TLSCertKey, err)
+		return
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, CurrentTime: spec.Now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		report.add(secretCheck, StatusFail, "not signed by the webhook caBundle: %v", err)
+		return
+	}
+	checkExpiry(report, secretCheck, "serving certificate", certs[0], spec.Now)
+}
+
+// checkExpiry reports the expiry of cert, returning false when it has expired.
+func checkExpiry(report *Report, check, what string, cert *x509.Certificate, now time.Time) bool {
+	switch left := cert.NotAfter.Sub(now); {
+	case left <= 0:
+		report.add(check, StatusFail, "%s expired at %s", what, cert.NotAfter.UTC().Format(time.RFC3339))
+		return false
+	case left < certExpiryWarning:
+		report.add(check, StatusWarn, "%s expires at %s; renew it first", what, cert.NotAfter.UTC().Format(time.RFC3339))
+	default:
+		report.add(check, StatusOK, "%s valid until %s", what, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return true
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate")
+	}
+	return certs, nil
+}
+
+// checkRestarts estimates the restarts of the upgraded operator's first reconcile. Workloads whose applied
+// hash has another encoding all restart at once, which is no-go above spec.MaxRestarts; workloads behind a
+// config change would restart without the upgrade too, so they are only reported.
+func checkRestarts(ctx context.Context, c client.Client, report *Report, spec Spec) {
+	namespaces := []string{spec.Namespace}
+	if spec.Namespace == "" {
+		list := &corev1.NamespaceList{}
+		if err := c.List(ctx, list); err != nil {
+			report.add("restarts", StatusFail, "cannot list namespaces: %v", err)
+			return
+		}
+		namespaces = namespaces[:0]
+		for _, ns := range list.Items {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+	var formatChanges, configChanges []string
+	encodings := map[string]int{}
+	for _, namespace := range namespaces {
+		pending, err := spec.PendingRestarts(ctx, namespace)
+		if err != nil {
+			report.add("restarts", StatusFail, "cannot compute the config hash of %s: %v", namespace, err)
+			return
+		}
+		for _, p := range pending {
+			name := p.Namespace + "/" + p.Name
+			if p.FormatChange() {
+				formatChanges = append(formatChanges, name)
+				encodings[hashEncoding(p.AppliedHash)]++
+			} else {
+				configChanges = append(configChanges, name)
+			}
+		}
+	}
+
+	if len(formatChanges) == 0 {
+		report.add("annotation-format", StatusOK, "every applied config hash has the %s encoding", controllers.ConfigHashPrefix)
+	} else {
+		var found []string
+		for encoding, count := range encodings {
+			found = append(found, fmt.Sprintf("%d %s", count, encoding))
+		}
+		sort.Strings(found)
+		report.add("annotation-format", StatusWarn, "applied config hashes of another encoding than %s: %s", controllers.ConfigHashPrefix, strings.Join(found, ", "))
+	}
+	switch {
+	case len(formatChanges) > spec.MaxRestarts:
+		report.add("restarts", StatusFail, "the hash format change would restart %d workload(s) at once, more than --max-restarts %d: %s",
+			len(formatChanges), spec.MaxRestarts, summarize(formatChanges))
+	case len(formatChanges) > 0:
+		report.add("restarts", StatusWarn, "the hash format change would restart %d workload(s): %s", len(formatChanges), summarize(formatChanges))
+	default:
+		report.add("restarts", StatusOK, "the upgrade itself restarts no workload")
+	}
+	if len(configChanges) > 0 {
+		report.add("pending-config", StatusWarn, "%d workload(s) are behind their config and restart on the next reconcile: %s", len(configChanges), summarize(configChanges))
+	}
+}
+
+// hashEncoding names the encoding of an applied hash: its prefix up to the digest, or "unprefixed".
+func hashEncoding(hash string) string {
+	if i := strings.LastIndex(hash, ":"); i >= 0 {
+		return hash[:i+1]
+	}
+	return "unprefixed"
+}
+
+// summarize lists up to five names.
+func summarize(names []string) string {
+	const limit = 5
+	if len(names) <= limit {
+		return strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(names[:limit], ", "), len(names)-limit)
+}
@@ -0,0 +1,234 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"synapse-operator/controllers"
+	"synapse-operator/state"
+)
+
+var (
+	testNow            = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	testServiceAccount = types.NamespacedName{Namespace: "synapse-system", Name: "synapse-operator"}
+)
+
+// newTestClient returns a fake client whose SubjectAccessReviews deny the resources in denied.
+func newTestClient(t *testing.T, denied map[string]bool, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if review, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+				review.Status.Allowed = !denied[review.Spec.ResourceAttributes.Resource]
+				return nil
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	}).Build()
+}
+
+func newTestCRD(name string, versions ...string) *unstructured.Unstructured {
+	crd := &unstructured.Unstructured{}
+	crd.SetAPIVersion("apiextensions.k8s.io/v1")
+	crd.SetKind("CustomResourceDefinition")
+	crd.SetName(name)
+	var served []interface{}
+	for _, version := range versions {
+		served = append(served, map[string]interface{}{"name": version, "served": true, "storage": true})
+	}
+	_ = unstructured.SetNestedSlice(crd.Object, served, "spec", "versions")
+	return crd
+}
+
+func findResult(t *testing.T, report *Report, check string) Result {
+	t.Helper()
+	for _, result := range report.Results {
+		if result.Check == check {
+			return result
+		}
+	}
+	t.Fatalf("no result for %s in %v", check, report.Results)
+	return Result{}
+}
+
+func TestRunChecksCRDVersions(t *testing.T) {
+	c := newTestClient(t, nil, newTestCRD("synapseoperatorstates.synapse.gen0sec.com", "v1alpha1"), newTestCRD("synapserollouthistories.synapse.gen0sec.com", "v0"))
+	report := Run(context.Background(), c, Spec{
+		Features:       Features{StateStore: state.BackendCRD, RolloutHistory: true},
+		ServiceAccount: testServiceAccount,
+	})
+
+	assert.Equal(t, StatusOK, findResult(t, report, "crd/synapseoperatorstates.synapse.gen0sec.com").Status)
+	history := findResult(t, report, "crd/synapserollouthistories.synapse.gen0sec.com")
+	assert.Equal(t, StatusFail, history.Status)
+	assert.Contains(t, history.Detail, "serves v0, the operator needs v1alpha1")
+	assert.False(t, report.Go())
+}
+
+func TestRunChecksMissingCRD(t *testing.T) {
+	report := Run(context.Background(), newTestClient(t, nil), Spec{Features: Features{StateStore: state.BackendCRD}, ServiceAccount: testServiceAccount})
+	result := findResult(t, report, "crd/synapseoperatorstates.synapse.gen0sec.com")
+	assert.Equal(t, StatusFail, result.Status)
+	assert.Contains(t, result.Detail, "--state-store=crd")
+}
+
+func TestRunChecksRBAC(t *testing.T) {
+	spec := Spec{Features: Features{ManageCronJobs: true}, ServiceAccount: testServiceAccount, Namespace: "matrix"}
+	report := Run(context.Background(), newTestClient(t, nil), spec)
+	assert.Equal(t, StatusOK, findResult(t, report, "rbac").Status)
+	assert.True(t, report.Go())
+
+	report = Run(context.Background(), newTestClient(t, map[string]bool{"cronjobs": true}), spec)
+	result := findResult(t, report, "rbac")
+	assert.Equal(t, StatusFail, result.Status)
+	assert.Contains(t, result.Detail, "system:serviceaccount:synapse-system:synapse-operator may not get cronjobs.batch in matrix")
+}
+
+func TestPermissionsFollowFeatures(t *testing.T) {
+	base := Permissions(Features{}, testServiceAccount)
+	withLeases := Permissions(Features{LeaderElection: true}, testServiceAccount)
+	require.Len(t, withLeases, len(base)+1)
+	lease := withLeases[len(withLeases)-1]
+	assert.Equal(t, "leases", lease.Resource)
+	assert.Equal(t, "synapse-system", lease.Namespace)
+}
+
+// newTestCertificate returns a PEM certificate valid until notAfter, signed by parent (self-signed when nil),
+// and its key.
+func newTestCertificate(t *testing.T, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, *x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "synapse-operator-webhook"},
+		NotBefore:             testNow.Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), cert, key
+}
+
+func newTestWebhook(caBundle []byte) *admissionregistrationv1.MutatingWebhookConfiguration {
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "synapse-operator-config-hash"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:         "config-hash.synapse.gen0sec.com",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: caBundle},
+		}},
+	}
+}
+
+func TestRunChecksWebhookCertificates(t *testing.T) {
+	caPEM, ca, caKey := newTestCertificate(t, testNow.Add(365*24*time.Hour), nil, nil)
+	servingPEM, _, _ := newTestCertificate(t, testNow.Add(7*24*time.Hour), ca, caKey)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "synapse-operator-webhook-cert", Namespace: "synapse-system"},
+		Data:       map[string][]byte{corev1.TLSCertKey: servingPEM},
+	}
+	spec := Spec{
+		ServiceAccount:    testServiceAccount,
+		Webhook:           "synapse-operator-config-hash",
+		WebhookCertSecret: types.NamespacedName{Namespace: "synapse-system", Name: "synapse-operator-webhook-cert"},
+		Now:               testNow,
+	}
+
+	report := Run(context.Background(), newTestClient(t, nil, newTestWebhook(caPEM), secret), spec)
+	assert.Equal(t, StatusOK, findResult(t, report, "webhook/synapse-operator-config-hash").Status)
+	serving := findResult(t, report, "webhook-cert/synapse-system/synapse-operator-webhook-cert")
+	assert.Equal(t, StatusWarn, serving.Status)
+	assert.Contains(t, serving.Detail, "expires at")
+	assert.True(t, report.Go())
+
+	otherCA, _, _ := newTestCertificate(t, testNow.Add(365*24*time.Hour), nil, nil)
+	report = Run(context.Background(), newTestClient(t, nil, newTestWebhook(otherCA), secret), spec)
+	assert.Equal(t, StatusFail, findResult(t, report, "webhook-cert/synapse-system/synapse-operator-webhook-cert").Status)
+
+	report = Run(context.Background(), newTestClient(t, nil, newTestWebhook(nil)), spec)
+	assert.Equal(t, StatusFail, findResult(t, report, "webhook/synapse-operator-config-hash").Status)
+	assert.False(t, report.Go())
+}
+
+func TestRunEstimatesRestarts(t *testing.T) {
+	pending := map[string][]controllers.PendingRestart{
+		"matrix": {
+			{Kind: "Deployment", Name: "synapse", Namespace: "matrix", AppliedHash: "0123abcd", Hash: controllers.ConfigHashPrefix + "ff"},
+			{Kind: "StatefulSet", Name: "media", Namespace: "matrix", AppliedHash: controllers.ConfigHashPrefix + "ee", Hash: controllers.ConfigHashPrefix + "ff"},
+		},
+		"chat": {{Kind: "Deployment", Name: "synapse", Namespace: "chat", AppliedHash: "4567cdef", Hash: controllers.ConfigHashPrefix + "aa"}},
+	}
+	c := newTestClient(t, nil,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "matrix"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "chat"}})
+	spec := Spec{
+		ServiceAccount: testServiceAccount,
+		PendingRestarts: func(_ context.Context, namespace string) ([]controllers.PendingRestart, error) {
+			return pending[namespace], nil
+		},
+		MaxRestarts: 1,
+	}
+
+	report := Run(context.Background(), c, spec)
+	format := findResult(t, report, "annotation-format")
+	assert.Equal(t, StatusWarn, format.Status)
+	assert.Contains(t, format.Detail, "2 unprefixed")
+	restarts := findResult(t, report, "restarts")
+	assert.Equal(t, StatusFail, restarts.Status)
+	assert.Contains(t, restarts.Detail, "would restart 2 workload(s) at once")
+	assert.Equal(t, StatusWarn, findResult(t, report, "pending-config").Status)
+	assert.False(t, report.Go())
+
+	spec.MaxRestarts = 2
+	report = Run(context.Background(), c, spec)
+	assert.Equal(t, StatusWarn, findResult(t, report, "restarts").Status)
+	assert.True(t, report.Go())
+}
+
+func TestReportWrite(t *testing.T) {
+	report := &Report{}
+	report.add("rbac", StatusOK, "fine")
+	var out bytes.Buffer
+	require.NoError(t, report.Write(&out, "text"))
+	assert.Contains(t, out.String(), "rbac")
+	assert.Contains(t, out.String(), "GO: no check failed")
+
+	report.add("restarts", StatusFail, "too many")
+	out.Reset()
+	require.NoError(t, report.Write(&out, "json"))
+	assert.Contains(t, out.String(), `"go": false`)
+	assert.Error(t, report.Write(&out, "yaml"))
+}
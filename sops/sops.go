@@ -0,0 +1,163 @@
+// Package sops gives SOPS-encrypted YAML and JSON documents a canonical form that changes with their
+// plaintext but not with re-encryption. Every encryption draws new IVs, and rotating the data key or the
+// recipients rewrites the document, so hashing the ciphertext would restart workloads for nothing.
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"sigs.k8s.io/yaml"
+)
+
+// encryptedValue matches a value encrypted by SOPS.
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// Canonicalizer rewrites SOPS documents into their canonical form. The zero value is usable: without
+// identities the MAC stays encrypted.
+type Canonicalizer struct {
+	// Identities decrypt the data key of documents encrypted to an age recipient.
+	Identities []age.Identity
+}
+
+// LoadAgeIdentities reads the age identities in path, in the format of SOPS_AGE_KEY_FILE.
+func LoadAgeIdentities(path string) ([]age.Identity, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parsing age identities in %s: %w", path, err)
+	}
+	return identities, nil
+}
+
+// Canonical returns the canonical form of value when it is a SOPS-encrypted YAML or JSON document, and false
+// for any other value. The canonical form is the document's key structure, with every encrypted value
+// replaced by its type, followed by the MAC SOPS computes over the plaintext values: decrypted when an
+// identity opens the data key, so re-encrypting or rotating the data key keeps it, and as stored otherwise,
+// where re-encryption changes it but recipient updates do not.
+func (c *Canonicalizer) Canonical(value []byte) ([]byte, bool) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(value, &doc); err != nil {
+		return nil, false
+	}
+	metadata, ok := doc["sops"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	mac, ok := metadata["mac"].(string)
+	if !ok || !encryptedValue.MatchString(mac) {
+		return nil, false
+	}
+	delete(doc, "sops")
+	structure, err := json.Marshal(redact(doc))
+	if err != nil {
+		return nil, false
+	}
+	if plaintext, err := c.decryptMAC(metadata, mac); err == nil {
+		mac = "plaintext:" + plaintext
+	}
+	return append(append(structure, 0), mac...), true
+}
+
+// redact replaces every encrypted value in node by its type.
+func redact(node interface{}) interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = redact(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redact(child)
+		}
+	case string:
+		if match := encryptedValue.FindStringSubmatch(v); match != nil {
+			return "ENC[" + match[4] + "]"
+		}
+	}
+	return node
+}
+
+// decryptMAC opens the data key with the identities and decrypts the MAC with it.
+func (c *Canonicalizer) decryptMAC(metadata map[string]interface{}, mac string) (string, error) {
+	if len(c.Identities) == 0 {
+		return "", errors.New("no age identities")
+	}
+	key, err := c.dataKey(metadata)
+	if err != nil {
+		return "", err
+	}
+	lastModified, _ := metadata["lastmodified"].(string)
+	modified, err := time.Parse(time.RFC3339, lastModified)
+	if err != nil {
+		return "", fmt.Errorf("invalid lastmodified %q", lastModified)
+	}
+	// SOPS authenticates the MAC with the modification time, formatted as it wrote it.
+	return decryptValue(mac, key, modified.Format(time.RFC3339))
+}
+
+// dataKey returns the data key of the first age stanza an identity opens.
+func (c *Canonicalizer) dataKey(metadata map[string]interface{}) ([]byte, error) {
+	stanzas, _ := metadata["age"].([]interface{})
+	for _, item := range stanzas {
+		stanza, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		enc, _ := stanza["enc"].(string)
+		reader, err := age.Decrypt(armor.NewReader(strings.NewReader(enc)), c.Identities...)
+		if err != nil {
+			continue
+		}
+		var key bytes.Buffer
+		if _, err := key.ReadFrom(reader); err != nil {
+			continue
+		}
+		return key.Bytes(), nil
+	}
+	return nil, errors.New("no age identity opens the data key")
+}
+
+// decryptValue decrypts a value encrypted by SOPS with key and additionalData.
+func decryptValue(value string, key []byte, additionalData string) (string, error) {
+	match := encryptedValue.FindStringSubmatch(value)
+	if match == nil {
+		return "", errors.New("not an encrypted value")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		decoded, err := base64.StdEncoding.DecodeString(match[i+1])
+		if err != nil {
+			return "", err
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
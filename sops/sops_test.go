@@ -0,0 +1,138 @@
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encrypt encrypts value the way SOPS does, with a fresh IV.
+func encrypt(t *testing.T, value string, key []byte, additionalData string) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	iv := make([]byte, 32)
+	_, err = rand.Read(iv)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	require.NoError(t, err)
+	sealed := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]",
+		base64.StdEncoding.EncodeToString(data), base64.StdEncoding.EncodeToString(iv), base64.StdEncoding.EncodeToString(tag))
+}
+
+// document encrypts a SOPS YAML document holding password under a new data key for recipient.
+func document(t *testing.T, recipient *age.X25519Recipient, password, lastModified string) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	var enc bytes.Buffer
+	armored := armor.NewWriter(&enc)
+	w, err := age.Encrypt(armored, recipient)
+	require.NoError(t, err)
+	_, err = w.Write(key)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, armored.Close())
+
+	// SOPS computes the MAC over every plaintext value; here there is one.
+	sum := sha512.Sum512([]byte(password))
+	mac := encrypt(t, strings.ToUpper(hex.EncodeToString(sum[:])), key, lastModified)
+	return []byte(fmt.Sprintf(`database:
+  password: %s
+  port: 5432
+sops:
+  age:
+  - recipient: %s
+    enc: |
+%s
+  lastmodified: "%s"
+  mac: %s
+  version: 3.9.0
+`, encrypt(t, password, key, "database:password:"), recipient, indent(enc.String()), lastModified, mac))
+}
+
+func indent(s string) string {
+	var out bytes.Buffer
+	for _, line := range bytes.Split(bytes.TrimRight([]byte(s), "\n"), []byte("\n")) {
+		out.WriteString("      ")
+		out.Write(line)
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+func canonical(t *testing.T, c *Canonicalizer, value []byte) string {
+	t.Helper()
+	out, ok := c.Canonical(value)
+	require.True(t, ok)
+	return string(out)
+}
+
+func TestCanonicalIgnoresOtherValues(t *testing.T) {
+	c := &Canonicalizer{}
+	for _, value := range []string{"server_name: example.com\n", "not: [yaml", "sops: {mac: plain}\n", ""} {
+		_, ok := c.Canonical([]byte(value))
+		assert.False(t, ok, value)
+	}
+}
+
+func TestCanonicalWithIdentityIgnoresReencryption(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	c := &Canonicalizer{Identities: []age.Identity{identity}}
+
+	first := canonical(t, c, document(t, identity.Recipient(), "hunter2", "2026-10-01T12:00:00Z"))
+	reencrypted := canonical(t, c, document(t, identity.Recipient(), "hunter2", "2026-10-02T08:30:00Z"))
+	changed := canonical(t, c, document(t, identity.Recipient(), "hunter3", "2026-10-02T08:30:00Z"))
+
+	assert.Equal(t, first, reencrypted, "a new data key and new IVs keep the canonical form")
+	assert.NotEqual(t, first, changed, "a new value changes the canonical form")
+	assert.NotContains(t, first, "hunter2")
+}
+
+func TestCanonicalWithoutIdentityIgnoresRecipients(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	other, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	c := &Canonicalizer{Identities: []age.Identity{other}}
+	doc := document(t, identity.Recipient(), "hunter2", "2026-10-01T12:00:00Z")
+
+	// Replacing the recipient stanza, as sops updatekeys does, leaves the ciphertext MAC alone.
+	updated := bytes.Replace(doc, []byte(identity.Recipient().String()), []byte(other.Recipient().String()), 1)
+	assert.Equal(t, canonical(t, c, doc), canonical(t, c, updated))
+	assert.NotEqual(t, canonical(t, c, doc), canonical(t, c, document(t, identity.Recipient(), "hunter2", "2026-10-01T12:00:00Z")),
+		"without the data key re-encryption changes the canonical form")
+}
+
+func TestLoadAgeIdentities(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# created: 2026-10-01\n"+identity.String()+"\n"), 0o600))
+
+	identities, err := LoadAgeIdentities(path)
+	require.NoError(t, err)
+	assert.Len(t, identities, 1)
+
+	_, err = LoadAgeIdentities(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,222 @@
+package state
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// configMapStateKey is the ConfigMap data key holding the JSON encoded entries.
+const configMapStateKey = "state.json"
+
+// StateGVK identifies the SynapseOperatorState custom resource backing the CRD store.
+var StateGVK = schema.GroupVersionKind{
+	Group:   "synapse.gen0sec.com",
+	Version: "v1alpha1",
+	Kind:    "SynapseOperatorState",
+}
+
+// objectBackend loads and saves the full entry set from a single Kubernetes object.
+type objectBackend interface {
+	// load returns the stored entries and the object they came from; obj is nil when it does not exist yet.
+	load(ctx context.Context) (entries map[string][]byte, obj client.Object, err error)
+	// save writes entries into obj, creating the object when obj is nil.
+	save(ctx context.Context, obj client.Object, entries map[string][]byte) error
+}
+
+// objectStore implements Store on top of one object with optimistic concurrency. Every write is a
+// read-modify-write of the whole document, so it suits the small amount of state the operator keeps.
+type objectStore struct {
+	backend objectBackend
+}
+
+func (s *objectStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	entries, _, err := s.backend.load(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := entries[key]
+	return value, ok, nil
+}
+
+func (s *objectStore) Put(ctx context.Context, key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("state key cannot be empty")
+	}
+	return s.mutate(ctx, func(entries map[string][]byte) bool {
+		entries[key] = cloneBytes(value)
+		return true
+	})
+}
+
+func (s *objectStore) Delete(ctx context.Context, key string) error {
+	return s.mutate(ctx, func(entries map[string][]byte) bool {
+		if _, ok := entries[key]; !ok {
+			return false
+		}
+		delete(entries, key)
+		return true
+	})
+}
+
+func (s *objectStore) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	entries, _, err := s.backend.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterPrefix(entries, prefix), nil
+}
+
+func (s *objectStore) mutate(ctx context.Context, fn func(entries map[string][]byte) bool) error {
+	retriable := func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}
+	return retry.OnError(retry.DefaultRetry, retriable, func() error {
+		entries, obj, err := s.backend.load(ctx)
+		if err != nil {
+			return err
+		}
+		if !fn(entries) {
+			return nil
+		}
+		return s.backend.save(ctx, obj, entries)
+	})
+}
+
+// NewConfigMapStore returns a Store persisted in the ConfigMap identified by key. Reads go through
+// reader, which should bypass the informer cache so state is read back consistently after writes.
+func NewConfigMapStore(c client.Client, reader client.Reader, key types.NamespacedName) Store {
+	return &objectStore{backend: &configMapBackend{client: c, reader: reader, key: key}}
+}
+
+type configMapBackend struct {
+	client client.Client
+	reader client.Reader
+	key    types.NamespacedName
+}
+
+func (b *configMapBackend) load(ctx context.Context) (map[string][]byte, client.Object, error) {
+	var cm corev1.ConfigMap
+	if err := b.reader.Get(ctx, b.key, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return map[string][]byte{}, nil, nil
+		}
+		return nil, nil, err
+	}
+	entries := map[string][]byte{}
+	if raw := cm.Data[configMapStateKey]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+			return nil, nil, fmt.Errorf("decoding state ConfigMap %s: %w", b.key, err)
+		}
+	}
+	return entries, &cm, nil
+}
+
+func (b *configMapBackend) save(ctx context.Context, obj client.Object, entries map[string][]byte) error {
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      b.key.Name,
+				Namespace: b.key.Namespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "synapse-operator"},
+			},
+			Data: map[string]string{configMapStateKey: string(raw)},
+		}
+		return b.client.Create(ctx, cm)
+	}
+	cm := obj.(*corev1.ConfigMap)
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[configMapStateKey] = string(raw)
+	return b.client.Update(ctx, cm)
+}
+
+// NewCRDStore returns a Store persisted in the spec of a SynapseOperatorState custom resource. The
+// resource is accessed as unstructured so the operator does not need generated API types for it.
+func NewCRDStore(c client.Client, reader client.Reader, key types.NamespacedName) Store {
+	return &objectStore{backend: &crdBackend{client: c, reader: reader, key: key}}
+}
+
+type crdBackend struct {
+	client client.Client
+	reader client.Reader
+	key    types.NamespacedName
+}
+
+func (b *crdBackend) load(ctx context.Context) (map[string][]byte, client.Object, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(StateGVK)
+	if err := b.reader.Get(ctx, b.key, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return map[string][]byte{}, nil, nil
+		}
+		return nil, nil, err
+	}
+	raw, _, err := unstructured.NestedStringMap(obj.Object, "spec", "entries")
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding %s %s: %w", StateGVK.Kind, b.key, err)
+	}
+	entries := make(map[string][]byte, len(raw))
+	for k, v := range raw {
+		value, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding %s %s entry %q: %w", StateGVK.Kind, b.key, k, err)
+		}
+		entries[k] = value
+	}
+	return entries, obj, nil
+}
+
+func (b *crdBackend) save(ctx context.Context, obj client.Object, entries map[string][]byte) error {
+	encoded := make(map[string]interface{}, len(entries))
+	for k, v := range entries {
+		encoded[k] = base64.StdEncoding.EncodeToString(v)
+	}
+	if obj == nil {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(StateGVK)
+		u.SetName(b.key.Name)
+		u.SetNamespace(b.key.Namespace)
+		u.SetLabels(map[string]string{"app.kubernetes.io/managed-by": "synapse-operator"})
+		if err := unstructured.SetNestedMap(u.Object, encoded, "spec", "entries"); err != nil {
+			return err
+		}
+		return b.client.Create(ctx, u)
+	}
+	u := obj.(*unstructured.Unstructured)
+	if err := unstructured.SetNestedMap(u.Object, encoded, "spec", "entries"); err != nil {
+		return err
+	}
+	return b.client.Update(ctx, u)
+}
+
+// Artifacts returns the objects the backend persists into, for the bootstrapper to create up front and to
+// prune after switching backends. The memory backend declares none.
+func Artifacts(backend, name string) []*unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetName(name)
+	switch backend {
+	case BackendConfigMap:
+		obj.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+	case BackendCRD:
+		obj.SetGroupVersionKind(StateGVK)
+	default:
+		return nil
+	}
+	return []*unstructured.Unstructured{obj}
+}
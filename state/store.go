@@ -0,0 +1,159 @@
+// Package state holds the storage backends for operator state that has to outlive a single reconcile,
+// such as debounce timers, pending approvals, rate limiter windows, and rollout history.
+package state
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Store persists small opaque values under string keys. Keys are namespaced by convention with a
+// "<feature>/" prefix so several subsystems can share one backend.
+type Store interface {
+	// Get returns the value stored under key and whether it exists.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Put stores value under key, replacing any previous value.
+	Put(ctx context.Context, key string, value []byte) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns all entries whose key starts with prefix.
+	List(ctx context.Context, prefix string) (map[string][]byte, error)
+}
+
+// Backend names accepted by the --state-store flag.
+const (
+	BackendMemory    = "memory"
+	BackendConfigMap = "configmap"
+	BackendCRD       = "crd"
+)
+
+// New builds the Store for the named backend. The ConfigMap and CRD backends persist into the object
+// identified by key.
+func New(backend string, c client.Client, reader client.Reader, key types.NamespacedName) (Store, error) {
+	switch backend {
+	case BackendMemory, "":
+		return NewMemoryStore(), nil
+	case BackendConfigMap:
+		return NewConfigMapStore(c, reader, key), nil
+	case BackendCRD:
+		return NewCRDStore(c, reader, key), nil
+	default:
+		return nil, fmt.Errorf("unknown state store backend %q", backend)
+	}
+}
+
+// MemoryStore is a process-local Store. State is lost on restart, which makes it the simplest choice
+// for single-replica installs and the fake used by tests.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string][]byte{}}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	return cloneBytes(value), true, nil
+}
+
+func (s *MemoryStore) Put(_ context.Context, key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("state key cannot be empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = cloneBytes(value)
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) List(_ context.Context, prefix string) (map[string][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return filterPrefix(s.entries, prefix), nil
+}
+
+// WithPrefix returns a Store that keeps its entries in store under prefix, so several instances of the same
+// subsystems, such as the reconcilers of different clusters, can share one backend without their keys
+// colliding. List returns keys without prefix.
+func WithPrefix(store Store, prefix string) Store {
+	return &prefixedStore{store: store, prefix: prefix}
+}
+
+type prefixedStore struct {
+	store  Store
+	prefix string
+}
+
+func (s *prefixedStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return s.store.Get(ctx, s.prefix+key)
+}
+
+func (s *prefixedStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.store.Put(ctx, s.prefix+key, value)
+}
+
+func (s *prefixedStore) Delete(ctx context.Context, key string) error {
+	return s.store.Delete(ctx, s.prefix+key)
+}
+
+func (s *prefixedStore) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	entries, err := s.store.List(ctx, s.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte, len(entries))
+	for k, v := range entries {
+		out[strings.TrimPrefix(k, s.prefix)] = v
+	}
+	return out, nil
+}
+
+// Keys returns the sorted keys of entries, mainly for stable iteration over List results.
+func Keys(entries map[string][]byte) []string {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func filterPrefix(entries map[string][]byte, prefix string) map[string][]byte {
+	out := make(map[string][]byte)
+	for k, v := range entries {
+		if strings.HasPrefix(k, prefix) {
+			out[k] = cloneBytes(v)
+		}
+	}
+	return out
+}
+
+func cloneBytes(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	out := make([]byte, len(value))
+	copy(out, value)
+	return out
+}
@@ -0,0 +1,107 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	s := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(s))
+	s.AddKnownTypeWithName(StateGVK, &unstructured.Unstructured{})
+	s.AddKnownTypeWithName(StateGVK.GroupVersion().WithKind(StateGVK.Kind+"List"), &unstructured.UnstructuredList{})
+	return s
+}
+
+func TestStoreBackends(t *testing.T) {
+	key := types.NamespacedName{Namespace: "synapse-system", Name: "synapse-operator-state"}
+
+	for _, backend := range []string{BackendMemory, BackendConfigMap, BackendCRD} {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+			store, err := New(backend, c, c, key)
+			require.NoError(t, err)
+
+			_, ok, err := store.Get(ctx, "history/a")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			require.NoError(t, store.Put(ctx, "history/a", []byte("one")))
+			require.NoError(t, store.Put(ctx, "history/b", []byte{0, 1, 2}))
+			require.NoError(t, store.Put(ctx, "approval/a", []byte("two")))
+
+			value, ok, err := store.Get(ctx, "history/a")
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, []byte("one"), value)
+
+			entries, err := store.List(ctx, "history/")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"history/a", "history/b"}, Keys(entries))
+			assert.Equal(t, []byte{0, 1, 2}, entries["history/b"])
+
+			require.NoError(t, store.Delete(ctx, "history/a"))
+			require.NoError(t, store.Delete(ctx, "history/missing"))
+			_, ok, err = store.Get(ctx, "history/a")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			assert.Error(t, store.Put(ctx, "", []byte("x")))
+		})
+	}
+}
+
+func TestConfigMapStorePersistsAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "synapse-system", Name: "state"}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+
+	require.NoError(t, NewConfigMapStore(c, c, key).Put(ctx, "debounce/ns", []byte("pending")))
+
+	value, ok, err := NewConfigMapStore(c, c, key).Get(ctx, "debounce/ns")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("pending"), value)
+
+	var cm corev1.ConfigMap
+	require.NoError(t, c.Get(ctx, key, &cm))
+	assert.Contains(t, cm.Data, configMapStateKey)
+}
+
+func TestNewUnknownBackend(t *testing.T) {
+	_, err := New("etcd", nil, nil, types.NamespacedName{})
+	assert.Error(t, err)
+}
+
+func TestWithPrefix(t *testing.T) {
+	ctx := context.Background()
+	shared := NewMemoryStore()
+	spoke := WithPrefix(shared, "clusters/spoke/")
+	require.NoError(t, shared.Put(ctx, "gradual/matrix", []byte("hub")))
+	require.NoError(t, spoke.Put(ctx, "gradual/matrix", []byte("spoke")))
+
+	value, found, err := spoke.Get(ctx, "gradual/matrix")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "spoke", string(value))
+	entries, err := spoke.List(ctx, "gradual/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"gradual/matrix": []byte("spoke")}, entries)
+
+	require.NoError(t, spoke.Delete(ctx, "gradual/matrix"))
+	value, found, err = shared.Get(ctx, "gradual/matrix")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "hub", string(value))
+}
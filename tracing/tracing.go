@@ -0,0 +1,106 @@
+// Package tracing exports OpenTelemetry spans of reconciles, hash computations, and workload patches over
+// OTLP/HTTP. Until Setup installs an exporter the global tracer provider records nothing, so instrumented
+// code costs next to nothing with tracing disabled.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name is the instrumentation scope of the operator's spans.
+const Name = "synapse-operator"
+
+// Span attribute keys.
+const (
+	NamespaceKey    = attribute.Key("k8s.namespace.name")
+	SourceKindKey   = attribute.Key("synapse.source.kind")
+	SourceNameKey   = attribute.Key("synapse.source.name")
+	WorkloadKindKey = attribute.Key("synapse.workload.kind")
+	WorkloadNameKey = attribute.Key("synapse.workload.name")
+	ConfigHashKey   = attribute.Key("synapse.config_hash")
+	StrategyKey     = attribute.Key("synapse.restart_strategy")
+	TriggerIDKey    = attribute.Key("synapse.trigger_id")
+	HaltReasonKey   = attribute.Key("synapse.halt_reason")
+	UpdatedKey      = attribute.Key("synapse.updated")
+)
+
+// Options configures the exporter.
+type Options struct {
+	// Endpoint is the OTLP/HTTP collector endpoint, as host:port or a URL such as
+	// http://otel-collector:4318/v1/traces.
+	Endpoint string
+	// Insecure sends spans over plain HTTP to a host:port Endpoint; URLs carry their own scheme.
+	Insecure bool
+	// SampleRatio is the fraction of root spans sampled, from 0 to 1. Child spans follow their parent.
+	SampleRatio float64
+	// ServiceVersion is reported as service.version when set.
+	ServiceVersion string
+}
+
+// Setup installs a global tracer provider exporting to opts.Endpoint and returns its shutdown function, which
+// flushes the spans still buffered.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.SampleRatio < 0 || opts.SampleRatio > 1 {
+		return nil, fmt.Errorf("sample ratio must be between 0 and 1, got %v", opts.SampleRatio)
+	}
+	var exporterOpts []otlptracehttp.Option
+	if u, err := url.Parse(opts.Endpoint); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		exporterOpts = append(exporterOpts, otlptracehttp.WithEndpointURL(opts.Endpoint))
+	} else {
+		exporterOpts = append(exporterOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		if opts.Insecure {
+			exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
+		}
+	}
+	exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []attribute.KeyValue{semconv.ServiceName(Name)}
+	if opts.ServiceVersion != "" {
+		attrs = append(attrs, semconv.ServiceVersion(opts.ServiceVersion))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, attrs...))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the operator's tracer from the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(Name)
+}
+
+// Start starts a span named name as a child of the span in ctx, if any.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
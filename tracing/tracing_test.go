@@ -0,0 +1,30 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	_, err := Setup(context.Background(), Options{Endpoint: "localhost:4318", SampleRatio: 2})
+	assert.Error(t, err)
+
+	for _, endpoint := range []string{"otel-collector:4318", "http://otel-collector:4318/v1/traces"} {
+		shutdown, err := Setup(context.Background(), Options{Endpoint: endpoint, Insecure: true, SampleRatio: 1})
+		require.NoError(t, err, endpoint)
+		_, span := Start(context.Background(), "test")
+		assert.True(t, span.SpanContext().IsSampled(), endpoint)
+		span.End()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		// Nothing listens on the endpoint; shutting down without waiting only drops the span.
+		_ = shutdown(ctx)
+	}
+}
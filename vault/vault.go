@@ -0,0 +1,206 @@
+// Package vault reads the versions of HashiCorp Vault KV v2 secrets. Secrets the Vault Agent injector renders
+// into pods never pass through a Kubernetes Secret, so their rotations are only visible in Vault itself.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultServiceAccountTokenFile is the projected token presented to the Kubernetes auth method.
+const DefaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// DefaultAuthMount is the path the Kubernetes auth method is usually enabled at.
+const DefaultAuthMount = "kubernetes"
+
+var (
+	// ErrNotFound is returned for a secret that does not exist, or whose metadata was deleted.
+	ErrNotFound = errors.New("secret not found")
+	// ErrPermissionDenied is returned when the token's policies do not allow reading the metadata.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Options configures a Client.
+type Options struct {
+	// Address is the Vault address, such as https://vault.vault:8200.
+	Address string
+	// Role logs in with the Kubernetes auth method under this role. Empty uses Token instead.
+	Role string
+	// AuthMount is the path of the Kubernetes auth method; DefaultAuthMount when empty.
+	AuthMount string
+	// TokenFile holds the service account token presented at login; DefaultServiceAccountTokenFile when empty.
+	TokenFile string
+	// Token is a static Vault token used when Role is empty.
+	Token string
+	// Namespace is the Vault Enterprise namespace, sent as X-Vault-Namespace.
+	Namespace string
+	// HTTPClient defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// Client reads secret metadata from Vault, logging in again whenever its token expires or is revoked.
+type Client struct {
+	opts Options
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// New returns a client for opts. Nothing is sent to Vault before the first read.
+func New(opts Options) (*Client, error) {
+	if opts.Address == "" {
+		return nil, errors.New("vault address is required")
+	}
+	if opts.Role == "" && opts.Token == "" {
+		return nil, errors.New("either a Kubernetes auth role or a token is required")
+	}
+	opts.Address = strings.TrimSuffix(opts.Address, "/")
+	if opts.AuthMount == "" {
+		opts.AuthMount = DefaultAuthMount
+	}
+	if opts.TokenFile == "" {
+		opts.TokenFile = DefaultServiceAccountTokenFile
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{opts: opts, token: opts.Token}, nil
+}
+
+// MetadataPath returns the metadata path of a KV v2 secret given by its data path "<mount>/data/<path>", the
+// form used in the vault.hashicorp.com/agent-inject-secret-* annotations of the Vault Agent injector.
+func MetadataPath(path string) (string, error) {
+	mount, secret, ok := strings.Cut(strings.Trim(path, "/"), "/data/")
+	if !ok || mount == "" || secret == "" {
+		return "", fmt.Errorf("vault path %q is not a KV v2 path <mount>/data/<path>", path)
+	}
+	return mount + "/metadata/" + secret, nil
+}
+
+// Version returns the current version of the KV v2 secret at path, a data path as accepted by MetadataPath,
+// together with its creation time, so a secret deleted and written again reads as a new version. Only the
+// metadata is read; the token never needs access to the secret's data.
+func (c *Client) Version(ctx context.Context, path string) (string, error) {
+	metadataPath, err := MetadataPath(path)
+	if err != nil {
+		return "", err
+	}
+	var metadata struct {
+		Data struct {
+			CurrentVersion int `json:"current_version"`
+			Versions       map[string]struct {
+				CreatedTime string `json:"created_time"`
+			} `json:"versions"`
+		} `json:"data"`
+	}
+	if err := c.get(ctx, metadataPath, &metadata); err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	version := strconv.Itoa(metadata.Data.CurrentVersion)
+	return version + "@" + metadata.Data.Versions[version].CreatedTime, nil
+}
+
+// get reads path into out, logging in again once when the token was rejected.
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	token, err := c.currentToken(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.do(ctx, http.MethodGet, path, token, nil, out)
+	if errors.Is(err, ErrPermissionDenied) && c.opts.Role != "" {
+		c.forgetToken(token)
+		if token, err = c.currentToken(ctx); err != nil {
+			return err
+		}
+		err = c.do(ctx, http.MethodGet, path, token, nil, out)
+	}
+	return err
+}
+
+// currentToken returns the cached token, logging in with the Kubernetes auth method when it is missing or
+// about to expire.
+func (c *Client) currentToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opts.Role == "" || (c.token != "" && (c.expires.IsZero() || time.Now().Before(c.expires))) {
+		return c.token, nil
+	}
+	jwt, err := os.ReadFile(c.opts.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("reading service account token: %w", err)
+	}
+	var login struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role": c.opts.Role, "jwt": strings.TrimSpace(string(jwt))}
+	if err := c.do(ctx, http.MethodPost, "auth/"+c.opts.AuthMount+"/login", "", body, &login); err != nil {
+		return "", fmt.Errorf("logging in to vault as role %s: %w", c.opts.Role, err)
+	}
+	c.token = login.Auth.ClientToken
+	c.expires = time.Time{}
+	if lease := time.Duration(login.Auth.LeaseDuration) * time.Second; lease > 0 {
+		// Renew ahead of expiry rather than fail a read on it.
+		c.expires = time.Now().Add(lease * 9 / 10)
+	}
+	return c.token, nil
+}
+
+func (c *Client) forgetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path, token string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.opts.Address+"/v1/"+path, reader)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.opts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.opts.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode == http.StatusForbidden:
+		return ErrPermissionDenied
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Errorf("vault returned %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
@@ -0,0 +1,87 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataPath(t *testing.T) {
+	path, err := MetadataPath("secret/data/synapse/signing-key")
+	require.NoError(t, err)
+	assert.Equal(t, "secret/metadata/synapse/signing-key", path)
+
+	_, err = MetadataPath("secret/synapse/signing-key")
+	assert.Error(t, err)
+}
+
+func TestVersionLogsInWithKubernetesAuth(t *testing.T) {
+	logins := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			var body map[string]string
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+			assert.Equal(t, map[string]string{"role": "synapse-operator", "jwt": "sa-token"}, body)
+			logins++
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"vault-token","lease_duration":3600}}`))
+		case "/v1/secret/metadata/synapse/signing-key":
+			if req.Header.Get("X-Vault-Token") != "vault-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"current_version":3,"versions":{"3":{"created_time":"2026-10-01T12:00:00Z"}}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("sa-token\n"), 0o600))
+
+	c, err := New(Options{Address: server.URL, Role: "synapse-operator", TokenFile: tokenFile})
+	require.NoError(t, err)
+	ctx := context.Background()
+	version, err := c.Version(ctx, "secret/data/synapse/signing-key")
+	require.NoError(t, err)
+	assert.Equal(t, "3@2026-10-01T12:00:00Z", version)
+	_, err = c.Version(ctx, "secret/data/synapse/signing-key")
+	require.NoError(t, err)
+	assert.Equal(t, 1, logins, "the token is reused until it expires")
+
+	_, err = c.Version(ctx, "secret/data/synapse/missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestVersionWithStaticToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "team-a", req.Header.Get("X-Vault-Namespace"))
+		if req.Header.Get("X-Vault-Token") != "static" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"current_version":1,"versions":{"1":{"created_time":"2026-10-01T12:00:00Z"}}}}`))
+	}))
+	defer server.Close()
+
+	c, err := New(Options{Address: server.URL, Token: "static", Namespace: "team-a"})
+	require.NoError(t, err)
+	version, err := c.Version(context.Background(), "secret/data/synapse/db")
+	require.NoError(t, err)
+	assert.Equal(t, "1@2026-10-01T12:00:00Z", version)
+
+	c, err = New(Options{Address: server.URL, Token: "revoked", Namespace: "team-a"})
+	require.NoError(t, err)
+	_, err = c.Version(context.Background(), "secret/data/synapse/db")
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+
+	_, err = New(Options{Address: server.URL})
+	assert.Error(t, err)
+}